@@ -0,0 +1,87 @@
+package hdfs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// HDFS always appends at the end of the file, but versions of
+// colinmarc/hdfs differ in what the writer returned by Append knows about
+// it: some return a plain writer that continues at the end, others one that
+// can seek and reports its position, which may start at 0 until it is
+// moved to the end. seekingWriter is implemented by the latter.
+type seekingWriter interface {
+	Seek(offset int64, whence int) (int64, error)
+}
+
+// appendPosition returns the offset at which writer, returned by Append for
+// a file that was size bytes long, writes next. A writer that can seek and
+// is not there yet is moved to the end of the file first, whose length it
+// reports then even if the file grew since size was read; the others
+// continue at size.
+func appendPosition(writer hdfsFileWriter, size int64) (int64, error) {
+	seeker, ok := writer.(seekingWriter)
+	if !ok {
+		return size, nil
+	}
+	position, err := seeker.Seek(0, io.SeekCurrent)
+	if err == nil && position != size {
+		position, err = seeker.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot find the end of the appended file: %v", err)
+	}
+	return position, nil
+}
+
+// isAppendUnsupported reports whether the namenode refused an append
+// because the cluster has append disabled (dfs.support.append=false)
+func isAppendUnsupported(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "dfs.support.append") ||
+		(strings.Contains(message, "ppend") && strings.Contains(message, "not supported"))
+}
+
+// rewriteForAppend emulates an append on clusters that do not support it.
+// The existing contents are read into memory and written to a new file,
+// which then replaces the original; the returned writer continues after
+// them. The original stays in place until the rewrite is complete.
+func (d *driver) rewriteForAppend(fullPath string) (hdfsFileWriter, int64, error) {
+	existing, err := d.readAll(fullPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	return d.rewrite(fullPath, existing)
+}
+
+// rewrite replaces the file at fullPath with one holding contents, and
+// returns a writer continuing after them
+func (d *driver) rewrite(fullPath string, existing []byte) (hdfsFileWriter, int64, error) {
+	rewritePath := d.stagingPath(fullPath)
+	writer, err := d.create(rewritePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := writer.Write(existing); err != nil {
+		writer.Close()
+		return nil, 0, err
+	}
+	if err := writer.Flush(); err != nil {
+		writer.Close()
+		return nil, 0, err
+	}
+
+	// HDFS renames files that are still open for writing, the writer keeps
+	// appending to the renamed file
+	if err := d.hdfsClient.Rename(rewritePath, fullPath); err != nil {
+		writer.Close()
+		return nil, 0, err
+	}
+	return writer, int64(len(existing)), nil
+}
+
+// appendUnsupportedError explains how to deal with a cluster without append
+func appendUnsupportedError(path string, err error) error {
+	return fmt.Errorf("cannot resume the upload to %s because the cluster does not support append, set appendfallback to rewrite files instead: %v", path, err)
+}
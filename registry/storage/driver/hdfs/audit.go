@@ -0,0 +1,105 @@
+package hdfs
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// auditLog appends a JSON line to the auditlog file in HDFS for every file
+// the registry writes, moves or deletes, for operators who must account for
+// the changes made to the cluster. Writes are recorded when PutContent
+// returns or a FileWriter is committed, with the size of the file. The user
+// is the registry user of the request, or hdfsuser for requests without one.
+//
+// An entry is appended before the operation returns, costing an append and a
+// close on the namenode. Entries that cannot be written are logged and the
+// operation still succeeds, so the audit file may miss entries but never
+// holds operations that failed. Replicas sharing the file take turns on its
+// lease; an append refused because another replica holds it is one of those
+// failures. A nil auditLog records nothing.
+type auditLog struct {
+	path     string
+	hdfsUser string
+
+	mu sync.Mutex
+}
+
+// auditEntry is a line of the audit file. Size is only set for writes.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Path   string    `json:"path"`
+	Source string    `json:"source,omitempty"`
+	Size   *int64    `json:"size,omitempty"`
+	User   string    `json:"user"`
+}
+
+func newAuditLog(file, hdfsUser string) *auditLog {
+	if file == "" {
+		return nil
+	}
+	return &auditLog{path: path.Clean(file), hdfsUser: hdfsUser}
+}
+
+// recordWrite records that size bytes are now stored at path
+func (a *auditLog) recordWrite(d *driver, ctx context.Context, path string, size int64) {
+	a.record(d, ctx, auditEntry{Op: "write", Path: path, Size: &size})
+}
+
+// recordMove records that source was moved to dest
+func (a *auditLog) recordMove(d *driver, ctx context.Context, source, dest string) {
+	a.record(d, ctx, auditEntry{Op: "move", Path: dest, Source: source})
+}
+
+// recordDelete records that path and everything below it were deleted
+func (a *auditLog) recordDelete(d *driver, ctx context.Context, path string) {
+	a.record(d, ctx, auditEntry{Op: "delete", Path: path})
+}
+
+func (a *auditLog) record(d *driver, ctx context.Context, entry auditEntry) {
+	if a == nil {
+		return
+	}
+	entry.Time = time.Now().UTC()
+	entry.User = context.GetStringValue(ctx, "auth.user.name")
+	if entry.User == "" {
+		entry.User = a.hdfsUser
+	}
+	line, err := json.Marshal(entry)
+	if err == nil {
+		err = a.append(d, append(line, '\n'))
+	}
+	if err != nil {
+		log.Printf("hdfs: unable to write the %s of %s to the audit log %s: %v", entry.Op, entry.Path, a.path, err)
+	}
+}
+
+// append adds line to the audit file, creating it on first use
+func (a *auditLog) append(d *driver, line []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	writer, err := d.hdfsClient.Append(a.path)
+	if os.IsNotExist(err) {
+		d.makeParentDir(a.path)
+		writer, err = d.hdfsClient.Create(a.path)
+		// Another replica may have created it meanwhile
+		if os.IsExist(err) {
+			writer, err = d.hdfsClient.Append(a.path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(line)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
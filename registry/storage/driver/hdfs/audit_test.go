@@ -0,0 +1,91 @@
+package hdfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestAuditLog(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{AuditLog: "/audit/registry.log", HdfsUser: "registry"})
+	ctx := context.WithValue(context.Background(), "auth.user.name", "alice")
+
+	if err := d.PutContent(ctx, "/a", []byte("aaa")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writer, err := d.Writer(context.Background(), "/b", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writer.Write([]byte("bbbbb"))
+	writer.Commit()
+	writer.Commit()
+	writer.Close()
+	if err := d.Move(ctx, "/a", "/c"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.Delete(ctx, "/b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Failed operations are not recorded
+	d.Delete(ctx, "/missing")
+
+	contents, err := client.ReadFile("/audit/registry.log")
+	if err != nil {
+		t.Fatalf("expected the audit log to be written: %v", err)
+	}
+	var entries []auditEntry
+	for _, line := range bytes.Split(bytes.TrimSpace(contents), []byte("\n")) {
+		var entry auditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("invalid audit line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	expected := []struct {
+		op, path, source, user string
+		size                   int64
+	}{
+		{"write", "/a", "", "alice", 3},
+		{"write", "/b", "", "registry", 5},
+		{"move", "/c", "/a", "alice", -1},
+		{"delete", "/b", "", "alice", -1},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d audit entries, got %s", len(expected), contents)
+	}
+	for i, e := range expected {
+		entry := entries[i]
+		if entry.Op != e.op || entry.Path != e.path || entry.Source != e.source || entry.User != e.user || entry.Time.IsZero() {
+			t.Errorf("entry %d: expected %+v, got %+v", i, e, entry)
+		}
+		if (e.size < 0 && entry.Size != nil) || (e.size >= 0 && (entry.Size == nil || *entry.Size != e.size)) {
+			t.Errorf("entry %d: expected size %d, got %v", i, e.size, entry.Size)
+		}
+	}
+}
+
+func TestAuditLogFailureKeepsOperation(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{AuditLog: "/audit/registry.log"})
+	fail := func(name string) error {
+		if name == "/audit/registry.log" {
+			return errors.New("lease held by another replica")
+		}
+		return nil
+	}
+	client.hook("Append", fail)
+	client.hook("Create", fail)
+
+	if err := d.PutContent(context.Background(), "/a", []byte("aaa")); err != nil {
+		t.Fatalf("expected the write to succeed without the audit entry: %v", err)
+	}
+	if contents, err := d.GetContent(context.Background(), "/a"); err != nil || string(contents) != "aaa" {
+		t.Fatalf("unexpected contents %q, %v", contents, err)
+	}
+}
@@ -0,0 +1,85 @@
+package hdfs
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/docker/distribution/context"
+)
+
+// bufferPool hands out fixed-size transfer buffers so that concurrent
+// pushes and pulls reuse memory instead of allocating a buffer per copy.
+type bufferPool struct {
+	size  int
+	inUse int64
+	pool  sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	bp := &bufferPool{size: size}
+	bp.pool.New = func() interface{} {
+		b := make([]byte, size)
+		return &b
+	}
+	return bp
+}
+
+// get returns a buffer of the pool's size. It must be handed back with put.
+func (bp *bufferPool) get() *[]byte {
+	atomic.AddInt64(&bp.inUse, 1)
+	return bp.pool.Get().(*[]byte)
+}
+
+// put returns a buffer obtained from get to the pool
+func (bp *bufferPool) put(b *[]byte) {
+	atomic.AddInt64(&bp.inUse, -1)
+	bp.pool.Put(b)
+}
+
+// buffersInUse returns the number of buffers currently checked out
+func (bp *bufferPool) buffersInUse() int64 {
+	return atomic.LoadInt64(&bp.inUse)
+}
+
+// copy copies from src to dst through a pooled buffer. The buffer is
+// returned to the pool whether or not the copy succeeds.
+func (bp *bufferPool) copy(dst io.Writer, src io.Reader) (int64, error) {
+	b := bp.get()
+	defer bp.put(b)
+
+	// Hide any ReaderFrom/WriterTo implementations so io.CopyBuffer really
+	// uses the pooled buffer rather than allocating its own.
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *b)
+}
+
+// copyContext is copy, except that it stops with ctx.Err() once ctx is
+// done. The context is checked before every chunk is read.
+func (bp *bufferPool) copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	return bp.copy(dst, &contextReader{Reader: src, ctx: ctx})
+}
+
+// contextReader fails reads once its context is done
+type contextReader struct {
+	io.Reader
+	ctx context.Context
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
+
+// pooledReader wraps the stream returned by Reader so that io.Copy into an
+// http.ResponseWriter uses a pooled buffer.
+type pooledReader struct {
+	io.ReadCloser
+	pool *bufferPool
+}
+
+// WriteTo implements io.WriterTo
+func (r *pooledReader) WriteTo(w io.Writer) (int64, error) {
+	return r.pool.copy(w, r.ReadCloser)
+}
@@ -0,0 +1,101 @@
+package hdfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// failingReader returns data until it has produced n bytes, then fails
+type failingReader struct {
+	n int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, errors.New("datanode went away")
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	r.n -= len(p)
+	return len(p), nil
+}
+
+func TestBufferPoolCopy(t *testing.T) {
+	pool := newBufferPool(minTransferBufferSize)
+
+	for _, size := range []int{0, 1, minTransferBufferSize - 1, minTransferBufferSize, 10*minTransferBufferSize + 7} {
+		contents := make([]byte, size)
+		rand.Read(contents)
+
+		var dst bytes.Buffer
+		n, err := pool.copy(&dst, bytes.NewReader(contents))
+		if err != nil {
+			t.Fatalf("unexpected error copying %d bytes: %v", size, err)
+		}
+		if n != int64(size) || !bytes.Equal(dst.Bytes(), contents) {
+			t.Fatalf("pooled copy of %d bytes returned %d bytes of mismatched content", size, n)
+		}
+	}
+
+	if inUse := pool.buffersInUse(); inUse != 0 {
+		t.Fatalf("expected all buffers to be returned, %d still in use", inUse)
+	}
+}
+
+func TestBufferPoolReturnsBufferOnError(t *testing.T) {
+	pool := newBufferPool(minTransferBufferSize)
+
+	_, err := pool.copy(ioutil.Discard, &failingReader{n: 3 * minTransferBufferSize})
+	if err == nil {
+		t.Fatal("expected the read error to be returned")
+	}
+	if inUse := pool.buffersInUse(); inUse != 0 {
+		t.Fatalf("expected the buffer to be returned after an error, %d still in use", inUse)
+	}
+}
+
+func TestPooledReaderWriteTo(t *testing.T) {
+	pool := newBufferPool(minTransferBufferSize)
+	contents := make([]byte, 5*minTransferBufferSize+3)
+	rand.Read(contents)
+
+	reader := &pooledReader{ReadCloser: ioutil.NopCloser(bytes.NewReader(contents)), pool: pool}
+	var dst bytes.Buffer
+	if _, err := io.Copy(&dst, reader); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(dst.Bytes(), contents) {
+		t.Fatal("content read through the pooled reader does not match")
+	}
+}
+
+func benchmarkConcurrentCopy(b *testing.B, copyFn func(dst io.Writer, src io.Reader) (int64, error)) {
+	contents := make([]byte, 1<<20)
+	b.SetBytes(int64(len(contents)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			// Hide WriterTo so that both variants go through a copy buffer
+			src := struct{ io.Reader }{bytes.NewReader(contents)}
+			if _, err := copyFn(struct{ io.Writer }{ioutil.Discard}, src); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkCopyUnpooled(b *testing.B) {
+	benchmarkConcurrentCopy(b, func(dst io.Writer, src io.Reader) (int64, error) {
+		return io.CopyBuffer(dst, src, make([]byte, defaultTransferBufferSize))
+	})
+}
+
+func BenchmarkCopyPooled(b *testing.B) {
+	pool := newBufferPool(defaultTransferBufferSize)
+	benchmarkConcurrentCopy(b, pool.copy)
+}
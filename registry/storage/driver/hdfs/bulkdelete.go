@@ -0,0 +1,63 @@
+package hdfs
+
+import (
+	"sync"
+
+	"github.com/docker/distribution/context"
+)
+
+// BulkDeleter is implemented by drivers that can delete many paths at once,
+// such as the HDFS driver. Garbage collection can use it instead of calling
+// Delete once per blob:
+//
+//	if deleter, ok := driver.(hdfs.BulkDeleter); ok {
+//		failed := deleter.DeleteFiles(ctx, paths)
+//	}
+type BulkDeleter interface {
+	// DeleteFiles deletes every path like Delete and returns the error for
+	// each path that could not be deleted. Paths missing from the result
+	// were deleted.
+	DeleteFiles(ctx context.Context, paths []string) map[string]error
+}
+
+// DeleteFiles implements BulkDeleter. Up to deleteconcurrency Removes are in
+// flight at a time.
+func (d *Driver) DeleteFiles(ctx context.Context, paths []string) map[string]error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.DeleteFiles(%d paths)", d.Name(), len(paths))
+
+	return d.inner().deleteFiles(ctx, paths)
+}
+
+func (d *driver) deleteFiles(ctx context.Context, paths []string) map[string]error {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed = make(map[string]error)
+	)
+	work := make(chan string)
+	for i := 0; i < d.deleteConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				checked, err := d.checkPath(p, false)
+				if err == nil {
+					err = d.Delete(ctx, checked)
+				}
+				if err != nil {
+					mu.Lock()
+					failed[p] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, p := range paths {
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+	return failed
+}
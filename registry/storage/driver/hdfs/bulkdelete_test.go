@@ -0,0 +1,41 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestDeleteFiles(t *testing.T) {
+	client := newFakeClient()
+	var sd storagedriver.StorageDriver = wrap(newTestDriverWithParameters(client, DriverParameters{DeleteConcurrency: 2}))
+	paths := []string{"/blobs/a", "/blobs/b", "/blobs/c", "/blobs/d"}
+	for _, p := range paths {
+		client.writeFile("/registry"+p, []byte(p))
+	}
+
+	deleter, ok := sd.(BulkDeleter)
+	if !ok {
+		t.Fatalf("expected the driver to implement BulkDeleter")
+	}
+	failed := deleter.DeleteFiles(context.Background(), append(paths, "/blobs/missing", "invalid"))
+
+	if len(failed) != 2 {
+		t.Fatalf("expected two failures, got %v", failed)
+	}
+	if !isPathNotFound(failed["/blobs/missing"]) {
+		t.Fatalf("expected PathNotFoundError for the missing path, got %v", failed["/blobs/missing"])
+	}
+	if _, ok := failed["invalid"].(storagedriver.InvalidPathError); !ok {
+		t.Fatalf("expected InvalidPathError for the invalid path, got %v", failed["invalid"])
+	}
+	for _, p := range paths {
+		if _, err := client.Stat("/registry" + p); err == nil {
+			t.Fatalf("expected %s to be deleted", p)
+		}
+	}
+	if client.callCount("Remove") != len(paths)+1 {
+		t.Fatalf("expected one Remove per valid path, got %d", client.callCount("Remove"))
+	}
+}
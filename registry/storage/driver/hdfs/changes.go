@@ -0,0 +1,173 @@
+package hdfs
+
+import (
+	"bytes"
+	"log"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With DriverParameters.OnChange set the driver reports every file the
+// registry writes, moves or deletes, e.g. to keep an external index of the
+// registry's content up to date. Changes are reported at the points the
+// audit log records them, after the operation succeeded, and in the order
+// they completed.
+//
+// OnChange is called from a goroutine of its own, one event at a time, so
+// that a slow consumer never holds up the storage operation. Up to
+// changeEventBuffer events wait for it; the events of a consumer that falls
+// further behind are dropped and logged, and an index fed by it should be
+// reconciled by walking the tree from time to time. Driver.Close ends the
+// goroutine once the waiting events are delivered, or right away when
+// OnChange calls it, in which case the waiting events are delivered once
+// OnChange returns.
+
+// changeEventBuffer is how many events wait for OnChange at most
+const changeEventBuffer = 1024
+
+// ChangeOp is the operation a ChangeEvent reports
+type ChangeOp string
+
+// The operations of ChangeEvent
+const (
+	// ChangeWrite is a file written by PutContent or a committed
+	// FileWriter
+	ChangeWrite ChangeOp = "write"
+
+	// ChangeMove is a file or directory moved from Source to Path
+	ChangeMove ChangeOp = "move"
+
+	// ChangeDelete is a file or directory deleted with everything below it
+	ChangeDelete ChangeOp = "delete"
+)
+
+// ChangeEvent describes a change to the registry's content, for the
+// DriverParameters.OnChange hook
+type ChangeEvent struct {
+	Op   ChangeOp
+	Path string
+
+	// Source is the path moved from, only set for moves
+	Source string
+
+	// Size is the size of the file, only set for writes
+	Size int64
+
+	Time time.Time
+}
+
+// changeNotifier delivers events to onChange in order. A nil changeNotifier
+// delivers nothing.
+type changeNotifier struct {
+	dropped  uint64
+	onChange func(ChangeEvent)
+
+	// mu keeps notify from sending on events once stop closed it
+	mu        sync.RWMutex
+	events    chan ChangeEvent
+	stopped   bool
+	delivered chan struct{}
+
+	// deliverer is the goroutine calling onChange, see goroutineID
+	deliverer uint64
+}
+
+// newChangeNotifier returns nil when onChange is, and otherwise starts
+// delivering events to it with up to buffer waiting
+func newChangeNotifier(onChange func(ChangeEvent), buffer int) *changeNotifier {
+	if onChange == nil {
+		return nil
+	}
+	n := &changeNotifier{onChange: onChange, events: make(chan ChangeEvent, buffer), delivered: make(chan struct{})}
+	started := make(chan struct{})
+	go n.deliver(started)
+	<-started
+	return n
+}
+
+func (n *changeNotifier) deliver(started chan<- struct{}) {
+	defer close(n.delivered)
+	n.deliverer = goroutineID()
+	close(started)
+	for event := range n.events {
+		n.call(event)
+	}
+}
+
+// stop waits for the queued events to be delivered and ends the delivery.
+// Later events are dropped. Called by onChange, it cannot wait for the
+// delivery it is part of and returns right away.
+func (n *changeNotifier) stop() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if !n.stopped {
+		n.stopped = true
+		close(n.events)
+	}
+	n.mu.Unlock()
+	if goroutineID() != n.deliverer {
+		<-n.delivered
+	}
+}
+
+// goroutineID returns the number of the calling goroutine, which the
+// runtime only tells in the header of its stack trace
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i >= 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
+
+// call calls onChange, which must not take the delivery of later events
+// down with it when it panics
+func (n *changeNotifier) call(event ChangeEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("hdfs: OnChange panicked on the %s of %s: %v", event.Op, event.Path, r)
+		}
+	}()
+	n.onChange(event)
+}
+
+// notify queues event without waiting for onChange
+func (n *changeNotifier) notify(event ChangeEvent) {
+	if n == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.stopped {
+		return
+	}
+	select {
+	case n.events <- event:
+	default:
+		// Log the first drop and every thousandth after it
+		if dropped := atomic.AddUint64(&n.dropped, 1); dropped%1000 == 1 {
+			log.Printf("hdfs: OnChange is %d events behind, dropped the %s of %s (%d dropped so far)", cap(n.events), event.Op, event.Path, dropped)
+		}
+	}
+}
+
+func (n *changeNotifier) wrote(path string, size int64) {
+	n.notify(ChangeEvent{Op: ChangeWrite, Path: path, Size: size})
+}
+
+func (n *changeNotifier) moved(source, dest string) {
+	n.notify(ChangeEvent{Op: ChangeMove, Path: dest, Source: source})
+}
+
+func (n *changeNotifier) deleted(path string) {
+	n.notify(ChangeEvent{Op: ChangeDelete, Path: path})
+}
@@ -0,0 +1,212 @@
+package hdfs
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestChangeEventsInOrder(t *testing.T) {
+	events := make(chan ChangeEvent, 16)
+	d := wrap(newTestDriverWithParameters(newFakeClient(), DriverParameters{
+		OnChange: func(event ChangeEvent) { events <- event },
+	}))
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/a/link", []byte("link")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	writer, err := d.Writer(ctx, "/b/data", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("layer data"))
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("unexpected error from Commit: %v", err)
+	}
+	writer.Close()
+	if err := d.Move(ctx, "/b/data", "/c/data"); err != nil {
+		t.Fatalf("unexpected error from Move: %v", err)
+	}
+	if err := d.Delete(ctx, "/a"); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+	// Failed operations are not reported
+	if err := d.Delete(ctx, "/missing"); err == nil {
+		t.Fatal("expected deleting a missing path to fail")
+	}
+
+	expected := []ChangeEvent{
+		{Op: ChangeWrite, Path: "/a/link", Size: 4},
+		{Op: ChangeWrite, Path: "/b/data", Size: 10},
+		{Op: ChangeMove, Path: "/c/data", Source: "/b/data"},
+		{Op: ChangeDelete, Path: "/a"},
+	}
+	for i, want := range expected {
+		select {
+		case event := <-events:
+			if event.Time.IsZero() {
+				t.Fatalf("expected event %d to carry its time", i)
+			}
+			event.Time = time.Time{}
+			if !reflect.DeepEqual(event, want) {
+				t.Fatalf("expected event %d to be %+v, got %+v", i, want, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d, %+v", i, want)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSlowChangeConsumerDoesNotStallWrites(t *testing.T) {
+	stuck, release := make(chan struct{}, 16), make(chan struct{})
+	delivered := make(chan ChangeEvent, 16)
+	d := newTestDriver(newFakeClient())
+	d.changes = newChangeNotifier(func(event ChangeEvent) {
+		stuck <- struct{}{}
+		<-release
+		delivered <- event
+	}, 4)
+	ctx := context.Background()
+
+	// The consumer is stuck on the first event
+	if err := d.PutContent(ctx, "/link", []byte("link")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	<-stuck
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := d.PutContent(ctx, "/link", []byte("link")); err != nil {
+			t.Fatalf("unexpected error from PutContent: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected writes to go on while the consumer is stuck, took %v", elapsed)
+	}
+
+	// The consumer gets the event it was handed and the buffered ones,
+	// the rest were dropped
+	close(release)
+	for i := 0; i < 5; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+	select {
+	case event := <-delivered:
+		t.Fatalf("expected events past the buffer to be dropped, got %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPackedMoveIsReportedOnce(t *testing.T) {
+	client := newFakeClient()
+	events := make(chan ChangeEvent, 16)
+	d := newTestDriverWithParameters(client, DriverParameters{
+		PackPrefixes: "/repos",
+		PackMaxSize:  16,
+		AuditLog:     "/audit/registry.log",
+		OnChange:     func(event ChangeEvent) { events <- event },
+	})
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/repos/a/link", []byte("link")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if err := d.Move(ctx, "/repos/a/link", "/repos/b/link"); err != nil {
+		t.Fatalf("unexpected error from Move: %v", err)
+	}
+	wrap(d).Close()
+
+	var ops []ChangeOp
+	for len(events) > 0 {
+		ops = append(ops, (<-events).Op)
+	}
+	if !reflect.DeepEqual(ops, []ChangeOp{ChangeWrite, ChangeMove}) {
+		t.Fatalf("expected a write and a move, got %v", ops)
+	}
+	contents, _ := client.ReadFile("/audit/registry.log")
+	if lines := bytes.Count(contents, []byte("\n")); lines != 2 {
+		t.Fatalf("expected an audit record for the write and one for the move, got %s", contents)
+	}
+}
+
+func TestCloseDeliversQueuedChanges(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	d := wrap(newTestDriverWithParameters(newFakeClient(), DriverParameters{
+		OnChange: func(event ChangeEvent) {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			paths = append(paths, event.Path)
+			mu.Unlock()
+		},
+	}))
+	ctx := context.Background()
+
+	for _, p := range []string{"/a", "/b", "/c"} {
+		if err := d.PutContent(ctx, p, []byte("x")); err != nil {
+			t.Fatalf("unexpected error from PutContent: %v", err)
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("unexpected error from Close: %v", err)
+	}
+	mu.Lock()
+	delivered := append([]string(nil), paths...)
+	mu.Unlock()
+	if !reflect.DeepEqual(delivered, []string{"/a", "/b", "/c"}) {
+		t.Fatalf("expected Close to deliver the queued events, got %v", delivered)
+	}
+
+	// Changes made after Close are not reported
+	if err := d.PutContent(ctx, "/d", []byte("x")); err != nil {
+		t.Fatalf("unexpected error from PutContent after Close: %v", err)
+	}
+	d.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 3 {
+		t.Fatalf("expected no events after Close, got %v", paths)
+	}
+}
+
+func TestCloseFromOnChange(t *testing.T) {
+	var d *Driver
+	var once sync.Once
+	closed := make(chan error, 1)
+	d = wrap(newTestDriverWithParameters(newFakeClient(), DriverParameters{
+		OnChange: func(event ChangeEvent) {
+			once.Do(func() { closed <- d.Close() })
+		},
+	}))
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/a", []byte("x")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	outside := make(chan error, 1)
+	go func() { outside <- d.Close() }()
+	for _, ch := range []chan error{closed, outside} {
+		select {
+		case err := <-ch:
+			if err != nil {
+				t.Fatalf("unexpected error from Close: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected Close called from OnChange not to wait for OnChange")
+		}
+	}
+}
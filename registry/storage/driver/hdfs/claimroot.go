@@ -0,0 +1,65 @@
+package hdfs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// rootMarkerName is the file claimroot keeps the instanceid of the owning
+// registry in, at the top of the root directory. Driver paths cannot start
+// with a dot, so it never collides with registry data.
+const rootMarkerName = ".registry-marker"
+
+// validateInstanceID checks the instanceid parameter claimroot writes
+func validateInstanceID(claimRoot bool, instanceID string) error {
+	if claimRoot && instanceID == "" {
+		return fmt.Errorf("The claimroot parameter requires instanceid to be set")
+	}
+	if strings.ContainsAny(instanceID, "\r\n") {
+		return fmt.Errorf("The instanceid parameter must be a single line")
+	}
+	return nil
+}
+
+// claimRoot makes sure the root directory belongs to this registry, so two
+// registries configured with the same root by accident do not both write
+// to it. An unclaimed root is claimed by writing the marker; a root claimed
+// by another instanceid is refused.
+func (d *driver) claimRoot(instanceID string) error {
+	marker := path.Join(d.hdfsRootDirectory, rootMarkerName)
+
+	owner, err := d.hdfsClient.ReadFile(marker)
+	if os.IsNotExist(err) {
+		if err := d.mkdirAll("/"); err != nil {
+			return err
+		}
+		// Create fails if another registry claimed the root meanwhile, in
+		// which case its marker is checked below
+		if writer, err := d.hdfsClient.Create(marker); err == nil {
+			_, err = writer.Write([]byte(instanceID + "\n"))
+			if closeErr := writer.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				d.hdfsClient.Remove(marker)
+				return fmt.Errorf("claiming root directory %s: %v", d.hdfsRootDirectory, err)
+			}
+			log.Printf("hdfs: claimed root directory %s for instance %s", d.hdfsRootDirectory, instanceID)
+			return nil
+		} else if !os.IsExist(err) {
+			return fmt.Errorf("claiming root directory %s: %v", d.hdfsRootDirectory, err)
+		}
+		owner, err = d.hdfsClient.ReadFile(marker)
+	}
+	if err != nil {
+		return fmt.Errorf("reading the owner of root directory %s: %v", d.hdfsRootDirectory, err)
+	}
+
+	if current := strings.TrimSpace(string(owner)); current != instanceID {
+		return fmt.Errorf("root directory %s is claimed by instance %q, not %q; remove %s if it was moved on purpose", d.hdfsRootDirectory, current, instanceID, marker)
+	}
+	return nil
+}
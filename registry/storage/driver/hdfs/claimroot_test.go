@@ -0,0 +1,44 @@
+package hdfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestClaimRoot(t *testing.T) {
+	client := newFakeClient()
+	params := DriverParameters{ClaimRoot: true, InstanceID: "registry-a"}
+
+	// An unclaimed root is claimed
+	if _, err := newDriver(client, fillTestParameters(params)); err != nil {
+		t.Fatalf("unexpected error claiming an unclaimed root: %v", err)
+	}
+	if marker, err := client.ReadFile("/registry/" + rootMarkerName); err != nil || string(marker) != "registry-a\n" {
+		t.Fatalf("unexpected marker %q, %v", marker, err)
+	}
+
+	// The owner starts again
+	d, err := newDriver(client, fillTestParameters(params))
+	if err != nil {
+		t.Fatalf("unexpected error restarting the owner: %v", err)
+	}
+	client.writeFile("/registry/docker/file", []byte("data"))
+	if entries, err := d.List(context.Background(), "/"); err != nil || !reflect.DeepEqual(entries, []string{"/docker"}) {
+		t.Fatalf("expected the marker to be hidden from List, got %v, %v", entries, err)
+	}
+
+	// Another registry is refused
+	params.InstanceID = "registry-b"
+	if _, err := newDriver(client, fillTestParameters(params)); err == nil || !strings.Contains(err.Error(), "registry-a") {
+		t.Fatalf("expected a root claimed by registry-a to be refused, got %v", err)
+	}
+}
+
+func TestClaimRootRequiresInstanceID(t *testing.T) {
+	if _, err := newDriver(newFakeClient(), fillTestParameters(DriverParameters{ClaimRoot: true})); err == nil {
+		t.Fatal("expected claimroot without instanceid to be rejected")
+	}
+}
@@ -0,0 +1,101 @@
+package hdfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/colinmarc/hdfs"
+)
+
+// hdfsClient captures the namenode operations the driver relies on. The
+// colinmarc/hdfs client is the production implementation; tests substitute
+// an in-memory fake.
+type hdfsClient interface {
+	Open(name string) (hdfsFileReader, error)
+	Create(name string) (hdfsFileWriter, error)
+	CreateFile(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error)
+	Append(name string) (hdfsFileWriter, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	MkdirAll(dirname string, perm os.FileMode) error
+	Chmod(name string, perm os.FileMode) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	ReadFile(filename string) ([]byte, error)
+}
+
+// errUnsupportedByClient is returned when the client has no way to perform
+// an optional operation
+var errUnsupportedByClient = errors.New("operation not supported by the HDFS client")
+
+// replicationSetter is implemented by clients that can change the
+// replication factor of an existing file. colinmarc/hdfs cannot, so the
+// driver goes through WebHDFS instead, see driver.setReplication.
+type replicationSetter interface {
+	SetReplication(name string, replication int) error
+}
+
+// setReplication changes the replication of name if c supports it
+func setReplication(c hdfsClient, name string, replication int) error {
+	if s, ok := c.(replicationSetter); ok {
+		return s.SetReplication(name, replication)
+	}
+	return errUnsupportedByClient
+}
+
+// errWebHdfsRequired is returned by the operations that colinmarc/hdfs has
+// no RPC for when WebHDFS is not configured to perform them instead
+var errWebHdfsRequired = errors.New("the HDFS client cannot do this without WebHDFS, set hdfswebhdfsaddr or webhdfsport")
+
+// hdfsFileReader is an open HDFS file being read
+type hdfsFileReader interface {
+	io.ReadSeeker
+	io.Closer
+	Stat() os.FileInfo
+}
+
+// hdfsFileWriter is an open HDFS file being written
+type hdfsFileWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// colinmarcClient adapts *hdfs.Client to the hdfsClient interface
+type colinmarcClient struct {
+	*hdfs.Client
+}
+
+func (c colinmarcClient) Open(name string) (hdfsFileReader, error) {
+	reader, err := c.Client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (c colinmarcClient) Create(name string) (hdfsFileWriter, error) {
+	writer, err := c.Client.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (c colinmarcClient) CreateFile(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	writer, err := c.Client.CreateFile(name, replication, blockSize, perm)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (c colinmarcClient) Append(name string) (hdfsFileWriter, error) {
+	writer, err := c.Client.Append(name)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
@@ -0,0 +1,812 @@
+package hdfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/colinmarc/hdfs"
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// fakeFile is a file or directory held by fakeClient
+type fakeFile struct {
+	data        []byte
+	isDir       bool
+	mode        os.FileMode
+	modTime     time.Time
+	replication int
+	owner       string
+	group       string
+	policy      string
+	encryption  *fileEncryptionInfo
+	favored     []string
+	symlink     string
+	xattrs      map[string][]byte
+}
+
+// fakeFileInfo implements os.FileInfo for fakeClient entries
+type fakeFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	isDir   bool
+	owner   string
+	group   string
+	sys     interface{}
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return fi.size }
+func (fi fakeFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi fakeFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fakeFileInfo) IsDir() bool        { return fi.isDir }
+func (fi fakeFileInfo) Sys() interface{}   { return fi.sys }
+
+// Owner and OwnerGroup match the colinmarc/hdfs FileInfo accessors
+func (fi fakeFileInfo) Owner() string      { return fi.owner }
+func (fi fakeFileInfo) OwnerGroup() string { return fi.group }
+
+// fakeClient is an in-memory hdfsClient that mimics the namenode semantics
+// the driver relies on. Hooks can be installed per method to inject errors.
+// fakeCapacity is the filesystem size the fake reports from StatFs
+const fakeCapacity = 1 << 30
+
+type fakeClient struct {
+	mu    sync.Mutex
+	files map[string]*fakeFile
+	calls map[string]int
+	hooks map[string]func(name string) error
+
+	openReaders int
+
+	// zones maps encryption zone directories to their key names
+	zones map[string]string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		files: map[string]*fakeFile{
+			"/": {isDir: true, mode: os.ModeDir | 0755, modTime: time.Now()},
+		},
+		calls: make(map[string]int),
+		hooks: make(map[string]func(name string) error),
+	}
+}
+
+// hook installs fn to run before every call to method; a non-nil return
+// value is returned from the call instead of performing it.
+func (c *fakeClient) hook(method string, fn func(name string) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks[method] = fn
+}
+
+// failWith makes every call to method fail with err
+func (c *fakeClient) failWith(method string, err error) {
+	c.hook(method, func(string) error { return err })
+}
+
+// callCount returns how many times method was called
+func (c *fakeClient) callCount(method string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[method]
+}
+
+// enter records the call and runs its hook. It must be called without
+// c.mu held and returns with it held unless an error is returned.
+func (c *fakeClient) enter(method, name string) error {
+	c.mu.Lock()
+	c.calls[method]++
+	hook := c.hooks[method]
+	c.mu.Unlock()
+
+	if hook != nil {
+		if err := hook(name); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	return nil
+}
+
+func (c *fakeClient) info(name string, f *fakeFile) os.FileInfo {
+	return fakeFileInfo{
+		name:    path.Base(name),
+		size:    int64(len(f.data)),
+		mode:    f.mode,
+		modTime: f.modTime,
+		isDir:   f.isDir,
+		owner:   f.owner,
+		group:   f.group,
+		sys:     f.status(),
+	}
+}
+
+// fakeFileType and fakeFileStatus mimic the file type and target that the
+// HdfsFileStatusProto of colinmarc/hdfs carries for symbolic links
+type fakeFileType int32
+
+func (t fakeFileType) String() string {
+	if t == 3 {
+		return "IS_SYMLINK"
+	}
+	return "IS_FILE"
+}
+
+type fakeFileStatus struct {
+	fileType fakeFileType
+	symlink  []byte
+}
+
+func (s *fakeFileStatus) GetFileType() fakeFileType { return s.fileType }
+func (s *fakeFileStatus) GetSymlink() []byte        { return s.symlink }
+
+func (f *fakeFile) status() interface{} {
+	if f.symlink == "" {
+		return nil
+	}
+	return &fakeFileStatus{fileType: 3, symlink: []byte(f.symlink)}
+}
+
+// unresolvedLink is the error the namenode returns for paths ending in a
+// symbolic link, or nil
+func unresolvedLink(op, name string, f *fakeFile) error {
+	if f.symlink == "" {
+		return nil
+	}
+	return pathError(op, name, fmt.Errorf("org.apache.hadoop.hdfs.protocol.UnresolvedPathException: %s", name))
+}
+
+// symlinkTo seeds a symbolic link to target
+func (c *fakeClient) symlinkTo(name, target string) {
+	if err := c.MkdirAll(path.Dir(name), 0755); err != nil {
+		panic(err)
+	}
+	c.files[name] = &fakeFile{symlink: target, mode: 0777, modTime: time.Now()}
+}
+
+func pathError(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// writeFile seeds a file, creating its parent directories
+func (c *fakeClient) writeFile(name string, data []byte) {
+	if err := c.MkdirAll(path.Dir(name), 0755); err != nil {
+		panic(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[name] = &fakeFile{data: append([]byte(nil), data...), mode: 0644, modTime: time.Now()}
+}
+
+func (c *fakeClient) Open(name string) (hdfsFileReader, error) {
+	if err := c.enter("Open", name); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return nil, pathError("open", name, os.ErrNotExist)
+	}
+	if err := unresolvedLink("open", name, f); err != nil {
+		return nil, err
+	}
+	c.openReaders++
+	return &fakeReader{
+		Reader: bytes.NewReader(append([]byte(nil), f.data...)),
+		info:   c.info(name, f),
+		client: c,
+	}, nil
+}
+
+func (c *fakeClient) create(name string) (*fakeWriter, error) {
+	parent, ok := c.files[path.Dir(name)]
+	if !ok || !parent.isDir {
+		return nil, pathError("create", name, os.ErrNotExist)
+	}
+	if _, ok := c.files[name]; ok {
+		return nil, pathError("create", name, os.ErrExist)
+	}
+	// Like the namenode with a client that sends no crypto protocol version
+	for zone := range c.zones {
+		if strings.HasPrefix(name, zone+"/") {
+			return nil, pathError("create", name, errors.New("UnknownCryptoProtocolVersionException: No crypto protocol versions provided by the client are supported"))
+		}
+	}
+	f := &fakeFile{mode: 0644, modTime: time.Now()}
+	c.files[name] = f
+	return &fakeWriter{client: c, file: f}, nil
+}
+
+func (c *fakeClient) Create(name string) (hdfsFileWriter, error) {
+	if err := c.enter("Create", name); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+	return c.create(name)
+}
+
+func (c *fakeClient) CreateFile(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	if err := c.enter("CreateFile", name); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	w, err := c.create(name)
+	if err != nil {
+		return nil, err
+	}
+	w.file.replication = replication
+	w.file.mode = perm
+	return w, nil
+}
+
+// ContentSize implements contentSizer
+func (c *fakeClient) ContentSize(name string) (int64, error) {
+	if err := c.enter("ContentSize", name); err != nil {
+		return 0, err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return 0, pathError("getcontentsummary", name, os.ErrNotExist)
+	}
+	size := int64(len(f.data))
+	for other, f := range c.files {
+		if isDescendant(other, name) {
+			size += int64(len(f.data))
+		}
+	}
+	return size, nil
+}
+
+// ContentCount implements contentCounter
+func (c *fakeClient) ContentCount(name string) (int64, error) {
+	if err := c.enter("ContentCount", name); err != nil {
+		return 0, err
+	}
+	defer c.mu.Unlock()
+
+	if _, ok := c.files[name]; !ok {
+		return 0, pathError("getcontentsummary", name, os.ErrNotExist)
+	}
+	var count int64
+	for other := range c.files {
+		if isDescendant(other, name) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// RenewLease implements leaseRenewer
+func (c *fakeClient) RenewLease() error {
+	if err := c.enter("RenewLease", ""); err != nil {
+		return err
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// CreateWithFavoredNodes implements favoredNodesCreator, recording the
+// favored nodes on the file
+func (c *fakeClient) CreateWithFavoredNodes(name string, replication int, blockSize int64, perm os.FileMode, favoredNodes []string) (hdfsFileWriter, error) {
+	if err := c.enter("CreateWithFavoredNodes", name); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	w, err := c.create(name)
+	if err != nil {
+		return nil, err
+	}
+	w.file.replication = replication
+	w.file.mode = perm
+	w.file.favored = append([]string(nil), favoredNodes...)
+	return w, nil
+}
+
+// CreateWithParents implements parentCreator, creating missing parents
+// with the mode the namenode would give them
+func (c *fakeClient) CreateWithParents(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	if err := c.enter("CreateWithParents", name); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if f, ok := c.files[dir]; ok {
+			if !f.isDir {
+				return nil, pathError("create", dir, os.ErrExist)
+			}
+		} else {
+			c.files[dir] = &fakeFile{isDir: true, mode: os.ModeDir | perm | 0300, modTime: time.Now()}
+		}
+		if dir == "/" {
+			break
+		}
+	}
+	w, err := c.create(name)
+	if err != nil {
+		return nil, err
+	}
+	w.file.replication = replication
+	w.file.mode = perm
+	return w, nil
+}
+
+func (c *fakeClient) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := c.enter("Chtimes", name); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return pathError("chtimes", name, os.ErrNotExist)
+	}
+	f.modTime = mtime
+	return nil
+}
+
+// SetReplication implements replicationSetter
+func (c *fakeClient) SetReplication(name string, replication int) error {
+	if err := c.enter("SetReplication", name); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return pathError("setreplication", name, os.ErrNotExist)
+	}
+	f.replication = replication
+	return nil
+}
+
+// StatFs implements fsStatter, reporting the bytes stored in the fake
+// against a fixed capacity
+func (c *fakeClient) StatFs() (hdfs.FsInfo, error) {
+	if err := c.enter("StatFs", "/"); err != nil {
+		return hdfs.FsInfo{}, err
+	}
+	defer c.mu.Unlock()
+
+	var used uint64
+	for _, f := range c.files {
+		used += uint64(len(f.data))
+	}
+	return hdfs.FsInfo{Capacity: fakeCapacity, Used: used, Remaining: fakeCapacity - used}, nil
+}
+
+// EncryptionInfo implements encryptionInfoGetter
+func (c *fakeClient) EncryptionInfo(name string) (*fileEncryptionInfo, error) {
+	if err := c.enter("EncryptionInfo", name); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return nil, pathError("stat", name, os.ErrNotExist)
+	}
+	return f.encryption, nil
+}
+
+// SetXAttr implements xattrClient
+func (c *fakeClient) SetXAttr(name, attr string, value []byte) error {
+	if err := c.enter("SetXAttr", name); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return pathError("setxattr", name, os.ErrNotExist)
+	}
+	if _, ok := f.xattrs[attr]; ok {
+		return pathError("setxattr", name, os.ErrExist)
+	}
+	if f.xattrs == nil {
+		f.xattrs = make(map[string][]byte)
+	}
+	f.xattrs[attr] = append([]byte(nil), value...)
+	return nil
+}
+
+// XAttrs implements xattrClient
+func (c *fakeClient) XAttrs(name string) (map[string][]byte, error) {
+	if err := c.enter("XAttrs", name); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return nil, pathError("getxattrs", name, os.ErrNotExist)
+	}
+	attrs := make(map[string][]byte, len(f.xattrs))
+	for attr, value := range f.xattrs {
+		attrs[attr] = value
+	}
+	return attrs, nil
+}
+
+// Truncate implements truncater
+func (c *fakeClient) Truncate(name string, size int64) (bool, error) {
+	if err := c.enter("Truncate", name); err != nil {
+		return false, err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return false, pathError("truncate", name, os.ErrNotExist)
+	}
+	if size < 0 || size > int64(len(f.data)) {
+		return false, pathError("truncate", name, errors.New("cannot truncate to a larger file size"))
+	}
+	f.data = f.data[:size]
+	return true, nil
+}
+
+// createEncryptionZone makes dir an encryption zone with the key keyName,
+// in which creates are refused
+func (c *fakeClient) createEncryptionZone(dir, keyName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.zones == nil {
+		c.zones = make(map[string]string)
+	}
+	c.zones[dir] = keyName
+}
+
+// SetStoragePolicy implements storagePolicySetter
+func (c *fakeClient) SetStoragePolicy(name string, policy string) error {
+	if err := c.enter("SetStoragePolicy", name); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return pathError("setstoragepolicy", name, os.ErrNotExist)
+	}
+	f.policy = policy
+	return nil
+}
+
+// storagePolicy returns the storage policy of name
+func (c *fakeClient) storagePolicy(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.files[name]; ok {
+		return f.policy
+	}
+	return ""
+}
+
+// replication returns the replication factor of name, 0 for the default
+func (c *fakeClient) replication(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.files[name]; ok {
+		return f.replication
+	}
+	return -1
+}
+
+func (c *fakeClient) Append(name string) (hdfsFileWriter, error) {
+	if err := c.enter("Append", name); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return nil, pathError("append", name, os.ErrNotExist)
+	}
+	if f.isDir {
+		return nil, pathError("append", name, errors.New("is a directory"))
+	}
+	return &fakeWriter{client: c, file: f}, nil
+}
+
+func (c *fakeClient) Stat(name string) (os.FileInfo, error) {
+	if err := c.enter("Stat", name); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return nil, pathError("stat", name, os.ErrNotExist)
+	}
+	if err := unresolvedLink("stat", name, f); err != nil {
+		return nil, err
+	}
+	return c.info(name, f), nil
+}
+
+func (c *fakeClient) ReadDir(dirname string) ([]os.FileInfo, error) {
+	if err := c.enter("ReadDir", dirname); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	dir, ok := c.files[dirname]
+	if !ok {
+		return nil, pathError("readdir", dirname, os.ErrNotExist)
+	}
+	// Like the namenode, listing a file returns the file itself
+	if !dir.isDir {
+		return []os.FileInfo{c.info(dirname, dir)}, nil
+	}
+
+	var names []string
+	for name := range c.files {
+		if name != dirname && path.Dir(name) == dirname {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, c.info(name, c.files[name]))
+	}
+	return infos, nil
+}
+
+// ReadDirBatches implements dirBatchReader, reading the whole directory like
+// ReadDir and handing it out n entries at a time
+func (c *fakeClient) ReadDirBatches(dirname string, n int, f func([]os.FileInfo) error) error {
+	fileInfos, err := c.ReadDir(dirname)
+	if err != nil {
+		return err
+	}
+	for len(fileInfos) > 0 {
+		batch := fileInfos
+		if len(batch) > n {
+			batch = batch[:n]
+		}
+		if err := f(batch); err != nil {
+			return err
+		}
+		fileInfos = fileInfos[len(batch):]
+	}
+	return nil
+}
+
+func (c *fakeClient) Rename(oldpath, newpath string) error {
+	if err := c.enter("Rename", oldpath); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	if _, ok := c.files[oldpath]; !ok {
+		return pathError("rename", oldpath, os.ErrNotExist)
+	}
+	if parent, ok := c.files[path.Dir(newpath)]; !ok || !parent.isDir {
+		return pathError("rename", newpath, os.ErrNotExist)
+	}
+	// Like the namenode, which only replaces files
+	if f, ok := c.files[newpath]; ok && f.isDir && newpath != oldpath {
+		return pathError("rename", newpath, os.ErrExist)
+	}
+	moved := make(map[string]*fakeFile)
+	for name, f := range c.files {
+		if name == oldpath || isDescendant(name, oldpath) {
+			delete(c.files, name)
+			moved[newpath+name[len(oldpath):]] = f
+		}
+	}
+	for name, f := range moved {
+		c.files[name] = f
+	}
+	return nil
+}
+
+func (c *fakeClient) Remove(name string) error {
+	if err := c.enter("Remove", name); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	if _, ok := c.files[name]; !ok {
+		return pathError("remove", name, os.ErrNotExist)
+	}
+	for other := range c.files {
+		if other == name || isDescendant(other, name) {
+			delete(c.files, other)
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) MkdirAll(dirname string, perm os.FileMode) error {
+	if err := c.enter("MkdirAll", dirname); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	for dir := dirname; ; dir = path.Dir(dir) {
+		if f, ok := c.files[dir]; ok {
+			if !f.isDir {
+				return pathError("mkdir", dir, os.ErrExist)
+			}
+		} else {
+			c.files[dir] = &fakeFile{isDir: true, mode: os.ModeDir | perm, modTime: time.Now()}
+		}
+		if dir == "/" {
+			return nil
+		}
+	}
+}
+
+func (c *fakeClient) Chmod(name string, perm os.FileMode) error {
+	if err := c.enter("Chmod", name); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[name]
+	if !ok {
+		return pathError("chmod", name, os.ErrNotExist)
+	}
+	f.mode = f.mode&os.ModeType | perm.Perm()
+	return nil
+}
+
+func (c *fakeClient) ReadFile(filename string) ([]byte, error) {
+	if err := c.enter("ReadFile", filename); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	f, ok := c.files[filename]
+	if !ok {
+		return nil, pathError("open", filename, os.ErrNotExist)
+	}
+	if err := unresolvedLink("open", filename, f); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+func isDescendant(name, dir string) bool {
+	if dir == "/" {
+		return name != "/"
+	}
+	return len(name) > len(dir) && name[:len(dir)] == dir && name[len(dir)] == '/'
+}
+
+// fakeReader is an open fakeClient file
+type fakeReader struct {
+	*bytes.Reader
+	info   os.FileInfo
+	client *fakeClient
+	closed bool
+}
+
+func (r *fakeReader) Stat() os.FileInfo { return r.info }
+
+func (r *fakeReader) Close() error {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		r.client.openReaders--
+	}
+	return nil
+}
+
+// fakeWriter appends to a fakeClient file; bytes are visible immediately
+type fakeWriter struct {
+	client  *fakeClient
+	file    *fakeFile
+	flushes int
+	closed  bool
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error) {
+	w.client.mu.Lock()
+	defer w.client.mu.Unlock()
+	if w.closed {
+		return 0, errors.New("write to closed file")
+	}
+	w.file.data = append(w.file.data, p...)
+	w.file.modTime = time.Now()
+	return len(p), nil
+}
+
+func (w *fakeWriter) Flush() error {
+	w.flushes++
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+// newTestDriver returns a driver rooted at /registry on top of client
+func newTestDriver(client hdfsClient) *driver {
+	return newTestDriverWithParameters(client, DriverParameters{})
+}
+
+// newTestDriverWithParameters is newTestDriver with additional parameters;
+// the root directory and umask are filled in when unset.
+func newTestDriverWithParameters(client hdfsClient, params DriverParameters) *driver {
+	d, err := newDriver(client, fillTestParameters(params))
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// fillTestParameters fills in the root directory and umask when unset
+func fillTestParameters(params DriverParameters) DriverParameters {
+	if params.HdfsRootDirectory == "" {
+		params.HdfsRootDirectory = "/registry"
+	}
+	if params.DirectoryUmask == 0 {
+		params.DirectoryUmask = defaultDirectoryUmask
+	}
+	return params
+}
+
+func TestFakeClientRoundTrip(t *testing.T) {
+	d := newTestDriver(newFakeClient())
+	ctx := context.Background()
+	contents := []byte("manifest contents")
+
+	if err := d.PutContent(ctx, "/repo/manifest", contents); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	read, err := d.GetContent(ctx, "/repo/manifest")
+	if err != nil {
+		t.Fatalf("unexpected error from GetContent: %v", err)
+	}
+	if !bytes.Equal(read, contents) {
+		t.Fatalf("expected %q, got %q", contents, read)
+	}
+
+	fi, err := d.Stat(ctx, "/repo/manifest")
+	if err != nil {
+		t.Fatalf("unexpected error from Stat: %v", err)
+	}
+	if fi.Size() != int64(len(contents)) || fi.IsDir() {
+		t.Fatalf("unexpected file info %+v", fi)
+	}
+}
+
+func TestFakeClientErrorMapping(t *testing.T) {
+	d := newTestDriver(newFakeClient())
+	ctx := context.Background()
+
+	if _, err := d.GetContent(ctx, "/missing"); !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError from GetContent, got %v", err)
+	}
+	if _, err := d.Stat(ctx, "/missing"); !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError from Stat, got %v", err)
+	}
+}
+
+func isPathNotFound(err error) bool {
+	_, ok := err.(storagedriver.PathNotFoundError)
+	return ok
+}
@@ -0,0 +1,41 @@
+package hdfs
+
+import (
+	"io"
+	"sync"
+)
+
+// driverCloser closes client, the client New dialed, once
+type driverCloser struct {
+	once   sync.Once
+	client io.Closer
+	err    error
+}
+
+// Close releases what the driver holds: it stops reporting changes to
+// DriverParameters.OnChange once the events that wait for it were
+// delivered, replays the changes waiting for mirrornamenode, stops probing
+// with healthrouting and closes the connections to the namenodes. A client
+// passed to NewWithClient is left open for its owner. Operations on the
+// driver fail after Close, which may be called more than once, including
+// from OnChange.
+func (d *Driver) Close() error {
+	inner := d.inner()
+	// Outside of once, which an OnChange calling Close would otherwise wait
+	// for while another Close waits for OnChange
+	inner.changes.stop()
+	inner.closer.once.Do(func() {
+		if inner.closer.client != nil {
+			inner.closer.err = inner.closer.client.Close()
+		}
+	})
+	return inner.closer.err
+}
+
+// closeClient closes client if it holds connections
+func closeClient(client hdfsClient) error {
+	if closer, ok := client.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package hdfs
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultCloseTimeout bounds how long closing a file may take. A close
+// waits for the datanodes to acknowledge the last packets and the namenode
+// to complete the last block, which with datanodes failing during pipeline
+// recovery can otherwise block forever.
+const defaultCloseTimeout = 2 * time.Minute
+
+// CloseTimeoutError is returned when closing the file at Path did not
+// finish within closetimeout. The file stays open under the lease of the
+// driver until the namenode recovers the lease, after the hard limit of an
+// hour at the latest, and reads may see it short until then.
+type CloseTimeoutError struct {
+	Path    string
+	Timeout time.Duration
+}
+
+func (e CloseTimeoutError) Error() string {
+	return fmt.Sprintf("hdfs: closing %s did not finish within %v", e.Path, e.Timeout)
+}
+
+// closeHdfsWriter closes the HDFS file of w, giving up after closeTimeout.
+// The close that was given up on keeps running until the client returns.
+func (w *fileWriter) closeHdfsWriter() error {
+	if w.closeTimeout <= 0 {
+		return w.hdfsWriter.Close()
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- w.hdfsWriter.Close()
+	}()
+	timer := time.NewTimer(w.closeTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-closed:
+		return err
+	case <-timer.C:
+		return CloseTimeoutError{Path: w.filePath, Timeout: w.closeTimeout}
+	}
+}
@@ -0,0 +1,65 @@
+package hdfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// stuckCloseClient creates files whose Close blocks until release is closed,
+// like a close waiting on a pipeline that never recovers
+type stuckCloseClient struct {
+	*fakeClient
+	release chan struct{}
+}
+
+func (c *stuckCloseClient) Create(name string) (hdfsFileWriter, error) {
+	writer, err := c.fakeClient.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &stuckCloseWriter{hdfsFileWriter: writer, release: c.release}, nil
+}
+
+type stuckCloseWriter struct {
+	hdfsFileWriter
+	release chan struct{}
+}
+
+func (w *stuckCloseWriter) Close() error {
+	<-w.release
+	return w.hdfsFileWriter.Close()
+}
+
+func TestCloseTimeout(t *testing.T) {
+	client := &stuckCloseClient{fakeClient: newFakeClient(), release: make(chan struct{})}
+	defer close(client.release)
+	d := newTestDriverWithParameters(client, DriverParameters{CloseTimeout: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	writer, err := d.Writer(ctx, "/file", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := writer.Write([]byte("contents")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	err = writer.Close()
+	if _, ok := err.(CloseTimeoutError); !ok {
+		t.Fatalf("expected a CloseTimeoutError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected Close to give up after the timeout, took %v", elapsed)
+	}
+
+	// PutContent closes through Commit with verifywrites
+	d = newTestDriverWithParameters(client, DriverParameters{CloseTimeout: 50 * time.Millisecond, VerifyWrites: true})
+	if err := d.PutContent(ctx, "/other", []byte("contents")); err == nil {
+		t.Fatal("expected PutContent to fail when the close times out")
+	} else if _, ok := err.(CloseTimeoutError); !ok {
+		t.Fatalf("expected a CloseTimeoutError, got %v", err)
+	}
+}
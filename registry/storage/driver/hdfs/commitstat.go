@@ -0,0 +1,40 @@
+package hdfs
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// defaultCommitStatTimeout bounds how long Commit waits for Stat to
+	// report what was written
+	defaultCommitStatTimeout = 10 * time.Second
+
+	// commitStatInterval is the pause between those Stats
+	commitStatInterval = 50 * time.Millisecond
+)
+
+// awaitSize waits until a Stat of fullPath reports size, as Commit does
+// with commitstattimeout. The namenode only knows the length of a file up
+// to its last complete block until it is closed, and an observer namenode
+// with readfromobserver answers from edits it may not have applied yet, so
+// a Stat right after a write can report less than was written.
+func (d *driver) awaitSize(fullPath string, size int64) error {
+	deadline := d.budget.limit(time.Now().Add(d.commitStatTimeout))
+	for {
+		fi, err := d.hdfsClient.Stat(fullPath)
+		if err == nil && fi.Size() == size {
+			return nil
+		} else if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if time.Now().Add(commitStatInterval).After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("hdfs: %s is still %d bytes %v after committing %d bytes", fullPath, fi.Size(), d.commitStatTimeout, size)
+		}
+		time.Sleep(commitStatInterval)
+	}
+}
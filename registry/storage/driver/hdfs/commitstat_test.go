@@ -0,0 +1,93 @@
+package hdfs
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// laggingStatClient reports files closed by its writers as empty for the
+// next lag Stats, like an observer namenode behind the active one
+type laggingStatClient struct {
+	*fakeClient
+	lag   int
+	stale int
+}
+
+func (c *laggingStatClient) Create(name string) (hdfsFileWriter, error) {
+	writer, err := c.fakeClient.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &laggingStatWriter{hdfsFileWriter: writer, client: c}, nil
+}
+
+func (c *laggingStatClient) Stat(name string) (os.FileInfo, error) {
+	fi, err := c.fakeClient.Stat(name)
+	if err == nil && c.stale > 0 {
+		c.stale--
+		return emptyFileInfo{fi}, nil
+	}
+	return fi, err
+}
+
+type laggingStatWriter struct {
+	hdfsFileWriter
+	client *laggingStatClient
+}
+
+func (w *laggingStatWriter) Close() error {
+	w.client.stale = w.client.lag
+	return w.hdfsFileWriter.Close()
+}
+
+type emptyFileInfo struct {
+	os.FileInfo
+}
+
+func (fi emptyFileInfo) Size() int64 { return 0 }
+
+func TestCommitStatConsistency(t *testing.T) {
+	client := &laggingStatClient{fakeClient: newFakeClient(), lag: 2}
+	d := newTestDriverWithParameters(client, DriverParameters{CommitStatTimeout: 5 * time.Second})
+	ctx := context.Background()
+
+	for i := 1; i <= 20; i++ {
+		p := fmt.Sprintf("/uploads/%d/data", i)
+		writer, err := d.Writer(ctx, p, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := writer.Write(make([]byte, i)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := writer.Commit(); err != nil {
+			t.Fatalf("unexpected error from Commit: %v", err)
+		}
+		writer.Close()
+
+		fi, err := d.Stat(ctx, p)
+		if err != nil {
+			t.Fatalf("unexpected error from Stat: %v", err)
+		}
+		if fi.Size() != int64(i) {
+			t.Fatalf("expected Stat after Commit to report %d bytes, got %d", i, fi.Size())
+		}
+	}
+
+	// A size that never shows up fails the Commit once the timeout passed
+	client.lag = 1 << 30
+	d = newTestDriverWithParameters(client, DriverParameters{CommitStatTimeout: 100 * time.Millisecond})
+	writer, err := d.Writer(ctx, "/uploads/lagging/data", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer writer.Close()
+	writer.Write([]byte("contents"))
+	if err := writer.Commit(); err == nil {
+		t.Fatal("expected Commit to fail when Stat keeps lagging")
+	}
+}
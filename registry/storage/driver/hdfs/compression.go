@@ -0,0 +1,184 @@
+package hdfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Objects stored compressed carry the codec and their logical
+// (uncompressed) size in the compressionXAttr extended attribute, as
+// "<codec> <size>"; their data is the compressed stream alone. Objects
+// without the attribute are read back as-is, so compressed and uncompressed
+// objects can live side by side and no contents are mistaken for a
+// compressed object. Stat reads the size from the attribute without opening
+// the file.
+//
+// The attribute is looked up whenever the compression parameter is set:
+// compression none stops compressing new objects while those written
+// compressed before still read back. colinmarc/hdfs cannot set or read
+// extended attributes, so compression needs WebHDFS. The attribute only
+// reaches the primary cluster, so compression cannot be combined with
+// mirrornamenode.
+//
+// Only PutContent and PutReader compress. Streams written through Writer are mostly
+// layers that are compressed already, and they are appended to across
+// resumed uploads using the physical size, so they are stored untouched.
+// zstd is not available, since no zstd library is vendored; only gzip,
+// which is in the standard library, is built in.
+const compressionXAttr = "user.registry.compression"
+
+var (
+	errCompressionWebHdfs = fmt.Errorf("The compression parameter requires hdfswebhdfsaddr, webhdfsport or webhdfstls")
+	errCompressionMirror  = fmt.Errorf("The compression parameter cannot be combined with mirrornamenode")
+)
+
+// codec compresses objects at rest
+type codec interface {
+	name() string
+	newWriter(w io.Writer) (io.WriteCloser, error)
+	newReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// codecs holds the codecs by name. Another codec needs its library
+// vendored and a registerCodec call in the init function below.
+var codecs = map[string]codec{}
+
+func registerCodec(c codec) {
+	codecs[c.name()] = c
+}
+
+func init() {
+	registerCodec(gzipCodec{})
+}
+
+// newCodec returns the codec selected by the compression parameter, or nil
+// when objects are stored uncompressed.
+func newCodec(name string) (codec, error) {
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	if c, ok := codecs[name]; ok {
+		return c, nil
+	}
+	if name == "zstd" {
+		return nil, fmt.Errorf("The compression parameter cannot be zstd, which is not implemented, use gzip")
+	}
+
+	names := []string{"none"}
+	for n := range codecs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("The compression parameter must be one of %v, %q is not available", names, name)
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) name() string { return "gzip" }
+
+func (gzipCodec) newWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCodec) newReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// compress encodes contents with c
+func compress(c codec, contents []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := c.newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(contents); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressionHeader describes a compressed object
+type compressionHeader struct {
+	codec       codec
+	logicalSize int64
+}
+
+// value returns the compressionXAttr of the object h describes
+func (h compressionHeader) value() []byte {
+	return []byte(fmt.Sprintf("%s %d", h.codec.name(), h.logicalSize))
+}
+
+// parseCompressionHeader parses a compressionXAttr
+func parseCompressionHeader(value []byte) (*compressionHeader, error) {
+	fields := strings.Fields(string(value))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid %s %q", compressionXAttr, value)
+	}
+	c, ok := codecs[fields[0]]
+	if !ok {
+		return nil, fmt.Errorf("object is compressed with unavailable codec %q", fields[0])
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid size in %s %q", compressionXAttr, value)
+	}
+	return &compressionHeader{codec: c, logicalSize: size}, nil
+}
+
+// compressionOf returns how the file at fullPath is compressed, or nil when
+// it is stored as it is. Files are only looked up with compression set.
+func (d *driver) compressionOf(fullPath string) (*compressionHeader, error) {
+	if !d.readsCompressed {
+		return nil, nil
+	}
+	attrs, err := d.xattrs(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := attrs[compressionXAttr]
+	if !ok {
+		return nil, nil
+	}
+	return parseCompressionHeader(value)
+}
+
+// decompress returns the logical contents of a stored object compressed as
+// header says, or stored itself when header is nil
+func decompress(header *compressionHeader, stored []byte) ([]byte, error) {
+	if header == nil {
+		return stored, nil
+	}
+	r, err := header.codec.newReader(bytes.NewReader(stored))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	contents := make([]byte, 0, header.logicalSize)
+	buf := bytes.NewBuffer(contents)
+	if _, err := io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressingReader streams the logical contents of a compressed object
+type decompressingReader struct {
+	io.Reader
+	decoder io.Closer
+	file    io.Closer
+}
+
+func (r *decompressingReader) Close() error {
+	r.decoder.Close()
+	return r.file.Close()
+}
@@ -0,0 +1,250 @@
+package hdfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{Compression: "gzip"})
+	ctx := context.Background()
+	contents := bytes.Repeat([]byte("compressible manifest contents "), 256)
+
+	if err := d.PutContent(ctx, "/repo/manifest", contents); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+
+	stored, err := client.ReadFile("/registry/repo/manifest")
+	if err != nil {
+		t.Fatalf("unexpected error reading the stored object: %v", err)
+	}
+	if !bytes.HasPrefix(stored, []byte{0x1f, 0x8b}) || len(stored) >= len(contents) {
+		t.Fatalf("expected a gzip stream, got %d bytes", len(stored))
+	}
+	attrs, _ := client.XAttrs("/registry/repo/manifest")
+	if value := string(attrs[compressionXAttr]); value != fmt.Sprintf("gzip %d", len(contents)) {
+		t.Fatalf("unexpected %s %q", compressionXAttr, value)
+	}
+
+	read, err := d.GetContent(ctx, "/repo/manifest")
+	if err != nil {
+		t.Fatalf("unexpected error from GetContent: %v", err)
+	}
+	if !bytes.Equal(read, contents) {
+		t.Fatalf("GetContent did not return the original contents")
+	}
+
+	reader, err := d.Reader(ctx, "/repo/manifest", 0)
+	if err != nil {
+		t.Fatalf("unexpected error from Reader: %v", err)
+	}
+	read, err = ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	if !bytes.Equal(read, contents) {
+		t.Fatalf("Reader did not return the original contents")
+	}
+
+	opens := client.callCount("Open")
+	fi, err := d.Stat(ctx, "/repo/manifest")
+	if err != nil {
+		t.Fatalf("unexpected error from Stat: %v", err)
+	}
+	if fi.Size() != int64(len(contents)) {
+		t.Fatalf("expected Stat to report the logical size %d, got %d", len(contents), fi.Size())
+	}
+	if client.callCount("Open") != opens {
+		t.Fatal("expected Stat not to open the file")
+	}
+	if client.openReaders != 0 {
+		t.Fatalf("%d readers left open", client.openReaders)
+	}
+}
+
+func TestCompressionReadsUncompressedObjects(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{Compression: "gzip"})
+	ctx := context.Background()
+	contents := []byte("written before compression was enabled")
+	client.writeFile("/registry/repo/old", contents)
+
+	read, err := d.GetContent(ctx, "/repo/old")
+	if err != nil || !bytes.Equal(read, contents) {
+		t.Fatalf("unexpected GetContent result %q, %v", read, err)
+	}
+
+	reader, err := d.Reader(ctx, "/repo/old", 8)
+	if err != nil {
+		t.Fatalf("unexpected error from Reader: %v", err)
+	}
+	read, _ = ioutil.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(read, contents[8:]) {
+		t.Fatalf("expected %q, got %q", contents[8:], read)
+	}
+
+	fi, err := d.Stat(ctx, "/repo/old")
+	if err != nil || fi.Size() != int64(len(contents)) {
+		t.Fatalf("unexpected Stat result %+v, %v", fi, err)
+	}
+}
+
+func TestCompressionReaderOffset(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{Compression: "gzip"})
+	ctx := context.Background()
+	contents := make([]byte, 256<<10)
+	for i := range contents {
+		contents[i] = byte(i % 251)
+	}
+
+	if err := d.PutContent(ctx, "/repo/blob", contents); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+
+	offset := int64(len(contents)/2 + 17)
+	reader, err := d.Reader(ctx, "/repo/blob", offset)
+	if err != nil {
+		t.Fatalf("unexpected error from Reader: %v", err)
+	}
+	read := make([]byte, 4096)
+	if _, err := io.ReadFull(reader, read); err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	reader.Close()
+	if !bytes.Equal(read, contents[offset:offset+4096]) {
+		t.Fatalf("Reader at offset %d returned the wrong range", offset)
+	}
+
+	reader, err = d.Reader(ctx, "/repo/blob", int64(len(contents)))
+	if err != nil {
+		t.Fatalf("unexpected error reading at the end: %v", err)
+	}
+	if rest, _ := ioutil.ReadAll(reader); len(rest) != 0 {
+		t.Fatalf("expected nothing after the end, got %d bytes", len(rest))
+	}
+	reader.Close()
+
+	if _, err := d.Reader(ctx, "/repo/blob", int64(len(contents))+1); err == nil {
+		t.Fatalf("expected an error reading past the end")
+	} else if _, ok := err.(storagedriver.InvalidOffsetError); !ok {
+		t.Fatalf("expected InvalidOffsetError, got %v", err)
+	}
+	if client.openReaders != 0 {
+		t.Fatalf("%d readers left open", client.openReaders)
+	}
+}
+
+func TestCompressionParameter(t *testing.T) {
+	if c, err := newCodec("none"); c != nil || err != nil {
+		t.Fatalf("expected none to disable compression, got %v, %v", c, err)
+	}
+	for _, test := range []struct {
+		parameters map[string]interface{}
+		expected   string
+	}{
+		{map[string]interface{}{"compression": "zstd", "webhdfsport": 9870}, "zstd, which is not implemented"},
+		{map[string]interface{}{"compression": "gzip"}, "requires hdfswebhdfsaddr"},
+		{map[string]interface{}{"compression": "gzip", "webhdfsport": 9870, "mirrornamenode": "dr:8020"}, "mirrornamenode"},
+	} {
+		_, err := FromParameters(test.parameters)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%v: expected an error containing %q, got %v", test.parameters, test.expected, err)
+		}
+	}
+}
+
+func TestCompressionNoneReadsCompressedObjects(t *testing.T) {
+	client := newFakeClient()
+	ctx := context.Background()
+	contents := bytes.Repeat([]byte("manifest "), 100)
+	if err := newTestDriverWithParameters(client, DriverParameters{Compression: "gzip"}).PutContent(ctx, "/repo/manifest", contents); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+
+	d := newTestDriverWithParameters(client, DriverParameters{Compression: "none"})
+	if read, err := d.GetContent(ctx, "/repo/manifest"); err != nil || !bytes.Equal(read, contents) {
+		t.Fatalf("expected compression none to decompress, got %d bytes, %v", len(read), err)
+	}
+	if err := d.PutContent(ctx, "/repo/other", contents); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if stored, _ := client.ReadFile("/registry/repo/other"); !bytes.Equal(stored, contents) {
+		t.Fatal("expected compression none to store contents as they are")
+	}
+}
+
+func TestCompressionKeepsContentsAsWritten(t *testing.T) {
+	client := newFakeClient()
+	ctx := context.Background()
+	gzipped, err := compress(gzipCodec{}, []byte("looks compressed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the attribute, contents are never mistaken for an object
+	// stored compressed, however they start
+	if err := newTestDriver(client).PutContent(ctx, "/repo/link", gzipped); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	writer, err := newTestDriver(client).Writer(ctx, "/repo/_uploads/data", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write(gzipped)
+	writer.Commit()
+	writer.Close()
+
+	d := newTestDriverWithParameters(client, DriverParameters{Compression: "gzip"})
+	for _, path := range []string{"/repo/link", "/repo/_uploads/data"} {
+		if contents, err := d.GetContent(ctx, path); err != nil || !bytes.Equal(contents, gzipped) {
+			t.Fatalf("%s: expected the contents as written, got %q, %v", path, contents, err)
+		}
+		if fi, err := d.Stat(ctx, path); err != nil || fi.Size() != int64(len(gzipped)) {
+			t.Fatalf("%s: unexpected Stat result %+v, %v", path, fi, err)
+		}
+	}
+}
+
+func TestCompressionThroughWebHdfs(t *testing.T) {
+	client := newFakeClient()
+	server := httptest.NewServer(&fakeWebHdfs{namenode: client})
+	defer server.Close()
+	d := newTestDriverWithParameters(basicClient{client}, DriverParameters{Compression: "gzip"})
+	d.webHdfs = newWebHdfsClient(server.URL, "registry")
+	ctx := context.Background()
+	contents := bytes.Repeat([]byte("config "), 100)
+
+	if err := d.PutContent(ctx, "/repo/config", contents); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if attrs, _ := client.XAttrs("/registry/repo/config"); len(attrs[compressionXAttr]) == 0 {
+		t.Fatalf("expected the attribute to be set through WebHDFS")
+	}
+	if read, err := d.GetContent(ctx, "/repo/config"); err != nil || !bytes.Equal(read, contents) {
+		t.Fatalf("unexpected GetContent result, %d bytes, %v", len(read), err)
+	}
+	if fi, err := d.Stat(ctx, "/repo/config"); err != nil || fi.Size() != int64(len(contents)) {
+		t.Fatalf("unexpected Stat result %+v, %v", fi, err)
+	}
+
+	// Without WebHDFS the object cannot be stored compressed
+	d.webHdfs = nil
+	if err := d.PutContent(ctx, "/repo/other", contents); err != errWebHdfsRequired {
+		t.Fatalf("expected %v, got %v", errWebHdfsRequired, err)
+	}
+	if _, err := client.Stat("/registry/repo/other"); err == nil {
+		t.Fatal("expected nothing to be stored without the attribute")
+	}
+}
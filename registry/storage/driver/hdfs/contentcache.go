@@ -0,0 +1,128 @@
+package hdfs
+
+import (
+	"container/list"
+	"path"
+	"strings"
+	"sync"
+)
+
+// defaultContentCachePrefixes holds content addressed blobs, manifests and
+// image configs among them, which never change once written
+const defaultContentCachePrefixes = "/docker/registry/v2/blobs"
+
+// contentCache keeps the contents GetContent read from immutable paths in
+// memory, evicting the least recently used once they take more than
+// maxBytes. Entries are keyed by full HDFS path, so reads from snapshots
+// are cached apart. Writes, moves and deletes invalidate what they touch,
+// so the cache only saves reads even if an immutable path is rewritten.
+type contentCache struct {
+	maxBytes int64
+	prefixes []string
+
+	mu      sync.Mutex
+	bytes   int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type contentCacheEntry struct {
+	fullPath string
+	contents []byte
+}
+
+// newContentCache returns nil when maxBytes is 0. prefixes are the paths
+// below the root directory that are cached.
+func newContentCache(maxBytes int64, root string, prefixes []string) *contentCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	c := &contentCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	c.prefixes = joinPrefixes(root, prefixes)
+	return c
+}
+
+// joinPrefixes returns the full paths of prefixes below root
+func joinPrefixes(root string, prefixes []string) []string {
+	var joined []string
+	for _, prefix := range prefixes {
+		joined = append(joined, path.Join(root, prefix))
+	}
+	return joined
+}
+
+// cacheable reports whether the contents of fullPath are cached
+func (c *contentCache) cacheable(fullPath string) bool {
+	return c != nil && belowAny(c.prefixes, fullPath)
+}
+
+// belowAny reports whether fullPath is below one of prefixes
+func belowAny(prefixes []string, fullPath string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(fullPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns a copy of the cached contents of fullPath
+func (c *contentCache) get(fullPath string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[fullPath]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return append([]byte{}, element.Value.(*contentCacheEntry).contents...), true
+}
+
+// add caches contents of fullPath, unless they would take up more than a
+// quarter of the cache
+func (c *contentCache) add(fullPath string, contents []byte) {
+	if c == nil || int64(len(contents)) > c.maxBytes/4 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[fullPath]; ok {
+		c.remove(element)
+	}
+	entry := &contentCacheEntry{fullPath: fullPath, contents: append([]byte{}, contents...)}
+	c.entries[fullPath] = c.order.PushFront(entry)
+	c.bytes += int64(len(contents))
+	for c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidate drops fullPath and everything below it
+func (c *contentCache) invalidate(fullPath string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, element := range c.entries {
+		if key == fullPath || strings.HasPrefix(key, fullPath+"/") {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *contentCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*contentCacheEntry)
+	delete(c.entries, entry.fullPath)
+	c.bytes -= int64(len(entry.contents))
+}
@@ -0,0 +1,70 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestContentCache(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{ContentCacheSize: 1 << 20, ContentCacheDirs: defaultContentCachePrefixes})
+	ctx := context.Background()
+
+	manifest := "/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data"
+	if err := d.PutContent(ctx, manifest, []byte("{}")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if contents, err := d.GetContent(ctx, manifest); err != nil || string(contents) != "{}" {
+			t.Fatalf("unexpected GetContent result %q, %v", contents, err)
+		}
+	}
+	if opens := client.callCount("Open"); opens != 2 {
+		t.Fatalf("expected the second GetContent to be served from the cache, got %d opens", opens)
+	}
+
+	// Mutable paths are read every time
+	tag := "/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link"
+	if err := d.PutContent(ctx, tag, []byte("sha256:"+testDigestHex)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before := client.callCount("Open")
+	d.GetContent(ctx, tag)
+	d.GetContent(ctx, tag)
+	if opens := client.callCount("Open") - before; opens != 2 {
+		t.Fatalf("expected tags not to be cached, got %d opens", opens)
+	}
+
+	// Deleting the blob directory invalidates its contents
+	if err := d.Delete(ctx, "/docker/registry/v2/blobs/sha256/ab"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := d.GetContent(ctx, manifest); !isPathNotFound(err) {
+		t.Fatalf("expected the deleted manifest to be gone, got %v", err)
+	}
+}
+
+func TestContentCacheEviction(t *testing.T) {
+	c := newContentCache(16, "/registry", []string{"/blobs"})
+	c.add("/registry/blobs/a", []byte("aaaa"))
+	c.add("/registry/blobs/b", []byte("bbbb"))
+	c.add("/registry/blobs/c", []byte("cccc"))
+	c.get("/registry/blobs/a")
+	c.add("/registry/blobs/d", []byte("dddd"))
+	c.add("/registry/blobs/e", []byte("eeee"))
+
+	if _, ok := c.get("/registry/blobs/b"); ok {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+	if _, ok := c.get("/registry/blobs/a"); !ok {
+		t.Fatal("expected the recently read entry to be kept")
+	}
+	if c.bytes > 16 {
+		t.Fatalf("expected the cache to stay within its size, it holds %d bytes", c.bytes)
+	}
+	c.add("/registry/blobs/big", make([]byte, 5))
+	if _, ok := c.get("/registry/blobs/big"); ok {
+		t.Fatal("expected objects larger than a quarter of the cache not to be cached")
+	}
+}
@@ -0,0 +1,24 @@
+package hdfs
+
+import (
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// ContentDefaulter is implemented by drivers that can read an optional
+// object in one call, such as the HDFS driver
+type ContentDefaulter interface {
+	// GetContentOrDefault is GetContent, except that defaultContent is
+	// returned when nothing is stored at path. Other errors are returned
+	// as they are.
+	GetContentOrDefault(ctx context.Context, path string, defaultContent []byte) ([]byte, error)
+}
+
+// GetContentOrDefault implements ContentDefaulter
+func (d *Driver) GetContentOrDefault(ctx context.Context, path string, defaultContent []byte) ([]byte, error) {
+	content, err := d.GetContent(ctx, path)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return defaultContent, nil
+	}
+	return content, err
+}
@@ -0,0 +1,38 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestGetContentOrDefault(t *testing.T) {
+	client := newFakeClient()
+	var sd storagedriver.StorageDriver = wrap(newTestDriver(client))
+	ctx := context.Background()
+	client.writeFile("/registry/present", []byte("stored"))
+
+	defaulter, ok := sd.(ContentDefaulter)
+	if !ok {
+		t.Fatalf("expected the driver to implement ContentDefaulter")
+	}
+
+	if content, err := defaulter.GetContentOrDefault(ctx, "/present", []byte("default")); err != nil || string(content) != "stored" {
+		t.Fatalf("expected the stored content, got %q, %v", content, err)
+	}
+	if content, err := defaulter.GetContentOrDefault(ctx, "/missing", []byte("default")); err != nil || string(content) != "default" {
+		t.Fatalf("expected the default content, got %q, %v", content, err)
+	}
+
+	// Errors other than a missing path are not papered over
+	if _, err := defaulter.GetContentOrDefault(ctx, "invalid", []byte("default")); err == nil {
+		t.Fatalf("expected an invalid path to be an error")
+	}
+	client.writeFile("/registry/corrupt", []byte("stored"))
+	client.SetXAttr("/registry/corrupt", compressionXAttr, []byte("lz4 6"))
+	defaulter = wrap(newTestDriverWithParameters(client, DriverParameters{Compression: "gzip"}))
+	if _, err := defaulter.GetContentOrDefault(ctx, "/corrupt", []byte("default")); err == nil {
+		t.Fatalf("expected an unreadable object to be an error")
+	}
+}
@@ -0,0 +1,23 @@
+package hdfs
+
+import "os"
+
+// parentCreator is implemented by clients that can have the namenode create
+// the missing parents of a file in the create RPC itself, saving the
+// MkdirAll before it. A replication or block size of 0 is the cluster
+// default. colinmarc/hdfs cannot.
+//
+// The namenode gives the parents it creates the mode of the file plus
+// owner write and execute rather than directoryumask, which is why the
+// createparents parameter has to ask for it.
+type parentCreator interface {
+	CreateWithParents(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error)
+}
+
+// createWithParents creates name and its missing parents if c supports it
+func createWithParents(c hdfsClient, name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	if p, ok := c.(parentCreator); ok {
+		return p.CreateWithParents(name, replication, blockSize, perm)
+	}
+	return nil, errUnsupportedByClient
+}
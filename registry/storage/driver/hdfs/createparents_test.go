@@ -0,0 +1,55 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestCreateParents(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{CreateParents: true})
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/new/repo/file", []byte("contents")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := client.callCount("CreateWithParents"); calls != 1 {
+		t.Fatalf("expected a single create with parents, got %d", calls)
+	}
+	if calls := client.callCount("MkdirAll"); calls != 0 {
+		t.Fatalf("expected no MkdirAll, got %d", calls)
+	}
+	if contents, err := d.GetContent(ctx, "/new/repo/file"); err != nil || string(contents) != "contents" {
+		t.Fatalf("unexpected contents %q, %v", contents, err)
+	}
+}
+
+func TestCreateParentsFallback(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		client        func(*fakeClient) hdfsClient
+		createParents bool
+	}{
+		{"unsupported by the client", func(c *fakeClient) hdfsClient { return basicClient{c} }, true},
+		{"not enabled", func(c *fakeClient) hdfsClient { return c }, false},
+	} {
+		client := newFakeClient()
+		d := newTestDriverWithParameters(tc.client(client), DriverParameters{CreateParents: tc.createParents})
+		ctx := context.Background()
+
+		if err := d.PutContent(ctx, "/new/repo/file", []byte("contents")); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if calls := client.callCount("CreateWithParents"); calls != 0 {
+			t.Fatalf("%s: expected no create with parents, got %d", tc.name, calls)
+		}
+		if mkdirs, creates := client.callCount("MkdirAll"), client.callCount("Create"); mkdirs != 1 || creates != 1 {
+			t.Fatalf("%s: expected MkdirAll and Create once each, got %d and %d", tc.name, mkdirs, creates)
+		}
+		fi, err := client.Stat("/registry/new/repo")
+		if err != nil || fi.Mode().Perm() != defaultDirectoryUmask {
+			t.Fatalf("%s: expected the parent to get directoryumask, got %v, %v", tc.name, fi, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package hdfs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// colinmarc/hdfs reads a block from the first of its replicas it can
+// connect to, trying them in the order the namenode returned. A datanode
+// that accepts no connections, or only after dialtimeout, slows every read
+// of its blocks until the namenode declares it dead, which takes about ten
+// minutes. With datanodeblacklistttl set, a datanode that failed
+// datanodeblacklistthreshold connections in a row is avoided for that
+// long: connecting to it fails at once, and the client moves on to the
+// next replica. A block without another replica fails to read until the
+// datanode is tried again once the TTL is over.
+
+// defaultDatanodeBlacklistThreshold is how many failed connections in a row
+// blacklist a datanode unless datanodeblacklistthreshold says otherwise
+const defaultDatanodeBlacklistThreshold = 2
+
+// datanodeBlacklist tracks the failed connections to each datanode
+type datanodeBlacklist struct {
+	ttl       time.Duration
+	threshold int
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]int
+	until    map[string]time.Time
+}
+
+// newDatanodeBlacklist returns nil, which blacklists nothing, when ttl is 0
+func newDatanodeBlacklist(ttl time.Duration, threshold int) *datanodeBlacklist {
+	if ttl <= 0 {
+		return nil
+	}
+	if threshold <= 0 {
+		threshold = defaultDatanodeBlacklistThreshold
+	}
+	return &datanodeBlacklist{
+		ttl:       ttl,
+		threshold: threshold,
+		now:       time.Now,
+		failures:  make(map[string]int),
+		until:     make(map[string]time.Time),
+	}
+}
+
+// errDatanodeBlacklisted is returned for connections to a blacklisted
+// datanode
+type errDatanodeBlacklisted struct {
+	address string
+	until   time.Time
+}
+
+func (e errDatanodeBlacklisted) Error() string {
+	return fmt.Sprintf("hdfs: datanode %s is avoided until %s after failed connections", e.address, e.until.Format(time.RFC3339))
+}
+
+// allow returns an error when address is blacklisted
+func (b *datanodeBlacklist) allow(address string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.until[address]
+	if !ok {
+		return nil
+	}
+	if b.now().Before(until) {
+		return errDatanodeBlacklisted{address: address, until: until}
+	}
+	// The TTL is over, the next connection decides
+	delete(b.until, address)
+	b.failures[address] = b.threshold - 1
+	return nil
+}
+
+// record counts the outcome of a connection to address
+func (b *datanodeBlacklist) record(address string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		delete(b.failures, address)
+		return
+	}
+	b.failures[address]++
+	if b.failures[address] >= b.threshold {
+		until := b.now().Add(b.ttl)
+		b.until[address] = until
+		log.Printf("hdfs: avoiding datanode %s until %s after %d failed connections: %v", address, until.Format(time.RFC3339), b.failures[address], err)
+	}
+}
+
+// dial returns next with connections to blacklisted datanodes refused, or
+// next itself without a blacklist
+func (b *datanodeBlacklist) dial(next dialFunc) dialFunc {
+	if b == nil {
+		return next
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if err := b.allow(address); err != nil {
+			return nil, err
+		}
+		conn, err := next(ctx, network, address)
+		b.record(address, err)
+		return conn, err
+	}
+}
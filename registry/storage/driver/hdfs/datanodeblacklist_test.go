@@ -0,0 +1,105 @@
+package hdfs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDatanodeBlacklistAvoidsFailingDatanodeUntilTTL(t *testing.T) {
+	var mu sync.Mutex
+	dials := make(map[string]int)
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		mu.Lock()
+		dials[address]++
+		mu.Unlock()
+		if address == "dn1:50010" {
+			return nil, errors.New("connection refused")
+		}
+		conn, other := net.Pipe()
+		other.Close()
+		return conn, nil
+	}
+	dialed := func(address string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return dials[address]
+	}
+
+	now := time.Unix(1500000000, 0)
+	blacklist := newDatanodeBlacklist(time.Minute, 2)
+	blacklist.now = func() time.Time { return now }
+	blacklistedDial := blacklist.dial(dial)
+
+	// Every block has a replica on each datanode, a read tries them in order
+	read := func() {
+		for _, address := range []string{"dn1:50010", "dn2:50010"} {
+			conn, err := blacklistedDial(context.Background(), "tcp", address)
+			if err == nil {
+				conn.Close()
+				return
+			}
+		}
+		t.Fatal("expected a read to reach the healthy datanode")
+	}
+
+	for i := 0; i < 10; i++ {
+		read()
+	}
+	if n := dialed("dn1:50010"); n != 2 {
+		t.Fatalf("expected the failing datanode to be dialed twice before it is avoided, got %d", n)
+	}
+	if n := dialed("dn2:50010"); n != 10 {
+		t.Fatalf("expected every read to use the healthy datanode, got %d", n)
+	}
+
+	now = now.Add(59 * time.Second)
+	read()
+	if n := dialed("dn1:50010"); n != 2 {
+		t.Fatalf("expected the failing datanode to be avoided within the TTL, got %d dials", n)
+	}
+
+	// Once the TTL is over it is tried again, and a single failure
+	// blacklists it anew
+	now = now.Add(2 * time.Second)
+	read()
+	read()
+	if n := dialed("dn1:50010"); n != 3 {
+		t.Fatalf("expected one retry of the failing datanode after the TTL, got %d dials in all", n)
+	}
+}
+
+func TestDatanodeBlacklistForgetsFailuresOnSuccess(t *testing.T) {
+	fail := true
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if fail {
+			return nil, errors.New("connection reset")
+		}
+		conn, other := net.Pipe()
+		other.Close()
+		return conn, nil
+	}
+	blacklist := newDatanodeBlacklist(time.Minute, 2)
+	blacklistedDial := blacklist.dial(dial)
+
+	// Failures that are not in a row do not add up
+	for i := 0; i < 3; i++ {
+		fail = true
+		if _, err := blacklistedDial(context.Background(), "tcp", "dn1:50010"); err == nil {
+			t.Fatal("expected the dial error")
+		}
+		fail = false
+		conn, err := blacklistedDial(context.Background(), "tcp", "dn1:50010")
+		if err != nil {
+			t.Fatalf("expected the datanode not to be blacklisted, got %v", err)
+		}
+		conn.Close()
+	}
+
+	if newDatanodeBlacklist(0, 2).dial(dial) == nil {
+		t.Fatal("expected the dial function itself without a TTL")
+	}
+}
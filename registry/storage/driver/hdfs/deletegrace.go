@@ -0,0 +1,97 @@
+package hdfs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// A garbage collector deleting a blob that a push needs again a moment
+// later makes the push see it missing and upload it again, or fail. With
+// deletegrace set, the driver remembers the paths it deleted for that long
+// and reports them with a RecentlyDeletedError rather than a
+// PathNotFoundError, so that callers can tell a path that was just deleted,
+// and may want to recreate it, from one that never existed. Only deletes
+// made through this instance are remembered, and writing a path again
+// forgets it.
+
+// RecentlyDeletedError is returned instead of a PathNotFoundError for a
+// path deleted, itself or a parent, less than deletegrace ago
+type RecentlyDeletedError struct {
+	Path      string
+	DeletedAt time.Time
+}
+
+func (e RecentlyDeletedError) Error() string {
+	return fmt.Sprintf("hdfs: %s was deleted at %s", e.Path, e.DeletedAt.Format(time.RFC3339))
+}
+
+// deleteGrace is the set of recently deleted paths
+type deleteGrace struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	deleted map[string]time.Time
+}
+
+// newDeleteGrace returns nil, which remembers nothing, when window is 0
+func newDeleteGrace(window time.Duration) *deleteGrace {
+	if window <= 0 {
+		return nil
+	}
+	return &deleteGrace{window: window, now: time.Now, deleted: make(map[string]time.Time)}
+}
+
+// record remembers that subPath was deleted, forgetting expired paths
+func (g *deleteGrace) record(subPath string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	for p, deletedAt := range g.deleted {
+		if now.Sub(deletedAt) >= g.window {
+			delete(g.deleted, p)
+		}
+	}
+	g.deleted[subPath] = now
+}
+
+// forget drops subPath and its parents before subPath is written
+func (g *deleteGrace) forget(subPath string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for p := range g.deleted {
+		if isBelow(subPath, p, true) {
+			delete(g.deleted, p)
+		}
+	}
+}
+
+// check replaces a PathNotFoundError in err with a RecentlyDeletedError
+// when subPath or a parent was deleted within the window
+func (g *deleteGrace) check(subPath string, err *error) {
+	if _, ok := (*err).(storagedriver.PathNotFoundError); g == nil || !ok {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var latest time.Time
+	for p, deletedAt := range g.deleted {
+		if isBelow(subPath, p, true) && deletedAt.After(latest) {
+			latest = deletedAt
+		}
+	}
+	if !latest.IsZero() && g.now().Sub(latest) < g.window {
+		*err = RecentlyDeletedError{Path: subPath, DeletedAt: latest}
+	}
+}
@@ -0,0 +1,78 @@
+package hdfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestRecentlyDeletedWithinGrace(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/data", []byte("blob"))
+	d := newTestDriverWithParameters(client, DriverParameters{DeleteGrace: 30 * time.Second})
+	now := time.Now()
+	d.recentlyDeleted.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if err := d.Delete(ctx, "/blobs"); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+	_, err := d.Stat(ctx, "/blobs/data")
+	if e, ok := err.(RecentlyDeletedError); !ok || e.Path != "/blobs/data" {
+		t.Fatalf("expected a RecentlyDeletedError within the window, got %v", err)
+	}
+	if category := ErrorCategoryOf(err); category != ErrorNotFound {
+		t.Fatalf("expected the not-found category, got %q", category)
+	}
+	if _, err := d.GetContent(ctx, "/blobs/data"); err == nil {
+		t.Fatal("expected GetContent to fail")
+	} else if _, ok := err.(RecentlyDeletedError); !ok {
+		t.Fatalf("expected a RecentlyDeletedError from GetContent, got %v", err)
+	}
+	if _, err := d.Stat(ctx, "/other"); err == nil {
+		t.Fatal("expected Stat to fail")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("expected paths never deleted to be not found, got %v", err)
+	}
+
+	// After the window the path is simply missing
+	now = now.Add(30 * time.Second)
+	if _, err := d.Stat(ctx, "/blobs/data"); err == nil {
+		t.Fatal("expected Stat to fail")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("expected PathNotFoundError after the window, got %v", err)
+	}
+}
+
+func TestRecentlyDeletedForgottenOnWrite(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/data", []byte("blob"))
+	d := newTestDriverWithParameters(client, DriverParameters{DeleteGrace: time.Minute})
+	ctx := context.Background()
+
+	if err := d.Delete(ctx, "/blobs/data"); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+	if err := d.PutContent(ctx, "/blobs/data", []byte("again")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if err := d.Delete(ctx, "/blobs/other"); err == nil {
+		t.Fatal("expected deleting a missing path to fail")
+	}
+	if err := d.Delete(ctx, "/blobs/data"); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+	if err := d.PutContent(ctx, "/blobs/data", []byte("again")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if err := d.Delete(ctx, "/blobs/data/missing"); err == nil {
+		t.Fatal("expected deleting a missing path to fail")
+	}
+	if _, err := d.Stat(ctx, "/blobs/data/missing"); err == nil {
+		t.Fatal("expected Stat to fail")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("expected the rewritten path to be forgotten, got %v", err)
+	}
+}
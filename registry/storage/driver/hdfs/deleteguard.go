@@ -0,0 +1,118 @@
+package hdfs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Delete removes whole trees, so a Delete of a high-level prefix wipes the
+// registry. The mindeletedepth and maxdeleteentries parameters guard
+// against that: a directory less than mindeletedepth components deep, such
+// as /docker/registry/v2 with 3, or holding more than maxdeleteentries
+// files and directories, is only deleted with ClientOptions.ForceDelete.
+// Files and missing paths are not guarded. The registry itself deletes
+// repositories, upload sessions and blob directories at least six
+// components deep, so 5 keeps its deletes working.
+
+// DeleteRefusedError is returned by Delete when a guard refuses to delete a
+// directory
+type DeleteRefusedError struct {
+	Path   string
+	Reason string
+}
+
+func (e DeleteRefusedError) Error() string {
+	return fmt.Sprintf("hdfs: refusing to delete %s: %s, set ClientOptions.ForceDelete to delete it anyway", e.Path, e.Reason)
+}
+
+// contentCounter is implemented by clients that can count the files and
+// directories below a path with getContentSummary. colinmarc/hdfs does.
+type contentCounter interface {
+	ContentCount(name string) (int64, error)
+}
+
+// contentCount counts the entries below name if c supports it
+func contentCount(c hdfsClient, name string) (int64, error) {
+	if s, ok := c.(contentCounter); ok {
+		return s.ContentCount(name)
+	}
+	return 0, errUnsupportedByClient
+}
+
+// ContentCount implements contentCounter. The summary counts name itself
+// among the directories, which is left out.
+func (c colinmarcClient) ContentCount(name string) (int64, error) {
+	summary, err := c.Client.GetContentSummary(name)
+	if err != nil {
+		return 0, err
+	}
+	return int64(summary.FileCount()+summary.DirectoryCount()) - 1, nil
+}
+
+// pathDepth is the number of components of subPath, 0 for the root
+func pathDepth(subPath string) int {
+	subPath = strings.Trim(path.Clean("/"+subPath), "/")
+	if subPath == "" {
+		return 0
+	}
+	return strings.Count(subPath, "/") + 1
+}
+
+// guardDelete returns a DeleteRefusedError when the guards keep subPath
+func (d *driver) guardDelete(subPath string) error {
+	if d.forceDelete || (d.minDeleteDepth <= 0 && d.maxDeleteEntries <= 0) {
+		return nil
+	}
+	fullPath := d.fullPath(subPath)
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if os.IsNotExist(err) || isUnresolvedLink(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return nil
+	}
+
+	if depth := pathDepth(subPath); depth < d.minDeleteDepth {
+		return DeleteRefusedError{Path: subPath, Reason: fmt.Sprintf("it is %d components deep, mindeletedepth is %d", depth, d.minDeleteDepth)}
+	}
+	if d.maxDeleteEntries > 0 {
+		entries, err := contentCount(d.hdfsClient, fullPath)
+		if err == errUnsupportedByClient {
+			entries, err = d.countEntries(fullPath, d.maxDeleteEntries+1)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if entries > d.maxDeleteEntries {
+			return DeleteRefusedError{Path: subPath, Reason: fmt.Sprintf("it holds more than maxdeleteentries %d entries", d.maxDeleteEntries)}
+		}
+	}
+	return nil
+}
+
+// countEntries counts the files and directories below dir, stopping once
+// it reaches limit
+func (d *driver) countEntries(dir string, limit int64) (int64, error) {
+	children, err := d.readDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	count := int64(len(children))
+	for _, child := range children {
+		if count >= limit {
+			break
+		}
+		if child.IsDir() {
+			below, err := d.countEntries(path.Join(dir, child.Name()), limit-count)
+			if err != nil && !os.IsNotExist(err) {
+				return 0, err
+			}
+			count += below
+		}
+	}
+	return count, nil
+}
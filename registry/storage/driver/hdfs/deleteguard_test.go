@@ -0,0 +1,65 @@
+package hdfs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestDeleteGuard(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		client func(*fakeClient) hdfsClient
+	}{
+		{"content summary", func(c *fakeClient) hdfsClient { return c }},
+		{"walk", func(c *fakeClient) hdfsClient { return basicClient{c} }},
+	} {
+		client := newFakeClient()
+		for i := 0; i < 5; i++ {
+			client.writeFile(fmt.Sprintf("/registry/docker/registry/v2/repositories/big/_layers/%d/link", i), []byte("link"))
+		}
+		client.writeFile("/registry/docker/registry/v2/repositories/small/_layers/a/link", []byte("link"))
+		d := newTestDriverWithParameters(tc.client(client), DriverParameters{MinDeleteDepth: 5, MaxDeleteEntries: 4})
+		ctx := context.Background()
+
+		// A high-level prefix is refused
+		err := d.Delete(ctx, "/docker/registry/v2/repositories")
+		if _, ok := err.(DeleteRefusedError); !ok {
+			t.Fatalf("%s: expected a DeleteRefusedError for a shallow delete, got %v", tc.name, err)
+		}
+		// So is a deep directory holding too much
+		err = d.Delete(ctx, "/docker/registry/v2/repositories/big/_layers")
+		if _, ok := err.(DeleteRefusedError); !ok {
+			t.Fatalf("%s: expected a DeleteRefusedError for a large delete, got %v", tc.name, err)
+		}
+		if _, err := client.Stat("/registry/docker/registry/v2/repositories/big/_layers/0/link"); err != nil {
+			t.Fatalf("%s: expected refused deletes to keep the files: %v", tc.name, err)
+		}
+
+		// Targeted deletes proceed
+		if err := d.Delete(ctx, "/docker/registry/v2/repositories/small/_layers"); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if err := d.Delete(ctx, "/docker/registry/v2/repositories/big/_layers/0/link"); err != nil {
+			t.Fatalf("%s: unexpected error deleting a file: %v", tc.name, err)
+		}
+
+		// And guarded ones with ForceDelete
+		forced := WithClientOptions(ctx, ClientOptions{ForceDelete: true})
+		if err := d.Delete(forced, "/docker/registry/v2/repositories"); err != nil {
+			t.Fatalf("%s: unexpected error from a forced delete: %v", tc.name, err)
+		}
+		if _, err := client.Stat("/registry/docker/registry/v2/repositories"); err == nil {
+			t.Fatalf("%s: expected the forced delete to remove the tree", tc.name)
+		}
+	}
+}
+
+func TestPathDepth(t *testing.T) {
+	for path, depth := range map[string]int{"/": 0, "": 0, "/docker": 1, "/docker/registry/v2/": 3, "/a/b/c/d/e": 5} {
+		if got := pathDepth(path); got != depth {
+			t.Errorf("expected the depth of %q to be %d, got %d", path, depth, got)
+		}
+	}
+}
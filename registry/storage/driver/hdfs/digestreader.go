@@ -0,0 +1,81 @@
+package hdfs
+
+import (
+	"errors"
+	"io"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+)
+
+// DigestReader is implemented by drivers that can hash a file while it is
+// read, such as the HDFS driver, so that a caller verifying a blob does not
+// have to read it twice:
+//
+//	if dr, ok := driver.(hdfs.DigestReader); ok {
+//		reader, err := dr.ReaderWithDigest(ctx, blobPath)
+//		...
+//		io.Copy(w, reader)
+//		if dgst, err := reader.Digest(); err == nil && dgst != expected {
+//			...
+//		}
+//	}
+type DigestReader interface {
+	// ReaderWithDigest returns a reader of the whole file at path, like
+	// Reader at offset 0, that hashes what is read with sha256
+	ReaderWithDigest(ctx context.Context, path string) (DigestReadCloser, error)
+}
+
+// DigestReadCloser is a reader of a file that reports the digest of its
+// content once read
+type DigestReadCloser interface {
+	io.ReadCloser
+
+	// Digest returns the sha256 digest of the file, or
+	// errDigestIncomplete until the file has been read to io.EOF
+	Digest() (digest.Digest, error)
+}
+
+// errDigestIncomplete is returned by Digest before the reader reached the
+// end of the file
+var errDigestIncomplete = errors.New("hdfs: the digest is only known once the file has been read to the end")
+
+// ReaderWithDigest implements DigestReader. The digest covers the content
+// as Reader returns it, decompressed and decrypted.
+func (d *Driver) ReaderWithDigest(ctx context.Context, path string) (DigestReadCloser, error) {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.ReaderWithDigest(%q)", d.Name(), path)
+
+	path, err := d.inner().checkPath(path, false)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := d.inner().Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &digestingReader{ReadCloser: reader, digester: digest.Canonical.New()}, nil
+}
+
+// digestingReader hashes what is read from ReadCloser
+type digestingReader struct {
+	io.ReadCloser
+	digester digest.Digester
+	eof      bool
+}
+
+func (r *digestingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.digester.Hash().Write(p[:n])
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *digestingReader) Digest() (digest.Digest, error) {
+	if !r.eof {
+		return "", errDigestIncomplete
+	}
+	return r.digester.Digest(), nil
+}
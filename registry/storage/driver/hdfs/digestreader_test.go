@@ -0,0 +1,40 @@
+package hdfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestReaderWithDigest(t *testing.T) {
+	client := newFakeClient()
+	contents := []byte(strings.Repeat("blob content ", 10000))
+	client.writeFile("/registry/blobs/data", contents)
+	d := wrap(newTestDriver(client))
+
+	reader, err := d.ReaderWithDigest(context.Background(), "/blobs/data")
+	if err != nil {
+		t.Fatalf("unexpected error from ReaderWithDigest: %v", err)
+	}
+	defer reader.Close()
+	if _, err := reader.Digest(); err != errDigestIncomplete {
+		t.Fatalf("expected no digest before reading, got %v", err)
+	}
+
+	read, err := ioutil.ReadAll(reader)
+	if err != nil || string(read) != string(contents) {
+		t.Fatalf("expected the file content, got %d bytes, %v", len(read), err)
+	}
+	sum := sha256.Sum256(contents)
+	if dgst, err := reader.Digest(); err != nil || dgst.String() != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected the sha256 of the content, got %s, %v", dgst, err)
+	}
+
+	if _, err := d.ReaderWithDigest(context.Background(), "/blobs/missing"); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
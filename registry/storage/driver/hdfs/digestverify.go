@@ -0,0 +1,114 @@
+package hdfs
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/docker/distribution/digest"
+)
+
+// The registry stores blobs under their digest, e.g.
+// /docker/registry/v2/blobs/sha256/ab/abcd.../data, and trusts what it finds
+// there to match. With verifydigest set the driver checks that content
+// written to such a path has the digest the path names, so that a write
+// routed to the wrong blob fails instead of corrupting it. PutContent
+// checks the content before writing anything. Writer hashes what is written
+// and checks it on Commit, reading the file back when the writer appended
+// to existing content; a mismatch removes the file rather than leaving the
+// wrong content in place. Blobs the registry moves into place after an
+// upload are verified by the registry itself and not checked again.
+
+// DigestMismatchError is returned by verifydigest for content written to a
+// blob path that does not match its digest
+type DigestMismatchError struct {
+	Path     string
+	Expected digest.Digest
+	Actual   digest.Digest
+}
+
+func (e DigestMismatchError) Error() string {
+	return fmt.Sprintf("hdfs: content written to %s has digest %s, not %s", e.Path, e.Actual, e.Expected)
+}
+
+// pathDigest returns the digest the blob data at subPath is stored under,
+// if subPath is the data of a blob and the digest is valid
+func pathDigest(subPath string) (digest.Digest, bool) {
+	if !isBlobData(subPath) {
+		return "", false
+	}
+	dir := path.Dir(subPath)
+	dgst := digest.NewDigestFromHex(path.Base(path.Dir(path.Dir(dir))), path.Base(dir))
+	if dgst.Validate() != nil {
+		return "", false
+	}
+	return dgst, true
+}
+
+// checkDigest returns a DigestMismatchError when verifydigest is set and
+// contents do not match the digest of the blob path subPath
+func (d *driver) checkDigest(subPath string, contents []byte) error {
+	if !d.verifyDigest {
+		return nil
+	}
+	expected, ok := pathDigest(subPath)
+	if !ok {
+		return nil
+	}
+	if actual := expected.Algorithm().FromBytes(contents); actual != expected {
+		return DigestMismatchError{Path: subPath, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// digestChecker returns the function Commit checks the content written by
+// w with, or nil when there is nothing to check. A writer starting after
+// existing content has its file read back, as only the new bytes go
+// through Write.
+func (d *driver) digestChecker(w *fileWriter, subPath, fullPath string) func() error {
+	if !d.verifyDigest {
+		return nil
+	}
+	expected, ok := pathDigest(subPath)
+	if !ok {
+		return nil
+	}
+	if w.startingFileSize == 0 {
+		w.digester = expected.Algorithm().New()
+	}
+
+	return func() error {
+		var actual digest.Digest
+		if w.digester != nil {
+			actual = w.digester.Digest()
+		} else {
+			if !w.isClosed {
+				w.isClosed = true
+				if err := w.closeHdfsWriter(); err != nil {
+					return err
+				}
+			}
+			reader, err := d.open(fullPath)
+			if err != nil {
+				return err
+			}
+			actual, err = expected.Algorithm().FromReader(reader)
+			reader.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if actual == expected {
+			return nil
+		}
+
+		// Never leave the wrong content under the digest
+		if !w.isClosed {
+			w.isClosed = true
+			w.closeHdfsWriter()
+		}
+		if err := d.hdfsClient.Remove(fullPath); err != nil {
+			return fmt.Errorf("%v, and removing it failed: %v", DigestMismatchError{Path: subPath, Expected: expected, Actual: actual}, err)
+		}
+		return DigestMismatchError{Path: subPath, Expected: expected, Actual: actual}
+	}
+}
@@ -0,0 +1,83 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+)
+
+// blobPath returns the registry path of the blob with content
+func blobPath(content string) string {
+	dgst := digest.FromBytes([]byte(content))
+	return "/docker/registry/v2/blobs/" + string(dgst.Algorithm()) + "/" + dgst.Hex()[:2] + "/" + dgst.Hex() + "/data"
+}
+
+func TestVerifyDigestRejectsMismatchedContent(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{VerifyDigest: true})
+	ctx := context.Background()
+	target := blobPath("expected content")
+
+	err := d.PutContent(ctx, target, []byte("other content"))
+	if e, ok := err.(DigestMismatchError); !ok || e.Expected != digest.FromBytes([]byte("expected content")) {
+		t.Fatalf("expected a DigestMismatchError from PutContent, got %v", err)
+	}
+	if client.callCount("Create")+client.callCount("CreateFile") != 0 {
+		t.Fatal("expected nothing to be written for mismatched content")
+	}
+
+	// Writer checks on Commit and removes what it wrote
+	writer, err := d.Writer(ctx, target, false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("other content"))
+	if err := writer.Commit(); err == nil {
+		t.Fatal("expected Commit to fail for mismatched content")
+	} else if _, ok := err.(DigestMismatchError); !ok {
+		t.Fatalf("expected a DigestMismatchError from Commit, got %v", err)
+	}
+	writer.Close()
+	if _, err := d.Stat(ctx, target); err == nil {
+		t.Fatal("expected the mismatched blob to be removed")
+	}
+
+	// Matching content and paths without a digest are written
+	if err := d.PutContent(ctx, target, []byte("expected content")); err != nil {
+		t.Fatalf("unexpected error writing matching content: %v", err)
+	}
+	if err := d.PutContent(ctx, "/docker/registry/v2/repositories/r/_layers/link", []byte("anything")); err != nil {
+		t.Fatalf("unexpected error writing a link: %v", err)
+	}
+}
+
+func TestVerifyDigestReadsBackAppendedBlobs(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{VerifyDigest: true})
+	ctx := context.Background()
+	target := blobPath("first half, second half")
+	client.writeFile("/registry"+target, []byte("first half, "))
+
+	writer, err := d.Writer(ctx, target, true)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("second half"))
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("unexpected error committing matching content: %v", err)
+	}
+	writer.Close()
+
+	writer, err = d.Writer(ctx, target, true)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte(" and more"))
+	if err := writer.Commit(); err == nil {
+		t.Fatal("expected Commit to fail once the blob no longer matches")
+	} else if _, ok := err.(DigestMismatchError); !ok {
+		t.Fatalf("expected a DigestMismatchError, got %v", err)
+	}
+	writer.Close()
+}
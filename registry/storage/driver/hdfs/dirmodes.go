@@ -0,0 +1,77 @@
+package hdfs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The directorymodes parameter gives the directories the driver creates a
+// mode by their depth instead of directoryumask for all of them, so that
+// the top of the tree can be more permissive than the repositories below
+// it. The first mode is that of the root directory, the second that of the
+// directories in it, and so on; deeper directories get directoryumask.
+//
+// MkdirAll creates every missing level with the same mode, so with
+// directorymodes the levels are created one at a time instead.
+
+// parseDirectoryModes parses the comma separated octal modes of
+// directorymodes
+func parseDirectoryModes(value string) ([]os.FileMode, error) {
+	var modes []os.FileMode
+	for _, item := range splitList(value) {
+		mode, err := strconv.ParseUint(item, 8, 32)
+		if err != nil || mode == 0 || mode > 0777 {
+			return nil, fmt.Errorf("The directorymodes parameter should list octal modes between 01 and 0777, %q invalid", item)
+		}
+		modes = append(modes, os.FileMode(mode))
+	}
+	return modes, nil
+}
+
+// directoryMode returns the mode the directory at fullPath is created with
+func (d *driver) directoryMode(fullPath string) os.FileMode {
+	if depth := d.directoryDepth(fullPath); depth >= 0 && depth < len(d.directoryModes) {
+		return d.directoryModes[depth]
+	}
+	return os.FileMode(d.directoryUmask)
+}
+
+// directoryDepth returns how far below the root directory fullPath is,
+// 0 for the root itself and -1 for paths outside of it
+func (d *driver) directoryDepth(fullPath string) int {
+	if fullPath == d.hdfsRootDirectory {
+		return 0
+	}
+	rel := strings.TrimPrefix(fullPath, strings.TrimSuffix(d.hdfsRootDirectory, "/")+"/")
+	if rel == fullPath {
+		return -1
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// mkdirLevels creates dir and its missing parents one level at a time, each
+// with the mode of its depth
+func (d *driver) mkdirLevels(dir string) error {
+	if d.directoryDepth(dir) <= 0 {
+		return d.hdfsClient.MkdirAll(dir, d.directoryMode(dir))
+	}
+
+	fi, err := d.hdfsClient.Stat(dir)
+	if err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: os.ErrExist}
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := d.mkdirLevels(path.Dir(dir)); err != nil {
+		return err
+	}
+	// The parent exists, so this creates only dir
+	return d.hdfsClient.MkdirAll(dir, d.directoryMode(dir))
+}
@@ -0,0 +1,57 @@
+package hdfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestDirectoryModes(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{DirectoryUmask: 0700, DirectoryModes: "0777,0775"})
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/repo/layers/file", []byte("contents")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for dir, want := range map[string]os.FileMode{
+		"/registry":             0777,
+		"/registry/repo":        0775,
+		"/registry/repo/layers": 0700,
+	} {
+		fi, err := client.Stat(dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if mode := fi.Mode().Perm(); mode != want {
+			t.Errorf("expected %s to have mode %#o, got %#o", dir, want, mode)
+		}
+	}
+
+	// Existing levels are left alone
+	if err := d.PutContent(ctx, "/repo/tags/file", []byte("contents")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi, _ := client.Stat("/registry/repo/tags"); fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected the new level to have mode 0700, got %#o", fi.Mode().Perm())
+	}
+
+	// A file in the way is not mistaken for a directory
+	client.writeFile("/registry/blocked", []byte("file"))
+	if err := d.mkdir("/registry/blocked/dir"); err == nil {
+		t.Fatal("expected creating a directory below a file to fail")
+	}
+}
+
+func TestParseDirectoryModes(t *testing.T) {
+	modes, err := parseDirectoryModes("0775, 755")
+	if err != nil || len(modes) != 2 || modes[0] != 0775 || modes[1] != 0755 {
+		t.Fatalf("unexpected modes %v, %v", modes, err)
+	}
+	for _, value := range []string{"0", "1000", "rwx"} {
+		if _, err := parseDirectoryModes(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
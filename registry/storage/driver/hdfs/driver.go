@@ -1,32 +1,57 @@
 package hdfs
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"net"
 	"os"
 	"path"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/colinmarc/hdfs"
 	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/base"
 	"github.com/docker/distribution/registry/storage/driver/factory"
-	"github.com/colinmarc/hdfs"
-	"strconv"
+	"golang.org/x/time/rate"
 )
 
 // Set the version
-const CurrentVersion Version = "0.1"
+const CurrentVersion storagedriver.Version = "0.1"
 
-// Default values for the driverParameters if not set by the user.
+// Version returns the revision of the driver, CurrentVersion, to tell
+// which one a registry is running
+func Version() string {
+	return string(CurrentVersion)
+}
+
+// Default values for the DriverParameters if not set by the user.
 const (
-	driverName			= "hdfs"
-	driverDisplayName		= "HDFS Storage Driver"
-	defaultHdfsRootDirectory	= "/tmp/hdfs-registry"
-	defaultHdfsNamenode		= ""
-	defaultHdfsUser			= "hdfs"
-	defaultDirectoryUmask		= 0755
+	driverName                = "hdfs"
+	driverDisplayName         = "HDFS Storage Driver"
+	defaultHdfsRootDirectory  = "/tmp/hdfs-registry"
+	defaultHdfsNamenode       = ""
+	defaultHdfsUser           = "hdfs"
+	defaultDirectoryUmask     = 0755
+	defaultWebHdfsAddress     = ""
+	defaultURLForExpiry       = 20 * time.Minute
+	defaultTransferBufferSize = 64 << 10
+	minTransferBufferSize     = 4 << 10
+	maxTransferBufferSize     = 16 << 20
+	defaultMaxPutContentSize  = 4 << 20
+	defaultBlockSize          = 128 << 20
+	defaultFileMode           = 0644
+	defaultDeleteConcurrency  = 8
+	defaultStatConcurrency    = 8
 )
 
 //
@@ -34,20 +59,383 @@ const (
 // user input.
 //
 
-// driverParameters is a struct that encapsulates all of the driver parameters after all values have been set
-type driverParameters struct {
-	hdfsRootDirectory string
-	hdfsNameNode string
-	hdfsUser string
-	directoryUmask int
+// DriverParameters is a struct that encapsulates all of the driver parameters after all values have been set.
+// Its fields correspond to the FromParameters parameters of the same names, embedders
+// start from DefaultParameters and pass the result to NewFromConfig.
+type DriverParameters struct {
+	HdfsRootDirectory  string
+	HdfsNameNode       string
+	HdfsUser           string
+	DirectoryUmask     int
+	WebHdfsAddress     string
+	WebHdfsPort        int64
+	WebHdfsTLS         bool
+	TransferBufferSize int64
+	UseHadoopEnv       bool
+	MaxPutContentSize  int64
+	PathTransform      string
+	PathDepth          int64
+	Compression        string
+	ReadBandwidth      int64
+	WriteBandwidth     int64
+	MaxOpsPerSecond    int64
+	MaxOpsMode         string
+	FixPermissions     bool
+	BestEffortDelete   bool
+	Replication        int64
+	StagingReplication int64
+	ListSort           string
+	DeleteConcurrency  int64
+	StoragePolicy      string
+	Snapshot           string
+	VerifyWrites       bool
+	AppendFallback     bool
+	UploadStateDir     string
+	DialTimeout        time.Duration
+	KmsURI             string
+	ListRetries        int64
+	ListRetryDelay     time.Duration
+	NamenodePorts      []string
+	BreakerThreshold   int64
+	BreakerCooldown    time.Duration
+	StagingStrategy    string
+	StagingThreshold   int64
+	ReadAhead          int64
+	ClaimRoot          bool
+	InstanceID         string
+	NoClobber          bool
+	StatConcurrency    int64
+	CreateParents      bool
+	RecoverPanics      bool
+	RepositoryQuota    int64
+	delegationToken    string
+	MinFreeBytes       int64
+	ReadFromObserver   bool
+	ObserverNameNode   string
+	LeaseTimeout       time.Duration
+	ReadLogLevel       string
+	ReadLogSampling    int64
+
+	// PathMapper, when set, replaces pathtransform. It can only be set
+	// through NewFromConfig, a parameters map selects mappers registered
+	// with RegisterPathMapper by name instead.
+	PathMapper PathMapper
+
+	HotStoragePolicy  string
+	ColdStoragePolicy string
+	HotPrefixes       string
+	ColdAfter         time.Duration
+
+	ReadRetries int64
+	ListExclude string
+
+	PreserveModTime bool
+
+	ContentCacheSize int64
+	ContentCacheDirs string
+
+	FaultInjection          string
+	FaultInjectionErrorRate int64
+	FaultInjectionDelay     time.Duration
+
+	WritePipelineRecovery string
+
+	TLSCAFile string
+
+	ParallelReadThreshold int64
+	ParallelReads         int64
+
+	WebHdfsReads bool
+
+	MaxConcurrentUploads     int64
+	MaxConcurrentUploadsMode string
+
+	FavoredNodes string
+
+	Symlinks string
+
+	FlushInterval time.Duration
+
+	DFSPacketSize int64
+
+	RetryBudget time.Duration
+
+	ProxyURL string
+
+	LazyConnect bool
+
+	LockDirectory string
+
+	LocalCacheDir  string
+	LocalCacheSize int64
+
+	DirectoryModes string
+
+	CloseTimeout time.Duration
+
+	PruneEmpty bool
+
+	CommitStatTimeout time.Duration
+
+	SlowOpLog time.Duration
+
+	AuditLog string
+
+	MinDeleteDepth   int64
+	MaxDeleteEntries int64
+
+	// OnReconnect, when set, is called after every reconnect to the
+	// namenode, e.g. to alert on an unstable cluster. It has no
+	// FromParameters equivalent.
+	OnReconnect func(ReconnectEvent) `json:"-"`
+
+	// OnChange, when set, is told about every file written, moved or
+	// deleted, e.g. to feed an external index, until Driver.Close. It has
+	// no FromParameters equivalent.
+	OnChange func(ChangeEvent) `json:"-"`
+
+	MaintenanceRetries    int64
+	MaintenanceRetryDelay time.Duration
+
+	NormalizePaths bool
+
+	SocketTimeout time.Duration
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+
+	ClassifyErrors bool
+
+	PackPrefixes string
+	PackMaxSize  int64
+
+	DeleteGrace time.Duration
+
+	HealthRouting       bool
+	HealthProbeInterval time.Duration
+
+	VerifyDigest bool
+
+	MirrorNameNode string
+	MirrorMode     string
+	MirrorFailure  string
+
+	DatanodeBlacklistTTL       time.Duration
+	DatanodeBlacklistThreshold int64
+
+	LongPathStrategy       string
+	MaxPathComponentLength int64
 }
 
 type driver struct {
-	hdfsRootDirectory string
-	hdfsNameNode string
-	hdfsUser string
-	directoryUmask int
-	hdfsClient *hdfs.Client
+	hdfsRootDirectory  string
+	hdfsNameNode       string
+	hdfsUser           string
+	directoryUmask     int
+	directoryModes     []os.FileMode
+	hdfsClient         hdfsClient
+	webHdfs            *webHdfsClient
+	kms                *kmsClient
+	listRetries        int
+	listRetryDelay     time.Duration
+	bufferPool         *bufferPool
+	maxPutContentSize  int64
+	pathTransform      pathTransform
+	compression        codec
+	readsCompressed    bool
+	bestEffortDelete   bool
+	replication        int
+	stagingReplication int
+	listSort           string
+	deleteConcurrency  int
+	storagePolicy      string
+	snapshot           string
+	verifyWrites       bool
+	appendFallback     bool
+	readLimiter        *rate.Limiter
+	writeLimiter       *rate.Limiter
+	writes             *writeBreaker
+	stagingStrategy    string
+	stagingThreshold   int64
+	readAheadSize      int
+	noClobber          bool
+	statConcurrency    int
+	createParents      bool
+	recoverPanics      bool
+
+	// leaseRecoveryTimeout bounds how long Append waits for the lease of
+	// a previous writer to recover, polling every leaseRecoveryInterval
+	leaseRecoveryTimeout  time.Duration
+	leaseRecoveryInterval time.Duration
+
+	// closeTimeout bounds how long closing a written file may take
+	closeTimeout time.Duration
+
+	// commitStatTimeout bounds how long Commit waits for Stat to report
+	// the size written, see awaitSize
+	commitStatTimeout time.Duration
+
+	// delegationToken is the token of HADOOP_TOKEN_FILE_LOCATION, which
+	// authenticates the WebHDFS operations of the driver; it is never put
+	// in the URLs URLFor hands out
+	delegationToken string
+
+	// params are the parameters the driver was created with, for
+	// EffectiveConfig
+	params DriverParameters
+
+	// listExclude are the name prefixes of temporary files List hides
+	// unless listTemporaryFiles is set
+	listExclude        []string
+	listTemporaryFiles bool
+
+	// nonRecursiveDelete makes Delete keep directories with entries, see
+	// ClientOptions.Recursive
+	nonRecursiveDelete bool
+
+	// pruneEmpty makes Delete remove the parents it leaves empty
+	pruneEmpty bool
+
+	// faults injects errors and latency when set, see faultinject.go
+	faults *faultInjector
+
+	// contentCache caches immutable objects when set. It is shared with
+	// the copies made by withOptions.
+	contentCache *contentCache
+
+	// localCache caches immutable objects on local disk when set, see
+	// localcachedirectory. It is shared like contentCache.
+	localCache *localCache
+
+	// preserveModTime keeps the modification time of files written to
+	// again, see keepModTime
+	preserveModTime bool
+
+	// readRetries is the budget of failed reads every Reader retries
+	readRetries int
+
+	// maintenanceRetries is the budget of reads failed by datanodes in
+	// maintenance every Reader retries, maintenanceRetryDelay apart
+	maintenanceRetries    int
+	maintenanceRetryDelay time.Duration
+
+	// normalizePaths makes Driver collapse slashes in the paths it is given
+	normalizePaths bool
+
+	// classifyErrors tags the errors of the StorageDriver methods with
+	// their ErrorCategory
+	classifyErrors bool
+
+	// packs holds the objects PutContent packs under packprefixes
+	packs *packs
+
+	// recentlyDeleted remembers deleted paths for deletegrace
+	recentlyDeleted *deleteGrace
+
+	// verifyDigest checks content written to blob paths against their
+	// digest
+	verifyDigest bool
+
+	// longPathStrategy and maxPathComponentLength handle paths over the
+	// name length limits of the namenode
+	longPathStrategy       string
+	maxPathComponentLength int
+
+	// changes reports writes, moves and deletes to OnChange
+	changes *changeNotifier
+
+	// closer closes the client New dialed, shared by the copies of the
+	// driver withOptions makes
+	closer *driverCloser
+
+	// parallelReadThreshold is the size from which GetContent reads
+	// parallelReadBlock sized blocks with up to parallelReads readers, see
+	// readParallel
+	parallelReadThreshold int64
+	parallelReadBlock     int64
+	parallelReads         int
+
+	// webHdfsReads reads files through webHdfs, see webHdfsFile
+	webHdfsReads bool
+
+	// pipelineRecovery is the writepipelinerecovery policy PutContent
+	// applies when a datanode fails, see rewritesAfter
+	pipelineRecovery string
+
+	// tiers picks the storage policy of files when set
+	tiers *storageTiers
+
+	// readLog logs reads. It is shared with the copies made by
+	// withOptions.
+	readLog *readLog
+
+	// slowOps logs operations slower than slowoplog when set
+	slowOps *slowOpLog
+
+	// audit records writes, moves and deletes in auditlog when set
+	audit *auditLog
+
+	// minDeleteDepth and maxDeleteEntries guard Delete against removing
+	// large trees, unless forceDelete is set, see guardDelete
+	minDeleteDepth   int
+	maxDeleteEntries int64
+	forceDelete      bool
+
+	// instanceTag identifies this registry in temporary file names
+	instanceTag string
+
+	// freeSpace enforces minfreebytes when set. It is shared with the
+	// copies made by withOptions.
+	freeSpace *freeSpaceCheck
+
+	// uploads enforces maxconcurrentuploads when set. It is shared with
+	// the copies made by withOptions.
+	uploads *uploadLimiter
+
+	// favoredNodes are passed to the namenode when creating files, see
+	// favoredNodesCreator. favoredNodesUnsupported logs once that the
+	// client cannot pass them.
+	favoredNodes            []string
+	favoredNodesUnsupported *sync.Once
+
+	// followSymlinks reads through symbolic links, see symlink.go
+	followSymlinks bool
+
+	// flushInterval is how often open FileWriters are flushed, see
+	// flushingWriter
+	flushInterval time.Duration
+
+	// retryBudget bounds the retries of one operation, see
+	// operationBudget. budget is the budget of the operation a copy of the
+	// driver performs.
+	retryBudget time.Duration
+	budget      *operationBudget
+
+	// lockDirectory holds the lock files of Locker
+	lockDirectory string
+
+	// quota enforces repositoryquota when set. It is shared with the
+	// copies made by withOptions.
+	quota *repositoryQuota
+
+	// uploadStateDirectory keeps upload session metadata out of the blob
+	// tree when set
+	uploadStateDirectory string
+
+	// storagePolicyDisabled is set once the cluster refused storagePolicy.
+	// It is shared with the copies made by withOptions.
+	storagePolicyDisabled *int32
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation backed by HDFS.
+// All provided paths will be subpaths of the hdfsrootdirectory. Besides the
+// storagedriver.StorageDriver methods it implements the optional interfaces
+// of this package, such as BulkDeleter.
+type Driver struct {
+	baseEmbed
 }
 
 // hdfsDriverFactory implements the factory.StorageDriverFactory interface
@@ -67,15 +455,239 @@ func (factory *hdfsDriverFactory) Create(parameters map[string]interface{}) (sto
 // Optional Parameters:
 // - hdfsrootdirectory
 // - hdfsuser
-// - directoryumask
+// - directoryumask (mode of new directories, between 01 and 0777, default 0755)
+// - hdfswebhdfsaddr (enables URLFor redirects through WebHDFS)
+// - webhdfsport (enables WebHDFS on the namenode host at this port, default 9870)
+// - webhdfstls (use https for WebHDFS, default port 9871)
+// - transferbuffersize (size in bytes of the pooled copy buffers)
+// - usehadoopenv (load unset parameters from HADOOP_CONF_DIR/HADOOP_HOME)
+// - maxputcontentsize (largest PutContent in bytes, 0 disables the limit)
+// - pathtransform (none, digestprefix to shard digests into directories, or a name passed to RegisterPathMapper)
+// - pathdepth (directory levels digestprefix shards digests into, default 1; changing it needs a Migrate)
+// - compression (none or gzip, applied to PutContent objects at rest; needs WebHDFS, none still reads compressed objects)
+// - readbandwidth (bytes per second read from HDFS, 0 for unlimited)
+// - writebandwidth (bytes per second written to HDFS, 0 for unlimited)
+// - maxopspersecond (namenode operations per second, 0 for unlimited)
+// - maxopsmode (block to wait for maxopspersecond or error to fail fast)
+// - fixpermissions (chmod the root and registry directories to directoryumask on startup)
+// - besteffortdelete (deleting a path that is already gone succeeds)
+// - replication (replication factor of new files, 0 for the cluster default)
+// - stagingreplication (replication factor of in-progress uploads, 0 to use replication)
+// - listsort (order of List results: name, modtime or none, default name)
+// - deleteconcurrency (parallel Removes issued by DeleteFiles, default 8)
+// - storagepolicy (HDFS storage policy of the root directory and new files, e.g. COLD; needs WebHDFS)
+// - snapshot (serve reads from this snapshot of the root directory, see WithSnapshot)
+// - verifywrites (Commit closes the file and checks its size on the namenode)
+// - appendfallback (rewrite files to resume uploads on clusters without append)
+// - uploadstatedirectory (absolute directory outside the root for upload session metadata)
+// - dialtimeout (how long connecting to a namenode or datanode may take, e.g. 10s)
+// - kmsuri (Hadoop KMS to decrypt files read from encryption zones, e.g. kms://http@kms:9600/kms, may come from usehadoopenv; writes to zones are refused)
+// - listretries (times List rereads an existing directory that comes back empty, default 0)
+// - listretrydelay (pause between those rereads, default 100ms)
+// - namenodeports (ports tried in order for hdfsnamenode entries without one, default 8020)
+// - writebreakerthreshold (consecutive write failures that suspend writes, default 0 for never)
+// - writebreakercooldown (how long writes stay suspended before one is let through, default 1m)
+// - stagingstrategy (how PutContent writes: memory, tempfile to rename complete objects into place, or auto)
+// - stagingthreshold (smallest PutContent auto stages in a temporary file, default 1MiB)
+// - readahead (bytes Reader prefetches in the background, default 0 for none)
+// - claimroot (refuse to start on a root directory another instanceid claimed, claiming it otherwise)
+// - instanceid (identifies this registry for claimroot)
+// - noclobber (refuse to overwrite existing blobs, failing with a NoClobberError)
+// - statconcurrency (parallel Stats issued by StatMany, default 8)
+// - createparents (create missing parents in the create RPC where the client can, their mode is then the namenode's)
+// - recoverpanics (return panics in driver methods as errors rather than crashing, default true)
+// - repositoryquota (bytes of layers and uploads each repository may hold, default 0 for no limit)
+// - minfreebytes (refuse writes while the filesystem has fewer raw bytes remaining, default 0 for no limit)
+// - readfromobserver (read blobs from the observernamenode, everything else goes to hdfsnamenode)
+// - observernamenode (comma separated observer namenodes for readfromobserver)
+// - leaserecoverytimeout (how long resuming an upload waits for the lease of a crashed writer, default 1m, 0 to fail at once)
+// - readloglevel (debug or info, the level successful GetContent, Reader, Stat and List calls log at, default debug; failures log as errors)
+// - readlogsampling (log only one in this many successful reads, default 1)
+// - hotstoragepolicy (storage policy of hot files, e.g. ALL_SSD, see Rebalance; needs WebHDFS)
+// - coldstoragepolicy (storage policy of all other files, e.g. COLD; needs WebHDFS)
+// - hotprefixes (comma separated paths whose files are hot, e.g. frequently pulled base image repositories)
+// - coldafter (files modified less than this long ago are hot as well, e.g. 720h, default 0 for prefixes only)
+// - readretries (times a Reader reopens a file to continue a failed read, default 2)
+// - listexclude (comma separated name prefixes List leaves out besides the driver's staging files, e.g. _temporary)
+// - preservemtime (appending to a file or rewriting it for appendfallback keeps its modification time)
+// - contentcachesize (bytes of GetContent results from contentcacheprefixes kept in memory, default 0 for none)
+// - contentcacheprefixes (comma separated paths of immutable objects, default /docker/registry/v2/blobs)
+// - faultinjection (comma separated operations, or all, to inject faults into; needs the include_faultinjection build tag)
+// - faultinjectionerrorrate (percentage of those operations that fail, default 0)
+// - faultinjectiondelay (latency added to those operations, default 0)
+// - writepipelinerecovery (NEVER, DEFAULT or ALWAYS, whether PutContent writes again after a datanode failed, default NEVER)
+// - tlscafile (PEM bundle of the CAs trusted for WebHDFS and KMS over HTTPS, default the system roots)
+// - parallelreadthreshold (size in bytes from which GetContent reads blocks in parallel, default 0 for never)
+// - parallelreads (blocks GetContent reads at a time above parallelreadthreshold, default 4)
+// - webhdfsreads (read files through WebHDFS rather than from the datanodes directly, retrying other datanodes)
+// - maxconcurrentuploads (the number of files written at once, 0 for no limit)
+// - maxconcurrentuploadsmode (block to wait for an upload slot or error to fail fast)
+// - favorednodes (comma separated host:port of datanodes to place new files on where the namenode can; needs WebHDFS)
+// - symlinks (follow to read through symbolic links, nofollow to stat links themselves and refuse reading them)
+// - flushinterval (how often open writers flush their bytes to the datanodes and renew their lease, such as 30s)
+// - dfspacketsize (the packet size in bytes of WebHDFS reads, 0 for the cluster default)
+// - retrybudget (the total time one operation may spend waiting to retry, such as 30s, 0 for no limit)
+// - proxyurl (socks5://host:port or http://host:port of a proxy to reach the namenode and datanodes through)
+// - lazyconnect (start without the namenode and connect on first use, failing operations until it is reachable)
+// - lockdirectory (absolute HDFS path of the locks replicas coordinate with, default .registry-locks in the root directory)
+// - localcachedirectory (local directory to keep copies of what is read from contentcachedirs in)
+// - localcachesize (bytes of localcachedirectory to use, 0 to disable)
+// - directorymodes (comma separated octal modes of new directories by depth, starting at rootdirectory, such as 0775,0775,0755)
+// - closetimeout (how long closing a written file may take before failing, default 2m, 0 to wait forever)
+// - pruneempty (remove the directories Delete leaves empty, up to the registry directories)
+// - commitstattimeout (how long Commit waits for Stat to report the size written, default 10s, 0 not to wait)
+// - slowoplog (log operations taking at least this long as warnings, such as 5s, 0 to disable)
+// - auditlog (absolute HDFS path of a file to append a JSON line to for every write, move and delete)
+// - mindeletedepth (directories Delete refuses unless forced when fewer components deep, e.g. 5)
+// - maxdeleteentries (directories Delete refuses unless forced when holding more files and directories)
+// - maintenanceretries (reads failed by datanodes in maintenance that every Reader retries from another replica, default 3)
+// - maintenanceretrydelay (how long to wait before each of those retries, default 2s)
+// - normalizepaths (collapse repeated, leading and trailing slashes in paths instead of rejecting them, default false)
+// - sockettimeout (how long datanode connections may wait on a read or write, default none or dfs.client.socket-timeout with usehadoopenv)
+// - readtimeout (sockettimeout for reads from datanodes, including write acknowledgements)
+// - writetimeout (sockettimeout for writes to datanodes)
+// - classifyerrors (return errors from HDFS as a ClassifiedError with their ErrorCategory, default false)
+// - packprefixes (comma separated paths whose small PutContent objects are appended to one pack file per prefix)
+// - packmaxsize (largest PutContent object packed under packprefixes, default 4096)
+// - deletegrace (how long paths deleted by this instance are reported with a RecentlyDeletedError, default none)
+// - healthrouting (probe the namenodes and connect to the healthiest one, default false)
+// - healthprobeinterval (how often healthrouting probes the namenodes, default 10s)
+// - verifydigest (check that content written to a blob has the digest of its path, default false)
+// - mirrornamenode (namenode of a second cluster that every change is replicated to, comma separated for HA)
+// - mirrormode (sync to write both clusters at once or async to replay changes on the second in the background, default async)
+// - mirrorfailure (ignore or, with mirrormode sync, fail operations that the second cluster fails, default ignore)
+// - datanodeblacklistttl (how long a datanode that failed connections is avoided, default none)
+// - datanodeblacklistthreshold (failed connections in a row that blacklist a datanode, default 2)
+// - longpathstrategy (none, error or shorten for paths over the HDFS name length limits, default none)
+// - maxpathcomponentlength (the dfs.namenode.fs-limits.max-component-length of the cluster, default 255)
 // Required Parameters:
-// - hdfsnamenode
+// - hdfsnamenode (comma separated for HA, may come from usehadoopenv)
 func FromParameters(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	params, err := parseParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewFromConfig(params)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DefaultParameters returns the parameters FromParameters uses for an empty map
+func DefaultParameters() DriverParameters {
+	params, _ := parseParameters(nil)
+	return params
+}
+
+// parseParameters reads the parameters map into DriverParameters without
+// validating the result
+func parseParameters(parameters map[string]interface{}) (DriverParameters, error) {
 	// Load the defaults
 	var hdfsRootDirectory = defaultHdfsRootDirectory
 	var hdfsNamenode = defaultHdfsNamenode
-	var hdfsUser = defaultHdfsUser
+	var hdfsUser = ""
 	var directoryUmask = defaultDirectoryUmask
+	var webHdfsAddress = defaultWebHdfsAddress
+	var webHdfsPort int64
+	var webHdfsTLS = false
+	var transferBufferSize int64 = defaultTransferBufferSize
+	var useHadoopEnv = false
+	var maxPutContentSize int64 = defaultMaxPutContentSize
+	var pathTransformName = ""
+	var pathDepth int64
+	var compression = ""
+	var readBandwidth int64
+	var writeBandwidth int64
+	var maxOpsPerSecond int64
+	var maxOpsMode = "block"
+	var fixPermissions = false
+	var bestEffortDelete = false
+	var replication int64
+	var stagingReplication int64
+	var listSort = listSortName
+	var deleteConcurrency int64 = defaultDeleteConcurrency
+	var storagePolicy = ""
+	var snapshot = ""
+	var verifyWrites = false
+	var appendFallback = false
+	var uploadStateDirectory = ""
+	var dialTimeout time.Duration
+	var kmsURI = ""
+	var listRetries int64
+	var listRetryDelay time.Duration
+	var namenodePorts []string
+	var writeBreakerThreshold int64
+	var writeBreakerCooldown time.Duration
+	var stagingStrategy = stagingMemory
+	var stagingThreshold int64 = defaultStagingThreshold
+	var readAhead int64
+	var claimRoot = false
+	var instanceID = ""
+	var noClobber = false
+	var statConcurrency int64 = defaultStatConcurrency
+	var createParents = false
+	var recoverPanics = true
+	var repositoryQuota int64
+	var minFreeBytes int64
+	var readFromObserver = false
+	var observerNameNode = ""
+	var leaseRecoveryTimeout = defaultLeaseRecoveryTimeout
+	var readLogLevel = readLogDebug
+	var readLogSampling int64 = 1
+	var hotStoragePolicy = ""
+	var coldStoragePolicy = ""
+	var hotPrefixes = ""
+	var coldAfter time.Duration
+	var readRetries int64 = defaultReadRetries
+	var listExclude = ""
+	var preserveModTime = false
+	var contentCacheSize int64
+	var contentCachePrefixes = defaultContentCachePrefixes
+	var faultInjection = ""
+	var faultInjectionErrorRate int64
+	var faultInjectionDelay time.Duration
+	var writePipelineRecovery = ""
+	var tlsCAFile = ""
+	var parallelReadThreshold int64
+	var parallelReads int64 = defaultParallelReads
+	var webHdfsReads = false
+	var maxConcurrentUploads int64
+	var maxConcurrentUploadsMode = "block"
+	var favoredNodes = ""
+	var symlinks = "follow"
+	var flushInterval time.Duration
+	var dfsPacketSize int64
+	var retryBudget time.Duration
+	var proxyURL string
+	var lazyConnect bool
+	var lockDirectory string
+	var localCacheDir string
+	var localCacheSize int64
+	var directoryModes = ""
+	var closeTimeout = defaultCloseTimeout
+	var pruneEmpty = false
+	var commitStatTimeout = defaultCommitStatTimeout
+	var slowOpLog time.Duration
+	var auditLogPath = ""
+	var minDeleteDepth int64
+	var maxDeleteEntries int64
+	var maintenanceRetries int64 = defaultMaintenanceRetries
+	var maintenanceRetryDelay = defaultMaintenanceRetryDelay
+	var normalizePaths bool
+	var socketTimeout, readTimeout, writeTimeout time.Duration
+	var classifyErrors bool
+	var packPrefixes string
+	var packMaxSize int64 = defaultPackMaxSize
+	var deleteGrace time.Duration
+	var healthRouting bool
+	var healthProbeInterval = defaultHealthProbeInterval
+	var verifyDigest bool
+	var mirrorNameNode, mirrorMode, mirrorFailure string
+	var datanodeBlacklistTTL time.Duration
+	var datanodeBlacklistThreshold int64 = defaultDatanodeBlacklistThreshold
+	var longPathStrategy string
+	var maxPathComponentLength int64 = defaultMaxPathComponentLength
 
 	// Validate input
 	if parameters != nil {
@@ -98,233 +710,2264 @@ func FromParameters(parameters map[string]interface{}) (storagedriver.StorageDri
 		}
 
 		// Get directoryUmask
-		dUmask, ok := parameters["directoryumask"]
+		umask, err := getParameterAsInt64(parameters, "directoryumask", defaultDirectoryUmask, 1, 0777)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+		directoryUmask = int(umask)
+
+		// Get webHdfsAddress
+		webHdfsAddr, ok := parameters["hdfswebhdfsaddr"]
 		if ok {
-			directoryUmask = dUmask.(int)
+			webHdfsAddress = fmt.Sprint(webHdfsAddr)
 		}
-	}
 
-	// Populate params
-	params := driverParameters{
-		hdfsRootDirectory:	hdfsRootDirectory,
-		hdfsNameNode:		hdfsNamenode,
-		hdfsUser:		hdfsUser,
-		directoryUmask:		directoryUmask,
-	}
+		// Get webHdfsPort
+		webHdfsPort, err = getParameterAsInt64(parameters, "webhdfsport", 0, 0, 65535)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-	return New(params)
-}
+		// Get webHdfsTLS
+		webHdfsTLS, err = getParameterAsBool(parameters, "webhdfstls", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-// New constructs a new driver
-func New(params driverParameters) (storagedriver.StorageDriver, error) {
+		// Get transferBufferSize
+		transferBufferSize, err = getParameterAsInt64(parameters, "transferbuffersize", defaultTransferBufferSize, minTransferBufferSize, maxTransferBufferSize)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-	// Setup the connection to hdfs
-	client, err := hdfs.NewForUser(params.hdfsNameNode, params.hdfsUser)
-	if err != nil {
-		log.Fatal(err)
-	}
+		// Get useHadoopEnv
+		useHadoopEnv, err = getParameterAsBool(parameters, "usehadoopenv", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-	// Populate the driver
-	d := &driver{
-		hdfsRootDirectory:	params.hdfsRootDirectory,
-		hdfsNameNode:		params.hdfsNameNode,
-		hdfsUser:		params.hdfsUser,
-		directoryUmask:		params.directoryUmask,
-		hdfsClient:		client,
-	}
+		// Get maxPutContentSize
+		maxPutContentSize, err = getParameterAsInt64(parameters, "maxputcontentsize", defaultMaxPutContentSize, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-	// Return the StorageDriver
-	return &base.Base{
-		StorageDriver: d,
-	}, nil
-}
+		// Get pathTransform
+		transform, ok := parameters["pathtransform"]
+		if ok {
+			pathTransformName = fmt.Sprint(transform)
+		}
 
-//
-// Implement the storagedriver.StorageDriver interface
-//
+		// Get pathDepth
+		pathDepth, err = getParameterAsInt64(parameters, "pathdepth", 0, 0, maxPathDepth)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-// Return the display name
-func (d *driver) Name() string {
-	return driverDisplayName
-}
+		// Get compression
+		codecName, ok := parameters["compression"]
+		if ok {
+			compression = fmt.Sprint(codecName)
+		}
 
-// GetContent retrieves the content stored at "path" as a []byte.
-// This should primarily be used for small objects.
-func (d *driver) GetContent(context context.Context, path string) ([]byte, error) {
-	fullPath := d.fullPath(path)
-	p, err := d.hdfsClient.ReadFile(fullPath)
-	if err != nil {
-		return nil, storagedriver.PathNotFoundError{Path: d.fullPath(path)}
-	}
-	return p, nil
-}
+		// Get readBandwidth
+		readBandwidth, err = getParameterAsInt64(parameters, "readbandwidth", 0, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-// PutContent stores the []byte content at a location designated by "path".
-// This should primarily be used for small objects.
-func (d *driver) PutContent(context context.Context, path string, contents[]byte) error {
-	fullPath := d.fullPath(path)
-	d.makeParentDir(fullPath)
+		// Get writeBandwidth
+		writeBandwidth, err = getParameterAsInt64(parameters, "writebandwidth", 0, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-	// Get the FileWriter
-	writer, err := d.Writer(context, fullPath, false)
-	if err != nil {
-		log.Print(err)
-	}
+		// Get maxOpsPerSecond
+		maxOpsPerSecond, err = getParameterAsInt64(parameters, "maxopspersecond", 0, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-	// Write the contents
-	_, err = writer.Write(contents)
-	if err != nil {
-		log.Print(err)
-	}
-	writer.Close()
-	return err
-}
+		// Get maxOpsMode
+		opsMode, ok := parameters["maxopsmode"]
+		if ok {
+			maxOpsMode = fmt.Sprint(opsMode)
+		}
 
-// Reader retrieves an io.ReadCloser for the content stored at "path"
-// with a given byte offset.
-// May be used to resume reading a stream by providing a nonzero offset.
-func (d *driver) Reader(context context.Context, path string, offset int64) (io.ReadCloser, error) {
-	fullPath := d.fullPath(path)
+		// Get fixPermissions
+		fixPermissions, err = getParameterAsBool(parameters, "fixpermissions", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-	// Open the file
-	reader, err := d.hdfsClient.Open(fullPath)
-	if(err != nil) {
-		log.Print(err)
-	}
+		// Get bestEffortDelete
+		bestEffortDelete, err = getParameterAsBool(parameters, "besteffortdelete", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-	// Seek to the supplied offset
-	seekPos, err := reader.Seek(int64(offset), os.SEEK_SET)
-	if err != nil {
-		reader.Close()
-		return nil, err
-	} else if seekPos < int64(offset) {
-		reader.Close()
-		return nil, storagedriver.InvalidOffsetError{Path: fullPath, Offset: offset}
-	}
+		// Get replication
+		replication, err = getParameterAsInt64(parameters, "replication", 0, 0, math.MaxInt16)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-	return reader, nil
-}
+		// Get stagingReplication
+		stagingReplication, err = getParameterAsInt64(parameters, "stagingreplication", 0, 0, math.MaxInt16)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-// Writer returns a FileWriter which will store the content written to it
-// at the location designated by "path" after the call to Commit.
-func (d *driver) Writer(context context.Context, path string, append bool) (storagedriver.FileWriter, error) {
-	fullPath := d.fullPath(path)
-	d.makeParentDir(fullPath)
+		// Get listSort
+		sortOrder, ok := parameters["listsort"]
+		if ok {
+			listSort = fmt.Sprint(sortOrder)
+		}
 
-	reader, err := d.hdfsClient.Open(fullPath)
-	if err != nil {
-		hdfsWriter, _ := d.hdfsClient.Create(fullPath)
-		return newFileWriter(hdfsWriter, fullPath, 0), nil
-	} else {
-		if !append {
-			d.hdfsClient.Remove(fullPath)
-			hdfsWriter, _ := d.hdfsClient.Create(fullPath)
-			return newFileWriter(hdfsWriter, fullPath, 0), nil
-		} else {
-			hdfsWriter, _ := d.hdfsClient.Append(fullPath)
-			return newFileWriter(hdfsWriter, fullPath, reader.Stat().Size()), nil
+		// Get deleteConcurrency
+		deleteConcurrency, err = getParameterAsInt64(parameters, "deleteconcurrency", defaultDeleteConcurrency, 1, 1024)
+		if err != nil {
+			return DriverParameters{}, err
 		}
-	}
-}
 
+		// Get storagePolicy
+		policy, ok := parameters["storagepolicy"]
+		if ok {
+			storagePolicy = strings.ToUpper(fmt.Sprint(policy))
+		}
 
-// Stat retrieves the FileInfo for the given path, including the current
-// size in bytes and the creation time.
-func (d *driver) Stat(context context.Context, path string) (storagedriver.FileInfo, error) {
-	fi, err := d.hdfsClient.Stat(d.fullPath(path))
-	if err != nil {
-		return nil, storagedriver.PathNotFoundError{Path: d.fullPath(path)}
-	}
+		// Get snapshot
+		snapshotName, ok := parameters["snapshot"]
+		if ok {
+			snapshot = fmt.Sprint(snapshotName)
+		}
 
-	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
-		Path:    d.fullPath(path),
-		Size:    int64(fi.Size()),
-		ModTime: fi.ModTime(),
-		IsDir:   fi.IsDir(),
-	}}, nil
-}
+		// Get verifyWrites
+		verifyWrites, err = getParameterAsBool(parameters, "verifywrites", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-// List returns a list of the objects that are direct descendants of the
-//given path.
-func (d *driver) List(context context.Context, subPath string) ([]string, error) {
-	fileInfos, err := d.hdfsClient.ReadDir(d.fullPath(subPath))
-	if err != nil {
-		return make([]string, 0), nil
-	}
+		// Get appendFallback
+		appendFallback, err = getParameterAsBool(parameters, "appendfallback", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-	fileNames := make([]string, len(fileInfos))
-	for index, fileInfo := range fileInfos {
-		fileNames[index] = d.fullPath(subPath) + "/" + fileInfo.Name()
-	}
-	return fileNames, nil
-}
+		// Get uploadStateDirectory
+		stateDir, ok := parameters["uploadstatedirectory"]
+		if ok {
+			uploadStateDirectory = fmt.Sprint(stateDir)
+		}
 
+		// Get dialTimeout
+		dialTimeout, err = getParameterAsDuration(parameters, "dialtimeout", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-// Move moves an object stored at sourcePath to destPath, removing the
-// original object.
-func (d *driver) Move(context context.Context, sourcePath string, destPathstring string) error {
-	d.makeParentDir(d.fullPath(destPathstring))
-	return d.hdfsClient.Rename(d.fullPath(sourcePath), d.fullPath(destPathstring))
-}
+		// Get kmsURI
+		kms, ok := parameters["kmsuri"]
+		if ok {
+			kmsURI = fmt.Sprint(kms)
+		}
 
-// Delete recursively deletes all objects stored at "path" and its subpaths.
-func (d *driver) Delete(context context.Context, path string) error {
-	//return nil
-	return d.hdfsClient.Remove(d.fullPath(path))
-}
+		// Get listRetries
+		listRetries, err = getParameterAsInt64(parameters, "listretries", 0, 0, 10)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-// URLFor returns a URL which may be used to retrieve the content stored at
-// the given path, possibly using the given options.
-func (d *driver) URLFor(context context.Context, path string, options map[string]interface{}) (string, error) {
-	return "", storagedriver.ErrUnsupportedMethod{}
-}
+		// Get listRetryDelay
+		listRetryDelay, err = getParameterAsDuration(parameters, "listretrydelay", defaultListRetryDelay)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-//
-// Implement the storagedriver.FileWriter interface
-//
-type fileWriter struct {
-	hdfsWriter		*hdfs.FileWriter
-	filePath		string
-	isClosed		bool
-	writeSize		int64
-	startingFileSize 	int64
-}
+		// Get namenodePorts
+		ports, ok := parameters["namenodeports"]
+		if ok {
+			namenodePorts, err = parseNamenodePorts(fmt.Sprint(ports))
+			if err != nil {
+				return DriverParameters{}, err
+			}
+		}
 
-func newFileWriter(hdfsWriter *hdfs.FileWriter, filePath string, startingFileSize int64) *fileWriter {
-	return &fileWriter{
-		hdfsWriter: hdfsWriter,
-		filePath: filePath,
-		startingFileSize: startingFileSize,
-	}
-}
+		// Get writeBreakerThreshold
+		writeBreakerThreshold, err = getParameterAsInt64(parameters, "writebreakerthreshold", 0, 0, math.MaxInt32)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-func (w *fileWriter) Write(p []byte) (int, error) {
-	w.Size()
-	if _, err := w.hdfsWriter.Write(p); err != nil {
-		log.Print(err)
-	}
-	w.isClosed = false
-	w.writeSize += int64(len(p))
-	return len(p), nil
-}
+		// Get writeBreakerCooldown
+		writeBreakerCooldown, err = getParameterAsDuration(parameters, "writebreakercooldown", defaultWriteBreakerCooldown)
+		if err != nil {
+			return DriverParameters{}, err
+		}
 
-// Close the client connection
-func (w *fileWriter) Close() error {
-	w.Size()
-	if w.hdfsWriter != nil {
-		if !w.isClosed {
-			w.isClosed = true
-			if err := w.hdfsWriter.Close(); err != nil {
-				log.Print(err)
-			}
+		// Get stagingStrategy
+		strategy, ok := parameters["stagingstrategy"]
+		if ok {
+			stagingStrategy = fmt.Sprint(strategy)
+		}
 
+		// Get stagingThreshold
+		stagingThreshold, err = getParameterAsInt64(parameters, "stagingthreshold", defaultStagingThreshold, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
 		}
-	}
-	return nil
-}
 
-// Size returns the number of bytes written to this FileWriter.
-func (w *fileWriter) Size() int64 {
+		// Get readAhead
+		readAhead, err = getParameterAsInt64(parameters, "readahead", 0, 0, maxReadAhead)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get claimRoot
+		claimRoot, err = getParameterAsBool(parameters, "claimroot", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get instanceID
+		id, ok := parameters["instanceid"]
+		if ok {
+			instanceID = fmt.Sprint(id)
+		}
+
+		// Get noClobber
+		noClobber, err = getParameterAsBool(parameters, "noclobber", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get statConcurrency
+		statConcurrency, err = getParameterAsInt64(parameters, "statconcurrency", defaultStatConcurrency, 1, 1024)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get createParents
+		createParents, err = getParameterAsBool(parameters, "createparents", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get recoverPanics
+		recoverPanics, err = getParameterAsBool(parameters, "recoverpanics", true)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get repositoryQuota
+		repositoryQuota, err = getParameterAsInt64(parameters, "repositoryquota", 0, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get minFreeBytes
+		minFreeBytes, err = getParameterAsInt64(parameters, "minfreebytes", 0, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get readFromObserver
+		readFromObserver, err = getParameterAsBool(parameters, "readfromobserver", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get observerNameNode
+		observer, ok := parameters["observernamenode"]
+		if ok {
+			observerNameNode = fmt.Sprint(observer)
+		}
+
+		// Get leaseRecoveryTimeout
+		leaseRecoveryTimeout, err = getParameterAsDuration(parameters, "leaserecoverytimeout", defaultLeaseRecoveryTimeout)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get readLogLevel
+		readLevel, ok := parameters["readloglevel"]
+		if ok {
+			readLogLevel = fmt.Sprint(readLevel)
+		}
+
+		// Get readLogSampling
+		readLogSampling, err = getParameterAsInt64(parameters, "readlogsampling", 1, 1, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get hotStoragePolicy
+		hot, ok := parameters["hotstoragepolicy"]
+		if ok {
+			hotStoragePolicy = strings.ToUpper(fmt.Sprint(hot))
+		}
+
+		// Get coldStoragePolicy
+		cold, ok := parameters["coldstoragepolicy"]
+		if ok {
+			coldStoragePolicy = strings.ToUpper(fmt.Sprint(cold))
+		}
+
+		// Get hotPrefixes
+		prefixes, ok := parameters["hotprefixes"]
+		if ok {
+			hotPrefixes = fmt.Sprint(prefixes)
+		}
+
+		// Get coldAfter
+		coldAfter, err = getParameterAsDuration(parameters, "coldafter", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get readRetries
+		readRetries, err = getParameterAsInt64(parameters, "readretries", defaultReadRetries, 0, 10)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get listExclude
+		exclude, ok := parameters["listexclude"]
+		if ok {
+			listExclude = fmt.Sprint(exclude)
+		}
+
+		// Get preserveModTime
+		preserveModTime, err = getParameterAsBool(parameters, "preservemtime", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get contentCacheSize
+		contentCacheSize, err = getParameterAsInt64(parameters, "contentcachesize", 0, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get contentCachePrefixes
+		cachePrefixes, ok := parameters["contentcacheprefixes"]
+		if ok {
+			contentCachePrefixes = fmt.Sprint(cachePrefixes)
+		}
+
+		// Get faultInjection
+		faultOps, ok := parameters["faultinjection"]
+		if ok {
+			faultInjection = fmt.Sprint(faultOps)
+		}
+
+		// Get faultInjectionErrorRate
+		faultInjectionErrorRate, err = getParameterAsInt64(parameters, "faultinjectionerrorrate", 0, 0, 100)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get faultInjectionDelay
+		faultInjectionDelay, err = getParameterAsDuration(parameters, "faultinjectiondelay", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get writePipelineRecovery
+		pipelineRecovery, ok := parameters["writepipelinerecovery"]
+		if ok {
+			writePipelineRecovery = fmt.Sprint(pipelineRecovery)
+		}
+
+		// Get tlsCAFile
+		caFile, ok := parameters["tlscafile"]
+		if ok {
+			tlsCAFile = fmt.Sprint(caFile)
+		}
+
+		// Get parallelReadThreshold
+		parallelReadThreshold, err = getParameterAsInt64(parameters, "parallelreadthreshold", 0, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get parallelReads
+		parallelReads, err = getParameterAsInt64(parameters, "parallelreads", defaultParallelReads, 1, 64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get webHdfsReads
+		webHdfsReads, err = getParameterAsBool(parameters, "webhdfsreads", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get maxConcurrentUploads
+		maxConcurrentUploads, err = getParameterAsInt64(parameters, "maxconcurrentuploads", 0, 0, math.MaxInt32)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get maxConcurrentUploadsMode
+		uploadsMode, ok := parameters["maxconcurrentuploadsmode"]
+		if ok {
+			maxConcurrentUploadsMode = fmt.Sprint(uploadsMode)
+		}
+
+		// Get favoredNodes
+		favored, ok := parameters["favorednodes"]
+		if ok {
+			favoredNodes = fmt.Sprint(favored)
+		}
+
+		// Get symlinks
+		symlinksMode, ok := parameters["symlinks"]
+		if ok {
+			symlinks = fmt.Sprint(symlinksMode)
+		}
+
+		// Get flushInterval
+		flushInterval, err = getParameterAsDuration(parameters, "flushinterval", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get dfsPacketSize
+		dfsPacketSize, err = getParameterAsInt64(parameters, "dfspacketsize", 0, 0, maxDFSPacketSize)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get retryBudget
+		retryBudget, err = getParameterAsDuration(parameters, "retrybudget", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get proxyURL
+		proxy, ok := parameters["proxyurl"]
+		if ok {
+			proxyURL = fmt.Sprint(proxy)
+		}
+
+		// Get lazyConnect
+		lazyConnect, err = getParameterAsBool(parameters, "lazyconnect", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get lockDirectory
+		lockDir, ok := parameters["lockdirectory"]
+		if ok {
+			lockDirectory = fmt.Sprint(lockDir)
+		}
+
+		// Get localCacheDir
+		cacheDir, ok := parameters["localcachedirectory"]
+		if ok {
+			localCacheDir = fmt.Sprint(cacheDir)
+		}
+
+		// Get localCacheSize
+		localCacheSize, err = getParameterAsInt64(parameters, "localcachesize", 0, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get directoryModes
+		modes, ok := parameters["directorymodes"]
+		if ok {
+			directoryModes = fmt.Sprint(modes)
+		}
+
+		// Get closeTimeout
+		closeTimeout, err = getParameterAsDuration(parameters, "closetimeout", defaultCloseTimeout)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get pruneEmpty
+		pruneEmpty, err = getParameterAsBool(parameters, "pruneempty", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get commitStatTimeout
+		commitStatTimeout, err = getParameterAsDuration(parameters, "commitstattimeout", defaultCommitStatTimeout)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get slowOpLog
+		slowOpLog, err = getParameterAsDuration(parameters, "slowoplog", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get auditLog
+		audit, ok := parameters["auditlog"]
+		if ok {
+			auditLogPath = fmt.Sprint(audit)
+		}
+
+		// Get minDeleteDepth
+		minDeleteDepth, err = getParameterAsInt64(parameters, "mindeletedepth", 0, 0, math.MaxInt32)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get maxDeleteEntries
+		maxDeleteEntries, err = getParameterAsInt64(parameters, "maxdeleteentries", 0, 0, math.MaxInt64)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get maintenanceRetries
+		maintenanceRetries, err = getParameterAsInt64(parameters, "maintenanceretries", defaultMaintenanceRetries, 0, 10)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get maintenanceRetryDelay
+		maintenanceRetryDelay, err = getParameterAsDuration(parameters, "maintenanceretrydelay", defaultMaintenanceRetryDelay)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get normalizePaths
+		normalizePaths, err = getParameterAsBool(parameters, "normalizepaths", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get socketTimeout
+		socketTimeout, err = getParameterAsDuration(parameters, "sockettimeout", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get readTimeout
+		readTimeout, err = getParameterAsDuration(parameters, "readtimeout", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get writeTimeout
+		writeTimeout, err = getParameterAsDuration(parameters, "writetimeout", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get classifyErrors
+		classifyErrors, err = getParameterAsBool(parameters, "classifyerrors", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get packPrefixes
+		packs, ok := parameters["packprefixes"]
+		if ok {
+			packPrefixes = fmt.Sprint(packs)
+		}
+
+		// Get packMaxSize
+		packMaxSize, err = getParameterAsInt64(parameters, "packmaxsize", defaultPackMaxSize, 0, maxPackMaxSize)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get deleteGrace
+		deleteGrace, err = getParameterAsDuration(parameters, "deletegrace", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get healthRouting
+		healthRouting, err = getParameterAsBool(parameters, "healthrouting", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get healthProbeInterval
+		healthProbeInterval, err = getParameterAsDuration(parameters, "healthprobeinterval", defaultHealthProbeInterval)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get verifyDigest
+		verifyDigest, err = getParameterAsBool(parameters, "verifydigest", false)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get mirrorNameNode, mirrorMode and mirrorFailure
+		mirror, ok := parameters["mirrornamenode"]
+		if ok {
+			mirrorNameNode = fmt.Sprint(mirror)
+		}
+		mode, ok := parameters["mirrormode"]
+		if ok {
+			mirrorMode = fmt.Sprint(mode)
+		}
+		failure, ok := parameters["mirrorfailure"]
+		if ok {
+			mirrorFailure = fmt.Sprint(failure)
+		}
+
+		// Get datanodeBlacklistTTL
+		datanodeBlacklistTTL, err = getParameterAsDuration(parameters, "datanodeblacklistttl", 0)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get datanodeBlacklistThreshold
+		datanodeBlacklistThreshold, err = getParameterAsInt64(parameters, "datanodeblacklistthreshold", defaultDatanodeBlacklistThreshold, 1, 100)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+
+		// Get longPathStrategy
+		strategy, ok = parameters["longpathstrategy"]
+		if ok {
+			longPathStrategy = fmt.Sprint(strategy)
+		}
+
+		// Get maxPathComponentLength
+		maxPathComponentLength, err = getParameterAsInt64(parameters, "maxpathcomponentlength", defaultMaxPathComponentLength, minPathComponentLength, maxHDFSPathLength)
+		if err != nil {
+			return DriverParameters{}, err
+		}
+	}
+
+	// Populate params
+	params := DriverParameters{
+		HdfsRootDirectory:  hdfsRootDirectory,
+		HdfsNameNode:       hdfsNamenode,
+		HdfsUser:           hdfsUser,
+		DirectoryUmask:     directoryUmask,
+		WebHdfsAddress:     webHdfsAddress,
+		WebHdfsPort:        webHdfsPort,
+		WebHdfsTLS:         webHdfsTLS,
+		TransferBufferSize: transferBufferSize,
+		UseHadoopEnv:       useHadoopEnv,
+		MaxPutContentSize:  maxPutContentSize,
+		PathTransform:      pathTransformName,
+		PathDepth:          pathDepth,
+		Compression:        compression,
+		ReadBandwidth:      readBandwidth,
+		WriteBandwidth:     writeBandwidth,
+		MaxOpsPerSecond:    maxOpsPerSecond,
+		MaxOpsMode:         maxOpsMode,
+		FixPermissions:     fixPermissions,
+		BestEffortDelete:   bestEffortDelete,
+		Replication:        replication,
+		StagingReplication: stagingReplication,
+		ListSort:           listSort,
+		DeleteConcurrency:  deleteConcurrency,
+		StoragePolicy:      storagePolicy,
+		Snapshot:           snapshot,
+		VerifyWrites:       verifyWrites,
+		AppendFallback:     appendFallback,
+		UploadStateDir:     uploadStateDirectory,
+		DialTimeout:        dialTimeout,
+		KmsURI:             kmsURI,
+		ListRetries:        listRetries,
+		ListRetryDelay:     listRetryDelay,
+		NamenodePorts:      namenodePorts,
+		BreakerThreshold:   writeBreakerThreshold,
+		BreakerCooldown:    writeBreakerCooldown,
+		StagingStrategy:    stagingStrategy,
+		StagingThreshold:   stagingThreshold,
+		ReadAhead:          readAhead,
+		ClaimRoot:          claimRoot,
+		InstanceID:         instanceID,
+		NoClobber:          noClobber,
+		StatConcurrency:    statConcurrency,
+		CreateParents:      createParents,
+		RecoverPanics:      recoverPanics,
+		RepositoryQuota:    repositoryQuota,
+		MinFreeBytes:       minFreeBytes,
+		ReadFromObserver:   readFromObserver,
+		ObserverNameNode:   observerNameNode,
+		LeaseTimeout:       leaseRecoveryTimeout,
+		ReadLogLevel:       readLogLevel,
+		ReadLogSampling:    readLogSampling,
+		HotStoragePolicy:   hotStoragePolicy,
+		ColdStoragePolicy:  coldStoragePolicy,
+		HotPrefixes:        hotPrefixes,
+		ColdAfter:          coldAfter,
+		ReadRetries:        readRetries,
+		ListExclude:        listExclude,
+		PreserveModTime:    preserveModTime,
+		ContentCacheSize:   contentCacheSize,
+		ContentCacheDirs:   contentCachePrefixes,
+
+		FaultInjection:          faultInjection,
+		FaultInjectionErrorRate: faultInjectionErrorRate,
+		FaultInjectionDelay:     faultInjectionDelay,
+
+		WritePipelineRecovery: writePipelineRecovery,
+
+		TLSCAFile: tlsCAFile,
+
+		ParallelReadThreshold: parallelReadThreshold,
+		ParallelReads:         parallelReads,
+
+		WebHdfsReads: webHdfsReads,
+
+		MaxConcurrentUploads:     maxConcurrentUploads,
+		MaxConcurrentUploadsMode: maxConcurrentUploadsMode,
+
+		FavoredNodes: favoredNodes,
+
+		Symlinks: symlinks,
+
+		FlushInterval: flushInterval,
+
+		DFSPacketSize: dfsPacketSize,
+
+		RetryBudget: retryBudget,
+
+		ProxyURL: proxyURL,
+
+		LazyConnect: lazyConnect,
+
+		LockDirectory: lockDirectory,
+
+		LocalCacheDir:  localCacheDir,
+		LocalCacheSize: localCacheSize,
+
+		DirectoryModes: directoryModes,
+
+		CloseTimeout: closeTimeout,
+
+		PruneEmpty: pruneEmpty,
+
+		CommitStatTimeout: commitStatTimeout,
+
+		SlowOpLog: slowOpLog,
+
+		AuditLog: auditLogPath,
+
+		MinDeleteDepth:   minDeleteDepth,
+		MaxDeleteEntries: maxDeleteEntries,
+
+		MaintenanceRetries:    maintenanceRetries,
+		MaintenanceRetryDelay: maintenanceRetryDelay,
+
+		NormalizePaths: normalizePaths,
+
+		SocketTimeout: socketTimeout,
+		ReadTimeout:   readTimeout,
+		WriteTimeout:  writeTimeout,
+
+		ClassifyErrors: classifyErrors,
+
+		PackPrefixes: packPrefixes,
+		PackMaxSize:  packMaxSize,
+
+		DeleteGrace: deleteGrace,
+
+		HealthRouting:       healthRouting,
+		HealthProbeInterval: healthProbeInterval,
+
+		VerifyDigest: verifyDigest,
+
+		MirrorNameNode: mirrorNameNode,
+		MirrorMode:     mirrorMode,
+		MirrorFailure:  mirrorFailure,
+
+		DatanodeBlacklistTTL:       datanodeBlacklistTTL,
+		DatanodeBlacklistThreshold: datanodeBlacklistThreshold,
+
+		LongPathStrategy:       longPathStrategy,
+		MaxPathComponentLength: maxPathComponentLength,
+	}
+	return params, nil
+}
+
+// NewFromConfig validates params and constructs a new Driver from them
+func NewFromConfig(params DriverParameters) (*Driver, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return New(params)
+}
+
+// New constructs a new driver
+func New(params DriverParameters) (*Driver, error) {
+
+	// Merge in the Hadoop client configuration, explicit parameters win
+	if params.UseHadoopEnv {
+		if err := applyHadoopEnvironment(&params); err != nil {
+			return nil, err
+		}
+	}
+	// Processes launched by YARN get delegation tokens instead
+	if err := applyTokenFile(&params); err != nil {
+		return nil, err
+	}
+	if params.HdfsUser == "" {
+		params.HdfsUser = defaultHdfsUser
+	}
+
+	// Setup the connection to hdfs
+	options, dialContext, err := clientOptions(params)
+	if err != nil {
+		return nil, err
+	}
+	dialNamenodes := func(namenodes string, lazy bool) (hdfsClient, error) {
+		// Ports are probed on every connect, they may change during
+		// upgrades. The client uses the first namenode it reaches, so
+		// failing over moves the next one to the front.
+		var failovers int
+		var router *healthRouter
+		if params.HealthRouting {
+			router = newHealthRouter(newNamenodeProbe(params, dialContext), params.HealthProbeInterval)
+		}
+		connect := func() (*hdfs.Client, error) {
+			addresses, err := resolveNamenodes(splitList(namenodes), params.NamenodePorts, dialContext.dial)
+			if err != nil {
+				return nil, err
+			}
+			resolved := options
+			resolved.Addresses = rotateNamenodes(router.order(addresses), failovers)
+			return hdfs.NewClient(resolved)
+		}
+
+		// The driver owns this client, so it may replace it when the
+		// connection goes stale or the namenode is a standby. The
+		// reconnecting client serializes calls to dial.
+		dial := func(failover bool) (hdfsClient, error) {
+			if failover {
+				failovers++
+			}
+			client, err := connect()
+			if err != nil {
+				return nil, err
+			}
+			return colinmarcClient{client}, nil
+		}
+		// With lazyconnect the first operation connects instead
+		var reconnecting *reconnectingClient
+		if lazy {
+			reconnecting = newReconnectingClient(nil, dial)
+		} else {
+			client, err := connect()
+			if err != nil {
+				return nil, fmt.Errorf("connecting to namenode %s: %v", namenodes, err)
+			}
+			reconnecting = newReconnectingClient(colinmarcClient{client}, dial)
+		}
+		reconnecting.onReconnect = params.OnReconnect
+		if router != nil {
+			reconnecting.router = router
+			router.unhealthy = func() {
+				if client := reconnecting.current(); client != nil {
+					log.Printf("hdfs: the namenode of %s is unhealthy, reconnecting", namenodes)
+					if err := reconnecting.switchOver(client); err != nil {
+						log.Printf("hdfs: unable to reconnect to a healthier namenode: %v", err)
+					}
+				}
+			}
+			router.start()
+		}
+		return reconnecting, nil
+	}
+	client, err := dialNamenodes(params.HdfsNameNode, params.LazyConnect)
+	if err != nil {
+		return nil, err
+	}
+	if params.ReadFromObserver {
+		observer, err := dialNamenodes(params.ObserverNameNode, params.LazyConnect)
+		if err != nil {
+			return nil, err
+		}
+		client = newObserverClient(client, observer)
+	}
+	if params.MirrorNameNode != "" {
+		secondary, err := dialNamenodes(params.MirrorNameNode, true)
+		if err != nil {
+			return nil, err
+		}
+		client = newMirrorClient(client, secondary, params.MirrorMode, params.MirrorFailure)
+	}
+
+	d, err := newDriver(client, params)
+	if err != nil {
+		closeClient(client)
+		return nil, err
+	}
+	d.closer.client, _ = client.(io.Closer)
+	return wrap(d), nil
+}
+
+// NewWithClient constructs a new driver around an existing HDFS client
+// instead of dialing the namenode. The hdfsnamenode and usehadoopenv
+// parameters are not consulted.
+func NewWithClient(client *hdfs.Client, params DriverParameters) (*Driver, error) {
+	if client == nil {
+		return nil, fmt.Errorf("hdfs client must not be nil")
+	}
+
+	d, err := newDriver(colinmarcClient{client}, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return wrap(d), nil
+}
+
+// wrap returns the exported Driver around d
+func wrap(d *driver) *Driver {
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: d,
+			},
+		},
+	}
+}
+
+// List lists the root for the empty path, which some callers enumerating
+// the catalog pass and base.Base would reject, and is base.Base's List
+// otherwise.
+func (d *Driver) List(ctx context.Context, path string) ([]string, error) {
+	if path == "" {
+		path = "/"
+	}
+	path, err := d.normalize(path)
+	if err != nil {
+		return nil, err
+	}
+	return d.baseEmbed.List(ctx, path)
+}
+
+// inner returns the driver wrapped by base.Base
+func (d *Driver) inner() *driver {
+	return d.Base.StorageDriver.(*driver)
+}
+
+// clientOptions returns the options of the colinmarc/hdfs clients for
+// params, and the function namenodes are dialed with
+func clientOptions(params DriverParameters) (hdfs.ClientOptions, dialFunc, error) {
+	options := hdfs.ClientOptions{
+		User: params.HdfsUser,
+	}
+	// Without a timeout an unreachable namenode blocks for as long as the
+	// kernel keeps retrying the connection
+	dialer := &net.Dialer{Timeout: params.DialTimeout}
+	dialContext := dialFunc(dialer.DialContext)
+	if params.ProxyURL != "" {
+		var err error
+		if dialContext, err = proxyDialer(params.ProxyURL, dialContext); err != nil {
+			return hdfs.ClientOptions{}, nil, err
+		}
+	}
+	if params.DialTimeout > 0 || params.ProxyURL != "" {
+		options.NamenodeDialFunc = dialContext
+		options.DatanodeDialFunc = dialContext
+	}
+	if readTimeout, writeTimeout := socketTimeouts(params); readTimeout > 0 || writeTimeout > 0 {
+		options.DatanodeDialFunc = withSocketTimeouts(dialContext, readTimeout, writeTimeout)
+	}
+	if blacklist := newDatanodeBlacklist(params.DatanodeBlacklistTTL, int(params.DatanodeBlacklistThreshold)); blacklist != nil {
+		datanodeDial := dialFunc(options.DatanodeDialFunc)
+		if datanodeDial == nil {
+			datanodeDial = dialContext
+		}
+		options.DatanodeDialFunc = blacklist.dial(datanodeDial)
+	}
+	return options, dialContext, nil
+}
+
+// newDriver populates the internal driver around any hdfsClient
+func newDriver(client hdfsClient, params DriverParameters) (*driver, error) {
+	transform, err := newPathMapping(params)
+	if err != nil {
+		return nil, err
+	}
+	transform, err = newLongPathTransform(transform, params.LongPathStrategy, int(params.MaxPathComponentLength))
+	if err != nil {
+		return nil, err
+	}
+	compression, err := newCodec(params.Compression)
+	if err != nil {
+		return nil, err
+	}
+	client, err = newOpsRateLimit(client, params.MaxOpsPerSecond, params.MaxOpsMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.HdfsUser == "" {
+		params.HdfsUser = defaultHdfsUser
+	}
+	if params.TransferBufferSize <= 0 {
+		params.TransferBufferSize = defaultTransferBufferSize
+	}
+	if params.DeleteConcurrency <= 0 {
+		params.DeleteConcurrency = defaultDeleteConcurrency
+	}
+	if params.StatConcurrency <= 0 {
+		params.StatConcurrency = defaultStatConcurrency
+	}
+	if params.ParallelReads <= 0 {
+		params.ParallelReads = defaultParallelReads
+	}
+	if params.ListSort == "" {
+		params.ListSort = listSortName
+	} else if err := validateListSort(params.ListSort); err != nil {
+		return nil, err
+	}
+	if params.StagingStrategy == "" {
+		params.StagingStrategy = stagingMemory
+	} else if err := validateStagingStrategy(params.StagingStrategy); err != nil {
+		return nil, err
+	}
+	// The cluster default cannot be restored once an upload is moved into
+	// place, so the final replication has to be explicit
+	if params.StagingReplication > 0 && params.Replication == 0 {
+		return nil, fmt.Errorf("stagingreplication requires replication to be set")
+	}
+
+	// Parameters built in code may leave the mode unset. A mode of 0 would
+	// create directories nobody can use, including the registry itself.
+	if params.DirectoryUmask == 0 {
+		params.DirectoryUmask = defaultDirectoryUmask
+	} else if params.DirectoryUmask < 0 || params.DirectoryUmask > 0777 {
+		return nil, fmt.Errorf("The directoryumask parameter must be a mode between 01 and 0777, %#o invalid", params.DirectoryUmask)
+	}
+
+	// Populate the driver
+	d := &driver{
+		hdfsRootDirectory:  path.Clean("/" + params.HdfsRootDirectory),
+		hdfsNameNode:       params.HdfsNameNode,
+		hdfsUser:           params.HdfsUser,
+		directoryUmask:     params.DirectoryUmask,
+		hdfsClient:         client,
+		bufferPool:         newBufferPool(int(params.TransferBufferSize)),
+		maxPutContentSize:  params.MaxPutContentSize,
+		pathTransform:      transform,
+		compression:        compression,
+		readsCompressed:    params.Compression != "",
+		bestEffortDelete:   params.BestEffortDelete,
+		replication:        int(params.Replication),
+		stagingReplication: int(params.StagingReplication),
+		listSort:           params.ListSort,
+		deleteConcurrency:  int(params.DeleteConcurrency),
+		storagePolicy:      params.StoragePolicy,
+		snapshot:           params.Snapshot,
+		verifyWrites:       params.VerifyWrites,
+		appendFallback:     params.AppendFallback,
+		readLimiter:        newBandwidthLimiter(params.ReadBandwidth),
+		writeLimiter:       newBandwidthLimiter(params.WriteBandwidth),
+		writes:             newWriteBreaker(int(params.BreakerThreshold), params.BreakerCooldown),
+		listRetries:        int(params.ListRetries),
+		listRetryDelay:     params.ListRetryDelay,
+		stagingStrategy:    params.StagingStrategy,
+		stagingThreshold:   params.StagingThreshold,
+		readAheadSize:      int(params.ReadAhead),
+		noClobber:          params.NoClobber,
+		statConcurrency:    int(params.StatConcurrency),
+		createParents:      params.CreateParents,
+		recoverPanics:      params.RecoverPanics,
+
+		leaseRecoveryTimeout:   params.LeaseTimeout,
+		leaseRecoveryInterval:  defaultLeaseRecoveryInterval,
+		closeTimeout:           params.CloseTimeout,
+		pruneEmpty:             params.PruneEmpty,
+		commitStatTimeout:      params.CommitStatTimeout,
+		readLog:                newReadLog(params.ReadLogLevel, params.ReadLogSampling),
+		slowOps:                newSlowOpLog(params.SlowOpLog),
+		audit:                  newAuditLog(params.AuditLog, params.HdfsUser),
+		minDeleteDepth:         int(params.MinDeleteDepth),
+		maxDeleteEntries:       params.MaxDeleteEntries,
+		readRetries:            int(params.ReadRetries),
+		maintenanceRetries:     int(params.MaintenanceRetries),
+		maintenanceRetryDelay:  params.MaintenanceRetryDelay,
+		normalizePaths:         params.NormalizePaths,
+		classifyErrors:         params.ClassifyErrors,
+		recentlyDeleted:        newDeleteGrace(params.DeleteGrace),
+		verifyDigest:           params.VerifyDigest,
+		longPathStrategy:       params.LongPathStrategy,
+		maxPathComponentLength: maxPathComponentLength(params),
+		changes:                newChangeNotifier(params.OnChange, changeEventBuffer),
+		closer:                 &driverCloser{},
+		listExclude:            splitList(params.ListExclude),
+		preserveModTime:        params.PreserveModTime,
+		tiers:                  newStorageTiers(params.HotStoragePolicy, params.ColdStoragePolicy, params.HotPrefixes, params.ColdAfter),
+		pipelineRecovery:       strings.ToUpper(params.WritePipelineRecovery),
+		parallelReadThreshold:  params.ParallelReadThreshold,
+		parallelReadBlock:      defaultBlockSize,
+		parallelReads:          int(params.ParallelReads),
+
+		uploadStateDirectory:  params.UploadStateDir,
+		storagePolicyDisabled: new(int32),
+		delegationToken:       params.delegationToken,
+		params:                params,
+	}
+	hostname, _ := os.Hostname()
+	d.instanceTag = newInstanceTag(hostname, params.InstanceID)
+	if params.RepositoryQuota > 0 {
+		d.quota = newRepositoryQuota(params.RepositoryQuota, d.repositoryUsage)
+	}
+	d.faults, err = newFaultInjector(params.FaultInjection, params.FaultInjectionErrorRate, params.FaultInjectionDelay)
+	if err != nil {
+		return nil, err
+	}
+	d.contentCache = newContentCache(params.ContentCacheSize, d.hdfsRootDirectory, splitList(params.ContentCacheDirs))
+	if d.localCache, err = newLocalCache(params.LocalCacheDir, params.LocalCacheSize, d.hdfsRootDirectory, splitList(params.ContentCacheDirs)); err != nil {
+		return nil, err
+	}
+	if d.directoryModes, err = parseDirectoryModes(params.DirectoryModes); err != nil {
+		return nil, err
+	}
+	if d.packs, err = newPacks(params.PackPrefixes, params.PackMaxSize); err != nil {
+		return nil, err
+	}
+	if params.MinFreeBytes > 0 {
+		d.freeSpace = newFreeSpaceCheck(uint64(params.MinFreeBytes), func() (hdfs.FsInfo, error) {
+			return statFs(d.hdfsClient)
+		})
+	}
+	if d.uploadStateDirectory != "" {
+		d.uploadStateDirectory = path.Clean(d.uploadStateDirectory)
+	}
+	if err := validateUploadStateDirectory(d.uploadStateDirectory, d.hdfsRootDirectory); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(params.TLSCAFile)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := newHTTPClient(tlsConfig)
+
+	// WebHDFS hands out redirect URLs and sets replication and truncates
+	address, err := webHdfsAddress(params)
+	if err != nil {
+		return nil, err
+	}
+	if address != "" {
+		d.webHdfs = newWebHdfsClient(address, params.HdfsUser)
+		d.webHdfs.client = httpClient
+		d.webHdfs.packetSize = params.DFSPacketSize
+		d.webHdfs.token = params.delegationToken
+	}
+	if params.WebHdfsReads {
+		if d.webHdfs == nil {
+			return nil, errWebHdfsReadsAddress
+		}
+		d.webHdfsReads = true
+	}
+	if d.uploads, err = newUploadLimiter(params.MaxConcurrentUploads, params.MaxConcurrentUploadsMode); err != nil {
+		return nil, err
+	}
+	if d.favoredNodes, err = parseFavoredNodes(params.FavoredNodes); err != nil {
+		return nil, err
+	}
+	d.favoredNodesUnsupported = &sync.Once{}
+	d.followSymlinks = params.Symlinks != "nofollow"
+	d.flushInterval = params.FlushInterval
+	d.retryBudget = params.RetryBudget
+	d.lockDirectory = path.Join(d.hdfsRootDirectory, lockDirectoryName)
+	if params.LockDirectory != "" {
+		d.lockDirectory = path.Clean(params.LockDirectory)
+	}
+
+	// Files read from encryption zones are decrypted by the driver
+	if params.KmsURI != "" {
+		if d.kms, err = newKmsClient(params.KmsURI, params.HdfsUser); err != nil {
+			return nil, err
+		}
+		d.kms.client = httpClient
+	}
+
+	// Claim the root before anything is written to it
+	if params.ClaimRoot && client != nil {
+		if err := validateInstanceID(params.ClaimRoot, params.InstanceID); err != nil {
+			return nil, err
+		}
+		if err := d.claimRoot(params.InstanceID); err != nil {
+			return nil, err
+		}
+	}
+
+	if params.FixPermissions && client != nil {
+		if err := d.fixPermissions(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Directories inherit the policy, so an existing root gets it as well
+	if d.storagePolicy != "" && client != nil {
+		if _, err := client.Stat(d.hdfsRootDirectory); err == nil {
+			d.applyStoragePolicy(d.hdfsRootDirectory)
+		}
+	}
+
+	context.GetLoggerWithFields(context.Background(), map[interface{}]interface{}{
+		"hdfs.version":       Version(),
+		"hdfs.rootdirectory": d.hdfsRootDirectory,
+		"hdfs.namenode":      d.hdfsNameNode,
+	}).Infof("hdfs: driver %s started", Version())
+	return d, nil
+}
+
+//
+// Implement the storagedriver.StorageDriver interface
+//
+
+// Return the display name
+func (d *driver) Name() string {
+	return driverDisplayName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+// This should primarily be used for small objects.
+func (d *driver) GetContent(context context.Context, path string) (_ []byte, err error) {
+	defer d.classify(&err)
+	defer d.recentlyDeleted.check(path, &err)
+	defer d.slowOps.log(context, "GetContent", path, time.Now())
+	defer d.readLog.log(context, "GetContent", path, time.Now(), &err)
+	defer transfers.failed("GetContent", &err)
+	defer d.recoverPanic(context, "GetContent", &err)
+
+	if err := d.faults.inject("GetContent"); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkClient(); err != nil {
+		return nil, err
+	}
+	d = d.withOptions(context)
+	fullPath, err := d.readPath(context, path)
+	if err != nil {
+		return nil, err
+	}
+	if contents, ok, err := d.getPacked(path); err != nil || ok {
+		return contents, err
+	}
+	cacheable := d.contentCache.cacheable(fullPath)
+	if contents, ok := d.contentCache.get(fullPath); ok {
+		return contents, nil
+	}
+	if contents, ok := d.localCache.get(fullPath); ok {
+		if cacheable {
+			d.contentCache.add(fullPath, contents)
+		}
+		return contents, nil
+	}
+	// Only a missing file is not found; the namenode failing the open, or
+	// the read failing later, says nothing about whether the file exists
+	generation := d.localCache.generation()
+	reader, storedPath, err := d.openResolved(fullPath)
+	if os.IsNotExist(err) {
+		return nil, storagedriver.PathNotFoundError{Path: fullPath}
+	} else if err != nil {
+		return nil, dataUnavailable(path, err)
+	}
+	defer reader.Close()
+
+	// A zero-length file has no blocks, so there is nothing to read
+	size := reader.Stat().Size()
+	if size == 0 {
+		return []byte{}, nil
+	}
+
+	var stored []byte
+	if d.parallelReadThreshold > 0 && size >= d.parallelReadThreshold {
+		if stored, err = d.readParallel(storedPath, size); err != nil {
+			return nil, dataUnavailable(path, err)
+		}
+	} else {
+		var buf bytes.Buffer
+		buf.Grow(int(size))
+		if _, err := d.bufferPool.copy(&buf, &countingReader{Reader: d.throttleReader(reader), op: "GetContent"}); err != nil {
+			return nil, dataUnavailable(path, err)
+		}
+		stored = buf.Bytes()
+	}
+	header, err := d.compressionOf(storedPath)
+	if err != nil {
+		return nil, dataUnavailable(path, err)
+	}
+	contents, err := decompress(header, stored)
+	if err == nil && cacheable {
+		d.contentCache.add(fullPath, contents)
+	}
+	if err == nil {
+		d.localCache.add(fullPath, contents, generation)
+	}
+	return contents, err
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+// This should primarily be used for small objects.
+func (d *driver) PutContent(context context.Context, path string, contents []byte) (err error) {
+	defer d.classify(&err)
+	defer d.slowOps.log(context, "PutContent", path, time.Now())
+	defer transfers.failed("PutContent", &err)
+	defer d.recoverPanic(context, "PutContent", &err)
+
+	if err := d.faults.inject("PutContent"); err != nil {
+		return err
+	}
+
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	d = d.withRetryBudget()
+	if d.maxPutContentSize > 0 && int64(len(contents)) > d.maxPutContentSize {
+		return fmt.Errorf("PutContent of %d bytes to %s exceeds maxputcontentsize of %d bytes, use Writer for large objects", len(contents), path, d.maxPutContentSize)
+	}
+	if err := d.checkDigest(path, contents); err != nil {
+		return err
+	}
+	if err := d.checkPathLength(path); err != nil {
+		return err
+	}
+
+	fullPath := d.fullPath(path)
+	size := int64(len(contents))
+	layer, err := d.reserveLayer(path, fullPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			d.releaseQuota(path, layer)
+		}
+	}()
+	defer func() {
+		if err == nil {
+			d.audit.recordWrite(d, context, path, size)
+			d.changes.wrote(path, size)
+			d.recentlyDeleted.forget(path)
+		}
+	}()
+	return d.storeContent(context, path, fullPath, contents)
+}
+
+// storeContent stores contents at path, packed, compressed or as they are,
+// without the audit record and change event of PutContent
+func (d *driver) storeContent(context context.Context, path, fullPath string, contents []byte) error {
+	if p := d.packs.packFor(path); p != nil && int64(len(contents)) <= d.packs.maxSize {
+		if err := d.writes.allow(); err != nil {
+			return err
+		}
+		return d.putPacked(p, path, contents)
+	}
+
+	var attrs map[string][]byte
+	if d.compression != nil {
+		header := compressionHeader{codec: d.compression, logicalSize: int64(len(contents))}
+		compressed, err := compress(d.compression, contents)
+		if err != nil {
+			return err
+		}
+		contents = compressed
+		attrs = map[string][]byte{compressionXAttr: header.value()}
+	}
+
+	err := d.putContent(context, path, fullPath, contents, attrs)
+	if d.rewritesAfter(err) {
+		log.Printf("hdfs: writing %s again after a datanode failed: %v", path, err)
+		err = d.putContent(context, path, fullPath, contents, attrs)
+	}
+	return err
+}
+
+// putContent writes the already compressed contents to path. Contents with
+// extended attributes are staged, so they never show without them.
+func (d *driver) putContent(context context.Context, path, fullPath string, contents []byte, attrs map[string][]byte) error {
+	if attrs != nil || d.stagesInTempFile(len(contents)) {
+		return d.putContentStaged(context, path, fullPath, contents, attrs)
+	}
+
+	// Get the FileWriter
+	writer, err := d.Writer(context, path, false)
+	if err != nil {
+		return err
+	}
+	if fw, ok := writer.(*fileWriter); ok {
+		fw.transferOp = "PutContent"
+		fw.audit = nil
+		// PutContent checked the digest, and may write compressed content
+		fw.checkDigest, fw.digester = nil, nil
+	}
+
+	// Write the contents. Commit may fail where the Close after it finds
+	// nothing left to do, so the first error is the one returned. Empty
+	// contents, such as the registry's marker files, are not written at
+	// all: the first write to a file allocates a block on the datanodes,
+	// while a file closed without one is a valid zero-length file.
+	if len(contents) > 0 {
+		_, err = writer.Write(contents)
+	}
+	if err != nil {
+		log.Print(err)
+	} else {
+		err = writer.Commit()
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path"
+// with a given byte offset.
+// May be used to resume reading a stream by providing a nonzero offset.
+func (d *driver) Reader(context context.Context, path string, offset int64) (_ io.ReadCloser, err error) {
+	defer d.classify(&err)
+	defer d.recentlyDeleted.check(path, &err)
+	defer d.slowOps.log(context, "Reader", path, time.Now())
+	defer d.readLog.log(context, "Reader", path, time.Now(), &err)
+	defer transfers.failed("Reader", &err)
+	defer d.recoverPanic(context, "Reader", &err)
+
+	if err := d.faults.inject("Reader"); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkClient(); err != nil {
+		return nil, err
+	}
+	d = d.withOptions(context)
+	fullPath, err := d.readPath(context, path)
+	if err != nil {
+		return nil, err
+	}
+	if contents, ok, err := d.getPacked(path); err != nil {
+		return nil, err
+	} else if ok {
+		if offset > int64(len(contents)) {
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+		}
+		return ioutil.NopCloser(bytes.NewReader(contents[offset:])), nil
+	}
+	if file, ok := d.localCache.open(fullPath); ok {
+		return readLocal(file, fullPath, offset)
+	}
+
+	// Open the file
+	generation := d.localCache.generation()
+	reader, err := d.open(fullPath)
+	if os.IsNotExist(err) {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	} else if err != nil {
+		return nil, err
+	}
+
+	// A zero-length file has no blocks to seek into; hand back a reader
+	// that is immediately at EOF instead of relying on Seek.
+	if reader.Stat().Size() == 0 {
+		reader.Close()
+		if offset > 0 {
+			return nil, storagedriver.InvalidOffsetError{Path: fullPath, Offset: offset}
+		}
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	header, err := d.compressionOf(fullPath)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	if header != nil {
+		if offset > header.logicalSize {
+			reader.Close()
+			return nil, storagedriver.InvalidOffsetError{Path: fullPath, Offset: offset}
+		}
+		decoder, err := header.codec.newReader(&countingReader{Reader: d.throttleReader(reader), op: "Reader"})
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		decompressed := &decompressingReader{Reader: decoder, decoder: decoder, file: reader}
+
+		// Compressed streams cannot seek, so decompress up to the offset
+		// and stream from there
+		if offset > 0 {
+			if _, err := d.bufferPool.copy(ioutil.Discard, io.LimitReader(decoder, offset)); err != nil {
+				decompressed.Close()
+				return nil, dataUnavailable(path, err)
+			}
+			return &unavailableReader{ReadCloser: decompressed, path: path}, nil
+		}
+		return d.localCache.tee(fullPath, &unavailableReader{ReadCloser: decompressed, path: path}, header.logicalSize, generation), nil
+	}
+
+	// HDFS seeks past the end of a file without an error, so the size is
+	// checked rather than the position the seek returned
+	size := reader.Stat().Size()
+	if offset > size {
+		reader.Close()
+		return nil, storagedriver.InvalidOffsetError{Path: fullPath, Offset: offset}
+	}
+
+	// Seek to the supplied offset
+	reader = d.retryReads(reader, fullPath)
+	seekPos, err := reader.Seek(int64(offset), os.SEEK_SET)
+	if err != nil {
+		reader.Close()
+		return nil, dataUnavailable(path, err)
+	} else if seekPos < int64(offset) {
+		reader.Close()
+		return nil, storagedriver.InvalidOffsetError{Path: fullPath, Offset: offset}
+	}
+
+	var readCloser io.ReadCloser = &pooledReader{
+		ReadCloser: &unavailableReader{ReadCloser: d.readAhead(countReadCloser("Reader", d.throttleReadCloser(reader))), path: path},
+		pool:       d.bufferPool,
+	}
+	if offset == 0 {
+		readCloser = d.localCache.tee(fullPath, readCloser, size, generation)
+	}
+	return readCloser, nil
+}
+
+// readLocal returns file, the local copy of fullPath, positioned at offset
+func readLocal(file *os.File, fullPath string, offset int64) (io.ReadCloser, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if offset > fi.Size() {
+		file.Close()
+		return nil, storagedriver.InvalidOffsetError{Path: fullPath, Offset: offset}
+	}
+	if _, err := file.Seek(offset, os.SEEK_SET); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" after the call to Commit.
+func (d *driver) Writer(context context.Context, path string, append bool) (writer storagedriver.FileWriter, err error) {
+	defer d.classify(&err)
+	defer d.slowOps.log(context, "Writer", path, time.Now())
+	defer transfers.failed("Writer", &err)
+	defer d.recoverPanic(context, "Writer", &err)
+
+	if err := d.faults.inject("Writer"); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkClient(); err != nil {
+		return nil, err
+	}
+	d = d.withOptions(context).withRetryBudget()
+	if err := d.writes.allow(); err != nil {
+		return nil, err
+	}
+	if err := d.checkPathLength(path); err != nil {
+		return nil, err
+	}
+	d.recentlyDeleted.forget(path)
+	if err := d.freeSpace.allow(); err != nil {
+		return nil, err
+	}
+	if err := d.uploads.acquire(context); err != nil {
+		return nil, err
+	}
+	defer func() { d.uploads.handOff(writer, err) }()
+	defer func() {
+		if fw, ok := writer.(*fileWriter); ok && err == nil && (d.audit != nil || d.changes != nil) {
+			fw.audit = func(size int64) {
+				d.audit.recordWrite(d, context, path, size)
+				d.changes.wrote(path, size)
+			}
+		}
+	}()
+	fullPath := d.fullPath(path)
+	d.contentCache.invalidate(fullPath)
+	if contents, ok, err := d.getPacked(path); err != nil {
+		return nil, err
+	} else if ok {
+		// The file replaces the packed object, which an append continues
+		if !append {
+			if err := d.unpack(path); err != nil {
+				return nil, err
+			}
+		} else {
+			d.makeParentDir(fullPath)
+			hdfsWriter, size, err := d.rewrite(fullPath, contents)
+			d.writes.record(err)
+			if err != nil {
+				return nil, err
+			}
+			if err := d.unpack(path); err != nil {
+				hdfsWriter.Close()
+				return nil, err
+			}
+			return d.newFileWriter(hdfsWriter, path, fullPath, size), nil
+		}
+	}
+	d.localCache.invalidate(fullPath)
+
+	reader, err := d.hdfsClient.Open(fullPath)
+	if err != nil {
+		// Another writer may create the file after the probe, which only
+		// appends and noclobber blobs must not replace
+		var hdfsWriter hdfsFileWriter
+		if append || (d.noClobber && isBlobData(path)) {
+			hdfsWriter, err = d.create(fullPath)
+		} else {
+			hdfsWriter, err = d.overwrite(fullPath, false)
+		}
+		d.writes.record(err)
+		if d.noClobber && os.IsExist(err) && isBlobData(path) {
+			return nil, NoClobberError{Path: path}
+		} else if err != nil {
+			return nil, err
+		}
+		return d.newFileWriter(hdfsWriter, path, fullPath, 0), nil
+	} else if reader.Stat().IsDir() {
+		// Appending to a directory fails obscurely, and overwriting one
+		// would remove everything below it
+		reader.Close()
+		return nil, fmt.Errorf("cannot write to %s: it is a directory", path)
+	} else {
+		if !append {
+			if d.noClobber && isBlobData(path) {
+				reader.Close()
+				return nil, NoClobberError{Path: path}
+			}
+			// The probe reader would otherwise keep the replaced file open
+			reader.Close()
+			hdfsWriter, err := d.overwrite(fullPath, true)
+			d.writes.record(err)
+			if err != nil {
+				return nil, err
+			}
+			return d.newFileWriter(hdfsWriter, path, fullPath, 0), nil
+		} else {
+			// The probe may be stale by now, the size comes from the
+			// file as it is when the append starts. The file may also
+			// have been deleted since it was opened.
+			reader.Close()
+			fi, err := d.hdfsClient.Stat(fullPath)
+			if os.IsNotExist(err) {
+				return nil, storagedriver.PathNotFoundError{Path: path}
+			} else if err != nil {
+				return nil, err
+			}
+			if err := d.checkAppendable(fullPath); err != nil {
+				return nil, err
+			}
+			size := fi.Size()
+			hdfsWriter, err := d.appendRecoveringLease(fullPath)
+			if err == nil {
+				if size, err = appendPosition(hdfsWriter, size); err != nil {
+					hdfsWriter.Close()
+				}
+			}
+			d.writes.record(err)
+			if os.IsNotExist(err) {
+				return nil, storagedriver.PathNotFoundError{Path: path}
+			} else if err != nil && isAppendUnsupported(err) {
+				if !d.appendFallback {
+					return nil, appendUnsupportedError(path, err)
+				}
+				hdfsWriter, size, err := d.rewriteForAppend(fullPath)
+				if err != nil {
+					return nil, err
+				}
+				return d.keepModTime(d.newFileWriter(hdfsWriter, path, fullPath, size), fullPath, fi.ModTime()), nil
+			} else if err != nil {
+				return nil, err
+			}
+			return d.keepModTime(d.newFileWriter(hdfsWriter, path, fullPath, size), fullPath, fi.ModTime()), nil
+		}
+	}
+}
+
+// Stat retrieves the FileInfo for the given path, including the current
+// size in bytes and the creation time.
+func (d *driver) Stat(context context.Context, path string) (_ storagedriver.FileInfo, err error) {
+	defer d.classify(&err)
+	defer d.recentlyDeleted.check(path, &err)
+	defer d.slowOps.log(context, "Stat", path, time.Now())
+	defer d.readLog.log(context, "Stat", path, time.Now(), &err)
+	defer transfers.failed("Stat", &err)
+	defer d.recoverPanic(context, "Stat", &err)
+
+	if err := d.faults.inject("Stat"); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkClient(); err != nil {
+		return nil, err
+	}
+	fullPath, err := d.readPath(context, path)
+	if err != nil {
+		return nil, err
+	}
+	var packed storagedriver.FileInfo
+	if d.packs != nil {
+		info, ok, err := d.statPacked(path)
+		if err != nil {
+			return nil, err
+		} else if ok && !info.IsDir() {
+			return info, nil
+		} else if ok {
+			packed = info
+		}
+	}
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if os.IsNotExist(err) && packed != nil {
+		// The directory only holds packed objects
+		return packed, nil
+	}
+	if err != nil && d.uploadStateDirectory != "" && isUploadSession(path) {
+		// Sessions that have no data yet only exist in the state directory
+		fullPath = d.uploadStatePath(path)
+		fi, err = d.hdfsClient.Stat(fullPath)
+	}
+	var symlink string
+	if isUnresolvedLink(err) {
+		if fi, err = d.lstat(fullPath); err == nil && d.followSymlinks {
+			symlink = linkTarget(fi)
+			if fullPath, err = d.followLink(fullPath); err == nil {
+				fi, err = d.hdfsClient.Stat(fullPath)
+			}
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	} else if err != nil {
+		return nil, err
+	}
+
+	size := fi.Size()
+	if !fi.IsDir() {
+		header, err := d.compressionOf(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		if header != nil {
+			size = header.logicalSize
+		}
+	}
+
+	info := newFileInfo(storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:    path,
+		Size:    size,
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}}, fi)
+	if symlink != "" {
+		info.symlink = symlink
+	}
+	return info, nil
+}
+
+// List returns a list of the objects that are direct descendants of the
+// given path.
+func (d *driver) List(context context.Context, subPath string) (_ []string, err error) {
+	defer d.classify(&err)
+	defer d.recentlyDeleted.check(subPath, &err)
+	defer d.slowOps.log(context, "List", subPath, time.Now())
+	defer d.readLog.log(context, "List", subPath, time.Now(), &err)
+	defer transfers.failed("List", &err)
+	defer d.recoverPanic(context, "List", &err)
+
+	if err := d.faults.inject("List"); err != nil {
+		return nil, err
+	}
+
+	if err := d.checkClient(); err != nil {
+		return nil, err
+	}
+	entries, err := d.withRetryBudget().listEntries(context, subPath)
+	if err != nil {
+		return nil, err
+	}
+	if d.packs != nil {
+		packed, err := d.packedEntries(path.Clean("/"+subPath), entries)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, packed...)
+	}
+	return sortListEntries(entries, d.listSort), nil
+}
+
+// listEntries reads the direct descendants of subPath for List and ListInfo.
+// A directory that cannot be read lists as empty.
+func (d *driver) listEntries(context context.Context, subPath string) ([]listEntry, error) {
+	entries, err := d.readEntries(context, subPath)
+	if _, ok := err.(errUnreadableDirectory); ok {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// errUnreadableDirectory is returned by readEntries when the namenode
+// refused to list a directory, such as for lack of permission
+type errUnreadableDirectory struct {
+	err error
+}
+
+func (e errUnreadableDirectory) Error() string {
+	return e.err.Error()
+}
+
+// readEntries is listEntries, but reports directories that cannot be read
+func (d *driver) readEntries(context context.Context, subPath string) ([]listEntry, error) {
+	d = d.withOptions(context)
+
+	// The empty path is the root, whose entries are reported as "/name"
+	if subPath == "" {
+		subPath = "/"
+	}
+	fullPath, err := d.readPath(context, subPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// ReadDir on a file fails, which would otherwise look like an empty
+	// directory to the caller
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if err == nil && !fi.IsDir() {
+		return nil, fmt.Errorf("cannot list %s: not a directory", subPath)
+	}
+	return d.readDirEntries(subPath, fullPath, err == nil)
+}
+
+// readDirEntries is readEntries for subPath stored at fullPath, which the
+// caller knows to be a directory when isDir is set
+func (d *driver) readDirEntries(subPath, fullPath string, isDir bool) ([]listEntry, error) {
+	fileInfos, err := d.readDirWithRetry(fullPath, isDir)
+	mergeUploadState := d.uploadStateDirectory != "" && isUploadSession(subPath)
+	if err != nil && !mergeUploadState {
+		return nil, errUnreadableDirectory{err}
+	}
+
+	entries := make([]listEntry, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		if entries, err = d.appendEntries(entries, subPath, fullPath, fileInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	// Sessions list their metadata next to their data
+	if mergeUploadState {
+		listed := make(map[string]bool, len(entries))
+		for _, entry := range entries {
+			listed[entry.path] = true
+		}
+		statePath := d.uploadStatePath(subPath)
+		if stateInfos, err := d.readDir(statePath); err == nil {
+			for _, fileInfo := range stateInfos {
+				if entryPath := path.Join(subPath, fileInfo.Name()); !listed[entryPath] {
+					entries = append(entries, listEntry{path: entryPath, fullPath: path.Join(statePath, fileInfo.Name()), info: fileInfo})
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+// appendEntries appends what the child fileInfo of the directory at
+// fullPath lists as to entries
+func (d *driver) appendEntries(entries []listEntry, subPath, fullPath string, fileInfo os.FileInfo) ([]listEntry, error) {
+	// Directories inserted by the path transform are flattened into
+	// their parent
+	if d.pathTransform != nil && fileInfo.IsDir() && d.pathTransform.isIntermediate(fileInfo.Name()) {
+		flattened, err := d.listIntermediate(subPath, fullPath, fileInfo.Name())
+		if err != nil {
+			return nil, err
+		}
+		return append(entries, flattened...), nil
+	}
+	if d.isTemporaryFile(fileInfo.Name()) || (d.packs != nil && fileInfo.Name() == packFileName) || (fullPath == d.hdfsRootDirectory && (fileInfo.Name() == rootMarkerName || fileInfo.Name() == lockDirectoryName)) {
+		return entries, nil
+	}
+	return append(entries, listEntry{path: path.Join(subPath, fileInfo.Name()), fullPath: path.Join(fullPath, fileInfo.Name()), info: fileInfo}), nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the
+// original object. HDFS renames are atomic, between directories too, so
+// the registry finishing an upload never exposes a partial blob. Only moves
+// between encryption zones, which HDFS refuses to rename, degrade to
+// copyMove, whose destination appears atomically but before the source
+// goes away.
+func (d *driver) Move(context context.Context, sourcePath string, destPathstring string) (err error) {
+	defer d.classify(&err)
+	defer d.recentlyDeleted.check(sourcePath, &err)
+	defer d.slowOps.log(context, "Move", sourcePath, time.Now())
+	defer transfers.failed("Move", &err)
+	defer d.recoverPanic(context, "Move", &err)
+
+	if err := d.faults.inject("Move"); err != nil {
+		return err
+	}
+
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	d = d.withOptions(context)
+	if err := d.writes.allow(); err != nil {
+		return err
+	}
+	if err := d.checkPathLength(destPathstring); err != nil {
+		return err
+	}
+	d.recentlyDeleted.forget(destPathstring)
+	source, dest := d.fullPath(sourcePath), d.fullPath(destPathstring)
+	if err := d.checkNoClobber(destPathstring, dest); err != nil {
+		return err
+	}
+	defer d.contentCache.invalidate(source)
+	defer d.contentCache.invalidate(dest)
+	defer d.localCache.invalidate(source)
+	defer d.localCache.invalidate(dest)
+	if contents, ok, err := d.getPacked(sourcePath); err != nil {
+		return err
+	} else if ok {
+		return d.movePacked(context, sourcePath, destPathstring, contents)
+	}
+	if err := d.unpack(destPathstring); err != nil {
+		return err
+	}
+	upload := d.uploadSize(sourcePath)
+	d.makeParentDir(dest)
+	err = d.hdfsClient.Rename(source, dest)
+	if isCrossZoneRename(err) {
+		err = d.copyMove(context, source, dest)
+	}
+	d.writes.record(err)
+	if err != nil {
+		return err
+	}
+
+	if _, repository := repositoryOf(destPathstring); repository == "" {
+		d.releaseQuota(sourcePath, upload)
+	}
+
+	// Uploads moved into place keep their staging replication otherwise
+	if replication := d.replicationFor(dest); replication != d.replicationFor(source) {
+		if err := d.setReplication(dest, replication); err != nil {
+			log.Printf("hdfs: unable to set replication of %s to %d: %v", dest, replication, err)
+		}
+	}
+	d.audit.recordMove(d, context, sourcePath, destPathstring)
+	d.changes.moved(sourcePath, destPathstring)
+	return nil
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths.
+// With ClientOptions.Recursive set to false, directories with entries are
+// kept and a DirectoryNotEmptyError returned instead. With pruneempty the
+// parent directories left empty are removed as well.
+func (d *driver) Delete(context context.Context, path string) (err error) {
+	defer d.classify(&err)
+	defer d.slowOps.log(context, "Delete", path, time.Now())
+	defer transfers.failed("Delete", &err)
+	defer d.recoverPanic(context, "Delete", &err)
+
+	if err := d.faults.inject("Delete"); err != nil {
+		return err
+	}
+
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	if err := d.writes.allow(); err != nil {
+		return err
+	}
+	d = d.withOptions(context)
+	if d.nonRecursiveDelete {
+		if err := d.checkEmpty(path); err != nil {
+			return err
+		}
+	}
+	if err := d.guardDelete(path); err != nil {
+		return err
+	}
+	unpacked, err := d.deletePacked(path)
+	if err != nil {
+		return err
+	}
+	upload := d.uploadSize(path)
+	err = d.hdfsClient.Remove(d.fullPath(path))
+	if unpacked && os.IsNotExist(err) {
+		err = nil
+	}
+	d.writes.record(err)
+	d.contentCache.invalidate(d.fullPath(path))
+	d.localCache.invalidate(d.fullPath(path))
+	if err == nil {
+		d.releaseQuota(path, upload)
+		d.audit.recordDelete(d, context, path)
+		d.changes.deleted(path)
+		d.recentlyDeleted.record(path)
+	}
+	if err == nil && d.pruneEmpty {
+		d.pruneEmptyParents(d.fullPath(path))
+	}
+
+	// Deleting a session, or anything containing one, takes its metadata
+	// along
+	if d.uploadStateDirectory != "" && !isUploadMetadata(path) {
+		stateErr := d.hdfsClient.Remove(d.uploadStatePath(path))
+		if stateErr == nil && os.IsNotExist(err) {
+			err = nil
+		} else if stateErr != nil && !os.IsNotExist(stateErr) && err == nil {
+			err = stateErr
+		}
+	}
+	if os.IsNotExist(err) {
+		// Concurrent garbage collectors may race to delete the same path
+		if d.bestEffortDelete {
+			return nil
+		}
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+	return err
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at
+// the given path, possibly using the given options.
+// When WebHDFS is configured the URL points at the WebHDFS OPEN operation and
+// carries a delegation token which is cancelled once the expiry has passed.
+// Any failure to obtain a token falls back to ErrUnsupportedMethod so the
+// registry serves the content itself. The contenttype option is a hint for
+// the Content-Type of the response, see openURL.
+func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (_ string, err error) {
+	defer d.classify(&err)
+	defer d.slowOps.log(ctx, "URLFor", path, time.Now())
+	defer transfers.failed("URLFor", &err)
+	defer d.recoverPanic(ctx, "URLFor", &err)
+
+	if err := d.faults.inject("URLFor"); err != nil {
+		return "", err
+	}
+
+	if d.webHdfs == nil {
+		return "", storagedriver.ErrUnsupportedMethod{}
+	}
+
+	methodString := "GET"
+	method, ok := options["method"]
+	if ok {
+		methodString, ok = method.(string)
+		if !ok || (methodString != "GET" && methodString != "HEAD") {
+			return "", storagedriver.ErrUnsupportedMethod{}
+		}
+	}
+
+	expiresIn := defaultURLForExpiry
+	expires, ok := options["expiry"]
+	if ok {
+		et, ok := expires.(time.Time)
+		if ok {
+			expiresIn = et.Sub(time.Now())
+		}
+	}
+	if expiresIn <= 0 {
+		return "", storagedriver.ErrUnsupportedMethod{}
+	}
+
+	var contentType string
+	if hint, ok := options["contenttype"]; ok {
+		contentType, ok = hint.(string)
+		if !ok {
+			return "", storagedriver.ErrUnsupportedMethod{}
+		}
+	}
+
+	token, err := d.webHdfs.getDelegationToken()
+	if err != nil {
+		context.GetLogger(ctx).Warnf("hdfs: unable to get WebHDFS delegation token: %v", err)
+		return "", storagedriver.ErrUnsupportedMethod{}
+	}
+	d.webHdfs.cancelDelegationTokenAfter(ctx, token, expiresIn)
+
+	return d.webHdfs.openURL(d.fullPath(path), token, contentType), nil
+}
+
+// Implement the storagedriver.FileWriter interface
+type fileWriter struct {
+	hdfsWriter       hdfsFileWriter
+	filePath         string
+	isClosed         bool
+	writeSize        int64
+	startingFileSize int64
+	pool             *bufferPool
+	breaker          *writeBreaker
+
+	// transferOp is the operation written bytes are counted for
+	transferOp string
+
+	// verify, when set, is called by Commit with the size written
+	verify func(size int64) error
+
+	// awaitSize, when set, is called by Commit with the size written and
+	// returns once Stat reports it
+	awaitSize func(size int64) error
+
+	// reserve, when set, is called by Write with the bytes to write and
+	// refuses them with an error. unreserve gives back those that were
+	// not written.
+	reserve   func(n int64) error
+	unreserve func(n int64)
+
+	// restoreModTime, when set, is called by Commit once the file is
+	// closed to set its modification time back
+	restoreModTime func() error
+
+	// commitErr is the error of a failed Commit, which later calls return
+	// rather than finding the file closed and reporting success
+	commitErr error
+
+	// release, when set, is called once by Close to give back the
+	// maxconcurrentuploads slot of the writer
+	release func()
+
+	// stopFlushing, when set, stops the flushinterval timer
+	stopFlushing func()
+
+	// closeTimeout bounds closing hdfsWriter when positive, see
+	// closeHdfsWriter
+	closeTimeout time.Duration
+
+	// audit, when set, is called once by a successful Commit with the size
+	// of the file
+	audit func(size int64)
+
+	// checkDigest, when set, is called by Commit to check the content
+	// against the digest of the blob path, see digestChecker. digester,
+	// when set, hashes what is written for it.
+	checkDigest func() error
+	digester    digest.Digester
+}
+
+// newFileWriter returns the FileWriter for hdfsWriter, applying the
+// writebandwidth, verifywrites and repositoryquota parameters
+func (d *driver) newFileWriter(hdfsWriter hdfsFileWriter, subPath, fullPath string, startingFileSize int64) *fileWriter {
+	flushing := d.flushPeriodically(hdfsWriter)
+	w := newFileWriter(d.throttleWriter(flushing), fullPath, startingFileSize, d.bufferPool)
+	w.breaker = d.writes
+	w.closeTimeout = d.closeTimeout
+	if f, ok := flushing.(*flushingWriter); ok {
+		w.stopFlushing = f.halt
+	}
+	if d.quota != nil {
+		w.reserve = func(n int64) error {
+			return d.reserveQuota(subPath, n)
+		}
+		w.unreserve = func(n int64) {
+			d.releaseQuota(subPath, n)
+		}
+	}
+	if d.commitStatTimeout > 0 {
+		w.awaitSize = func(size int64) error {
+			return d.awaitSize(fullPath, size)
+		}
+	}
+	w.checkDigest = d.digestChecker(w, subPath, fullPath)
+	if d.verifyWrites {
+		w.verify = func(size int64) error {
+			fi, err := d.hdfsClient.Stat(fullPath)
+			if err != nil {
+				return err
+			}
+			if fi.Size() != size {
+				return fmt.Errorf("verifywrites: %s is %d bytes after commit but %d bytes were written", fullPath, fi.Size(), size)
+			}
+			return nil
+		}
+	}
+	return w
+}
+
+func newFileWriter(hdfsWriter hdfsFileWriter, filePath string, startingFileSize int64, pool *bufferPool) *fileWriter {
+	return &fileWriter{
+		hdfsWriter:       hdfsWriter,
+		filePath:         filePath,
+		startingFileSize: startingFileSize,
+		pool:             pool,
+		transferOp:       "Writer",
+	}
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if w.reserve != nil {
+		if err := w.reserve(int64(len(p))); err != nil {
+			return 0, err
+		}
+	}
+	w.Size()
+	n, err := w.hdfsWriter.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		w.breaker.record(err)
+		if w.unreserve != nil {
+			w.unreserve(int64(len(p) - n))
+		}
+	}
+	w.isClosed = false
+	w.writeSize += int64(n)
+	if w.digester != nil {
+		w.digester.Hash().Write(p[:n])
+	}
+	transfers.wrote(w.transferOp, n)
+	return n, err
+}
+
+// Flush sends any bytes buffered by the writer to the datanodes without
+// closing the file, so that acknowledged bytes survive a registry crash and
+// are visible to other readers. The writer remains open for further writes.
+func (w *fileWriter) Flush() error {
+	if w.isClosed {
+		return fmt.Errorf("already closed")
+	}
+	return w.hdfsWriter.Flush()
+}
+
+// ReadFrom implements io.ReaderFrom so that io.Copy into the writer uses a
+// pooled transfer buffer.
+func (w *fileWriter) ReadFrom(r io.Reader) (int64, error) {
+	return w.pool.copy(w, r)
+}
+
+// Close the client connection. Errors closing the file are logged, except
+// for a close that timed out, which is returned since the file is left open.
+func (w *fileWriter) Close() error {
+	w.Size()
+	if w.release != nil {
+		w.release()
+		w.release = nil
+	}
+	if w.hdfsWriter != nil {
+		if !w.isClosed {
+			w.isClosed = true
+			err := w.closeHdfsWriter()
+			w.breaker.record(err)
+			if _, ok := err.(CloseTimeoutError); ok {
+				return err
+			} else if err != nil {
+				log.Print(err)
+			}
+
+		}
+	}
+	return nil
+}
+
+// Size returns the number of bytes written to this FileWriter.
+func (w *fileWriter) Size() int64 {
 	if w.startingFileSize > 0 {
 		w.writeSize += w.startingFileSize
 		w.startingFileSize = 0
@@ -334,31 +2977,343 @@ func (w *fileWriter) Size() int64 {
 
 // Cancel removes any written content from this FileWriter.
 func (w *fileWriter) Cancel() error {
+	if w.stopFlushing != nil {
+		w.stopFlushing()
+	}
 	return nil
 }
 
 // Commit flushes all content written to this FileWriter and makes it
 // available for future calls to StorageDriver.GetContent and
 // StorageDriver.Reader.
+// With commitstattimeout or verifywrites the file is closed here, since the
+// namenode only knows the final length of a closed file. Commit then waits
+// for Stat to report the size written, and verifywrites checks it.
 func (w *fileWriter) Commit() error {
-	return nil
+	if w.commitErr == nil && w.audit != nil {
+		defer func() {
+			if w.commitErr == nil {
+				w.audit(w.Size())
+				w.audit = nil
+			}
+		}()
+	}
+	if w.checkDigest != nil && w.commitErr == nil {
+		if w.commitErr = w.checkDigest(); w.commitErr != nil {
+			return w.commitErr
+		}
+	}
+	if (w.verify == nil && w.restoreModTime == nil && w.awaitSize == nil) || w.commitErr != nil {
+		return w.commitErr
+	}
+	if !w.isClosed {
+		w.isClosed = true
+		if err := w.closeHdfsWriter(); err != nil {
+			w.commitErr = err
+			return err
+		}
+	}
+	if w.awaitSize != nil {
+		if w.commitErr = w.awaitSize(w.Size()); w.commitErr != nil {
+			return w.commitErr
+		}
+	}
+	if w.verify != nil {
+		if w.commitErr = w.verify(w.Size()); w.commitErr != nil {
+			return w.commitErr
+		}
+	}
+	if w.restoreModTime != nil {
+		w.commitErr = w.restoreModTime()
+	}
+	return w.commitErr
 }
 
 //
 // Utils
 //
 
-// fullPath returns the full path to the file
+// isCrossZoneRename reports whether the namenode refused a rename because
+// it would move a file between encryption zones
+func isCrossZoneRename(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "encryption zone")
+}
+
+// copyMove moves a file by copying and deleting it, for renames HDFS does
+// not allow. The copy is written to a staging file next to dest and renamed
+// over it, so readers of dest see the previous file or the whole copy, but
+// never part of it. The move as a whole is not atomic: the source is only
+// removed after the copy is in place. The modification time of the source
+// is carried over so that age-based decisions such as upload purging see
+// the original time. The copy counts against writebandwidth and stops when
+// ctx is cancelled, in which case the staging file is removed.
+func (d *driver) copyMove(ctx context.Context, source, dest string) error {
+	reader, err := d.open(source)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	fi := reader.Stat()
+	if fi.IsDir() {
+		return fmt.Errorf("cannot move directory %s across encryption zones", source)
+	}
+
+	staging := d.stagingPath(dest)
+	writer, err := d.create(staging)
+	if err != nil {
+		return err
+	}
+	removeStaging := func() {
+		if rerr := d.hdfsClient.Remove(staging); rerr != nil && !os.IsNotExist(rerr) {
+			log.Printf("hdfs: unable to remove partial copy %s: %v", staging, rerr)
+		}
+	}
+	if _, err := d.bufferPool.copyContext(ctx, d.throttleWriter(writer), reader); err != nil {
+		writer.Close()
+		removeStaging()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		removeStaging()
+		return err
+	}
+
+	if err := d.hdfsClient.Chtimes(staging, time.Now(), fi.ModTime()); err != nil {
+		removeStaging()
+		return err
+	}
+	if err := d.hdfsClient.Rename(staging, dest); err != nil {
+		removeStaging()
+		return err
+	}
+	return d.hdfsClient.Remove(source)
+}
+
+// isStagingPath reports whether fullPath belongs to an in-progress upload
+func isStagingPath(fullPath string) bool {
+	return strings.Contains(fullPath, "/_uploads/")
+}
+
+// replicationFor returns the replication factor for files created at
+// fullPath, 0 for the cluster default.
+func (d *driver) replicationFor(fullPath string) int {
+	if d.stagingReplication > 0 && isStagingPath(fullPath) {
+		return d.stagingReplication
+	}
+	return d.replication
+}
+
+// create creates the file at fullPath with the replication configured for
+// it. Explicit replication needs an explicit block size too, so those files
+// use defaultBlockSize rather than the cluster's dfs.blocksize.
+func (d *driver) create(fullPath string) (hdfsFileWriter, error) {
+	var writer hdfsFileWriter
+	err := errUnsupportedByClient
+	replication := d.replicationFor(fullPath)
+	var blockSize int64
+	if replication != 0 {
+		blockSize = defaultBlockSize
+	}
+	if len(d.favoredNodes) > 0 {
+		// The favored nodes RPC does not create parents
+		d.makeParentDir(fullPath)
+		writer, err = d.createWithFavoredNodes(fullPath, replication, blockSize)
+		if err == errWebHdfsRequired {
+			d.favoredNodesUnsupported.Do(func() {
+				log.Printf("hdfs: the client cannot pass favorednodes to the namenode without WebHDFS, files are placed by the namenode alone")
+			})
+			err = errUnsupportedByClient
+		}
+	} else if d.createParents {
+		writer, err = createWithParents(d.hdfsClient, fullPath, replication, blockSize, defaultFileMode)
+	}
+	if err == errUnsupportedByClient {
+		if len(d.favoredNodes) == 0 {
+			d.makeParentDir(fullPath)
+		}
+		if replication == 0 {
+			writer, err = d.hdfsClient.Create(fullPath)
+		} else {
+			writer, err = d.hdfsClient.CreateFile(fullPath, replication, blockSize, defaultFileMode)
+		}
+	}
+	if err != nil {
+		return nil, createError(fullPath, err)
+	}
+	if _, ok := writer.(*webHdfsWriter); !ok {
+		d.applyStoragePolicy(fullPath)
+	}
+	return writer, nil
+}
+
+// permissionDirectories are the directories below the root directory that
+// fixpermissions corrects, next to the root itself
+var permissionDirectories = []string{
+	"docker",
+	"docker/registry",
+	"docker/registry/v2",
+	"docker/registry/v2/blobs",
+	"docker/registry/v2/repositories",
+}
+
+// fixPermissions changes the mode of the root directory and the registry
+// directories below it to directoryumask, or their mode in directorymodes,
+// where they differ. Directories that do not exist yet are skipped; they
+// get the right mode when created.
+func (d *driver) fixPermissions() error {
+	dirs := []string{d.hdfsRootDirectory}
+	for _, dir := range permissionDirectories {
+		dirs = append(dirs, path.Join(d.hdfsRootDirectory, dir))
+	}
+
+	for _, dir := range dirs {
+		fi, err := d.hdfsClient.Stat(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		mode := d.directoryMode(dir)
+		if !fi.IsDir() || fi.Mode().Perm() == mode {
+			continue
+		}
+		log.Printf("hdfs: changing mode of %s from %#o to %#o", dir, fi.Mode().Perm(), mode)
+		if err := d.hdfsClient.Chmod(dir, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errClientNotInitialized is returned by every operation of a driver
+// that has no HDFS client, instead of a nil pointer panic.
+var errClientNotInitialized = fmt.Errorf("hdfs client not initialized")
+
+// checkClient guards the operations that talk to HDFS
+func (d *driver) checkClient() error {
+	if d.hdfsClient == nil {
+		return errClientNotInitialized
+	}
+	return nil
+}
+
+// fullPath returns the full path to the file. Paths are cleaned first, so
+// "foo", "/foo" and "/foo/" all name the same file, and "/" or "." is the
+// root itself. Every other path is below the root, including one that
+// happens to repeat the root's name: with a root of /registry, /registry
+// is /registry/registry. Dot files, such as the markers next to the
+// registry data, are stored under their own name. Paths in the upload
+// state directory, which is outside the root, are returned as is.
 func (d *driver) fullPath(subPath string) string {
-	if strings.HasPrefix(subPath, d.hdfsRootDirectory) {
+	subPath = path.Clean("/" + subPath)
+	if d.inUploadStateDirectory(subPath) {
 		return subPath
 	}
+	if d.uploadStateDirectory != "" && isUploadMetadata(subPath) {
+		return d.uploadStatePath(subPath)
+	}
+	if d.pathTransform != nil {
+		subPath = d.pathTransform.transform(subPath)
+	}
 	return path.Join(d.hdfsRootDirectory, subPath)
 }
 
-// creates the parent directory with the default umask
-func (d *driver) makeParentDir(subPath string) error {
-	if err := d.hdfsClient.MkdirAll(path.Dir(d.fullPath(subPath)), os.FileMode(d.directoryUmask)); err != nil {
+// getParameterAsInt64 reads an integer parameter, falling back to defaultt
+// when it is unset and enforcing the inclusive [min, max] range.
+func getParameterAsInt64(parameters map[string]interface{}, name string, defaultt int64, min int64, max int64) (int64, error) {
+	rv := defaultt
+	param := parameters[name]
+	switch v := param.(type) {
+	case string:
+		vv, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s parameter must be an integer, %v invalid", name, param)
+		}
+		rv = vv
+	case int64:
+		rv = v
+	case int, uint, int32, uint32, uint64:
+		rv = reflect.ValueOf(v).Convert(reflect.TypeOf(rv)).Int()
+	case nil:
+		// do nothing
+	default:
+		return 0, fmt.Errorf("invalid value for %s: %#v", name, param)
+	}
+
+	if rv < min || rv > max {
+		return 0, fmt.Errorf("The %s %#v parameter should be a number between %d and %d (inclusive)", name, rv, min, max)
+	}
+
+	return rv, nil
+}
+
+// getParameterAsDuration reads a duration parameter given either as a
+// time.Duration or as a string such as "10s", falling back to defaultt when
+// it is unset.
+func getParameterAsDuration(parameters map[string]interface{}, name string, defaultt time.Duration) (time.Duration, error) {
+	switch v := parameters[name].(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("The %s parameter should be a positive duration such as 10s", name)
+		}
+		return d, nil
+	case time.Duration:
+		return v, nil
+	case nil:
+		return defaultt, nil
+	default:
+		return 0, fmt.Errorf("The %s parameter should be a positive duration such as 10s", name)
+	}
+}
+
+// getParameterAsBool reads a boolean parameter given either as a bool or as
+// a string, falling back to defaultt when it is unset.
+func getParameterAsBool(parameters map[string]interface{}, name string, defaultt bool) (bool, error) {
+	switch v := parameters[name].(type) {
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("The %s parameter should be a boolean", name)
+		}
+		return b, nil
+	case bool:
+		return v, nil
+	case nil:
+		return defaultt, nil
+	default:
+		return false, fmt.Errorf("The %s parameter should be a boolean", name)
+	}
+}
+
+// makeParentDir creates the directory the file at fullPath goes into, but
+// not fullPath itself; mkdirAll creates paths that are directories.
+func (d *driver) makeParentDir(fullPath string) error {
+	return d.mkdir(path.Dir(fullPath))
+}
+
+// mkdirAll creates the directory subPath along with its missing parents
+func (d *driver) mkdirAll(subPath string) error {
+	return d.mkdir(d.fullPath(subPath))
+}
+
+// mkdir creates the directory at fullPath and its parents with the
+// default umask, or the modes of directorymodes. Pushes into a new
+// repository create the same directories concurrently, so a directory that
+// appeared underneath MkdirAll is as good as one it created.
+func (d *driver) mkdir(dir string) error {
+	var err error
+	if len(d.directoryModes) > 0 {
+		err = d.mkdirLevels(dir)
+	} else {
+		err = d.hdfsClient.MkdirAll(dir, os.FileMode(d.directoryUmask))
+	}
+	if err != nil {
+		if os.IsExist(err) {
+			if fi, serr := d.hdfsClient.Stat(dir); serr == nil && fi.IsDir() {
+				return nil
+			}
+		}
 		return err
 	}
 	return nil
@@ -0,0 +1,886 @@
+package hdfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colinmarc/hdfs"
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/testsuites"
+	netcontext "golang.org/x/net/context"
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { check.TestingT(t) }
+
+var hdfsDriverConstructor func(rootDirectory string) (storagedriver.StorageDriver, error)
+var skipHdfs func() string
+
+func init() {
+	namenode := os.Getenv("HDFS_NAMENODE")
+	user := os.Getenv("HDFS_USER")
+	if user == "" {
+		user = defaultHdfsUser
+	}
+
+	// Skip HDFS storage driver tests if environment variable parameters are not provided
+	skipHdfs = func() string {
+		if namenode == "" {
+			return "The following environment variables must be set to enable these tests: HDFS_NAMENODE"
+		}
+		return ""
+	}
+
+	hdfsDriverConstructor = func(rootDirectory string) (storagedriver.StorageDriver, error) {
+		return FromParameters(map[string]interface{}{
+			"hdfsnamenode":      namenode,
+			"hdfsuser":          user,
+			"hdfsrootdirectory": rootDirectory,
+		})
+	}
+
+	testsuites.RegisterSuite(func() (storagedriver.StorageDriver, error) {
+		return hdfsDriverConstructor(randomRootDirectory())
+	}, skipHdfs)
+}
+
+// randomRootDirectory returns a fresh HDFS root so test runs don't collide
+func randomRootDirectory() string {
+	return fmt.Sprintf("/tmp/hdfs-registry-test-%d-%d", time.Now().UnixNano(), rand.Int63())
+}
+
+func newLiveTestDriver(t *testing.T) storagedriver.StorageDriver {
+	if reason := skipHdfs(); reason != "" {
+		t.Skip(reason)
+	}
+	d, err := hdfsDriverConstructor(randomRootDirectory())
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+	return d
+}
+
+func TestZeroLengthFile(t *testing.T) {
+	d := newLiveTestDriver(t)
+	ctx := context.Background()
+	filename := "/empty/config"
+	defer d.Delete(ctx, "/empty")
+
+	if err := d.PutContent(ctx, filename, []byte{}); err != nil {
+		t.Fatalf("unexpected error writing zero-length file: %v", err)
+	}
+
+	contents, err := d.GetContent(ctx, filename)
+	if err != nil {
+		t.Fatalf("unexpected error reading zero-length file: %v", err)
+	}
+	if contents == nil || len(contents) != 0 {
+		t.Fatalf("expected an empty non-nil slice, got %#v", contents)
+	}
+
+	reader, err := d.Reader(ctx, filename, 0)
+	if err != nil {
+		t.Fatalf("unexpected error opening zero-length file at offset 0: %v", err)
+	}
+	defer reader.Close()
+	read, err := ioutil.ReadAll(reader)
+	if err != nil || len(read) != 0 {
+		t.Fatalf("expected an immediate EOF, got %d bytes and error %v", len(read), err)
+	}
+}
+
+func TestFlushMakesBytesVisibleBeforeCommit(t *testing.T) {
+	root := randomRootDirectory()
+	if reason := skipHdfs(); reason != "" {
+		t.Skip(reason)
+	}
+	writerDriver, err := hdfsDriverConstructor(root)
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+	readerDriver, err := hdfsDriverConstructor(root)
+	if err != nil {
+		t.Fatalf("unexpected error creating second driver: %v", err)
+	}
+
+	ctx := context.Background()
+	filename := "/flush/data"
+	defer writerDriver.Delete(ctx, "/flush")
+
+	writer, err := writerDriver.Writer(ctx, filename, false)
+	if err != nil {
+		t.Fatalf("unexpected error opening writer: %v", err)
+	}
+	defer writer.Close()
+
+	contents := []byte("acknowledged bytes")
+	if _, err := writer.Write(contents); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	flusher, ok := writer.(interface {
+		Flush() error
+	})
+	if !ok {
+		t.Fatal("file writer does not implement Flush")
+	}
+	if err := flusher.Flush(); err != nil {
+		t.Fatalf("unexpected error flushing: %v", err)
+	}
+
+	reader, err := readerDriver.Reader(ctx, filename, 0)
+	if err != nil {
+		t.Fatalf("unexpected error opening flushed file from a second client: %v", err)
+	}
+	defer reader.Close()
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("unexpected error reading flushed file: %v", err)
+	}
+	if string(read) != string(contents) {
+		t.Fatalf("expected flushed bytes %q to be visible, got %q", contents, read)
+	}
+
+	if _, err := writer.Write([]byte(" and more")); err != nil {
+		t.Fatalf("writer should remain usable after Flush: %v", err)
+	}
+}
+
+func TestListOnFile(t *testing.T) {
+	d := newLiveTestDriver(t)
+	ctx := context.Background()
+	defer d.Delete(ctx, "/list")
+
+	if err := d.PutContent(ctx, "/list/file", []byte("contents")); err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+
+	entries, err := d.List(ctx, "/list/file")
+	if err == nil {
+		t.Fatalf("expected an error listing a file, got entries %v", entries)
+	}
+	if !strings.Contains(err.Error(), "not a directory") {
+		t.Fatalf("expected a descriptive error listing a file, got %v", err)
+	}
+}
+
+func TestNewWithClient(t *testing.T) {
+	if _, err := NewWithClient(nil, DriverParameters{}); err == nil {
+		t.Fatal("expected an error for a nil client")
+	}
+
+	// The namenode address is never dialed when a client is supplied
+	client := &hdfs.Client{}
+	sd, err := NewWithClient(client, DriverParameters{
+		HdfsNameNode:      "namenode.invalid:8020",
+		HdfsRootDirectory: "/registry",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error constructing driver with a client: %v", err)
+	}
+
+	d := sd.inner()
+	if d.hdfsClient.(colinmarcClient).Client != client {
+		t.Fatal("driver does not use the supplied client")
+	}
+	if d.hdfsUser != defaultHdfsUser || d.bufferPool == nil {
+		t.Fatalf("driver defaults were not applied: %+v", d)
+	}
+}
+
+func TestPutContentSizeLimit(t *testing.T) {
+	d := newTestDriver(newFakeClient())
+	d.maxPutContentSize = 16
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/limit/small", make([]byte, 16)); err != nil {
+		t.Fatalf("unexpected error writing content at the limit: %v", err)
+	}
+	err := d.PutContent(ctx, "/limit/large", make([]byte, 17))
+	if err == nil || !strings.Contains(err.Error(), "maxputcontentsize") {
+		t.Fatalf("expected a maxputcontentsize error, got %v", err)
+	}
+	if _, err := d.Stat(ctx, "/limit/large"); !isPathNotFound(err) {
+		t.Fatalf("oversized content must not be written, Stat returned %v", err)
+	}
+}
+
+func TestUnsetDirectoryUmaskFallsBackToDefault(t *testing.T) {
+	client := newFakeClient()
+	d, err := newDriver(client, DriverParameters{HdfsRootDirectory: "/registry", DirectoryUmask: 0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/repo/manifest", []byte("contents")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	fi, err := client.Stat("/registry/repo")
+	if err != nil {
+		t.Fatalf("unexpected error from Stat: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != defaultDirectoryUmask {
+		t.Fatalf("expected directory mode %#o, got %#o", defaultDirectoryUmask, perm)
+	}
+	if _, err := d.GetContent(ctx, "/repo/manifest"); err != nil {
+		t.Fatalf("unexpected error from GetContent: %v", err)
+	}
+
+	// Modes that are set have to be valid
+	if _, err := newDriver(client, DriverParameters{HdfsRootDirectory: "/registry", DirectoryUmask: 01000}); err == nil || !strings.Contains(err.Error(), "directoryumask") {
+		t.Fatalf("expected an invalid directoryumask to be rejected, got %v", err)
+	}
+}
+
+func TestNilClientReturnsGuardError(t *testing.T) {
+	d, err := newDriver(nil, DriverParameters{HdfsRootDirectory: "/registry", MaxOpsPerSecond: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := context.Background()
+
+	checks := map[string]error{}
+	_, checks["GetContent"] = d.GetContent(ctx, "/file")
+	checks["PutContent"] = d.PutContent(ctx, "/file", []byte("contents"))
+	_, checks["Reader"] = d.Reader(ctx, "/file", 0)
+	_, checks["Writer"] = d.Writer(ctx, "/file", false)
+	_, checks["Stat"] = d.Stat(ctx, "/file")
+	_, checks["List"] = d.List(ctx, "/")
+	checks["Move"] = d.Move(ctx, "/file", "/other")
+	checks["Delete"] = d.Delete(ctx, "/file")
+
+	for method, err := range checks {
+		if err != errClientNotInitialized {
+			t.Errorf("expected %s to return the guard error, got %v", method, err)
+		}
+	}
+}
+
+func TestFixPermissions(t *testing.T) {
+	client := newFakeClient()
+	client.MkdirAll("/registry/docker/registry/v2/blobs", 0700)
+	client.MkdirAll("/registry/docker/registry/v2/repositories/foo", 0700)
+
+	newTestDriverWithParameters(client, DriverParameters{DirectoryUmask: 0750, FixPermissions: true})
+
+	for _, dir := range []string{"/registry", "/registry/docker/registry/v2/blobs", "/registry/docker/registry/v2/repositories"} {
+		fi, err := client.Stat(dir)
+		if err != nil {
+			t.Fatalf("unexpected error from Stat: %v", err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0750 {
+			t.Errorf("expected %s to have mode 0750, got %#o", dir, perm)
+		}
+	}
+	// Only the registry's own directories are corrected
+	if fi, _ := client.Stat("/registry/docker/registry/v2/repositories/foo"); fi.Mode().Perm() != 0700 {
+		t.Errorf("expected repository directories to be left alone")
+	}
+}
+
+func TestFixPermissionsDisabled(t *testing.T) {
+	client := newFakeClient()
+	client.MkdirAll("/registry/docker", 0700)
+
+	newTestDriverWithParameters(client, DriverParameters{DirectoryUmask: 0750})
+
+	if client.callCount("Chmod") != 0 {
+		t.Fatalf("expected no Chmod calls without fixpermissions")
+	}
+	if fi, _ := client.Stat("/registry/docker"); fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected the mode to be left alone")
+	}
+}
+
+func TestDeleteMissingPath(t *testing.T) {
+	d := newTestDriver(newFakeClient())
+	if err := d.Delete(context.Background(), "/missing"); !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+}
+
+func TestBestEffortDeleteConcurrent(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{BestEffortDelete: true})
+	ctx := context.Background()
+	client.writeFile("/registry/blobs/data", []byte("contents"))
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- d.Delete(ctx, "/blobs")
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error from concurrent Delete: %v", err)
+		}
+	}
+	if _, err := client.Stat("/registry/blobs"); err == nil {
+		t.Fatalf("expected the path to be deleted")
+	}
+	if err := d.Delete(ctx, "/blobs"); err != nil {
+		t.Fatalf("unexpected error deleting a deleted path: %v", err)
+	}
+}
+
+// failCrossZoneRenames makes renames out of /registry/zone1 fail like the
+// namenode refuses renames between encryption zones
+func failCrossZoneRenames(client *fakeClient) {
+	client.hook("Rename", func(name string) error {
+		if strings.HasPrefix(name, "/registry/zone1/") {
+			return fmt.Errorf("%s can't be moved from encryption zone /registry/zone1 to encryption zone /registry/zone2", name)
+		}
+		return nil
+	})
+}
+
+func TestCopyMovePreservesModTime(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(client)
+	ctx := context.Background()
+	client.writeFile("/registry/zone1/file", []byte("contents"))
+	client.Chtimes("/registry/zone1/file", time.Now(), time.Now().Add(-48*time.Hour))
+	source, err := client.Stat("/registry/zone1/file")
+	if err != nil {
+		t.Fatalf("unexpected error from Stat: %v", err)
+	}
+
+	failCrossZoneRenames(client)
+	if err := d.Move(ctx, "/zone1/file", "/zone2/file"); err != nil {
+		t.Fatalf("unexpected error from Move: %v", err)
+	}
+
+	moved, err := d.Stat(ctx, "/zone2/file")
+	if err != nil {
+		t.Fatalf("unexpected error from Stat: %v", err)
+	}
+	if !moved.ModTime().Equal(source.ModTime()) {
+		t.Fatalf("expected ModTime %v, got %v", source.ModTime(), moved.ModTime())
+	}
+	if contents, _ := d.GetContent(ctx, "/zone2/file"); string(contents) != "contents" {
+		t.Fatalf("unexpected contents %q", contents)
+	}
+	if _, err := client.Stat("/registry/zone1/file"); err == nil {
+		t.Fatalf("expected the source to be removed")
+	}
+}
+
+func TestCopyMoveIsAtomicForReaders(t *testing.T) {
+	client := newFakeClient()
+	blob := bytes.Repeat([]byte("blob"), 16<<10)
+	client.writeFile("/registry/zone1/data", blob)
+	client.writeFile("/registry/zone2/data", []byte("previous"))
+	failCrossZoneRenames(client)
+	d := newTestDriverWithParameters(client, DriverParameters{TransferBufferSize: 4 << 10, WriteBandwidth: 512 << 10})
+	ctx := context.Background()
+
+	moved := make(chan error, 1)
+	go func() {
+		moved <- d.Move(ctx, "/zone1/data", "/zone2/data")
+	}()
+	for reads := 0; ; reads++ {
+		select {
+		case err := <-moved:
+			if err != nil {
+				t.Fatalf("unexpected error from Move: %v", err)
+			}
+			if contents, err := d.GetContent(ctx, "/zone2/data"); err != nil || !bytes.Equal(contents, blob) {
+				t.Fatalf("expected the moved blob, got %d bytes, %v", len(contents), err)
+			}
+			if reads == 0 {
+				t.Fatal("expected reads during the move")
+			}
+			return
+		default:
+		}
+		contents, err := d.GetContent(ctx, "/zone2/data")
+		if err != nil {
+			t.Fatalf("unexpected error reading during the move: %v", err)
+		}
+		if string(contents) != "previous" && !bytes.Equal(contents, blob) {
+			t.Fatalf("read a partial blob of %d bytes during the move", len(contents))
+		}
+	}
+}
+
+func TestStatExtendedFileInfo(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(client)
+	client.writeFile("/registry/repo/file", []byte("contents"))
+	client.mu.Lock()
+	f := client.files["/registry/repo/file"]
+	f.owner, f.group, f.mode = "registry", "docker", 0640
+	client.mu.Unlock()
+
+	fi, err := d.Stat(context.Background(), "/repo/file")
+	if err != nil {
+		t.Fatalf("unexpected error from Stat: %v", err)
+	}
+	info, ok := fi.(ExtendedFileInfo)
+	if !ok {
+		t.Fatalf("expected Stat to return an ExtendedFileInfo, got %T", fi)
+	}
+	if info.Owner() != "registry" || info.Group() != "docker" || info.Mode() != 0640 {
+		t.Fatalf("unexpected ownership %s:%s %#o", info.Owner(), info.Group(), info.Mode())
+	}
+	if info.Size() != int64(len("contents")) || info.IsDir() {
+		t.Fatalf("unexpected file info %+v", info)
+	}
+}
+
+func TestStatPathIsDriverRelative(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{HdfsRootDirectory: "/srv/registry"})
+	ctx := context.Background()
+	client.writeFile("/srv/registry/docker/registry/v2/repositories/foo/_layers/data", []byte("link"))
+
+	for _, p := range []string{"/docker/registry/v2/repositories/foo/_layers/data", "/docker/registry/v2/repositories/foo"} {
+		fi, err := d.Stat(ctx, p)
+		if err != nil {
+			t.Fatalf("unexpected error from Stat(%q): %v", p, err)
+		}
+		if fi.Path() != p {
+			t.Fatalf("expected Stat(%q).Path() to be %q, got %q", p, p, fi.Path())
+		}
+	}
+
+	_, err := d.Stat(ctx, "/missing")
+	if e, ok := err.(storagedriver.PathNotFoundError); !ok || e.Path != "/missing" {
+		t.Fatalf("expected PathNotFoundError for /missing, got %v", err)
+	}
+}
+
+func TestTrailingSlashNormalization(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{HdfsRootDirectory: "/registry/"})
+	ctx := context.Background()
+	client.writeFile("/registry/foo/a", []byte("a"))
+	client.writeFile("/registry/foo/b", []byte("b"))
+
+	for _, p := range []string{"/foo", "/foo/", "foo"} {
+		fi, err := d.Stat(ctx, p)
+		if err != nil || !fi.IsDir() {
+			t.Fatalf("unexpected Stat result for %q: %+v, %v", p, fi, err)
+		}
+		names, err := d.List(ctx, p)
+		if err != nil {
+			t.Fatalf("unexpected error listing %q: %v", p, err)
+		}
+		if len(names) != 2 || path.Base(names[0]) != "a" || path.Base(names[1]) != "b" {
+			t.Fatalf("unexpected List result for %q: %v", p, names)
+		}
+	}
+
+	if d.fullPath("/foo/") != d.fullPath("/foo") || d.fullPath("/foo/") != "/registry/foo" {
+		t.Fatalf("expected trailing slashes to be dropped, got %q and %q", d.fullPath("/foo/"), d.fullPath("/foo"))
+	}
+	// A path merely starting with the root's name is not under the root
+	if full := d.fullPath("/registryfoo"); full != "/registry/registryfoo" {
+		t.Fatalf("unexpected full path %q", full)
+	}
+
+	if err := d.Delete(ctx, "/foo/"); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+	if _, err := d.Stat(ctx, "/foo"); !isPathNotFound(err) {
+		t.Fatalf("expected /foo to be deleted, got %v", err)
+	}
+}
+
+func TestFullPathRootAndDotFiles(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(client)
+	ctx := context.Background()
+
+	for _, p := range []string{"/", "", "."} {
+		if full := d.fullPath(p); full != "/registry" {
+			t.Fatalf("expected %q to be the root, got %q", p, full)
+		}
+	}
+
+	// A dot file at the root is stored and read under its own name
+	if err := d.PutContent(ctx, "/.marker", []byte("marker")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Stat("/registry/.marker"); err != nil {
+		t.Fatalf("expected the marker at /registry/.marker: %v", err)
+	}
+	if contents, err := d.GetContent(ctx, ".marker"); err != nil || string(contents) != "marker" {
+		t.Fatalf("unexpected contents %q, %v", contents, err)
+	}
+
+	// A path equal to the root's name is below the root like any other
+	if full := d.fullPath("/registry/.marker"); full != "/registry/registry/.marker" {
+		t.Fatalf("unexpected full path %q", full)
+	}
+	if err := d.PutContent(ctx, "/registry", []byte("contents")); err != nil {
+		t.Fatalf("unexpected error writing a path named like the root: %v", err)
+	}
+	if fi, err := client.Stat("/registry"); err != nil || !fi.IsDir() {
+		t.Fatalf("expected the root to stay a directory, got %v, %v", fi, err)
+	}
+	if contents, err := d.GetContent(ctx, "/registry"); err != nil || string(contents) != "contents" {
+		t.Fatalf("unexpected contents %q, %v", contents, err)
+	}
+
+	// Below a root of / dot files are at the top of the file system
+	d = newTestDriverWithParameters(client, DriverParameters{HdfsRootDirectory: "/"})
+	if full := d.fullPath("/.marker"); full != "/.marker" {
+		t.Fatalf("unexpected full path %q", full)
+	}
+}
+
+func TestReaderOpenFailure(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(client)
+	ctx := context.Background()
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Reader panicked after a failed Open: %v", r)
+		}
+	}()
+
+	if _, err := d.Reader(ctx, "/missing", 10); !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+
+	client.writeFile("/registry/file", []byte("contents"))
+	client.failWith("Open", errors.New("connection reset"))
+	if reader, err := d.Reader(ctx, "/file", 2); err == nil || reader != nil {
+		t.Fatalf("expected an error and no reader, got %v, %v", reader, err)
+	}
+}
+
+// failingReadClient opens readers that fail with err after the first n bytes
+type failingReadClient struct {
+	*fakeClient
+	n   int64
+	err error
+}
+
+func (c *failingReadClient) Open(name string) (hdfsFileReader, error) {
+	reader, err := c.fakeClient.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &midReadFailure{hdfsFileReader: reader, remaining: c.n, err: c.err}, nil
+}
+
+type midReadFailure struct {
+	hdfsFileReader
+	remaining int64
+	err       error
+}
+
+func (r *midReadFailure) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, r.err
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.hdfsFileReader.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+func TestGetContentReadFailure(t *testing.T) {
+	fake := newFakeClient()
+	fake.writeFile("/registry/file", []byte("contents"))
+	readErr := errors.New("read tcp 10.0.0.2:50010: connection reset by peer")
+	d := newTestDriver(&failingReadClient{fakeClient: fake, n: 3, err: readErr})
+	ctx := context.Background()
+
+	if _, err := d.GetContent(ctx, "/file"); err != readErr {
+		t.Fatalf("expected the read error, got %v", err)
+	}
+
+	// Failing opens are not mistaken for missing files either
+	fake.failWith("Open", errors.New("connection refused"))
+	if _, err := d.GetContent(ctx, "/file"); err == nil || isPathNotFound(err) {
+		t.Fatalf("expected the open error, got %v", err)
+	}
+	fake.hook("Open", nil)
+	if _, err := d.GetContent(ctx, "/missing"); !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+}
+
+func TestReaderOffsetPastEOF(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/file", []byte("contents"))
+	d := newTestDriver(client)
+	ctx := context.Background()
+
+	if _, err := d.Reader(ctx, "/file", 9); err == nil {
+		t.Fatal("expected an error reading past the end of the file")
+	} else if _, ok := err.(storagedriver.InvalidOffsetError); !ok {
+		t.Fatalf("expected InvalidOffsetError, got %v", err)
+	}
+
+	// Reading from the very end is an empty read, not an error
+	reader, err := d.Reader(ctx, "/file", 8)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+	if contents, err := ioutil.ReadAll(reader); err != nil || len(contents) != 0 {
+		t.Fatalf("expected nothing to read at the end, got %q, %v", contents, err)
+	}
+}
+
+func TestListRoot(t *testing.T) {
+	client := newFakeClient()
+	sd := wrap(newTestDriver(client))
+	ctx := context.Background()
+	client.writeFile("/registry/docker/registry/v2/repositories/library/ubuntu/_layers/link", []byte("link"))
+	client.writeFile("/registry/top", []byte("top"))
+
+	for _, p := range []string{"", "/"} {
+		entries, err := sd.List(ctx, p)
+		if err != nil {
+			t.Fatalf("unexpected error listing %q: %v", p, err)
+		}
+		if expected := []string{"/docker", "/top"}; !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("expected %v listing %q, got %v", expected, p, entries)
+		}
+	}
+}
+
+func TestHdfsRootAsRootDirectory(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{HdfsRootDirectory: "/", PathTransform: "digestprefix"})
+	ctx := context.Background()
+	layer := "/docker/registry/v2/blobs/sha256/" + testDigestHex[:2] + "/" + testDigestHex + "/data"
+
+	var outputs []string
+	record := func(values ...string) {
+		outputs = append(outputs, values...)
+	}
+	for _, p := range []string{"/top", layer} {
+		if err := d.PutContent(ctx, p, []byte("contents")); err != nil {
+			t.Fatalf("unexpected error storing %s: %v", p, err)
+		}
+		if contents, err := d.GetContent(ctx, p); err != nil || string(contents) != "contents" {
+			t.Fatalf("expected %s to read back, got %q, %v", p, contents, err)
+		}
+		fi, err := d.Stat(ctx, p)
+		if err != nil || fi.Path() != p {
+			t.Fatalf("expected Stat(%q) to report its path, got %v, %v", p, fi, err)
+		}
+		record(d.fullPath(p), fi.Path())
+	}
+	if full, want := d.fullPath(layer), "/docker/registry/v2/blobs/sha256/ab/~ab/"+testDigestHex+"/data"; full != want {
+		t.Fatalf("expected the path transform to apply below /, got %s", full)
+	}
+
+	for _, p := range []string{"", "/"} {
+		entries, err := d.List(ctx, p)
+		if err != nil {
+			t.Fatalf("unexpected error listing %q: %v", p, err)
+		}
+		if expected := []string{"/docker", "/top"}; !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("expected %v listing %q, got %v", expected, p, entries)
+		}
+		record(entries...)
+	}
+	entries, err := d.List(ctx, path.Dir(layer))
+	if err != nil || len(entries) != 1 || entries[0] != layer {
+		t.Fatalf("expected %s to be listed, got %v, %v", layer, entries, err)
+	}
+	infos, err := wrap(d).ListInfo(ctx, "/")
+	if err != nil {
+		t.Fatalf("unexpected error from ListInfo: %v", err)
+	}
+	for _, info := range infos {
+		record(info.Path())
+	}
+	err = wrap(d).Walk(ctx, "/", func(fi storagedriver.FileInfo, err error) error {
+		record(fi.Path())
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error from Walk: %v", err)
+	}
+
+	if err := d.Move(ctx, "/top", "/moved"); err != nil {
+		t.Fatalf("unexpected error from Move: %v", err)
+	}
+	_, err = d.Stat(ctx, "/top")
+	record(err.Error())
+	_, err = d.GetContent(ctx, "/top")
+	record(err.Error())
+	if err := d.Delete(ctx, "/moved"); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+	record(d.Delete(ctx, "/moved").Error())
+
+	for name := range client.files {
+		record(name)
+	}
+	for _, output := range outputs {
+		if output == "" || strings.Contains(output, "//") {
+			t.Errorf("unexpected path %q with a root of /", output)
+		}
+	}
+}
+
+func TestSpecialCharacterPaths(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{PathTransform: "digestprefix"})
+	ctx := context.Background()
+
+	for _, name := range []string{"c++", "my.repo", "with space", "100%", "..."} {
+		repo := "/repositories/" + name
+		if err := d.PutContent(ctx, repo+"/_manifests/link", []byte(name)); err != nil {
+			t.Fatalf("unexpected error storing %q: %v", name, err)
+		}
+
+		contents, err := d.GetContent(ctx, repo+"/_manifests/link")
+		if err != nil || string(contents) != name {
+			t.Fatalf("expected %q to read back, got %q, %v", name, contents, err)
+		}
+		if _, err := client.Stat("/registry" + repo + "/_manifests/link"); err != nil {
+			t.Fatalf("expected %q to be stored verbatim: %v", name, err)
+		}
+
+		entries, err := d.List(ctx, repo)
+		if err != nil || !reflect.DeepEqual(entries, []string{repo + "/_manifests"}) {
+			t.Fatalf("unexpected List result for %q: %v, %v", name, entries, err)
+		}
+
+		if err := d.Move(ctx, repo+"/_manifests/link", repo+"/moved"); err != nil {
+			t.Fatalf("unexpected error moving within %q: %v", name, err)
+		}
+		if contents, err := d.GetContent(ctx, repo+"/moved"); err != nil || string(contents) != name {
+			t.Fatalf("expected the moved file in %q to read back, got %q, %v", name, contents, err)
+		}
+	}
+
+	entries, err := d.List(ctx, "/repositories")
+	if err != nil {
+		t.Fatalf("unexpected error listing repositories: %v", err)
+	}
+	expected := []string{"/repositories/...", "/repositories/100%", "/repositories/c++", "/repositories/my.repo", "/repositories/with space"}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	if _, err := FromParameters(map[string]interface{}{"hdfsnamenode": "127.0.0.1:1", "dialtimeout": "soon"}); err == nil {
+		t.Fatalf("expected an invalid dialtimeout to be rejected")
+	}
+
+	// Addresses in TEST-NET-1 are never routed, so connecting to them
+	// hangs until the dial timeout
+	start := time.Now()
+	_, err := New(DriverParameters{HdfsNameNode: "192.0.2.1:8020", DialTimeout: 200 * time.Millisecond})
+	if err == nil {
+		t.Fatalf("expected New to fail for an unreachable namenode")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected New to give up after the dial timeout, took %v", elapsed)
+	}
+}
+
+// cancellingClient cancels a context once the first chunk of a file opened
+// through it has been read
+type cancellingClient struct {
+	hdfsClient
+	cancel func()
+}
+
+func (c cancellingClient) Open(name string) (hdfsFileReader, error) {
+	reader, err := c.hdfsClient.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &cancellingReader{hdfsFileReader: reader, cancel: c.cancel}, nil
+}
+
+type cancellingReader struct {
+	hdfsFileReader
+	cancel func()
+}
+
+func (r *cancellingReader) Read(p []byte) (int, error) {
+	n, err := r.hdfsFileReader.Read(p)
+	r.cancel()
+	return n, err
+}
+
+func TestCopyMoveCancelled(t *testing.T) {
+	fake := newFakeClient()
+	fake.writeFile("/registry/zone1/blob", make([]byte, 1<<20))
+	failCrossZoneRenames(fake)
+
+	ctx, cancel := netcontext.WithCancel(context.Background())
+	defer cancel()
+	d := newTestDriverWithParameters(cancellingClient{hdfsClient: fake, cancel: cancel}, DriverParameters{TransferBufferSize: 4 << 10})
+
+	if err := d.Move(ctx, "/zone1/blob", "/zone2/blob"); err == nil {
+		t.Fatalf("expected the cancelled Move to fail")
+	}
+	if _, err := fake.Stat("/registry/zone2/blob"); err == nil {
+		t.Fatalf("expected the partial destination to be removed")
+	}
+	if _, err := fake.Stat("/registry/zone1/blob"); err != nil {
+		t.Fatalf("expected the source to be kept: %v", err)
+	}
+	if fake.callCount("Create") != 1 {
+		t.Fatalf("expected the copy to have started")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	if Version() != string(CurrentVersion) || Version() == "" {
+		t.Fatalf("expected Version to return %q, got %q", CurrentVersion, Version())
+	}
+}
+
+func TestMakeParentDirAndMkdirAll(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(client)
+
+	// A file's parent is created, the file is left to the create
+	if err := d.makeParentDir(d.fullPath("/repositories/foo/_layers/link")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi, err := client.Stat("/registry/repositories/foo/_layers"); err != nil || !fi.IsDir() {
+		t.Fatalf("expected the parent directory to be created, got %v, %v", fi, err)
+	}
+	if _, err := client.Stat("/registry/repositories/foo/_layers/link"); err == nil {
+		t.Fatal("expected makeParentDir not to create the file's path")
+	}
+
+	// A directory is created itself
+	if err := d.mkdirAll("/repositories/bar"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi, err := client.Stat("/registry/repositories/bar"); err != nil || !fi.IsDir() {
+		t.Fatalf("expected the directory to be created, got %v, %v", fi, err)
+	}
+	if err := d.mkdirAll("/repositories/bar"); err != nil {
+		t.Fatalf("expected an existing directory to be fine, got %v", err)
+	}
+
+	// A file in the way is not a directory
+	client.writeFile("/registry/repositories/file", []byte("x"))
+	if err := d.mkdirAll("/repositories/file"); err == nil {
+		t.Fatal("expected mkdirAll to fail on a file")
+	}
+}
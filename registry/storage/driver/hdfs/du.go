@@ -0,0 +1,109 @@
+package hdfs
+
+import (
+	"os"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// Sizer is implemented by drivers that can total the bytes stored below a
+// path, such as the HDFS driver, for operators and per-repository quotas.
+type Sizer interface {
+	// Size returns the number of bytes stored below prefix, or the size of
+	// prefix when it is a file. Replicas are not counted, and compressed
+	// files count their compressed size.
+	Size(ctx context.Context, prefix string) (int64, error)
+}
+
+// contentSizer is implemented by clients that can issue the namenode's
+// getContentSummary RPC, which totals a whole subtree in a single call.
+// colinmarc/hdfs does.
+type contentSizer interface {
+	ContentSize(name string) (int64, error)
+}
+
+// contentSize totals the bytes below name if c supports it
+func contentSize(c hdfsClient, name string) (int64, error) {
+	if s, ok := c.(contentSizer); ok {
+		return s.ContentSize(name)
+	}
+	return 0, errUnsupportedByClient
+}
+
+// ContentSize implements contentSizer
+func (c colinmarcClient) ContentSize(name string) (int64, error) {
+	summary, err := c.Client.GetContentSummary(name)
+	if err != nil {
+		return 0, err
+	}
+	return summary.Size(), nil
+}
+
+// Size implements Sizer. Without getContentSummary the tree is walked
+// instead, which costs a listing per directory.
+func (d *Driver) Size(ctx context.Context, prefix string) (int64, error) {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.Size(%q)", d.Name(), prefix)
+
+	prefix, err := d.inner().checkPath(prefix, true)
+	if err != nil {
+		return 0, err
+	}
+	return d.inner().size(ctx, prefix)
+}
+
+func (d *driver) size(ctx context.Context, prefix string) (int64, error) {
+	if err := d.checkClient(); err != nil {
+		return 0, err
+	}
+	d = d.withOptions(ctx)
+	fullPath, err := d.readPath(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := contentSize(d.hdfsClient, fullPath)
+	if err == errUnsupportedByClient {
+		size, err = d.walkSize(ctx, prefix, fullPath)
+	}
+	if os.IsNotExist(err) {
+		return 0, storagedriver.PathNotFoundError{Path: prefix, DriverName: driverName}
+	}
+	return size, err
+}
+
+// walkSize adds up the sizes of the files below prefix
+func (d *driver) walkSize(ctx context.Context, prefix, fullPath string) (int64, error) {
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if err != nil {
+		return 0, err
+	} else if !fi.IsDir() {
+		return fi.Size(), nil
+	}
+
+	var size int64
+	dirs := make(map[string]bool)
+	list := func(dir string) ([]string, error) {
+		entries, err := d.readEntries(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, len(entries))
+		for i, entry := range entries {
+			paths[i] = entry.path
+			if entry.info.IsDir() {
+				dirs[entry.path] = true
+			} else {
+				size += entry.info.Size()
+			}
+		}
+		return paths, nil
+	}
+	err = walkTree(prefix, list, func(p string) (bool, error) {
+		descend := dirs[p]
+		delete(dirs, p)
+		return descend, nil
+	})
+	return size, err
+}
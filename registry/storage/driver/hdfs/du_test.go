@@ -0,0 +1,51 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func newSizeTestClient() *fakeClient {
+	client := newFakeClient()
+	client.writeFile("/registry/repo/a", []byte("12345"))
+	client.writeFile("/registry/repo/sub/b", []byte("123"))
+	client.writeFile("/registry/repo/sub/deeper/c", []byte("1234567"))
+	client.writeFile("/registry/other/d", []byte("not counted"))
+	return client
+}
+
+func TestSize(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		client    func(*fakeClient) hdfsClient
+		summaries int
+	}{
+		{"content summary", func(c *fakeClient) hdfsClient { return c }, 1},
+		{"walk", func(c *fakeClient) hdfsClient { return basicClient{c} }, 0},
+	} {
+		client := newSizeTestClient()
+		d := wrap(newTestDriver(tc.client(client)))
+
+		size, err := d.Size(context.Background(), "/repo")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if size != 15 {
+			t.Fatalf("%s: expected 15 bytes, got %d", tc.name, size)
+		}
+		if calls := client.callCount("ContentSize"); calls != tc.summaries {
+			t.Fatalf("%s: expected %d content summaries, got %d", tc.name, tc.summaries, calls)
+		}
+
+		if size, err := d.Size(context.Background(), "/repo/sub/b"); err != nil || size != 3 {
+			t.Fatalf("%s: expected the size of a file, got %d, %v", tc.name, size, err)
+		}
+		if _, err := d.Size(context.Background(), "/missing"); err == nil {
+			t.Fatalf("%s: expected an error for a missing path", tc.name)
+		} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			t.Fatalf("%s: expected PathNotFoundError, got %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package hdfs
+
+import "net/url"
+
+// redacted replaces secrets in an EffectiveConfig
+const redacted = "REDACTED"
+
+// authSimple is the Authentication of every driver, colinmarc/hdfs sends
+// the user name and nothing else
+const authSimple = "simple"
+
+// ConfigReporter is implemented by drivers that can report the
+// configuration they run with, such as the HDFS driver, for troubleshooting.
+// The result holds no secrets, so it can be logged or served as JSON:
+//
+//	if reporter, ok := driver.(hdfs.ConfigReporter); ok {
+//		json.NewEncoder(w).Encode(reporter.EffectiveConfig())
+//	}
+type ConfigReporter interface {
+	EffectiveConfig() EffectiveConfig
+}
+
+// EffectiveConfig is the configuration of a driver once usehadoopenv and
+// HADOOP_TOKEN_FILE_LOCATION were applied and defaults filled in
+type EffectiveConfig struct {
+	// NameNodes and ObserverNameNodes are the namenodes the driver
+	// connects to, before namenodeports probing
+	NameNodes         []string `json:"namenodes"`
+	ObserverNameNodes []string `json:"observernamenodes,omitempty"`
+	RootDirectory     string   `json:"rootdirectory"`
+	User              string   `json:"user"`
+
+	// Authentication is simple, the only way colinmarc/hdfs authenticates
+	Authentication string `json:"authentication"`
+
+	// DelegationToken is REDACTED when the driver holds a token
+	DelegationToken string `json:"delegationtoken,omitempty"`
+
+	// BlockSize is the block size of the files the driver creates, 0 for
+	// the cluster's dfs.blocksize
+	BlockSize   int64 `json:"blocksize"`
+	Replication int64 `json:"replication"`
+
+	// Parameters are all the parameters, with the password of proxyurl
+	// REDACTED
+	Parameters DriverParameters `json:"parameters"`
+}
+
+// EffectiveConfig implements ConfigReporter
+func (d *Driver) EffectiveConfig() EffectiveConfig {
+	return d.inner().effectiveConfig()
+}
+
+func (d *driver) effectiveConfig() EffectiveConfig {
+	params := d.params
+	params.delegationToken = ""
+	params.ProxyURL = redactURL(params.ProxyURL)
+
+	config := EffectiveConfig{
+		NameNodes:         splitList(params.HdfsNameNode),
+		ObserverNameNodes: splitList(params.ObserverNameNode),
+		RootDirectory:     d.hdfsRootDirectory,
+		User:              params.HdfsUser,
+		Authentication:    authSimple,
+		Replication:       params.Replication,
+		Parameters:        params,
+	}
+	if d.delegationToken != "" {
+		config.DelegationToken = redacted
+	}
+	// Explicit replication needs an explicit block size, see create
+	if params.Replication != 0 {
+		config.BlockSize = defaultBlockSize
+	}
+	return config
+}
+
+// redactURL replaces the password in rawURL, if any
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
@@ -0,0 +1,257 @@
+package hdfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/colinmarc/hdfs"
+)
+
+// HDFS transparent encryption keeps the files of an encryption zone
+// encrypted with AES/CTR on the datanodes. Every file has its own data
+// encryption key, which the namenode returns encrypted with the zone key;
+// clients decrypt it through the KMS and encrypt or decrypt the file
+// contents themselves. The driver decrypts files it reads from a zone when
+// the kmsuri parameter is set, so they are not served in their encrypted
+// form.
+//
+// Support is decrypt-on-read only. The namenode refuses to create files in
+// a zone for clients that do not send a crypto protocol version, which
+// colinmarc/hdfs does not, and appending to a zone file would store the
+// appended data unencrypted; both fail with an error naming the file.
+//
+// kmsuri is the hadoop.security.key.provider.path of the cluster, which
+// usehadoopenv picks up as well. The KMS is called with simple
+// authentication as hdfsuser, which needs the DECRYPT_EEK permission on the
+// zone key in kms-acls.xml.
+
+// fileEncryptionInfo describes how a file in an encryption zone is encrypted
+type fileEncryptionInfo struct {
+	keyName          string
+	ezKeyVersionName string
+	edek             []byte
+	iv               []byte
+}
+
+// encryptionInfoGetter is implemented by clients that can report the
+// encryption of a file. It returns nil for files outside encryption zones.
+type encryptionInfoGetter interface {
+	EncryptionInfo(name string) (*fileEncryptionInfo, error)
+}
+
+// encryptionInfo returns the encryption of name if c can report it
+func encryptionInfo(c hdfsClient, name string) (*fileEncryptionInfo, error) {
+	if g, ok := c.(encryptionInfoGetter); ok {
+		return g.EncryptionInfo(name)
+	}
+	return nil, errUnsupportedByClient
+}
+
+// EncryptionInfo implements encryptionInfoGetter. colinmarc/hdfs does not
+// expose the FileEncryptionInfo of a file, but the hdfs.FileStatus returned
+// by Sys carries it, so it is read from there.
+func (c colinmarcClient) EncryptionInfo(name string) (*fileEncryptionInfo, error) {
+	fi, err := c.Client.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	status, ok := fi.Sys().(*hdfs.FileStatus)
+	if !ok {
+		return nil, errUnsupportedByClient
+	}
+	info := status.FileEncryptionInfo
+	if info == nil {
+		return nil, nil
+	}
+	return &fileEncryptionInfo{
+		keyName:          info.GetKeyName(),
+		ezKeyVersionName: info.GetEzKeyVersionName(),
+		edek:             info.GetKey(),
+		iv:               info.GetIv(),
+	}, nil
+}
+
+// ctrStream returns the AES/CTR key stream of a file positioned at offset.
+// Like the Hadoop codec, the counter for a position is iv plus the number
+// of whole blocks before it.
+func ctrStream(key, iv []byte, offset int64) (cipher.Stream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid encryption IV length %d", len(iv))
+	}
+
+	counter := append([]byte(nil), iv...)
+	carry := uint64(offset / aes.BlockSize)
+	for i := len(counter) - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(counter[i]) + carry&0xff
+		counter[i] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+
+	stream := cipher.NewCTR(block, counter)
+	if skip := offset % aes.BlockSize; skip > 0 {
+		discard := make([]byte, skip)
+		stream.XORKeyStream(discard, discard)
+	}
+	return stream, nil
+}
+
+// decryptingFile decrypts an encrypted file as it is read, following seeks
+type decryptingFile struct {
+	hdfsFileReader
+	key, iv []byte
+	stream  cipher.Stream
+}
+
+func (f *decryptingFile) Read(p []byte) (int, error) {
+	n, err := f.hdfsFileReader.Read(p)
+	f.stream.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+func (f *decryptingFile) Seek(offset int64, whence int) (int64, error) {
+	position, err := f.hdfsFileReader.Seek(offset, whence)
+	if err != nil {
+		return position, err
+	}
+	f.stream, err = ctrStream(f.key, f.iv, position)
+	return position, err
+}
+
+// encryptedWriteError is returned for writes to files in encryption zones
+func encryptedWriteError(fullPath string) error {
+	return fmt.Errorf("cannot write %s: it is in an encryption zone, which the driver can only read from", fullPath)
+}
+
+// isCryptoProtocolRefusal reports whether err is the namenode refusing a
+// create in an encryption zone to a client without a crypto protocol version
+func isCryptoProtocolRefusal(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "rypto protocol version")
+}
+
+// createError returns encryptedWriteError for creates of fullPath the
+// namenode refused because of its encryption zone, and err otherwise
+func createError(fullPath string, err error) error {
+	if isCryptoProtocolRefusal(err) {
+		return encryptedWriteError(fullPath)
+	}
+	return err
+}
+
+// dataKey returns the key of the file at fullPath, or nil when it is not
+// encrypted or the client cannot tell.
+func (d *driver) dataKey(fullPath string) ([]byte, *fileEncryptionInfo, error) {
+	if d.kms == nil {
+		return nil, nil, nil
+	}
+	info, err := encryptionInfo(d.hdfsClient, fullPath)
+	if err == errUnsupportedByClient || (err == nil && info == nil) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	key, err := d.kms.decryptDataKey(info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, info, nil
+}
+
+// open opens the file at fullPath for reading, decrypting it if it is in an
+// encryption zone, or through WebHDFS with webhdfsreads. Symbolic links
+// are followed with symlinks set to follow. Reads return io.EOF only at the
+// end of the file, see strictReader.
+func (d *driver) open(fullPath string) (hdfsFileReader, error) {
+	reader, _, err := d.openResolved(fullPath)
+	return reader, err
+}
+
+// openResolved is open, also returning the path opened, which is the target
+// of fullPath when it is a link
+func (d *driver) openResolved(fullPath string) (hdfsFileReader, string, error) {
+	reader, err := d.openFile(fullPath)
+	if isUnresolvedLink(err) {
+		if fullPath, err = d.followLink(fullPath); err == nil {
+			reader, err = d.openFile(fullPath)
+		}
+	}
+	if err != nil {
+		return nil, fullPath, err
+	}
+	return newStrictReader(reader), fullPath, nil
+}
+
+func (d *driver) openFile(fullPath string) (hdfsFileReader, error) {
+	if d.webHdfsReads {
+		fi, err := d.hdfsClient.Stat(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		return &webHdfsFile{webHdfs: d.webHdfs, hdfsPath: fullPath, info: fi}, nil
+	}
+
+	reader, err := d.hdfsClient.Open(fullPath)
+	if err != nil || d.kms == nil {
+		return reader, err
+	}
+
+	key, info, err := d.dataKey(fullPath)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	if key == nil {
+		return reader, nil
+	}
+	stream, err := ctrStream(key, info.iv, 0)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &decryptingFile{hdfsFileReader: reader, key: key, iv: info.iv, stream: stream}, nil
+}
+
+// checkAppendable fails appends to the file at fullPath when it is in an
+// encryption zone, where the appended data would not be encrypted
+func (d *driver) checkAppendable(fullPath string) error {
+	if d.kms == nil {
+		return nil
+	}
+	info, err := encryptionInfo(d.hdfsClient, fullPath)
+	if err == errUnsupportedByClient {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info != nil {
+		return encryptedWriteError(fullPath)
+	}
+	return nil
+}
+
+// readAll reads the whole file at fullPath like open
+func (d *driver) readAll(fullPath string) ([]byte, error) {
+	if d.kms == nil && !d.webHdfsReads {
+		contents, err := d.hdfsClient.ReadFile(fullPath)
+		if isUnresolvedLink(err) {
+			if fullPath, err = d.followLink(fullPath); err == nil {
+				contents, err = d.hdfsClient.ReadFile(fullPath)
+			}
+		}
+		return contents, err
+	}
+	reader, err := d.open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
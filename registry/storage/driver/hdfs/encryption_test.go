@@ -0,0 +1,190 @@
+package hdfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+// fakeKeyMask stands in for the zone key: the fake KMS "encrypts" data keys
+// by xoring them with it
+var fakeKeyMask = []byte("0123456789abcdef")
+
+func xorMask(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ fakeKeyMask[i%len(fakeKeyMask)]
+	}
+	return out
+}
+
+func newFakeEncryptionInfo(keyName string) *fileEncryptionInfo {
+	dek := make([]byte, 16)
+	iv := make([]byte, 16)
+	rand.Read(dek)
+	rand.Read(iv)
+	// Start close to a counter overflow to exercise the carry
+	for i := 8; i < 16; i++ {
+		iv[i] = 0xff
+	}
+	return &fileEncryptionInfo{keyName: keyName, ezKeyVersionName: keyName + "@0", edek: xorMask(dek), iv: iv}
+}
+
+// fakeKms serves the decrypt operation of the Hadoop KMS REST API
+type fakeKms struct {
+	sync.Mutex
+	decrypted []string
+}
+
+func (k *fakeKms) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Query().Get("eek_op") != "decrypt" || r.URL.Query().Get("user.name") != "registry" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	var req kmsKeyMaterial
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	edek, err := decodeKmsBase64(req.Material)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	k.Lock()
+	k.decrypted = append(k.decrypted, r.URL.Path)
+	k.Unlock()
+	json.NewEncoder(w).Encode(kmsKeyMaterial{Name: "EK", Material: encodeKmsBase64(xorMask(edek))})
+}
+
+func newEncryptionTestDriver() (*driver, *fakeClient, *fakeKms, func()) {
+	kms := &fakeKms{}
+	server := httptest.NewServer(kms)
+	client := newFakeClient()
+	client.createEncryptionZone("/registry/zone", "zonekey")
+	d := newTestDriverWithParameters(client, DriverParameters{HdfsUser: "registry", KmsURI: strings.Replace(server.URL, "http://", "kms://http@", 1) + "/kms"})
+	return d, client, kms, server.Close
+}
+
+// writeEncryptedFile stores plaintext at name encrypted, as the datanodes
+// hold the files of the encryption zone name is in
+func writeEncryptedFile(c *fakeClient, name string, plaintext []byte) {
+	c.mu.Lock()
+	var keyName string
+	for zone, key := range c.zones {
+		if strings.HasPrefix(name, zone+"/") {
+			keyName = key
+		}
+	}
+	c.mu.Unlock()
+
+	info := newFakeEncryptionInfo(keyName)
+	stream, err := ctrStream(xorMask(info.edek), info.iv, 0)
+	if err != nil {
+		panic(err)
+	}
+	encrypted := make([]byte, len(plaintext))
+	stream.XORKeyStream(encrypted, plaintext)
+	c.writeFile(name, encrypted)
+	c.mu.Lock()
+	c.files[name].encryption = info
+	c.mu.Unlock()
+}
+
+func TestEncryptionZoneReads(t *testing.T) {
+	d, client, kms, closeServer := newEncryptionTestDriver()
+	defer closeServer()
+	ctx := context.Background()
+
+	plaintext := bytes.Repeat([]byte("secret layer data "), 10)
+	writeEncryptedFile(client, "/registry/zone/blob", plaintext)
+
+	contents, err := d.GetContent(ctx, "/zone/blob")
+	if err != nil || !bytes.Equal(contents, plaintext) {
+		t.Fatalf("expected the contents to decrypt, got %q, %v", contents, err)
+	}
+
+	// Reads at an offset decrypt from the middle of a cipher block
+	reader, err := d.Reader(ctx, "/zone/blob", 21)
+	if err != nil {
+		t.Fatalf("unexpected error from Reader: %v", err)
+	}
+	tail, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(tail, plaintext[21:]) {
+		t.Fatalf("expected the contents from offset 21, got %q, %v", tail, err)
+	}
+
+	kms.Lock()
+	defer kms.Unlock()
+	if len(kms.decrypted) == 0 || kms.decrypted[0] != "/kms/v1/keyversion/zonekey@0/_eek" {
+		t.Fatalf("expected the data key to be decrypted through the KMS, got %v", kms.decrypted)
+	}
+}
+
+func TestEncryptionZoneWritesAreRefused(t *testing.T) {
+	d, client, _, closeServer := newEncryptionTestDriver()
+	defer closeServer()
+	ctx := context.Background()
+
+	refused := func(err error) bool {
+		return err != nil && strings.Contains(err.Error(), "encryption zone")
+	}
+	if err := d.PutContent(ctx, "/zone/blob", []byte("data")); !refused(err) {
+		t.Fatalf("expected creates in a zone to be refused, got %v", err)
+	}
+	if _, err := d.Writer(ctx, "/zone/_uploads/new", false); !refused(err) {
+		t.Fatalf("expected new uploads in a zone to be refused, got %v", err)
+	}
+
+	// Appended data would not be encrypted
+	writeEncryptedFile(client, "/registry/zone/_uploads/data", []byte("first part, "))
+	stored, _ := client.ReadFile("/registry/zone/_uploads/data")
+	if _, err := d.Writer(ctx, "/zone/_uploads/data", true); !refused(err) {
+		t.Fatalf("expected appends in a zone to be refused, got %v", err)
+	}
+	if after, _ := client.ReadFile("/registry/zone/_uploads/data"); !bytes.Equal(after, stored) {
+		t.Fatalf("expected the encrypted file to be left as is, got %q", after)
+	}
+}
+
+func TestOutsideEncryptionZone(t *testing.T) {
+	d, client, kms, closeServer := newEncryptionTestDriver()
+	defer closeServer()
+
+	if err := d.PutContent(context.Background(), "/plain", []byte("plain")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if stored, _ := client.ReadFile("/registry/plain"); string(stored) != "plain" {
+		t.Fatalf("expected files outside zones to be stored as is, got %q", stored)
+	}
+	if len(kms.decrypted) != 0 {
+		t.Fatalf("expected no KMS calls outside encryption zones, got %v", kms.decrypted)
+	}
+}
+
+func TestKmsAddress(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"kms://http@kms.example.com:9600/kms":                     "http://kms.example.com:9600/kms",
+		"kms://https@kms1.example.com;kms2.example.com:9600/kms/": "https://kms1.example.com:9600/kms",
+		"http://kms.example.com:9600/kms":                         "http://kms.example.com:9600/kms",
+	} {
+		if address, err := kmsAddress(uri); err != nil || address != expected {
+			t.Errorf("expected %q for %q, got %q, %v", expected, uri, address, err)
+		}
+	}
+	for _, uri := range []string{"kms://kms.example.com/kms", "ftp://kms.example.com", "kms://http@/kms"} {
+		if _, err := kmsAddress(uri); err == nil {
+			t.Errorf("expected %q to be rejected", uri)
+		}
+	}
+}
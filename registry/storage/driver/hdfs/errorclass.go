@@ -0,0 +1,186 @@
+package hdfs
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// ErrorCategory says what kind of failure an error of the driver is, so
+// that the registry can answer with a matching HTTP status rather than a
+// 500 for everything that is not a missing path
+type ErrorCategory string
+
+// The categories of ErrorCategoryOf and the status each corresponds to
+const (
+	// ErrorNotFound is a missing path, 404
+	ErrorNotFound ErrorCategory = "not-found"
+	// ErrorPermission is an operation HDFS or the driver does not allow,
+	// 403
+	ErrorPermission ErrorCategory = "permission"
+	// ErrorUnavailable is a transient failure of the cluster, such as a
+	// namenode in safe mode or unreachable datanodes, 503
+	ErrorUnavailable ErrorCategory = "unavailable"
+	// ErrorConflict is an operation conflicting with the state of a path,
+	// such as a file that exists or a lease another client holds, 409
+	ErrorConflict ErrorCategory = "conflict"
+	// ErrorInvalid is a request that can never succeed as made, such as an
+	// invalid path or writing to a directory, 400
+	ErrorInvalid ErrorCategory = "invalid"
+)
+
+// CategorizedError is implemented by the errors of this package that fall
+// in a category, and by ClassifiedError
+type CategorizedError interface {
+	error
+	Category() ErrorCategory
+}
+
+// ClassifiedError is an error from HDFS tagged with its category. The
+// storagedriver.StorageDriver methods return them for the errors they
+// would otherwise pass on as is when the classifyerrors parameter is set;
+// base.Base then encloses them in a storagedriver.Error like any other.
+type ClassifiedError struct {
+	Err   error
+	Class ErrorCategory
+}
+
+func (e ClassifiedError) Error() string {
+	return e.Err.Error()
+}
+
+// Category implements CategorizedError
+func (e ClassifiedError) Category() ErrorCategory {
+	return e.Class
+}
+
+// ErrorCategoryOf returns the category of an error returned by the driver,
+// looking into the storagedriver.Error base.Base returns, or "" for errors
+// that fall in none of them and are internal errors. It works whether or
+// not classifyerrors is set.
+func ErrorCategoryOf(err error) ErrorCategory {
+	if enclosing, ok := err.(storagedriver.Error); ok {
+		err = enclosing.Enclosed
+	}
+	switch e := err.(type) {
+	case nil:
+		return ""
+	case CategorizedError:
+		return e.Category()
+	case storagedriver.PathNotFoundError:
+		return ErrorNotFound
+	case storagedriver.InvalidPathError, storagedriver.InvalidOffsetError:
+		return ErrorInvalid
+	}
+	return classifyError(err)
+}
+
+// isTransportError reports whether err is the failure of a connection to
+// the namenode or a datanode, or of a stream read from one
+func isTransportError(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	switch err {
+	case nil:
+		return false
+	case io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	message := err.Error()
+	return strings.Contains(message, "connection reset") ||
+		strings.Contains(message, "broken pipe") ||
+		strings.Contains(message, "use of closed network connection")
+}
+
+// classifyError returns the category of an error from HDFS by what the
+// namenode or the client reported
+func classifyError(err error) ErrorCategory {
+	switch {
+	case os.IsNotExist(err):
+		return ErrorNotFound
+	case os.IsPermission(err):
+		return ErrorPermission
+	case os.IsExist(err) || isLeaseHeld(err):
+		return ErrorConflict
+	case isConnectionError(err) || isTransportError(err) || isFailoverError(err) || isMissingBlock(err) || isMaintenanceError(err):
+		return ErrorUnavailable
+	}
+
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "AccessControlException") || strings.Contains(message, "Permission denied"):
+		return ErrorPermission
+	case strings.Contains(message, "SafeModeException") || strings.Contains(message, "safe mode"):
+		return ErrorUnavailable
+	case strings.Contains(message, "FileAlreadyExistsException") || strings.Contains(message, "AlreadyBeingCreatedException"):
+		return ErrorConflict
+	case strings.Contains(message, "InvalidPathException") || strings.Contains(message, "is a directory") || strings.Contains(message, "not a directory"):
+		return ErrorInvalid
+	}
+	return ""
+}
+
+// classify tags *err with its category when classifyerrors is set. Errors
+// of the storagedriver types and of this package are left alone, callers
+// check for them by type.
+func (d *driver) classify(err *error) {
+	if !d.classifyErrors || *err == nil {
+		return
+	}
+	switch (*err).(type) {
+	case CategorizedError, storagedriver.PathNotFoundError, storagedriver.InvalidPathError, storagedriver.InvalidOffsetError, storagedriver.ErrUnsupportedMethod:
+		return
+	}
+	if category := classifyError(*err); category != "" {
+		*err = ClassifiedError{Err: *err, Class: category}
+	}
+}
+
+// Category implements CategorizedError
+func (e NoClobberError) Category() ErrorCategory { return ErrorConflict }
+
+// Category implements CategorizedError
+func (e DirectoryNotEmptyError) Category() ErrorCategory { return ErrorConflict }
+
+// Category implements CategorizedError
+func (e LockHeldError) Category() ErrorCategory { return ErrorConflict }
+
+// Category implements CategorizedError
+func (e DeleteRefusedError) Category() ErrorCategory { return ErrorPermission }
+
+// Category implements CategorizedError
+func (e QuotaExceededError) Category() ErrorCategory { return ErrorPermission }
+
+// Category implements CategorizedError
+func (e DataUnavailableError) Category() ErrorCategory { return ErrorUnavailable }
+
+// Category implements CategorizedError
+func (e InsufficientSpaceError) Category() ErrorCategory { return ErrorUnavailable }
+
+// Category implements CategorizedError
+func (e CloseTimeoutError) Category() ErrorCategory { return ErrorUnavailable }
+
+// Category implements CategorizedError
+func (e errNamenodeUnavailable) Category() ErrorCategory { return ErrorUnavailable }
+
+// Category implements CategorizedError
+func (e errWritesSuspended) Category() ErrorCategory { return ErrorUnavailable }
+
+// Category implements CategorizedError
+func (e errSymlinkNotFollowed) Category() ErrorCategory { return ErrorPermission }
+
+// Category implements CategorizedError
+func (e RecentlyDeletedError) Category() ErrorCategory { return ErrorNotFound }
+
+// Category implements CategorizedError
+func (e DigestMismatchError) Category() ErrorCategory { return ErrorInvalid }
+
+// Category implements CategorizedError
+func (e PathTooLongError) Category() ErrorCategory { return ErrorInvalid }
@@ -0,0 +1,79 @@
+package hdfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestErrorCategoryOf(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want ErrorCategory
+	}{
+		{"missing path", storagedriver.PathNotFoundError{Path: "/a"}, ErrorNotFound},
+		{"missing file", pathError("open", "/registry/a", os.ErrNotExist), ErrorNotFound},
+		{"invalid path", storagedriver.InvalidPathError{Path: "a"}, ErrorInvalid},
+		{"invalid offset", storagedriver.InvalidOffsetError{Path: "/a", Offset: -1}, ErrorInvalid},
+		{"permission", pathError("mkdir", "/registry/a", os.ErrPermission), ErrorPermission},
+		{"access control", errors.New("org.apache.hadoop.security.AccessControlException: Permission denied: user=registry, access=WRITE, inode=/registry"), ErrorPermission},
+		{"exists", pathError("create", "/registry/a", os.ErrExist), ErrorConflict},
+		{"lease held", errLeaseHeld, ErrorConflict},
+		{"safe mode", errors.New("org.apache.hadoop.hdfs.server.namenode.SafeModeException: Cannot create directory /registry/a. Name node is in safe mode."), ErrorUnavailable},
+		{"standby", errors.New("org.apache.hadoop.ipc.StandbyException: Operation category WRITE is not supported in state standby"), ErrorUnavailable},
+		{"connection", pathError("stat", "/registry/a", io.ErrUnexpectedEOF), ErrorUnavailable},
+		{"missing block", pathError("read", "/registry/a", errBlockRead), ErrorUnavailable},
+		{"directory", errors.New("cannot write to /a: it is a directory"), ErrorInvalid},
+		{"noclobber", NoClobberError{Path: "/a"}, ErrorConflict},
+		{"quota", QuotaExceededError{Repository: "r", Quota: 1, Usage: 2}, ErrorPermission},
+		{"writes suspended", errWritesSuspended{until: time.Now()}, ErrorUnavailable},
+		{"unknown", errors.New("checksum mismatch"), ""},
+		{"enclosed", storagedriver.Error{DriverName: driverName, Enclosed: errLeaseHeld}, ErrorConflict},
+	} {
+		if got := ErrorCategoryOf(tc.err); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestClassifyErrors(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient()
+	client.writeFile("/registry/a", []byte("a"))
+	d := wrap(newTestDriverWithParameters(client, DriverParameters{ClassifyErrors: true}))
+	client.failWith("Rename", errors.New("Cannot rename /registry/a. Name node is in safe mode."))
+
+	err := d.Move(ctx, "/a", "/b")
+	enclosing, ok := err.(storagedriver.Error)
+	if !ok {
+		t.Fatalf("expected base.Base to enclose the error, got %T %v", err, err)
+	}
+	if classified, ok := enclosing.Enclosed.(CategorizedError); !ok || classified.Category() != ErrorUnavailable {
+		t.Fatalf("expected an unavailable ClassifiedError, got %T %v", enclosing.Enclosed, enclosing.Enclosed)
+	}
+
+	// The errors callers check by type are returned as they were
+	if _, err := d.Stat(ctx, "/missing"); ErrorCategoryOf(err) != ErrorNotFound {
+		t.Fatalf("expected a missing path, got %v", err)
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("expected PathNotFoundError, got %T", err)
+	}
+
+	// Without classifyerrors the errors are untouched but still classified
+	d = wrap(newTestDriver(client))
+	err = d.Move(ctx, "/a", "/b")
+	if enclosing, ok := err.(storagedriver.Error); !ok {
+		t.Fatalf("expected base.Base to enclose the error, got %T", err)
+	} else if _, ok := enclosing.Enclosed.(ClassifiedError); ok {
+		t.Fatal("expected no ClassifiedError without classifyerrors")
+	}
+	if category := ErrorCategoryOf(err); category != ErrorUnavailable {
+		t.Fatalf("expected the error to be classified as unavailable, got %q", category)
+	}
+}
@@ -0,0 +1,79 @@
+package hdfs
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Fault injection lets operators check that their retries and alerts work
+// by making driver operations fail or slow down on purpose. It is only
+// available in builds with the include_faultinjection tag, so that a
+// stray parameter cannot break a production registry:
+//
+//	faultinjection: GetContent,Stat
+//	faultinjectionerrorrate: 10
+//	faultinjectiondelay: 200ms
+//
+// fails one in ten of the listed operations and delays all of them.
+
+// errInjectedFault is returned by operations failed by faultinjection
+type errInjectedFault struct {
+	op string
+}
+
+func (e errInjectedFault) Error() string {
+	return fmt.Sprintf("hdfs: fault injected into %s", e.op)
+}
+
+// faultInjector fails errorRate percent of ops and delays them all
+type faultInjector struct {
+	ops       map[string]bool
+	errorRate int64
+	delay     time.Duration
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// newFaultInjector returns nil when ops, a comma separated list of driver
+// operations or "all", is empty
+func newFaultInjector(ops string, errorRate int64, delay time.Duration) (*faultInjector, error) {
+	names := splitList(ops)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if !faultInjectionBuild {
+		return nil, fmt.Errorf("The faultinjection parameter requires a registry built with the include_faultinjection tag")
+	}
+
+	f := &faultInjector{
+		ops:       make(map[string]bool, len(names)),
+		errorRate: errorRate,
+		delay:     delay,
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, name := range names {
+		f.ops[name] = true
+	}
+	return f, nil
+}
+
+// inject delays op and returns the error it fails with, if any
+func (f *faultInjector) inject(op string) error {
+	if f == nil || !(f.ops[op] || f.ops["all"]) {
+		return nil
+	}
+	if f.delay > 0 {
+		time.Sleep(f.delay)
+	}
+
+	f.mu.Lock()
+	fail := f.random.Int63n(100) < f.errorRate
+	f.mu.Unlock()
+	if fail {
+		return errInjectedFault{op: op}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !include_faultinjection
+// +build !include_faultinjection
+
+package hdfs
+
+// faultInjectionBuild is unset in regular builds, which refuse the
+// faultinjection parameter
+var faultInjectionBuild = false
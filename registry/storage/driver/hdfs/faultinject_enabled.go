@@ -0,0 +1,8 @@
+//go:build include_faultinjection
+// +build include_faultinjection
+
+package hdfs
+
+// faultInjectionBuild is set in builds made with the include_faultinjection
+// tag, the only ones that accept the faultinjection parameter
+var faultInjectionBuild = true
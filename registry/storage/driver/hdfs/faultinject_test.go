@@ -0,0 +1,73 @@
+package hdfs
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// withFaultInjectionBuild runs f as if built with include_faultinjection
+func withFaultInjectionBuild(f func()) {
+	saved := faultInjectionBuild
+	faultInjectionBuild = true
+	defer func() { faultInjectionBuild = saved }()
+	f()
+}
+
+func TestFaultInjection(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/a", []byte("a"))
+	ctx := context.Background()
+
+	withFaultInjectionBuild(func() {
+		d := newTestDriverWithParameters(client, DriverParameters{
+			FaultInjection:          "Stat",
+			FaultInjectionErrorRate: 100,
+			FaultInjectionDelay:     10 * time.Millisecond,
+		})
+
+		start := time.Now()
+		if _, err := d.Stat(ctx, "/a"); err == nil {
+			t.Fatal("expected an injected error")
+		} else if _, ok := err.(errInjectedFault); !ok {
+			t.Fatalf("expected errInjectedFault, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+			t.Fatalf("expected the delay to be injected, took %v", elapsed)
+		}
+		if calls := client.callCount("Stat"); calls != 0 {
+			t.Fatalf("expected the injected fault to stop the operation, got %d Stats", calls)
+		}
+
+		// Other operations are left alone
+		if _, err := d.GetContent(ctx, "/a"); err != nil {
+			t.Fatalf("unexpected error from GetContent: %v", err)
+		}
+	})
+}
+
+func TestFaultInjectionOffByDefault(t *testing.T) {
+	d := newTestDriver(newFakeClient())
+	if d.faults != nil {
+		t.Fatal("expected fault injection to be off by default")
+	}
+	params := DefaultParameters()
+	if params.FaultInjection != "" || params.FaultInjectionErrorRate != 0 {
+		t.Fatalf("expected no faults to be configured by default, got %+v", params)
+	}
+
+	// Regular builds refuse to inject faults
+	if faultInjectionBuild {
+		t.Skip("built with include_faultinjection")
+	}
+	params = validParameters()
+	params.FaultInjection = "all"
+	if err := params.Validate(); err == nil || !strings.Contains(err.Error(), "include_faultinjection") {
+		t.Fatalf("expected faultinjection to be refused without the include_faultinjection tag, got %v", err)
+	}
+	if _, err := newDriver(newFakeClient(), fillTestParameters(params)); err == nil {
+		t.Fatal("expected faultinjection to be refused without the include_faultinjection tag")
+	}
+}
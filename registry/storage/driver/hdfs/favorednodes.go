@@ -0,0 +1,68 @@
+package hdfs
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// favoredNodesCreator is implemented by clients that can pass favored
+// datanodes to the create RPC, as host:port of their data transfer port. A
+// replication or block size of 0 is the cluster default. colinmarc/hdfs
+// cannot, so the driver creates those files through WebHDFS instead, see
+// driver.createWithFavoredNodes.
+//
+// The hints are best effort: the namenode places replicas on the favored
+// nodes it can use and picks the others itself when a node is unknown,
+// dead, decommissioning or full, without failing the create. The balancer
+// may also move the blocks away later unless it is told to pin them.
+type favoredNodesCreator interface {
+	CreateWithFavoredNodes(name string, replication int, blockSize int64, perm os.FileMode, favoredNodes []string) (hdfsFileWriter, error)
+}
+
+// createWithFavoredNodes creates name with favoredNodes if c supports it
+func createWithFavoredNodes(c hdfsClient, name string, replication int, blockSize int64, perm os.FileMode, favoredNodes []string) (hdfsFileWriter, error) {
+	if f, ok := c.(favoredNodesCreator); ok {
+		return f.CreateWithFavoredNodes(name, replication, blockSize, perm, favoredNodes)
+	}
+	return nil, errUnsupportedByClient
+}
+
+// errFavoredNodesWebHdfs is returned by Validate for favorednodes without
+// WebHDFS, which colinmarc/hdfs needs to pass them
+var errFavoredNodesWebHdfs = fmt.Errorf("The favorednodes parameter requires hdfswebhdfsaddr, webhdfsport or webhdfstls")
+
+// createWithFavoredNodes creates the file at fullPath with favorednodes,
+// through WebHDFS when the client cannot pass them. WebHDFS streams the
+// first blocks of the file to the datanodes through the HTTP port of one
+// of them, appends to the file go through the client again and are placed
+// by the namenode alone. A file created through WebHDFS cannot be flushed
+// before it is closed.
+func (d *driver) createWithFavoredNodes(fullPath string, replication int, blockSize int64) (hdfsFileWriter, error) {
+	writer, err := createWithFavoredNodes(d.hdfsClient, fullPath, replication, blockSize, defaultFileMode, d.favoredNodes)
+	if err != errUnsupportedByClient {
+		return writer, err
+	}
+	if d.webHdfs == nil {
+		return nil, errWebHdfsRequired
+	}
+	// The datanode would only refuse the create once data is sent
+	if _, err := d.hdfsClient.Stat(fullPath); err == nil {
+		return nil, &os.PathError{Op: "create", Path: fullPath, Err: os.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return d.createThroughWebHdfs(fullPath, false, replication, blockSize)
+}
+
+// parseFavoredNodes splits the comma separated favorednodes parameter,
+// checking every entry is a host:port
+func parseFavoredNodes(value string) ([]string, error) {
+	nodes := splitList(value)
+	for _, node := range nodes {
+		if _, port, err := net.SplitHostPort(node); err != nil || port == "" {
+			return nil, fmt.Errorf("The favorednodes parameter should list datanodes as host:port, %q invalid", node)
+		}
+	}
+	return nodes, nil
+}
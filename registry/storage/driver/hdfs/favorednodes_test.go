@@ -0,0 +1,101 @@
+package hdfs
+
+import (
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestFavoredNodes(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{FavoredNodes: "dn1:9866, dn2:9866", CreateParents: true})
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/new/repo/file", []byte("contents")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := client.callCount("CreateWithFavoredNodes"); calls != 1 {
+		t.Fatalf("expected a single create with favored nodes, got %d", calls)
+	}
+	want := []string{"dn1:9866", "dn2:9866"}
+	if favored := client.files["/registry/new/repo/file"].favored; !reflect.DeepEqual(favored, want) {
+		t.Fatalf("expected favored nodes %v, got %v", want, favored)
+	}
+	if contents, err := d.GetContent(ctx, "/new/repo/file"); err != nil || string(contents) != "contents" {
+		t.Fatalf("unexpected contents %q, %v", contents, err)
+	}
+}
+
+func TestFavoredNodesThroughWebHdfs(t *testing.T) {
+	client := newFakeClient()
+	server := httptest.NewServer(&fakeWebHdfs{namenode: client})
+	defer server.Close()
+	ctx := context.Background()
+
+	// colinmarc/hdfs cannot pass favored nodes over RPC
+	d := newTestDriverWithParameters(basicClient{client}, DriverParameters{HdfsUser: "registry", WebHdfsAddress: server.URL, FavoredNodes: "dn1:9866,dn2:9866", Replication: 2})
+	if err := d.PutContent(ctx, "/new/repo/file", []byte("contents")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := client.callCount("CreateWithFavoredNodes"); calls != 1 {
+		t.Fatalf("expected a single create through WebHDFS, got %d", calls)
+	}
+	want := []string{"dn1:9866", "dn2:9866"}
+	if favored := client.files["/registry/new/repo/file"].favored; !reflect.DeepEqual(favored, want) {
+		t.Fatalf("expected favored nodes %v, got %v", want, favored)
+	}
+	if replication := client.replication("/registry/new/repo/file"); replication != 2 {
+		t.Fatalf("expected the replication to be passed along, got %d", replication)
+	}
+	if contents, err := d.GetContent(ctx, "/new/repo/file"); err != nil || string(contents) != "contents" {
+		t.Fatalf("unexpected contents %q, %v", contents, err)
+	}
+
+	// Uploads are created through WebHDFS too and appended to over RPC
+	writer, err := d.Writer(ctx, "/uploads/data", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	if _, err := writer.Write([]byte("first")); err != nil {
+		t.Fatalf("unexpected error from Write: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unexpected error from Close: %v", err)
+	}
+	if writer, err = d.Writer(ctx, "/uploads/data", true); err != nil {
+		t.Fatalf("unexpected error appending: %v", err)
+	}
+	writer.Write([]byte(" second"))
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("unexpected error from Commit: %v", err)
+	}
+	if contents, err := d.GetContent(ctx, "/uploads/data"); err != nil || string(contents) != "first second" {
+		t.Fatalf("unexpected contents %q, %v", contents, err)
+	}
+
+	// A file that is there is not replaced by a create
+	if _, err := d.createWithFavoredNodes("/registry/uploads/data", 0, 0); !os.IsExist(err) {
+		t.Fatalf("expected the create of an existing file to fail, got %v", err)
+	}
+}
+
+func TestFavoredNodesUnsupportedByClient(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(basicClient{client}, DriverParameters{FavoredNodes: "dn1:9866"})
+
+	// The hints are best effort, so writes go ahead without them
+	for _, path := range []string{"/a/file", "/b/file"} {
+		if err := d.PutContent(context.Background(), path, []byte("contents")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if creates := client.callCount("Create"); creates != 2 {
+		t.Fatalf("expected 2 plain creates, got %d", creates)
+	}
+	if mkdirs := client.callCount("MkdirAll"); mkdirs != 2 {
+		t.Fatalf("expected a MkdirAll per create, got %d", mkdirs)
+	}
+}
@@ -0,0 +1,60 @@
+package hdfs
+
+import (
+	"os"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// ExtendedFileInfo is implemented by every FileInfo returned from Stat. It
+// adds the ownership and permissions HDFS keeps for each path:
+//
+//	if info, ok := fi.(hdfs.ExtendedFileInfo); ok {
+//		log.Println(info.Owner(), info.Group(), info.Mode())
+//	}
+type ExtendedFileInfo interface {
+	storagedriver.FileInfo
+
+	// Owner returns the HDFS user owning the path
+	Owner() string
+
+	// Group returns the HDFS group of the path
+	Group() string
+
+	// Mode returns the permission bits of the path
+	Mode() os.FileMode
+
+	// Symlink returns the target of the path if it is a symbolic link
+	Symlink() string
+}
+
+// hdfsOwnership is implemented by the os.FileInfo that colinmarc/hdfs
+// returns for a FileStatus
+type hdfsOwnership interface {
+	Owner() string
+	OwnerGroup() string
+}
+
+// fileInfo implements ExtendedFileInfo
+type fileInfo struct {
+	storagedriver.FileInfoInternal
+	owner   string
+	group   string
+	mode    os.FileMode
+	symlink string
+}
+
+// newFileInfo carries the ownership and mode of fi over into info
+func newFileInfo(info storagedriver.FileInfoInternal, fi os.FileInfo) fileInfo {
+	extended := fileInfo{FileInfoInternal: info, mode: fi.Mode().Perm(), symlink: linkTarget(fi)}
+	if ownership, ok := fi.(hdfsOwnership); ok {
+		extended.owner = ownership.Owner()
+		extended.group = ownership.OwnerGroup()
+	}
+	return extended
+}
+
+func (fi fileInfo) Owner() string     { return fi.owner }
+func (fi fileInfo) Group() string     { return fi.group }
+func (fi fileInfo) Mode() os.FileMode { return fi.mode }
+func (fi fileInfo) Symlink() string   { return fi.symlink }
@@ -0,0 +1,119 @@
+package hdfs
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// leaseRenewer is implemented by clients that can issue the namenode's
+// renewLease RPC, which renews the leases of every file the client is
+// writing. colinmarc/hdfs cannot.
+type leaseRenewer interface {
+	RenewLease() error
+}
+
+// renewLease renews the leases of c if it supports it
+func renewLease(c hdfsClient) error {
+	if r, ok := c.(leaseRenewer); ok {
+		return r.RenewLease()
+	}
+	return errUnsupportedByClient
+}
+
+// flushingWriter flushes what was written to it every flushinterval while
+// it stays open. Resumable uploads may sit idle between chunks for long
+// stretches; the flush puts their bytes on the datanodes, where they
+// survive a registry crash, rather than leaving them buffered, and the
+// lease of the file is renewed where the client can. Idle writers with
+// nothing new to flush only renew. The timer stops when the writer is
+// closed or the upload cancelled.
+type flushingWriter struct {
+	hdfsFileWriter
+	renew func() error
+
+	// mu serializes the flushes with the writes, which the client does
+	// not allow concurrently
+	mu    sync.Mutex
+	dirty bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// flushPeriodically returns w flushed every flushinterval, or w itself
+// when flushinterval is 0
+func (d *driver) flushPeriodically(w hdfsFileWriter) hdfsFileWriter {
+	if d.flushInterval <= 0 || w == nil {
+		return w
+	}
+	client := d.hdfsClient
+	f := &flushingWriter{
+		hdfsFileWriter: w,
+		renew:          func() error { return renewLease(client) },
+		stop:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+	go f.loop(d.flushInterval)
+	return f
+}
+
+func (f *flushingWriter) loop(interval time.Duration) {
+	defer close(f.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+		f.mu.Lock()
+		if f.dirty {
+			if err := f.hdfsFileWriter.Flush(); err != nil {
+				log.Printf("hdfs: periodic flush: %v", err)
+			} else {
+				f.dirty = false
+			}
+		}
+		f.mu.Unlock()
+		if err := f.renew(); err != nil && err != errUnsupportedByClient {
+			log.Printf("hdfs: renewing the lease: %v", err)
+		}
+	}
+}
+
+// halt stops the timer and waits for a flush in progress
+func (f *flushingWriter) halt() {
+	f.once.Do(func() { close(f.stop) })
+	<-f.stopped
+}
+
+func (f *flushingWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.hdfsFileWriter.Write(p)
+	if n > 0 {
+		f.dirty = true
+	}
+	return n, err
+}
+
+func (f *flushingWriter) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.hdfsFileWriter.Flush()
+	if err == nil {
+		f.dirty = false
+	}
+	return err
+}
+
+func (f *flushingWriter) Close() error {
+	f.halt()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hdfsFileWriter.Close()
+}
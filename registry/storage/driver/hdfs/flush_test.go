@@ -0,0 +1,69 @@
+package hdfs
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestFlushInterval(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{FlushInterval: 5 * time.Millisecond})
+	goroutines := runtime.NumGoroutine()
+
+	writer, err := d.Writer(context.Background(), "/upload", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hdfsWriter := writer.(*fileWriter).hdfsWriter.(*flushingWriter).hdfsFileWriter.(*fakeWriter)
+	if _, err := writer.Write([]byte("chunk")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The upload then sits idle
+	deadline := time.Now().Add(time.Second)
+	for client.callCount("RenewLease") < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	renewals := client.callCount("RenewLease")
+	if renewals < 3 {
+		t.Fatalf("expected the lease to be renewed while idle, got %d renewals", renewals)
+	}
+	// Only the bytes written were flushed, once
+	if hdfsWriter.flushes != 1 {
+		t.Fatalf("expected a single flush of the idle upload, got %d", hdfsWriter.flushes)
+	}
+
+	// The timer stopped with the writer
+	time.Sleep(20 * time.Millisecond)
+	if calls := client.callCount("RenewLease"); calls != renewals {
+		t.Fatalf("expected no renewals after Close, got %d more", calls-renewals)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Fatalf("expected the flush goroutine to exit, %d goroutines running, %d before", n, goroutines)
+	}
+}
+
+func TestFlushIntervalStopsOnCancel(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{FlushInterval: time.Millisecond})
+
+	writer, err := d.Writer(context.Background(), "/upload", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := writer.Cancel(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	renewals := client.callCount("RenewLease")
+	time.Sleep(10 * time.Millisecond)
+	if calls := client.callCount("RenewLease"); calls != renewals {
+		t.Fatalf("expected no renewals after Cancel, got %d more", calls-renewals)
+	}
+	writer.Close()
+}
@@ -0,0 +1,69 @@
+package hdfs
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/colinmarc/hdfs"
+)
+
+// freeSpaceCacheTTL is how long the remaining capacity reported by the
+// namenode is trusted, so that writes do not cost an extra RPC each
+const freeSpaceCacheTTL = 10 * time.Second
+
+// InsufficientSpaceError is returned by writes while the filesystem has
+// less than minfreebytes remaining
+type InsufficientSpaceError struct {
+	Remaining uint64
+	MinFree   uint64
+}
+
+func (e InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("hdfs: %d bytes remaining, writes are refused below minfreebytes of %d", e.Remaining, e.MinFree)
+}
+
+// freeSpaceCheck refuses writes once the remaining capacity of the
+// filesystem drops below minFree. A full HDFS destabilizes the whole
+// cluster, so the registry stops adding to it first. The capacity counts
+// raw space, replicas included, like the namenode's FsStatus.
+type freeSpaceCheck struct {
+	minFree uint64
+	statFs  func() (hdfs.FsInfo, error)
+	now     func() time.Time
+
+	mu        sync.Mutex
+	checked   time.Time
+	remaining uint64
+	known     bool
+}
+
+func newFreeSpaceCheck(minFree uint64, statFs func() (hdfs.FsInfo, error)) *freeSpaceCheck {
+	return &freeSpaceCheck{minFree: minFree, statFs: statFs, now: time.Now}
+}
+
+// allow returns an InsufficientSpaceError if too little space remains.
+// When the capacity cannot be fetched writes are allowed, since a failed
+// status RPC says nothing about the space left.
+func (c *freeSpaceCheck) allow() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now := c.now(); now.Sub(c.checked) >= freeSpaceCacheTTL {
+		c.checked = now
+		info, err := c.statFs()
+		c.known = err == nil
+		if err != nil {
+			log.Printf("hdfs: unable to check the remaining capacity for minfreebytes: %v", err)
+		}
+		c.remaining = info.Remaining
+	}
+	if c.known && c.remaining < c.minFree {
+		return InsufficientSpaceError{Remaining: c.remaining, MinFree: c.minFree}
+	}
+	return nil
+}
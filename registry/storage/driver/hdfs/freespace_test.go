@@ -0,0 +1,53 @@
+package hdfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/colinmarc/hdfs"
+	"github.com/docker/distribution/context"
+)
+
+// lowSpaceClient reports remaining bytes left on the filesystem
+type lowSpaceClient struct {
+	*fakeClient
+	remaining *uint64
+}
+
+func (c lowSpaceClient) StatFs() (hdfs.FsInfo, error) {
+	c.enter("StatFs", "/")
+	defer c.mu.Unlock()
+	return hdfs.FsInfo{Capacity: fakeCapacity, Remaining: *c.remaining}, nil
+}
+
+func TestMinFreeBytes(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/a", []byte("a"))
+	remaining := uint64(1 << 10)
+	d := newTestDriverWithParameters(lowSpaceClient{client, &remaining}, DriverParameters{MinFreeBytes: 1 << 20})
+	now := time.Now()
+	d.freeSpace.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/b", []byte("b")); err == nil {
+		t.Fatal("expected PutContent to be refused")
+	} else if _, ok := err.(InsufficientSpaceError); !ok {
+		t.Fatalf("expected an InsufficientSpaceError, got %v", err)
+	}
+	if _, err := d.Writer(ctx, "/b", false); err == nil {
+		t.Fatal("expected Writer to be refused")
+	}
+	if content, err := d.GetContent(ctx, "/a"); err != nil || string(content) != "a" {
+		t.Fatalf("expected reads to keep working, got %q, %v", content, err)
+	}
+	if calls := client.callCount("StatFs"); calls != 1 {
+		t.Fatalf("expected the capacity to be cached, got %d StatFs calls", calls)
+	}
+
+	// Space freed up is noticed once the cached capacity expires
+	remaining = 1 << 30
+	now = now.Add(freeSpaceCacheTTL)
+	if err := d.PutContent(ctx, "/b", []byte("b")); err != nil {
+		t.Fatalf("unexpected error once space is available: %v", err)
+	}
+}
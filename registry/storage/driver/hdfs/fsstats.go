@@ -0,0 +1,52 @@
+package hdfs
+
+import (
+	"github.com/colinmarc/hdfs"
+	"github.com/docker/distribution/context"
+)
+
+// FilesystemStats is the capacity of the filesystem backing the driver,
+// in bytes. Used and Remaining count raw space, including replicas.
+type FilesystemStats struct {
+	Capacity  uint64
+	Used      uint64
+	Remaining uint64
+}
+
+// FilesystemStatter is implemented by drivers that can report the capacity
+// of their backing filesystem, such as the HDFS driver
+type FilesystemStatter interface {
+	FilesystemStats(ctx context.Context) (FilesystemStats, error)
+}
+
+// fsStatter is implemented by clients that can fetch the namenode's
+// FsStatus. colinmarc/hdfs does.
+type fsStatter interface {
+	StatFs() (hdfs.FsInfo, error)
+}
+
+// statFs fetches the filesystem status if c supports it
+func statFs(c hdfsClient) (hdfs.FsInfo, error) {
+	if s, ok := c.(fsStatter); ok {
+		return s.StatFs()
+	}
+	return hdfs.FsInfo{}, errUnsupportedByClient
+}
+
+// FilesystemStats implements FilesystemStatter
+func (d *Driver) FilesystemStats(ctx context.Context) (FilesystemStats, error) {
+	inner := d.inner()
+	if err := inner.checkClient(); err != nil {
+		return FilesystemStats{}, err
+	}
+
+	info, err := statFs(inner.hdfsClient)
+	if err != nil {
+		return FilesystemStats{}, err
+	}
+	return FilesystemStats{
+		Capacity:  info.Capacity,
+		Used:      info.Used,
+		Remaining: info.Remaining,
+	}, nil
+}
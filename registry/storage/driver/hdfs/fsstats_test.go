@@ -0,0 +1,43 @@
+package hdfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestFilesystemStats(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blob", []byte("0123456789"))
+	var sd storagedriver.StorageDriver = wrap(newTestDriver(client))
+
+	statter, ok := sd.(FilesystemStatter)
+	if !ok {
+		t.Fatalf("expected the driver to implement FilesystemStatter")
+	}
+
+	stats, err := statter.FilesystemStats(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Capacity != fakeCapacity || stats.Used != 10 || stats.Remaining != fakeCapacity-10 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestFilesystemStatsErrors(t *testing.T) {
+	client := newFakeClient()
+	unavailable := errors.New("namenode unavailable")
+	client.failWith("StatFs", unavailable)
+
+	if _, err := wrap(newTestDriver(client)).FilesystemStats(context.Background()); err != unavailable {
+		t.Fatalf("expected the namenode error, got %v", err)
+	}
+
+	// Clients without FsStatus support say so
+	if _, err := wrap(newTestDriver(basicClient{newFakeClient()})).FilesystemStats(context.Background()); err != errUnsupportedByClient {
+		t.Fatalf("expected errUnsupportedByClient, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package hdfs
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hadoopConfFiles are the client configuration files read from the Hadoop
+// configuration directory, in the order native clients apply them.
+var hadoopConfFiles = []string{"core-site.xml", "hdfs-site.xml"}
+
+// hadoopConf holds the properties loaded from a Hadoop configuration
+// directory.
+type hadoopConf map[string]string
+
+type hadoopConfiguration struct {
+	Properties []struct {
+		Name  string `xml:"name"`
+		Value string `xml:"value"`
+	} `xml:"property"`
+}
+
+// hadoopConfDir resolves the Hadoop configuration directory the same way
+// native clients do: HADOOP_CONF_DIR if set, otherwise the configuration
+// directory under HADOOP_HOME. It returns "" when neither is set.
+func hadoopConfDir() string {
+	if dir := os.Getenv("HADOOP_CONF_DIR"); dir != "" {
+		return dir
+	}
+	if home := os.Getenv("HADOOP_HOME"); home != "" {
+		// Hadoop 2 and later keep the configuration in etc/hadoop
+		dir := filepath.Join(home, "etc", "hadoop")
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		return filepath.Join(home, "conf")
+	}
+	return ""
+}
+
+// loadHadoopConf reads the Hadoop client configuration files in dir. Missing
+// files are skipped, malformed ones are an error.
+func loadHadoopConf(dir string) (hadoopConf, error) {
+	conf := hadoopConf{}
+	for _, name := range hadoopConfFiles {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var parsed hadoopConfiguration
+		if err := xml.Unmarshal(contents, &parsed); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", filepath.Join(dir, name), err)
+		}
+		for _, property := range parsed.Properties {
+			conf[strings.TrimSpace(property.Name)] = strings.TrimSpace(property.Value)
+		}
+	}
+	return conf, nil
+}
+
+// namenodes returns the namenode RPC addresses described by the
+// configuration. HA nameservices take precedence over fs.defaultFS.
+func (conf hadoopConf) namenodes() []string {
+	var namenodes []string
+	for _, nameservice := range splitList(conf["dfs.nameservices"]) {
+		for _, nn := range splitList(conf["dfs.ha.namenodes."+nameservice]) {
+			if address := conf["dfs.namenode.rpc-address."+nameservice+"."+nn]; address != "" {
+				namenodes = append(namenodes, address)
+			}
+		}
+	}
+	if len(namenodes) > 0 {
+		return namenodes
+	}
+
+	// Non-HA clusters may list the rpc addresses directly
+	var keys []string
+	for key := range conf {
+		if strings.HasPrefix(key, "dfs.namenode.rpc-address") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		namenodes = append(namenodes, conf[key])
+	}
+	if len(namenodes) > 0 {
+		return namenodes
+	}
+
+	if defaultFS, err := url.Parse(conf["fs.defaultFS"]); err == nil && defaultFS.Scheme == "hdfs" && defaultFS.Host != "" {
+		return []string{defaultFS.Host}
+	}
+	return nil
+}
+
+// keyProvider returns the KMS used for encryption zones, if any
+func (conf hadoopConf) keyProvider() string {
+	if provider := conf["hadoop.security.key.provider.path"]; provider != "" {
+		return provider
+	}
+	return conf["dfs.encryption.key.provider.uri"]
+}
+
+// authentication returns the configured hadoop.security.authentication mode
+func (conf hadoopConf) authentication() string {
+	if mode := conf["hadoop.security.authentication"]; mode != "" {
+		return strings.ToLower(mode)
+	}
+	return "simple"
+}
+
+// applyHadoopEnvironment fills in any parameter that was not set explicitly
+// from the Hadoop configuration found via HADOOP_CONF_DIR or HADOOP_HOME.
+func applyHadoopEnvironment(params *DriverParameters) error {
+	dir := hadoopConfDir()
+	if dir == "" {
+		return fmt.Errorf("usehadoopenv is set but neither HADOOP_CONF_DIR nor HADOOP_HOME is")
+	}
+
+	conf, err := loadHadoopConf(dir)
+	if err != nil {
+		return err
+	}
+
+	if params.HdfsNameNode == "" {
+		params.HdfsNameNode = strings.Join(conf.namenodes(), ",")
+	}
+	if params.HdfsUser == "" {
+		params.HdfsUser = os.Getenv("HADOOP_USER_NAME")
+	}
+	if params.KmsURI == "" {
+		params.KmsURI = conf.keyProvider()
+	}
+	if params.WritePipelineRecovery == "" {
+		params.WritePipelineRecovery = conf[pipelineRecoveryProperty]
+	}
+	if params.DFSPacketSize == 0 && conf[dfsPacketSizeProperty] != "" {
+		packetSize, err := strconv.ParseInt(conf[dfsPacketSizeProperty], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q in %s", dfsPacketSizeProperty, conf[dfsPacketSizeProperty], dir)
+		}
+		params.DFSPacketSize = packetSize
+	}
+	if params.SocketTimeout == 0 && conf[socketTimeoutProperty] != "" {
+		timeout, err := parseSocketTimeout(conf[socketTimeoutProperty])
+		if err != nil {
+			return fmt.Errorf("invalid %s %q in %s", socketTimeoutProperty, conf[socketTimeoutProperty], dir)
+		}
+		params.SocketTimeout = timeout
+	}
+	if mode := conf.authentication(); mode != "simple" {
+		return fmt.Errorf("hadoop.security.authentication %q in %s is not supported", mode, dir)
+	}
+	return nil
+}
+
+// splitList splits a comma separated configuration value, dropping blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
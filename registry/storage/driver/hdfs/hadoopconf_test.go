@@ -0,0 +1,120 @@
+package hdfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testCoreSite = `<?xml version="1.0"?>
+<configuration>
+  <property>
+    <name>fs.defaultFS</name>
+    <value>hdfs://registry</value>
+  </property>
+  <property>
+    <name>hadoop.security.authentication</name>
+    <value>simple</value>
+  </property>
+</configuration>
+`
+
+const testHdfsSite = `<?xml version="1.0"?>
+<configuration>
+  <property>
+    <name>dfs.nameservices</name>
+    <value>registry</value>
+  </property>
+  <property>
+    <name>dfs.ha.namenodes.registry</name>
+    <value>nn1,nn2</value>
+  </property>
+  <property>
+    <name>dfs.namenode.rpc-address.registry.nn1</name>
+    <value>namenode1.example.com:8020</value>
+  </property>
+  <property>
+    <name>dfs.namenode.rpc-address.registry.nn2</name>
+    <value>namenode2.example.com:8020</value>
+  </property>
+</configuration>
+`
+
+// withHadoopConfDir writes the given files into a temporary directory and
+// points HADOOP_CONF_DIR at it for the duration of the test.
+func withHadoopConfDir(t *testing.T, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "hadoop-conf-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldConfDir, oldHome := os.Getenv("HADOOP_CONF_DIR"), os.Getenv("HADOOP_HOME")
+	os.Setenv("HADOOP_CONF_DIR", dir)
+	os.Unsetenv("HADOOP_HOME")
+	return func() {
+		os.Setenv("HADOOP_CONF_DIR", oldConfDir)
+		os.Setenv("HADOOP_HOME", oldHome)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHadoopEnvironmentNamenodes(t *testing.T) {
+	defer withHadoopConfDir(t, map[string]string{
+		"core-site.xml": testCoreSite,
+		"hdfs-site.xml": testHdfsSite,
+	})()
+
+	params := DriverParameters{}
+	if err := applyHadoopEnvironment(&params); err != nil {
+		t.Fatalf("unexpected error loading Hadoop configuration: %v", err)
+	}
+	if expected := "namenode1.example.com:8020,namenode2.example.com:8020"; params.HdfsNameNode != expected {
+		t.Fatalf("expected namenodes %q from HADOOP_CONF_DIR, got %q", expected, params.HdfsNameNode)
+	}
+}
+
+func TestHadoopEnvironmentDefaultFS(t *testing.T) {
+	defer withHadoopConfDir(t, map[string]string{
+		"core-site.xml": `<configuration><property><name>fs.defaultFS</name><value>hdfs://namenode.example.com:9000</value></property></configuration>`,
+	})()
+
+	conf, err := loadHadoopConf(hadoopConfDir())
+	if err != nil {
+		t.Fatalf("unexpected error loading Hadoop configuration: %v", err)
+	}
+	if namenodes := conf.namenodes(); !reflect.DeepEqual(namenodes, []string{"namenode.example.com:9000"}) {
+		t.Fatalf("unexpected namenodes from fs.defaultFS: %v", namenodes)
+	}
+}
+
+func TestHadoopEnvironmentExplicitParametersWin(t *testing.T) {
+	defer withHadoopConfDir(t, map[string]string{
+		"core-site.xml": testCoreSite,
+		"hdfs-site.xml": testHdfsSite,
+	})()
+
+	params := DriverParameters{HdfsNameNode: "explicit.example.com:8020", HdfsUser: "registry"}
+	if err := applyHadoopEnvironment(&params); err != nil {
+		t.Fatalf("unexpected error loading Hadoop configuration: %v", err)
+	}
+	if params.HdfsNameNode != "explicit.example.com:8020" || params.HdfsUser != "registry" {
+		t.Fatalf("explicit parameters were overridden: %+v", params)
+	}
+}
+
+func TestHadoopEnvironmentRejectsKerberos(t *testing.T) {
+	defer withHadoopConfDir(t, map[string]string{
+		"core-site.xml": `<configuration><property><name>hadoop.security.authentication</name><value>kerberos</value></property></configuration>`,
+	})()
+
+	if err := applyHadoopEnvironment(&DriverParameters{}); err == nil {
+		t.Fatal("expected an error for an unsupported authentication mode")
+	}
+}
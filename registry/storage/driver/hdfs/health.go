@@ -0,0 +1,95 @@
+package hdfs
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"os"
+)
+
+// HealthReporter is implemented by drivers that can report their state
+// over HTTP, such as the HDFS driver, for operators without Prometheus. The
+// registry or a sidecar can mount the handler:
+//
+//	if reporter, ok := driver.(hdfs.HealthReporter); ok {
+//		http.Handle("/debug/hdfs", reporter.HealthHandler())
+//	}
+type HealthReporter interface {
+	HealthHandler() http.Handler
+}
+
+// Health is the JSON document HealthHandler serves
+type Health struct {
+	NameNode      string `json:"namenode"`
+	RootDirectory string `json:"rootdirectory"`
+
+	// Connected reports whether the namenode answered a stat of the root
+	// directory when the document was requested, Error why not
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"`
+
+	WritesSuspended bool `json:"writessuspended"`
+
+	// ActiveUploads and MaxUploads are the maxconcurrentuploads slots
+	// taken and available, 0 without a limit
+	ActiveUploads int `json:"activeuploads"`
+	MaxUploads    int `json:"maxuploads"`
+
+	// The counters of registry.storage.hdfs, which are kept for all the
+	// drivers of the process, by operation
+	BytesRead    map[string]int64 `json:"bytesread"`
+	BytesWritten map[string]int64 `json:"byteswritten"`
+	Errors       map[string]int64 `json:"errors"`
+	Reconnects   int64            `json:"reconnects"`
+	Failovers    int64            `json:"failovers"`
+}
+
+// HealthHandler implements HealthReporter. Every request stats the root
+// directory to tell whether the namenode is reachable.
+func (d *Driver) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := d.inner().health()
+		w.Header().Set("Content-Type", "application/json")
+		if !health.Connected {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	})
+}
+
+func (d *driver) health() Health {
+	health := Health{
+		NameNode:        d.hdfsNameNode,
+		RootDirectory:   d.hdfsRootDirectory,
+		WritesSuspended: d.writes.suspended(),
+		BytesRead:       expvarCounts(transfers.bytesRead),
+		BytesWritten:    expvarCounts(transfers.bytesWritten),
+		Errors:          expvarCounts(transfers.errors),
+		Reconnects:      transfers.reconnects.Value(),
+		Failovers:       transfers.failovers.Value(),
+	}
+	health.ActiveUploads, health.MaxUploads = d.uploads.usage()
+
+	err := d.checkClient()
+	if err == nil {
+		if _, err = d.hdfsClient.Stat(d.hdfsRootDirectory); os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	health.Connected = err == nil
+	if err != nil {
+		health.Error = err.Error()
+	}
+	return health
+}
+
+// expvarCounts returns the counters of m
+func expvarCounts(m *expvar.Map) map[string]int64 {
+	counts := make(map[string]int64)
+	m.Do(func(kv expvar.KeyValue) {
+		if counter, ok := kv.Value.(*expvar.Int); ok {
+			counts[kv.Key] = counter.Value()
+		}
+	})
+	return counts
+}
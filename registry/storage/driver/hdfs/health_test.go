@@ -0,0 +1,58 @@
+package hdfs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func getHealth(t *testing.T, handler http.Handler) (int, map[string]interface{}) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/hdfs", nil))
+	var health map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &health); err != nil {
+		t.Fatalf("unexpected error decoding %q: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code, health
+}
+
+func TestHealthHandler(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{MaxConcurrentUploads: 4})
+	d.hdfsNameNode = "namenode:8020"
+	sd := wrap(d)
+
+	code, health := getHealth(t, sd.HealthHandler())
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	for _, field := range []string{"namenode", "rootdirectory", "connected", "writessuspended", "activeuploads", "maxuploads", "bytesread", "byteswritten", "errors"} {
+		if _, ok := health[field]; !ok {
+			t.Errorf("expected the health document to include %s, got %v", field, health)
+		}
+	}
+	if health["namenode"] != "namenode:8020" || health["connected"] != true || health["maxuploads"] != 4.0 {
+		t.Fatalf("unexpected health %v", health)
+	}
+
+	// Failed operations are counted and an unreachable namenode reported
+	client.writeFile("/registry/file", []byte("contents"))
+	before := expvarCounts(transfers.errors)["Move"]
+	client.failWith("Rename", errors.New("connection refused"))
+	sd.Move(context.Background(), "/file", "/moved")
+	if after := expvarCounts(transfers.errors)["Move"]; after != before+1 {
+		t.Fatalf("expected the failed Move to be counted, got %d errors after %d", after, before)
+	}
+	client.failWith("Stat", errors.New("connection refused"))
+	code, health = getHealth(t, sd.HealthHandler())
+	if code != http.StatusServiceUnavailable || health["connected"] != false || health["error"] == nil {
+		t.Fatalf("expected the namenode to be reported unreachable, got %d %v", code, health)
+	}
+	if errs, ok := health["errors"].(map[string]interface{}); !ok || errs["Move"] == nil {
+		t.Fatalf("expected the Stat errors to be reported, got %v", health["errors"])
+	}
+}
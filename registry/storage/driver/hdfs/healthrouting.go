@@ -0,0 +1,282 @@
+package hdfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// colinmarc/hdfs connects to the first of its namenodes that answers and
+// only moves to the next when an operation fails, so with HA namenodes the
+// client may sit on a slow namenode, or learn that one is a standby one
+// failed operation at a time. With healthrouting set, a prober checks every
+// namenode each healthprobeinterval: how long connecting to its RPC port
+// takes and, when webhdfsport or webhdfstls is set, whether its web server
+// reports it active or standby. Connections then go to the reachable active
+// namenodes first, fastest first, then to standbys, then to the namenodes
+// that could not be reached; a failover still moves on to the next one.
+// When the prober finds the namenode of the connection in standby or
+// unreachable, the driver reconnects before an operation has to fail.
+//
+// The prober runs for the lifetime of the process.
+
+// defaultHealthProbeInterval is how often namenodes are probed unless
+// healthprobeinterval says otherwise
+const defaultHealthProbeInterval = 10 * time.Second
+
+// healthProbeTimeout bounds a single probe, so that a namenode that does
+// not answer does not hold up the others
+const healthProbeTimeout = 5 * time.Second
+
+// namenodeHealth is what the last probe found out about a namenode
+type namenodeHealth struct {
+	probed    bool
+	reachable bool
+	standby   bool
+	latency   time.Duration
+}
+
+// rank orders namenodes by health, lower first. Namenodes not probed yet
+// go with the healthy ones, after them.
+func (h namenodeHealth) rank() int {
+	switch {
+	case !h.probed:
+		return 0
+	case !h.reachable:
+		return 2
+	case h.standby:
+		return 1
+	}
+	return 0
+}
+
+// namenodeProbe probes the namenode at address, reporting whether it is a
+// standby
+type namenodeProbe func(ctx context.Context, address string) (standby bool, err error)
+
+// healthRouter orders namenodes by the health its prober found
+type healthRouter struct {
+	probe    namenodeProbe
+	interval time.Duration
+
+	// unhealthy is called when the namenode connected to turned out to be
+	// a standby or unreachable
+	unhealthy func()
+
+	mu        sync.Mutex
+	addresses []string
+	connected string
+	health    map[string]namenodeHealth
+	started   bool
+	stopped   bool
+
+	// done is closed by stop to end the probing
+	done chan struct{}
+}
+
+func newHealthRouter(probe namenodeProbe, interval time.Duration) *healthRouter {
+	if interval <= 0 {
+		interval = defaultHealthProbeInterval
+	}
+	return &healthRouter{probe: probe, interval: interval, health: make(map[string]namenodeHealth), done: make(chan struct{})}
+}
+
+// order returns addresses healthiest first, remembering them for the
+// prober and the first one as the namenode connected to
+func (r *healthRouter) order(addresses []string) []string {
+	if r == nil {
+		return addresses
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ordered := append([]string(nil), addresses...)
+	sort.Stable(byHealth{ordered, r.health})
+	r.addresses = append([]string(nil), addresses...)
+	if len(ordered) > 0 {
+		r.connected = ordered[0]
+	}
+	return ordered
+}
+
+// byHealth sorts addresses by rank, then latency
+type byHealth struct {
+	addresses []string
+	health    map[string]namenodeHealth
+}
+
+func (b byHealth) Len() int      { return len(b.addresses) }
+func (b byHealth) Swap(i, j int) { b.addresses[i], b.addresses[j] = b.addresses[j], b.addresses[i] }
+func (b byHealth) Less(i, j int) bool {
+	hi, hj := b.health[b.addresses[i]], b.health[b.addresses[j]]
+	if hi.rank() != hj.rank() {
+		return hi.rank() < hj.rank()
+	}
+	if hi.probed != hj.probed {
+		return hi.probed
+	}
+	return hi.latency < hj.latency
+}
+
+// probeAll probes every namenode order last saw, concurrently
+func (r *healthRouter) probeAll() {
+	r.mu.Lock()
+	addresses := append([]string(nil), r.addresses...)
+	r.mu.Unlock()
+
+	results := make([]namenodeHealth, len(addresses))
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+			defer cancel()
+			start := time.Now()
+			standby, err := r.probe(ctx, address)
+			results[i] = namenodeHealth{probed: true, reachable: err == nil, standby: standby, latency: time.Since(start)}
+			if err != nil {
+				log.Printf("hdfs: probing namenode %s: %v", address, err)
+			}
+		}(i, address)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	for i, address := range addresses {
+		r.health[address] = results[i]
+	}
+	// Reconnecting only helps when another namenode is healthy
+	unhealthy := false
+	if connected, ok := r.health[r.connected]; ok && connected.rank() > 0 {
+		for _, address := range addresses {
+			if r.health[address].rank() == 0 {
+				unhealthy = true
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	if unhealthy && r.unhealthy != nil && !r.isStopped() {
+		r.unhealthy()
+	}
+}
+
+// start probes the namenodes now and every interval from then on
+func (r *healthRouter) start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return
+	}
+	r.started = true
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			r.probeAll()
+			select {
+			case <-ticker.C:
+			case <-r.done:
+				return
+			}
+		}
+	}()
+}
+
+func (r *healthRouter) isStopped() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopped
+}
+
+// stop ends the probing started by start. A probe in progress completes,
+// but does not reconnect.
+func (r *healthRouter) stop() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.stopped {
+		r.stopped = true
+		close(r.done)
+	}
+}
+
+// newNamenodeProbe returns the probe of the healthrouting parameter, which
+// times connecting to the RPC port with dial and, when WebHDFS is
+// configured, asks the web server of the namenode whether it is a standby
+func newNamenodeProbe(params DriverParameters, dial dialFunc) namenodeProbe {
+	scheme, port := "", params.WebHdfsPort
+	if params.WebHdfsTLS {
+		scheme = "https"
+		if port == 0 {
+			port = defaultWebHdfsTLSPort
+		}
+	} else if port != 0 {
+		scheme = "http"
+	}
+	tlsConfig, _ := newTLSConfig(params.TLSCAFile)
+	client := newHTTPClient(tlsConfig)
+
+	return func(ctx context.Context, address string) (bool, error) {
+		conn, err := dial(ctx, "tcp", address)
+		if err != nil {
+			return false, err
+		}
+		conn.Close()
+		if scheme == "" {
+			return false, nil
+		}
+
+		host := address
+		if h, _, err := net.SplitHostPort(address); err == nil {
+			host = h
+		}
+		state, err := namenodeState(ctx, client, scheme+"://"+net.JoinHostPort(host, strconv.FormatInt(port, 10)))
+		if err != nil {
+			// The RPC port answered, the namenode is usable either way
+			log.Printf("hdfs: reading the HA state of namenode %s: %v", address, err)
+			return false, nil
+		}
+		return state == "standby", nil
+	}
+}
+
+// namenodeState returns the HA state, such as active or standby, that the
+// namenode serving base reports in its NameNodeStatus JMX bean
+func namenodeState(ctx context.Context, client *http.Client, base string) (string, error) {
+	req, err := http.NewRequest("GET", base+"/jmx?qry=Hadoop:service=NameNode,name=NameNodeStatus", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var status struct {
+		Beans []struct {
+			State string `json:"State"`
+		} `json:"beans"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return "", err
+	}
+	if len(status.Beans) == 0 {
+		return "", fmt.Errorf("no NameNodeStatus bean")
+	}
+	return status.Beans[0].State, nil
+}
@@ -0,0 +1,144 @@
+package hdfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockNamenode is a namenode as the prober sees it
+type mockNamenode struct {
+	mu      sync.Mutex
+	latency time.Duration
+	standby bool
+	down    bool
+}
+
+func (nn *mockNamenode) set(standby, down bool) {
+	nn.mu.Lock()
+	defer nn.mu.Unlock()
+	nn.standby, nn.down = standby, down
+}
+
+// newMockRouter returns a router probing the mock namenodes
+func newMockRouter(namenodes map[string]*mockNamenode) *healthRouter {
+	return newHealthRouter(func(ctx context.Context, address string) (bool, error) {
+		nn := namenodes[address]
+		nn.mu.Lock()
+		defer nn.mu.Unlock()
+		time.Sleep(nn.latency)
+		if nn.down {
+			return false, errors.New("connection refused")
+		}
+		return nn.standby, nil
+	}, time.Minute)
+}
+
+func TestHealthRoutingPrefersHealthierNamenode(t *testing.T) {
+	namenodes := map[string]*mockNamenode{
+		"nn1:8020": {latency: 100 * time.Millisecond},
+		"nn2:8020": {},
+	}
+	r := newMockRouter(namenodes)
+
+	addresses := []string{"nn1:8020", "nn2:8020"}
+	if ordered := r.order(addresses); !reflect.DeepEqual(ordered, addresses) {
+		t.Fatalf("expected the configured order before probing, got %v", ordered)
+	}
+	r.probeAll()
+	if ordered := r.order(addresses); !reflect.DeepEqual(ordered, []string{"nn2:8020", "nn1:8020"}) {
+		t.Fatalf("expected the faster namenode first, got %v", ordered)
+	}
+
+	// A standby goes after the active namenode, however fast it is
+	namenodes["nn2:8020"].set(true, false)
+	r.probeAll()
+	if ordered := r.order(addresses); !reflect.DeepEqual(ordered, []string{"nn1:8020", "nn2:8020"}) {
+		t.Fatalf("expected the active namenode first, got %v", ordered)
+	}
+
+	// And a namenode that cannot be reached after a standby
+	namenodes["nn1:8020"].set(false, true)
+	r.probeAll()
+	if ordered := r.order(addresses); !reflect.DeepEqual(ordered, []string{"nn2:8020", "nn1:8020"}) {
+		t.Fatalf("expected the unreachable namenode last, got %v", ordered)
+	}
+}
+
+func TestHealthRoutingReconnectsFromUnhealthyNamenode(t *testing.T) {
+	namenodes := map[string]*mockNamenode{
+		"nn1:8020": {},
+		"nn2:8020": {},
+	}
+	r := newMockRouter(namenodes)
+	reconnects := 0
+	r.unhealthy = func() { reconnects++ }
+
+	r.order([]string{"nn1:8020", "nn2:8020"})
+	r.probeAll()
+	if reconnects != 0 {
+		t.Fatalf("expected no reconnect while the namenode is healthy, got %d", reconnects)
+	}
+
+	namenodes["nn1:8020"].set(true, false)
+	r.probeAll()
+	if reconnects != 1 {
+		t.Fatalf("expected a reconnect once the namenode became a standby, got %d", reconnects)
+	}
+	if ordered := r.order([]string{"nn1:8020", "nn2:8020"}); ordered[0] != "nn2:8020" {
+		t.Fatalf("expected the reconnect to go to the active namenode, got %v", ordered)
+	}
+
+	// Without a healthy namenode to go to there is no point reconnecting
+	namenodes["nn2:8020"].set(false, true)
+	r.probeAll()
+	if reconnects != 1 {
+		t.Fatalf("expected no reconnect without a healthy namenode, got %d", reconnects)
+	}
+}
+
+func TestHealthRoutingStops(t *testing.T) {
+	probes := make(chan string, 10)
+	r := newHealthRouter(func(ctx context.Context, address string) (bool, error) {
+		probes <- address
+		return false, nil
+	}, time.Millisecond)
+	r.order([]string{"nn1:8020"})
+	r.start()
+	<-probes
+
+	r.stop()
+	r.stop()
+	// Drain a probe that was in progress when stopping
+	time.Sleep(10 * time.Millisecond)
+	for len(probes) > 0 {
+		<-probes
+	}
+	time.Sleep(10 * time.Millisecond)
+	if len(probes) != 0 {
+		t.Fatalf("expected no probes after stop, got %d", len(probes))
+	}
+}
+
+func TestNamenodeState(t *testing.T) {
+	for _, state := range []string{"active", "standby"} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("qry") != "Hadoop:service=NameNode,name=NameNodeStatus" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `{"beans":[{"name":"Hadoop:service=NameNode,name=NameNodeStatus","State":%q}]}`, state)
+		}))
+		got, err := namenodeState(context.Background(), http.DefaultClient, server.URL)
+		server.Close()
+		if err != nil || got != state {
+			t.Fatalf("expected state %s, got %q, %v", state, got, err)
+		}
+	}
+}
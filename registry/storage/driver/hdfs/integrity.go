@@ -0,0 +1,225 @@
+package hdfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// IntegrityVerifier is implemented by drivers that can check a file against
+// the checksums its storage keeps, such as the HDFS driver. The sweep of
+// garbage collection can check the blobs it keeps, so that corruption is
+// found before the blob is served:
+//
+//	if verifier, ok := driver.(hdfs.IntegrityVerifier); ok {
+//		if err := verifier.VerifyIntegrity(ctx, blobPath); err != nil {
+//			if _, corrupt := err.(hdfs.CorruptionError); corrupt {
+//				// report or quarantine the blob
+//			}
+//		}
+//	}
+type IntegrityVerifier interface {
+	// VerifyIntegrity reads the file at path and compares it with the
+	// checksum the datanodes report for it, returning a CorruptionError
+	// when they differ. Other errors mean the file could not be checked.
+	VerifyIntegrity(ctx context.Context, path string) error
+}
+
+// CorruptionError is returned by VerifyIntegrity when the contents of a file
+// do not match its HDFS checksum
+type CorruptionError struct {
+	Path     string
+	Checksum []byte
+	Computed []byte
+}
+
+func (e CorruptionError) Error() string {
+	return fmt.Sprintf("hdfs: %s is corrupt, its checksum is %x but its contents give %x", e.Path, e.Checksum, e.Computed)
+}
+
+// The checksum HDFS reports for a file is an MD5 of the MD5s of the CRCs of
+// every chunk of each block, MD5-of-MD5-of-CRC32C for the chunks of 512
+// bytes colinmarc/hdfs always writes whatever dfs.checksum.type and
+// dfs.bytes-per-checksum say. Files written by other clients with other
+// settings cannot be checked.
+const integrityBytesPerCRC = 512
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// fileChecksum is the HDFS checksum of a file and the block size it was
+// computed with
+type fileChecksum struct {
+	md5       []byte
+	blockSize int64
+}
+
+// fileChecksummer is implemented by clients that can get the checksum of a
+// file from the datanodes holding its blocks
+type fileChecksummer interface {
+	FileChecksum(name string) (*fileChecksum, error)
+}
+
+// getFileChecksum returns the checksum of name if c can get it
+func getFileChecksum(c hdfsClient, name string) (*fileChecksum, error) {
+	if s, ok := c.(fileChecksummer); ok {
+		return s.FileChecksum(name)
+	}
+	return nil, errUnsupportedByClient
+}
+
+// FileChecksum implements fileChecksummer. The block size is read from the
+// HdfsFileStatusProto returned by Sys, like EncryptionInfo.
+func (c colinmarcClient) FileChecksum(name string) (*fileChecksum, error) {
+	reader, err := c.Client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	status := reflect.ValueOf(reader.Stat().Sys())
+	if !status.IsValid() || !status.MethodByName("GetBlocksize").IsValid() {
+		return nil, errUnsupportedByClient
+	}
+	checksum, err := reader.Checksum()
+	if err != nil {
+		return nil, err
+	}
+	blockSize := status.MethodByName("GetBlocksize").Call(nil)[0].Uint()
+	return &fileChecksum{md5: checksum, blockSize: int64(blockSize)}, nil
+}
+
+// checksumWriter computes the checksum of what is written to it the way the
+// datanodes and colinmarc/hdfs do for a file
+type checksumWriter struct {
+	blockSize  int64
+	chunk      []byte
+	inBlock    int64
+	block      hash.Hash
+	blocks     []byte
+	blockCount int
+}
+
+func newChecksumWriter(blockSize int64) *checksumWriter {
+	return &checksumWriter{blockSize: blockSize, chunk: make([]byte, 0, integrityBytesPerCRC), block: md5.New()}
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := integrityBytesPerCRC - len(w.chunk)
+		if remaining := w.blockSize - w.inBlock; int64(take) > remaining {
+			take = int(remaining)
+		}
+		if take > len(p) {
+			take = len(p)
+		}
+		w.chunk = append(w.chunk, p[:take]...)
+		w.inBlock += int64(take)
+		p = p[take:]
+
+		if len(w.chunk) == integrityBytesPerCRC || w.inBlock == w.blockSize {
+			w.endChunk()
+		}
+		if w.inBlock == w.blockSize {
+			w.endBlock()
+		}
+	}
+	return n, nil
+}
+
+func (w *checksumWriter) endChunk() {
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.Checksum(w.chunk, crc32c))
+	w.block.Write(crc[:])
+	w.chunk = w.chunk[:0]
+}
+
+func (w *checksumWriter) endBlock() {
+	w.blocks = w.block.Sum(w.blocks)
+	w.blockCount++
+	w.block.Reset()
+	w.inBlock = 0
+}
+
+// Sum returns the checksum of the file. Like Hadoop, colinmarc/hdfs pads the
+// block checksums with zeros to the next power of two of at least 32 bytes
+// before taking their MD5.
+func (w *checksumWriter) Sum() []byte {
+	if len(w.chunk) > 0 {
+		w.endChunk()
+	}
+	if w.inBlock > 0 {
+		w.endBlock()
+	}
+	padded := 32
+	for padded < len(w.blocks) {
+		padded *= 2
+	}
+	sum := md5.New()
+	sum.Write(w.blocks)
+	sum.Write(make([]byte, padded-len(w.blocks)))
+	return sum.Sum(nil)
+}
+
+// VerifyIntegrity implements IntegrityVerifier
+func (d *Driver) VerifyIntegrity(ctx context.Context, path string) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.VerifyIntegrity(%q)", d.Name(), path)
+
+	path, err := d.inner().checkPath(path, false)
+	if err != nil {
+		return err
+	}
+	return d.inner().verifyIntegrity(ctx, path)
+}
+
+// verifyIntegrity checks the bytes stored at path, which are compressed or
+// encrypted as the datanodes hold them
+func (d *driver) verifyIntegrity(ctx context.Context, subPath string) error {
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	fullPath, err := d.readPath(ctx, subPath)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := getFileChecksum(d.hdfsClient, fullPath)
+	if os.IsNotExist(err) {
+		return storagedriver.PathNotFoundError{Path: subPath}
+	} else if err != nil {
+		return err
+	}
+	if checksum.blockSize <= 0 {
+		return fmt.Errorf("hdfs: the block size of %s is unknown", subPath)
+	}
+
+	reader, err := d.hdfsClient.Open(fullPath)
+	if os.IsNotExist(err) {
+		return storagedriver.PathNotFoundError{Path: subPath}
+	} else if err != nil {
+		return dataUnavailable(subPath, err)
+	}
+	defer reader.Close()
+	if reader.Stat().IsDir() {
+		return fmt.Errorf("hdfs: cannot verify %s: it is a directory", subPath)
+	}
+
+	computed := newChecksumWriter(checksum.blockSize)
+	if _, err := io.Copy(computed, reader); err != nil {
+		return dataUnavailable(subPath, err)
+	}
+	if sum := computed.Sum(); !bytes.Equal(sum, checksum.md5) {
+		return CorruptionError{Path: subPath, Checksum: checksum.md5, Computed: sum}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package hdfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// checksumClient reports the checksums set for its files, as the datanodes
+// would
+type checksumClient struct {
+	*fakeClient
+	checksums map[string][]byte
+	blockSize int64
+}
+
+func (c checksumClient) FileChecksum(name string) (*fileChecksum, error) {
+	if _, err := c.Stat(name); err != nil {
+		return nil, err
+	}
+	return &fileChecksum{md5: c.checksums[name], blockSize: c.blockSize}, nil
+}
+
+// referenceChecksum computes the MD5-of-MD5-of-CRC32C of data a block at a
+// time
+func referenceChecksum(data []byte, blockSize int) []byte {
+	var blocks []byte
+	for start := 0; start < len(data); start += blockSize {
+		block := data[start:]
+		if len(block) > blockSize {
+			block = block[:blockSize]
+		}
+		var crcs []byte
+		for chunk := 0; chunk < len(block); chunk += 512 {
+			end := chunk + 512
+			if end > len(block) {
+				end = len(block)
+			}
+			crc := make([]byte, 4)
+			binary.BigEndian.PutUint32(crc, crc32.Checksum(block[chunk:end], crc32.MakeTable(crc32.Castagnoli)))
+			crcs = append(crcs, crc...)
+		}
+		sum := md5.Sum(crcs)
+		blocks = append(blocks, sum[:]...)
+	}
+	padded := 32
+	for padded < len(blocks) {
+		padded *= 2
+	}
+	sum := md5.Sum(append(blocks, make([]byte, padded-len(blocks))...))
+	return sum[:]
+}
+
+func TestVerifyIntegrity(t *testing.T) {
+	client := newFakeClient()
+	data := bytes.Repeat([]byte("layer data "), 300)
+	client.writeFile("/registry/blobs/good/data", data)
+	client.writeFile("/registry/blobs/bad/data", data)
+
+	corrupt := referenceChecksum(data, 1024)
+	corrupt[0] ^= 0xff
+	checksums := checksumClient{client, map[string][]byte{
+		"/registry/blobs/good/data": referenceChecksum(data, 1024),
+		"/registry/blobs/bad/data":  corrupt,
+	}, 1024}
+
+	var sd storagedriver.StorageDriver = wrap(newTestDriver(checksums))
+	verifier, ok := sd.(IntegrityVerifier)
+	if !ok {
+		t.Fatal("expected the driver to implement IntegrityVerifier")
+	}
+	ctx := context.Background()
+
+	if err := verifier.VerifyIntegrity(ctx, "/blobs/good/data"); err != nil {
+		t.Fatalf("expected a matching checksum, got %v", err)
+	}
+	err := verifier.VerifyIntegrity(ctx, "/blobs/bad/data")
+	if corruption, ok := err.(CorruptionError); !ok || corruption.Path != "/blobs/bad/data" || !bytes.Equal(corruption.Checksum, corrupt) {
+		t.Fatalf("expected a CorruptionError for the mismatch, got %v", err)
+	}
+	if err := verifier.VerifyIntegrity(ctx, "/blobs/missing/data"); !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+
+	// Without checksums from the client nothing is reported corrupt
+	err = wrap(newTestDriver(basicClient{client})).VerifyIntegrity(ctx, "/blobs/good/data")
+	if _, ok := err.(CorruptionError); err == nil || ok {
+		t.Fatalf("expected the check to fail without being corruption, got %v", err)
+	}
+}
+
+func TestChecksumWriterMatchesReference(t *testing.T) {
+	for _, size := range []int{0, 1, 511, 512, 1024, 1025, 5000} {
+		data := bytes.Repeat([]byte{0x5a}, size)
+		w := newChecksumWriter(1024)
+		// Odd write sizes cross chunk and block boundaries
+		for rest := data; len(rest) > 0; {
+			n := 333
+			if n > len(rest) {
+				n = len(rest)
+			}
+			w.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if sum := w.Sum(); !bytes.Equal(sum, referenceChecksum(data, 1024)) {
+			t.Errorf("%d bytes: expected %x, got %x", size, referenceChecksum(data, 1024), sum)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package hdfs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// kmsClient talks to the REST API of a Hadoop KMS, which holds the zone keys
+// of HDFS encryption zones. The driver only needs it to decrypt the data
+// encryption keys the namenode hands out with every encrypted file.
+type kmsClient struct {
+	address string
+	user    string
+	client  *http.Client
+}
+
+// kmsAddress turns a key provider URI as found in
+// hadoop.security.key.provider.path, such as kms://http@kms.example.com:9600/kms,
+// into the HTTP address of the KMS. Plain http and https URLs are accepted
+// as well. Only the first host of a load balanced provider is used.
+func kmsAddress(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid KMS URI %q: %v", uri, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+	case "kms":
+		if u.User == nil || (u.User.Username() != "http" && u.User.Username() != "https") {
+			return "", fmt.Errorf("invalid KMS URI %q: expected kms://http@host:port/kms or kms://https@host:port/kms", uri)
+		}
+		u.Scheme = u.User.Username()
+		u.User = nil
+		// Load balanced providers list their hosts before a shared port
+		if hosts := strings.Split(u.Host, ";"); len(hosts) > 1 {
+			port := u.Port()
+			u.Host = hosts[0]
+			if port != "" {
+				u.Host = net.JoinHostPort(hosts[0], port)
+			}
+		}
+	default:
+		return "", fmt.Errorf("invalid KMS URI %q: unsupported scheme %q", uri, u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid KMS URI %q: no host", uri)
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
+
+func newKmsClient(uri, user string) (*kmsClient, error) {
+	address, err := kmsAddress(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &kmsClient{
+		address: address,
+		user:    user,
+		client:  http.DefaultClient,
+	}, nil
+}
+
+// kmsKeyMaterial is the JSON representation of key material in the KMS API
+type kmsKeyMaterial struct {
+	Name        string `json:"name,omitempty"`
+	VersionName string `json:"versionName,omitempty"`
+	IV          string `json:"iv,omitempty"`
+	Material    string `json:"material"`
+}
+
+// decryptDataKey asks the KMS to decrypt the data encryption key of a file
+// with the zone key version it was encrypted with.
+func (k *kmsClient) decryptDataKey(info *fileEncryptionInfo) ([]byte, error) {
+	body, err := json.Marshal(kmsKeyMaterial{
+		Name:     info.keyName,
+		IV:       encodeKmsBase64(info.iv),
+		Material: encodeKmsBase64(info.edek),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("eek_op", "decrypt")
+	query.Set("user.name", k.user)
+	endpoint := k.address + "/v1/keyversion/" + url.QueryEscape(info.ezKeyVersionName) + "/_eek?" + query.Encode()
+
+	resp, err := k.client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var remote struct {
+			Exception string `json:"RemoteException"`
+			Message   string `json:"message"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&remote); err == nil && remote.Message != "" {
+			return nil, fmt.Errorf("kms: decrypting the key of %s: %s", info.ezKeyVersionName, remote.Message)
+		}
+		return nil, fmt.Errorf("kms: decrypting the key of %s: unexpected status %s", info.ezKeyVersionName, resp.Status)
+	}
+
+	var decrypted kmsKeyMaterial
+	if err := json.NewDecoder(resp.Body).Decode(&decrypted); err != nil {
+		return nil, fmt.Errorf("kms: decoding the decrypted key: %v", err)
+	}
+	return decodeKmsBase64(decrypted.Material)
+}
+
+// The KMS encodes binary values as unpadded URL-safe base64
+func encodeKmsBase64(b []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
+}
+
+// decodeKmsBase64 accepts both base64 alphabets, with or without padding
+func decodeKmsBase64(s string) ([]byte, error) {
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(strings.TrimRight(s, "="))
+	if pad := len(s) % 4; pad != 0 {
+		s += strings.Repeat("=", 4-pad)
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
@@ -0,0 +1,70 @@
+package hdfs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultLeaseRecoveryTimeout outlasts the namenode's soft lease limit
+	// of a minute, after which another client may take over the lease
+	defaultLeaseRecoveryTimeout = time.Minute
+
+	// defaultLeaseRecoveryInterval is the pause between append attempts
+	// while a lease recovers
+	defaultLeaseRecoveryInterval = time.Second
+)
+
+// isLeaseHeld reports whether the namenode refused to open a file for
+// writing because another client, typically one that crashed, still holds
+// its lease or the lease is being recovered
+func isLeaseHeld(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "AlreadyBeingCreatedException") ||
+		strings.Contains(message, "RecoveryInProgressException")
+}
+
+// leaseRecoverer is implemented by clients that can issue the namenode's
+// recoverLease RPC, which revokes the lease on a file and closes it. It
+// reports whether the file is closed already; otherwise its last block is
+// still being recovered. colinmarc/hdfs cannot, so appends wait for the
+// soft lease limit to expire instead.
+type leaseRecoverer interface {
+	RecoverLease(name string) (bool, error)
+}
+
+// recoverLease recovers the lease on name if c supports it
+func recoverLease(c hdfsClient, name string) (bool, error) {
+	if r, ok := c.(leaseRecoverer); ok {
+		return r.RecoverLease(name)
+	}
+	return false, errUnsupportedByClient
+}
+
+// appendRecoveringLease appends to fullPath. When a previous writer still
+// holds the lease, which keeps resumable uploads from continuing after a
+// registry crashed mid-write, the lease is recovered where the client can
+// and the append retried until leaserecoverytimeout, or the retrybudget of
+// the operation, passes.
+func (d *driver) appendRecoveringLease(fullPath string) (hdfsFileWriter, error) {
+	writer, err := d.hdfsClient.Append(fullPath)
+	if err == nil || d.leaseRecoveryTimeout <= 0 || !isLeaseHeld(err) {
+		return writer, err
+	}
+
+	start := time.Now()
+	deadline := d.budget.limit(start.Add(d.leaseRecoveryTimeout))
+	for err != nil && isLeaseHeld(err) && time.Now().Before(deadline) {
+		if recovered, rerr := recoverLease(d.hdfsClient, fullPath); rerr != nil || !recovered {
+			if !d.budget.sleep(d.leaseRecoveryInterval) {
+				break
+			}
+		}
+		writer, err = d.hdfsClient.Append(fullPath)
+	}
+	if err != nil && isLeaseHeld(err) {
+		return nil, fmt.Errorf("the lease on %s was not recovered within %v: %v", fullPath, deadline.Sub(start), err)
+	}
+	return writer, err
+}
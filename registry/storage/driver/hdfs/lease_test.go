@@ -0,0 +1,98 @@
+package hdfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+var errLeaseHeld = errors.New("org.apache.hadoop.hdfs.protocol.AlreadyBeingCreatedException: Failed to APPEND_FILE /registry/upload/data for DFSClient_NONMAPREDUCE_1 because this file lease is currently owned by DFSClient_NONMAPREDUCE_2")
+
+// recoveringClient can recover leases, which succeeds on the first call
+type recoveringClient struct {
+	*fakeClient
+	recovered []string
+}
+
+func (c *recoveringClient) RecoverLease(name string) (bool, error) {
+	c.recovered = append(c.recovered, name)
+	c.hook("Append", nil)
+	return true, nil
+}
+
+// holdLease makes the first n appends fail as if another writer held the lease
+func holdLease(client *fakeClient, n int) {
+	client.hook("Append", func(string) error {
+		if n > 0 {
+			n--
+			return errLeaseHeld
+		}
+		return nil
+	})
+}
+
+func newLeaseTestDriver(client hdfsClient, timeout time.Duration) *driver {
+	d := newTestDriverWithParameters(client, DriverParameters{LeaseTimeout: timeout})
+	d.leaseRecoveryInterval = time.Millisecond
+	return d
+}
+
+func TestAppendWaitsForLease(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/upload/data", []byte("abc"))
+	holdLease(client, 2)
+	d := newLeaseTestDriver(client, time.Minute)
+
+	w, err := d.Writer(context.Background(), "/upload/data", true)
+	if err != nil {
+		t.Fatalf("expected the append to succeed once the lease recovered: %v", err)
+	}
+	if w.Size() != 3 {
+		t.Fatalf("expected the writer to continue at 3, got %d", w.Size())
+	}
+	w.Close()
+	if calls := client.callCount("Append"); calls != 3 {
+		t.Fatalf("expected 3 appends, got %d", calls)
+	}
+}
+
+func TestAppendRecoversLease(t *testing.T) {
+	fake := newFakeClient()
+	fake.writeFile("/registry/upload/data", []byte("abc"))
+	fake.failWith("Append", errLeaseHeld)
+	client := &recoveringClient{fakeClient: fake}
+	d := newLeaseTestDriver(client, time.Minute)
+
+	w, err := d.Writer(context.Background(), "/upload/data", true)
+	if err != nil {
+		t.Fatalf("expected the append to succeed after recovering the lease: %v", err)
+	}
+	w.Close()
+	if len(client.recovered) != 1 || client.recovered[0] != "/registry/upload/data" {
+		t.Fatalf("expected the lease on the upload to be recovered, got %v", client.recovered)
+	}
+}
+
+func TestAppendLeaseRecoveryTimeout(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/upload/data", []byte("abc"))
+	client.failWith("Append", errLeaseHeld)
+
+	d := newLeaseTestDriver(client, 20*time.Millisecond)
+	if _, err := d.Writer(context.Background(), "/upload/data", true); err == nil || !strings.Contains(err.Error(), "not recovered") {
+		t.Fatalf("expected the append to give up, got %v", err)
+	}
+
+	// Without a timeout the error is returned at once
+	before := client.callCount("Append")
+	d = newLeaseTestDriver(client, 0)
+	if _, err := d.Writer(context.Background(), "/upload/data", true); err != errLeaseHeld {
+		t.Fatalf("expected the lease error, got %v", err)
+	}
+	if calls := client.callCount("Append") - before; calls != 1 {
+		t.Fatalf("expected a single append, got %d", calls)
+	}
+}
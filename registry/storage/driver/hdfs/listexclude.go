@@ -0,0 +1,45 @@
+package hdfs
+
+import (
+	"os"
+	"strings"
+)
+
+// isTemporaryFile reports whether List leaves name out: the driver's own
+// staging files, and whatever the listexclude parameter names, such as the
+// _temporary directories of Hadoop jobs writing next to the registry.
+// ClientOptions.ListTemporaryFiles shows them all.
+func (d *driver) isTemporaryFile(name string) bool {
+	if d.listTemporaryFiles {
+		return false
+	}
+	if isStagingFile(name) {
+		return true
+	}
+	for _, prefix := range d.listExclude {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSelfReference reports whether a directory entry named name is the
+// directory itself or its parent rather than a child. The namenode never
+// lists such entries, but gateways and other client implementations may,
+// and a directory listing itself would send walks into a loop.
+func isSelfReference(name string) bool {
+	return name == "" || name == "." || name == ".." || strings.Contains(name, "/")
+}
+
+// readDir reads the children of dirname, leaving out self references
+func (d *driver) readDir(dirname string) ([]os.FileInfo, error) {
+	fileInfos, err := d.hdfsClient.ReadDir(dirname)
+	children := fileInfos[:0]
+	for _, fileInfo := range fileInfos {
+		if !isSelfReference(fileInfo.Name()) {
+			children = append(children, fileInfo)
+		}
+	}
+	return children, err
+}
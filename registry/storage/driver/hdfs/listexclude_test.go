@@ -0,0 +1,90 @@
+package hdfs
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestListExcludesTemporaryFiles(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/data", []byte("layer"))
+	client.writeFile("/registry/blobs/.staging-data-host-1234", []byte("lay"))
+	client.writeFile("/registry/blobs/_temporary/0/part", []byte("job"))
+	client.writeFile("/registry/blobs/other", []byte("other"))
+	d := newTestDriverWithParameters(client, DriverParameters{ListExclude: "_temporary"})
+
+	entries, err := d.List(context.Background(), "/blobs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"/blobs/data", "/blobs/other"}; !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected temporary files to be excluded, got %v", entries)
+	}
+
+	// Maintenance can ask for them
+	ctx := WithClientOptions(context.Background(), ClientOptions{ListTemporaryFiles: true})
+	entries, err = d.List(ctx, "/blobs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"/blobs/.staging-data-host-1234", "/blobs/_temporary", "/blobs/data", "/blobs/other"}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected temporary files to be listed on request, got %v", entries)
+	}
+}
+
+// dotEntriesClient lists every directory with dot entries and itself, like
+// some gateways do
+type dotEntriesClient struct {
+	hdfsClient
+}
+
+func (c dotEntriesClient) ReadDir(dirname string) ([]os.FileInfo, error) {
+	fileInfos, err := c.hdfsClient.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	dots := []os.FileInfo{
+		fakeFileInfo{name: ".", isDir: true},
+		fakeFileInfo{name: "..", isDir: true},
+		fakeFileInfo{name: dirname, isDir: true},
+		fakeFileInfo{name: "", isDir: true},
+	}
+	return append(dots, fileInfos...), nil
+}
+
+func TestListExcludesSelfReferences(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/data", []byte("layer"))
+	client.writeFile("/registry/blobs/dir/nested", []byte("nested"))
+	d := newTestDriver(dotEntriesClient{client})
+
+	entries, err := d.List(context.Background(), "/blobs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"/blobs/data", "/blobs/dir"}; !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected dot entries to be excluded, got %v", entries)
+	}
+
+	// Walks do not loop through them either
+	var walked []string
+	err = wrap(d).Walk(context.Background(), "/blobs", func(fi storagedriver.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path.Base(fi.Path()))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"data", "dir", "nested"}; !reflect.DeepEqual(walked, expected) {
+		t.Fatalf("expected the walk to skip dot entries, got %v", walked)
+	}
+}
@@ -0,0 +1,64 @@
+package hdfs
+
+import (
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// InfoLister is implemented by drivers that can list a directory together
+// with the FileInfo of every entry, such as the HDFS driver. Callers that
+// would otherwise Stat each entry returned by List, like garbage collection
+// looking at ages, save a namenode call per entry.
+type InfoLister interface {
+	// ListInfo returns what Stat would return for each path List returns,
+	// in the same order
+	ListInfo(ctx context.Context, path string) ([]storagedriver.FileInfo, error)
+}
+
+// ListInfo implements InfoLister
+func (d *Driver) ListInfo(ctx context.Context, path string) ([]storagedriver.FileInfo, error) {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.ListInfo(%q)", d.Name(), path)
+
+	path, err := d.inner().checkPath(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return d.inner().listInfo(ctx, path)
+}
+
+func (d *driver) listInfo(ctx context.Context, subPath string) ([]storagedriver.FileInfo, error) {
+	if err := d.checkClient(); err != nil {
+		return nil, err
+	}
+	entries, err := d.listEntries(ctx, subPath)
+	if err != nil {
+		return nil, err
+	}
+	sortEntries(entries, d.listSort)
+	return d.entryInfos(entries)
+}
+
+// entryInfos returns what Stat would return for each of entries
+func (d *driver) entryInfos(entries []listEntry) ([]storagedriver.FileInfo, error) {
+	infos := make([]storagedriver.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		size := entry.info.Size()
+		if !entry.info.IsDir() {
+			header, err := d.compressionOf(entry.fullPath)
+			if err != nil {
+				return nil, err
+			}
+			if header != nil {
+				size = header.logicalSize
+			}
+		}
+		infos = append(infos, newFileInfo(storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+			Path:    entry.path,
+			Size:    size,
+			ModTime: entry.info.ModTime(),
+			IsDir:   entry.info.IsDir(),
+		}}, entry.info))
+	}
+	return infos, nil
+}
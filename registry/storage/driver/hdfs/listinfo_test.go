@@ -0,0 +1,94 @@
+package hdfs
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestListInfoMatchesStat(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/repo/small", []byte("a"))
+	client.writeFile("/registry/repo/large", make([]byte, 4096))
+	client.writeFile("/registry/repo/dir/child", []byte("child"))
+	var sd storagedriver.StorageDriver = wrap(newTestDriver(client))
+	ctx := context.Background()
+
+	lister, ok := sd.(InfoLister)
+	if !ok {
+		t.Fatalf("expected the driver to implement InfoLister")
+	}
+	infos, err := lister.ListInfo(ctx, "/repo")
+	if err != nil {
+		t.Fatalf("unexpected error from ListInfo: %v", err)
+	}
+	paths, err := sd.List(ctx, "/repo")
+	if err != nil {
+		t.Fatalf("unexpected error from List: %v", err)
+	}
+	if len(infos) != len(paths) {
+		t.Fatalf("expected %d infos, got %d", len(paths), len(infos))
+	}
+
+	stats := client.callCount("Stat")
+	for i, info := range infos {
+		if info.Path() != paths[i] {
+			t.Fatalf("expected info %d to be for %s, got %s", i, paths[i], info.Path())
+		}
+		fi, err := sd.Stat(ctx, paths[i])
+		if err != nil {
+			t.Fatalf("unexpected error from Stat: %v", err)
+		}
+		if info.Size() != fi.Size() || !info.ModTime().Equal(fi.ModTime()) || info.IsDir() != fi.IsDir() || path.Base(info.Path()) != path.Base(fi.Path()) {
+			t.Fatalf("ListInfo %+v does not match Stat %+v", info, fi)
+		}
+		if _, ok := info.(ExtendedFileInfo); !ok {
+			t.Fatalf("expected ListInfo to return ExtendedFileInfo")
+		}
+	}
+	if client.callCount("Stat")-stats != len(paths) {
+		t.Fatalf("expected only the Stats of the comparison")
+	}
+
+	if _, err := lister.ListInfo(ctx, "invalid"); err == nil {
+		t.Fatalf("expected an invalid path to be rejected")
+	}
+}
+
+func BenchmarkListInfo(b *testing.B) {
+	benchmarkList(b, func(ctx context.Context, sd *Driver) error {
+		_, err := sd.ListInfo(ctx, "/repo")
+		return err
+	})
+}
+
+func BenchmarkListAndStat(b *testing.B) {
+	benchmarkList(b, func(ctx context.Context, sd *Driver) error {
+		paths, err := sd.List(ctx, "/repo")
+		for _, p := range paths {
+			if _, err := sd.Stat(ctx, p); err != nil {
+				return err
+			}
+		}
+		return err
+	})
+}
+
+func benchmarkList(b *testing.B, list func(context.Context, *Driver) error) {
+	client := newFakeClient()
+	for i := 0; i < 1000; i++ {
+		client.writeFile(fmt.Sprintf("/registry/repo/%04d", i), []byte("blob"))
+	}
+	sd := wrap(newTestDriver(client))
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := list(ctx, sd); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package hdfs
+
+import (
+	"os"
+	"time"
+)
+
+// defaultListRetryDelay is the pause between List attempts with listretries
+const defaultListRetryDelay = 100 * time.Millisecond
+
+// readDirWithRetry reads dirname, reading it again up to listretries times
+// while it comes back empty. HDFS itself is strongly consistent, but setups
+// serving metadata from observer or federated namenodes can briefly return
+// a listing that lacks entries just written, which garbage collection would
+// take for missing blobs. Only existing directories are retried, so empty
+// and missing ones cost nothing extra while listretries is 0. The rereads
+// stop early once the retrybudget of the operation is spent.
+func (d *driver) readDirWithRetry(dirname string, isDir bool) ([]os.FileInfo, error) {
+	fileInfos, err := d.readDir(dirname)
+	for attempt := 0; attempt < d.listRetries && isDir && err == nil && len(fileInfos) == 0; attempt++ {
+		if !d.budget.sleep(d.listRetryDelay) {
+			break
+		}
+		fileInfos, err = d.readDir(dirname)
+	}
+	return fileInfos, err
+}
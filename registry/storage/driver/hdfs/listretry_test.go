@@ -0,0 +1,59 @@
+package hdfs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// laggingListClient returns empty listings for the first reads, like a
+// namenode whose metadata has not caught up yet
+type laggingListClient struct {
+	hdfsClient
+	empty int
+}
+
+func (c *laggingListClient) ReadDir(dirname string) ([]os.FileInfo, error) {
+	if c.empty > 0 {
+		c.empty--
+		return nil, nil
+	}
+	return c.hdfsClient.ReadDir(dirname)
+}
+
+func TestListRetriesEmptyListing(t *testing.T) {
+	fake := newFakeClient()
+	fake.writeFile("/registry/blobs/sha256/aa/data", []byte("layer"))
+	client := &laggingListClient{hdfsClient: fake, empty: 1}
+	d := newTestDriverWithParameters(client, DriverParameters{ListRetries: 2, ListRetryDelay: time.Millisecond})
+
+	entries, err := d.List(context.Background(), "/blobs/sha256/aa")
+	if err != nil || !reflect.DeepEqual(entries, []string{"/blobs/sha256/aa/data"}) {
+		t.Fatalf("expected the retry to pick up the entry, got %v, %v", entries, err)
+	}
+
+	// Directories that really are empty give up after listretries
+	if err := fake.MkdirAll("/registry/empty", 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before := fake.callCount("ReadDir")
+	if entries, err := d.List(context.Background(), "/empty"); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty listing, got %v, %v", entries, err)
+	}
+	if reads := fake.callCount("ReadDir") - before; reads != 3 {
+		t.Fatalf("expected 3 reads of the empty directory, got %d", reads)
+	}
+}
+
+func TestListRetriesDisabled(t *testing.T) {
+	fake := newFakeClient()
+	fake.writeFile("/registry/blobs/sha256/aa/data", []byte("layer"))
+	d := newTestDriver(&laggingListClient{hdfsClient: fake, empty: 1})
+
+	if entries, err := d.List(context.Background(), "/blobs/sha256/aa"); err != nil || len(entries) != 0 {
+		t.Fatalf("expected the lagging listing without listretries, got %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,71 @@
+package hdfs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Orders accepted by the listsort parameter
+const (
+	listSortName    = "name"
+	listSortModTime = "modtime"
+	listSortNone    = "none"
+)
+
+var listSortOrders = []string{listSortName, listSortModTime, listSortNone}
+
+func validateListSort(order string) error {
+	for _, valid := range listSortOrders {
+		if order == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("The listsort parameter must be one of %v, %q invalid", listSortOrders, order)
+}
+
+// listEntry is a path returned from List with the HDFS file it stands for
+type listEntry struct {
+	path     string
+	fullPath string
+	info     os.FileInfo
+}
+
+type byName []listEntry
+
+func (e byName) Len() int           { return len(e) }
+func (e byName) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byName) Less(i, j int) bool { return e[i].path < e[j].path }
+
+type byModTime struct{ byName }
+
+func (e byModTime) Less(i, j int) bool {
+	if a, b := e.byName[i].info.ModTime(), e.byName[j].info.ModTime(); !a.Equal(b) {
+		return a.Before(b)
+	}
+	return e.byName.Less(i, j)
+}
+
+// sortListEntries orders entries as configured by listsort and returns
+// their paths. Ties in modification time are broken by name so the result
+// is deterministic either way.
+func sortListEntries(entries []listEntry, order string) []string {
+	sortEntries(entries, order)
+
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		paths[i] = entry.path
+	}
+	return paths
+}
+
+// sortEntries orders entries in place as configured by listsort
+func sortEntries(entries []listEntry, order string) {
+	switch order {
+	case listSortModTime:
+		sort.Sort(byModTime{byName(entries)})
+	case listSortNone:
+	default:
+		sort.Sort(byName(entries))
+	}
+}
@@ -0,0 +1,64 @@
+package hdfs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// reversingClient returns directory entries in reverse name order, as a
+// namenode is free to
+type reversingClient struct {
+	*fakeClient
+}
+
+func (c reversingClient) ReadDir(dirname string) ([]os.FileInfo, error) {
+	infos, err := c.fakeClient.ReadDir(dirname)
+	for i, j := 0, len(infos)-1; i < j; i, j = i+1, j-1 {
+		infos[i], infos[j] = infos[j], infos[i]
+	}
+	return infos, err
+}
+
+func TestListSortedByName(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(reversingClient{client})
+	for _, name := range []string{"b", "c", "a"} {
+		client.writeFile("/registry/dir/"+name, []byte(name))
+	}
+
+	names, err := d.List(context.Background(), "/dir")
+	if err != nil {
+		t.Fatalf("unexpected error from List: %v", err)
+	}
+	if expected := []string{"/dir/a", "/dir/b", "/dir/c"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestListSortedByModTime(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(reversingClient{client}, DriverParameters{ListSort: listSortModTime})
+	now := time.Now()
+	for i, name := range []string{"a", "b", "c"} {
+		client.writeFile("/registry/dir/"+name, []byte(name))
+		client.Chtimes("/registry/dir/"+name, now, now.Add(-time.Duration(i)*time.Hour))
+	}
+
+	names, err := d.List(context.Background(), "/dir")
+	if err != nil {
+		t.Fatalf("unexpected error from List: %v", err)
+	}
+	if expected := []string{"/dir/c", "/dir/b", "/dir/a"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestListSortParameter(t *testing.T) {
+	if _, err := FromParameters(map[string]interface{}{"listsort": "size"}); err == nil {
+		t.Fatalf("expected an unknown listsort to be rejected")
+	}
+}
@@ -0,0 +1,162 @@
+package hdfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// listStreamBatch is how many entries ListStream asks the namenode for at
+// a time, the namenode's default dfs.ls.limit
+const listStreamBatch = 1000
+
+// ListStreamer is implemented by drivers that can list a directory without
+// holding all of it in memory, such as the HDFS driver, for directories too
+// large to list into a slice, like the blobs of a big registry.
+type ListStreamer interface {
+	// ListStream sends the paths List would return on the first channel
+	// as they are read, then closes both channels. An error ends the
+	// stream early and is sent on the second channel first.
+	ListStream(ctx context.Context, path string) (<-chan string, <-chan error)
+}
+
+// ListStream implements ListStreamer. Paths arrive in the order of the
+// namenode rather than listsort, directories that cannot be read are
+// errors rather than empty, and listretries does not apply. Cancelling ctx
+// stops the stream with ctx.Err().
+func (d *Driver) ListStream(ctx context.Context, path string) (<-chan string, <-chan error) {
+	ctx, done := context.WithTrace(ctx)
+
+	normalized, err := d.inner().checkPath(path, true)
+	if err != nil {
+		done("%s.ListStream(%q)", d.Name(), path)
+		paths, errs := make(chan string), make(chan error, 1)
+		errs <- err
+		close(paths)
+		close(errs)
+		return paths, errs
+	}
+	path = normalized
+
+	paths, errs := make(chan string), make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(paths)
+		defer done("%s.ListStream(%q)", d.Name(), path)
+		if err := d.inner().listStream(ctx, path, paths); err != nil {
+			errs <- err
+		}
+	}()
+	return paths, errs
+}
+
+func (d *driver) listStream(ctx context.Context, subPath string, paths chan<- string) (err error) {
+	defer d.slowOps.log(ctx, "ListStream", subPath, time.Now())
+	defer d.readLog.log(ctx, "ListStream", subPath, time.Now(), &err)
+	defer transfers.failed("ListStream", &err)
+	defer d.recoverPanic(ctx, "ListStream", &err)
+
+	if err := d.faults.inject("List"); err != nil {
+		return err
+	}
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	d = d.withOptions(ctx)
+	send := func(entries []listEntry) error {
+		for _, entry := range entries {
+			// A receiver that is ready does not keep a cancelled stream going
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			select {
+			case paths <- entry.path:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+
+	// Sessions merge their metadata from elsewhere, and are small
+	if d.uploadStateDirectory != "" && isUploadSession(subPath) {
+		entries, err := d.listEntries(ctx, subPath)
+		if err != nil {
+			return err
+		}
+		return send(entries)
+	}
+
+	fullPath, err := d.readPath(ctx, subPath)
+	if err != nil {
+		return err
+	}
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return storagedriver.PathNotFoundError{Path: subPath}
+	} else if err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("cannot list %s: not a directory", subPath)
+	}
+
+	return readDirBatches(d.hdfsClient, fullPath, listStreamBatch, func(batch []os.FileInfo) error {
+		var entries []listEntry
+		for _, fileInfo := range batch {
+			if isSelfReference(fileInfo.Name()) {
+				continue
+			}
+			if entries, err = d.appendEntries(entries, subPath, fullPath, fileInfo); err != nil {
+				return err
+			}
+		}
+		return send(entries)
+	})
+}
+
+// dirBatchReader is implemented by clients that can read a directory a
+// batch of at most n entries at a time, calling f with each batch until it
+// returns an error.
+type dirBatchReader interface {
+	ReadDirBatches(dirname string, n int, f func([]os.FileInfo) error) error
+}
+
+// readDirBatches reads dirname in batches if c can, or in one batch
+func readDirBatches(c hdfsClient, dirname string, n int, f func([]os.FileInfo) error) error {
+	if r, ok := c.(dirBatchReader); ok {
+		return r.ReadDirBatches(dirname, n, f)
+	}
+	fileInfos, err := c.ReadDir(dirname)
+	if err != nil {
+		return err
+	}
+	return f(fileInfos)
+}
+
+// ReadDirBatches implements dirBatchReader. Every Readdir of the open
+// directory is a getListing call for the entries after the last one read.
+func (c colinmarcClient) ReadDirBatches(dirname string, n int, f func([]os.FileInfo) error) error {
+	dir, err := c.Client.Open(dirname)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	for {
+		batch, err := dir.Readdir(n)
+		if len(batch) > 0 {
+			if ferr := f(batch); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF || (err == nil && len(batch) == 0) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package hdfs
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	netcontext "golang.org/x/net/context"
+)
+
+func TestListStream(t *testing.T) {
+	client := newFakeClient()
+	for i := 0; i < 2*listStreamBatch+1; i++ {
+		client.writeFile(fmt.Sprintf("/registry/blobs/%04d", i), []byte("blob"))
+	}
+	client.writeFile("/registry/blobs/.staging-0000-host-1", []byte("partial"))
+	d := wrap(newTestDriver(client))
+
+	streamer, ok := storagedriver.StorageDriver(d).(ListStreamer)
+	if !ok {
+		t.Fatal("expected the driver to implement ListStreamer")
+	}
+	paths, errs := streamer.ListStream(context.Background(), "/blobs")
+	var streamed []string
+	for p := range paths {
+		streamed = append(streamed, p)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	listed, err := d.List(context.Background(), "/blobs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(streamed)
+	if len(streamed) != len(listed) || len(listed) != 2*listStreamBatch+1 {
+		t.Fatalf("expected %d paths, got %d streamed and %d listed", 2*listStreamBatch+1, len(streamed), len(listed))
+	}
+	for i := range listed {
+		if streamed[i] != listed[i] {
+			t.Fatalf("expected %s, got %s", listed[i], streamed[i])
+		}
+	}
+
+	// Missing directories end the stream with PathNotFoundError
+	paths, errs = d.ListStream(context.Background(), "/missing")
+	for range paths {
+		t.Fatal("expected no paths for a missing directory")
+	}
+	if err := <-errs; !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+}
+
+func TestListStreamCancel(t *testing.T) {
+	client := newFakeClient()
+	for i := 0; i < listStreamBatch; i++ {
+		client.writeFile(fmt.Sprintf("/registry/blobs/%04d", i), []byte("blob"))
+	}
+	d := wrap(newTestDriver(client))
+
+	ctx, cancel := netcontext.WithCancel(context.Background())
+	paths, errs := d.ListStream(ctx, "/blobs")
+	<-paths
+	cancel()
+
+	received := 1
+	for range paths {
+		received++
+	}
+	if err := <-errs; err != netcontext.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if received > 2 {
+		t.Fatalf("expected the stream to stop when cancelled, got %d paths", received)
+	}
+}
@@ -0,0 +1,347 @@
+package hdfs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxInvalidatedPaths bounds the paths the local cache remembers the
+// invalidation of. Once there are more, they are forgotten and every read
+// in progress is dropped instead.
+const maxInvalidatedPaths = 4096
+
+// localCacheSuffix ends the names of the files the local cache keeps, so
+// that only they are cleared from localcachedirectory at startup
+const localCacheSuffix = ".hdfscache"
+
+// localCache keeps copies of what Reader and GetContent read from the
+// immutable paths of contentcachedirs on local disk, evicting the least
+// recently used once they take more than maxBytes. Like contentCache it is
+// keyed by full HDFS path and invalidated by writes, moves and deletes.
+// Files are written under a temporary name and renamed into place once
+// complete, so a cached file is never read half written. The index is kept
+// in memory only; files left by a previous run are removed.
+//
+// Reads take the generation of the cache before they open a file, and what
+// they read is only cached if neither the file nor a directory above it was
+// invalidated since, so a read that raced a write, move or delete does not
+// bring the old contents back while reads of other paths still are. Only
+// the operations of this instance invalidate its copies: an object another
+// registry instance deletes is still served from the copy here until it is
+// evicted or the registry restarts, which is why only immutable paths are
+// cached.
+type localCache struct {
+	dir      string
+	maxBytes int64
+	prefixes []string
+
+	mu      sync.Mutex
+	bytes   int64
+	order   *list.List
+	entries map[string]*list.Element
+
+	// below holds the cached paths below each directory, so that
+	// invalidating a directory finds them without a scan of entries
+	below map[string]map[string]*list.Element
+
+	// invalidations counts the calls to invalidate of cached paths, see
+	// generation. invalidated is the count at the last invalidation of each
+	// path, and reads from before forgotten are dropped.
+	invalidations uint64
+	invalidated   map[string]uint64
+	forgotten     uint64
+}
+
+type localCacheEntry struct {
+	fullPath string
+	size     int64
+}
+
+// newLocalCache returns nil when maxBytes is 0. prefixes are the paths below
+// the root directory that are cached.
+func newLocalCache(dir string, maxBytes int64, root string, prefixes []string) (*localCache, error) {
+	if maxBytes <= 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "*"+localCacheSuffix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range stale {
+		os.Remove(file)
+	}
+
+	return &localCache{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		prefixes:    joinPrefixes(root, prefixes),
+		order:       list.New(),
+		entries:     make(map[string]*list.Element),
+		below:       make(map[string]map[string]*list.Element),
+		invalidated: make(map[string]uint64),
+	}, nil
+}
+
+// cacheable reports whether the contents of fullPath, size bytes long, are
+// cached. Like contentCache, files over a quarter of the cache are not.
+func (c *localCache) cacheable(fullPath string, size int64) bool {
+	return c != nil && size <= c.maxBytes/4 && belowAny(c.prefixes, fullPath)
+}
+
+// file returns the name of the cached copy of fullPath
+func (c *localCache) file(fullPath string) string {
+	sum := sha256.Sum256([]byte(fullPath))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+localCacheSuffix)
+}
+
+// open opens the cached copy of fullPath
+func (c *localCache) open(fullPath string) (*os.File, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[fullPath]
+	if !ok {
+		return nil, false
+	}
+	file, err := os.Open(c.file(fullPath))
+	if err != nil {
+		log.Printf("hdfs: dropping the local copy of %s: %v", fullPath, err)
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return file, true
+}
+
+// get returns the cached contents of fullPath
+func (c *localCache) get(fullPath string) ([]byte, bool) {
+	file, ok := c.open(fullPath)
+	if !ok {
+		return nil, false
+	}
+	defer file.Close()
+	contents, err := ioutil.ReadAll(file)
+	if err != nil {
+		log.Printf("hdfs: reading the local copy of %s: %v", fullPath, err)
+		return nil, false
+	}
+	return contents, true
+}
+
+// generation returns what a read passes to add or tee, taken before it
+// opens the file, so that its contents are dropped when the cache was
+// invalidated in the meantime
+func (c *localCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.invalidations
+}
+
+// add caches contents of fullPath, read since generation
+func (c *localCache) add(fullPath string, contents []byte, generation uint64) {
+	if !c.cacheable(fullPath, int64(len(contents))) {
+		return
+	}
+	temp, err := c.tempFile()
+	if err != nil {
+		return
+	}
+	if _, err := temp.Write(contents); err != nil {
+		c.discard(temp, err)
+		return
+	}
+	c.commit(fullPath, temp, int64(len(contents)), generation)
+}
+
+// tee returns reader, which reads the size bytes of fullPath from the start,
+// caching what it reads once it was read to the end unless the cache was
+// invalidated after generation
+func (c *localCache) tee(fullPath string, reader io.ReadCloser, size int64, generation uint64) io.ReadCloser {
+	if !c.cacheable(fullPath, size) {
+		return reader
+	}
+	temp, err := c.tempFile()
+	if err != nil {
+		return reader
+	}
+	return &cachingReader{ReadCloser: reader, cache: c, fullPath: fullPath, size: size, generation: generation, temp: temp}
+}
+
+func (c *localCache) tempFile() (*os.File, error) {
+	temp, err := ioutil.TempFile(c.dir, "tmp"+localCacheSuffix)
+	if err != nil {
+		log.Printf("hdfs: unable to create a file in localcachedirectory %s: %v", c.dir, err)
+	}
+	return temp, err
+}
+
+func (c *localCache) discard(temp *os.File, err error) {
+	if err != nil {
+		log.Printf("hdfs: unable to write to localcachedirectory %s: %v", c.dir, err)
+	}
+	temp.Close()
+	os.Remove(temp.Name())
+}
+
+// commit moves the complete temp into place as the copy of fullPath,
+// unless the cache was invalidated after generation
+func (c *localCache) commit(fullPath string, temp *os.File, size int64, generation uint64) {
+	if err := temp.Close(); err != nil {
+		c.discard(temp, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.invalidatedSince(fullPath, generation) {
+		c.discard(temp, nil)
+		return
+	}
+	if element, ok := c.entries[fullPath]; ok {
+		c.remove(element)
+	}
+	if err := os.Rename(temp.Name(), c.file(fullPath)); err != nil {
+		c.discard(temp, err)
+		return
+	}
+	element := c.order.PushFront(&localCacheEntry{fullPath: fullPath, size: size})
+	c.entries[fullPath] = element
+	for dir := path.Dir(fullPath); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if c.below[dir] == nil {
+			c.below[dir] = make(map[string]*list.Element)
+		}
+		c.below[dir][fullPath] = element
+	}
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidatedSince reports whether fullPath or a directory above it was
+// invalidated after generation
+func (c *localCache) invalidatedSince(fullPath string, generation uint64) bool {
+	if generation < c.forgotten {
+		return true
+	}
+	for p := fullPath; p != "/" && p != "."; p = path.Dir(p) {
+		if c.invalidated[p] > generation {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidate drops fullPath and everything below it. Paths that neither are
+// cached nor hold cached paths, such as uploads, are left alone.
+func (c *localCache) invalidate(fullPath string) {
+	if c == nil || !c.holds(fullPath) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidations++
+	if len(c.invalidated) >= maxInvalidatedPaths {
+		c.invalidated = make(map[string]uint64)
+		c.forgotten = c.invalidations
+	}
+	c.invalidated[fullPath] = c.invalidations
+	if element, ok := c.entries[fullPath]; ok {
+		c.remove(element)
+	}
+	for _, element := range c.below[fullPath] {
+		c.remove(element)
+	}
+}
+
+// holds reports whether fullPath is cached or is a directory above those
+// that are
+func (c *localCache) holds(fullPath string) bool {
+	if fullPath == "/" || belowAny(c.prefixes, fullPath) {
+		return true
+	}
+	for _, prefix := range c.prefixes {
+		if prefix == fullPath || strings.HasPrefix(prefix, fullPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// remove drops element and its file. Readers that opened the file keep
+// reading it.
+func (c *localCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*localCacheEntry)
+	delete(c.entries, entry.fullPath)
+	for dir := path.Dir(entry.fullPath); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		delete(c.below[dir], entry.fullPath)
+		if len(c.below[dir]) == 0 {
+			delete(c.below, dir)
+		}
+	}
+	c.bytes -= entry.size
+	os.Remove(c.file(entry.fullPath))
+}
+
+// cachingReader copies what is read to a temporary file, which becomes the
+// cached copy once the whole file was read
+type cachingReader struct {
+	io.ReadCloser
+	cache      *localCache
+	fullPath   string
+	size       int64
+	generation uint64
+	temp       *os.File
+	written    int64
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.temp == nil {
+		return n, err
+	}
+	if n > 0 {
+		if _, werr := r.temp.Write(p[:n]); werr != nil {
+			r.cache.discard(r.temp, werr)
+			r.temp = nil
+			return n, err
+		}
+		r.written += int64(n)
+	}
+	if err == io.EOF {
+		if r.written == r.size {
+			r.cache.commit(r.fullPath, r.temp, r.size, r.generation)
+		} else {
+			r.cache.discard(r.temp, nil)
+		}
+		r.temp = nil
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	if r.temp != nil {
+		r.cache.discard(r.temp, nil)
+		r.temp = nil
+	}
+	return r.ReadCloser.Close()
+}
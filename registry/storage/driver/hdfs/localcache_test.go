@@ -0,0 +1,219 @@
+package hdfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func newLocalCacheTestDriver(t *testing.T, client *fakeClient, size int64) (*driver, string) {
+	dir, err := ioutil.TempDir("", "hdfs-localcache")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d := newTestDriverWithParameters(client, DriverParameters{LocalCacheDir: dir, LocalCacheSize: size, ContentCacheDirs: defaultContentCachePrefixes})
+	return d, dir
+}
+
+func readThrough(t *testing.T, d *driver, path string) string {
+	reader, err := d.Reader(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+	contents, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(contents)
+}
+
+func TestLocalCacheServesRepeatedReads(t *testing.T) {
+	client := newFakeClient()
+	blob := "/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data"
+	client.writeFile("/registry"+blob, []byte("layer contents"))
+	d, dir := newLocalCacheTestDriver(t, client, 1<<20)
+	defer os.RemoveAll(dir)
+
+	if contents := readThrough(t, d, blob); contents != "layer contents" {
+		t.Fatalf("unexpected contents %q", contents)
+	}
+	opens := client.callCount("Open")
+	if contents := readThrough(t, d, blob); contents != "layer contents" {
+		t.Fatalf("unexpected contents from the local cache %q", contents)
+	}
+	if contents, err := d.GetContent(context.Background(), blob); err != nil || string(contents) != "layer contents" {
+		t.Fatalf("unexpected contents from the local cache %q, %v", contents, err)
+	}
+	reader, err := d.Reader(context.Background(), blob, 6)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rest, _ := ioutil.ReadAll(reader); string(rest) != "contents" {
+		t.Fatalf("expected the local copy to honor the offset, got %q", rest)
+	}
+	reader.Close()
+	if calls := client.callCount("Open"); calls != opens {
+		t.Fatalf("expected the repeated reads to come from local disk, got %d more opens", calls-opens)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Fatalf("expected exactly one cached file, got %v", files)
+	}
+
+	// Deleting the blob drops the local copy
+	if err := d.Delete(context.Background(), blob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := d.GetContent(context.Background(), blob); !isPathNotFound(err) {
+		t.Fatalf("expected the deleted blob to be gone, got %v", err)
+	}
+}
+
+func TestLocalCacheEviction(t *testing.T) {
+	client := newFakeClient()
+	d, dir := newLocalCacheTestDriver(t, client, 40)
+	defer os.RemoveAll(dir)
+
+	blobs := make([]string, 5)
+	for i := range blobs {
+		blobs[i] = fmt.Sprintf("/docker/registry/v2/blobs/sha256/ab/%d/data", i)
+		client.writeFile("/registry"+blobs[i], []byte(fmt.Sprintf("contents-%d", i)))
+		readThrough(t, d, blobs[i])
+	}
+
+	var total int64
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		total += fi.Size()
+	}
+	if total > 40 || len(files) != 4 {
+		t.Fatalf("expected 4 files within 40 bytes, got %d files of %d bytes", len(files), total)
+	}
+
+	// The least recently read blob was evicted
+	opens := client.callCount("Open")
+	readThrough(t, d, blobs[0])
+	if client.callCount("Open") != opens+1 {
+		t.Fatal("expected the evicted blob to be read from HDFS")
+	}
+}
+
+func TestLocalCacheIgnoresPartialReads(t *testing.T) {
+	client := newFakeClient()
+	blob := "/docker/registry/v2/blobs/sha256/ab/partial/data"
+	client.writeFile("/registry"+blob, []byte("layer contents"))
+	d, dir := newLocalCacheTestDriver(t, client, 1<<20)
+	defer os.RemoveAll(dir)
+
+	reader, err := d.Reader(context.Background(), blob, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reader.Read(make([]byte, 4))
+	reader.Close()
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Fatalf("expected a partial read to leave nothing behind, got %v", files)
+	}
+}
+
+func TestLocalCacheDropsReadsRacingInvalidations(t *testing.T) {
+	client := newFakeClient()
+	blob := "/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data"
+	client.writeFile("/registry"+blob, []byte("layer contents"))
+	d, dir := newLocalCacheTestDriver(t, client, 1<<20)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	// A delete while a Reader streams the old contents
+	reader, err := d.Reader(ctx, blob, 0)
+	if err != nil {
+		t.Fatalf("unexpected error from Reader: %v", err)
+	}
+	if err := d.Delete(ctx, blob); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+	if contents, err := ioutil.ReadAll(reader); err != nil || string(contents) != "layer contents" {
+		t.Fatalf("expected the open reader to finish, got %q, %v", contents, err)
+	}
+	reader.Close()
+	if _, err := d.GetContent(ctx, blob); !isPathNotFound(err) {
+		t.Fatalf("expected the deleted blob not to be served from the local cache, got %v", err)
+	}
+
+	// A move of the path while GetContent reads it
+	client.writeFile("/registry"+blob, []byte("layer contents"))
+	client.hook("Open", func(string) error {
+		d.localCache.invalidate("/registry" + blob)
+		return nil
+	})
+	if contents, err := d.GetContent(ctx, blob); err != nil || string(contents) != "layer contents" {
+		t.Fatalf("unexpected contents %q, %v", contents, err)
+	}
+	client.hook("Open", nil)
+	if _, ok := d.localCache.open("/registry" + blob); ok {
+		t.Fatal("expected a read that raced an invalidation not to be cached")
+	}
+
+	// A delete of the directory above it
+	client.hook("Open", func(string) error {
+		d.localCache.invalidate("/registry/docker/registry/v2/blobs/sha256/ab")
+		return nil
+	})
+	readThrough(t, d, blob)
+	client.hook("Open", nil)
+	if _, ok := d.localCache.open("/registry" + blob); ok {
+		t.Fatal("expected a read that raced the invalidation of its directory not to be cached")
+	}
+}
+
+func TestLocalCacheKeepsReadsOfOtherPaths(t *testing.T) {
+	client := newFakeClient()
+	blob := "/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data"
+	other := "/docker/registry/v2/blobs/sha256/cd/" + testDigestHex + "/data"
+	client.writeFile("/registry"+blob, []byte("layer contents"))
+	client.writeFile("/registry"+other, []byte("other contents"))
+	d, dir := newLocalCacheTestDriver(t, client, 1<<20)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	readThrough(t, d, other)
+
+	// Writes of uploads and of other blobs while the blob is read
+	client.hook("Open", func(name string) error {
+		if name != "/registry"+blob {
+			return nil
+		}
+		if err := d.PutContent(ctx, "/docker/registry/v2/repositories/foo/_uploads/id/data", []byte("upload")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		d.localCache.invalidate("/registry" + other)
+		return nil
+	})
+	readThrough(t, d, blob)
+	client.hook("Open", nil)
+	if _, ok := d.localCache.open("/registry" + blob); !ok {
+		t.Fatal("expected the read to be cached despite writes of other paths")
+	}
+	if _, ok := d.localCache.open("/registry" + other); ok {
+		t.Fatal("expected the invalidated path to be dropped")
+	}
+
+	// Deleting the directory above a cached blob drops it
+	if err := d.Delete(ctx, "/docker/registry/v2/blobs/sha256/ab"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := d.localCache.open("/registry" + blob); ok {
+		t.Fatal("expected the blob below the deleted directory to be dropped")
+	}
+	if len(d.localCache.below) != 0 {
+		t.Fatalf("expected no directories left to invalidate, got %v", d.localCache.below)
+	}
+}
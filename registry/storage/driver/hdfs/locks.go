@@ -0,0 +1,234 @@
+package hdfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// lockDirectoryName is the directory locks are kept in unless lockdirectory
+// says otherwise, at the top of the root directory next to rootMarkerName.
+// Driver paths cannot start with a dot, so it never collides with registry
+// data.
+const lockDirectoryName = ".registry-locks"
+
+// lockNameRegexp matches the names AcquireLock accepts, which become file
+// names in the lock directory
+var lockNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// lockWriteGrace is how long a lock file that does not parse yet is taken
+// to be held. The namenode creates the file before its holder writes it, so
+// another replica may read it empty; one whose holder died before writing
+// it expires this long after it was created.
+const lockWriteGrace = time.Minute
+
+// Locker is implemented by drivers that can serialize work across registry
+// replicas sharing the storage, such as the HDFS driver. Garbage collection
+// can hold a lock so only one replica runs at a time:
+//
+//	if locker, ok := driver.(hdfs.Locker); ok {
+//		if err := locker.AcquireLock(ctx, "gc", hostname, time.Hour); err != nil {
+//			return err
+//		}
+//		defer locker.ReleaseLock(ctx, "gc", hostname)
+//	}
+type Locker interface {
+	// AcquireLock takes the lock called name for holder, until ReleaseLock
+	// or until ttl passes, so a replica that died holding it does not keep
+	// it forever. A lock another holder has returns a LockHeldError.
+	AcquireLock(ctx context.Context, name, holder string, ttl time.Duration) error
+
+	// ReleaseLock releases the lock called name if holder has it. Locks
+	// that expired and were taken over return a LockHeldError.
+	ReleaseLock(ctx context.Context, name, holder string) error
+}
+
+// LockHeldError is returned for a lock another holder has
+type LockHeldError struct {
+	Name    string
+	Holder  string
+	Expires time.Time
+}
+
+func (e LockHeldError) Error() string {
+	return fmt.Sprintf("hdfs: lock %s is held by %s until %s", e.Name, e.Holder, e.Expires.Format(time.RFC3339))
+}
+
+// lockFile is the content of a lock file
+type lockFile struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// AcquireLock implements Locker. The lock is a file in lockdirectory that
+// the namenode creates for one replica only.
+func (d *Driver) AcquireLock(ctx context.Context, name, holder string, ttl time.Duration) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.AcquireLock(%q, %q, %v)", d.Name(), name, holder, ttl)
+
+	if !lockNameRegexp.MatchString(name) {
+		return fmt.Errorf("hdfs: invalid lock name %q", name)
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("hdfs: the ttl of lock %s must be positive, %v invalid", name, ttl)
+	}
+	return d.inner().acquireLock(name, holder, ttl)
+}
+
+// ReleaseLock implements Locker
+func (d *Driver) ReleaseLock(ctx context.Context, name, holder string) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.ReleaseLock(%q, %q)", d.Name(), name, holder)
+
+	if !lockNameRegexp.MatchString(name) {
+		return fmt.Errorf("hdfs: invalid lock name %q", name)
+	}
+	return d.inner().releaseLock(name, holder)
+}
+
+func (d *driver) lockPath(name string) string {
+	return path.Join(d.lockDirectory, name)
+}
+
+func (d *driver) acquireLock(name, holder string, ttl time.Duration) error {
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	if err := d.mkdir(d.lockDirectory); err != nil {
+		return err
+	}
+	lockPath := d.lockPath(name)
+
+	err := d.createLock(lockPath, holder, ttl)
+	if !os.IsExist(err) {
+		return err
+	}
+	current, err := d.readLock(lockPath)
+	if os.IsNotExist(err) {
+		// Released meanwhile
+		return d.createLock(lockPath, holder, ttl)
+	} else if err != nil {
+		return err
+	}
+	if time.Now().Before(current.Expires) {
+		return LockHeldError{Name: name, Holder: current.Holder, Expires: current.Expires}
+	}
+
+	if err := d.breakLock(lockPath, current); err != nil {
+		return err
+	}
+	err = d.createLock(lockPath, holder, ttl)
+	if os.IsExist(err) {
+		// Another replica took the expired lock over first
+		if current, rerr := d.readLock(lockPath); rerr == nil {
+			return LockHeldError{Name: name, Holder: current.Holder, Expires: current.Expires}
+		}
+	}
+	return err
+}
+
+// createLock creates the lock file for holder, failing with an os.ErrExist
+// error when it exists
+func (d *driver) createLock(lockPath, holder string, ttl time.Duration) error {
+	contents, err := json.Marshal(lockFile{Holder: holder, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	writer, err := d.hdfsClient.Create(lockPath)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(contents)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		d.hdfsClient.Remove(lockPath)
+	}
+	return err
+}
+
+// readLock reads the lock file at lockPath. One that does not parse is
+// returned without a holder, expiring lockWriteGrace after it was last
+// modified, see lockWriteGrace.
+func (d *driver) readLock(lockPath string) (lockFile, error) {
+	var lock lockFile
+	contents, err := d.hdfsClient.ReadFile(lockPath)
+	if err != nil {
+		return lock, err
+	}
+	if json.Unmarshal(contents, &lock) == nil {
+		return lock, nil
+	}
+	fi, err := d.hdfsClient.Stat(lockPath)
+	if err != nil {
+		return lock, err
+	}
+	return lockFile{Expires: fi.ModTime().Add(lockWriteGrace)}, nil
+}
+
+// renameLock moves the lock at lockPath aside and returns the lock it held,
+// so that it can be removed only if it is the expected one. HDFS cannot
+// remove a file only if it is unchanged, but of two replicas renaming it
+// at once only one succeeds. A lock that cannot be read is put back.
+func (d *driver) renameLock(lockPath string) (aside string, lock lockFile, err error) {
+	aside = fmt.Sprintf("%s.expired-%d", lockPath, time.Now().UnixNano())
+	if err := d.hdfsClient.Rename(lockPath, aside); err != nil {
+		return "", lock, err
+	}
+	lock, err = d.readLock(aside)
+	if err != nil {
+		if rerr := d.hdfsClient.Rename(aside, lockPath); rerr != nil {
+			return "", lock, rerr
+		}
+		return "", lock, err
+	}
+	return aside, lock, nil
+}
+
+// breakLock removes the expired lock stale, putting back a lock that turns
+// out to have been taken over meanwhile
+func (d *driver) breakLock(lockPath string, stale lockFile) error {
+	aside, broken, err := d.renameLock(lockPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if broken.Holder != stale.Holder || !broken.Expires.Equal(stale.Expires) {
+		return d.hdfsClient.Rename(aside, lockPath)
+	}
+	return d.hdfsClient.Remove(aside)
+}
+
+func (d *driver) releaseLock(name, holder string) error {
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	lockPath := d.lockPath(name)
+
+	// Checking the holder before removing the lock would remove the lock
+	// of a replica that took it over in between, so it is checked aside.
+	// Until it is put back, the lock of another holder can be taken over.
+	aside, current, err := d.renameLock(lockPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if current.Holder != holder {
+		if err := d.hdfsClient.Rename(aside, lockPath); err != nil {
+			return err
+		}
+		return LockHeldError{Name: name, Holder: current.Holder, Expires: current.Expires}
+	}
+	if err := d.hdfsClient.Remove(aside); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
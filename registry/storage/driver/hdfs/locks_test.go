@@ -0,0 +1,124 @@
+package hdfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestLockExcludesOtherHolders(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriver(client))
+	ctx := context.Background()
+	if _, ok := storagedriver.StorageDriver(d).(Locker); !ok {
+		t.Fatalf("expected the driver to implement Locker")
+	}
+
+	if err := d.AcquireLock(ctx, "gc", "replica-1", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := d.AcquireLock(ctx, "gc", "replica-2", time.Hour)
+	if e, ok := err.(LockHeldError); !ok || e.Holder != "replica-1" {
+		t.Fatalf("expected the lock to be held by replica-1, got %v", err)
+	}
+	if err := d.ReleaseLock(ctx, "gc", "replica-2"); err == nil {
+		t.Fatal("expected releasing a lock held by another replica to fail")
+	}
+	if err := d.AcquireLock(ctx, "gc", "replica-2", time.Hour); err == nil {
+		t.Fatal("expected the lock to be put back after the failed release")
+	}
+
+	if err := d.ReleaseLock(ctx, "gc", "replica-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.AcquireLock(ctx, "gc", "replica-2", time.Hour); err != nil {
+		t.Fatalf("expected the released lock to be acquired: %v", err)
+	}
+
+	// Locks stay out of listings of the root
+	client.writeFile("/registry/top", []byte("top"))
+	if entries, err := d.List(ctx, "/"); err != nil || len(entries) != 1 || entries[0] != "/top" {
+		t.Fatalf("expected only /top to be listed, got %v, %v", entries, err)
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriverWithParameters(client, DriverParameters{LockDirectory: "/locks"}))
+	ctx := context.Background()
+
+	if err := d.AcquireLock(ctx, "gc", "replica-1", 20*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Stat("/locks/gc"); err != nil {
+		t.Fatalf("expected the lock in lockdirectory: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if err := d.AcquireLock(ctx, "gc", "replica-2", time.Hour); err != nil {
+		t.Fatalf("expected the expired lock to be taken over: %v", err)
+	}
+	if err := d.ReleaseLock(ctx, "gc", "replica-1"); err == nil {
+		t.Fatal("expected the previous holder to have lost the lock")
+	}
+	if entries, _ := client.ReadDir("/locks"); len(entries) != 1 {
+		t.Fatalf("expected the expired lock to be removed, got %d entries", len(entries))
+	}
+}
+
+func TestLockBeingWrittenIsHeld(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriverWithParameters(client, DriverParameters{LockDirectory: "/locks"}))
+	ctx := context.Background()
+
+	// The namenode created the file, its holder has not written it yet
+	client.writeFile("/locks/gc", nil)
+	if err := d.AcquireLock(ctx, "gc", "replica-2", time.Hour); err == nil {
+		t.Fatal("expected a lock being written to be held")
+	} else if _, ok := err.(LockHeldError); !ok {
+		t.Fatalf("expected a LockHeldError, got %v", err)
+	}
+
+	// One whose holder died before writing it expires
+	old := time.Now().Add(-2 * lockWriteGrace)
+	client.Chtimes("/locks/gc", old, old)
+	if err := d.AcquireLock(ctx, "gc", "replica-2", time.Hour); err != nil {
+		t.Fatalf("expected the abandoned lock to be taken over: %v", err)
+	}
+}
+
+func TestLockUnreadableWhenBrokenIsPutBack(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriverWithParameters(client, DriverParameters{LockDirectory: "/locks"}))
+	ctx := context.Background()
+
+	if err := d.AcquireLock(ctx, "gc", "replica-1", time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	client.hook("ReadFile", func(name string) error {
+		if strings.Contains(name, ".expired-") {
+			return errors.New("connection reset by peer")
+		}
+		return nil
+	})
+	if err := d.AcquireLock(ctx, "gc", "replica-2", time.Hour); err == nil {
+		t.Fatal("expected the failed read to fail AcquireLock")
+	}
+	if entries, _ := client.ReadDir("/locks"); len(entries) != 1 || entries[0].Name() != "gc" {
+		t.Fatalf("expected the lock to be put back, got %v", entries)
+	}
+}
+
+func TestLockInvalidName(t *testing.T) {
+	d := wrap(newTestDriver(newFakeClient()))
+	for _, name := range []string{"", ".hidden", "a/b"} {
+		if err := d.AcquireLock(context.Background(), name, "replica-1", time.Hour); err == nil {
+			t.Errorf("expected lock name %q to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package hdfs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The namenode refuses to create a path with a component longer than
+// dfs.namenode.fs-limits.max-component-length, 255 bytes by default, or
+// longer than 8000 characters in all, and says so with an exception that
+// surfaces as an obscure create failure. Long repository names nested in
+// the registry's layout can get there. longpathstrategy decides what the
+// driver does about it:
+//
+//   - none, the default, leaves it to the namenode
+//   - error fails writes to such paths with a PathTooLongError upfront
+//   - shorten stores components longer than maxpathcomponentlength split
+//     over nested directories, "~~" and "~-" followed by a part of the
+//     name, which List joins back into the original name. Only paths
+//     longer than 8000 characters in all fail, with a PathTooLongError.
+//
+// maxpathcomponentlength should match the limit of the cluster. Lowering
+// it with shorten on a populated root hides the content stored under the
+// components that have become too long.
+
+// The values of the longpathstrategy parameter
+const (
+	longPathNone    = "none"
+	longPathError   = "error"
+	longPathShorten = "shorten"
+)
+
+// defaultMaxPathComponentLength is the namenode's default of
+// dfs.namenode.fs-limits.max-component-length
+const defaultMaxPathComponentLength = 255
+
+// minPathComponentLength bounds maxpathcomponentlength so that the parts
+// of a shortened component cannot be taken for digestprefix shards
+const minPathComponentLength = 16
+
+// maxHDFSPathLength is the longest path the namenode accepts
+const maxHDFSPathLength = 8000
+
+// Shortened components are split into parts starting with these markers,
+// which are outside the characters of storage driver paths
+const (
+	longPathPartMarker = "~~"
+	longPathLastMarker = "~-"
+)
+
+func validateLongPathStrategy(strategy string) error {
+	switch strategy {
+	case "", longPathNone, longPathError, longPathShorten:
+		return nil
+	}
+	return fmt.Errorf("The longpathstrategy parameter must be one of none, error or shorten, %q invalid", strategy)
+}
+
+// PathTooLongError is returned by longpathstrategy for writes to paths the
+// namenode would refuse to create
+type PathTooLongError struct {
+	Path string
+	// Component is the component over the limit, empty when the whole
+	// HDFS path is
+	Component string
+	Length    int
+	Limit     int
+}
+
+func (e PathTooLongError) Error() string {
+	if e.Component != "" {
+		return fmt.Sprintf("hdfs: %s cannot be stored, its component %q is %d bytes long, over the limit of %d", e.Path, e.Component, e.Length, e.Limit)
+	}
+	return fmt.Sprintf("hdfs: %s cannot be stored, its HDFS path is %d characters long, over the limit of %d", e.Path, e.Length, e.Limit)
+}
+
+// checkPathLength returns a PathTooLongError if longpathstrategy refuses
+// writes to subPath
+func (d *driver) checkPathLength(subPath string) error {
+	if d.longPathStrategy == "" || d.longPathStrategy == longPathNone {
+		return nil
+	}
+	fullPath := d.fullPath(subPath)
+	if d.longPathStrategy == longPathError {
+		for _, component := range strings.Split(strings.TrimPrefix(fullPath, d.hdfsRootDirectory), "/") {
+			if len(component) > d.maxPathComponentLength {
+				return PathTooLongError{Path: subPath, Component: component, Length: len(component), Limit: d.maxPathComponentLength}
+			}
+		}
+	}
+	if len(fullPath) > maxHDFSPathLength {
+		return PathTooLongError{Path: subPath, Length: len(fullPath), Limit: maxHDFSPathLength}
+	}
+	return nil
+}
+
+// maxPathComponentLength returns the maxpathcomponentlength of params, the
+// namenode's default when it is not set
+func maxPathComponentLength(params DriverParameters) int {
+	if params.MaxPathComponentLength == 0 {
+		return defaultMaxPathComponentLength
+	}
+	return int(params.MaxPathComponentLength)
+}
+
+// newLongPathTransform returns next with the components it maps longer
+// than limit shortened if strategy is shorten, and next otherwise
+func newLongPathTransform(next pathTransform, strategy string, limit int) (pathTransform, error) {
+	if err := validateLongPathStrategy(strategy); err != nil {
+		return nil, err
+	}
+	if strategy != longPathShorten {
+		return next, nil
+	}
+	if limit == 0 {
+		limit = defaultMaxPathComponentLength
+	}
+	if limit < minPathComponentLength || limit > maxHDFSPathLength {
+		return nil, fmt.Errorf("The maxpathcomponentlength %#v parameter should be a number between %d and %d (inclusive)", limit, minPathComponentLength, maxHDFSPathLength)
+	}
+	return longPathTransform{next: next, limit: limit}, nil
+}
+
+// longPathTransform splits the components longer than limit that next
+// maps a path to. A component of 600 bytes with a limit of 255 is stored
+// as "~~<200 bytes>/~~<200 bytes>/~-<200 bytes>": the parts but the last
+// are intermediate directories, so that List reports the last, joined
+// with the parts above it, as the entry.
+type longPathTransform struct {
+	next  pathTransform
+	limit int
+}
+
+func (t longPathTransform) transform(subPath string) string {
+	if t.next != nil {
+		subPath = t.next.transform(subPath)
+	}
+	components := strings.Split(subPath, "/")
+	transformed := make([]string, 0, len(components))
+	for _, component := range components {
+		if len(component) <= t.limit {
+			transformed = append(transformed, component)
+			continue
+		}
+		transformed = append(transformed, t.split(component)...)
+	}
+	return strings.Join(transformed, "/")
+}
+
+// split splits component into parts of about the same length that fit
+// the limit with their marker
+func (t longPathTransform) split(component string) []string {
+	partLength := t.limit - len(longPathPartMarker)
+	count := (len(component) + partLength - 1) / partLength
+	parts := make([]string, count)
+	start := 0
+	for i := range parts {
+		length := len(component) / count
+		if i < len(component)%count {
+			length++
+		}
+		marker := longPathPartMarker
+		if i == count-1 {
+			marker = longPathLastMarker
+		}
+		parts[i] = marker + component[start:start+length]
+		start += length
+	}
+	return parts
+}
+
+func (t longPathTransform) reverse(hdfsPath string) string {
+	components := strings.Split(hdfsPath, "/")
+	reversed := make([]string, 0, len(components))
+	joined := ""
+	for _, component := range components {
+		switch {
+		case strings.HasPrefix(component, longPathPartMarker):
+			joined += strings.TrimPrefix(component, longPathPartMarker)
+		case strings.HasPrefix(component, longPathLastMarker):
+			reversed = append(reversed, joined+strings.TrimPrefix(component, longPathLastMarker))
+			joined = ""
+		default:
+			reversed = append(reversed, component)
+		}
+	}
+	// A path ending within a shortened component, such as the relative
+	// path of an intermediate directory
+	if joined != "" {
+		reversed = append(reversed, joined)
+	}
+	hdfsPath = strings.Join(reversed, "/")
+	if t.next != nil {
+		hdfsPath = t.next.reverse(hdfsPath)
+	}
+	return hdfsPath
+}
+
+func (t longPathTransform) isIntermediate(name string) bool {
+	if strings.HasPrefix(name, longPathPartMarker) {
+		return true
+	}
+	return t.next != nil && t.next.isIntermediate(name)
+}
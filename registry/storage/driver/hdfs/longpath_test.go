@@ -0,0 +1,125 @@
+package hdfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// enclosedPathTooLong returns the PathTooLongError base.Base wrapped err
+// around
+func enclosedPathTooLong(err error) (PathTooLongError, bool) {
+	if enclosing, ok := err.(storagedriver.Error); ok {
+		err = enclosing.Enclosed
+	}
+	tooLong, ok := err.(PathTooLongError)
+	return tooLong, ok
+}
+
+// longRepository is a repository name component over the namenode's
+// default limit of 255 bytes
+var longRepository = strings.Repeat("a", 300)
+
+func TestLongPathStrategyError(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriverWithParameters(client, DriverParameters{LongPathStrategy: "error"}))
+	ctx := context.Background()
+
+	longPath := "/repositories/" + longRepository + "/_layers/link"
+	err := d.PutContent(ctx, longPath, []byte("link"))
+	tooLong, ok := enclosedPathTooLong(err)
+	if !ok || tooLong.Component != longRepository || tooLong.Limit != defaultMaxPathComponentLength {
+		t.Fatalf("expected a PathTooLongError naming the component, got %v", err)
+	}
+	if category := ErrorCategoryOf(err); category != ErrorInvalid {
+		t.Fatalf("expected an invalid error, got %q", category)
+	}
+	if _, err := d.Writer(ctx, longPath, false); err == nil {
+		t.Fatal("expected Writer to refuse the path too")
+	}
+	if calls := client.callCount("CreateFile") + client.callCount("Create") + client.callCount("CreateWithParents"); calls != 0 {
+		t.Fatalf("expected nothing to be created, got %d creates", calls)
+	}
+
+	// Paths within the limit are stored as they are
+	if err := d.PutContent(ctx, "/repositories/"+longRepository[:255]+"/_layers/link", []byte("link")); err != nil {
+		t.Fatalf("unexpected error for a path within the limit: %v", err)
+	}
+}
+
+func TestLongPathStrategyShorten(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriverWithParameters(client, DriverParameters{LongPathStrategy: "shorten"}))
+	ctx := context.Background()
+
+	longPath := "/repositories/" + longRepository + "/_layers/link"
+	if err := d.PutContent(ctx, longPath, []byte("link")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	client.mu.Lock()
+	for name := range client.files {
+		for _, component := range strings.Split(name, "/") {
+			if len(component) > defaultMaxPathComponentLength {
+				t.Errorf("expected components within the limit, %s has one of %d bytes", name, len(component))
+			}
+		}
+	}
+	client.mu.Unlock()
+
+	if contents, err := d.GetContent(ctx, longPath); err != nil || string(contents) != "link" {
+		t.Fatalf("expected the content to round trip, got %q, %v", contents, err)
+	}
+	if fi, err := d.Stat(ctx, longPath); err != nil || fi.Path() != longPath {
+		t.Fatalf("expected the original path from Stat, got %v, %v", fi, err)
+	}
+	listed, err := d.List(ctx, "/repositories")
+	if err != nil || !reflect.DeepEqual(listed, []string{"/repositories/" + longRepository}) {
+		t.Fatalf("expected List to report the original name, got %v, %v", listed, err)
+	}
+	listed, err = d.List(ctx, "/repositories/"+longRepository)
+	if err != nil || !reflect.DeepEqual(listed, []string{"/repositories/" + longRepository + "/_layers"}) {
+		t.Fatalf("expected the children of the shortened directory, got %v, %v", listed, err)
+	}
+
+	moved := "/repositories/" + strings.Repeat("b", 600) + "/_layers/link"
+	if err := d.Move(ctx, longPath, moved); err != nil {
+		t.Fatalf("unexpected error from Move: %v", err)
+	}
+	if contents, err := d.GetContent(ctx, moved); err != nil || string(contents) != "link" {
+		t.Fatalf("expected the moved content, got %q, %v", contents, err)
+	}
+
+	// Only the namenode's limit on whole paths is left
+	if err := d.PutContent(ctx, "/repositories/"+strings.Repeat("c", 8000), []byte("x")); err == nil {
+		t.Fatal("expected a path over 8000 characters to be refused")
+	} else if _, ok := enclosedPathTooLong(err); !ok {
+		t.Fatalf("expected a PathTooLongError, got %v", err)
+	}
+}
+
+func TestLongPathTransformWithDigestPrefix(t *testing.T) {
+	next, err := newPathTransform("digestprefix", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	transform, err := newLongPathTransform(next, "shorten", 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	subPath := "/repositories/" + longRepository + "/_layers/sha256/" + testDigestHex + "/link"
+	mapped := transform.transform(subPath)
+	if again := transform.transform(mapped); again != mapped {
+		t.Fatalf("expected transforming twice to be a no-op, got %s from %s", again, mapped)
+	}
+	if reversed := transform.reverse(mapped); reversed != subPath {
+		t.Fatalf("expected %s back, got %s", subPath, reversed)
+	}
+	for _, component := range strings.Split(mapped, "/") {
+		if len(component) > 100 {
+			t.Fatalf("expected components within the limit, got %s", mapped)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package hdfs
+
+import (
+	"expvar"
+	"io"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// transferMetrics counts the bytes each operation reads from and writes to
+// HDFS, which tells a slow large transfer from a slow small one. Bytes are
+// counted as they are transferred, so compressed files count their stored
+// size, GetContent served from the content cache counts nothing and a
+// failed transfer counts what it moved. The errors of each operation are
+// counted as well, and so are the reconnects to the namenode, failovers
+// included, and the failovers alone.
+// Like the blob descriptor cache metrics, the counts are kept globally and
+// made available via expvar as registry.storage.hdfs.
+type transferMetrics struct {
+	bytesRead    *expvar.Map
+	bytesWritten *expvar.Map
+	errors       *expvar.Map
+	reconnects   *expvar.Int
+	failovers    *expvar.Int
+}
+
+var transfers = newTransferMetrics()
+
+func newTransferMetrics() *transferMetrics {
+	registry := expvar.Get("registry")
+	if registry == nil {
+		registry = expvar.NewMap("registry")
+	}
+
+	storage := registry.(*expvar.Map).Get("storage")
+	if storage == nil {
+		storage = &expvar.Map{}
+		storage.(*expvar.Map).Init()
+		registry.(*expvar.Map).Set("storage", storage)
+	}
+
+	m := &transferMetrics{bytesRead: new(expvar.Map).Init(), bytesWritten: new(expvar.Map).Init(), errors: new(expvar.Map).Init(), reconnects: new(expvar.Int), failovers: new(expvar.Int)}
+	hdfs := new(expvar.Map).Init()
+	hdfs.Set("bytesread", m.bytesRead)
+	hdfs.Set("byteswritten", m.bytesWritten)
+	hdfs.Set("errors", m.errors)
+	hdfs.Set("reconnects", m.reconnects)
+	hdfs.Set("failovers", m.failovers)
+	storage.(*expvar.Map).Set("hdfs", hdfs)
+	return m
+}
+
+// read counts n bytes read by op
+func (m *transferMetrics) read(op string, n int) {
+	if n > 0 {
+		m.bytesRead.Add(op, int64(n))
+	}
+}
+
+// wrote counts n bytes written by op
+func (m *transferMetrics) wrote(op string, n int) {
+	if n > 0 {
+		m.bytesWritten.Add(op, int64(n))
+	}
+}
+
+// reconnected counts a reconnect to the namenode
+func (m *transferMetrics) reconnected(failover bool) {
+	m.reconnects.Add(1)
+	if failover {
+		m.failovers.Add(1)
+	}
+}
+
+// failed counts *err as an error of op unless it is nil or a missing path,
+// which callers ask for rather than the cluster failing. It is deferred by
+// the driver methods:
+//
+//	defer transfers.failed("Stat", &err)
+func (m *transferMetrics) failed(op string, err *error) {
+	if *err == nil {
+		return
+	}
+	if _, ok := (*err).(storagedriver.PathNotFoundError); !ok {
+		m.errors.Add(op, 1)
+	}
+}
+
+// countingReader counts what is read from it as read by op
+type countingReader struct {
+	io.Reader
+	op string
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	transfers.read(r.op, n)
+	return n, err
+}
+
+// countReadCloser counts what is read from rc as read by op, keeping its
+// Close
+func countReadCloser(op string, rc io.ReadCloser) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{&countingReader{Reader: rc, op: op}, rc}
+}
+
+// countingWriter counts what is written to it as written by op
+type countingWriter struct {
+	io.Writer
+	op string
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	transfers.wrote(w.op, n)
+	return n, err
+}
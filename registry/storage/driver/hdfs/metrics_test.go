@@ -0,0 +1,83 @@
+package hdfs
+
+import (
+	"bytes"
+	"expvar"
+	"io/ioutil"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+// transferred returns the bytes counted for op in the published counters
+// named counter
+func transferred(counter, op string) int64 {
+	hdfs := expvar.Get("registry").(*expvar.Map).Get("storage").(*expvar.Map).Get("hdfs").(*expvar.Map)
+	if count, ok := hdfs.Get(counter).(*expvar.Map).Get(op).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
+
+func TestTransferMetricsCountBytes(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/small", []byte("small"))
+	client.writeFile("/registry/stream", []byte("streamed"))
+	d := newTestDriver(client)
+	ctx := context.Background()
+
+	counts := func() [5]int64 {
+		return [5]int64{
+			transferred("bytesread", "GetContent"),
+			transferred("bytesread", "Reader"),
+			transferred("byteswritten", "PutContent"),
+			transferred("byteswritten", "Writer"),
+			transferred("byteswritten", "PutReader"),
+		}
+	}
+	before := counts()
+
+	if _, err := d.GetContent(ctx, "/small"); err != nil {
+		t.Fatalf("unexpected error from GetContent: %v", err)
+	}
+	reader, err := d.Reader(ctx, "/stream", 2)
+	if err != nil {
+		t.Fatalf("unexpected error from Reader: %v", err)
+	}
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	reader.Close()
+	if err := d.PutContent(ctx, "/put", make([]byte, 1234)); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	writer, err := d.Writer(ctx, "/written", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	if _, err := writer.Write(make([]byte, 4321)); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	writer.Commit()
+	writer.Close()
+	if err := wrap(d).PutReader(ctx, "/putreader", bytes.NewReader(make([]byte, 99)), 99); err != nil {
+		t.Fatalf("unexpected error from PutReader: %v", err)
+	}
+
+	after := counts()
+	for i, want := range []int64{5, 6, 1234, 4321, 99} {
+		if got := after[i] - before[i]; got != want {
+			t.Errorf("counter %d: expected %d bytes, got %d", i, want, got)
+		}
+	}
+
+	// Staged PutContent counts the same
+	d = newTestDriverWithParameters(client, DriverParameters{StagingStrategy: stagingTempFile})
+	before = counts()
+	if err := d.PutContent(ctx, "/staged", make([]byte, 77)); err != nil {
+		t.Fatalf("unexpected error from staged PutContent: %v", err)
+	}
+	if got := counts()[2] - before[2]; got != 77 {
+		t.Fatalf("expected 77 bytes written by staged PutContent, got %d", got)
+	}
+}
@@ -0,0 +1,193 @@
+package hdfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// MigrateOptions configures Migrate
+type MigrateOptions struct {
+	// Parallelism is the number of objects copied concurrently, 1 if unset
+	Parallelism int
+
+	// Progress, when set, is called after each object with the totals so
+	// far. Calls are serialized.
+	Progress func(MigrateProgress)
+}
+
+// MigrateProgress describes how far a migration has got
+type MigrateProgress struct {
+	// Path is the object that was just copied or skipped
+	Path string
+
+	// Copied and Skipped count the objects copied and those already
+	// present in the destination
+	Copied  int
+	Skipped int
+
+	// Bytes is the number of bytes copied
+	Bytes int64
+}
+
+// Migrate copies every object below root in src into dst, typically an HDFS
+// driver, keeping the same paths. Objects already present and identical in
+// dst are skipped, so an interrupted migration is resumed by running it
+// again. It returns the final totals and the first error encountered.
+func Migrate(ctx context.Context, src, dst storagedriver.StorageDriver, root string, options MigrateOptions) (MigrateProgress, error) {
+	parallelism := options.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var (
+		mu       sync.Mutex
+		progress MigrateProgress
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	record := func(path string, copied bool, size int64, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		progress.Path = path
+		if copied {
+			progress.Copied++
+			progress.Bytes += size
+		} else {
+			progress.Skipped++
+		}
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+	}
+
+	objects := make(chan storagedriver.FileInfo)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fi := range objects {
+				if failed() {
+					continue
+				}
+				copied, err := migrateObject(ctx, src, dst, fi)
+				record(fi.Path(), copied, fi.Size(), err)
+			}
+		}()
+	}
+
+	walkErr := migrateWalk(ctx, src, root, func(fi storagedriver.FileInfo) error {
+		if failed() {
+			return errMigrationFailed
+		}
+		objects <- fi
+		return nil
+	})
+	close(objects)
+	wg.Wait()
+
+	if firstErr != nil {
+		return progress, firstErr
+	}
+	return progress, walkErr
+}
+
+// errMigrationFailed stops the walk once a copy has failed; the copy error
+// is the one reported.
+var errMigrationFailed = errors.New("migration stopped after a failed copy")
+
+// migrateWalk calls f for every file below from in driver
+func migrateWalk(ctx context.Context, driver storagedriver.StorageDriver, from string, f func(storagedriver.FileInfo) error) error {
+	list := func(dir string) ([]string, error) {
+		return driver.List(ctx, dir)
+	}
+	return walkTree(from, list, func(child string) (bool, error) {
+		fi, err := driver.Stat(ctx, child)
+		if err != nil {
+			return false, err
+		}
+		if fi.IsDir() {
+			return true, nil
+		}
+		return false, f(storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+			Path:    child,
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		}})
+	})
+}
+
+// migrateObject copies a single object unless dst already holds an
+// identical copy, and reports whether it copied.
+func migrateObject(ctx context.Context, src, dst storagedriver.StorageDriver, fi storagedriver.FileInfo) (bool, error) {
+	identical, err := migratedAlready(ctx, src, dst, fi)
+	if err != nil || identical {
+		return false, err
+	}
+
+	reader, err := src.Reader(ctx, fi.Path(), 0)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	// Writing from the start replaces whatever an interrupted run left
+	writer, err := dst.Writer(ctx, fi.Path(), false)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Cancel()
+		writer.Close()
+		return false, err
+	}
+	if err := writer.Commit(); err != nil {
+		writer.Close()
+		return false, err
+	}
+	return true, writer.Close()
+}
+
+// migratedAlready reports whether dst holds the same object as src. Blobs
+// are content addressed, so a blob of the right size is complete; other
+// objects such as links and tags are small and compared byte for byte.
+func migratedAlready(ctx context.Context, src, dst storagedriver.StorageDriver, fi storagedriver.FileInfo) (bool, error) {
+	existing, err := dst.Stat(ctx, fi.Path())
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if existing.IsDir() || existing.Size() != fi.Size() {
+		return false, nil
+	}
+	if strings.Contains(fi.Path(), "/blobs/") {
+		return true, nil
+	}
+
+	srcContents, err := src.GetContent(ctx, fi.Path())
+	if err != nil {
+		return false, err
+	}
+	dstContents, err := dst.GetContent(ctx, fi.Path())
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcContents, dstContents), nil
+}
@@ -0,0 +1,110 @@
+package hdfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	src := inmemory.New()
+	objects := map[string][]byte{
+		"/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data":                 bytes.Repeat([]byte("layer"), 1000),
+		"/docker/registry/v2/repositories/foo/_layers/sha256/" + testDigestHex + "/link": []byte("sha256:" + testDigestHex),
+		"/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link":       []byte("sha256:" + testDigestHex),
+		"/docker/registry/v2/repositories/bar/_manifests/tags/v1/current/link":           []byte("sha256:0000"),
+	}
+	for path, contents := range objects {
+		if err := src.PutContent(ctx, path, contents); err != nil {
+			t.Fatalf("unexpected error seeding %s: %v", path, err)
+		}
+	}
+
+	client := newFakeClient()
+	dst := newTestDriver(client)
+
+	// A copy left behind by an interrupted run must be replaced
+	partial := "/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data"
+	client.writeFile("/registry"+partial, []byte("lay"))
+
+	var reported []string
+	progress, err := Migrate(ctx, src, dst, "/", MigrateOptions{
+		Parallelism: 3,
+		Progress:    func(p MigrateProgress) { reported = append(reported, p.Path) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error from Migrate: %v", err)
+	}
+	if progress.Copied != len(objects) || progress.Skipped != 0 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+
+	var expected []string
+	for path, contents := range objects {
+		expected = append(expected, path)
+		stored, err := dst.GetContent(ctx, path)
+		if err != nil {
+			t.Fatalf("unexpected error reading migrated %s: %v", path, err)
+		}
+		if !bytes.Equal(stored, contents) {
+			t.Fatalf("%s was not migrated intact", path)
+		}
+	}
+	sort.Strings(expected)
+	sort.Strings(reported)
+	if len(reported) != len(expected) {
+		t.Fatalf("expected progress for %v, got %v", expected, reported)
+	}
+	for i := range expected {
+		if reported[i] != expected[i] {
+			t.Fatalf("expected progress for %v, got %v", expected, reported)
+		}
+	}
+
+	// Running it again finds everything in place
+	creates := client.callCount("Create")
+	progress, err = Migrate(ctx, src, dst, "/", MigrateOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error resuming Migrate: %v", err)
+	}
+	if progress.Copied != 0 || progress.Skipped != len(objects) {
+		t.Fatalf("expected every object to be skipped, got %+v", progress)
+	}
+	if client.callCount("Create") != creates {
+		t.Fatalf("expected no objects to be rewritten")
+	}
+}
+
+// failingSource fails every Reader call
+type failingSource struct {
+	storagedriver.StorageDriver
+}
+
+func (failingSource) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	return nil, errors.New("source unavailable")
+}
+
+func TestMigrateStopsOnError(t *testing.T) {
+	ctx := context.Background()
+	src := inmemory.New()
+	for _, path := range []string{"/a", "/b", "/c"} {
+		if err := src.PutContent(ctx, path, []byte(path)); err != nil {
+			t.Fatalf("unexpected error seeding %s: %v", path, err)
+		}
+	}
+
+	progress, err := Migrate(ctx, failingSource{src}, newTestDriver(newFakeClient()), "/", MigrateOptions{Parallelism: 2})
+	if err == nil || err.Error() != "source unavailable" {
+		t.Fatalf("expected the source error to be returned, got %v", err)
+	}
+	if progress.Copied != 0 {
+		t.Fatalf("expected nothing to be copied, got %+v", progress)
+	}
+}
@@ -0,0 +1,532 @@
+package hdfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/colinmarc/hdfs"
+)
+
+// With mirrornamenode set, everything the driver changes on the cluster is
+// replicated to a second cluster for disaster recovery, under the same
+// hdfsrootdirectory: files written, renames, deletes, directories and
+// permission and time changes. Reads only go to the primary cluster, and so
+// do settings such as replication and storage policies, which the second
+// cluster may want to choose for itself.
+//
+// With mirrormode sync, files are written to both clusters as they are
+// written and every other change is made on the second cluster before the
+// operation returns. A failure on the second cluster is logged and, with
+// mirrorfailure fail, fails the operation as well; with mirrorfailure
+// ignore the file or change is left out of the mirror instead.
+//
+// With mirrormode async, the default, the primary cluster alone is written
+// and the changes are replayed on the second cluster in the background, in
+// order, files being copied from the primary cluster once closed; of a file
+// appended to only the appended bytes are copied. Failures
+// are logged and never fail the primary operation. Up to 1024 changes wait
+// to be replayed; more are dropped with a log line while the second cluster
+// falls behind. A file moved or deleted before its copy ran is copied from
+// where it was moved to, or skipped.
+//
+// Failures are counted as errors of the Mirror operation in
+// registry.storage.hdfs. The second cluster is dialed on the first change,
+// so an unavailable one does not keep the registry from starting.
+
+// mirrorQueueSize bounds the changes waiting to be replayed with mirrormode
+// async
+const mirrorQueueSize = 1024
+
+// validateMirror checks the mirrormode and mirrorfailure parameters
+func validateMirror(mode, failure string) error {
+	switch mode {
+	case "", "sync", "async":
+	default:
+		return fmt.Errorf("The mirrormode parameter should be sync or async, %q invalid", mode)
+	}
+	switch failure {
+	case "", "ignore":
+	case "fail":
+		if mode != "sync" {
+			return fmt.Errorf("The mirrorfailure parameter can only be fail with mirrormode sync")
+		}
+	default:
+		return fmt.Errorf("The mirrorfailure parameter should be ignore or fail, %q invalid", failure)
+	}
+	return nil
+}
+
+// mirrorChange replays a change on the second cluster
+type mirrorChange struct {
+	description string
+	apply       func(secondary hdfsClient) error
+}
+
+// mirrorClient makes every change of primary on secondary as well
+type mirrorClient struct {
+	hdfsClient
+	secondary hdfsClient
+	async     bool
+	fail      bool
+
+	queue   chan mirrorChange
+	pending sync.WaitGroup
+
+	// mu keeps mirror from queueing changes once Close closed the queue
+	mu     sync.RWMutex
+	closed bool
+}
+
+// newMirrorClient returns the client mirroring primary to secondary for
+// the mirrormode and mirrorfailure parameters
+func newMirrorClient(primary, secondary hdfsClient, mode, failure string) *mirrorClient {
+	c := &mirrorClient{
+		hdfsClient: primary,
+		secondary:  secondary,
+		async:      mode != "sync",
+		fail:       failure == "fail",
+	}
+	if c.async {
+		c.queue = make(chan mirrorChange, mirrorQueueSize)
+		go c.replay()
+	}
+	return c
+}
+
+// errMirrorClosed is logged for changes made after Close
+var errMirrorClosed = errors.New("the driver is closed")
+
+// Close replays the changes that wait and closes the connections to both
+// clusters
+func (c *mirrorClient) Close() error {
+	c.mu.Lock()
+	if c.async && !c.closed {
+		close(c.queue)
+	}
+	c.closed = true
+	c.mu.Unlock()
+	c.wait()
+
+	err := closeClient(c.secondary)
+	if primaryErr := closeClient(c.hdfsClient); primaryErr != nil {
+		err = primaryErr
+	}
+	return err
+}
+
+// replay applies the queued changes in order
+func (c *mirrorClient) replay() {
+	for change := range c.queue {
+		if err := change.apply(c.secondary); err != nil {
+			c.failed(change.description, err)
+		}
+		c.pending.Done()
+	}
+}
+
+// wait returns once the queued changes have been replayed
+func (c *mirrorClient) wait() {
+	c.pending.Wait()
+}
+
+func (c *mirrorClient) failed(description string, err error) {
+	log.Printf("hdfs: mirroring %s: %v", description, err)
+	transfers.failed("Mirror", &err)
+}
+
+// mirror makes a change that succeeded on the primary cluster on the
+// second one, returning the error that should fail the operation
+func (c *mirrorClient) mirror(description string, apply func(secondary hdfsClient) error) error {
+	if c.async {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		if c.closed {
+			c.failed(description, errMirrorClosed)
+			return nil
+		}
+		c.pending.Add(1)
+		select {
+		case c.queue <- mirrorChange{description: description, apply: apply}:
+		default:
+			c.pending.Done()
+			c.failed(description, fmt.Errorf("%d changes are waiting already, dropped", mirrorQueueSize))
+		}
+		return nil
+	}
+	err := apply(c.secondary)
+	if err == nil {
+		return nil
+	}
+	c.failed(description, err)
+	if c.fail {
+		return fmt.Errorf("mirroring %s: %v", description, err)
+	}
+	return nil
+}
+
+// copyFile copies the file at name from the primary cluster to secondary,
+// replacing what is there. A file that is gone has nothing to copy and is
+// removed from secondary, so that the replay of its move copies it from
+// where it went instead of moving a stale copy.
+func (c *mirrorClient) copyFile(secondary hdfsClient, name string) error {
+	reader, err := c.hdfsClient.Open(name)
+	if os.IsNotExist(err) {
+		if err := secondary.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := c.createMirrored(secondary, name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// copyWritten copies the size bytes written at offset of the file at name
+// on the primary cluster to secondary, creating the file there when offset
+// is 0 and appending to it otherwise. Later appends may have grown the file
+// since, so only those bytes are copied. A copy that is not offset bytes
+// long missed earlier changes and is replaced by a copy of the whole file.
+func (c *mirrorClient) copyWritten(secondary hdfsClient, name string, offset, size int64) error {
+	if offset != 0 {
+		if fi, err := secondary.Stat(name); err != nil || offset < 0 || fi.Size() != offset {
+			return c.copyFile(secondary, name)
+		}
+	}
+	reader, err := c.hdfsClient.Open(name)
+	if err != nil {
+		return c.copyFile(secondary, name)
+	}
+	defer reader.Close()
+	if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	var writer hdfsFileWriter
+	if offset == 0 {
+		writer, err = c.createMirrored(secondary, name)
+	} else {
+		writer, err = secondary.Append(name)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(writer, reader, size); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// createMirrored creates name on secondary, replacing any file there. The
+// parent directories get the permissions of the primary one.
+func (c *mirrorClient) createMirrored(secondary hdfsClient, name string) (hdfsFileWriter, error) {
+	if err := secondary.Remove(name); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	perm := os.FileMode(0755)
+	if fi, err := c.hdfsClient.Stat(path.Dir(name)); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	if err := secondary.MkdirAll(path.Dir(name), perm); err != nil {
+		return nil, err
+	}
+	return secondary.Create(name)
+}
+
+// writer returns the writer for primary, a new file at name or name
+// appended to: with mirrormode sync it writes secondary too, with async the
+// file, or what was appended to it, is copied once closed
+func (c *mirrorClient) writer(name string, primary hdfsFileWriter, appending bool) (hdfsFileWriter, error) {
+	w := &mirrorWriter{hdfsFileWriter: primary, client: c, name: name}
+	if appending {
+		w.offset = -1
+		if fi, err := c.hdfsClient.Stat(name); err == nil {
+			w.offset = fi.Size()
+		}
+	}
+	if c.async {
+		return w, nil
+	}
+
+	var err error
+	if appending {
+		// Appending needs the same content on both sides first, which only
+		// takes a copy when the second cluster missed earlier changes
+		if fi, serr := c.secondary.Stat(name); serr != nil || w.offset < 0 || fi.Size() != w.offset {
+			err = c.copyFile(c.secondary, name)
+		}
+		if err == nil {
+			w.secondary, err = c.secondary.Append(name)
+		}
+	} else {
+		w.secondary, err = c.createMirrored(c.secondary, name)
+	}
+	if err != nil {
+		if merr := c.mirror("the creation of "+name, func(hdfsClient) error { return err }); merr != nil {
+			primary.Close()
+			// What was there before the append is not ours to remove
+			if !appending {
+				c.hdfsClient.Remove(name)
+			}
+			return nil, merr
+		}
+	}
+	return w, nil
+}
+
+// mirrorWriter writes a file on the primary cluster and mirrors it
+type mirrorWriter struct {
+	hdfsFileWriter
+	client    *mirrorClient
+	name      string
+	secondary hdfsFileWriter
+
+	// offset is the size of the file when opened, -1 when unknown, and
+	// written the bytes written since
+	offset  int64
+	written int64
+}
+
+// secondaryFailed deals with a failure writing the second cluster: the
+// mirror of the file is abandoned, and the error returned with
+// mirrorfailure fail
+func (w *mirrorWriter) secondaryFailed(err error) error {
+	w.secondary.Close()
+	w.secondary = nil
+	return w.client.mirror(w.name, func(hdfsClient) error { return err })
+}
+
+func (w *mirrorWriter) Write(p []byte) (int, error) {
+	n, err := w.hdfsFileWriter.Write(p)
+	w.written += int64(n)
+	if w.secondary != nil && n > 0 {
+		if _, serr := w.secondary.Write(p[:n]); serr != nil {
+			if merr := w.secondaryFailed(serr); merr != nil && err == nil {
+				err = merr
+			}
+		}
+	}
+	return n, err
+}
+
+func (w *mirrorWriter) Flush() error {
+	err := w.hdfsFileWriter.Flush()
+	if err == nil && w.secondary != nil {
+		if serr := w.secondary.Flush(); serr != nil {
+			err = w.secondaryFailed(serr)
+		}
+	}
+	return err
+}
+
+func (w *mirrorWriter) Close() error {
+	err := w.hdfsFileWriter.Close()
+	if err != nil {
+		if w.secondary != nil {
+			w.secondary.Close()
+			w.secondary = nil
+		}
+		return err
+	}
+	if w.client.async {
+		name, offset, written := w.name, w.offset, w.written
+		return w.client.mirror(name, func(secondary hdfsClient) error {
+			return w.client.copyWritten(secondary, name, offset, written)
+		})
+	}
+	if w.secondary != nil {
+		secondary := w.secondary
+		w.secondary = nil
+		if serr := secondary.Close(); serr != nil {
+			return w.client.mirror(w.name, func(hdfsClient) error { return serr })
+		}
+	}
+	return nil
+}
+
+func (c *mirrorClient) Create(name string) (hdfsFileWriter, error) {
+	writer, err := c.hdfsClient.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.writer(name, writer, false)
+}
+
+func (c *mirrorClient) CreateFile(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	writer, err := c.hdfsClient.CreateFile(name, replication, blockSize, perm)
+	if err != nil {
+		return nil, err
+	}
+	return c.writer(name, writer, false)
+}
+
+func (c *mirrorClient) CreateWithParents(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	writer, err := createWithParents(c.hdfsClient, name, replication, blockSize, perm)
+	if err != nil {
+		return nil, err
+	}
+	return c.writer(name, writer, false)
+}
+
+func (c *mirrorClient) CreateOverwriting(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	writer, err := createOverwriting(c.hdfsClient, name, replication, blockSize, perm)
+	if err != nil {
+		return nil, err
+	}
+	return c.writer(name, writer, false)
+}
+
+func (c *mirrorClient) CreateWithFavoredNodes(name string, replication int, blockSize int64, perm os.FileMode, favoredNodes []string) (hdfsFileWriter, error) {
+	writer, err := createWithFavoredNodes(c.hdfsClient, name, replication, blockSize, perm, favoredNodes)
+	if err != nil {
+		return nil, err
+	}
+	return c.writer(name, writer, false)
+}
+
+func (c *mirrorClient) Append(name string) (hdfsFileWriter, error) {
+	writer, err := c.hdfsClient.Append(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.writer(name, writer, true)
+}
+
+func (c *mirrorClient) Rename(oldpath, newpath string) error {
+	if err := c.hdfsClient.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	return c.mirror("the move of "+oldpath+" to "+newpath, func(secondary hdfsClient) error {
+		// The source may never have made it, copy the result instead
+		err := secondary.Rename(oldpath, newpath)
+		if os.IsNotExist(err) {
+			return c.copyFile(secondary, newpath)
+		} else if os.IsExist(err) {
+			if err = secondary.Remove(newpath); err == nil {
+				err = secondary.Rename(oldpath, newpath)
+			}
+		}
+		return err
+	})
+}
+
+func (c *mirrorClient) Remove(name string) error {
+	if err := c.hdfsClient.Remove(name); err != nil {
+		return err
+	}
+	return c.mirror("the delete of "+name, func(secondary hdfsClient) error {
+		if err := secondary.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (c *mirrorClient) MkdirAll(dirname string, perm os.FileMode) error {
+	if err := c.hdfsClient.MkdirAll(dirname, perm); err != nil {
+		return err
+	}
+	return c.mirror("the creation of "+dirname, func(secondary hdfsClient) error {
+		return secondary.MkdirAll(dirname, perm)
+	})
+}
+
+func (c *mirrorClient) Chmod(name string, perm os.FileMode) error {
+	if err := c.hdfsClient.Chmod(name, perm); err != nil {
+		return err
+	}
+	return c.mirror("the permissions of "+name, func(secondary hdfsClient) error {
+		return secondary.Chmod(name, perm)
+	})
+}
+
+func (c *mirrorClient) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := c.hdfsClient.Chtimes(name, atime, mtime); err != nil {
+		return err
+	}
+	return c.mirror("the times of "+name, func(secondary hdfsClient) error {
+		return secondary.Chtimes(name, atime, mtime)
+	})
+}
+
+func (c *mirrorClient) Truncate(name string, size int64) (bool, error) {
+	done, err := truncate(c.hdfsClient, name, size)
+	if err != nil {
+		return done, err
+	}
+	return done, c.mirror("the truncation of "+name, func(secondary hdfsClient) error {
+		if _, err := truncate(secondary, name, size); err != errUnsupportedByClient {
+			return err
+		}
+		return c.copyFile(secondary, name)
+	})
+}
+
+func (c *mirrorClient) SetXAttr(name, attr string, value []byte) error {
+	if err := setXAttr(c.hdfsClient, name, attr, value); err != nil {
+		return err
+	}
+	return c.mirror("the attribute "+attr+" of "+name, func(secondary hdfsClient) error {
+		return setXAttr(secondary, name, attr, value)
+	})
+}
+
+func (c *mirrorClient) XAttrs(name string) (map[string][]byte, error) {
+	return getXAttrs(c.hdfsClient, name)
+}
+
+func (c *mirrorClient) SetReplication(name string, replication int) error {
+	return setReplication(c.hdfsClient, name, replication)
+}
+
+func (c *mirrorClient) SetStoragePolicy(name string, policy string) error {
+	return setStoragePolicy(c.hdfsClient, name, policy)
+}
+
+func (c *mirrorClient) ReadDirBatches(dirname string, n int, f func([]os.FileInfo) error) error {
+	return readDirBatches(c.hdfsClient, dirname, n, f)
+}
+
+func (c *mirrorClient) StatFs() (hdfs.FsInfo, error) {
+	return statFs(c.hdfsClient)
+}
+
+func (c *mirrorClient) EncryptionInfo(name string) (*fileEncryptionInfo, error) {
+	return encryptionInfo(c.hdfsClient, name)
+}
+
+func (c *mirrorClient) FileChecksum(name string) (*fileChecksum, error) {
+	return getFileChecksum(c.hdfsClient, name)
+}
+
+func (c *mirrorClient) ContentSize(name string) (int64, error) {
+	return contentSize(c.hdfsClient, name)
+}
+
+func (c *mirrorClient) ContentCount(name string) (int64, error) {
+	return contentCount(c.hdfsClient, name)
+}
+
+func (c *mirrorClient) RenewLease() error {
+	return renewLease(c.hdfsClient)
+}
+
+func (c *mirrorClient) RecoverLease(name string) (bool, error) {
+	return recoverLease(c.hdfsClient, name)
+}
@@ -0,0 +1,195 @@
+package hdfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+// exercise makes the changes every mirror test checks for
+func exercise(t *testing.T, d *driver) {
+	ctx := context.Background()
+	if err := d.PutContent(ctx, "/repos/a/link", []byte("sha256:a")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	writer, err := d.Writer(ctx, "/uploads/1/data", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("blob content"))
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("unexpected error from Commit: %v", err)
+	}
+	writer.Close()
+	if err := d.Move(ctx, "/uploads/1/data", "/blobs/b/data"); err != nil {
+		t.Fatalf("unexpected error from Move: %v", err)
+	}
+	if err := d.PutContent(ctx, "/repos/old/link", []byte("old")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if err := d.Delete(ctx, "/repos/old"); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+}
+
+// checkMirrored fails unless both clients hold the files exercise leaves
+func checkMirrored(t *testing.T, clients ...*fakeClient) {
+	for i, c := range clients {
+		for name, want := range map[string]string{"/registry/repos/a/link": "sha256:a", "/registry/blobs/b/data": "blob content"} {
+			if contents, err := c.ReadFile(name); err != nil || string(contents) != want {
+				t.Errorf("cluster %d: expected %q at %s, got %q, %v", i, want, name, contents, err)
+			}
+		}
+		for _, name := range []string{"/registry/uploads/1/data", "/registry/repos/old"} {
+			if _, err := c.Stat(name); err == nil {
+				t.Errorf("cluster %d: expected %s to be gone", i, name)
+			}
+		}
+	}
+}
+
+func TestMirrorSync(t *testing.T) {
+	primary, secondary := newFakeClient(), newFakeClient()
+	d := newTestDriver(newMirrorClient(primary, secondary, "sync", "ignore"))
+
+	exercise(t, d)
+	checkMirrored(t, primary, secondary)
+}
+
+func TestMirrorAsync(t *testing.T) {
+	primary, secondary := newFakeClient(), newFakeClient()
+	mirror := newMirrorClient(primary, secondary, "async", "ignore")
+	d := newTestDriver(mirror)
+
+	exercise(t, d)
+	mirror.wait()
+	checkMirrored(t, primary, secondary)
+}
+
+func TestMirrorAsyncFailureKeepsPrimary(t *testing.T) {
+	primary, secondary := newFakeClient(), newFakeClient()
+	mirror := newMirrorClient(primary, secondary, "async", "ignore")
+	d := newTestDriver(mirror)
+	secondary.failWith("Create", errors.New("no space left on device"))
+	secondary.failWith("Rename", errors.New("no space left on device"))
+
+	exercise(t, d)
+	mirror.wait()
+	checkMirrored(t, primary)
+	if _, err := secondary.Stat("/registry/blobs/b/data"); err == nil {
+		t.Fatal("expected the failed copy to be missing from the second cluster")
+	}
+}
+
+func TestMirrorSyncFailurePolicy(t *testing.T) {
+	secondaryErr := errors.New("no space left on device")
+
+	// Ignored failures leave the file out of the mirror
+	primary, secondary := newFakeClient(), newFakeClient()
+	d := newTestDriver(newMirrorClient(primary, secondary, "sync", "ignore"))
+	secondary.failWith("Create", secondaryErr)
+	if err := d.PutContent(context.Background(), "/repos/a/link", []byte("a")); err != nil {
+		t.Fatalf("expected the failure to be ignored, got %v", err)
+	}
+	if _, err := primary.ReadFile("/registry/repos/a/link"); err != nil {
+		t.Fatalf("expected the primary cluster to be written: %v", err)
+	}
+
+	// Otherwise they fail the write
+	primary, secondary = newFakeClient(), newFakeClient()
+	d = newTestDriver(newMirrorClient(primary, secondary, "sync", "fail"))
+	secondary.failWith("Create", secondaryErr)
+	if err := d.PutContent(context.Background(), "/repos/a/link", []byte("a")); err == nil {
+		t.Fatal("expected the failure on the second cluster to fail PutContent")
+	}
+}
+
+// appendChunks writes a file through mirror in three chunks, the way
+// uploads are written
+func appendChunks(t *testing.T, mirror *mirrorClient, name string) {
+	for i, chunk := range []string{"one ", "two ", "three"} {
+		open := mirror.Append
+		if i == 0 {
+			open = mirror.Create
+		}
+		w, err := open(name)
+		if err != nil {
+			t.Fatalf("unexpected error opening chunk %d: %v", i, err)
+		}
+		w.Write([]byte(chunk))
+		if err := w.Close(); err != nil {
+			t.Fatalf("unexpected error closing chunk %d: %v", i, err)
+		}
+	}
+}
+
+func TestMirrorCopiesOnlyAppendedBytes(t *testing.T) {
+	for _, mode := range []string{"sync", "async"} {
+		primary, secondary := newFakeClient(), newFakeClient()
+		mirror := newMirrorClient(primary, secondary, mode, "ignore")
+		appendChunks(t, mirror, "/upload")
+		mirror.wait()
+
+		if contents, err := secondary.ReadFile("/upload"); err != nil || string(contents) != "one two three" {
+			t.Fatalf("%s: unexpected mirror %q, %v", mode, contents, err)
+		}
+		if n := secondary.callCount("Create"); n != 1 {
+			t.Fatalf("%s: expected the appends not to copy the file again, got %d creates", mode, n)
+		}
+	}
+
+	// A second cluster that fell behind gets the whole file
+	primary, secondary := newFakeClient(), newFakeClient()
+	mirror := newMirrorClient(primary, secondary, "async", "ignore")
+	secondary.failWith("Append", errors.New("no space left on device"))
+	appendChunks(t, mirror, "/upload")
+	mirror.wait()
+	secondary.hook("Append", nil)
+	w, _ := mirror.Append("/upload")
+	w.Write([]byte("!"))
+	w.Close()
+	mirror.wait()
+	if contents, err := secondary.ReadFile("/upload"); err != nil || string(contents) != "one two three!" {
+		t.Fatalf("expected the whole file to be copied, got %q, %v", contents, err)
+	}
+}
+
+func TestMirrorSyncAppendFailureKeepsPrimary(t *testing.T) {
+	primary, secondary := newFakeClient(), newFakeClient()
+	mirror := newMirrorClient(primary, secondary, "sync", "fail")
+	w, _ := mirror.Create("/upload")
+	w.Write([]byte("chunk"))
+	w.Close()
+
+	secondary.failWith("Append", errors.New("no space left on device"))
+	if _, err := mirror.Append("/upload"); err == nil {
+		t.Fatal("expected the failure on the second cluster to fail the append")
+	}
+	if contents, err := primary.ReadFile("/upload"); err != nil || string(contents) != "chunk" {
+		t.Fatalf("expected the upload to be kept, got %q, %v", contents, err)
+	}
+}
+
+func TestMirrorClose(t *testing.T) {
+	primary, secondary := &closingClient{fakeClient: newFakeClient()}, &closingClient{fakeClient: newFakeClient()}
+	mirror := newMirrorClient(primary, secondary, "async", "ignore")
+	d := newTestDriver(mirror)
+	d.closer.client = mirror
+
+	// Close replays what waits before closing both clusters
+	exercise(t, d)
+	if err := wrap(d).Close(); err != nil {
+		t.Fatalf("unexpected error from Close: %v", err)
+	}
+	checkMirrored(t, primary.fakeClient, secondary.fakeClient)
+	wrap(d).Close()
+	if primary.closed != 1 || secondary.closed != 1 {
+		t.Fatalf("expected both clusters to be closed once, got %d and %d", primary.closed, secondary.closed)
+	}
+
+	// Changes after Close are not queued
+	if err := mirror.mirror("a late change", func(hdfsClient) error { return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
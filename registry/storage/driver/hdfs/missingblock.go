@@ -0,0 +1,59 @@
+package hdfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DataUnavailableError is returned by Reader and GetContent when a file
+// exists but none of the replicas of one of its blocks can be read, such
+// as while the datanodes holding them are down. Unlike PathNotFoundError it
+// does not mean the content is gone, so garbage collection and repair tools
+// should leave the path alone and retry later.
+type DataUnavailableError struct {
+	Path string
+	Err  error
+}
+
+func (e DataUnavailableError) Error() string {
+	return fmt.Sprintf("hdfs: the data of %s is unavailable, no replica of one of its blocks could be read: %v", e.Path, e.Err)
+}
+
+// isMissingBlock reports whether err is a read that found no replica of a
+// block: the "no available datanodes" of colinmarc/hdfs, or a
+// BlockMissingException relayed by WebHDFS
+func isMissingBlock(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "no available datanodes") ||
+		strings.Contains(message, "BlockMissingException") ||
+		strings.Contains(message, "Could not obtain block")
+}
+
+// dataUnavailable returns err for reading path, as a DataUnavailableError if
+// it is a missing block
+func dataUnavailable(path string, err error) error {
+	if isMissingBlock(err) {
+		return DataUnavailableError{Path: path, Err: err}
+	}
+	return err
+}
+
+// unavailableReader returns missing blocks read from path as
+// DataUnavailableErrors
+type unavailableReader struct {
+	io.ReadCloser
+	path string
+}
+
+func (r *unavailableReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	return n, dataUnavailable(r.path, err)
+}
@@ -0,0 +1,52 @@
+package hdfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestMissingBlockIsDataUnavailable(t *testing.T) {
+	contents := bytes.Repeat([]byte("0123456789"), 1000)
+	fake := newFakeClient()
+	fake.writeFile("/registry/blob", contents)
+	client := &badReplicaClient{fakeClient: fake, failAt: 4096, failures: 1 << 20}
+	d := newTestDriver(client)
+
+	_, err := d.GetContent(context.Background(), "/blob")
+	if e, ok := err.(DataUnavailableError); !ok || e.Path != "/blob" {
+		t.Fatalf("expected DataUnavailableError from GetContent, got %v", err)
+	}
+	if isPathNotFound(err) {
+		t.Fatal("expected a missing block not to be reported as a missing path")
+	}
+
+	reader, err := d.Reader(context.Background(), "/blob", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+	if _, err := ioutil.ReadAll(reader); err == nil {
+		t.Fatal("expected the read to fail")
+	} else if _, ok := err.(DataUnavailableError); !ok {
+		t.Fatalf("expected DataUnavailableError from Reader, got %v", err)
+	}
+}
+
+func TestIsMissingBlock(t *testing.T) {
+	for message, want := range map[string]bool{
+		"no available datanodes for block":                                                 true,
+		"org.apache.hadoop.hdfs.BlockMissingException: Could not obtain block: BP-1:blk_1": true,
+		"connection reset by peer":                                                         false,
+	} {
+		if got := isMissingBlock(errorString(message)); got != want {
+			t.Errorf("isMissingBlock(%q) = %v, want %v", message, got, want)
+		}
+	}
+}
+
+type errorString string
+
+func (e errorString) Error() string { return string(e) }
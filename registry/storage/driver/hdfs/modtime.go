@@ -0,0 +1,49 @@
+package hdfs
+
+import (
+	"os"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// ModTimeSetter is implemented by drivers that can set the modification
+// time of a file, e.g. to restore the age of data copied by maintenance
+// tools for age based garbage collection
+type ModTimeSetter interface {
+	SetModTime(ctx context.Context, path string, modTime time.Time) error
+}
+
+// SetModTime implements ModTimeSetter. HDFS can only set the access time
+// along with it, which becomes the current time.
+func (d *Driver) SetModTime(ctx context.Context, path string, modTime time.Time) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.SetModTime(%q, %v)", d.Name(), path, modTime)
+
+	inner := d.inner()
+	path, err := inner.checkPath(path, false)
+	if err != nil {
+		return err
+	}
+	if err := inner.checkClient(); err != nil {
+		return err
+	}
+	err = inner.hdfsClient.Chtimes(inner.fullPath(path), time.Now(), modTime)
+	if os.IsNotExist(err) {
+		return storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+	return err
+}
+
+// keepModTime makes Commit restore the modification time an appended or
+// rewritten file had before, which closing it resets, when preservemtime
+// is set
+func (d *driver) keepModTime(w *fileWriter, fullPath string, modTime time.Time) *fileWriter {
+	if d.preserveModTime {
+		w.restoreModTime = func() error {
+			return d.hdfsClient.Chtimes(fullPath, time.Now(), modTime)
+		}
+	}
+	return w
+}
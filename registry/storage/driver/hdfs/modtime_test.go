@@ -0,0 +1,61 @@
+package hdfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestPreserveModTimeAcrossRewrite(t *testing.T) {
+	ctx := context.Background()
+	old := time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+
+	for _, preserve := range []bool{true, false} {
+		client := newFakeClient()
+		client.writeFile("/registry/uploads/data", []byte("partial"))
+		if err := client.Chtimes("/registry/uploads/data", old, old); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		client.failWith("Append", errAppendDisabled)
+		d := newTestDriverWithParameters(client, DriverParameters{AppendFallback: true, PreserveModTime: preserve})
+
+		writer, err := d.Writer(ctx, "/uploads/data", true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := writer.Write([]byte(" more")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := writer.Commit(); err != nil {
+			t.Fatalf("unexpected error from Commit: %v", err)
+		}
+		writer.Close()
+
+		fi, err := d.Stat(ctx, "/uploads/data")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if kept := fi.ModTime().Equal(old); kept != preserve {
+			t.Fatalf("preservemtime %v: expected the modification time to be kept %v, got %v", preserve, preserve, fi.ModTime())
+		}
+	}
+}
+
+func TestSetModTime(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blob", []byte("blob"))
+	d := wrap(newTestDriver(client))
+	ctx := context.Background()
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := d.SetModTime(ctx, "/blob", modTime); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi, err := d.Stat(ctx, "/blob"); err != nil || !fi.ModTime().Equal(modTime) {
+		t.Fatalf("expected the modification time to be set, got %v, %v", fi, err)
+	}
+	if err := d.SetModTime(ctx, "/missing", modTime); !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package hdfs
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultNamenodePort is the namenode RPC port tried for hdfsnamenode
+// entries without one when namenodeports is not set
+const defaultNamenodePort = "8020"
+
+// parseNamenodePorts validates the namenodeports parameter, a comma
+// separated list of the ports to try in order
+func parseNamenodePorts(value string) ([]string, error) {
+	ports := splitList(value)
+	for _, port := range ports {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("The namenodeports parameter must list ports between 1 and 65535, %q invalid", port)
+		}
+	}
+	return ports, nil
+}
+
+// resolveNamenodes fills in the port of every namenode given without one
+// with the first of ports that accepts a connection. While a rolling upgrade
+// moves the namenodes to a new RPC port, listing both ports lets the driver
+// reach every node. Addresses with a port are returned as they are.
+func resolveNamenodes(namenodes []string, ports []string, dial func(network, address string) (net.Conn, error)) ([]string, error) {
+	if len(ports) == 0 {
+		ports = []string{defaultNamenodePort}
+	}
+
+	resolved := make([]string, 0, len(namenodes))
+	for _, namenode := range namenodes {
+		if _, _, err := net.SplitHostPort(namenode); err == nil {
+			resolved = append(resolved, namenode)
+			continue
+		}
+
+		host := strings.TrimSuffix(strings.TrimPrefix(namenode, "["), "]")
+		address, err := probePorts(host, ports, dial)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, address)
+	}
+	return resolved, nil
+}
+
+// probePorts returns the address of the first port of host that connects
+func probePorts(host string, ports []string, dial func(network, address string) (net.Conn, error)) (string, error) {
+	var errs []string
+	for _, port := range ports {
+		address := net.JoinHostPort(host, port)
+		conn, err := dial("tcp", address)
+		if err == nil {
+			conn.Close()
+			return address, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return "", fmt.Errorf("no namenode port of %s accepts connections, tried %v: %s", host, ports, strings.Join(errs, "; "))
+}
+
+// rotateNamenodes returns namenodes starting from the one n places after
+// the first, wrapping around
+func rotateNamenodes(namenodes []string, n int) []string {
+	if len(namenodes) == 0 {
+		return namenodes
+	}
+	n %= len(namenodes)
+	return append(append([]string(nil), namenodes[n:]...), namenodes[:n]...)
+}
@@ -0,0 +1,77 @@
+package hdfs
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+	return port
+}
+
+func TestResolveNamenodesTriesPortsInOrder(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	defer l.Close()
+	_, open, _ := net.SplitHostPort(l.Addr().String())
+	closed := closedPort(t)
+
+	var dialed []string
+	dial := func(network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		return net.Dial(network, address)
+	}
+
+	resolved, err := resolveNamenodes([]string{"127.0.0.1", "nn2.example.com:8020"}, []string{closed, open}, dial)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []string{"127.0.0.1:" + open, "nn2.example.com:8020"}; !reflect.DeepEqual(resolved, expected) {
+		t.Fatalf("expected %v, got %v", expected, resolved)
+	}
+	if expected := []string{"127.0.0.1:" + closed, "127.0.0.1:" + open}; !reflect.DeepEqual(dialed, expected) {
+		t.Fatalf("expected the ports to be tried in order, dialed %v", dialed)
+	}
+
+	if _, err := resolveNamenodes([]string{"127.0.0.1"}, []string{closed}, net.Dial); err == nil {
+		t.Fatalf("expected an error when no port connects")
+	}
+}
+
+func TestParseNamenodePorts(t *testing.T) {
+	if ports, err := parseNamenodePorts("8020, 9000"); err != nil || !reflect.DeepEqual(ports, []string{"8020", "9000"}) {
+		t.Fatalf("unexpected result %v, %v", ports, err)
+	}
+	for _, value := range []string{"8020,http", "0", "70000"} {
+		if _, err := parseNamenodePorts(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestRotateNamenodes(t *testing.T) {
+	namenodes := []string{"nn1:8020", "nn2:8020", "nn3:8020"}
+	for n, expected := range [][]string{
+		{"nn1:8020", "nn2:8020", "nn3:8020"},
+		{"nn2:8020", "nn3:8020", "nn1:8020"},
+		{"nn3:8020", "nn1:8020", "nn2:8020"},
+		{"nn1:8020", "nn2:8020", "nn3:8020"},
+	} {
+		if rotated := rotateNamenodes(namenodes, n); !reflect.DeepEqual(rotated, expected) {
+			t.Errorf("rotating by %d: expected %v, got %v", n, expected, rotated)
+		}
+	}
+	if namenodes[0] != "nn1:8020" {
+		t.Fatalf("expected the addresses to be left alone, got %v", namenodes)
+	}
+}
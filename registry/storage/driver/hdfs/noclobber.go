@@ -0,0 +1,40 @@
+package hdfs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// NoClobberError is returned when the noclobber parameter keeps an existing
+// blob from being overwritten
+type NoClobberError struct {
+	Path string
+}
+
+func (e NoClobberError) Error() string {
+	return fmt.Sprintf("hdfs: %s exists and noclobber forbids overwriting blobs", e.Path)
+}
+
+// isBlobData reports whether subPath holds the content of a blob, such as
+// /docker/registry/v2/blobs/sha256/ab/abcd.../data. Blobs are addressed by
+// their content, so a write to an existing one is never needed; everything
+// else, like tag links, is overwritten as a matter of course.
+func isBlobData(subPath string) bool {
+	return strings.Contains(subPath, "/blobs/") && strings.HasSuffix(subPath, "/data")
+}
+
+// checkNoClobber returns a NoClobberError when noclobber is set and subPath
+// is an existing blob. The creates that follow fail on a file that
+// appeared since, so this only has to catch the blobs that are there.
+func (d *driver) checkNoClobber(subPath, fullPath string) error {
+	if !d.noClobber || !isBlobData(subPath) {
+		return nil
+	}
+	if _, err := d.hdfsClient.Stat(fullPath); err == nil {
+		return NoClobberError{Path: subPath}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+const testBlobPath = "/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data"
+
+func TestNoClobber(t *testing.T) {
+	for _, strategy := range []string{stagingMemory, stagingTempFile} {
+		client := newFakeClient()
+		d := newTestDriverWithParameters(client, DriverParameters{NoClobber: true, StagingStrategy: strategy})
+		ctx := context.Background()
+
+		if err := d.PutContent(ctx, testBlobPath, []byte("blob")); err != nil {
+			t.Fatalf("%s: unexpected error from the first write: %v", strategy, err)
+		}
+		if err := d.PutContent(ctx, testBlobPath, []byte("other")); err == nil {
+			t.Fatalf("%s: expected the second write to be refused", strategy)
+		} else if _, ok := err.(NoClobberError); !ok {
+			t.Fatalf("%s: expected a NoClobberError, got %v", strategy, err)
+		}
+		if _, err := d.Writer(ctx, testBlobPath, false); err == nil {
+			t.Fatalf("%s: expected Writer to refuse the existing blob", strategy)
+		} else if _, ok := err.(NoClobberError); !ok {
+			t.Fatalf("%s: expected a NoClobberError, got %v", strategy, err)
+		}
+		if contents, _ := d.GetContent(ctx, testBlobPath); string(contents) != "blob" {
+			t.Fatalf("%s: the blob was overwritten with %q", strategy, contents)
+		}
+
+		// Uploads are committed by moving them onto the blob
+		if err := d.PutContent(ctx, "/docker/registry/v2/repositories/foo/_uploads/1/data", []byte("other")); err != nil {
+			t.Fatalf("%s: unexpected error: %v", strategy, err)
+		}
+		if err := d.Move(ctx, "/docker/registry/v2/repositories/foo/_uploads/1/data", testBlobPath); err == nil {
+			t.Fatalf("%s: expected the move onto the blob to be refused", strategy)
+		} else if _, ok := err.(NoClobberError); !ok {
+			t.Fatalf("%s: expected a NoClobberError, got %v", strategy, err)
+		}
+
+		// Everything else is overwritten as before
+		for i := 0; i < 2; i++ {
+			if err := d.PutContent(ctx, "/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link", []byte("sha256:"+testDigestHex)); err != nil {
+				t.Fatalf("%s: unexpected error overwriting a link: %v", strategy, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package hdfs
+
+import (
+	"fmt"
+	"os"
+)
+
+// DirectoryNotEmptyError is returned by a Delete with ClientOptions.Recursive
+// set to false when the path is a directory that still has entries
+type DirectoryNotEmptyError struct {
+	Path string
+}
+
+func (e DirectoryNotEmptyError) Error() string {
+	return fmt.Sprintf("hdfs: cannot delete %s: directory not empty", e.Path)
+}
+
+// checkEmpty returns a DirectoryNotEmptyError when subPath is a directory
+// with entries. colinmarc/hdfs only deletes recursively, so the directory is
+// listed first; an entry created between the listing and the Remove is
+// deleted along with it. Missing paths are left for Remove to report.
+func (d *driver) checkEmpty(subPath string) error {
+	fullPath := d.fullPath(subPath)
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if os.IsNotExist(err) || isUnresolvedLink(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return nil
+	}
+
+	children, err := d.readDir(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(children) > 0 {
+		return DirectoryNotEmptyError{Path: subPath}
+	}
+	return nil
+}
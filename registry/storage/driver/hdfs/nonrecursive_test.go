@@ -0,0 +1,57 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestDeleteRecursiveByDefault(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/dir/a", []byte("a"))
+	client.writeFile("/registry/dir/sub/b", []byte("b"))
+	d := newTestDriver(client)
+
+	recursive := true
+	ctx := WithClientOptions(context.Background(), ClientOptions{Recursive: &recursive})
+	if err := d.Delete(ctx, "/dir"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := d.Stat(context.Background(), "/dir/sub/b"); !isPathNotFound(err) {
+		t.Fatalf("expected the directory to be deleted with its entries, got %v", err)
+	}
+}
+
+func TestDeleteNonRecursive(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/dir/a", []byte("a"))
+	client.writeFile("/registry/file", []byte("f"))
+	d := newTestDriver(client)
+
+	recursive := false
+	ctx := WithClientOptions(context.Background(), ClientOptions{Recursive: &recursive})
+	err := d.Delete(ctx, "/dir")
+	if e, ok := err.(DirectoryNotEmptyError); !ok || e.Path != "/dir" {
+		t.Fatalf("expected DirectoryNotEmptyError for /dir, got %v", err)
+	}
+	if calls := client.callCount("Remove"); calls != 0 {
+		t.Fatalf("expected nothing to be removed, got %d Removes", calls)
+	}
+	if _, err := d.Stat(context.Background(), "/dir/a"); err != nil {
+		t.Fatalf("expected the entries to be kept: %v", err)
+	}
+
+	// Files and emptied directories are deleted as usual
+	if err := d.Delete(ctx, "/file"); err != nil {
+		t.Fatalf("unexpected error deleting a file: %v", err)
+	}
+	if err := d.Delete(ctx, "/dir/a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.Delete(ctx, "/dir"); err != nil {
+		t.Fatalf("unexpected error deleting an empty directory: %v", err)
+	}
+	if err := d.Delete(ctx, "/missing"); !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+}
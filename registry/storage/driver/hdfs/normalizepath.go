@@ -0,0 +1,146 @@
+package hdfs
+
+import (
+	"io"
+	"strings"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// base.Base rejects any path that is not of the form /a/b, so a caller that
+// builds "a//b" or "/a/b/" gets an InvalidPathError from one method and,
+// through a helper that cleans paths itself, a file from another. With
+// normalizepaths set every method of Driver normalizes its paths first:
+// repeated slashes are collapsed, the leading slash is added and the
+// trailing one removed, so /a/b, a//b and /a/b/ all name the same file
+// everywhere. Segments are still validated: "." and "..", which would
+// otherwise be resolved into another file, and names with characters
+// storagedriver.PathRegexp does not allow are rejected with an
+// InvalidPathError naming the original path.
+
+// normalizePath returns p with its slashes normalized, or an
+// InvalidPathError if one of its segments is invalid. The empty path and
+// "/" are the root.
+func normalizePath(p string) (string, error) {
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		switch segment {
+		case "":
+			continue
+		case ".", "..":
+			return "", storagedriver.InvalidPathError{Path: p, DriverName: driverName}
+		}
+		segments = append(segments, segment)
+	}
+	normalized := "/" + strings.Join(segments, "/")
+	if normalized != "/" && !storagedriver.PathRegexp.MatchString(normalized) {
+		return "", storagedriver.InvalidPathError{Path: p, DriverName: driverName}
+	}
+	return normalized, nil
+}
+
+// checkPath returns the path a method should operate on for p, normalized
+// with normalizepaths, or an InvalidPathError. root allows "/", which only
+// the methods working on trees accept.
+func (d *driver) checkPath(p string, root bool) (string, error) {
+	if d.normalizePaths {
+		normalized, err := normalizePath(p)
+		if err != nil {
+			return "", err
+		}
+		p = normalized
+	}
+	if root && (p == "" || p == "/") {
+		return "/", nil
+	}
+	if !storagedriver.PathRegexp.MatchString(p) {
+		return "", storagedriver.InvalidPathError{Path: p, DriverName: driverName}
+	}
+	return p, nil
+}
+
+// normalize returns p normalized with normalizepaths and as is otherwise,
+// leaving the validation to base.Base
+func (d *Driver) normalize(p string) (string, error) {
+	if !d.inner().normalizePaths {
+		return p, nil
+	}
+	return normalizePath(p)
+}
+
+// GetContent is base.Base's GetContent with the path normalized
+func (d *Driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	path, err := d.normalize(path)
+	if err != nil {
+		return nil, err
+	}
+	return d.baseEmbed.GetContent(ctx, path)
+}
+
+// PutContent is base.Base's PutContent with the path normalized
+func (d *Driver) PutContent(ctx context.Context, path string, content []byte) error {
+	path, err := d.normalize(path)
+	if err != nil {
+		return err
+	}
+	return d.baseEmbed.PutContent(ctx, path, content)
+}
+
+// Reader is base.Base's Reader with the path normalized
+func (d *Driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	path, err := d.normalize(path)
+	if err != nil {
+		return nil, err
+	}
+	return d.baseEmbed.Reader(ctx, path, offset)
+}
+
+// Writer is base.Base's Writer with the path normalized
+func (d *Driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	path, err := d.normalize(path)
+	if err != nil {
+		return nil, err
+	}
+	return d.baseEmbed.Writer(ctx, path, append)
+}
+
+// Stat is base.Base's Stat with the path normalized
+func (d *Driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	path, err := d.normalize(path)
+	if err != nil {
+		return nil, err
+	}
+	return d.baseEmbed.Stat(ctx, path)
+}
+
+// Move is base.Base's Move with both paths normalized
+func (d *Driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	sourcePath, err := d.normalize(sourcePath)
+	if err != nil {
+		return err
+	}
+	destPath, err = d.normalize(destPath)
+	if err != nil {
+		return err
+	}
+	return d.baseEmbed.Move(ctx, sourcePath, destPath)
+}
+
+// Delete is base.Base's Delete with the path normalized
+func (d *Driver) Delete(ctx context.Context, path string) error {
+	path, err := d.normalize(path)
+	if err != nil {
+		return err
+	}
+	return d.baseEmbed.Delete(ctx, path)
+}
+
+// URLFor is base.Base's URLFor with the path normalized
+func (d *Driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	path, err := d.normalize(path)
+	if err != nil {
+		return "", err
+	}
+	return d.baseEmbed.URLFor(ctx, path, options)
+}
@@ -0,0 +1,78 @@
+package hdfs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestNormalizePath(t *testing.T) {
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"/a/b", "/a/b"},
+		{"a//b", "/a/b"},
+		{"/a/b/", "/a/b"},
+		{"//a///b//", "/a/b"},
+		{"", "/"},
+		{"/", "/"},
+	} {
+		if got, err := normalizePath(tc.path); err != nil || got != tc.want {
+			t.Errorf("%q: expected %q, got %q, %v", tc.path, tc.want, got, err)
+		}
+	}
+	for _, path := range []string{"/a/../b", "/a/./b", "/a/b c", "/a/\x00"} {
+		if _, err := normalizePath(path); err == nil {
+			t.Errorf("%q: expected an invalid path", path)
+		} else if e, ok := err.(storagedriver.InvalidPathError); !ok || e.Path != path {
+			t.Errorf("%q: expected an InvalidPathError naming the path, got %v", path, err)
+		}
+	}
+}
+
+func TestNormalizePathsAcrossMethods(t *testing.T) {
+	ctx := context.Background()
+	d := wrap(newTestDriverWithParameters(newFakeClient(), DriverParameters{NormalizePaths: true}))
+
+	if err := d.PutContent(ctx, "a//b", []byte("content")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, path := range []string{"/a/b", "a//b", "/a/b/"} {
+		if content, err := d.GetContent(ctx, path); err != nil || string(content) != "content" {
+			t.Errorf("GetContent(%q): expected the content, got %q, %v", path, content, err)
+		}
+		if fi, err := d.Stat(ctx, path); err != nil || fi.Path() != "/a/b" {
+			t.Errorf("Stat(%q): expected /a/b, got %v, %v", path, fi, err)
+		}
+	}
+	for _, path := range []string{"/a", "a/", "//a//"} {
+		if entries, err := d.List(ctx, path); err != nil || !reflect.DeepEqual(entries, []string{"/a/b"}) {
+			t.Errorf("List(%q): expected [/a/b], got %v, %v", path, entries, err)
+		}
+	}
+
+	// Segments that would name another file are still rejected
+	if err := d.PutContent(ctx, "/a/../b", []byte("content")); err == nil {
+		t.Fatal("expected .. to be rejected")
+	} else if _, ok := err.(storagedriver.InvalidPathError); !ok {
+		t.Fatalf("expected an InvalidPathError, got %v", err)
+	}
+	if _, err := d.StatMany(ctx, []string{"/a/b/", "/a/./b"}); err == nil {
+		t.Fatal("expected StatMany to reject /a/./b")
+	}
+}
+
+func TestPathsNotNormalizedByDefault(t *testing.T) {
+	ctx := context.Background()
+	d := wrap(newTestDriver(newFakeClient()))
+
+	for _, path := range []string{"a//b", "a/b", "/a/b/"} {
+		if err := d.PutContent(ctx, path, []byte("content")); err == nil {
+			t.Errorf("PutContent(%q): expected an invalid path", path)
+		} else if _, ok := err.(storagedriver.InvalidPathError); !ok {
+			t.Errorf("PutContent(%q): expected an InvalidPathError, got %v", path, err)
+		}
+	}
+}
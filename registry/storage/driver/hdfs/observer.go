@@ -0,0 +1,171 @@
+package hdfs
+
+import (
+	"os"
+	"time"
+
+	"github.com/colinmarc/hdfs"
+)
+
+// observerClient sends the reads of blob data to an observer namenode,
+// which serves reads from a replica of the namespace and so takes them off
+// the active namenode, and everything else to the active namenode.
+//
+// Observer reads are only consistent when the client passes the state id
+// of its last write along, which colinmarc/hdfs does not. Blobs never
+// change once they are in place, so they are safe to read from a replica
+// that may lag; whatever the observer fails to find, such as a blob moved
+// into place a moment ago, is read from the active namenode instead.
+// Links, tags and listings always go to the active namenode.
+type observerClient struct {
+	active   hdfsClient
+	observer hdfsClient
+}
+
+func newObserverClient(active, observer hdfsClient) *observerClient {
+	return &observerClient{active: active, observer: observer}
+}
+
+// Close closes the connections to both namenodes
+func (c *observerClient) Close() error {
+	err := closeClient(c.observer)
+	if activeErr := closeClient(c.active); activeErr != nil {
+		err = activeErr
+	}
+	return err
+}
+
+// read runs op against the observer for blob data, and against the active
+// namenode otherwise or when the observer fails
+func (c *observerClient) read(name string, op func(client hdfsClient) error) error {
+	if isBlobData(name) {
+		if err := op(c.observer); err == nil {
+			return nil
+		}
+	}
+	return op(c.active)
+}
+
+func (c *observerClient) Open(name string) (reader hdfsFileReader, err error) {
+	err = c.read(name, func(client hdfsClient) error {
+		reader, err = client.Open(name)
+		return err
+	})
+	return reader, err
+}
+
+func (c *observerClient) Stat(name string) (fi os.FileInfo, err error) {
+	err = c.read(name, func(client hdfsClient) error {
+		fi, err = client.Stat(name)
+		return err
+	})
+	return fi, err
+}
+
+func (c *observerClient) ReadFile(filename string) (contents []byte, err error) {
+	err = c.read(filename, func(client hdfsClient) error {
+		contents, err = client.ReadFile(filename)
+		return err
+	})
+	return contents, err
+}
+
+func (c *observerClient) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return c.active.ReadDir(dirname)
+}
+
+func (c *observerClient) Create(name string) (hdfsFileWriter, error) {
+	return c.active.Create(name)
+}
+
+func (c *observerClient) CreateFile(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	return c.active.CreateFile(name, replication, blockSize, perm)
+}
+
+func (c *observerClient) Append(name string) (hdfsFileWriter, error) {
+	return c.active.Append(name)
+}
+
+func (c *observerClient) Rename(oldpath, newpath string) error {
+	return c.active.Rename(oldpath, newpath)
+}
+
+func (c *observerClient) Remove(name string) error {
+	return c.active.Remove(name)
+}
+
+func (c *observerClient) MkdirAll(dirname string, perm os.FileMode) error {
+	return c.active.MkdirAll(dirname, perm)
+}
+
+func (c *observerClient) Chmod(name string, perm os.FileMode) error {
+	return c.active.Chmod(name, perm)
+}
+
+func (c *observerClient) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return c.active.Chtimes(name, atime, mtime)
+}
+
+func (c *observerClient) SetReplication(name string, replication int) error {
+	return setReplication(c.active, name, replication)
+}
+
+func (c *observerClient) SetStoragePolicy(name string, policy string) error {
+	return setStoragePolicy(c.active, name, policy)
+}
+
+func (c *observerClient) ReadDirBatches(dirname string, n int, f func([]os.FileInfo) error) error {
+	return readDirBatches(c.active, dirname, n, f)
+}
+
+func (c *observerClient) StatFs() (hdfs.FsInfo, error) {
+	return statFs(c.active)
+}
+
+func (c *observerClient) EncryptionInfo(name string) (*fileEncryptionInfo, error) {
+	return encryptionInfo(c.active, name)
+}
+
+func (c *observerClient) CreateWithParents(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	return createWithParents(c.active, name, replication, blockSize, perm)
+}
+
+func (c *observerClient) FileChecksum(name string) (*fileChecksum, error) {
+	return getFileChecksum(c.active, name)
+}
+
+func (c *observerClient) CreateOverwriting(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	return createOverwriting(c.active, name, replication, blockSize, perm)
+}
+
+func (c *observerClient) CreateWithFavoredNodes(name string, replication int, blockSize int64, perm os.FileMode, favoredNodes []string) (hdfsFileWriter, error) {
+	return createWithFavoredNodes(c.active, name, replication, blockSize, perm, favoredNodes)
+}
+
+func (c *observerClient) Truncate(name string, size int64) (bool, error) {
+	return truncate(c.active, name, size)
+}
+
+func (c *observerClient) SetXAttr(name, attr string, value []byte) error {
+	return setXAttr(c.active, name, attr, value)
+}
+
+func (c *observerClient) XAttrs(name string) (map[string][]byte, error) {
+	return getXAttrs(c.active, name)
+}
+
+func (c *observerClient) ContentSize(name string) (int64, error) {
+	return contentSize(c.active, name)
+}
+
+func (c *observerClient) ContentCount(name string) (int64, error) {
+	return contentCount(c.active, name)
+}
+
+func (c *observerClient) RenewLease() error {
+	return renewLease(c.active)
+}
+
+func (c *observerClient) RecoverLease(name string) (bool, error) {
+	return recoverLease(c.active, name)
+}
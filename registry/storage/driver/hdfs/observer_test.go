@@ -0,0 +1,54 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestReadFromObserver(t *testing.T) {
+	blob := "/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data"
+	active, observer := newFakeClient(), newFakeClient()
+	for _, client := range []*fakeClient{active, observer} {
+		client.writeFile("/registry"+blob, []byte("layer"))
+	}
+	d := newTestDriver(newObserverClient(active, observer))
+	ctx := context.Background()
+
+	if content, err := d.GetContent(ctx, blob); err != nil || string(content) != "layer" {
+		t.Fatalf("unexpected result reading the blob: %q, %v", content, err)
+	}
+	if _, err := d.Stat(ctx, blob); err != nil {
+		t.Fatalf("unexpected error from Stat: %v", err)
+	}
+	if observer.callCount("Open") != 1 || observer.callCount("Stat") != 1 || active.callCount("Open") != 0 {
+		t.Fatalf("expected the blob to be read from the observer")
+	}
+
+	// Writes, and reads of anything that may change, go to the active
+	tag := "/docker/registry/v2/repositories/app/_manifests/tags/latest/current/link"
+	if err := d.PutContent(ctx, tag, []byte("sha256:"+testDigestHex)); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if _, err := active.Stat("/registry" + tag); err != nil {
+		t.Fatalf("expected the write to reach the active: %v", err)
+	}
+	if _, err := d.GetContent(ctx, tag); err != nil {
+		t.Fatalf("unexpected error reading the tag: %v", err)
+	}
+	for _, method := range []string{"Create", "CreateFile", "Rename", "ReadDir"} {
+		if calls := observer.callCount(method); calls != 0 {
+			t.Fatalf("expected no %s on the observer, got %d", method, calls)
+		}
+	}
+	if observer.callCount("Open") != 1 {
+		t.Fatalf("expected the tag to be read from the active")
+	}
+
+	// A blob the observer has not caught up with is read from the active
+	fresh := "/docker/registry/v2/blobs/sha256/cd/" + testDigestHex + "/data"
+	active.writeFile("/registry"+fresh, []byte("fresh"))
+	if content, err := d.GetContent(ctx, fresh); err != nil || string(content) != "fresh" {
+		t.Fatalf("expected a lagging observer to fall back to the active, got %q, %v", content, err)
+	}
+}
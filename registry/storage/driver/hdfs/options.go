@@ -0,0 +1,78 @@
+package hdfs
+
+import (
+	"github.com/docker/distribution/context"
+)
+
+// ClientOptions override driver parameters for the operations performed
+// with a context returned by WithClientOptions. Zero values keep the
+// driver's setting.
+type ClientOptions struct {
+	// Replication is the replication factor of the files created, in
+	// place of the replication parameter
+	Replication int
+
+	// ReadBandwidth and WriteBandwidth replace the readbandwidth and
+	// writebandwidth limits with limits of their own, in bytes per second.
+	// A negative value lifts the limit.
+	ReadBandwidth  int64
+	WriteBandwidth int64
+
+	// VerifyWrites, when set, overrides the verifywrites parameter
+	VerifyWrites *bool
+
+	// ListTemporaryFiles makes List report staging files and the entries
+	// listexclude hides, e.g. to clean up after crashed uploads
+	ListTemporaryFiles bool
+
+	// Recursive, when set to false, makes Delete refuse to delete
+	// directories that still have entries with a DirectoryNotEmptyError.
+	// Deletes are recursive by default.
+	Recursive *bool
+
+	// ForceDelete makes Delete remove directories that mindeletedepth or
+	// maxdeleteentries would keep
+	ForceDelete bool
+}
+
+type clientOptionsKey struct{}
+
+// WithClientOptions returns a context that makes the HDFS driver apply
+// options on top of its parameters, e.g. to throttle garbage collection
+// harder than pulls without a separate driver instance.
+func WithClientOptions(ctx context.Context, options ClientOptions) context.Context {
+	return context.WithValue(ctx, clientOptionsKey{}, options)
+}
+
+// withOptions returns the driver to perform an operation with ctx: d itself,
+// or a copy with the ClientOptions carried by ctx applied.
+func (d *driver) withOptions(ctx context.Context) *driver {
+	options, ok := ctx.Value(clientOptionsKey{}).(ClientOptions)
+	if !ok {
+		return d
+	}
+
+	o := *d
+	if options.Replication > 0 {
+		o.replication = options.Replication
+	}
+	if options.ReadBandwidth != 0 {
+		o.readLimiter = newBandwidthLimiter(options.ReadBandwidth)
+	}
+	if options.WriteBandwidth != 0 {
+		o.writeLimiter = newBandwidthLimiter(options.WriteBandwidth)
+	}
+	if options.VerifyWrites != nil {
+		o.verifyWrites = *options.VerifyWrites
+	}
+	if options.ListTemporaryFiles {
+		o.listTemporaryFiles = true
+	}
+	if options.Recursive != nil {
+		o.nonRecursiveDelete = !*options.Recursive
+	}
+	if options.ForceDelete {
+		o.forceDelete = true
+	}
+	return &o
+}
@@ -0,0 +1,50 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestClientOptionsOverridePerOperation(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{Replication: 3})
+	ctx := context.Background()
+
+	gc := WithClientOptions(ctx, ClientOptions{Replication: 1})
+	if err := d.PutContent(gc, "/overridden", []byte("a")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.PutContent(ctx, "/default", []byte("b")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if r := client.replication("/registry/overridden"); r != 1 {
+		t.Fatalf("expected the override to apply to its operation, got replication %d", r)
+	}
+	if r := client.replication("/registry/default"); r != 3 {
+		t.Fatalf("expected other operations to keep the driver default, got replication %d", r)
+	}
+	if d.replication != 3 {
+		t.Fatalf("expected the driver itself to be left alone, got replication %d", d.replication)
+	}
+}
+
+func TestClientOptionsBandwidth(t *testing.T) {
+	d := newTestDriverWithParameters(newFakeClient(), DriverParameters{ReadBandwidth: 1 << 20})
+	ctx := context.Background()
+
+	if o := d.withOptions(ctx); o != d {
+		t.Fatalf("expected a context without options to use the driver as is")
+	}
+	if o := d.withOptions(WithClientOptions(ctx, ClientOptions{ReadBandwidth: -1})); o.readLimiter != nil {
+		t.Fatalf("expected a negative bandwidth to lift the limit")
+	}
+	o := d.withOptions(WithClientOptions(ctx, ClientOptions{ReadBandwidth: 1 << 10, WriteBandwidth: 1 << 10}))
+	if o.readLimiter == d.readLimiter || int64(o.readLimiter.Limit()) != 1<<10 || int64(o.writeLimiter.Limit()) != 1<<10 {
+		t.Fatalf("expected limits of the operation's own, got read %v and write %v", o.readLimiter, o.writeLimiter)
+	}
+	if d.writeLimiter != nil {
+		t.Fatalf("expected the driver's write limit to be left alone")
+	}
+}
@@ -0,0 +1,81 @@
+package hdfs
+
+import "os"
+
+// overwriteCreator is implemented by clients that can set the OVERWRITE
+// flag of the create RPC, which has the namenode replace an existing file
+// in the same operation. A replication or block size of 0 is the cluster
+// default. colinmarc/hdfs cannot: it always creates with CREATE alone.
+type overwriteCreator interface {
+	CreateOverwriting(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error)
+}
+
+// createOverwriting creates name, replacing any file there, if c supports it
+func createOverwriting(c hdfsClient, name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	if o, ok := c.(overwriteCreator); ok {
+		return o.CreateOverwriting(name, replication, blockSize, perm)
+	}
+	return nil, errUnsupportedByClient
+}
+
+// overwrite creates the file at fullPath like create, replacing the file
+// that may be there in a single namenode operation, so that readers never
+// find the path missing and a concurrent writer cannot slip its own file
+// in between. colinmarc/hdfs cannot overwrite in the create RPC, so with it
+// the driver creates the file through WebHDFS CREATE with overwrite when
+// WebHDFS is configured, and falls back to createAndReplace otherwise. The
+// favored nodes RPC carries no overwrite flag either. exists tells whether
+// a file was there; otherwise the parent is created when the namenode
+// reports it missing.
+func (d *driver) overwrite(fullPath string, exists bool) (hdfsFileWriter, error) {
+	replication := d.replicationFor(fullPath)
+	var blockSize int64
+	if replication != 0 {
+		blockSize = defaultBlockSize
+	}
+	err := errUnsupportedByClient
+	var writer hdfsFileWriter
+	if len(d.favoredNodes) == 0 {
+		writer, err = createOverwriting(d.hdfsClient, fullPath, replication, blockSize, defaultFileMode)
+		if os.IsNotExist(err) && !exists {
+			d.makeParentDir(fullPath)
+			writer, err = createOverwriting(d.hdfsClient, fullPath, replication, blockSize, defaultFileMode)
+		}
+	}
+	if err == errUnsupportedByClient {
+		if d.webHdfs != nil {
+			return d.createThroughWebHdfs(fullPath, true, replication, blockSize)
+		}
+		// Without a file to replace a create does, unless one appeared
+		if !exists {
+			if writer, err := d.create(fullPath); !os.IsExist(err) {
+				return writer, err
+			}
+		}
+		return d.createAndReplace(fullPath)
+	} else if err != nil {
+		return nil, createError(fullPath, err)
+	}
+	d.applyStoragePolicy(fullPath)
+	return writer, nil
+}
+
+// createAndReplace creates a temporary file next to fullPath and renames it
+// over fullPath at once, which replaces the file there in one namenode
+// operation as well. The writer keeps writing to the renamed file, and when
+// writers overwrite concurrently the last rename wins; the writers whose
+// files were replaced fail when they close them, the namenode having
+// dropped their leases.
+func (d *driver) createAndReplace(fullPath string) (hdfsFileWriter, error) {
+	staged := d.stagingPath(fullPath)
+	writer, err := d.create(staged)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.hdfsClient.Rename(staged, fullPath); err != nil {
+		writer.Close()
+		d.hdfsClient.Remove(staged)
+		return nil, err
+	}
+	return writer, nil
+}
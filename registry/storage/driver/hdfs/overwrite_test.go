@@ -0,0 +1,202 @@
+package hdfs
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+// overwritingClient gives fakeClient an overwriting create, which replaces
+// the file at name in one step
+type overwritingClient struct {
+	*fakeClient
+}
+
+func (c overwritingClient) CreateOverwriting(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	if err := c.enter("CreateOverwriting", name); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	if f, ok := c.files[name]; ok {
+		if f.isDir {
+			return nil, pathError("create", name, os.ErrExist)
+		}
+		delete(c.files, name)
+	}
+	w, err := c.create(name)
+	if err != nil {
+		return nil, err
+	}
+	w.file.replication = replication
+	w.file.mode = perm
+	return w, nil
+}
+
+func TestWriterOverwritesConcurrently(t *testing.T) {
+	client := newFakeClient()
+	client.MkdirAll("/registry/repo", 0755)
+	testOverwritesConcurrently(t, client, newTestDriver(overwritingClient{client}))
+	if removes, creates := client.callCount("Remove"), client.callCount("CreateOverwriting"); removes != 0 || creates != 16 {
+		t.Fatalf("expected 16 overwriting creates and no removes, got %d and %d", creates, removes)
+	}
+}
+
+func TestWriterOverwritesConcurrentlyWithoutOverwritingCreate(t *testing.T) {
+	client := newFakeClient()
+	client.MkdirAll("/registry/repo", 0755)
+	testOverwritesConcurrently(t, client, newTestDriver(client))
+}
+
+// testOverwritesConcurrently has writers overwrite the same file at once
+// and checks that every one succeeds and the file holds what one wrote
+func testOverwritesConcurrently(t *testing.T, client *fakeClient, d *driver) {
+	ctx := context.Background()
+
+	const writers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			writer, err := d.Writer(ctx, "/repo/file", false)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := writer.Write([]byte(strings.Repeat(fmt.Sprintf("writer %02d;", i), 8))); err != nil {
+				errs <- err
+			} else if err := writer.Commit(); err != nil {
+				errs <- err
+			} else if err := writer.Close(); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("unexpected error from a concurrent writer: %v", err)
+	}
+
+	// The file holds what exactly one of the writers wrote
+	contents, err := d.GetContent(ctx, "/repo/file")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(contents) != 8*len("writer 00;") || strings.Repeat(string(contents[:10]), 8) != string(contents) {
+		t.Fatalf("expected the contents of a single writer, got %q", contents)
+	}
+}
+
+func TestWriterOverwriteFallback(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/file", []byte("old contents"))
+	d := newTestDriver(client)
+
+	// Readers find the old file until the new one replaces it
+	client.hook("Rename", func(name string) error {
+		if contents, err := client.ReadFile("/registry/file"); err != nil || string(contents) != "old contents" {
+			t.Errorf("expected the old file before the rename, got %q, %v", contents, err)
+		}
+		return nil
+	})
+	writer, err := d.Writer(context.Background(), "/file", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("new"))
+	writer.Commit()
+	writer.Close()
+
+	if removes, renames := client.callCount("Remove"), client.callCount("Rename"); removes != 0 || renames != 1 {
+		t.Fatalf("expected a new file renamed over the old one without an overwriting create, got %d removes and %d renames", removes, renames)
+	}
+	if contents, err := d.GetContent(context.Background(), "/file"); err != nil || string(contents) != "new" {
+		t.Fatalf("expected the file to be overwritten, got %q, %v", contents, err)
+	}
+	if files := countFiles(client); files != 1 {
+		t.Fatalf("expected no file left besides the overwritten one, got %d", files)
+	}
+}
+
+func TestWriterOverwriteFallbackReplacesACreatedFile(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(client)
+
+	// Another writer creates the file after the Writer found none
+	client.hook("Create", func(name string) error {
+		if name == "/registry/file" {
+			client.writeFile(name, []byte("concurrent contents"))
+		}
+		return nil
+	})
+
+	writer, err := d.Writer(context.Background(), "/file", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("new"))
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("unexpected error from Commit: %v", err)
+	}
+	writer.Close()
+
+	if contents, err := d.GetContent(context.Background(), "/file"); err != nil || string(contents) != "new" {
+		t.Fatalf("expected the last writer to win, got %q, %v", contents, err)
+	}
+}
+
+func TestWriterOverwriteFallbackKeepsDirectories(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/file", []byte("old contents"))
+	d := newTestDriver(client)
+
+	// A directory appears where the file was
+	client.hook("Rename", func(name string) error {
+		client.Remove("/registry/file")
+		client.MkdirAll("/registry/file", 0755)
+		return nil
+	})
+	if _, err := d.Writer(context.Background(), "/file", false); err == nil {
+		t.Fatal("expected Writer to fail on the directory")
+	}
+	if fi, err := client.Stat("/registry/file"); err != nil || !fi.IsDir() {
+		t.Fatalf("expected the directory to be kept, got %v, %v", fi, err)
+	}
+	if files := countFiles(client); files != 0 {
+		t.Fatalf("expected the new file to be removed, got %d files", files)
+	}
+}
+
+func TestWriterOverwritesThroughWebHdfs(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/file", []byte("old contents"))
+	server := httptest.NewServer(&fakeWebHdfs{namenode: client})
+	defer server.Close()
+	d := newTestDriverWithParameters(basicClient{client}, DriverParameters{HdfsUser: "registry", WebHdfsAddress: server.URL})
+
+	writer, err := d.Writer(context.Background(), "/file", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("new"))
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("unexpected error from Commit: %v", err)
+	}
+	writer.Close()
+
+	// The fake namenode replaces the file on behalf of WebHDFS
+	if calls := client.callCount("Rename"); calls != 0 {
+		t.Fatalf("expected the file to be replaced by WebHDFS CREATE, got %d renames", calls)
+	}
+	if contents, err := d.GetContent(context.Background(), "/file"); err != nil || string(contents) != "new" {
+		t.Fatalf("expected the file to be overwritten, got %q, %v", contents, err)
+	}
+}
@@ -0,0 +1,746 @@
+package hdfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// Every file costs the namenode an inode and a block in its heap, and a
+// registry with millions of repositories has millions of link files of a
+// few dozen bytes each. With packprefixes set, PutContent stores objects of
+// up to packmaxsize bytes below those prefixes in a single pack file per
+// prefix instead, the .hdfs-pack file in the directory of the prefix.
+//
+// A pack is a log: every write appends a record of the path, its size, the
+// CRC32 of its content and the content itself, and deletes append a record
+// marking the path deleted. The driver indexes the records in memory the
+// first time it needs the pack, which rebuilds the index after a restart,
+// and reads only the records appended since on later uses, so writes of
+// other registry instances show up too: within packMissRefreshInterval for
+// paths missing from the index, within packRefreshInterval for overwrites,
+// deletes and new entries of directories already indexed. Objects are also
+// indexed by directory, so that Stat and List do not go through the whole
+// index. A record cut short by a crash fails its CRC and is skipped.
+//
+// Appends wait for the lease on the pack file while another instance holds
+// it, without holding up lookups in the index. As a log, a pack grows with
+// every overwrite and delete, and so does the time to index it after a
+// restart. CompactPacks rewrites the packs with only the objects they still
+// hold, which operators run when packs have grown well past them, for
+// instance after garbage collection.
+//
+// Packed objects are read back by their paths with GetContent, Reader and
+// Stat, listed by List next to the files of their directories and removed
+// by Delete like them. Writer to a packed path stores a file as usual,
+// which replaces the packed object, and Move copies the object to its
+// destination before deleting it. Packs are written as is,
+// without compression, and ListInfo, ListSizes, ListStream and Walk leave
+// packed objects out.
+
+// packFileName is the name of the pack file in the directory of a prefix
+const packFileName = ".hdfs-pack"
+
+// defaultPackMaxSize is the largest object packed by default, enough for
+// links and the other metadata files of the registry
+const defaultPackMaxSize = 4096
+
+// maxPackMaxSize bounds packmaxsize, as packs are read into memory a
+// record at a time
+const maxPackMaxSize = 1 << 20
+
+// packRefreshInterval is how long a pack index answers lookups that find
+// what they look for without checking the pack file for new records
+const packRefreshInterval = time.Second
+
+// packMissRefreshInterval is the same for lookups that do not, which bounds
+// the namenode calls of reads and Stats of files that are not packed
+const packMissRefreshInterval = 100 * time.Millisecond
+
+// packHeadSize is how much of the start of a pack file is kept to tell it
+// from a pack written again, such as by CompactPacks
+const packHeadSize = 128
+
+// packMagic starts every record. The newline lets a record be found again
+// after one cut short, whose length cannot be trusted.
+var packMagic = []byte("\nHDFSPACK1 ")
+
+// packRecord is the header of a record, followed by Size bytes of content
+type packRecord struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	CRC     uint32    `json:"crc"`
+	ModTime time.Time `json:"modTime"`
+	Deleted bool      `json:"deleted,omitempty"`
+
+	// Compacted, on the record without a path that starts a compacted
+	// pack, is when it was compacted
+	Compacted time.Time `json:"compacted,omitempty"`
+}
+
+// packEntry is where the content of a packed object is in its pack
+type packEntry struct {
+	offset  int64
+	size    int64
+	modTime time.Time
+}
+
+// pack is the index of the pack file of prefix
+type pack struct {
+	prefix string
+
+	// writing serializes the appends of this instance, which wait for the
+	// lease on the pack file without holding mu
+	writing sync.Mutex
+
+	mu        sync.Mutex
+	scanned   int64
+	head      []byte
+	refreshed time.Time
+	index     map[string]packEntry
+	dirs      map[string]*packDir
+}
+
+// packDir is a directory that packed objects imply
+type packDir struct {
+	// children counts the packed objects at or below each child, by name
+	children map[string]int
+	modTime  time.Time
+}
+
+func newPack(prefix string) *pack {
+	p := &pack{prefix: prefix}
+	p.reset()
+	return p
+}
+
+// reset empties the index
+func (p *pack) reset() {
+	p.scanned, p.head, p.index, p.dirs = 0, nil, make(map[string]packEntry), make(map[string]*packDir)
+}
+
+// put indexes entry at subPath
+func (p *pack) put(subPath string, entry packEntry) {
+	delta := 1
+	if _, ok := p.index[subPath]; ok {
+		delta = 0
+	}
+	p.index[subPath] = entry
+	p.count(subPath, delta, entry.modTime)
+}
+
+// remove drops subPath from the index, deleted at modTime
+func (p *pack) remove(subPath string, modTime time.Time) {
+	if _, ok := p.index[subPath]; ok {
+		delete(p.index, subPath)
+		p.count(subPath, -1, modTime)
+	}
+}
+
+// count adds delta to the objects every ancestor of subPath holds below
+// it, which were modified at modTime
+func (p *pack) count(subPath string, delta int, modTime time.Time) {
+	for child, dir := subPath, path.Dir(subPath); ; child, dir = dir, path.Dir(dir) {
+		pd := p.dirs[dir]
+		if pd == nil {
+			pd = &packDir{children: make(map[string]int)}
+			p.dirs[dir] = pd
+		}
+		name := path.Base(child)
+		if pd.children[name] += delta; pd.children[name] <= 0 {
+			delete(pd.children, name)
+		}
+		if len(pd.children) == 0 {
+			delete(p.dirs, dir)
+		} else if modTime.After(pd.modTime) {
+			pd.modTime = modTime
+		}
+		if dir == "/" {
+			return
+		}
+	}
+}
+
+// below returns the packed paths below dir
+func (p *pack) below(dir string) []string {
+	var paths []string
+	stack := []string{dir}
+	for len(stack) > 0 {
+		dir := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if pd := p.dirs[dir]; pd != nil {
+			for name := range pd.children {
+				child := path.Join(dir, name)
+				if _, ok := p.index[child]; ok {
+					paths = append(paths, child)
+				}
+				stack = append(stack, child)
+			}
+		}
+	}
+	return paths
+}
+
+// packs are the packs of packprefixes, longest prefix first
+type packs struct {
+	maxSize int64
+	packs   []*pack
+}
+
+// newPacks parses the packprefixes parameter, returning nil without any
+func newPacks(prefixes string, maxSize int64) (*packs, error) {
+	ps := &packs{maxSize: maxSize}
+	for _, prefix := range splitList(prefixes) {
+		if !storagedriver.PathRegexp.MatchString(prefix) {
+			return nil, fmt.Errorf("The packprefixes parameter must list absolute paths such as /docker/registry/v2/repositories, %q invalid", prefix)
+		}
+		ps.packs = append(ps.packs, newPack(prefix))
+	}
+	if len(ps.packs) == 0 {
+		return nil, nil
+	}
+	if ps.maxSize <= 0 {
+		ps.maxSize = defaultPackMaxSize
+	}
+	sort.Sort(byPrefixLength(ps.packs))
+	return ps, nil
+}
+
+// byPrefixLength sorts packs by the length of their prefix, longest first
+type byPrefixLength []*pack
+
+func (p byPrefixLength) Len() int           { return len(p) }
+func (p byPrefixLength) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byPrefixLength) Less(i, j int) bool { return len(p[i].prefix) > len(p[j].prefix) }
+
+// isBelow reports whether subPath is below dir, or dir itself with orSelf
+func isBelow(subPath, dir string, orSelf bool) bool {
+	if subPath == dir {
+		return orSelf
+	}
+	return dir == "/" || strings.HasPrefix(subPath, dir+"/")
+}
+
+// packFor returns the pack subPath is stored in when packed
+func (ps *packs) packFor(subPath string) *pack {
+	if ps == nil {
+		return nil
+	}
+	for _, p := range ps.packs {
+		if isBelow(subPath, p.prefix, false) {
+			return p
+		}
+	}
+	return nil
+}
+
+// covering returns the packs that may hold objects below subPath, or
+// subPath itself
+func (ps *packs) covering(subPath string) []*pack {
+	if ps == nil {
+		return nil
+	}
+	var covering []*pack
+	for _, p := range ps.packs {
+		if isBelow(subPath, p.prefix, true) || isBelow(p.prefix, subPath, false) {
+			covering = append(covering, p)
+		}
+	}
+	return covering
+}
+
+// packPath returns where the pack file of p is stored
+func (d *driver) packPath(p *pack) string {
+	return path.Join(d.fullPath(p.prefix), packFileName)
+}
+
+// refreshPackFor refreshes p for a lookup, unless p was refreshed within
+// packRefreshInterval, or packMissRefreshInterval when found reports that
+// what the lookup is after is not indexed. It must be called with p.mu
+// held.
+func (d *driver) refreshPackFor(p *pack, found func() bool) error {
+	interval := packRefreshInterval
+	if !found() {
+		interval = packMissRefreshInterval
+	}
+	if time.Since(p.refreshed) < interval {
+		return nil
+	}
+	return d.refreshPack(p)
+}
+
+// refreshPack indexes the records appended to the pack file of p since it
+// was last read. It must be called with p.mu held.
+func (d *driver) refreshPack(p *pack) error {
+	packPath := d.packPath(p)
+	refreshed := time.Now()
+	fi, err := d.hdfsClient.Stat(packPath)
+	if os.IsNotExist(err) {
+		p.reset()
+		p.refreshed = refreshed
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// A pack that shrank was deleted and written again
+	if fi.Size() < p.scanned {
+		p.reset()
+	}
+	if fi.Size() == p.scanned {
+		p.refreshed = refreshed
+		return nil
+	}
+
+	reader, err := d.hdfsClient.Open(packPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	// A pack that starts differently was written again too
+	head := make([]byte, fi.Size())
+	if len(head) > packHeadSize {
+		head = head[:packHeadSize]
+	}
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return err
+	}
+	if n := len(p.head); p.scanned > 0 && (n > len(head) || !bytes.Equal(head[:n], p.head)) {
+		p.reset()
+	}
+	p.head = head
+	scanned, err := p.indexRecords(newPackScanner(reader, fi.Size()), p.scanned)
+	if err != nil {
+		return err
+	}
+	p.scanned, p.refreshed = scanned, refreshed
+	return nil
+}
+
+// packScannerBufferSize bounds the header of a record, which holds little
+// more than its path
+const packScannerBufferSize = 64 << 10
+
+// packScanner reads a pack file up to end through a buffer, keeping track
+// of the offset it got to
+type packScanner struct {
+	file   io.ReadSeeker
+	end    int64
+	reader *bufio.Reader
+	offset int64
+}
+
+func newPackScanner(file io.ReadSeeker, end int64) *packScanner {
+	return &packScanner{file: file, end: end, reader: bufio.NewReaderSize(nil, packScannerBufferSize)}
+}
+
+// seek continues reading at offset
+func (s *packScanner) seek(offset int64) error {
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	s.offset = offset
+	s.reader.Reset(io.LimitReader(s.file, s.end-offset))
+	return nil
+}
+
+func (s *packScanner) discard(n int) {
+	discarded, _ := s.reader.Discard(n)
+	s.offset += int64(discarded)
+}
+
+// indexRecords adds the records from offset on to the index, reading them
+// a record at a time. It returns the offset it got to: the end, or the
+// start of a last record that is still incomplete, which is read again
+// next time. Bytes that are not a valid record, such as one cut short by a
+// crash and followed by others, are skipped up to the next record.
+func (p *pack) indexRecords(s *packScanner, offset int64) (int64, error) {
+	if err := s.seek(offset); err != nil {
+		return offset, err
+	}
+	// skipped is where the bytes being skipped started, -1 when none are
+	skipped := int64(-1)
+	resync := func(start int64) error {
+		if skipped < 0 {
+			skipped = start
+		}
+		return s.seek(start + 1)
+	}
+	done := func(start int64, err error) (int64, error) {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return start, err
+		}
+		if skipped >= 0 {
+			return skipped, nil
+		}
+		return start, nil
+	}
+
+	for {
+		start := s.offset
+		head, err := s.reader.Peek(len(packMagic))
+		if err != nil {
+			// The end of the pack, possibly within the magic of a record
+			// being appended
+			return done(start, err)
+		}
+		if !bytes.Equal(head, packMagic) {
+			if skipped < 0 {
+				skipped = start
+			}
+			s.discard(1)
+			continue
+		}
+		s.discard(len(packMagic))
+
+		line, err := s.reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			if err := resync(start); err != nil {
+				return start, err
+			}
+			continue
+		} else if err != nil {
+			// No newline follows, so neither does another record
+			return done(start, err)
+		}
+		s.offset += int64(len(line))
+		var record packRecord
+		if err := json.Unmarshal(line[:len(line)-1], &record); err != nil || record.Size < 0 || record.Size > maxPackMaxSize {
+			if err := resync(start); err != nil {
+				return start, err
+			}
+			continue
+		}
+
+		contentStart := s.offset
+		content := make([]byte, record.Size)
+		n, err := io.ReadFull(s.reader, content)
+		s.offset += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && crc32.ChecksumIEEE(content) != record.CRC) {
+			// Incomplete or torn, then skipped if another record follows
+			if err := resync(start); err != nil {
+				return start, err
+			}
+			continue
+		} else if err != nil {
+			return start, err
+		}
+
+		if record.Path == "" {
+			// The start of a compacted pack
+		} else if record.Deleted {
+			p.remove(record.Path, record.ModTime)
+		} else {
+			p.put(record.Path, packEntry{offset: contentStart, size: record.Size, modTime: record.ModTime})
+		}
+		skipped = -1
+	}
+}
+
+// encodePackRecord returns the record storing content at subPath, or
+// marking it deleted when content is nil
+func encodePackRecord(subPath string, content []byte) ([]byte, error) {
+	return encodeRecord(packRecord{Path: subPath, ModTime: time.Now(), Deleted: content == nil}, content)
+}
+
+// encodeRecord returns the record of header and content, filling in the
+// size and CRC of content
+func encodeRecord(header packRecord, content []byte) ([]byte, error) {
+	header.Size, header.CRC = int64(len(content)), crc32.ChecksumIEEE(content)
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, 0, len(packMagic)+len(encoded)+1+len(content))
+	record = append(record, packMagic...)
+	record = append(record, encoded...)
+	record = append(record, '\n')
+	return append(record, content...), nil
+}
+
+// appendPack appends records to the pack file of p, creating it if needed,
+// and indexes them. It must be called with p.writing held and p.mu not,
+// which it takes to index the records once they are written.
+func (d *driver) appendPack(p *pack, records []byte) error {
+	packPath := d.packPath(p)
+	writer, err := d.openPack(packPath)
+	if os.IsNotExist(err) {
+		if err = d.makeParentDir(packPath); err == nil {
+			writer, err = d.hdfsClient.Create(packPath)
+		}
+		if os.IsExist(err) {
+			writer, err = d.openPack(packPath)
+		}
+	}
+	d.writes.record(err)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(records); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return d.refreshPack(p)
+}
+
+// openPack opens the pack file at packPath for appending. Other instances
+// appending at the same time hold the lease on the pack, so the append is
+// retried for as long as leaserecoverytimeout allows.
+func (d *driver) openPack(packPath string) (hdfsFileWriter, error) {
+	writer, err := d.hdfsClient.Append(packPath)
+	deadline := d.budget.limit(time.Now().Add(d.leaseRecoveryTimeout))
+	for err != nil && (isLeaseHeld(err) || os.IsExist(err)) && time.Now().Before(deadline) {
+		if !d.budget.sleep(d.leaseRecoveryInterval) {
+			break
+		}
+		writer, err = d.hdfsClient.Append(packPath)
+	}
+	return writer, err
+}
+
+// putPacked stores contents at subPath in the pack p
+func (d *driver) putPacked(p *pack, subPath string, contents []byte) error {
+	if contents == nil {
+		contents = []byte{}
+	}
+	record, err := encodePackRecord(subPath, contents)
+	if err != nil {
+		return err
+	}
+	p.writing.Lock()
+	defer p.writing.Unlock()
+	return d.appendPack(p, record)
+}
+
+// getPacked returns the content packed at subPath, and whether it is
+// packed at all
+func (d *driver) getPacked(subPath string) ([]byte, bool, error) {
+	p := d.packs.packFor(subPath)
+	if p == nil {
+		return nil, false, nil
+	}
+	p.mu.Lock()
+	if err := d.refreshPackFor(p, func() bool { _, ok := p.index[subPath]; return ok }); err != nil {
+		p.mu.Unlock()
+		return nil, false, err
+	}
+	entry, ok := p.index[subPath]
+	p.mu.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+
+	reader, err := d.hdfsClient.Open(d.packPath(p))
+	if err != nil {
+		return nil, false, err
+	}
+	defer reader.Close()
+	content := make([]byte, entry.size)
+	if _, err := reader.Seek(entry.offset, io.SeekStart); err != nil {
+		return nil, false, dataUnavailable(subPath, err)
+	}
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return nil, false, dataUnavailable(subPath, err)
+	}
+	return content, true, nil
+}
+
+// statPacked returns the FileInfo of subPath when it is packed, or a
+// directory holding packed objects only
+func (d *driver) statPacked(subPath string) (storagedriver.FileInfo, bool, error) {
+	var dirModTime time.Time
+	isDir := false
+	for _, p := range d.packs.covering(subPath) {
+		p.mu.Lock()
+		found := func() bool {
+			_, file := p.index[subPath]
+			_, dir := p.dirs[subPath]
+			return file || dir
+		}
+		if err := d.refreshPackFor(p, found); err != nil {
+			p.mu.Unlock()
+			return nil, false, err
+		}
+		if entry, ok := p.index[subPath]; ok && p == d.packs.packFor(subPath) {
+			p.mu.Unlock()
+			return packedFileInfo(subPath, entry.size, entry.modTime, false), true, nil
+		}
+		if pd, ok := p.dirs[subPath]; ok {
+			isDir = true
+			if pd.modTime.After(dirModTime) {
+				dirModTime = pd.modTime
+			}
+		}
+		p.mu.Unlock()
+	}
+	if !isDir {
+		return nil, false, nil
+	}
+	return packedFileInfo(subPath, 0, dirModTime, true), true, nil
+}
+
+// packedEntries returns the direct descendants of dir that packed objects
+// imply, files or directories, other than those in listed
+func (d *driver) packedEntries(dir string, listed []listEntry) ([]listEntry, error) {
+	seen := make(map[string]bool, len(listed))
+	for _, entry := range listed {
+		seen[entry.path] = true
+	}
+	var entries []listEntry
+	for _, p := range d.packs.covering(dir) {
+		p.mu.Lock()
+		if err := d.refreshPackFor(p, func() bool { _, ok := p.dirs[dir]; return ok }); err != nil {
+			p.mu.Unlock()
+			return nil, err
+		}
+		if pd, ok := p.dirs[dir]; ok {
+			for name := range pd.children {
+				child := path.Join(dir, name)
+				if seen[child] {
+					continue
+				}
+				seen[child] = true
+				info := packFileInfo{name: name}
+				if childDir, ok := p.dirs[child]; ok {
+					info.isDir, info.modTime = true, childDir.modTime
+				} else {
+					entry := p.index[child]
+					info.size, info.modTime = entry.size, entry.modTime
+				}
+				entries = append(entries, listEntry{path: child, info: info})
+			}
+		}
+		p.mu.Unlock()
+	}
+	return entries, nil
+}
+
+// deletePacked marks subPath and everything packed below it deleted,
+// reporting whether there was anything
+func (d *driver) deletePacked(subPath string) (bool, error) {
+	deleted := false
+	for _, p := range d.packs.covering(subPath) {
+		p.writing.Lock()
+		records, err := d.deleteRecords(p, subPath)
+		if err == nil && len(records) > 0 {
+			if err = d.appendPack(p, records); err == nil {
+				deleted = true
+			}
+		}
+		p.writing.Unlock()
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteRecords returns the records marking subPath and everything packed
+// below it in p deleted
+func (d *driver) deleteRecords(p *pack, subPath string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := d.refreshPack(p); err != nil {
+		return nil, err
+	}
+	paths := p.below(subPath)
+	if _, ok := p.index[subPath]; ok {
+		paths = append(paths, subPath)
+	}
+	var records []byte
+	for _, packedPath := range paths {
+		record, err := encodePackRecord(packedPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record...)
+	}
+	return records, nil
+}
+
+// unpack marks subPath deleted in its pack if it is packed there, before a
+// file replaces it
+func (d *driver) unpack(subPath string) error {
+	p := d.packs.packFor(subPath)
+	if p == nil {
+		return nil
+	}
+	p.writing.Lock()
+	defer p.writing.Unlock()
+	p.mu.Lock()
+	err := d.refreshPack(p)
+	_, packed := p.index[subPath]
+	p.mu.Unlock()
+	if err != nil || !packed {
+		return err
+	}
+	record, err := encodePackRecord(subPath, nil)
+	if err != nil {
+		return err
+	}
+	return d.appendPack(p, record)
+}
+
+// packFileInfo is the os.FileInfo of a packed object, or of a directory
+// implied by packed objects
+type packFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi packFileInfo) Name() string       { return fi.name }
+func (fi packFileInfo) Size() int64        { return fi.size }
+func (fi packFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi packFileInfo) IsDir() bool        { return fi.isDir }
+func (fi packFileInfo) Sys() interface{}   { return nil }
+
+func (fi packFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// packedFileInfo returns the FileInfo of a packed object at subPath
+func packedFileInfo(subPath string, size int64, modTime time.Time, isDir bool) storagedriver.FileInfo {
+	fi := packFileInfo{name: path.Base(subPath), size: size, modTime: modTime, isDir: isDir}
+	return newFileInfo(storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:    subPath,
+		Size:    size,
+		ModTime: modTime,
+		IsDir:   isDir,
+	}}, fi)
+}
+
+// movePacked moves the packed object at sourcePath, holding contents, to
+// destPath. A pack cannot rename, so the object is written to destPath,
+// packed or not, and then marked deleted.
+func (d *driver) movePacked(ctx context.Context, sourcePath, destPath string, contents []byte) error {
+	if err := d.storeContent(ctx, destPath, d.fullPath(destPath), contents); err != nil {
+		return err
+	}
+	if err := d.unpack(sourcePath); err != nil {
+		return err
+	}
+	d.audit.recordMove(d, ctx, sourcePath, destPath)
+	d.changes.moved(sourcePath, destPath)
+	return nil
+}
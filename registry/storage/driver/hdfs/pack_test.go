@@ -0,0 +1,418 @@
+package hdfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func newPackTestDriver(client hdfsClient) *driver {
+	return newTestDriverWithParameters(client, DriverParameters{PackPrefixes: "/repos", PackMaxSize: 16})
+}
+
+// expireRefreshes makes the next lookups of d check its pack files for new
+// records, as they would once packRefreshInterval has passed
+func expireRefreshes(d *driver) {
+	for _, p := range d.packs.packs {
+		p.mu.Lock()
+		p.refreshed = time.Now().Add(-packRefreshInterval)
+		p.mu.Unlock()
+	}
+}
+
+// countFiles returns how many regular files the fake namenode holds
+func countFiles(c *fakeClient) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files := 0
+	for _, f := range c.files {
+		if !f.isDir {
+			files++
+		}
+	}
+	return files
+}
+
+func TestPackedWritesAreReadableByPath(t *testing.T) {
+	client := newFakeClient()
+	d := newPackTestDriver(client)
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		link := fmt.Sprintf("/repos/r%d/_layers/link", i)
+		if err := d.PutContent(ctx, link, []byte(fmt.Sprintf("sha256:%d", i))); err != nil {
+			t.Fatalf("unexpected error from PutContent: %v", err)
+		}
+	}
+	// Objects over packmaxsize and outside the prefixes are files
+	if err := d.PutContent(ctx, "/repos/r0/_manifests/big", make([]byte, 17)); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if err := d.PutContent(ctx, "/blobs/link", []byte("x")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if files := countFiles(client); files != 3 {
+		t.Fatalf("expected the pack and two files, got %d files", files)
+	}
+
+	contents, err := d.GetContent(ctx, "/repos/r3/_layers/link")
+	if err != nil || string(contents) != "sha256:3" {
+		t.Fatalf("expected the packed content, got %q, %v", contents, err)
+	}
+	reader, err := d.Reader(ctx, "/repos/r3/_layers/link", 7)
+	if err != nil {
+		t.Fatalf("unexpected error from Reader: %v", err)
+	}
+	rest, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	if string(rest) != "3" {
+		t.Fatalf("expected the packed content from the offset, got %q", rest)
+	}
+
+	info, err := d.Stat(ctx, "/repos/r3/_layers/link")
+	if err != nil || info.IsDir() || info.Size() != 8 || info.Path() != "/repos/r3/_layers/link" {
+		t.Fatalf("unexpected packed FileInfo %+v, %v", info, err)
+	}
+	if info, err := d.Stat(ctx, "/repos/r3"); err != nil || !info.IsDir() {
+		t.Fatalf("expected a directory of packed objects, got %+v, %v", info, err)
+	}
+	if _, err := d.Stat(ctx, "/repos/r3/_layers/other"); err == nil {
+		t.Fatal("expected a missing packed path to be reported missing")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+
+	listed, err := d.List(ctx, "/repos/r0")
+	sort.Strings(listed)
+	if err != nil || !reflect.DeepEqual(listed, []string{"/repos/r0/_layers", "/repos/r0/_manifests"}) {
+		t.Fatalf("expected packed and stored entries, got %v, %v", listed, err)
+	}
+	if listed, err := d.List(ctx, "/repos"); err != nil || len(listed) != 10 {
+		t.Fatalf("expected the 10 repositories without the pack file, got %v, %v", listed, err)
+	}
+}
+
+func TestPackIndexSurvivesRestart(t *testing.T) {
+	client := newFakeClient()
+	d := newPackTestDriver(client)
+	ctx := context.Background()
+
+	for _, p := range []string{"/repos/a/link", "/repos/b/link", "/repos/c/link"} {
+		if err := d.PutContent(ctx, p, []byte(p)); err != nil {
+			t.Fatalf("unexpected error from PutContent: %v", err)
+		}
+	}
+	if err := d.PutContent(ctx, "/repos/a/link", []byte("again")); err != nil {
+		t.Fatalf("unexpected error overwriting: %v", err)
+	}
+	if err := d.Delete(ctx, "/repos/b"); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+
+	// A new instance rebuilds the index from the pack file
+	restarted := newPackTestDriver(client)
+	if contents, err := restarted.GetContent(ctx, "/repos/a/link"); err != nil || string(contents) != "again" {
+		t.Fatalf("expected the last write after a restart, got %q, %v", contents, err)
+	}
+	if contents, err := restarted.GetContent(ctx, "/repos/c/link"); err != nil || string(contents) != "/repos/c/link" {
+		t.Fatalf("expected the packed content after a restart, got %q, %v", contents, err)
+	}
+	if _, err := restarted.GetContent(ctx, "/repos/b/link"); err == nil {
+		t.Fatal("expected the deleted object to stay deleted after a restart")
+	}
+
+	// Writes of either instance are seen by the other
+	if err := restarted.PutContent(ctx, "/repos/d/link", []byte("d")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	expireRefreshes(d)
+	if contents, err := d.GetContent(ctx, "/repos/d/link"); err != nil || string(contents) != "d" {
+		t.Fatalf("expected the other instance's write, got %q, %v", contents, err)
+	}
+}
+
+func TestPackSkipsTornRecords(t *testing.T) {
+	client := newFakeClient()
+	d := newPackTestDriver(client)
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/repos/a/link", []byte("a")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	// A crash left a record cut short before the next append
+	torn, _ := encodePackRecord("/repos/torn/link", []byte("torn content"))
+	packPath := "/registry/repos/" + packFileName
+	client.mu.Lock()
+	client.files[packPath].data = append(client.files[packPath].data, torn[:len(torn)-4]...)
+	client.mu.Unlock()
+	if err := d.PutContent(ctx, "/repos/b/link", []byte("b")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+
+	restarted := newPackTestDriver(client)
+	for p, want := range map[string]string{"/repos/a/link": "a", "/repos/b/link": "b"} {
+		if contents, err := restarted.GetContent(ctx, p); err != nil || string(contents) != want {
+			t.Fatalf("expected %q at %s, got %q, %v", want, p, contents, err)
+		}
+	}
+	if _, err := restarted.GetContent(ctx, "/repos/torn/link"); err == nil {
+		t.Fatal("expected the torn record to be skipped")
+	}
+}
+
+func TestPackedObjectsMoveAndReplace(t *testing.T) {
+	client := newFakeClient()
+	d := newPackTestDriver(client)
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/repos/a/link", []byte("a")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if err := d.Move(ctx, "/repos/a/link", "/repos/b/link"); err != nil {
+		t.Fatalf("unexpected error from Move: %v", err)
+	}
+	if _, err := d.GetContent(ctx, "/repos/a/link"); err == nil {
+		t.Fatal("expected the source to be gone after a move")
+	}
+	if contents, err := d.GetContent(ctx, "/repos/b/link"); err != nil || string(contents) != "a" {
+		t.Fatalf("expected the moved content, got %q, %v", contents, err)
+	}
+
+	// Appending continues the packed object in a file
+	writer, err := d.Writer(ctx, "/repos/b/link", true)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("ppended"))
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("unexpected error from Commit: %v", err)
+	}
+	writer.Close()
+	if contents, err := d.GetContent(ctx, "/repos/b/link"); err != nil || string(contents) != "appended" {
+		t.Fatalf("expected the appended content, got %q, %v", contents, err)
+	}
+	if contents, err := client.ReadFile("/registry/repos/b/link"); err != nil || string(contents) != "appended" {
+		t.Fatalf("expected the object to be stored as a file, got %q, %v", contents, err)
+	}
+}
+
+func TestPackIndexStreamsLargePacks(t *testing.T) {
+	client := newFakeClient()
+	ctx := context.Background()
+
+	// A pack well over the scanner's buffer, with a torn record in the
+	// middle and one still being appended at the end
+	var data []byte
+	for i := 0; i < 5000; i++ {
+		record, _ := encodePackRecord(fmt.Sprintf("/repos/r%d/link", i), []byte(fmt.Sprintf("sha256:%d", i)))
+		if i == 2500 {
+			record = record[:len(record)-3]
+		}
+		data = append(data, record...)
+	}
+	last, _ := encodePackRecord("/repos/last/link", []byte("last"))
+	client.writeFile("/registry/repos/"+packFileName, append(data, last[:len(last)-2]...))
+	if len(data) <= 4*packScannerBufferSize {
+		t.Fatalf("expected the pack to exceed the buffer, got %d bytes", len(data))
+	}
+
+	d := newPackTestDriver(client)
+	for _, i := range []int{0, 2499, 2501, 4999} {
+		p := fmt.Sprintf("/repos/r%d/link", i)
+		if contents, err := d.GetContent(ctx, p); err != nil || string(contents) != fmt.Sprintf("sha256:%d", i) {
+			t.Fatalf("expected the packed content at %s, got %q, %v", p, contents, err)
+		}
+	}
+	if _, err := d.GetContent(ctx, "/repos/r2500/link"); err == nil {
+		t.Fatal("expected the torn record to be skipped")
+	}
+	if _, err := d.GetContent(ctx, "/repos/last/link"); err == nil {
+		t.Fatal("expected the incomplete record to be left out")
+	}
+
+	// The incomplete record is read again once it is complete
+	client.mu.Lock()
+	f := client.files["/registry/repos/"+packFileName]
+	f.data = append(f.data, last[len(last)-2:]...)
+	client.mu.Unlock()
+	expireRefreshes(d)
+	if contents, err := d.GetContent(ctx, "/repos/last/link"); err != nil || string(contents) != "last" {
+		t.Fatalf("expected the completed record, got %q, %v", contents, err)
+	}
+}
+
+func TestPackRefreshesAreThrottled(t *testing.T) {
+	client := newFakeClient()
+	d := newPackTestDriver(client)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		if err := d.PutContent(ctx, fmt.Sprintf("/repos/r%d/link", i), []byte("x")); err != nil {
+			t.Fatalf("unexpected error from PutContent: %v", err)
+		}
+	}
+	var packStats int32
+	packPath := "/registry/repos/" + packFileName
+	client.hook("Stat", func(name string) error {
+		if name == packPath {
+			atomic.AddInt32(&packStats, 1)
+		}
+		return nil
+	})
+
+	for i := 0; i < 100; i++ {
+		p := fmt.Sprintf("/repos/r%d/link", i)
+		if _, err := d.GetContent(ctx, p); err != nil {
+			t.Fatalf("unexpected error from GetContent: %v", err)
+		}
+		if _, err := d.Stat(ctx, p); err != nil {
+			t.Fatalf("unexpected error from Stat: %v", err)
+		}
+	}
+	if info, err := d.Stat(ctx, "/repos"); err != nil || !info.IsDir() {
+		t.Fatalf("expected a directory of packed objects, got %+v, %v", info, err)
+	}
+	if listed, err := d.List(ctx, "/repos"); err != nil || len(listed) != 100 {
+		t.Fatalf("expected the 100 repositories, got %v, %v", listed, err)
+	}
+	if n := atomic.LoadInt32(&packStats); n != 0 {
+		t.Fatalf("expected indexed lookups to skip the pack file, got %d Stat calls", n)
+	}
+
+	// Paths missing from the index check for new records at most every
+	// packMissRefreshInterval
+	for i := 0; i < 100; i++ {
+		if _, err := d.Stat(ctx, fmt.Sprintf("/repos/r%d/data", i)); err == nil {
+			t.Fatal("expected a path that is not packed to be missing")
+		}
+	}
+	if n := atomic.LoadInt32(&packStats); n > 1 {
+		t.Fatalf("expected misses to refresh the index at most once, got %d Stat calls", n)
+	}
+	restarted := newPackTestDriver(client)
+	if err := restarted.PutContent(ctx, "/repos/new/link", []byte("new")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if err := restarted.PutContent(ctx, "/repos/r0/link", []byte("again")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	time.Sleep(packMissRefreshInterval)
+	if contents, err := d.GetContent(ctx, "/repos/new/link"); err != nil || string(contents) != "new" {
+		t.Fatalf("expected the other instance's write, got %q, %v", contents, err)
+	}
+
+	// Overwrites show up once the interval has passed
+	expireRefreshes(d)
+	if contents, err := d.GetContent(ctx, "/repos/r0/link"); err != nil || string(contents) != "again" {
+		t.Fatalf("expected the other instance's overwrite, got %q, %v", contents, err)
+	}
+
+	// Deletes below a directory go through the index too
+	if err := d.Delete(ctx, "/repos"); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+	if _, err := newPackTestDriver(client).Stat(ctx, "/repos/r5/link"); err == nil {
+		t.Fatal("expected the deleted objects to be gone")
+	}
+	if _, err := d.Stat(ctx, "/repos"); err == nil {
+		t.Fatal("expected no directory once its packed objects are deleted")
+	}
+}
+
+func TestPackLookupsDoNotWaitForTheLease(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{PackPrefixes: "/repos", PackMaxSize: 16, LeaseTimeout: time.Minute})
+	d.leaseRecoveryInterval = time.Millisecond
+	ctx := context.Background()
+	if err := d.PutContent(ctx, "/repos/a/link", []byte("a")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+
+	// Another instance holds the lease on the pack until released
+	held, release := make(chan struct{}), make(chan struct{})
+	var appends int32
+	client.hook("Append", func(name string) error {
+		if atomic.AddInt32(&appends, 1) == 1 {
+			close(held)
+			<-release
+			return errLeaseHeld
+		}
+		return nil
+	})
+	written := make(chan error)
+	go func() { written <- d.PutContent(ctx, "/repos/b/link", []byte("b")) }()
+	<-held
+
+	if contents, err := d.GetContent(ctx, "/repos/a/link"); err != nil || string(contents) != "a" {
+		t.Fatalf("expected the packed content while an append waits, got %q, %v", contents, err)
+	}
+	close(release)
+	if err := <-written; err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if contents, err := d.GetContent(ctx, "/repos/b/link"); err != nil || string(contents) != "b" {
+		t.Fatalf("expected the append once the lease was released, got %q, %v", contents, err)
+	}
+}
+
+func TestCompactPacks(t *testing.T) {
+	client := newFakeClient()
+	d := newPackTestDriver(client)
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		for _, p := range []string{"/repos/a/link", "/repos/b/link", "/repos/c/link"} {
+			if err := d.PutContent(ctx, p, []byte(fmt.Sprintf("%s %d", path.Base(path.Dir(p)), i))); err != nil {
+				t.Fatalf("unexpected error from PutContent: %v", err)
+			}
+		}
+	}
+	if err := d.Delete(ctx, "/repos/b"); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+	other := newPackTestDriver(client)
+	if _, err := other.GetContent(ctx, "/repos/a/link"); err != nil {
+		t.Fatalf("unexpected error from GetContent: %v", err)
+	}
+	packPath := "/registry/repos/" + packFileName
+	before, _ := client.Stat(packPath)
+
+	if err := wrap(d).CompactPacks(ctx); err != nil {
+		t.Fatalf("unexpected error from CompactPacks: %v", err)
+	}
+	after, _ := client.Stat(packPath)
+	if after.Size() >= before.Size()/5 {
+		t.Fatalf("expected the compacted pack to shrink, from %d to %d bytes", before.Size(), after.Size())
+	}
+	if files := countFiles(client); files != 1 {
+		t.Fatalf("expected only the pack file, got %d files", files)
+	}
+
+	// Both the compacting instance and the others read the compacted pack,
+	// and keep appending to it
+	if err := d.PutContent(ctx, "/repos/d/link", []byte("d")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	expireRefreshes(other)
+	for _, instance := range []*driver{d, other, newPackTestDriver(client)} {
+		for p, expected := range map[string]string{"/repos/a/link": "a 9", "/repos/c/link": "c 9", "/repos/d/link": "d"} {
+			if contents, err := instance.GetContent(ctx, p); err != nil || string(contents) != expected {
+				t.Fatalf("expected %q at %s after compacting, got %q, %v", expected, p, contents, err)
+			}
+		}
+		if _, err := instance.GetContent(ctx, "/repos/b/link"); err == nil {
+			t.Fatal("expected the deleted object to stay deleted after compacting")
+		}
+		if info, err := instance.Stat(ctx, "/repos/a/link"); err != nil || info.Size() != 3 {
+			t.Fatalf("expected the packed object after compacting, got %+v, %v", info, err)
+		}
+	}
+}
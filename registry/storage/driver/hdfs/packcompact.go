@@ -0,0 +1,155 @@
+package hdfs
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// PackCompacter is implemented by drivers that pack small objects into
+// logs, such as the HDFS driver with packprefixes, which grow with every
+// overwrite and delete until compacted.
+type PackCompacter interface {
+	// CompactPacks rewrites every pack with only the objects it still
+	// holds. It stops at the first error.
+	CompactPacks(ctx context.Context) error
+}
+
+// CompactPacks implements PackCompacter. Holding the lease on a pack keeps
+// the other instances from appending to it until the compacted pack has
+// replaced it, so their writes wait rather than go missing, for up to
+// leaserecoverytimeout.
+func (d *Driver) CompactPacks(ctx context.Context) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.CompactPacks()", d.Name())
+
+	return d.inner().compactPacks(ctx)
+}
+
+func (d *driver) compactPacks(ctx context.Context) error {
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	d = d.withOptions(ctx)
+	if err := d.writes.allow(); err != nil {
+		return err
+	}
+	if d.packs == nil {
+		return nil
+	}
+	for _, p := range d.packs.packs {
+		if err := d.compactPack(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packedObject is an object of a pack being compacted
+type packedObject struct {
+	path  string
+	entry packEntry
+}
+
+// byOffset sorts packed objects by where they are in their pack
+type byOffset []packedObject
+
+func (o byOffset) Len() int           { return len(o) }
+func (o byOffset) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o byOffset) Less(i, j int) bool { return o[i].entry.offset < o[j].entry.offset }
+
+// compactPack writes the objects p holds to a new pack file, which is then
+// renamed over that of p
+func (d *driver) compactPack(ctx context.Context, p *pack) error {
+	p.writing.Lock()
+	defer p.writing.Unlock()
+
+	packPath := d.packPath(p)
+	lease, err := d.openPack(packPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// Closed once the pack it appends to is replaced, which fails
+	defer lease.Close()
+
+	p.mu.Lock()
+	err = d.refreshPack(p)
+	scanned := p.scanned
+	objects := make([]packedObject, 0, len(p.index))
+	for subPath, entry := range p.index {
+		objects = append(objects, packedObject{path: subPath, entry: entry})
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	sort.Sort(byOffset(objects))
+
+	reader, err := d.hdfsClient.Open(packPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	compactedPath := d.stagingPath(packPath)
+	writer, err := d.hdfsClient.Create(compactedPath)
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewWriter(writer)
+	err = d.writeCompacted(buffered, reader, objects)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = d.hdfsClient.Rename(compactedPath, packPath)
+	}
+	if err != nil {
+		d.hdfsClient.Remove(compactedPath)
+		return err
+	}
+	context.GetLogger(ctx).Infof("hdfs: compacted the pack of %s, %d bytes holding %d objects", p.prefix, scanned, len(objects))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reset()
+	return d.refreshPack(p)
+}
+
+// writeCompacted writes the records of a compacted pack holding objects,
+// read from the pack in reader, to w
+func (d *driver) writeCompacted(w io.Writer, reader hdfsFileReader, objects []packedObject) error {
+	start, err := encodeRecord(packRecord{Compacted: time.Now()}, []byte{})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(start); err != nil {
+		return err
+	}
+	for _, object := range objects {
+		content := make([]byte, object.entry.size)
+		if _, err := reader.Seek(object.entry.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(reader, content); err != nil {
+			return err
+		}
+		record, err := encodeRecord(packRecord{Path: object.path, ModTime: object.entry.modTime}, content)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
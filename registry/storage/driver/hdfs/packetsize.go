@@ -0,0 +1,30 @@
+package hdfs
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// HDFS moves data between clients and datanodes in packets of
+// dfs.client-write-packet-size bytes, 64 KiB by default, and larger packets
+// save round trips on high latency links. colinmarc/hdfs always sends
+// packets of 64 KiB, so the dfspacketsize parameter applies to the
+// transfers the driver does control: WebHDFS reads, with webhdfsreads and
+// through the URLs from URLFor, ask the datanode for buffers of that size.
+// usehadoopenv takes it from hdfs-site.xml.
+const (
+	dfsPacketSizeProperty = "dfs.client-write-packet-size"
+
+	// A packet holds at least one checksum chunk and at most
+	// PacketReceiver.MAX_PACKET_SIZE
+	minDFSPacketSize = 512
+	maxDFSPacketSize = 16 << 20
+)
+
+// setBufferSize asks WebHDFS for buffers of packetSize in query, unless it
+// is 0 for the cluster default
+func setBufferSize(query url.Values, packetSize int64) {
+	if packetSize > 0 {
+		query.Set("buffersize", strconv.FormatInt(packetSize, 10))
+	}
+}
@@ -0,0 +1,55 @@
+package hdfs
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestDFSPacketSize(t *testing.T) {
+	server := httptest.NewServer(&fakeWebHdfs{})
+	defer server.Close()
+	d := newTestDriverWithParameters(newFakeClient(), DriverParameters{WebHdfsAddress: server.URL, DFSPacketSize: 1 << 20})
+
+	if d.webHdfs.packetSize != 1<<20 {
+		t.Fatalf("expected the packet size in the WebHDFS client, got %d", d.webHdfs.packetSize)
+	}
+	u, err := d.URLFor(context.Background(), "/a/b", nil)
+	if err != nil {
+		t.Fatalf("unexpected error from URLFor: %v", err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("URLFor returned an unparseable URL %q: %v", u, err)
+	}
+	if bufferSize := parsed.Query().Get("buffersize"); bufferSize != "1048576" {
+		t.Fatalf("expected buffersize=1048576, got %q", bufferSize)
+	}
+
+	// The cluster default leaves the buffer size to the datanode
+	d = newTestDriverWithParameters(newFakeClient(), DriverParameters{WebHdfsAddress: server.URL})
+	u, err = d.URLFor(context.Background(), "/a/b", nil)
+	if err != nil {
+		t.Fatalf("unexpected error from URLFor: %v", err)
+	}
+	if parsed, _ := url.Parse(u); parsed.Query().Get("buffersize") != "" {
+		t.Fatalf("expected no buffersize by default, got %q", u)
+	}
+}
+
+func TestDFSPacketSizeFromHadoopEnvironment(t *testing.T) {
+	defer withHadoopConfDir(t, map[string]string{
+		"core-site.xml": testCoreSite,
+		"hdfs-site.xml": `<configuration><property><name>dfs.client-write-packet-size</name><value>131072</value></property></configuration>`,
+	})()
+
+	params := DriverParameters{HdfsNameNode: "namenode:8020"}
+	if err := applyHadoopEnvironment(&params); err != nil {
+		t.Fatalf("unexpected error loading Hadoop configuration: %v", err)
+	}
+	if params.DFSPacketSize != 131072 {
+		t.Fatalf("expected the packet size from hdfs-site.xml, got %d", params.DFSPacketSize)
+	}
+}
@@ -0,0 +1,66 @@
+package hdfs
+
+import (
+	"io"
+	"sync"
+)
+
+// defaultParallelReads bounds the blocks GetContent reads at a time above
+// parallelreadthreshold
+const defaultParallelReads = 4
+
+// readParallel reads the size bytes of the file at fullPath for GetContent
+// with up to parallelreads readers, each reading whole blocks. Every block
+// is served by its own datanodes, so large files read faster than through
+// one stream. The blocks are assembled in order.
+func (d *driver) readParallel(fullPath string, size int64) ([]byte, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		contents = make([]byte, size)
+		firstErr error
+	)
+	work := make(chan int64)
+	for i := 0; i < d.parallelReads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range work {
+				end := offset + d.parallelReadBlock
+				if end > size {
+					end = size
+				}
+				err := d.readBlock(fullPath, offset, contents[offset:end])
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for offset := int64(0); offset < size; offset += d.parallelReadBlock {
+		work <- offset
+	}
+	close(work)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return contents, nil
+}
+
+// readBlock fills p from offset of the file at fullPath
+func (d *driver) readBlock(fullPath string, offset int64, p []byte) error {
+	reader, err := d.open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.ReadFull(&countingReader{Reader: d.throttleReader(reader), op: "GetContent"}, p)
+	return err
+}
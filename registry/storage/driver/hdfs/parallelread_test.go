@@ -0,0 +1,57 @@
+package hdfs
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestParallelGetContentMatchesSingleStream(t *testing.T) {
+	contents := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(contents)
+
+	for _, params := range []DriverParameters{
+		{},
+		{ParallelReadThreshold: 4096, ParallelReads: 3},
+		{ParallelReadThreshold: 4096, ParallelReads: 3, Compression: "gzip"},
+	} {
+		client := newFakeClient()
+		d := newTestDriverWithParameters(client, params)
+		d.parallelReadBlock = 1024
+		if err := d.PutContent(context.Background(), "/large", contents); err != nil {
+			t.Fatalf("unexpected error from PutContent: %v", err)
+		}
+		if err := d.PutContent(context.Background(), "/small", contents[:100]); err != nil {
+			t.Fatalf("unexpected error from PutContent: %v", err)
+		}
+
+		opens := client.callCount("Open")
+		read, err := d.GetContent(context.Background(), "/large")
+		if err != nil {
+			t.Fatalf("%+v: unexpected error from GetContent: %v", params, err)
+		}
+		if !bytes.Equal(read, contents) {
+			t.Fatalf("%+v: GetContent returned different content", params)
+		}
+		// Every block is read by a reader of its own
+		stored := int64(len(client.files["/registry/large"].data))
+		blocks := int((stored + d.parallelReadBlock - 1) / d.parallelReadBlock)
+		if params.ParallelReadThreshold == 0 || stored < params.ParallelReadThreshold {
+			blocks = 0
+		}
+		if calls := client.callCount("Open") - opens; calls != 1+blocks {
+			t.Fatalf("%+v: expected %d opens, got %d", params, 1+blocks, calls)
+		}
+
+		// Small objects keep a single stream
+		opens = client.callCount("Open")
+		if read, err := d.GetContent(context.Background(), "/small"); err != nil || !bytes.Equal(read, contents[:100]) {
+			t.Fatalf("%+v: unexpected small GetContent result: %v", params, err)
+		}
+		if calls := client.callCount("Open") - opens; calls != 1 {
+			t.Fatalf("%+v: expected a single open for a small object, got %d", params, calls)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package hdfs
+
+import (
+	"io"
+	"os"
+)
+
+// maxEmptyReads is how many reads in a row may return nothing and no error
+// before strictReader gives up, as bufio does
+const maxEmptyReads = 100
+
+// strictReader keeps the reads of a file to what io.Reader promises, so
+// callers reading in small increments with Read do not stop early. Short
+// reads are passed on, but an io.EOF before the size the file had when it
+// was opened is taken for the end of a block, where the HDFS client may
+// return one: the reader is positioned where the read stopped and reading
+// continues. Only an io.EOF that repeats there is returned, as
+// io.ErrUnexpectedEOF, since the file was truncated. Reads that return
+// nothing without an error are retried up to maxEmptyReads times.
+type strictReader struct {
+	hdfsFileReader
+	size     int64
+	position int64
+}
+
+func newStrictReader(reader hdfsFileReader) *strictReader {
+	return &strictReader{hdfsFileReader: reader, size: reader.Stat().Size()}
+}
+
+func (r *strictReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	resumed := false
+	for empty := 0; empty < maxEmptyReads; empty++ {
+		n, err := r.hdfsFileReader.Read(p)
+		r.position += int64(n)
+		if err == io.EOF && r.position < r.size {
+			if n > 0 {
+				return n, nil
+			}
+			if resumed {
+				return 0, io.ErrUnexpectedEOF
+			}
+			if _, err := r.hdfsFileReader.Seek(r.position, os.SEEK_SET); err != nil {
+				return 0, err
+			}
+			resumed = true
+			continue
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	return 0, io.ErrNoProgress
+}
+
+func (r *strictReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := r.hdfsFileReader.Seek(offset, whence)
+	if err == nil {
+		r.position = position
+	}
+	return position, err
+}
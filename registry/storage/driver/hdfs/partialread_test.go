@@ -0,0 +1,107 @@
+package hdfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+// blockEOFClient opens readers that return short reads and io.EOF at the
+// end of every block, like an HDFS client moving between datanodes
+type blockEOFClient struct {
+	*fakeClient
+	blockSize int64
+	truncated bool
+}
+
+func (c blockEOFClient) Open(name string) (hdfsFileReader, error) {
+	reader, err := c.fakeClient.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &blockEOFReader{hdfsFileReader: reader, blockSize: c.blockSize, truncated: c.truncated}, nil
+}
+
+type blockEOFReader struct {
+	hdfsFileReader
+	blockSize int64
+	position  int64
+	atEOF     bool
+	truncated bool
+}
+
+func (r *blockEOFReader) Read(p []byte) (int, error) {
+	if r.atEOF {
+		// Seeking moves on to the next block unless the file was cut short
+		return 0, io.EOF
+	}
+	if len(p) > 7 {
+		p = p[:7]
+	}
+	if rest := r.blockSize - r.position%r.blockSize; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := r.hdfsFileReader.Read(p)
+	r.position += int64(n)
+	if err == nil && r.position%r.blockSize == 0 {
+		r.atEOF = true
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *blockEOFReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := r.hdfsFileReader.Seek(offset, whence)
+	if err == nil {
+		r.position = position
+		r.atEOF = r.truncated && r.atEOF
+	}
+	return position, err
+}
+
+func TestReaderSmallReadsReachEnd(t *testing.T) {
+	client := newFakeClient()
+	contents := bytes.Repeat([]byte("0123456789"), 25)
+	client.writeFile("/registry/blob", contents)
+	d := newTestDriver(blockEOFClient{fakeClient: client, blockSize: 64})
+
+	reader, err := d.Reader(context.Background(), "/blob", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+
+	var read []byte
+	buf := make([]byte, 3)
+	for {
+		n, err := reader.Read(buf)
+		read = append(read, buf[:n]...)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error after %d bytes: %v", len(read), err)
+		}
+	}
+	if !bytes.Equal(read, contents) {
+		t.Fatalf("expected all %d bytes before EOF, got %d", len(contents), len(read))
+	}
+}
+
+func TestReaderTruncatedFile(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blob", bytes.Repeat([]byte("x"), 200))
+	d := newTestDriver(blockEOFClient{fakeClient: client, blockSize: 64, truncated: true})
+
+	reader, err := d.Reader(context.Background(), "/blob", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+
+	read, err := io.Copy(&bytes.Buffer{}, reader)
+	if err != io.ErrUnexpectedEOF || read != 64 {
+		t.Fatalf("expected io.ErrUnexpectedEOF after 64 bytes, got %d bytes, %v", read, err)
+	}
+}
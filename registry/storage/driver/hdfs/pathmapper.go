@@ -0,0 +1,75 @@
+package hdfs
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PathMapper lets embedders decide where the registry's logical paths are
+// stored below the root directory, e.g. to keep a repository's links close
+// to each other so garbage collection reads fewer namenode directories.
+//
+// List reads the directory a path maps to and reports its entries as the
+// children of that path, descending into the directories IsIntermediate
+// recognizes. A mapper must therefore map the children of a path into the
+// directory it maps the path to, possibly below intermediate directories.
+type PathMapper interface {
+	// MapPath maps a driver-relative path to the relative HDFS path.
+	// Applying it to an already mapped path must be a no-op.
+	MapPath(subPath string) string
+
+	// UnmapPath undoes MapPath. List also applies it to paths relative to
+	// a mapped directory, so it must work on any trailing part of one.
+	UnmapPath(hdfsPath string) string
+
+	// IsIntermediate reports whether a directory entry was introduced by
+	// MapPath, in which case its children belong to the parent
+	IsIntermediate(name string) bool
+}
+
+// pathMappers are the mappers the pathtransform parameter can select
+var pathMappers = make(map[string]PathMapper)
+
+// RegisterPathMapper makes mapper available to the pathtransform
+// parameter under name. It is meant to be called from init functions and
+// panics if name is taken.
+func RegisterPathMapper(name string, mapper PathMapper) {
+	if mapper == nil {
+		panic("Must not provide nil PathMapper")
+	}
+	if _, registered := pathMappers[name]; registered || name == "" || name == "none" || name == "digestprefix" {
+		panic(fmt.Sprintf("PathMapper named %s already registered", name))
+	}
+	pathMappers[name] = mapper
+}
+
+// pathTransformNames lists the values the pathtransform parameter accepts
+func pathTransformNames() []string {
+	names := make([]string, 0, len(pathMappers))
+	for name := range pathMappers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{"none", "digestprefix"}, names...)
+}
+
+// mapperTransform adapts a PathMapper to pathTransform
+type mapperTransform struct {
+	PathMapper
+}
+
+func (t mapperTransform) transform(subPath string) string { return t.MapPath(subPath) }
+func (t mapperTransform) reverse(hdfsPath string) string  { return t.UnmapPath(hdfsPath) }
+func (t mapperTransform) isIntermediate(name string) bool { return t.IsIntermediate(name) }
+
+// newPathMapping returns the transform params select, which is their
+// PathMapper when one is set
+func newPathMapping(params DriverParameters) (pathTransform, error) {
+	if params.PathMapper == nil {
+		return newPathTransform(params.PathTransform, int(params.PathDepth))
+	}
+	if (params.PathTransform != "" && params.PathTransform != "none") || params.PathDepth != 0 {
+		return nil, fmt.Errorf("The pathtransform and pathdepth parameters cannot be combined with a PathMapper")
+	}
+	return mapperTransform{params.PathMapper}, nil
+}
@@ -0,0 +1,114 @@
+package hdfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+// repositoryShardMapper groups repositories by their first letter, so
+// that "repositories/alpine/..." is stored as "repositories/@a/alpine/..."
+type repositoryShardMapper struct{}
+
+func (m repositoryShardMapper) MapPath(subPath string) string {
+	components := strings.Split(subPath, "/")
+	for i := 0; i+1 < len(components); i++ {
+		if components[i] == "repositories" && !m.IsIntermediate(components[i+1]) {
+			shard := "@" + components[i+1][:1]
+			return strings.Join(append(components[:i+1], append([]string{shard}, components[i+1:]...)...), "/")
+		}
+	}
+	return subPath
+}
+
+func (m repositoryShardMapper) UnmapPath(hdfsPath string) string {
+	components := strings.Split(hdfsPath, "/")
+	unmapped := make([]string, 0, len(components))
+	for _, component := range components {
+		if !m.IsIntermediate(component) {
+			unmapped = append(unmapped, component)
+		}
+	}
+	return strings.Join(unmapped, "/")
+}
+
+func (repositoryShardMapper) IsIntermediate(name string) bool {
+	return len(name) == 2 && name[0] == '@'
+}
+
+func init() {
+	RegisterPathMapper("repositoryshard", repositoryShardMapper{})
+}
+
+func TestPathMapperRoundTrip(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{PathMapper: repositoryShardMapper{}})
+	ctx := context.Background()
+
+	repositories := "/docker/registry/v2/repositories"
+	link := repositories + "/alpine/_layers/sha256/" + testDigestHex + "/link"
+	if err := d.PutContent(ctx, link, []byte("sha256:"+testDigestHex)); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := d.PutContent(ctx, repositories+"/busybox/_manifests/tags/latest/current/link", []byte("x")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if _, err := client.Stat("/registry" + repositories + "/@a/alpine/_layers/sha256/" + testDigestHex + "/link"); err != nil {
+		t.Fatalf("content was not written to the mapped location: %v", err)
+	}
+
+	if read, err := d.GetContent(ctx, link); err != nil || string(read) != "sha256:"+testDigestHex {
+		t.Fatalf("unexpected round-trip result %q, %v", read, err)
+	}
+	entries, err := d.List(ctx, repositories)
+	if err != nil {
+		t.Fatalf("unexpected error listing: %v", err)
+	}
+	if expected := []string{repositories + "/alpine", repositories + "/busybox"}; !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected List to report logical paths %v, got %v", expected, entries)
+	}
+	if entries, err := d.List(ctx, repositories+"/alpine/_layers/sha256"); err != nil || len(entries) != 1 || entries[0] != repositories+"/alpine/_layers/sha256/"+testDigestHex {
+		t.Fatalf("unexpected listing below a mapped directory: %v, %v", entries, err)
+	}
+
+	if err := d.Move(ctx, link, repositories+"/alpine/moved"); err != nil {
+		t.Fatalf("unexpected error moving: %v", err)
+	}
+	if _, err := client.Stat("/registry" + repositories + "/@a/alpine/moved"); err != nil {
+		t.Fatalf("the move did not land in the mapped location: %v", err)
+	}
+	if err := d.Delete(ctx, repositories+"/alpine"); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	if _, err := client.Stat("/registry" + repositories + "/@a/alpine"); err == nil {
+		t.Fatal("expected the mapped directory to be deleted")
+	}
+}
+
+func TestRegisteredPathMapper(t *testing.T) {
+	params, err := parseParameters(map[string]interface{}{"hdfsnamenode": "namenode:8020", "pathtransform": "repositoryshard"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := params.Validate(); err != nil {
+		t.Fatalf("expected the registered mapper to be accepted: %v", err)
+	}
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, params)
+	if err := d.PutContent(context.Background(), "/repositories/nginx/x", []byte("x")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if _, err := client.Stat(params.HdfsRootDirectory + "/repositories/@n/nginx/x"); err != nil {
+		t.Fatalf("content was not written to the mapped location: %v", err)
+	}
+
+	// A mapper cannot be combined with the built in transforms
+	params = validParameters()
+	params.PathMapper = repositoryShardMapper{}
+	params.PathTransform = "digestprefix"
+	if err := params.Validate(); err == nil || !strings.Contains(err.Error(), "PathMapper") {
+		t.Fatalf("expected pathtransform and a PathMapper to conflict, got %v", err)
+	}
+}
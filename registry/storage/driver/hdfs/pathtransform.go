@@ -0,0 +1,174 @@
+package hdfs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pathTransform rewrites driver-relative paths before they are joined onto
+// the root directory, e.g. to spread content-addressed data over more HDFS
+// directories. reverse must undo transform so that List can report the
+// driver-relative paths callers expect.
+type pathTransform interface {
+	// transform maps a driver-relative path to the relative HDFS path.
+	// Applying it to an already transformed path must be a no-op.
+	transform(subPath string) string
+
+	// reverse maps a relative HDFS path back to the driver-relative path
+	reverse(hdfsPath string) string
+
+	// isIntermediate reports whether a directory entry was introduced by
+	// the transform, in which case its children belong to the parent.
+	isIntermediate(name string) bool
+}
+
+// maxPathDepth bounds pathdepth; every level takes two hex characters of
+// the digest and another namenode lookup per access
+const maxPathDepth = 8
+
+// newPathTransform returns the transform selected by the pathtransform
+// parameter, or nil for none. depth is the pathdepth parameter, 0 for the
+// default of one level. Mappers registered with RegisterPathMapper are
+// selected by their name.
+func newPathTransform(name string, depth int) (pathTransform, error) {
+	if depth < 0 || depth > maxPathDepth {
+		return nil, fmt.Errorf("The pathdepth %#v parameter should be a number between 1 and %d (inclusive)", depth, maxPathDepth)
+	}
+	switch name {
+	case "", "none":
+		if depth > 1 {
+			return nil, fmt.Errorf("The pathdepth parameter requires pathtransform digestprefix")
+		}
+		return nil, nil
+	case "digestprefix":
+		if depth == 0 {
+			depth = 1
+		}
+		return digestPrefixTransform{depth: depth}, nil
+	default:
+		if mapper, ok := pathMappers[name]; ok {
+			if depth != 0 {
+				return nil, fmt.Errorf("The pathdepth parameter requires pathtransform digestprefix")
+			}
+			return mapperTransform{mapper}, nil
+		}
+		return nil, fmt.Errorf("The pathtransform parameter must be one of %v, %q invalid", pathTransformNames(), name)
+	}
+}
+
+// shardMarker starts every directory name inserted by digestPrefixTransform.
+// It is outside the characters allowed in storage driver paths, so shard
+// directories can never be confused with real path components.
+const shardMarker = "~"
+
+// digestHexRegexp matches the hex encoding of a sha256 or longer digest
+var digestHexRegexp = regexp.MustCompile(`^[a-f0-9]{64,}$`)
+
+// digestPrefixTransform shards content-addressed paths by inserting depth
+// directories named after successive pairs of hex characters of every
+// digest component. At depth 1 "_layers/sha256/abcd.../link" is stored as
+// "_layers/sha256/~ab/abcd.../link", at depth 2 as
+// "_layers/sha256/~ab/~cd/abcd.../link". Deeper trees keep directories
+// small at the cost of more of them.
+//
+// The depth decides where existing digests are looked up, so changing
+// pathdepth on a populated root hides its content. Copy the data with
+// Migrate from a driver using the old depth into one using the new depth
+// instead.
+type digestPrefixTransform struct {
+	depth int
+}
+
+func (t digestPrefixTransform) shards(component string) []string {
+	shards := make([]string, t.depth)
+	for i := range shards {
+		shards[i] = shardMarker + component[2*i:2*i+2]
+	}
+	return shards
+}
+
+func (t digestPrefixTransform) transform(subPath string) string {
+	components := strings.Split(subPath, "/")
+	transformed := make([]string, 0, len(components)+t.depth)
+	for _, component := range components {
+		if digestHexRegexp.MatchString(component) {
+			shards := t.shards(component)
+			if !hasSuffix(transformed, shards) {
+				transformed = append(transformed, shards...)
+			}
+		}
+		transformed = append(transformed, component)
+	}
+	return strings.Join(transformed, "/")
+}
+
+// hasSuffix reports whether components ends with suffix
+func hasSuffix(components, suffix []string) bool {
+	if len(components) < len(suffix) {
+		return false
+	}
+	tail := components[len(components)-len(suffix):]
+	for i := range suffix {
+		if tail[i] != suffix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (t digestPrefixTransform) reverse(hdfsPath string) string {
+	components := strings.Split(hdfsPath, "/")
+	reversed := make([]string, 0, len(components))
+	for _, component := range components {
+		if !t.isIntermediate(component) {
+			reversed = append(reversed, component)
+		}
+	}
+	return strings.Join(reversed, "/")
+}
+
+func (digestPrefixTransform) isIntermediate(name string) bool {
+	return len(name) == len(shardMarker)+2 && strings.HasPrefix(name, shardMarker)
+}
+
+// listIntermediate lists the entries below relative, a directory inserted
+// by the path transform in fullPath, descending through the further
+// levels pathdepth adds. Their paths are reported relative to subPath.
+// Nothing but the transform keeps a tree from nesting such directories
+// deeper, so they are walked with walkTree rather than recursively.
+func (d *driver) listIntermediate(subPath, fullPath, relative string) ([]listEntry, error) {
+	var entries []listEntry
+	infos := make(map[string]os.FileInfo)
+	list := func(dir string) ([]string, error) {
+		children, err := d.readDir(path.Join(fullPath, dir))
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, len(children))
+		for i, child := range children {
+			paths[i] = path.Join(dir, child.Name())
+			infos[paths[i]] = child
+		}
+		return paths, nil
+	}
+	err := walkTree(relative, list, func(childPath string) (bool, error) {
+		child := infos[childPath]
+		delete(infos, childPath)
+		if child.IsDir() && d.pathTransform.isIntermediate(child.Name()) {
+			return true, nil
+		}
+		entries = append(entries, listEntry{
+			path:     path.Join(subPath, d.pathTransform.reverse(childPath)),
+			fullPath: path.Join(fullPath, childPath),
+			info:     child,
+		})
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
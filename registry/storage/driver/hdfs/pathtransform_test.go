@@ -0,0 +1,125 @@
+package hdfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+const testDigestHex = "ab5e4b2ff0fa0ee9d7c0fd4d5e2d7f1b6c5b7e0e8c2d5c6f3f1b6e7a9d8c7b6a"
+
+func TestDigestPrefixTransform(t *testing.T) {
+	transform := digestPrefixTransform{depth: 1}
+	logical := "/docker/registry/v2/repositories/foo/_layers/sha256/" + testDigestHex + "/link"
+	stored := "/docker/registry/v2/repositories/foo/_layers/sha256/~ab/" + testDigestHex + "/link"
+
+	if transformed := transform.transform(logical); transformed != stored {
+		t.Fatalf("unexpected transformed path: %q", transformed)
+	}
+	if transformed := transform.transform(stored); transformed != stored {
+		t.Fatalf("transform is not idempotent: %q", transformed)
+	}
+	if reversed := transform.reverse(stored); reversed != logical {
+		t.Fatalf("reverse did not undo the transform: %q", reversed)
+	}
+	if short := "/docker/registry/v2/blobs/sha256/ab"; transform.transform(short) != short {
+		t.Fatalf("paths without a digest must not be changed")
+	}
+}
+
+func TestDigestPrefixTransformRoundTrip(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{PathTransform: "digestprefix"})
+	ctx := context.Background()
+
+	dir := "/docker/registry/v2/repositories/foo/_layers/sha256"
+	logical := dir + "/" + testDigestHex + "/link"
+	contents := []byte("sha256:" + testDigestHex)
+
+	if err := d.PutContent(ctx, logical, contents); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if _, err := client.Stat("/registry" + dir + "/~ab/" + testDigestHex + "/link"); err != nil {
+		t.Fatalf("content was not written to the transformed location: %v", err)
+	}
+
+	read, err := d.GetContent(ctx, logical)
+	if err != nil || string(read) != string(contents) {
+		t.Fatalf("unexpected round-trip result %q, %v", read, err)
+	}
+
+	entries, err := d.List(ctx, dir)
+	if err != nil {
+		t.Fatalf("unexpected error listing: %v", err)
+	}
+	if expected := []string{dir + "/" + testDigestHex}; !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected List to report driver paths %v, got %v", expected, entries)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry, shardMarker) {
+			t.Fatalf("List leaked a shard directory: %q", entry)
+		}
+	}
+}
+
+func TestPathDepthRoundTrip(t *testing.T) {
+	dir := "/docker/registry/v2/blobs/sha256/ab"
+	logical := dir + "/" + testDigestHex + "/data"
+	for _, tc := range []struct {
+		depth  int64
+		stored string
+	}{
+		{1, dir + "/~ab/" + testDigestHex + "/data"},
+		{3, dir + "/~ab/~5e/~4b/" + testDigestHex + "/data"},
+	} {
+		client := newFakeClient()
+		d := newTestDriverWithParameters(client, DriverParameters{PathTransform: "digestprefix", PathDepth: tc.depth})
+		ctx := context.Background()
+
+		if err := d.PutContent(ctx, logical, []byte("layer")); err != nil {
+			t.Fatalf("depth %d: unexpected error writing: %v", tc.depth, err)
+		}
+		if _, err := client.Stat("/registry" + tc.stored); err != nil {
+			t.Fatalf("depth %d: content was not written to %s: %v", tc.depth, tc.stored, err)
+		}
+		if transformed := d.pathTransform.transform(tc.stored); transformed != tc.stored {
+			t.Fatalf("depth %d: transform is not idempotent: %q", tc.depth, transformed)
+		}
+
+		read, err := d.GetContent(ctx, logical)
+		if err != nil || string(read) != "layer" {
+			t.Fatalf("depth %d: unexpected round-trip result %q, %v", tc.depth, read, err)
+		}
+		entries, err := d.List(ctx, dir)
+		if expected := []string{dir + "/" + testDigestHex}; err != nil || !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("depth %d: expected List to report %v, got %v, %v", tc.depth, expected, entries, err)
+		}
+		entries, err = d.List(ctx, dir+"/"+testDigestHex)
+		if expected := []string{logical}; err != nil || !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("depth %d: expected List to report %v, got %v, %v", tc.depth, expected, entries, err)
+		}
+	}
+}
+
+func TestInvalidPathDepth(t *testing.T) {
+	for _, tc := range []struct {
+		transform string
+		depth     int
+	}{
+		{"digestprefix", -1},
+		{"digestprefix", maxPathDepth + 1},
+		{"none", 3},
+	} {
+		if _, err := newPathTransform(tc.transform, tc.depth); err == nil {
+			t.Fatalf("expected an error for pathtransform %s with pathdepth %d", tc.transform, tc.depth)
+		}
+	}
+}
+
+func TestUnknownPathTransform(t *testing.T) {
+	if _, err := FromParameters(map[string]interface{}{"pathtransform": "bogus"}); err == nil {
+		t.Fatal("expected an error for an unknown path transform")
+	}
+}
@@ -0,0 +1,55 @@
+package hdfs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Native HDFS clients replace a datanode that fails in the middle of a
+// write pipeline according to
+// dfs.client.block.write.replace-datanode-on-failure.policy. colinmarc/hdfs
+// does not recover pipelines, its ClientOptions have no such setting and a
+// datanode failing mid-write fails the write. The writepipelinerecovery
+// parameter, which usehadoopenv picks up from that property, takes the
+// same values and the driver applies it to PutContent, which still holds
+// the contents: the write is done again from the start, and the namenode
+// allocates a new pipeline from the datanodes that are alive.
+//
+//	NEVER    return the error, as the client does
+//	DEFAULT  write again when files have three or more replicas, or the
+//	         cluster default; like native clients, smaller pipelines are
+//	         left alone
+//	ALWAYS   always write again
+//
+// Unlike with native clients, DEFAULT and ALWAYS do not fail writes on
+// small clusters for want of a spare datanode. Writers are not retried.
+const pipelineRecoveryProperty = "dfs.client.block.write.replace-datanode-on-failure.policy"
+
+func validateWritePipelineRecovery(policy string) error {
+	switch strings.ToUpper(policy) {
+	case "NEVER", "DEFAULT", "ALWAYS":
+		return nil
+	}
+	return fmt.Errorf("The writepipelinerecovery parameter should be NEVER, DEFAULT or ALWAYS, %q invalid", policy)
+}
+
+// isPipelineFailure reports whether err is a write failing on a datanode
+// rather than at the namenode
+func isPipelineFailure(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "datanode")
+}
+
+// rewritesAfter reports whether PutContent writes again after failing with
+// err by the writepipelinerecovery policy
+func (d *driver) rewritesAfter(err error) bool {
+	if !isPipelineFailure(err) {
+		return false
+	}
+	switch d.pipelineRecovery {
+	case "ALWAYS":
+		return true
+	case "DEFAULT":
+		return d.replication == 0 || d.replication >= 3
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package hdfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+// failingPipelineClient fails the first write to each of the next failures
+// files created with err, errPipeline unless set, as a datanode dropping
+// out of the pipeline does
+type failingPipelineClient struct {
+	*fakeClient
+	failures int
+	err      error
+}
+
+var errPipeline = errors.New("Error from datanode: All datanodes [DatanodeInfoWithStorage[10.0.0.3:9866]] are bad. Aborting...")
+
+func (c *failingPipelineClient) fail(w hdfsFileWriter, err error) (hdfsFileWriter, error) {
+	if err != nil || c.failures == 0 {
+		return w, err
+	}
+	c.failures--
+	if c.err != nil {
+		return shortWriter{w, c.err}, nil
+	}
+	return shortWriter{w, errPipeline}, nil
+}
+
+func (c *failingPipelineClient) Create(name string) (hdfsFileWriter, error) {
+	return c.fail(c.fakeClient.Create(name))
+}
+
+func (c *failingPipelineClient) CreateFile(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	return c.fail(c.fakeClient.CreateFile(name, replication, blockSize, perm))
+}
+
+func TestWritePipelineRecoveryFromHadoopEnvironment(t *testing.T) {
+	defer withHadoopConfDir(t, map[string]string{
+		"core-site.xml": testCoreSite,
+		"hdfs-site.xml": `<configuration><property><name>` + pipelineRecoveryProperty + `</name><value>ALWAYS</value></property></configuration>`,
+	})()
+
+	params := DriverParameters{}
+	if err := applyHadoopEnvironment(&params); err != nil {
+		t.Fatalf("unexpected error loading Hadoop configuration: %v", err)
+	}
+	if params.WritePipelineRecovery != "ALWAYS" {
+		t.Fatalf("expected the policy from hdfs-site.xml, got %q", params.WritePipelineRecovery)
+	}
+
+	// The parameter wins over the Hadoop configuration
+	params, err := parseParameters(map[string]interface{}{"hdfsnamenode": "namenode:8020", "writepipelinerecovery": "never"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := applyHadoopEnvironment(&params); err != nil {
+		t.Fatalf("unexpected error loading Hadoop configuration: %v", err)
+	}
+	d := newTestDriverWithParameters(newFakeClient(), params)
+	if d.pipelineRecovery != "NEVER" {
+		t.Fatalf("expected the driver to get the configured policy, got %q", d.pipelineRecovery)
+	}
+}
+
+func TestWritePipelineRecovery(t *testing.T) {
+	for _, tc := range []struct {
+		policy      string
+		replication int64
+		recovers    bool
+	}{
+		{"", 0, false},
+		{"NEVER", 3, false},
+		{"DEFAULT", 0, true},
+		{"DEFAULT", 3, true},
+		{"DEFAULT", 2, false},
+		{"ALWAYS", 1, true},
+	} {
+		client := &failingPipelineClient{fakeClient: newFakeClient(), failures: 1}
+		d := newTestDriverWithParameters(client, DriverParameters{WritePipelineRecovery: tc.policy, Replication: tc.replication})
+
+		err := d.PutContent(context.Background(), "/repo/file", []byte("contents"))
+		if !tc.recovers {
+			if err != errPipeline {
+				t.Errorf("%s with replication %d: expected the datanode error, got %v", tc.policy, tc.replication, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s with replication %d: unexpected error: %v", tc.policy, tc.replication, err)
+			continue
+		}
+		if contents, err := d.GetContent(context.Background(), "/repo/file"); err != nil || string(contents) != "contents" {
+			t.Errorf("%s with replication %d: expected the contents to be written again, got %q, %v", tc.policy, tc.replication, contents, err)
+		}
+	}
+
+	// Writes failing at the namenode are not retried
+	quotaErr := errors.New("The DiskSpace quota of /registry is exceeded")
+	client := &failingPipelineClient{fakeClient: newFakeClient(), failures: 1, err: quotaErr}
+	d := newTestDriverWithParameters(client, DriverParameters{WritePipelineRecovery: "ALWAYS"})
+	if err := d.PutContent(context.Background(), "/repo/file", []byte("contents")); err != quotaErr {
+		t.Fatalf("expected the quota error, got %v", err)
+	}
+	if calls := client.callCount("CreateFile") + client.callCount("Create"); calls != 1 {
+		t.Fatalf("expected a single create, got %d", calls)
+	}
+}
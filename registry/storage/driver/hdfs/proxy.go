@@ -0,0 +1,209 @@
+package hdfs
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// dialFunc is the signature of the dial functions of hdfs.ClientOptions
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// dial dials without a context, like net.Dial
+func (f dialFunc) dial(network, address string) (net.Conn, error) {
+	return f(context.Background(), network, address)
+}
+
+// parseProxyURL validates the proxyurl parameter: socks5://host:port for a
+// SOCKS5 proxy, or http://host:port for an HTTP proxy that supports CONNECT,
+// either with optional user:password@ credentials.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("The proxyurl parameter %q is invalid: %v", proxyURL, err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "http" {
+		return nil, fmt.Errorf("The proxyurl parameter must be a socks5:// or http:// URL, %q invalid", proxyURL)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, fmt.Errorf("The proxyurl parameter must include the host and port of the proxy, %q invalid", proxyURL)
+	}
+	return u, nil
+}
+
+// proxyDialer returns a dial function that connects through the proxy at
+// proxyURL with dial, for registries that reach the cluster from outside its
+// network. Namenode and datanode connections alike go through it, since
+// reads and writes need the datanodes as much as the namenode.
+func proxyDialer(proxyURL string, dial dialFunc) (dialFunc, error) {
+	u, err := parseProxyURL(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	handshake := socks5Connect
+	if u.Scheme == "http" {
+		handshake = httpConnect
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, u.Host)
+		if err != nil {
+			return nil, err
+		}
+		// The handshake is bound by the dial timeout like the connection
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		tunnel, err := handshake(conn, u.User, address)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("connecting to %s through proxy %s: %v", address, u.Host, err)
+		}
+		conn.SetDeadline(time.Time{})
+		return tunnel, nil
+	}, nil
+}
+
+// socks5Connect asks the SOCKS5 proxy on conn to connect to address, see RFC
+// 1928, authenticating with user as in RFC 1929 when it is set
+func socks5Connect(conn net.Conn, user *url.Userinfo, address string) (net.Conn, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", address)
+	}
+
+	methods := []byte{0x00}
+	if user != nil {
+		methods = []byte{0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	switch {
+	case reply[0] != 0x05:
+		return nil, fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	case reply[1] == 0x02 && user != nil:
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return nil, errors.New("SOCKS5 credentials longer than 255 bytes")
+		}
+		auth := []byte{0x01, byte(len(user.Username()))}
+		auth = append(auth, user.Username()...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil, err
+		}
+		if reply[1] != 0x00 {
+			return nil, errors.New("SOCKS5 authentication failed")
+		}
+	case reply[1] != 0x00:
+		return nil, errors.New("no acceptable SOCKS5 authentication method")
+	}
+
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip.To4() != nil {
+		request = append(append(request, 0x01), ip.To4()...)
+	} else if ip != nil {
+		request = append(append(request, 0x04), ip...)
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("host name %q too long for SOCKS5", host)
+		}
+		request = append(append(request, 0x03, byte(len(host))), host...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	// The reply ends with the address the proxy bound, which is not needed
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[1] != 0x00 {
+		return nil, fmt.Errorf("SOCKS5 proxy refused the connection with code %d", header[1])
+	}
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+		skip = int(length[0])
+	default:
+		return nil, fmt.Errorf("unexpected SOCKS5 address type %d", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// httpConnect asks the HTTP proxy on conn to tunnel to address with CONNECT
+func httpConnect(conn net.Conn, user *url.Userinfo, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP proxy refused CONNECT with %s", resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads what the proxy sent past its response before the rest
+// of the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
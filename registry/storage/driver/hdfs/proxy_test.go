@@ -0,0 +1,151 @@
+package hdfs
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+// fakeProxy accepts one connection, lets handshake read the request and
+// reply, and echoes the tunnel when handshake returns true. The address
+// requested is sent on targets.
+func fakeProxy(t *testing.T, handshake func(conn net.Conn) (string, bool)) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	targets := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		target, ok := handshake(conn)
+		targets <- target
+		if ok {
+			io.Copy(conn, conn)
+		}
+	}()
+	return listener.Addr().String(), targets
+}
+
+// socks5Handshake serves a SOCKS5 connect, requiring user:password when
+// wantAuth is set, and replies with code
+func socks5Handshake(wantAuth bool, code byte) func(conn net.Conn) (string, bool) {
+	return func(conn net.Conn) (string, bool) {
+		greeting := make([]byte, 3)
+		io.ReadFull(conn, greeting)
+		if wantAuth {
+			conn.Write([]byte{0x05, 0x02})
+			header := make([]byte, 2)
+			io.ReadFull(conn, header)
+			user := make([]byte, header[1])
+			io.ReadFull(conn, user)
+			length := make([]byte, 1)
+			io.ReadFull(conn, length)
+			password := make([]byte, length[0])
+			io.ReadFull(conn, password)
+			if string(user) != "user" || string(password) != "secret" {
+				conn.Write([]byte{0x01, 0x01})
+				return "", false
+			}
+			conn.Write([]byte{0x01, 0x00})
+		} else {
+			conn.Write([]byte{0x05, 0x00})
+		}
+
+		request := make([]byte, 5)
+		io.ReadFull(conn, request)
+		host := make([]byte, request[4])
+		io.ReadFull(conn, host)
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+		target := net.JoinHostPort(string(host), strconv.Itoa(int(port[0])<<8|int(port[1])))
+		conn.Write([]byte{0x05, code, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+		return target, code == 0x00
+	}
+}
+
+func assertTunnel(t *testing.T, conn net.Conn) {
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("unexpected error writing through the proxy: %v", err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("expected the tunnel to carry the connection, got %q, %v", reply, err)
+	}
+}
+
+func TestProxyDialerSOCKS5(t *testing.T) {
+	address, targets := fakeProxy(t, socks5Handshake(true, 0x00))
+	dial, err := proxyDialer("socks5://user:secret@"+address, (&net.Dialer{}).DialContext)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := dial(context.Background(), "tcp", "namenode.example:8020")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	if target := <-targets; target != "namenode.example:8020" {
+		t.Fatalf("expected the proxy to connect to the namenode, got %q", target)
+	}
+	assertTunnel(t, conn)
+}
+
+func TestProxyDialerHTTPConnect(t *testing.T) {
+	address, targets := fakeProxy(t, func(conn net.Conn) (string, bool) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != "CONNECT" {
+			return "", false
+		}
+		if user, password, ok := parseBasicProxyAuth(req.Header.Get("Proxy-Authorization")); !ok || user != "user" || password != "secret" {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return req.Host, false
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		return req.Host, true
+	})
+	dial, err := proxyDialer("http://user:secret@"+address, (&net.Dialer{}).DialContext)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := dial(context.Background(), "tcp", "datanode.example:9866")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	if target := <-targets; target != "datanode.example:9866" {
+		t.Fatalf("expected the proxy to connect to the datanode, got %q", target)
+	}
+	assertTunnel(t, conn)
+}
+
+func parseBasicProxyAuth(header string) (string, string, bool) {
+	req := &http.Request{Header: http.Header{"Authorization": {header}}}
+	return req.BasicAuth()
+}
+
+func TestNewDialsThroughProxy(t *testing.T) {
+	// The proxy refusing the connection fails New after the namenode port
+	// probe went through it
+	address, targets := fakeProxy(t, socks5Handshake(false, 0x05))
+	params := DefaultParameters()
+	params.HdfsNameNode = "namenode.example"
+	params.ProxyURL = "socks5://" + address
+	if _, err := New(params); err == nil {
+		t.Fatal("expected New to fail with the proxy refusing connections")
+	}
+	if target := <-targets; target != "namenode.example:8020" {
+		t.Fatalf("expected the namenode to be dialed through the proxy, got %q", target)
+	}
+}
@@ -0,0 +1,36 @@
+package hdfs
+
+import (
+	"log"
+	"os"
+	"path"
+)
+
+// pruneEmptyParents removes the directories above fullPath that deleting it
+// left empty, with pruneempty, up to the first one that still has entries.
+// The root and the registry directories fixpermissions knows are kept even
+// when empty, since every repository and blob shares them. Like checkEmpty
+// the directory is listed before the recursive Remove, so an entry created
+// in between is deleted with it. Failures only stop the pruning.
+func (d *driver) pruneEmptyParents(fullPath string) {
+	kept := map[string]bool{d.hdfsRootDirectory: true}
+	for _, dir := range permissionDirectories {
+		kept[path.Join(d.hdfsRootDirectory, dir)] = true
+	}
+
+	for dir := path.Dir(fullPath); !kept[dir] && d.directoryDepth(dir) > 0; dir = path.Dir(dir) {
+		children, err := d.readDir(dir)
+		if err != nil || len(children) > 0 {
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("hdfs: pruneempty: listing %s: %v", dir, err)
+			}
+			return
+		}
+		if err := d.hdfsClient.Remove(dir); err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("hdfs: pruneempty: removing %s: %v", dir, err)
+			}
+			return
+		}
+	}
+}
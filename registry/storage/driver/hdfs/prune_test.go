@@ -0,0 +1,50 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestPruneEmpty(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/docker/registry/v2/blobs/sha256/ab/abcd/data", []byte("blob"))
+	client.writeFile("/registry/docker/registry/v2/repositories/foo/_layers/sha256/abcd/link", []byte("link"))
+	client.writeFile("/registry/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link", []byte("link"))
+	d := newTestDriverWithParameters(client, DriverParameters{PruneEmpty: true})
+	ctx := context.Background()
+
+	// Parents are removed up to the first one with entries left
+	if err := d.Delete(ctx, "/docker/registry/v2/repositories/foo/_layers/sha256/abcd/link"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, dir := range []string{"_layers/sha256/abcd", "_layers/sha256", "_layers"} {
+		if _, err := client.Stat("/registry/docker/registry/v2/repositories/foo/" + dir); err == nil {
+			t.Fatalf("expected the empty directory %s to be pruned", dir)
+		}
+	}
+	if _, err := client.Stat("/registry/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link"); err != nil {
+		t.Fatalf("expected the rest of the repository to be kept: %v", err)
+	}
+
+	// The shared registry directories stay even when empty
+	if err := d.Delete(ctx, "/docker/registry/v2/blobs/sha256/ab/abcd"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Stat("/registry/docker/registry/v2/blobs/sha256"); err == nil {
+		t.Fatal("expected the empty blob prefix directories to be pruned")
+	}
+	if fi, err := client.Stat("/registry/docker/registry/v2/blobs"); err != nil || !fi.IsDir() {
+		t.Fatalf("expected the blobs directory to be kept, got %v, %v", fi, err)
+	}
+
+	// Without pruneempty empty parents are left alone
+	client.writeFile("/registry/other/nested/file", []byte("file"))
+	d = newTestDriver(client)
+	if err := d.Delete(ctx, "/other/nested/file"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Stat("/registry/other/nested"); err != nil {
+		t.Fatalf("expected the empty parent to be kept: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package hdfs
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/docker/distribution/context"
+)
+
+// ReaderPutter is implemented by drivers that can store content streamed
+// from a reader, such as the HDFS driver, so that callers do not have to
+// buffer it for PutContent
+type ReaderPutter interface {
+	// PutReader stores the size bytes read from r at path. Like a staged
+	// PutContent the content only appears at path once it is complete;
+	// when r fails or yields another number of bytes nothing is stored.
+	PutReader(ctx context.Context, path string, r io.Reader, size int64) error
+}
+
+// PutReader implements ReaderPutter. The content is always written to a
+// temporary file and renamed into place, whatever stagingstrategy says,
+// and maxputcontentsize does not apply.
+func (d *Driver) PutReader(ctx context.Context, path string, r io.Reader, size int64) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.PutReader(%q, %d)", d.Name(), path, size)
+
+	path, err := d.inner().checkPath(path, false)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		return fmt.Errorf("cannot put %d bytes to %s", size, path)
+	}
+	return d.inner().putReader(ctx, path, r, size)
+}
+
+func (d *driver) putReader(ctx context.Context, subPath string, r io.Reader, size int64) error {
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+
+	var attrs map[string][]byte
+	if d.compression != nil {
+		attrs = map[string][]byte{compressionXAttr: compressionHeader{codec: d.compression, logicalSize: size}.value()}
+	}
+	return d.putStaged(ctx, subPath, d.fullPath(subPath), size, attrs, func(w io.Writer) error {
+		w = &countingWriter{Writer: w, op: "PutReader"}
+		if d.compression == nil {
+			return d.copyExactly(ctx, subPath, w, r, size)
+		}
+		compressor, err := d.compression.newWriter(w)
+		if err != nil {
+			return err
+		}
+		if err := d.copyExactly(ctx, subPath, compressor, r, size); err != nil {
+			compressor.Close()
+			return err
+		}
+		return compressor.Close()
+	})
+}
+
+// copyExactly copies size bytes from r to w, failing if r has fewer or more
+func (d *driver) copyExactly(ctx context.Context, subPath string, w io.Writer, r io.Reader, size int64) error {
+	// One byte more than expected is enough to tell r is too long
+	n, err := d.bufferPool.copyContext(ctx, w, io.LimitReader(r, size+1))
+	switch {
+	case err != nil:
+		return err
+	case n < size:
+		return fmt.Errorf("PutReader to %s expected %d bytes, the reader ended after %d", subPath, size, n)
+	case n > size:
+		return fmt.Errorf("PutReader to %s expected %d bytes, the reader has more", subPath, size)
+	}
+	return nil
+}
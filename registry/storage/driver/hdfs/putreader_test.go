@@ -0,0 +1,64 @@
+package hdfs
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestPutReader(t *testing.T) {
+	for _, compression := range []string{"", "gzip"} {
+		client := newFakeClient()
+		var sd storagedriver.StorageDriver = wrap(newTestDriverWithParameters(client, DriverParameters{Compression: compression}))
+		putter, ok := sd.(ReaderPutter)
+		if !ok {
+			t.Fatalf("expected the driver to implement ReaderPutter")
+		}
+
+		contents := bytes.Repeat([]byte("layer"), 100000)
+		r, w := io.Pipe()
+		go func() {
+			for i := 0; i < len(contents); i += 4096 {
+				end := i + 4096
+				if end > len(contents) {
+					end = len(contents)
+				}
+				w.Write(contents[i:end])
+			}
+			w.Close()
+		}()
+
+		ctx := context.Background()
+		if err := putter.PutReader(ctx, "/streamed", r, int64(len(contents))); err != nil {
+			t.Fatalf("%q: unexpected error from PutReader: %v", compression, err)
+		}
+		stored, err := sd.GetContent(ctx, "/streamed")
+		if err != nil || !bytes.Equal(stored, contents) {
+			t.Fatalf("%q: stored content does not match, %d bytes, %v", compression, len(stored), err)
+		}
+	}
+}
+
+func TestPutReaderWrongSize(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriver(client))
+	ctx := context.Background()
+
+	for _, size := range []int64{4, 6} {
+		err := d.PutReader(ctx, "/short", strings.NewReader("layer"), size)
+		if err == nil {
+			t.Fatalf("expected a size of %d to be rejected", size)
+		}
+		if _, err := d.Stat(ctx, "/short"); !isPathNotFound(err) {
+			t.Fatalf("expected nothing to be stored for a size of %d, got %v", size, err)
+		}
+	}
+	// List hides temporary files, so look at the directory itself
+	if infos, err := client.ReadDir("/registry"); err != nil || len(infos) != 0 {
+		t.Fatalf("expected the temporary files to be removed, got %d, %v", len(infos), err)
+	}
+}
@@ -0,0 +1,260 @@
+package hdfs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// The repositoryquota parameter caps the bytes every repository may hold,
+// independently of HDFS quotas, which apply to directories: the layers of
+// a repository live in the shared blob store rather than below it. The
+// usage of a repository is the size of the layers it links plus its
+// uploads in progress. The driver counts it on the first write to the
+// repository, and again every quotaRescanInterval. In between it adds what
+// is written and the size of the layers linked, including those mounted
+// from other repositories, and takes off the uploads deleted or moved out
+// and the writes that failed. Every registry instance keeps its own count, so
+// concurrent pushes through several instances may overshoot the quota
+// until the next count.
+
+// quotaRescanInterval is how long a counted usage is trusted, so that
+// deletions and garbage collection are eventually reflected
+const quotaRescanInterval = time.Hour
+
+// QuotaExceededError is returned by writes that would take a repository
+// past the repositoryquota parameter
+type QuotaExceededError struct {
+	Repository string
+	Quota      int64
+	Usage      int64
+}
+
+func (e QuotaExceededError) Error() string {
+	return fmt.Sprintf("hdfs: repository %s would exceed its quota of %d bytes, %d bytes are used", e.Repository, e.Quota, e.Usage)
+}
+
+// repositoryOf splits subPath into the registry root, such as
+// /docker/registry/v2, and the name of the repository whose content it is.
+// The name is empty for paths outside a repository.
+func repositoryOf(subPath string) (root, repository string) {
+	i := strings.Index(subPath, "/repositories/")
+	if i < 0 {
+		return "", ""
+	}
+	components := strings.Split(subPath[i+len("/repositories/"):], "/")
+	for j, component := range components {
+		// Repository names cannot start with an underscore, the
+		// directories below them do
+		if strings.HasPrefix(component, "_") {
+			if j == 0 {
+				return "", ""
+			}
+			return subPath[:i], strings.Join(components[:j], "/")
+		}
+	}
+	return "", ""
+}
+
+type repositoryUsage struct {
+	bytes   int64
+	counted time.Time
+}
+
+// repositoryQuota keeps the usage of the repositories written to
+type repositoryQuota struct {
+	limit int64
+	count func(root, repository string) (int64, error)
+	now   func() time.Time
+
+	mu    sync.Mutex
+	usage map[string]*repositoryUsage
+}
+
+func newRepositoryQuota(limit int64, count func(root, repository string) (int64, error)) *repositoryQuota {
+	return &repositoryQuota{
+		limit: limit,
+		count: count,
+		now:   time.Now,
+		usage: make(map[string]*repositoryUsage),
+	}
+}
+
+// reserve adds n bytes to the usage of repository, or returns a
+// QuotaExceededError if that takes it past the quota
+func (q *repositoryQuota) reserve(root, repository string, n int64) error {
+	q.mu.Lock()
+	usage := q.usage[repository]
+	stale := usage == nil || q.now().Sub(usage.counted) >= quotaRescanInterval
+	q.mu.Unlock()
+
+	// Counting lists the repository, which should not block other writes
+	if stale {
+		bytes, err := q.count(root, repository)
+		if err != nil {
+			return err
+		}
+		q.mu.Lock()
+		q.usage[repository] = &repositoryUsage{bytes: bytes, counted: q.now()}
+		q.mu.Unlock()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage = q.usage[repository]
+	if usage.bytes+n > q.limit {
+		return QuotaExceededError{Repository: repository, Quota: q.limit, Usage: usage.bytes}
+	}
+	usage.bytes += n
+	return nil
+}
+
+// release takes n bytes off the usage of repository, if it was counted
+func (q *repositoryQuota) release(repository string, n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if usage := q.usage[repository]; usage != nil {
+		usage.bytes -= n
+		if usage.bytes < 0 {
+			usage.bytes = 0
+		}
+	}
+}
+
+// reserveQuota reserves n bytes written to subPath against the quota of
+// its repository, if any
+func (d *driver) reserveQuota(subPath string, n int64) error {
+	if d.quota == nil {
+		return nil
+	}
+	root, repository := repositoryOf(subPath)
+	if repository == "" {
+		return nil
+	}
+	return d.quota.reserve(root, repository, n)
+}
+
+// releaseQuota gives back n bytes reserved for subPath
+func (d *driver) releaseQuota(subPath string, n int64) {
+	if d.quota == nil || n <= 0 {
+		return
+	}
+	if _, repository := repositoryOf(subPath); repository != "" {
+		d.quota.release(repository, n)
+	}
+}
+
+// repositoryPathOf splits subPath into the registry root, the directory of
+// its repository and the components of subPath below it
+func repositoryPathOf(subPath string) (root, repositoryPath string, components []string) {
+	root, repository := repositoryOf(subPath)
+	if repository == "" {
+		return "", "", nil
+	}
+	repositoryPath = path.Join(root, "repositories", repository)
+	return root, repositoryPath, strings.Split(strings.TrimPrefix(subPath, repositoryPath+"/"), "/")
+}
+
+// uploadSize returns the bytes counted for subPath when it is an upload,
+// _uploads/<id>, or its data, which Delete and Move give back once it is
+// gone from the repository
+func (d *driver) uploadSize(subPath string) int64 {
+	if d.quota == nil {
+		return 0
+	}
+	_, repositoryPath, components := repositoryPathOf(subPath)
+	switch {
+	case len(components) == 2 && components[0] == "_uploads":
+	case len(components) == 3 && components[0] == "_uploads" && components[2] == "data":
+	default:
+		return 0
+	}
+	fi, err := d.hdfsClient.Stat(d.fullPath(path.Join(repositoryPath, "_uploads", components[1], "data")))
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// reserveLayer reserves the size of the blob a layer link, written to
+// _layers/<algorithm>/<hex>/link, links, unless the link exists already.
+// Uploads are given back when they are moved into the blob store, and
+// mounts from other repositories only write the link, so this is where
+// layers count. It returns the bytes reserved.
+func (d *driver) reserveLayer(subPath, fullPath string) (int64, error) {
+	if d.quota == nil {
+		return 0, nil
+	}
+	root, _, components := repositoryPathOf(subPath)
+	if len(components) != 4 || components[0] != "_layers" || components[3] != "link" || len(components[2]) < 2 {
+		return 0, nil
+	}
+	if _, err := d.hdfsClient.Stat(fullPath); err == nil {
+		return 0, nil
+	}
+	algorithm, hex := components[1], components[2]
+	fi, err := d.hdfsClient.Stat(d.fullPath(path.Join(root, "blobs", algorithm, hex[:2], hex, "data")))
+	if err != nil {
+		return 0, nil
+	}
+	if err := d.reserveQuota(subPath, fi.Size()); err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// repositoryUsage returns the size of the layers repository links and of
+// its uploads in progress
+func (d *driver) repositoryUsage(root, repository string) (int64, error) {
+	ctx := context.Background()
+	repositoryPath := path.Join(root, "repositories", repository)
+	var total int64
+	add := func(subPath string) error {
+		fi, err := d.Stat(ctx, subPath)
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		total += fi.Size()
+		return nil
+	}
+
+	// Layers are linked from _layers/<algorithm>/<hex>/link. Listing a
+	// missing directory returns nothing.
+	algorithms, err := d.List(ctx, path.Join(repositoryPath, "_layers"))
+	if err != nil {
+		return 0, err
+	}
+	for _, algorithm := range algorithms {
+		digests, err := d.List(ctx, algorithm)
+		if err != nil {
+			return 0, err
+		}
+		for _, digest := range digests {
+			hex := path.Base(digest)
+			if len(hex) < 2 {
+				continue
+			}
+			if err := add(path.Join(root, "blobs", path.Base(algorithm), hex[:2], hex, "data")); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	uploads, err := d.List(ctx, path.Join(repositoryPath, "_uploads"))
+	if err != nil {
+		return 0, err
+	}
+	for _, upload := range uploads {
+		if err := add(path.Join(upload, "data")); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,129 @@
+package hdfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestRepositoryOf(t *testing.T) {
+	for _, tc := range []struct {
+		subPath, root, repository string
+	}{
+		{"/docker/registry/v2/repositories/library/ubuntu/_uploads/123/data", "/docker/registry/v2", "library/ubuntu"},
+		{"/docker/registry/v2/repositories/app/_manifests/tags/latest/current/link", "/docker/registry/v2", "app"},
+		{"/docker/registry/v2/repositories/app", "", ""},
+		{"/docker/registry/v2/repositories/_uploads/data", "", ""},
+		{"/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data", "", ""},
+	} {
+		root, repository := repositoryOf(tc.subPath)
+		if root != tc.root || repository != tc.repository {
+			t.Errorf("%s: expected %q, %q, got %q, %q", tc.subPath, tc.root, tc.repository, root, repository)
+		}
+	}
+}
+
+func TestRepositoryQuota(t *testing.T) {
+	const v2 = "/docker/registry/v2"
+	client := newFakeClient()
+	client.writeFile("/registry"+v2+"/blobs/sha256/"+testDigestHex[:2]+"/"+testDigestHex+"/data", []byte("layer"))
+	client.writeFile("/registry"+v2+"/repositories/app/_layers/sha256/"+testDigestHex+"/link", []byte("sha256:"+testDigestHex))
+	d := newTestDriverWithParameters(client, DriverParameters{RepositoryQuota: 16})
+	ctx := context.Background()
+
+	// The linked layer counts against the quota
+	writer, err := d.Writer(ctx, v2+"/repositories/app/_uploads/1/data", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	if _, err := writer.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("unexpected error writing within the quota: %v", err)
+	}
+	_, err = writer.Write([]byte("0123456789"))
+	quotaErr, ok := err.(QuotaExceededError)
+	if !ok {
+		t.Fatalf("expected a QuotaExceededError, got %v", err)
+	}
+	if quotaErr.Repository != "app" || quotaErr.Usage != 15 {
+		t.Fatalf("unexpected error %+v", quotaErr)
+	}
+	writer.Close()
+
+	if err := d.PutContent(ctx, v2+"/repositories/app/_uploads/2/data", []byte("0123456789")); err == nil {
+		t.Fatal("expected PutContent past the quota to fail")
+	}
+
+	// Other repositories and the blob store have their own limits
+	if err := d.PutContent(ctx, v2+"/repositories/other/_uploads/1/data", []byte("0123456789")); err != nil {
+		t.Fatalf("unexpected error writing to another repository: %v", err)
+	}
+	if err := d.PutContent(ctx, v2+"/blobs/sha256/00/"+testDigestHex+"/data", make([]byte, 64)); err != nil {
+		t.Fatalf("unexpected error writing outside repositories: %v", err)
+	}
+}
+
+func TestRepositoryQuotaReleases(t *testing.T) {
+	const v2 = "/docker/registry/v2"
+	client := newFakeClient()
+	client.writeFile("/registry"+v2+"/blobs/sha256/"+testDigestHex[:2]+"/"+testDigestHex+"/data", []byte("0123456789"))
+	d := newTestDriverWithParameters(client, DriverParameters{RepositoryQuota: 100})
+	ctx := context.Background()
+	usage := func() int64 {
+		d.quota.mu.Lock()
+		defer d.quota.mu.Unlock()
+		return d.quota.usage["app"].bytes
+	}
+
+	// Bytes a write could not take are given back
+	upload := v2 + "/repositories/app/_uploads/1/data"
+	client.writeFile("/registry"+upload, nil)
+	appender, err := client.Append("/registry" + upload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := d.newFileWriter(shortWriter{hdfsFileWriter: appender, err: errors.New("datanode went away")}, upload, "/registry"+upload, 0)
+	if _, err := w.Write([]byte("01234567")); err == nil {
+		t.Fatal("expected the short write to fail")
+	}
+	w.Close()
+	if bytes := usage(); bytes != 4 {
+		t.Fatalf("expected the 4 bytes written to count, got %d", bytes)
+	}
+
+	// Deleting the upload gives it back
+	if err := d.Delete(ctx, v2+"/repositories/app/_uploads/1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes := usage(); bytes != 0 {
+		t.Fatalf("expected the deleted upload to be given back, got %d", bytes)
+	}
+
+	// A completed upload moves into the blob store and counts as the layer
+	// its link links
+	if err := d.PutContent(ctx, v2+"/repositories/app/_uploads/2/data", []byte("abcdefghij")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	blob := v2 + "/blobs/sha256/00/" + testDigestHex + "/data"
+	if err := d.Move(ctx, v2+"/repositories/app/_uploads/2/data", blob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes := usage(); bytes != 0 {
+		t.Fatalf("expected the moved upload to be given back, got %d", bytes)
+	}
+
+	// Mounting a layer only writes its link, which counts the layer
+	link := v2 + "/repositories/app/_layers/sha256/" + testDigestHex + "/link"
+	if err := d.PutContent(ctx, link, []byte("sha256:"+testDigestHex)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes := usage(); bytes != 10+int64(len("sha256:"+testDigestHex)) {
+		t.Fatalf("expected the mounted layer to count, got %d", bytes)
+	}
+	large := "ff" + testDigestHex[2:]
+	client.writeFile("/registry"+v2+"/blobs/sha256/ff/"+large+"/data", make([]byte, 64))
+	err = d.PutContent(ctx, v2+"/repositories/app/_layers/sha256/"+large+"/link", []byte("sha256:"+large))
+	if _, ok := err.(QuotaExceededError); !ok {
+		t.Fatalf("expected mounting a layer past the quota to fail, got %v", err)
+	}
+}
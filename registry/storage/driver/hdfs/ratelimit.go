@@ -0,0 +1,277 @@
+package hdfs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/colinmarc/hdfs"
+	"github.com/docker/distribution/context"
+	"golang.org/x/time/rate"
+)
+
+// errTooManyOperations is returned by namenode operations when maxopsmode is
+// error and the maxopspersecond budget is spent.
+var errTooManyOperations = errors.New("hdfs: maxopspersecond exceeded")
+
+// newOpsRateLimit returns the client limiting c to opsPerSecond namenode
+// operations, or c itself when opsPerSecond is 0. mode is block to wait for
+// the next slot or error to fail fast with errTooManyOperations.
+func newOpsRateLimit(c hdfsClient, opsPerSecond int64, mode string) (hdfsClient, error) {
+	switch mode {
+	case "", "block", "error":
+	default:
+		return nil, fmt.Errorf("The maxopsmode parameter must be one of %v, %q invalid", []string{"block", "error"}, mode)
+	}
+	if opsPerSecond <= 0 || c == nil {
+		return c, nil
+	}
+
+	burst := opsPerSecond
+	if burst > math.MaxInt32 {
+		burst = math.MaxInt32
+	}
+	return &rateLimitedClient{
+		hdfsClient: c,
+		limiter:    rate.NewLimiter(rate.Limit(opsPerSecond), int(burst)),
+		failFast:   mode == "error",
+	}, nil
+}
+
+// rateLimitedClient spends a token from limiter for every namenode
+// operation. Reads and writes on open files go to the datanodes and are not
+// counted.
+type rateLimitedClient struct {
+	hdfsClient
+	limiter  *rate.Limiter
+	failFast bool
+}
+
+func (c *rateLimitedClient) take() error {
+	if c.failFast {
+		if !c.limiter.Allow() {
+			return errTooManyOperations
+		}
+		return nil
+	}
+	return c.limiter.Wait(context.Background())
+}
+
+func (c *rateLimitedClient) Open(name string) (hdfsFileReader, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return c.hdfsClient.Open(name)
+}
+
+func (c *rateLimitedClient) Create(name string) (hdfsFileWriter, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return c.hdfsClient.Create(name)
+}
+
+func (c *rateLimitedClient) CreateFile(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return c.hdfsClient.CreateFile(name, replication, blockSize, perm)
+}
+
+func (c *rateLimitedClient) Append(name string) (hdfsFileWriter, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return c.hdfsClient.Append(name)
+}
+
+func (c *rateLimitedClient) Stat(name string) (os.FileInfo, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return c.hdfsClient.Stat(name)
+}
+
+func (c *rateLimitedClient) ReadDir(dirname string) ([]os.FileInfo, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return c.hdfsClient.ReadDir(dirname)
+}
+
+func (c *rateLimitedClient) Rename(oldpath, newpath string) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	return c.hdfsClient.Rename(oldpath, newpath)
+}
+
+func (c *rateLimitedClient) Remove(name string) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	return c.hdfsClient.Remove(name)
+}
+
+func (c *rateLimitedClient) MkdirAll(dirname string, perm os.FileMode) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	return c.hdfsClient.MkdirAll(dirname, perm)
+}
+
+func (c *rateLimitedClient) Chmod(name string, perm os.FileMode) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	return c.hdfsClient.Chmod(name, perm)
+}
+
+func (c *rateLimitedClient) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	return c.hdfsClient.Chtimes(name, atime, mtime)
+}
+
+func (c *rateLimitedClient) SetReplication(name string, replication int) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	return setReplication(c.hdfsClient, name, replication)
+}
+
+func (c *rateLimitedClient) SetStoragePolicy(name string, policy string) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	return setStoragePolicy(c.hdfsClient, name, policy)
+}
+
+func (c *rateLimitedClient) ReadDirBatches(dirname string, n int, f func([]os.FileInfo) error) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	return readDirBatches(c.hdfsClient, dirname, n, f)
+}
+
+func (c *rateLimitedClient) StatFs() (hdfs.FsInfo, error) {
+	if err := c.take(); err != nil {
+		return hdfs.FsInfo{}, err
+	}
+	return statFs(c.hdfsClient)
+}
+
+func (c *rateLimitedClient) EncryptionInfo(name string) (*fileEncryptionInfo, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return encryptionInfo(c.hdfsClient, name)
+}
+
+func (c *rateLimitedClient) CreateWithParents(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	// Clients without it fall back to two calls, which take their own tokens
+	if _, ok := c.hdfsClient.(parentCreator); !ok {
+		return nil, errUnsupportedByClient
+	}
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return createWithParents(c.hdfsClient, name, replication, blockSize, perm)
+}
+
+func (c *rateLimitedClient) FileChecksum(name string) (*fileChecksum, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return getFileChecksum(c.hdfsClient, name)
+}
+
+func (c *rateLimitedClient) CreateOverwriting(name string, replication int, blockSize int64, perm os.FileMode) (hdfsFileWriter, error) {
+	if _, ok := c.hdfsClient.(overwriteCreator); !ok {
+		return nil, errUnsupportedByClient
+	}
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return createOverwriting(c.hdfsClient, name, replication, blockSize, perm)
+}
+
+func (c *rateLimitedClient) CreateWithFavoredNodes(name string, replication int, blockSize int64, perm os.FileMode, favoredNodes []string) (hdfsFileWriter, error) {
+	if _, ok := c.hdfsClient.(favoredNodesCreator); !ok {
+		return nil, errUnsupportedByClient
+	}
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return createWithFavoredNodes(c.hdfsClient, name, replication, blockSize, perm, favoredNodes)
+}
+
+func (c *rateLimitedClient) Truncate(name string, size int64) (bool, error) {
+	if err := c.take(); err != nil {
+		return false, err
+	}
+	return truncate(c.hdfsClient, name, size)
+}
+
+func (c *rateLimitedClient) SetXAttr(name, attr string, value []byte) error {
+	if err := c.take(); err != nil {
+		return err
+	}
+	return setXAttr(c.hdfsClient, name, attr, value)
+}
+
+func (c *rateLimitedClient) XAttrs(name string) (map[string][]byte, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return getXAttrs(c.hdfsClient, name)
+}
+
+func (c *rateLimitedClient) ContentCount(name string) (int64, error) {
+	// Clients without it fall back to a walk, which takes its own tokens
+	if _, ok := c.hdfsClient.(contentCounter); !ok {
+		return 0, errUnsupportedByClient
+	}
+	if err := c.take(); err != nil {
+		return 0, err
+	}
+	return contentCount(c.hdfsClient, name)
+}
+
+func (c *rateLimitedClient) ContentSize(name string) (int64, error) {
+	// Clients without it fall back to a walk, which takes its own tokens
+	if _, ok := c.hdfsClient.(contentSizer); !ok {
+		return 0, errUnsupportedByClient
+	}
+	if err := c.take(); err != nil {
+		return 0, err
+	}
+	return contentSize(c.hdfsClient, name)
+}
+
+func (c *rateLimitedClient) RenewLease() error {
+	if _, ok := c.hdfsClient.(leaseRenewer); !ok {
+		return errUnsupportedByClient
+	}
+	if err := c.take(); err != nil {
+		return err
+	}
+	return renewLease(c.hdfsClient)
+}
+
+func (c *rateLimitedClient) RecoverLease(name string) (bool, error) {
+	if err := c.take(); err != nil {
+		return false, err
+	}
+	return recoverLease(c.hdfsClient, name)
+}
+
+func (c *rateLimitedClient) ReadFile(filename string) ([]byte, error) {
+	if err := c.take(); err != nil {
+		return nil, err
+	}
+	return c.hdfsClient.ReadFile(filename)
+}
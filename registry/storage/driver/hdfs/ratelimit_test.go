@@ -0,0 +1,57 @@
+package hdfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestMaxOpsPerSecondBlocks(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{MaxOpsPerSecond: 50})
+	ctx := context.Background()
+	client.writeFile("/registry/file", []byte("contents"))
+
+	// The first 50 operations use the initial burst, the next 50 have to
+	// wait for the bucket to refill
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if _, err := d.Stat(ctx, "/file"); err != nil {
+			t.Fatalf("unexpected error from Stat: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("100 operations at 50 per second took %v, expected about 1s", elapsed)
+	}
+	if client.callCount("Stat") != 100 {
+		t.Fatalf("expected every Stat to reach the namenode, got %d", client.callCount("Stat"))
+	}
+}
+
+func TestMaxOpsPerSecondErrors(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{MaxOpsPerSecond: 10, MaxOpsMode: "error"})
+	client.writeFile("/registry/file", []byte("contents"))
+
+	var failed int
+	for i := 0; i < 20; i++ {
+		if _, err := d.hdfsClient.Stat("/registry/file"); err == errTooManyOperations {
+			failed++
+		} else if err != nil {
+			t.Fatalf("unexpected error from Stat: %v", err)
+		}
+	}
+	if failed == 0 {
+		t.Fatalf("expected operations beyond maxopspersecond to fail")
+	}
+	if calls := client.callCount("Stat"); calls > 11 {
+		t.Fatalf("expected at most about 10 operations to reach the namenode, got %d", calls)
+	}
+}
+
+func TestMaxOpsModeParameter(t *testing.T) {
+	if _, err := FromParameters(map[string]interface{}{"maxopsmode": "sometimes"}); err == nil {
+		t.Fatalf("expected an invalid maxopsmode to be rejected")
+	}
+}
@@ -0,0 +1,119 @@
+package hdfs
+
+import (
+	"io"
+	"sync"
+)
+
+// maxReadAhead bounds the readahead parameter, which is held in memory for
+// every open Reader
+const maxReadAhead = 256 << 20
+
+// readAheadChunk is a piece of the stream read by the prefetcher
+type readAheadChunk struct {
+	data []byte
+	err  error
+}
+
+// readAheadReader prefetches a stream in a background goroutine so that
+// the next HDFS blocks are being fetched while the caller still sends the
+// previous ones on, e.g. to a client pulling a layer. At most the chunks
+// in flight plus the one being consumed are held in memory.
+type readAheadReader struct {
+	rc     io.ReadCloser
+	chunks chan readAheadChunk
+	free   chan []byte
+	stop   chan struct{}
+	done   chan struct{}
+
+	current readAheadChunk
+	buffer  []byte
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// newReadAheadReader prefetches up to size bytes of rc, in chunks of at
+// most chunkSize bytes
+func newReadAheadReader(rc io.ReadCloser, size, chunkSize int) *readAheadReader {
+	if chunkSize > size {
+		chunkSize = size
+	}
+	depth := size / chunkSize
+	r := &readAheadReader{
+		rc:     rc,
+		chunks: make(chan readAheadChunk, depth),
+		free:   make(chan []byte, depth+1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < depth+1; i++ {
+		r.free <- make([]byte, chunkSize)
+	}
+	go r.prefetch()
+	return r
+}
+
+func (r *readAheadReader) prefetch() {
+	defer close(r.done)
+	for {
+		var buf []byte
+		select {
+		case buf = <-r.free:
+		case <-r.stop:
+			return
+		}
+
+		n, err := io.ReadFull(r.rc, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		select {
+		case r.chunks <- readAheadChunk{data: buf[:n], err: err}:
+		case <-r.stop:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (r *readAheadReader) Read(p []byte) (int, error) {
+	if len(r.current.data) == 0 {
+		if r.current.err != nil {
+			return 0, r.current.err
+		}
+		if r.buffer != nil {
+			r.free <- r.buffer[:cap(r.buffer)]
+		}
+		r.current = <-r.chunks
+		r.buffer = r.current.data
+	}
+
+	n := copy(p, r.current.data)
+	r.current.data = r.current.data[n:]
+	if len(r.current.data) == 0 && r.current.err != nil {
+		return n, r.current.err
+	}
+	return n, nil
+}
+
+// Close stops the prefetcher, waiting for a read it has in progress, and
+// closes the stream
+func (r *readAheadReader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+		r.closeErr = r.rc.Close()
+	})
+	return r.closeErr
+}
+
+// readAhead applies the readahead parameter to rc
+func (d *driver) readAhead(rc io.ReadCloser) io.ReadCloser {
+	if d.readAheadSize <= 0 {
+		return rc
+	}
+	return newReadAheadReader(rc, d.readAheadSize, d.bufferPool.size)
+}
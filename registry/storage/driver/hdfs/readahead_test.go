@@ -0,0 +1,121 @@
+package hdfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestReadAheadReadsExactBytes(t *testing.T) {
+	contents := make([]byte, 1<<20+123)
+	rand.New(rand.NewSource(1)).Read(contents)
+
+	client := newFakeClient()
+	client.writeFile("/registry/blob", contents)
+	d := newTestDriverWithParameters(client, DriverParameters{ReadAhead: 256 << 10, TransferBufferSize: minTransferBufferSize})
+
+	for _, offset := range []int64{0, 1, 70000, int64(len(contents))} {
+		reader, err := d.Reader(context.Background(), "/blob", offset)
+		if err != nil {
+			t.Fatalf("unexpected error opening at %d: %v", offset, err)
+		}
+		// Small reads exercise chunks being consumed piecemeal
+		read, err := ioutil.ReadAll(io.LimitReader(reader, 1000))
+		if err != nil {
+			t.Fatalf("unexpected error reading at %d: %v", offset, err)
+		}
+		rest, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("unexpected error reading at %d: %v", offset, err)
+		}
+		reader.Close()
+		if !bytes.Equal(append(read, rest...), contents[offset:]) {
+			t.Fatalf("read ahead returned different bytes at offset %d", offset)
+		}
+	}
+}
+
+func TestReadAheadCloseStopsPrefetcher(t *testing.T) {
+	contents := bytes.Repeat([]byte("layer"), 1<<18)
+	client := newFakeClient()
+	client.writeFile("/registry/blob", contents)
+	d := newTestDriverWithParameters(client, DriverParameters{ReadAhead: 64 << 10, TransferBufferSize: minTransferBufferSize})
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		reader, err := d.Reader(context.Background(), "/blob", 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := reader.Read(make([]byte, 10)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := reader.Close(); err != nil {
+			t.Fatalf("unexpected error closing: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("prefetchers leaked: %d goroutines before, %d after", before, after)
+	}
+	if open := client.openReaders; open != 0 {
+		t.Fatalf("expected every file to be closed, %d are open", open)
+	}
+}
+
+// slowReader takes latency for every read, like fetching a block from a
+// datanode
+type slowReader struct {
+	io.Reader
+	latency time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.latency)
+	return r.Reader.Read(p)
+}
+
+// slowWriter takes latency for every write, like a client on the network
+type slowWriter struct {
+	latency time.Duration
+}
+
+func (w slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.latency)
+	return len(p), nil
+}
+
+func BenchmarkSequentialRead(b *testing.B) {
+	const size = 4 << 20
+	contents := make([]byte, size)
+	for _, readAhead := range []int{0, 1 << 20} {
+		name := "none"
+		if readAhead > 0 {
+			name = "readahead"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				var rc io.ReadCloser = ioutil.NopCloser(&slowReader{Reader: bytes.NewReader(contents), latency: 100 * time.Microsecond})
+				if readAhead > 0 {
+					rc = newReadAheadReader(rc, readAhead, 32<<10)
+				}
+				buf := make([]byte, 32<<10)
+				if _, err := io.CopyBuffer(slowWriter{latency: 100 * time.Microsecond}, rc, buf); err != nil {
+					b.Fatal(err)
+				}
+				rc.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package hdfs
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+const (
+	readLogDebug = "debug"
+	readLogInfo  = "info"
+)
+
+// readLog logs the outcome of reads, which a busy registry issues far too
+// often to log each at info. Successful reads log at readloglevel, and
+// only one in readlogsampling of them; failures always log as errors.
+// Missing paths count as successes, the registry probes for them all the
+// time.
+type readLog struct {
+	level    string
+	sampling uint64
+	count    uint64
+}
+
+func newReadLog(level string, sampling int64) *readLog {
+	if level == "" {
+		level = readLogDebug
+	}
+	if sampling < 1 {
+		sampling = 1
+	}
+	return &readLog{level: level, sampling: uint64(sampling)}
+}
+
+func validateReadLogLevel(level string) error {
+	switch level {
+	case readLogDebug, readLogInfo:
+		return nil
+	}
+	return fmt.Errorf("The readloglevel parameter should be %s or %s, %q invalid", readLogDebug, readLogInfo, level)
+}
+
+// log is deferred by read methods with their error result
+func (l *readLog) log(ctx context.Context, op, path string, start time.Time, err *error) {
+	logger := context.GetLoggerWithFields(ctx, map[interface{}]interface{}{
+		"hdfs.op":       op,
+		"hdfs.path":     path,
+		"hdfs.duration": time.Since(start),
+	})
+	if *err != nil {
+		if _, ok := (*err).(storagedriver.PathNotFoundError); !ok {
+			logger.Errorf("hdfs: %s failed: %v", op, *err)
+			return
+		}
+	}
+	if atomic.AddUint64(&l.count, 1)%l.sampling != 0 {
+		return
+	}
+	if l.level == readLogInfo {
+		logger.Infof("hdfs: %s", op)
+	} else {
+		logger.Debugf("hdfs: %s", op)
+	}
+}
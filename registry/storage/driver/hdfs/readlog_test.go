@@ -0,0 +1,64 @@
+package hdfs
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/context"
+)
+
+// newLogContext returns a context logging to the returned buffer at info
+func newLogContext() (context.Context, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buf
+	logger.Level = logrus.InfoLevel
+	return context.WithLogger(context.Background(), logrus.NewEntry(logger)), &buf
+}
+
+func TestReadLogOnlyLogsFailuresAtInfo(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/a", []byte("a"))
+	d := newTestDriver(client)
+	ctx, buf := newLogContext()
+
+	if _, err := d.GetContent(ctx, "/a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := d.Stat(ctx, "/a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := d.Stat(ctx, "/missing"); err == nil {
+		t.Fatal("expected a missing path")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected successful reads not to log at info, got %q", buf.String())
+	}
+
+	client.failWith("Stat", errors.New("connection reset by peer"))
+	if _, err := d.Stat(ctx, "/a"); err == nil {
+		t.Fatal("expected Stat to fail")
+	}
+	if out := buf.String(); !strings.Contains(out, "level=error") || !strings.Contains(out, "connection reset by peer") {
+		t.Fatalf("expected the failure to be logged, got %q", out)
+	}
+}
+
+func TestReadLogSampling(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/a", []byte("a"))
+	d := newTestDriverWithParameters(client, DriverParameters{ReadLogLevel: readLogInfo, ReadLogSampling: 3})
+	ctx, buf := newLogContext()
+
+	for i := 0; i < 6; i++ {
+		if _, err := d.Stat(ctx, "/a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if lines := strings.Count(buf.String(), "level=info"); lines != 2 {
+		t.Fatalf("expected 2 of 6 reads to be logged, got %d: %q", lines, buf.String())
+	}
+}
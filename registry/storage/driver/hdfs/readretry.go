@@ -0,0 +1,123 @@
+package hdfs
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultReadRetries is how many failed reads a Reader retries
+const defaultReadRetries = 2
+
+// A datanode entering maintenance, for an upgrade or a reboot, stops
+// serving its replicas without the namenode re-replicating them, as
+// dfs.namenode.maintenance.replication.min allows. Reads of blocks only
+// left there fail until the namenode moves the read to another replica,
+// which takes longer than the immediate retries of readretries allow.
+// These failures are retried separately, maintenanceretries times each
+// maintenanceretrydelay apart, looking the blocks up on the namenode again
+// every time. colinmarc/hdfs reports a block none of whose datanodes
+// answered as no available datanodes, which readretries covers; the
+// maintenance errors are those naming the maintenance admin state.
+const (
+	defaultMaintenanceRetries    = 3
+	defaultMaintenanceRetryDelay = 2 * time.Second
+)
+
+// isMaintenanceError reports whether err is a read failing because a
+// datanode is in or entering maintenance
+func isMaintenanceError(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	if err == nil {
+		return false
+	}
+	message := strings.ToUpper(err.Error())
+	return strings.Contains(message, "IN_MAINTENANCE") ||
+		strings.Contains(message, "ENTERING_MAINTENANCE") ||
+		strings.Contains(message, "IN MAINTENANCE")
+}
+
+// retryingReader reopens a file whose read failed and continues where the
+// failure happened. The HDFS client already tries every replica of a block
+// before giving up, but a datanode it cannot reach under the name it
+// reports, or a broken short-circuit read, fails the whole block; a fresh
+// reader looks the block up on the namenode again and keeps no list of
+// datanodes it gave up on. Every Reader gets retries attempts, and
+// maintenanceRetries more for maintenance errors.
+type retryingReader struct {
+	hdfsFileReader
+	open     func() (hdfsFileReader, error)
+	name     string
+	position int64
+	retries  int
+
+	maintenanceRetries int
+	maintenanceDelay   time.Duration
+}
+
+func (r *retryingReader) Read(p []byte) (int, error) {
+	n, err := r.hdfsFileReader.Read(p)
+	r.position += int64(n)
+	for err != nil && err != io.EOF && n == 0 {
+		if isMaintenanceError(err) && r.maintenanceRetries > 0 {
+			r.maintenanceRetries--
+			log.Printf("hdfs: a datanode holding %s at %d is in maintenance, reading it from another replica in %v", r.name, r.position, r.maintenanceDelay)
+			time.Sleep(r.maintenanceDelay)
+		} else if r.retries > 0 {
+			r.retries--
+		} else {
+			break
+		}
+		if rerr := r.reopen(); rerr != nil {
+			log.Printf("hdfs: unable to reopen %s at %d after %v: %v", r.name, r.position, err, rerr)
+			return 0, err
+		}
+		log.Printf("hdfs: retrying the read of %s at %d after %v", r.name, r.position, err)
+		n, err = r.hdfsFileReader.Read(p)
+		r.position += int64(n)
+	}
+	return n, err
+}
+
+func (r *retryingReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := r.hdfsFileReader.Seek(offset, whence)
+	if err == nil {
+		r.position = position
+	}
+	return position, err
+}
+
+// reopen replaces the reader with a new one positioned where it failed
+func (r *retryingReader) reopen() error {
+	reader, err := r.open()
+	if err != nil {
+		return err
+	}
+	if _, err := reader.Seek(r.position, os.SEEK_SET); err != nil {
+		reader.Close()
+		return err
+	}
+	r.hdfsFileReader.Close()
+	r.hdfsFileReader = reader
+	return nil
+}
+
+// retryReads makes reader retry failed reads of fullPath if readretries or
+// maintenanceretries allows any
+func (d *driver) retryReads(reader hdfsFileReader, fullPath string) hdfsFileReader {
+	if d.readRetries <= 0 && d.maintenanceRetries <= 0 {
+		return reader
+	}
+	return &retryingReader{
+		hdfsFileReader:     reader,
+		open:               func() (hdfsFileReader, error) { return d.open(fullPath) },
+		name:               fullPath,
+		retries:            d.readRetries,
+		maintenanceRetries: d.maintenanceRetries,
+		maintenanceDelay:   d.maintenanceRetryDelay,
+	}
+}
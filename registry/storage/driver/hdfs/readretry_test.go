@@ -0,0 +1,138 @@
+package hdfs
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+var errBlockRead = errors.New("could not read block BP-1:blk_1073741825_1001: no available datanodes")
+
+// badReplicaClient opens readers that fail once they reach failAt, the
+// first failures times, with err or errBlockRead
+type badReplicaClient struct {
+	*fakeClient
+	failAt   int64
+	failures int
+	err      error
+}
+
+func (c *badReplicaClient) Open(name string) (hdfsFileReader, error) {
+	reader, err := c.fakeClient.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &badReplicaReader{hdfsFileReader: reader, client: c}, nil
+}
+
+type badReplicaReader struct {
+	hdfsFileReader
+	client   *badReplicaClient
+	position int64
+}
+
+func (r *badReplicaReader) Read(p []byte) (int, error) {
+	if r.position >= r.client.failAt && r.client.failures > 0 {
+		r.client.failures--
+		if r.client.err != nil {
+			return 0, r.client.err
+		}
+		return 0, errBlockRead
+	}
+	if limit := r.client.failAt - r.position; limit > 0 && int64(len(p)) > limit {
+		p = p[:limit]
+	}
+	n, err := r.hdfsFileReader.Read(p)
+	r.position += int64(n)
+	return n, err
+}
+
+func (r *badReplicaReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := r.hdfsFileReader.Seek(offset, whence)
+	r.position = position
+	return position, err
+}
+
+func TestReaderRetriesFailedBlockRead(t *testing.T) {
+	contents := bytes.Repeat([]byte("0123456789"), 1000)
+	fake := newFakeClient()
+	fake.writeFile("/registry/blob", contents)
+	client := &badReplicaClient{fakeClient: fake, failAt: 4096, failures: 2}
+	d := newTestDriverWithParameters(client, DriverParameters{ReadRetries: 2})
+
+	reader, err := d.Reader(context.Background(), "/blob", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("expected the read to recover, got %v", err)
+	}
+	if !bytes.Equal(read, contents[10:]) {
+		t.Fatalf("expected the retried read to return the right bytes")
+	}
+	if opens := fake.callCount("Open"); opens != 3 {
+		t.Fatalf("expected the file to be reopened twice, got %d opens", opens)
+	}
+}
+
+func TestReaderRetryBudget(t *testing.T) {
+	fake := newFakeClient()
+	fake.writeFile("/registry/blob", bytes.Repeat([]byte("x"), 8192))
+	client := &badReplicaClient{fakeClient: fake, failAt: 4096, failures: 3}
+	d := newTestDriverWithParameters(client, DriverParameters{ReadRetries: 2})
+
+	reader, err := d.Reader(context.Background(), "/blob", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+	if _, err := ioutil.ReadAll(reader); err == nil {
+		t.Fatal("expected the read to fail once the retries are used up")
+	} else if e, ok := err.(DataUnavailableError); !ok || e.Err != errBlockRead {
+		t.Fatalf("expected the read to fail once the retries are used up, got %v", err)
+	}
+}
+
+func TestReaderRetriesMaintenanceReplica(t *testing.T) {
+	contents := bytes.Repeat([]byte("0123456789"), 1000)
+	fake := newFakeClient()
+	fake.writeFile("/registry/blob", contents)
+	maintenance := &os.PathError{Op: "read", Path: "/registry/blob", Err: errors.New("datanode 10.0.0.7:9866 is IN_MAINTENANCE")}
+	client := &badReplicaClient{fakeClient: fake, failAt: 4096, failures: 2, err: maintenance}
+	d := newTestDriverWithParameters(client, DriverParameters{MaintenanceRetries: 2, MaintenanceRetryDelay: time.Millisecond})
+
+	// With no readretries, only the maintenance retries recover the read
+	reader, err := d.Reader(context.Background(), "/blob", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("expected the read to move to another replica, got %v", err)
+	}
+	if !bytes.Equal(read, contents) {
+		t.Fatalf("expected the retried read to return the right bytes")
+	}
+	if opens := fake.callCount("Open"); opens != 3 {
+		t.Fatalf("expected the file to be reopened twice, got %d opens", opens)
+	}
+
+	// Other errors do not use the maintenance budget
+	client.failures, client.err = 1, nil
+	reader, err = d.Reader(context.Background(), "/blob", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+	if _, err := ioutil.ReadAll(reader); err == nil {
+		t.Fatal("expected a generic read failure not to be retried without readretries")
+	}
+}
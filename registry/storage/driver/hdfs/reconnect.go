@@ -0,0 +1,501 @@
+package hdfs
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colinmarc/hdfs"
+)
+
+// reconnectingClient redials the namenode when an operation fails because
+// the connection went stale, for instance after a namenode restart, and
+// retries the operation once on the new connection. Operations that change
+// the namespace, such as Rename, Remove and the creates, may have been
+// applied by the namenode before the connection failed; for those the new
+// connection is asked first, see doMutation. When the namenode
+// refused the operation as a standby it fails over instead, dialing with
+// failover set so that dial starts from the next HA namenode. Without a
+// client, as with lazyconnect, the first operation dials; until that
+// succeeds operations fail with errNamenodeUnavailable.
+//
+// Every reconnect is counted in registry.storage.hdfs, failovers separately,
+// and reported to onReconnect when set, since frequent ones are a sign of
+// an unstable cluster.
+type reconnectingClient struct {
+	mu          sync.RWMutex
+	client      hdfsClient
+	dial        func(failover bool) (hdfsClient, error)
+	onReconnect func(ReconnectEvent)
+
+	// router, when set, probes the namenodes for this client until Close
+	router *healthRouter
+}
+
+// ReconnectEvent describes a reconnect to the namenode, for the
+// DriverParameters.OnReconnect hook
+type ReconnectEvent struct {
+	// Failover is set when the namenode asked the client to go to another
+	// namenode rather than the connection failing
+	Failover bool
+
+	// Cause is the error of the operation that needed the reconnect, Err
+	// the error dialing again if that failed too
+	Cause error
+	Err   error
+
+	// Duration is how long dialing took
+	Duration time.Duration
+}
+
+func newReconnectingClient(client hdfsClient, dial func(failover bool) (hdfsClient, error)) *reconnectingClient {
+	return &reconnectingClient{client: client, dial: dial}
+}
+
+// isConnectionError reports whether err means the connection to the
+// namenode is unusable, as opposed to the namenode rejecting the request.
+// colinmarc/hdfs runs a namenode RPC whose connection fails again on the
+// other namenodes, and reports that none is available once it has tried
+// them all. Errors of datanode connections, such as timeouts, short reads
+// and the EOF of a stream, are left to the read and write retries: the
+// namenode connection is fine and reconnecting would not help.
+func isConnectionError(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err != nil && strings.Contains(err.Error(), "no available namenodes")
+}
+
+// isFailoverError reports whether err is the namenode asking the client to
+// go to another namenode: a StandbyException from a namenode that is not
+// active, or a RetriableException from one that cannot serve requests yet,
+// typically while it becomes active. Neither ran the operation.
+func isFailoverError(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "StandbyException") ||
+		strings.Contains(message, "RetriableException")
+}
+
+// errNamenodeUnavailable is returned while a lazily connected client cannot
+// reach the namenode. It is transient: the next operation dials again.
+type errNamenodeUnavailable struct {
+	err error
+}
+
+func (e errNamenodeUnavailable) Error() string {
+	return fmt.Sprintf("hdfs: the namenode is not available yet: %v", e.err)
+}
+
+func (c *reconnectingClient) current() hdfsClient {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.client
+}
+
+// connected returns the current client, dialing the first one. Concurrent
+// first operations wait for the same dial.
+func (c *reconnectingClient) connected() (hdfsClient, error) {
+	if client := c.current(); client != nil {
+		return client, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		client, err := c.dial(false)
+		if err != nil {
+			return nil, errNamenodeUnavailable{err}
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
+
+// reconnect replaces stale with a freshly dialed client, unless another
+// operation already did, and reports whether it dialed. The connection of
+// stale is unusable, so stale is closed.
+func (c *reconnectingClient) reconnect(stale hdfsClient, failover bool) (bool, error) {
+	return c.replace(stale, failover, true)
+}
+
+// switchOver replaces stale, which still works, with a client of a
+// healthier namenode for the health router. Readers and writers opened
+// through stale may still be transferring, so stale is not closed but left
+// to the garbage collector once they are done.
+func (c *reconnectingClient) switchOver(stale hdfsClient) error {
+	_, err := c.replace(stale, false, false)
+	return err
+}
+
+func (c *reconnectingClient) replace(stale hdfsClient, failover, closeStale bool) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != stale {
+		return false, nil
+	}
+
+	client, err := c.dial(failover)
+	if err != nil {
+		return true, err
+	}
+	if closer, ok := stale.(io.Closer); ok && closeStale {
+		closer.Close()
+	}
+	c.client = client
+	return true, nil
+}
+
+// Close stops the health router and closes the current client
+func (c *reconnectingClient) Close() error {
+	c.router.stop()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if closer, ok := c.client.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// do runs op, reconnecting and running it again if the connection was stale
+// or the namenode asked to fail over. op must be idempotent.
+func (c *reconnectingClient) do(op func(client hdfsClient) error) error {
+	return c.doMutation(op, nil)
+}
+
+// settleFunc decides, on the new connection, what becomes of a mutation
+// whose connection failed with cause: whether to run it again, or else the
+// error to return, nil when the namenode applied it
+type settleFunc func(client hdfsClient, cause error) (retry bool, err error)
+
+// doMutation is do for an op that is not idempotent. A failover is retried
+// as is, the namenode not having run op, but a stale connection leaves
+// open whether it did, so settle decides after reconnecting.
+func (c *reconnectingClient) doMutation(op func(client hdfsClient) error, settle settleFunc) error {
+	client, err := c.connected()
+	if err != nil {
+		return err
+	}
+	err = op(client)
+	failover := isFailoverError(err)
+	if !failover && !isConnectionError(err) {
+		return err
+	}
+
+	start := time.Now()
+	dialed, rerr := c.reconnect(client, failover)
+	if dialed {
+		event := ReconnectEvent{Failover: failover, Cause: err, Err: rerr, Duration: time.Since(start)}
+		transfers.reconnected(failover)
+		if c.onReconnect != nil {
+			c.onReconnect(event)
+		}
+	}
+	if rerr != nil {
+		log.Printf("hdfs: unable to reconnect to the namenode after %v: %v", err, rerr)
+		return err
+	}
+	log.Printf("hdfs: reconnected to the namenode in %v after %v", time.Since(start), err)
+	if settle != nil && !failover {
+		if retry, err := settle(c.current(), err); !retry {
+			return err
+		}
+	}
+	return op(c.current())
+}
+
+// pathExists reports whether name exists, the error being set when that
+// cannot be told
+func pathExists(client hdfsClient, name string) (bool, error) {
+	_, err := client.Stat(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// settleRename treats a rename as applied once oldpath is gone and newpath
+// there, and runs it again while oldpath is still there
+func settleRename(oldpath, newpath string) settleFunc {
+	return func(client hdfsClient, cause error) (bool, error) {
+		if exists, err := pathExists(client, oldpath); err != nil {
+			return false, cause
+		} else if exists {
+			return true, nil
+		}
+		if exists, err := pathExists(client, newpath); err == nil && exists {
+			return false, nil
+		}
+		return false, cause
+	}
+}
+
+// settleRemove treats a remove as applied once name is gone
+func settleRemove(name string) settleFunc {
+	return func(client hdfsClient, cause error) (bool, error) {
+		exists, err := pathExists(client, name)
+		if err != nil {
+			return false, cause
+		}
+		return exists, nil
+	}
+}
+
+// settleCreate runs a create again only if name is still missing. A file
+// the namenode did create cannot be written without the writer that was
+// lost with the connection, and creating it again would fail as existing,
+// so the connection error is returned instead.
+func settleCreate(name string) settleFunc {
+	return func(client hdfsClient, cause error) (bool, error) {
+		exists, err := pathExists(client, name)
+		if err != nil || exists {
+			return false, cause
+		}
+		return true, nil
+	}
+}
+
+// settleXAttr runs the creation of attribute attr again only if name does
+// not have it yet, creating it again would fail as existing
+func settleXAttr(name, attr string) settleFunc {
+	return func(client hdfsClient, cause error) (bool, error) {
+		attrs, err := getXAttrs(client, name)
+		if err != nil {
+			return false, cause
+		}
+		_, exists := attrs[attr]
+		return !exists, nil
+	}
+}
+
+func (c *reconnectingClient) Open(name string) (reader hdfsFileReader, err error) {
+	err = c.do(func(client hdfsClient) error {
+		reader, err = client.Open(name)
+		return err
+	})
+	return reader, err
+}
+
+func (c *reconnectingClient) Create(name string) (writer hdfsFileWriter, err error) {
+	err = c.doMutation(func(client hdfsClient) error {
+		writer, err = client.Create(name)
+		return err
+	}, settleCreate(name))
+	return writer, err
+}
+
+func (c *reconnectingClient) CreateFile(name string, replication int, blockSize int64, perm os.FileMode) (writer hdfsFileWriter, err error) {
+	err = c.doMutation(func(client hdfsClient) error {
+		writer, err = client.CreateFile(name, replication, blockSize, perm)
+		return err
+	}, settleCreate(name))
+	return writer, err
+}
+
+// Append cannot tell from the new connection whether the namenode opened
+// the file for the lost one, except by appending again: the lease of the
+// lost connection then refuses it, which is reported as the connection
+// error rather than the file being held by another writer.
+func (c *reconnectingClient) Append(name string) (writer hdfsFileWriter, err error) {
+	var cause error
+	err = c.doMutation(func(client hdfsClient) error {
+		writer, err = client.Append(name)
+		if cause != nil && err != nil && isLeaseHeld(err) {
+			return cause
+		}
+		return err
+	}, func(client hdfsClient, err error) (bool, error) {
+		cause = err
+		return true, nil
+	})
+	return writer, err
+}
+
+func (c *reconnectingClient) Stat(name string) (fi os.FileInfo, err error) {
+	err = c.do(func(client hdfsClient) error {
+		fi, err = client.Stat(name)
+		return err
+	})
+	return fi, err
+}
+
+func (c *reconnectingClient) ReadDir(dirname string) (fis []os.FileInfo, err error) {
+	err = c.do(func(client hdfsClient) error {
+		fis, err = client.ReadDir(dirname)
+		return err
+	})
+	return fis, err
+}
+
+func (c *reconnectingClient) Rename(oldpath, newpath string) error {
+	return c.doMutation(func(client hdfsClient) error {
+		return client.Rename(oldpath, newpath)
+	}, settleRename(oldpath, newpath))
+}
+
+func (c *reconnectingClient) Remove(name string) error {
+	return c.doMutation(func(client hdfsClient) error {
+		return client.Remove(name)
+	}, settleRemove(name))
+}
+
+func (c *reconnectingClient) MkdirAll(dirname string, perm os.FileMode) error {
+	return c.do(func(client hdfsClient) error {
+		return client.MkdirAll(dirname, perm)
+	})
+}
+
+func (c *reconnectingClient) Chmod(name string, perm os.FileMode) error {
+	return c.do(func(client hdfsClient) error {
+		return client.Chmod(name, perm)
+	})
+}
+
+func (c *reconnectingClient) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return c.do(func(client hdfsClient) error {
+		return client.Chtimes(name, atime, mtime)
+	})
+}
+
+func (c *reconnectingClient) SetReplication(name string, replication int) error {
+	return c.do(func(client hdfsClient) error {
+		return setReplication(client, name, replication)
+	})
+}
+
+func (c *reconnectingClient) SetStoragePolicy(name string, policy string) error {
+	return c.do(func(client hdfsClient) error {
+		return setStoragePolicy(client, name, policy)
+	})
+}
+
+func (c *reconnectingClient) ReadDirBatches(dirname string, n int, f func([]os.FileInfo) error) error {
+	// Batches already handed to f would be repeated by a retry
+	started := false
+	var err error
+	return c.do(func(client hdfsClient) error {
+		if started {
+			return err
+		}
+		err = readDirBatches(client, dirname, n, func(batch []os.FileInfo) error {
+			started = true
+			return f(batch)
+		})
+		return err
+	})
+}
+
+func (c *reconnectingClient) StatFs() (info hdfs.FsInfo, err error) {
+	err = c.do(func(client hdfsClient) error {
+		info, err = statFs(client)
+		return err
+	})
+	return info, err
+}
+
+func (c *reconnectingClient) EncryptionInfo(name string) (info *fileEncryptionInfo, err error) {
+	err = c.do(func(client hdfsClient) error {
+		info, err = encryptionInfo(client, name)
+		return err
+	})
+	return info, err
+}
+
+func (c *reconnectingClient) CreateWithParents(name string, replication int, blockSize int64, perm os.FileMode) (writer hdfsFileWriter, err error) {
+	err = c.doMutation(func(client hdfsClient) error {
+		writer, err = createWithParents(client, name, replication, blockSize, perm)
+		return err
+	}, settleCreate(name))
+	return writer, err
+}
+
+func (c *reconnectingClient) FileChecksum(name string) (checksum *fileChecksum, err error) {
+	err = c.do(func(client hdfsClient) error {
+		checksum, err = getFileChecksum(client, name)
+		return err
+	})
+	return checksum, err
+}
+
+func (c *reconnectingClient) CreateOverwriting(name string, replication int, blockSize int64, perm os.FileMode) (writer hdfsFileWriter, err error) {
+	err = c.doMutation(func(client hdfsClient) error {
+		writer, err = createOverwriting(client, name, replication, blockSize, perm)
+		return err
+	}, settleCreate(name))
+	return writer, err
+}
+
+func (c *reconnectingClient) CreateWithFavoredNodes(name string, replication int, blockSize int64, perm os.FileMode, favoredNodes []string) (writer hdfsFileWriter, err error) {
+	err = c.doMutation(func(client hdfsClient) error {
+		writer, err = createWithFavoredNodes(client, name, replication, blockSize, perm, favoredNodes)
+		return err
+	}, settleCreate(name))
+	return writer, err
+}
+
+func (c *reconnectingClient) Truncate(name string, size int64) (done bool, err error) {
+	err = c.do(func(client hdfsClient) error {
+		done, err = truncate(client, name, size)
+		return err
+	})
+	return done, err
+}
+
+func (c *reconnectingClient) SetXAttr(name, attr string, value []byte) error {
+	return c.doMutation(func(client hdfsClient) error {
+		return setXAttr(client, name, attr, value)
+	}, settleXAttr(name, attr))
+}
+
+func (c *reconnectingClient) XAttrs(name string) (attrs map[string][]byte, err error) {
+	err = c.do(func(client hdfsClient) error {
+		attrs, err = getXAttrs(client, name)
+		return err
+	})
+	return attrs, err
+}
+
+func (c *reconnectingClient) ContentCount(name string) (count int64, err error) {
+	err = c.do(func(client hdfsClient) error {
+		count, err = contentCount(client, name)
+		return err
+	})
+	return count, err
+}
+
+func (c *reconnectingClient) ContentSize(name string) (size int64, err error) {
+	err = c.do(func(client hdfsClient) error {
+		size, err = contentSize(client, name)
+		return err
+	})
+	return size, err
+}
+
+func (c *reconnectingClient) RenewLease() error {
+	return c.do(renewLease)
+}
+
+func (c *reconnectingClient) RecoverLease(name string) (done bool, err error) {
+	err = c.do(func(client hdfsClient) error {
+		done, err = recoverLease(client, name)
+		return err
+	})
+	return done, err
+}
+
+func (c *reconnectingClient) ReadFile(filename string) (contents []byte, err error) {
+	err = c.do(func(client hdfsClient) error {
+		contents, err = client.ReadFile(filename)
+		return err
+	})
+	return contents, err
+}
@@ -0,0 +1,414 @@
+package hdfs
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestReconnectOnStaleConnection(t *testing.T) {
+	stale := newFakeClient()
+	stale.failWith("Open", &os.PathError{Op: "open", Path: "/registry/file", Err: errDropped})
+	fresh := newFakeClient()
+	fresh.writeFile("/registry/file", []byte("contents"))
+
+	var dials int
+	client := newReconnectingClient(stale, func(failover bool) (hdfsClient, error) {
+		dials++
+		return fresh, nil
+	})
+	d := newTestDriver(client)
+
+	contents, err := d.GetContent(context.Background(), "/file")
+	if err != nil {
+		t.Fatalf("unexpected error from GetContent: %v", err)
+	}
+	if string(contents) != "contents" {
+		t.Fatalf("unexpected contents %q", contents)
+	}
+	if dials != 1 || stale.callCount("Open") != 1 || fresh.callCount("Open") != 1 {
+		t.Fatalf("expected one reconnect and retry, got %d dials", dials)
+	}
+
+	// Later operations use the new connection without redialing
+	if _, err := d.Stat(context.Background(), "/file"); err != nil {
+		t.Fatalf("unexpected error from Stat: %v", err)
+	}
+	if dials != 1 || stale.callCount("Stat") != 0 {
+		t.Fatalf("expected the new connection to be kept")
+	}
+}
+
+func TestReconnectIgnoresNamenodeErrors(t *testing.T) {
+	stale := newFakeClient()
+	var dials int
+	client := newReconnectingClient(stale, func(failover bool) (hdfsClient, error) {
+		dials++
+		return newFakeClient(), nil
+	})
+
+	if _, err := client.Stat("/missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+	if dials != 0 {
+		t.Fatalf("expected no reconnect for an error returned by the namenode")
+	}
+}
+
+func TestReconnectIgnoresDatanodeErrors(t *testing.T) {
+	stale := newFakeClient()
+	var dials int
+	client := newReconnectingClient(stale, func(failover bool) (hdfsClient, error) {
+		dials++
+		return newFakeClient(), nil
+	})
+
+	// Short reads, timeouts and resets of datanode connections are for the
+	// read retries, the namenode connection being fine
+	for _, err := range []error{
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		&net.OpError{Op: "read", Net: "tcp", Err: errors.New("i/o timeout")},
+		errors.New("read tcp 10.0.0.2:9866: connection reset by peer"),
+	} {
+		stale.failWith("ReadFile", &os.PathError{Op: "read", Path: "/registry/file", Err: err})
+		if _, err := client.ReadFile("/registry/file"); err == nil {
+			t.Fatal("expected the datanode error to be returned")
+		}
+	}
+	if dials != 0 || client.current() != stale {
+		t.Fatalf("expected no reconnect for datanode errors, got %d", dials)
+	}
+}
+
+func TestReconnectFailure(t *testing.T) {
+	stale := newFakeClient()
+	stale.failWith("Stat", errors.New("no available namenodes: write tcp 10.0.0.1:8020: broken pipe"))
+	client := newReconnectingClient(stale, func(failover bool) (hdfsClient, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	if _, err := client.Stat("/file"); err == nil || err.Error() != "no available namenodes: write tcp 10.0.0.1:8020: broken pipe" {
+		t.Fatalf("expected the original error when reconnecting fails, got %v", err)
+	}
+	if client.current() != stale {
+		t.Fatalf("expected the client to be kept when reconnecting fails")
+	}
+}
+
+func TestFailoverOnStandbyException(t *testing.T) {
+	standby := newFakeClient()
+	standby.failWith("Open", &os.PathError{Op: "open", Path: "/registry/file",
+		Err: errors.New("org.apache.hadoop.ipc.StandbyException: Operation category READ is not supported in state standby")})
+	active := newFakeClient()
+	active.writeFile("/registry/file", []byte("contents"))
+
+	// Dialing returns the namenodes in HA order, starting over from the
+	// standby unless failing over
+	namenodes := []*fakeClient{standby, active}
+	var next int
+	client := newReconnectingClient(standby, func(failover bool) (hdfsClient, error) {
+		if failover {
+			next++
+		}
+		return namenodes[next%len(namenodes)], nil
+	})
+	d := newTestDriver(client)
+
+	contents, err := d.GetContent(context.Background(), "/file")
+	if err != nil {
+		t.Fatalf("unexpected error from GetContent: %v", err)
+	}
+	if string(contents) != "contents" {
+		t.Fatalf("unexpected contents %q", contents)
+	}
+	if next != 1 || standby.callCount("Open") != 1 || active.callCount("Open") != 1 {
+		t.Fatalf("expected one failover to the active namenode, got %d", next)
+	}
+}
+
+func TestFailoverErrors(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		failover bool
+	}{
+		{errors.New("org.apache.hadoop.ipc.StandbyException: Operation category WRITE is not supported in state standby"), true},
+		{&os.PathError{Op: "stat", Path: "/file", Err: errors.New("org.apache.hadoop.ipc.RetriableException: NameNode still not started")}, true},
+		{&os.PathError{Op: "stat", Path: "/file", Err: io.EOF}, false},
+		{os.ErrNotExist, false},
+		{nil, false},
+	} {
+		if failover := isFailoverError(tc.err); failover != tc.failover {
+			t.Errorf("isFailoverError(%v) = %v, expected %v", tc.err, failover, tc.failover)
+		}
+	}
+}
+
+func TestLazyConnect(t *testing.T) {
+	fake := newFakeClient()
+	fake.writeFile("/registry/file", []byte("contents"))
+	var (
+		mu    sync.Mutex
+		dials int
+		down  = true
+	)
+	client := newReconnectingClient(nil, func(failover bool) (hdfsClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dials++
+		if down {
+			return nil, errors.New("connection refused")
+		}
+		time.Sleep(10 * time.Millisecond)
+		return fake, nil
+	})
+	d := newTestDriver(client)
+
+	// Operations fail while the namenode is down, and dial again each time
+	if _, err := d.GetContent(context.Background(), "/file"); err == nil {
+		t.Fatal("expected an error while the namenode is down")
+	} else if _, ok := err.(errNamenodeUnavailable); !ok {
+		t.Fatalf("expected errNamenodeUnavailable, got %v", err)
+	}
+
+	mu.Lock()
+	down, dials = false, 0
+	mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := d.GetContent(context.Background(), "/file")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error once the namenode is up: %v", err)
+		}
+	}
+	if dials != 1 {
+		t.Fatalf("expected exactly one client to be created, got %d", dials)
+	}
+}
+
+func TestNewLazyConnect(t *testing.T) {
+	params := DefaultParameters()
+	params.HdfsNameNode = "127.0.0.1:1"
+	params.LazyConnect = true
+	d, err := New(params)
+	if err != nil {
+		t.Fatalf("expected New to succeed without the namenode: %v", err)
+	}
+	if _, err := d.Stat(context.Background(), "/file"); err == nil || !strings.Contains(err.Error(), "not available yet") {
+		t.Fatalf("expected operations to fail while the namenode is unreachable, got %v", err)
+	}
+}
+
+func TestReconnectCountsAndReports(t *testing.T) {
+	// Every client answers once and then goes stale, the second as a
+	// standby asking to fail over
+	var dialed int
+	staleAfterOneUse := func() hdfsClient {
+		client := newFakeClient()
+		client.writeFile("/registry/file", []byte("contents"))
+		standby := dialed == 2
+		var calls int
+		client.hook("Stat", func(name string) error {
+			if calls++; calls == 1 {
+				return nil
+			} else if standby {
+				return errors.New("org.apache.hadoop.ipc.StandbyException: Operation category READ is not supported in state standby")
+			}
+			return &os.PathError{Op: "stat", Path: name, Err: errDropped}
+		})
+		return client
+	}
+	dialed = 1
+	client := newReconnectingClient(staleAfterOneUse(), func(failover bool) (hdfsClient, error) {
+		dialed++
+		return staleAfterOneUse(), nil
+	})
+	var events []ReconnectEvent
+	client.onReconnect = func(event ReconnectEvent) { events = append(events, event) }
+
+	reconnects, failovers := transfers.reconnects.Value(), transfers.failovers.Value()
+	for i := 0; i < 4; i++ {
+		if _, err := client.Stat("/registry/file"); err != nil {
+			t.Fatalf("stat %d: unexpected error: %v", i, err)
+		}
+	}
+
+	if got := transfers.reconnects.Value() - reconnects; got != 3 {
+		t.Fatalf("expected 3 reconnects to be counted, got %d", got)
+	}
+	if got := transfers.failovers.Value() - failovers; got != 1 {
+		t.Fatalf("expected 1 failover to be counted, got %d", got)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected the hook to fire for each reconnect, got %+v", events)
+	}
+	for i, event := range events {
+		if event.Failover != (i == 1) || event.Cause == nil || event.Err != nil {
+			t.Errorf("event %d: unexpected %+v", i, event)
+		}
+	}
+
+	// A redial that fails is reported too
+	client.dial = func(failover bool) (hdfsClient, error) { return nil, errors.New("connection refused") }
+	if _, err := client.Stat("/registry/file"); err == nil {
+		t.Fatal("expected the stat to fail without a connection")
+	}
+	if len(events) != 4 || events[3].Err == nil {
+		t.Fatalf("expected the failed redial to be reported, got %+v", events)
+	}
+}
+
+// droppingClient applies the operations in drop to the fake namenode, then
+// loses the connection before the reply arrives
+type droppingClient struct {
+	*fakeClient
+	drop map[string]bool
+}
+
+var errDropped = errors.New("no available namenodes: read tcp 10.0.0.1:8020: connection reset by peer")
+
+func (c *droppingClient) Rename(oldpath, newpath string) error {
+	if err := c.fakeClient.Rename(oldpath, newpath); err != nil || !c.drop["Rename"] {
+		return err
+	}
+	return errDropped
+}
+
+func (c *droppingClient) Remove(name string) error {
+	if err := c.fakeClient.Remove(name); err != nil || !c.drop["Remove"] {
+		return err
+	}
+	return errDropped
+}
+
+func (c *droppingClient) Create(name string) (hdfsFileWriter, error) {
+	writer, err := c.fakeClient.Create(name)
+	if err != nil || !c.drop["Create"] {
+		return writer, err
+	}
+	return nil, errDropped
+}
+
+func TestReconnectSettlesAppliedMutations(t *testing.T) {
+	namenode := newFakeClient()
+	namenode.writeFile("/registry/a", []byte("a"))
+	namenode.writeFile("/registry/b", []byte("b"))
+	stale := &droppingClient{namenode, map[string]bool{"Rename": true, "Remove": true, "Create": true}}
+	client := newReconnectingClient(stale, func(failover bool) (hdfsClient, error) {
+		return namenode, nil
+	})
+
+	// The namenode renamed before the connection dropped
+	if err := client.Rename("/registry/a", "/registry/moved"); err != nil {
+		t.Fatalf("expected the applied rename to succeed, got %v", err)
+	}
+	if namenode.callCount("Rename") != 1 {
+		t.Fatalf("expected the rename not to be repeated, got %d", namenode.callCount("Rename"))
+	}
+
+	client = newReconnectingClient(stale, func(failover bool) (hdfsClient, error) {
+		return namenode, nil
+	})
+	if err := client.Remove("/registry/b"); err != nil {
+		t.Fatalf("expected the applied remove to succeed, got %v", err)
+	}
+	if namenode.callCount("Remove") != 1 {
+		t.Fatalf("expected the remove not to be repeated, got %d", namenode.callCount("Remove"))
+	}
+
+	// The file was created but its writer is lost
+	client = newReconnectingClient(stale, func(failover bool) (hdfsClient, error) {
+		return namenode, nil
+	})
+	if _, err := client.Create("/registry/c"); err != errDropped {
+		t.Fatalf("expected the connection error for a created file, got %v", err)
+	}
+	if namenode.callCount("Create") != 1 {
+		t.Fatalf("expected the create not to be repeated, got %d", namenode.callCount("Create"))
+	}
+}
+
+func TestReconnectRetriesMutationsNotApplied(t *testing.T) {
+	namenode := newFakeClient()
+	namenode.writeFile("/registry/a", []byte("a"))
+	stale := newFakeClient()
+	stale.failWith("Rename", errDropped)
+	stale.failWith("Remove", errDropped)
+	stale.failWith("Create", errDropped)
+	dial := func(failover bool) (hdfsClient, error) { return namenode, nil }
+
+	if err := newReconnectingClient(stale, dial).Rename("/registry/a", "/registry/moved"); err != nil {
+		t.Fatalf("unexpected error from the retried rename: %v", err)
+	}
+	if _, err := namenode.Stat("/registry/moved"); err != nil || namenode.callCount("Rename") != 1 {
+		t.Fatalf("expected the rename to be retried once, got %v after %d", err, namenode.callCount("Rename"))
+	}
+	if err := newReconnectingClient(stale, dial).Remove("/registry/moved"); err != nil || namenode.callCount("Remove") != 1 {
+		t.Fatalf("expected the remove to be retried once, got %v after %d", err, namenode.callCount("Remove"))
+	}
+	if writer, err := newReconnectingClient(stale, dial).Create("/registry/c"); err != nil || writer == nil {
+		t.Fatalf("expected the create to be retried, got %v", err)
+	}
+}
+
+// closingClient counts how often its connection was closed
+type closingClient struct {
+	*fakeClient
+	closed int
+}
+
+func (c *closingClient) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestSwitchOverKeepsStaleClientOpen(t *testing.T) {
+	stale := &closingClient{fakeClient: newFakeClient()}
+	fresh := &closingClient{fakeClient: newFakeClient()}
+	client := newReconnectingClient(stale, func(failover bool) (hdfsClient, error) { return fresh, nil })
+
+	// Transfers through a client the health router moves away from may be
+	// in flight, so it is not closed
+	if err := client.switchOver(stale); err != nil {
+		t.Fatalf("unexpected error from switchOver: %v", err)
+	}
+	if client.current() != fresh || stale.closed != 0 {
+		t.Fatalf("expected the switch over to keep the stale client open, closed %d times", stale.closed)
+	}
+
+	// A client whose connection was lost is closed
+	client.dial = func(failover bool) (hdfsClient, error) { return stale, nil }
+	if _, err := client.reconnect(fresh, false); err != nil {
+		t.Fatalf("unexpected error from reconnect: %v", err)
+	}
+	if fresh.closed != 1 {
+		t.Fatalf("expected the reconnect to close the lost client, closed %d times", fresh.closed)
+	}
+
+	// Close stops the router and closes the current client
+	client.router = newMockRouter(nil)
+	if err := client.Close(); err != nil {
+		t.Fatalf("unexpected error from Close: %v", err)
+	}
+	if stale.closed != 1 || !client.router.isStopped() {
+		t.Fatalf("expected Close to close the client and stop the router, closed %d times", stale.closed)
+	}
+}
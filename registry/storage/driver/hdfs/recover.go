@@ -0,0 +1,37 @@
+package hdfs
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/docker/distribution/context"
+)
+
+// errDriverPanic is returned by a driver method that panicked while
+// recoverpanics is set, instead of the panic taking down the registry
+type errDriverPanic struct {
+	op    string
+	value interface{}
+}
+
+func (e errDriverPanic) Error() string {
+	return fmt.Sprintf("hdfs: %s panicked: %v", e.op, e.value)
+}
+
+// recoverPanic turns a panic in the driver method op into *err, logging
+// the stack to the logger of ctx. It must be deferred directly, as
+//
+//	defer d.recoverPanic(ctx, "Stat", &err)
+//
+// since recover only stops a panic there. Without recoverpanics it leaves
+// the panic alone. Panics in the readers and writers returned by the
+// driver happen after the method returned and are not covered.
+func (d *driver) recoverPanic(ctx context.Context, op string, err *error) {
+	if !d.recoverPanics {
+		return
+	}
+	if r := recover(); r != nil {
+		context.GetLogger(ctx).Errorf("hdfs: %s panicked: %v\n%s", op, r, debug.Stack())
+		*err = errDriverPanic{op: op, value: r}
+	}
+}
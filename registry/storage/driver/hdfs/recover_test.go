@@ -0,0 +1,44 @@
+package hdfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/a", []byte("a"))
+	d := wrap(newTestDriverWithParameters(client, DriverParameters{RecoverPanics: true}))
+	client.hook("Stat", func(name string) error {
+		var reader hdfsFileReader
+		reader.Close()
+		return nil
+	})
+
+	_, err := d.Stat(context.Background(), "/a")
+	if err == nil || !strings.Contains(err.Error(), "Stat panicked") {
+		t.Fatalf("expected the panic to be returned as an error, got %v", err)
+	}
+
+	// The driver keeps working afterwards
+	client.hook("Stat", nil)
+	if _, err := d.Stat(context.Background(), "/a"); err != nil {
+		t.Fatalf("unexpected error after recovering: %v", err)
+	}
+}
+
+func TestRecoverPanicsDisabled(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriver(client))
+	client.hook("Remove", func(name string) error { panic("boom") })
+
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Fatalf("expected the panic to propagate, got %v", r)
+		}
+	}()
+	d.Delete(context.Background(), "/a")
+	t.Fatal("expected Delete to panic")
+}
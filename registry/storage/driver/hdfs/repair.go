@@ -0,0 +1,108 @@
+package hdfs
+
+import (
+	"os"
+	"strings"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// Repairer is implemented by drivers that can put the directory structure
+// of their tree back in order after failures, such as the HDFS driver,
+// without external Hadoop tooling.
+type Repairer interface {
+	// Repair creates the registry directories missing below prefix and
+	// gives every directory below it directoryumask, or its mode in
+	// directorymodes, and every file the default file mode. It stops at
+	// the first error.
+	Repair(ctx context.Context, prefix string) error
+}
+
+// Repair implements Repairer. Directories the namenode refuses to list are
+// listed again once their mode is corrected, which recovers subtrees left
+// unreadable by a tool running with a stricter umask.
+func (d *Driver) Repair(ctx context.Context, prefix string) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.Repair(%q)", d.Name(), prefix)
+
+	prefix, err := d.inner().checkPath(prefix, true)
+	if err != nil {
+		return err
+	}
+	return d.inner().repair(ctx, prefix)
+}
+
+func (d *driver) repair(ctx context.Context, prefix string) error {
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	d = d.withOptions(ctx)
+	if err := d.writes.allow(); err != nil {
+		return err
+	}
+
+	// The layout directories the registry expects below prefix
+	for _, dir := range permissionDirectories {
+		subPath := "/" + dir
+		if prefix != "/" && !strings.HasPrefix(subPath+"/", prefix+"/") {
+			continue
+		}
+		if _, err := d.hdfsClient.Stat(d.fullPath(subPath)); os.IsNotExist(err) {
+			context.GetLogger(ctx).Infof("hdfs: repair: creating missing directory %s", subPath)
+			if err := d.mkdirAll(subPath); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	fullPath := d.fullPath(prefix)
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return storagedriver.PathNotFoundError{Path: prefix, DriverName: driverName}
+	} else if err != nil {
+		return err
+	}
+	if err := d.repairMode(ctx, prefix, fullPath, fi); err != nil || !fi.IsDir() {
+		return err
+	}
+
+	entries := make(map[string]listEntry)
+	list := func(dir string) ([]string, error) {
+		children, err := d.readEntries(ctx, dir)
+		if _, ok := err.(errUnreadableDirectory); ok {
+			// The mode was corrected when the directory was visited
+			children, err = d.readEntries(ctx, dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, len(children))
+		for i, child := range children {
+			paths[i] = child.path
+			entries[child.path] = child
+		}
+		return paths, nil
+	}
+	return walkTree(prefix, list, func(p string) (bool, error) {
+		entry := entries[p]
+		delete(entries, p)
+		return entry.info.IsDir(), d.repairMode(ctx, entry.path, entry.fullPath, entry.info)
+	})
+}
+
+// repairMode changes the mode of fullPath to the one the driver creates it
+// with if it differs
+func (d *driver) repairMode(ctx context.Context, subPath, fullPath string, fi os.FileInfo) error {
+	mode := os.FileMode(defaultFileMode)
+	if fi.IsDir() {
+		mode = d.directoryMode(fullPath)
+	}
+	if fi.Mode().Perm() == mode {
+		return nil
+	}
+	context.GetLogger(ctx).Infof("hdfs: repair: changing mode of %s from %#o to %#o", subPath, fi.Mode().Perm(), mode)
+	return d.hdfsClient.Chmod(fullPath, mode)
+}
@@ -0,0 +1,62 @@
+package hdfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestRepair(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/docker/registry/v2/blobs/sha256/ab/"+testDigestHex+"/data", []byte("layer"))
+	client.Chmod("/registry/docker/registry/v2/blobs/sha256/ab", 0700)
+	client.Chmod("/registry/docker/registry/v2/blobs/sha256/ab/"+testDigestHex+"/data", 0600)
+	d := wrap(newTestDriver(client))
+
+	if err := d.Repair(context.Background(), "/"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The repositories directory of the layout was missing
+	fi, err := client.Stat("/registry/docker/registry/v2/repositories")
+	if err != nil || !fi.IsDir() || fi.Mode().Perm() != defaultDirectoryUmask {
+		t.Fatalf("expected the repositories directory to be created, got %v, %v", fi, err)
+	}
+	for name, mode := range map[string]os.FileMode{
+		"/registry/docker/registry/v2/blobs/sha256/ab":                            defaultDirectoryUmask,
+		"/registry/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data": defaultFileMode,
+	} {
+		fi, err := client.Stat(name)
+		if err != nil || fi.Mode().Perm() != mode {
+			t.Fatalf("expected %s to get mode %#o, got %v, %v", name, mode, fi, err)
+		}
+	}
+
+	// A repaired tree is left alone
+	chmods := client.callCount("Chmod")
+	if err := d.Repair(context.Background(), "/"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := client.callCount("Chmod") - chmods; calls != 0 {
+		t.Fatalf("expected no changes to a repaired tree, got %d", calls)
+	}
+}
+
+func TestRepairPrefix(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/docker/registry/v2/blobs/sha256/ab/"+testDigestHex+"/data", []byte("layer"))
+	client.writeFile("/registry/other/file", []byte("other"))
+	client.Chmod("/registry/other/file", 0600)
+	d := wrap(newTestDriver(client))
+
+	if err := d.Repair(context.Background(), "/docker/registry/v2/blobs"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Stat("/registry/docker/registry/v2/repositories"); !os.IsNotExist(err) {
+		t.Fatalf("expected directories outside the prefix to be left alone, got %v", err)
+	}
+	if fi, _ := client.Stat("/registry/other/file"); fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected files outside the prefix to be left alone, got %#o", fi.Mode().Perm())
+	}
+}
@@ -0,0 +1,129 @@
+package hdfs
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// Replicator is implemented by drivers that can change the replication of
+// the files they already store, such as the HDFS driver. Files keep the
+// replication they were created with, so after datanodes were added or the
+// replication parameter changed, an operator walks the tree once:
+//
+//	if replicator, ok := driver.(hdfs.Replicator); ok {
+//		results, err := replicator.SetReplication(ctx, "/docker/registry/v2/blobs", 3)
+//	}
+type Replicator interface {
+	// SetReplication gives every file below prefix the replication factor,
+	// or with factor 0 the one the driver creates it with now, and returns
+	// the outcome for each file. Files that fail do not stop the walk; the
+	// error is only set when the tree cannot be walked.
+	SetReplication(ctx context.Context, prefix string, factor int) ([]ReplicationResult, error)
+}
+
+// ReplicationResult is the outcome of SetReplication for one file
+type ReplicationResult struct {
+	Path        string
+	Replication int
+	Err         error
+}
+
+// SetReplication implements Replicator. The namenode schedules the copies
+// or removals of replicas in the background, so the new factor may take a
+// while to show in fsck. With colinmarc/hdfs, which has no RPC to change
+// the replication, it needs WebHDFS configured.
+func (d *Driver) SetReplication(ctx context.Context, prefix string, factor int) ([]ReplicationResult, error) {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.SetReplication(%q, %d)", d.Name(), prefix, factor)
+
+	prefix, err := d.inner().checkPath(prefix, true)
+	if err != nil {
+		return nil, err
+	}
+	return d.inner().setTreeReplication(ctx, prefix, factor)
+}
+
+func (d *driver) setTreeReplication(ctx context.Context, prefix string, factor int) ([]ReplicationResult, error) {
+	if factor < 0 || factor > math.MaxInt16 {
+		return nil, fmt.Errorf("hdfs: the replication factor should be a number between 0 and %d, %d invalid", math.MaxInt16, factor)
+	}
+	if err := d.checkClient(); err != nil {
+		return nil, err
+	}
+	d = d.withOptions(ctx)
+	if err := d.writes.allow(); err != nil {
+		return nil, err
+	}
+	var results []ReplicationResult
+	setFile := func(subPath, fullPath string) error {
+		replication := factor
+		if replication == 0 {
+			replication = d.replicationFor(fullPath)
+		}
+		result := ReplicationResult{Path: subPath, Replication: replication}
+		if replication == 0 {
+			result.Err = fmt.Errorf("no replication factor given and the replication parameter is not set")
+		} else if result.Err = d.setReplication(fullPath, replication); result.Err == errWebHdfsRequired {
+			return fmt.Errorf("hdfs: SetReplication: %v", result.Err)
+		}
+		if result.Err != nil {
+			context.GetLogger(ctx).Warnf("hdfs: unable to set replication of %s to %d: %v", subPath, replication, result.Err)
+		}
+		results = append(results, result)
+		return nil
+	}
+
+	fullPath := d.fullPath(prefix)
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return nil, storagedriver.PathNotFoundError{Path: prefix, DriverName: driverName}
+	} else if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		if err := setFile(prefix, fullPath); err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+
+	entries := make(map[string]listEntry)
+	list := func(dir string) ([]string, error) {
+		children, err := d.readEntries(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, len(children))
+		for i, child := range children {
+			paths[i] = child.path
+			entries[child.path] = child
+		}
+		return paths, nil
+	}
+	err = walkTree(prefix, list, func(p string) (bool, error) {
+		entry := entries[p]
+		delete(entries, p)
+		if entry.info.IsDir() {
+			return true, nil
+		}
+		return false, setFile(entry.path, entry.fullPath)
+	})
+	return results, err
+}
+
+// setReplication changes the replication of the file at fullPath, through
+// WebHDFS when the client cannot
+func (d *driver) setReplication(fullPath string, replication int) error {
+	err := setReplication(d.hdfsClient, fullPath, replication)
+	if err == errUnsupportedByClient {
+		if d.webHdfs == nil {
+			return errWebHdfsRequired
+		}
+		err = d.webHdfs.SetReplication(fullPath, replication)
+	}
+	return err
+}
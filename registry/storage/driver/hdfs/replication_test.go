@@ -0,0 +1,100 @@
+package hdfs
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestSetReplicationBelowPrefix(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/ab/data", []byte("ab"))
+	client.writeFile("/registry/blobs/cd/ef/data", []byte("ef"))
+	client.writeFile("/registry/blobs/gh/data", []byte("gh"))
+	client.writeFile("/registry/repositories/link", []byte("link"))
+	client.hook("SetReplication", func(name string) error {
+		if name == "/registry/blobs/gh/data" {
+			return errors.New("permission denied")
+		}
+		return nil
+	})
+	d := wrap(newTestDriver(client))
+
+	results, err := d.SetReplication(context.Background(), "/blobs", 5)
+	if err != nil {
+		t.Fatalf("unexpected error from SetReplication: %v", err)
+	}
+	got := make(map[string]error)
+	for _, result := range results {
+		if result.Replication != 5 {
+			t.Fatalf("expected replication 5 for %s, got %d", result.Path, result.Replication)
+		}
+		got[result.Path] = result.Err
+	}
+	if len(got) != 3 || got["/blobs/ab/data"] != nil || got["/blobs/cd/ef/data"] != nil || got["/blobs/gh/data"] == nil {
+		t.Fatalf("expected a result for each file below the prefix, got %v", results)
+	}
+	if calls := client.callCount("SetReplication"); calls != 3 {
+		t.Fatalf("expected a SetReplication call per file, got %d", calls)
+	}
+	for _, name := range []string{"/registry/blobs/ab/data", "/registry/blobs/cd/ef/data"} {
+		if replication := client.replication(name); replication != 5 {
+			t.Fatalf("expected %s to get replication 5, got %d", name, replication)
+		}
+	}
+	if replication := client.replication("/registry/repositories/link"); replication != 0 {
+		t.Fatalf("expected files outside the prefix to be left alone, got %d", replication)
+	}
+}
+
+func TestSetReplicationDefaultsToParameter(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/ab/data", []byte("ab"))
+	ctx := context.Background()
+
+	d := wrap(newTestDriverWithParameters(client, DriverParameters{Replication: 2}))
+	results, err := d.SetReplication(ctx, "/blobs/ab/data", 0)
+	if err != nil || len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected result %v, %v", results, err)
+	}
+	if replication := client.replication("/registry/blobs/ab/data"); replication != 2 {
+		t.Fatalf("expected the replication parameter, got %d", replication)
+	}
+
+	// Without the parameter there is nothing to apply
+	results, err = wrap(newTestDriver(client)).SetReplication(ctx, "/blobs", 0)
+	if err != nil || len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("expected the file to fail without a factor, got %v, %v", results, err)
+	}
+}
+
+func TestSetReplicationThroughWebHdfs(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/ab/data", []byte("ab"))
+	server := httptest.NewServer(&fakeWebHdfs{namenode: client})
+	defer server.Close()
+
+	// colinmarc/hdfs cannot set the replication over RPC
+	d := wrap(newTestDriverWithParameters(basicClient{client}, DriverParameters{HdfsUser: "registry", WebHdfsAddress: server.URL}))
+	results, err := d.SetReplication(context.Background(), "/blobs", 4)
+	if err != nil || len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected result %v, %v", results, err)
+	}
+	if replication := client.replication("/registry/blobs/ab/data"); replication != 4 {
+		t.Fatalf("expected the replication to be set through WebHDFS, got %d", replication)
+	}
+}
+
+func TestSetReplicationRequiresWebHdfs(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/ab/data", []byte("ab"))
+	client.writeFile("/registry/blobs/cd/data", []byte("cd"))
+
+	d := wrap(newTestDriver(basicClient{client}))
+	if _, err := d.SetReplication(context.Background(), "/blobs", 4); err == nil || !strings.Contains(err.Error(), "hdfswebhdfsaddr") {
+		t.Fatalf("expected SetReplication to require WebHDFS, got %v", err)
+	}
+}
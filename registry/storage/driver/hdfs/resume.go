@@ -0,0 +1,85 @@
+package hdfs
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// AppendResumer is implemented by drivers that can resume appending to a
+// file at a known offset, such as the HDFS driver. An append interrupted
+// partway, e.g. by a network blip, may leave the start of a write the
+// upload session never accounted for at the end of the file; resuming
+// trims it before appending.
+type AppendResumer interface {
+	// ResumeWriter returns a FileWriter appending to path after its first
+	// offset bytes, dropping any that follow them. Its Size is offset.
+	ResumeWriter(ctx context.Context, path string, offset int64) (storagedriver.FileWriter, error)
+}
+
+// ResumeWriter implements AppendResumer. The excess is removed with the
+// namenode's truncate RPC, or with appendfallback by rewriting the file
+// when the client cannot issue it. A file shorter than offset lost data
+// and fails with an InvalidOffsetError.
+func (d *Driver) ResumeWriter(ctx context.Context, path string, offset int64) (storagedriver.FileWriter, error) {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.ResumeWriter(%q, %d)", d.Name(), path, offset)
+
+	path, err := d.inner().checkPath(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return d.inner().resumeWriter(ctx, path, offset)
+}
+
+func (d *driver) resumeWriter(ctx context.Context, subPath string, offset int64) (storagedriver.FileWriter, error) {
+	if err := d.checkClient(); err != nil {
+		return nil, err
+	}
+	d = d.withOptions(ctx)
+
+	fullPath := d.fullPath(subPath)
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if err != nil {
+		return nil, storagedriver.PathNotFoundError{Path: subPath, DriverName: driverName}
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("cannot write to %s: it is a directory", subPath)
+	}
+	if offset < 0 || offset > fi.Size() {
+		return nil, storagedriver.InvalidOffsetError{Path: subPath, Offset: offset, DriverName: driverName}
+	}
+	if offset < fi.Size() {
+		context.GetLogger(ctx).Warnf("hdfs: trimming %d bytes past offset %d of %s left by an interrupted append", fi.Size()-offset, offset, subPath)
+	}
+
+	err = d.truncateFile(ctx, subPath, offset)
+	if err == nil {
+		// Writer waits for the namenode to recover the last block
+		return d.Writer(ctx, subPath, true)
+	} else if !d.appendFallback {
+		return nil, err
+	}
+
+	existing, err := d.readAll(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.writes.allow(); err != nil {
+		return nil, err
+	}
+	if err := d.uploads.acquire(ctx); err != nil {
+		return nil, err
+	}
+	hdfsWriter, size, err := d.rewrite(fullPath, existing[:offset])
+	d.writes.record(err)
+	if err != nil {
+		d.uploads.release()
+		return nil, err
+	}
+	d.contentCache.invalidate(fullPath)
+	writer := d.keepModTime(d.newFileWriter(hdfsWriter, subPath, fullPath, size), fullPath, fi.ModTime())
+	d.uploads.handOff(writer, nil)
+	return writer, nil
+}
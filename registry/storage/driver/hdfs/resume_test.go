@@ -0,0 +1,80 @@
+package hdfs
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestResumeWriterTrimsInterruptedAppend(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		client func(c *fakeClient) hdfsClient
+		params DriverParameters
+	}{
+		{"truncate", func(c *fakeClient) hdfsClient { return c }, DriverParameters{}},
+		{"appendfallback", func(c *fakeClient) hdfsClient { return basicClient{c} }, DriverParameters{AppendFallback: true}},
+	} {
+		client := newFakeClient()
+		// The session accounted for 6 bytes, the interrupted append left 4 more
+		client.writeFile("/registry/upload/data", []byte("012345abcd"))
+		d := wrap(newTestDriverWithParameters(tc.client(client), tc.params))
+		ctx := context.Background()
+
+		var resumer AppendResumer = d
+		writer, err := resumer.ResumeWriter(ctx, "/upload/data", 6)
+		if err != nil {
+			t.Fatalf("%s: unexpected error from ResumeWriter: %v", tc.name, err)
+		}
+		if size := writer.Size(); size != 6 {
+			t.Fatalf("%s: expected the writer to continue at 6, got %d", tc.name, size)
+		}
+		if _, err := writer.Write([]byte("6789")); err != nil {
+			t.Fatalf("%s: unexpected error writing: %v", tc.name, err)
+		}
+		if err := writer.Commit(); err != nil {
+			t.Fatalf("%s: unexpected error from Commit: %v", tc.name, err)
+		}
+		writer.Close()
+
+		contents, err := d.GetContent(ctx, "/upload/data")
+		if err != nil || string(contents) != "0123456789" {
+			t.Fatalf("%s: expected the excess to be trimmed before appending, got %q, %v", tc.name, contents, err)
+		}
+	}
+}
+
+func TestResumeWriterOffsets(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/upload/data", []byte("012345"))
+	d := wrap(newTestDriver(client))
+	ctx := context.Background()
+
+	// Nothing to trim
+	writer, err := d.ResumeWriter(ctx, "/upload/data", 6)
+	if err != nil || writer.Size() != 6 {
+		t.Fatalf("expected to resume at 6, got %v", err)
+	}
+	writer.Close()
+	if calls := client.callCount("Truncate"); calls != 0 {
+		t.Fatalf("expected no truncate, got %d", calls)
+	}
+
+	// Bytes the session accounted for are missing
+	if _, err := d.ResumeWriter(ctx, "/upload/data", 7); err == nil {
+		t.Fatal("expected resuming past the end to fail")
+	} else if _, ok := err.(storagedriver.InvalidOffsetError); !ok {
+		t.Fatalf("expected InvalidOffsetError, got %v", err)
+	}
+	if _, err := d.ResumeWriter(ctx, "/upload/missing", 0); !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+
+	// Without truncate or appendfallback the excess cannot be removed
+	d = wrap(newTestDriver(basicClient{client}))
+	client.writeFile("/registry/upload/data", []byte("012345abcd"))
+	if _, err := d.ResumeWriter(ctx, "/upload/data", 6); err == nil {
+		t.Fatal("expected trimming without truncate to fail")
+	}
+}
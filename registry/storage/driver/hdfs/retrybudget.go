@@ -0,0 +1,45 @@
+package hdfs
+
+import (
+	"time"
+)
+
+// operationBudget bounds the time one driver operation spends waiting to
+// retry. Without it, an operation whose sub-RPCs each retry on their own,
+// such as a PutContent appending through lease recovery, waits for every
+// one of them in turn; with retrybudget set they draw from one deadline
+// instead, so the operation fails once it is spent.
+type operationBudget struct {
+	deadline time.Time
+}
+
+// withRetryBudget returns the driver to perform an operation with: d itself
+// when retrybudget is not set or d is already within an operation, such as
+// the Writer of a PutContent, or a copy with a budget of its own.
+func (d *driver) withRetryBudget() *driver {
+	if d.retryBudget <= 0 || d.budget != nil {
+		return d
+	}
+	o := *d
+	o.budget = &operationBudget{deadline: time.Now().Add(d.retryBudget)}
+	return &o
+}
+
+// sleep waits delay before a retry and returns true, or returns false right
+// away when the retry would end past the deadline. A nil budget always
+// waits.
+func (b *operationBudget) sleep(delay time.Duration) bool {
+	if b != nil && time.Now().Add(delay).After(b.deadline) {
+		return false
+	}
+	time.Sleep(delay)
+	return true
+}
+
+// limit returns deadline, or the deadline of the budget when that is sooner
+func (b *operationBudget) limit(deadline time.Time) time.Time {
+	if b != nil && b.deadline.Before(deadline) {
+		return b.deadline
+	}
+	return deadline
+}
@@ -0,0 +1,63 @@
+package hdfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestRetryBudgetBoundsLeaseRecovery(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/upload/data", []byte("abc"))
+	holdLease(client, 1<<30)
+	d := newTestDriverWithParameters(client, DriverParameters{LeaseTimeout: time.Minute, RetryBudget: 100 * time.Millisecond})
+	d.leaseRecoveryInterval = 10 * time.Millisecond
+
+	start := time.Now()
+	if _, err := d.Writer(context.Background(), "/upload/data", true); err == nil {
+		t.Fatal("expected the append to fail while the lease is held")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the retries to stop within the budget, took %v", elapsed)
+	}
+	if calls := client.callCount("Append"); calls < 2 {
+		t.Fatalf("expected the append to be retried within the budget, got %d appends", calls)
+	}
+}
+
+func TestRetryBudgetSharedWithinOperation(t *testing.T) {
+	d := newTestDriverWithParameters(newFakeClient(), DriverParameters{RetryBudget: time.Minute})
+
+	o := d.withRetryBudget()
+	if o == d || o.budget == nil {
+		t.Fatal("expected an operation to get a budget of its own")
+	}
+	if o.withRetryBudget() != o || o.withOptions(WithClientOptions(context.Background(), ClientOptions{})).budget != o.budget {
+		t.Fatal("expected the sub-operations to share the budget of their operation")
+	}
+	if d.budget != nil {
+		t.Fatal("expected the driver itself to be left without a budget")
+	}
+
+	// A spent budget refuses further retries
+	o.budget.deadline = time.Now()
+	if o.budget.sleep(time.Millisecond) {
+		t.Fatal("expected a spent budget to refuse the retry")
+	}
+	if !(*operationBudget)(nil).sleep(time.Millisecond) {
+		t.Fatal("expected no budget to always retry")
+	}
+}
+
+func TestRetryBudgetBoundsListRetries(t *testing.T) {
+	client := newFakeClient()
+	client.MkdirAll("/registry/empty", 0755)
+	d := newTestDriverWithParameters(client, DriverParameters{ListRetries: 10, ListRetryDelay: 50 * time.Millisecond, RetryBudget: 120 * time.Millisecond})
+
+	start := time.Now()
+	d.List(context.Background(), "/empty")
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Fatalf("expected the rereads to stop within the budget, took %v", elapsed)
+	}
+}
@@ -0,0 +1,117 @@
+package hdfs
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/uuid"
+)
+
+// SelfTester is implemented by drivers that can check they work end to end
+// against their storage, such as the HDFS driver. Misconfigured clusters
+// often answer the namenode calls and only fail once data moves, for
+// example when the datanodes advertise hostnames the registry cannot
+// resolve, which a stat of the root directory does not catch.
+type SelfTester interface {
+	// SelfTest writes a small file, reads it back, appends to it, reads
+	// it again and deletes it, verifying every step. It returns a
+	// SelfTestError for the first step that fails.
+	SelfTest(ctx context.Context) error
+}
+
+// SelfTestError is returned by SelfTest when one of its steps fails
+type SelfTestError struct {
+	Step string
+	Path string
+	Err  error
+}
+
+func (e SelfTestError) Error() string {
+	return fmt.Sprintf("hdfs: self-test failed to %s %s: %v", e.Step, e.Path, e.Err)
+}
+
+// SelfTest implements SelfTester. Every step is logged as it succeeds. The
+// file is written at the top of the root directory with the name of a
+// staging file, which List hides, and removed again if a step fails.
+func (d *Driver) SelfTest(ctx context.Context) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.SelfTest()", d.Name())
+
+	return d.inner().selfTest(ctx)
+}
+
+func (d *driver) selfTest(ctx context.Context) error {
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	subPath := "/" + stagingFilePrefix + "selftest-" + d.instanceTag + "-" + uuid.Generate().String()
+	first := []byte("registry self-test " + path.Base(subPath) + "\n")
+	second := []byte("appended\n")
+	deleted := false
+	defer func() {
+		if !deleted {
+			d.Delete(ctx, subPath)
+		}
+	}()
+
+	step := func(name string, f func() error) error {
+		if err := f(); err != nil {
+			return SelfTestError{Step: name, Path: d.fullPath(subPath), Err: err}
+		}
+		context.GetLogger(ctx).Infof("hdfs: self-test: %s %s: ok", name, d.fullPath(subPath))
+		return nil
+	}
+	verify := func(want []byte) func() error {
+		return func() error {
+			contents, err := d.GetContent(ctx, subPath)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(contents, want) {
+				return fmt.Errorf("read %d bytes that differ from the %d written", len(contents), len(want))
+			}
+			return nil
+		}
+	}
+
+	if err := step("write", func() error { return d.PutContent(ctx, subPath, first) }); err != nil {
+		return err
+	}
+	if err := step("read", verify(first)); err != nil {
+		return err
+	}
+	if err := step("append to", func() error {
+		writer, err := d.Writer(ctx, subPath, true)
+		if err != nil {
+			return err
+		}
+		defer writer.Close()
+		if _, err := writer.Write(second); err != nil {
+			return err
+		}
+		return writer.Commit()
+	}); err != nil {
+		return err
+	}
+	if err := step("read back", verify(append(append([]byte(nil), first...), second...))); err != nil {
+		return err
+	}
+	if err := step("delete", func() error {
+		if err := d.Delete(ctx, subPath); err != nil {
+			return err
+		}
+		deleted = true
+		if _, err := d.Stat(ctx, subPath); err == nil {
+			return fmt.Errorf("the file still exists")
+		} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return nil
+}
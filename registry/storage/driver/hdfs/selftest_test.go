@@ -0,0 +1,49 @@
+package hdfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestSelfTest(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriver(client))
+
+	tester, ok := storagedriver.StorageDriver(d).(SelfTester)
+	if !ok {
+		t.Fatal("expected the driver to implement SelfTester")
+	}
+	if err := tester.SelfTest(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := client.callCount("Append"); calls != 1 {
+		t.Fatalf("expected the self-test to append once, got %d", calls)
+	}
+	if entries, err := client.ReadDir("/registry"); err != nil || len(entries) != 0 {
+		t.Fatalf("expected the self-test to clean up, got %v, %v", entries, err)
+	}
+}
+
+func TestSelfTestFailingStep(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriver(client))
+	client.failWith("Append", errors.New("Failed to replace a bad datanode on the existing pipeline"))
+
+	err := d.SelfTest(context.Background())
+	failure, ok := err.(SelfTestError)
+	if !ok {
+		t.Fatalf("expected a SelfTestError, got %v", err)
+	}
+	if failure.Step != "append to" || !strings.Contains(err.Error(), "bad datanode") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The file written before the failing step is removed
+	if entries, err := client.ReadDir("/registry"); err != nil || len(entries) != 0 {
+		t.Fatalf("expected the self-test to clean up, got %v, %v", entries, err)
+	}
+}
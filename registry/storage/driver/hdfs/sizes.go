@@ -0,0 +1,97 @@
+package hdfs
+
+import (
+	"os"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// SizeLister is implemented by drivers that can report the size of every
+// file in a tree at once, such as the HDFS driver. The mark phase of garbage
+// collection, which needs to know which blobs exist and how large they are,
+// can use it instead of a Stat per blob:
+//
+//	if lister, ok := driver.(hdfs.SizeLister); ok {
+//		sizes, err := lister.ListSizes(ctx, "/docker/registry/v2/blobs")
+//		...
+//	}
+type SizeLister interface {
+	// ListSizes returns the size of every file below prefix, or of
+	// prefix itself if it is a file, by path. Directories that cannot be
+	// read are errors rather than missing from the result.
+	ListSizes(ctx context.Context, prefix string) (map[string]int64, error)
+}
+
+// ListSizes implements SizeLister. The sizes come from the listings of the
+// directories, a single ReadDir each, with one Stat of prefix; with
+// compression every compressed file is also opened to read its
+// uncompressed size, as List and Stat do.
+func (d *Driver) ListSizes(ctx context.Context, prefix string) (map[string]int64, error) {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.ListSizes(%q)", d.Name(), prefix)
+
+	prefix, err := d.inner().checkPath(prefix, true)
+	if err != nil {
+		return nil, err
+	}
+	return d.inner().listSizes(ctx, prefix)
+}
+
+func (d *driver) listSizes(ctx context.Context, prefix string) (map[string]int64, error) {
+	if err := d.checkClient(); err != nil {
+		return nil, err
+	}
+	d = d.withOptions(ctx)
+	fullPath, err := d.readPath(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return nil, storagedriver.PathNotFoundError{Path: prefix, DriverName: driverName}
+	} else if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64)
+	if !fi.IsDir() {
+		infos, err := d.entryInfos([]listEntry{{path: prefix, fullPath: fullPath, info: fi}})
+		if err != nil {
+			return nil, err
+		}
+		sizes[prefix] = infos[0].Size()
+		return sizes, nil
+	}
+
+	// Directories are listed by where they are stored, which the path
+	// transform may have moved them to, without a Stat of their own
+	pending := []listEntry{{path: prefix, fullPath: fullPath, info: fi}}
+	for len(pending) > 0 {
+		dir := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		entries, err := d.readDirEntries(dir.path, dir.fullPath, true)
+		if unreadable, ok := err.(errUnreadableDirectory); ok {
+			return nil, unreadable.err
+		} else if err != nil {
+			return nil, err
+		}
+		var files []listEntry
+		for _, entry := range entries {
+			if entry.info.IsDir() {
+				pending = append(pending, entry)
+			} else {
+				files = append(files, entry)
+			}
+		}
+		infos, err := d.entryInfos(files)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			sizes[info.Path()] = info.Size()
+		}
+	}
+	return sizes, nil
+}
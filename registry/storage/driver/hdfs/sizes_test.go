@@ -0,0 +1,87 @@
+package hdfs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestListSizesMatchesStat(t *testing.T) {
+	client := newFakeClient()
+	var expected []string
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 5; j++ {
+			p := fmt.Sprintf("/blobs/sha256/%02d/%d/data", i, j)
+			client.writeFile("/registry"+p, make([]byte, i*100+j))
+			expected = append(expected, p)
+		}
+	}
+	client.writeFile("/registry/blobs/sha256/loose", []byte("loose"))
+	expected = append(expected, "/blobs/sha256/loose")
+	var sd storagedriver.StorageDriver = wrap(newTestDriver(client))
+	ctx := context.Background()
+
+	lister, ok := sd.(SizeLister)
+	if !ok {
+		t.Fatal("expected the driver to implement SizeLister")
+	}
+	stats, readDirs := client.callCount("Stat"), client.callCount("ReadDir")
+	sizes, err := lister.ListSizes(ctx, "/blobs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// One Stat of the prefix and a ReadDir for each of its 26 directories:
+	// blobs, sha256, the 4 prefixes and their 20 blob directories
+	if calls := client.callCount("Stat") - stats; calls != 1 {
+		t.Fatalf("expected a single Stat, got %d", calls)
+	}
+	if calls := client.callCount("ReadDir") - readDirs; calls != 26 {
+		t.Fatalf("expected 26 ReadDirs, got %d", calls)
+	}
+
+	if len(sizes) != len(expected) {
+		t.Fatalf("expected %d sizes, got %d: %v", len(expected), len(sizes), sizes)
+	}
+	for _, p := range expected {
+		fi, err := sd.Stat(ctx, p)
+		if err != nil {
+			t.Fatalf("unexpected error from Stat: %v", err)
+		}
+		if size, ok := sizes[p]; !ok || size != fi.Size() {
+			t.Errorf("%s: expected size %d, got %d (%v)", p, fi.Size(), size, ok)
+		}
+	}
+
+	// A file is its own tree
+	if sizes, err := lister.ListSizes(ctx, "/blobs/sha256/loose"); err != nil || len(sizes) != 1 || sizes["/blobs/sha256/loose"] != 5 {
+		t.Fatalf("expected the size of the file, got %v, %v", sizes, err)
+	}
+	if _, err := lister.ListSizes(ctx, "/missing"); err == nil {
+		t.Fatal("expected a missing prefix to be an error")
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+}
+
+func TestListSizesUnreadableDirectory(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/a/data", []byte("a"))
+	client.writeFile("/registry/blobs/b/data", []byte("b"))
+	d := wrap(newTestDriver(client))
+	denied := errors.New("Permission denied: user=registry, access=READ_EXECUTE, inode=/registry/blobs/b")
+	client.hook("ReadDir", func(name string) error {
+		if name == "/registry/blobs/b" {
+			return denied
+		}
+		return nil
+	})
+
+	// A directory that cannot be read is not a directory without blobs
+	if _, err := d.ListSizes(context.Background(), "/blobs"); err == nil {
+		t.Fatal("expected an unreadable directory to fail ListSizes")
+	}
+}
@@ -0,0 +1,38 @@
+package hdfs
+
+import (
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// slowOpLog logs the operations that take slowoplog or longer as warnings,
+// with their path and duration, to show namenode and datanode slowness in
+// the registry's own log. Reader and Writer count until the file is open;
+// the transfers through it are not included. A nil slowOpLog logs nothing.
+type slowOpLog struct {
+	threshold time.Duration
+}
+
+func newSlowOpLog(threshold time.Duration) *slowOpLog {
+	if threshold <= 0 {
+		return nil
+	}
+	return &slowOpLog{threshold: threshold}
+}
+
+// log is deferred by the driver methods with the time they started
+func (l *slowOpLog) log(ctx context.Context, op, path string, start time.Time) {
+	if l == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	if elapsed < l.threshold {
+		return
+	}
+	context.GetLoggerWithFields(ctx, map[interface{}]interface{}{
+		"hdfs.op":       op,
+		"hdfs.path":     path,
+		"hdfs.duration": elapsed,
+	}).Warnf("hdfs: slow %s of %s took %v", op, path, elapsed)
+}
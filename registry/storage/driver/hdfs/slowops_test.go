@@ -0,0 +1,33 @@
+package hdfs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowOpLog(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/a", []byte("a"))
+	d := newTestDriverWithParameters(client, DriverParameters{SlowOpLog: 20 * time.Millisecond})
+	ctx, buf := newLogContext()
+
+	if _, err := d.Stat(ctx, "/a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected a fast Stat not to be logged, got %q", buf.String())
+	}
+
+	client.hook("Stat", func(string) error {
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	})
+	if _, err := d.Stat(ctx, "/a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "level=warning") || !strings.Contains(out, "slow Stat of /a") || !strings.Contains(out, "hdfs.duration=") {
+		t.Fatalf("expected the slow Stat to be logged, got %q", out)
+	}
+}
@@ -0,0 +1,51 @@
+package hdfs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/context"
+)
+
+// snapshotDir is the directory through which HDFS exposes the snapshots
+// of a snapshottable directory
+const snapshotDir = ".snapshot"
+
+type snapshotKey struct{}
+
+// WithSnapshot returns a context that makes the reads of the HDFS driver
+// (GetContent, Reader, Stat and List) see the named snapshot of the root
+// directory instead of the live tree. It overrides the snapshot parameter;
+// an empty name reads the live tree. Writes always go to the live tree.
+func WithSnapshot(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, name)
+}
+
+func validateSnapshotName(name string) error {
+	if strings.Contains(name, "/") || name == "." || name == ".." {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return nil
+}
+
+// readPath returns the HDFS path reads of subPath go to: the full path in
+// the snapshot selected by ctx or the snapshot parameter, if any.
+func (d *driver) readPath(ctx context.Context, subPath string) (string, error) {
+	fullPath := d.fullPath(subPath)
+
+	snapshot := d.snapshot
+	if name, ok := ctx.Value(snapshotKey{}).(string); ok {
+		snapshot = name
+	}
+	// Upload session metadata lives outside the snapshotted root
+	if snapshot == "" || d.inUploadStateDirectory(fullPath) {
+		return fullPath, nil
+	}
+	if err := validateSnapshotName(snapshot); err != nil {
+		return "", err
+	}
+
+	relative := strings.TrimPrefix(strings.TrimPrefix(fullPath, d.hdfsRootDirectory), "/")
+	return path.Join(d.hdfsRootDirectory, snapshotDir, snapshot, relative), nil
+}
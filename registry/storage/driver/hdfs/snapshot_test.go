@@ -0,0 +1,69 @@
+package hdfs
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestSnapshotReads(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(client)
+	client.writeFile("/registry/repo/tag", []byte("live"))
+	client.writeFile("/registry/.snapshot/nightly/repo/tag", []byte("snapshot"))
+
+	ctx := WithSnapshot(context.Background(), "nightly")
+
+	contents, err := d.GetContent(ctx, "/repo/tag")
+	if err != nil || string(contents) != "snapshot" {
+		t.Fatalf("expected GetContent to read the snapshot, got %q, %v", contents, err)
+	}
+	reader, err := d.Reader(ctx, "/repo/tag", 4)
+	if err != nil {
+		t.Fatalf("unexpected error from Reader: %v", err)
+	}
+	contents, _ = ioutil.ReadAll(reader)
+	reader.Close()
+	if string(contents) != "shot" {
+		t.Fatalf("expected Reader to read the snapshot, got %q", contents)
+	}
+	fi, err := d.Stat(ctx, "/repo/tag")
+	if err != nil || fi.Size() != int64(len("snapshot")) {
+		t.Fatalf("expected Stat to describe the snapshot, got %+v, %v", fi, err)
+	}
+	names, err := d.List(ctx, "/repo")
+	if err != nil || !reflect.DeepEqual(names, []string{"/repo/tag"}) {
+		t.Fatalf("expected List to return logical paths, got %v, %v", names, err)
+	}
+
+	// Writes stay on the live tree
+	if err := d.PutContent(ctx, "/repo/tag", []byte("updated")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if live, _ := client.ReadFile("/registry/repo/tag"); string(live) != "updated" {
+		t.Fatalf("expected the live file to be written, got %q", live)
+	}
+	if snap, _ := client.ReadFile("/registry/.snapshot/nightly/repo/tag"); string(snap) != "snapshot" {
+		t.Fatalf("expected the snapshot to be untouched, got %q", snap)
+	}
+}
+
+func TestSnapshotParameter(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{Snapshot: "nightly"})
+	client.writeFile("/registry/tag", []byte("live"))
+	client.writeFile("/registry/.snapshot/nightly/tag", []byte("snapshot"))
+
+	if contents, _ := d.GetContent(context.Background(), "/tag"); string(contents) != "snapshot" {
+		t.Fatalf("expected the snapshot parameter to redirect reads, got %q", contents)
+	}
+	// The context can switch back to the live tree
+	if contents, _ := d.GetContent(WithSnapshot(context.Background(), ""), "/tag"); string(contents) != "live" {
+		t.Fatalf("expected an empty snapshot to read the live tree, got %q", contents)
+	}
+	if _, err := d.GetContent(WithSnapshot(context.Background(), "../x"), "/tag"); err == nil {
+		t.Fatalf("expected an invalid snapshot name to be rejected")
+	}
+}
@@ -0,0 +1,84 @@
+package hdfs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Reads and writes of a datanode connection are bounded separately, like
+// dfs.client.socket-timeout and dfs.datanode.socket.write.timeout bound them
+// in the Java client: readtimeout is how long a connection may wait for the
+// datanode to send anything, block data or the acknowledgements of a
+// write, and writetimeout how long sending to it may block. Both default to
+// sockettimeout, which usehadoopenv takes from dfs.client.socket-timeout.
+// Namenode connections are not affected, RPCs such as a recursive delete
+// may legitimately take longer than any socket timeout; dialtimeout still
+// bounds connecting to them.
+
+// socketTimeoutProperty is the Hadoop property usehadoopenv takes
+// sockettimeout from, in milliseconds
+const socketTimeoutProperty = "dfs.client.socket-timeout"
+
+// parseSocketTimeout parses a dfs.client.socket-timeout value
+func parseSocketTimeout(value string) (time.Duration, error) {
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if millis < 0 {
+		return 0, fmt.Errorf("negative timeout %d", millis)
+	}
+	return time.Duration(millis) * time.Millisecond, nil
+}
+
+// socketTimeouts returns the read and write timeouts params configure
+func socketTimeouts(params DriverParameters) (read, write time.Duration) {
+	read, write = params.ReadTimeout, params.WriteTimeout
+	if read == 0 {
+		read = params.SocketTimeout
+	}
+	if write == 0 {
+		write = params.SocketTimeout
+	}
+	return read, write
+}
+
+// timeoutConn moves the deadlines of its connection before every read and
+// write
+type timeoutConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	if c.readTimeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	return c.Conn.Write(p)
+}
+
+// withSocketTimeouts returns dial with the connections it makes bounded by
+// readTimeout and writeTimeout, or dial itself when neither is set
+func withSocketTimeouts(dial dialFunc, readTimeout, writeTimeout time.Duration) dialFunc {
+	if readTimeout <= 0 && writeTimeout <= 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &timeoutConn{Conn: conn, readTimeout: readTimeout, writeTimeout: writeTimeout}, nil
+	}
+}
@@ -0,0 +1,87 @@
+package hdfs
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// dialTestListener dials a listener that accepts and holds connections
+func dialTestListener(t *testing.T, dial func(ctx context.Context, network, address string) (net.Conn, error)) net.Conn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	conn, err := dial(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error dialing: %v", err)
+	}
+	return conn
+}
+
+func TestClientOptionsSocketTimeouts(t *testing.T) {
+	params := DefaultParameters()
+	params.SocketTimeout = time.Minute
+	params.ReadTimeout = 30 * time.Second
+	options, _, err := clientOptions(params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if options.NamenodeDialFunc != nil {
+		t.Fatal("expected namenode connections to be left alone")
+	}
+
+	conn := dialTestListener(t, options.DatanodeDialFunc)
+	defer conn.Close()
+	timeouts, ok := conn.(*timeoutConn)
+	if !ok {
+		t.Fatalf("expected datanode connections to have timeouts, got %T", conn)
+	}
+	if timeouts.readTimeout != 30*time.Second || timeouts.writeTimeout != time.Minute {
+		t.Fatalf("expected readtimeout to override sockettimeout for reads only, got %v and %v", timeouts.readTimeout, timeouts.writeTimeout)
+	}
+
+	params.SocketTimeout, params.ReadTimeout, params.WriteTimeout = 0, 0, 0
+	if options, _, _ := clientOptions(params); options.DatanodeDialFunc != nil {
+		t.Fatal("expected no datanode dial function without timeouts")
+	}
+}
+
+func TestReadTimeoutExpires(t *testing.T) {
+	dialer := &net.Dialer{}
+	conn := dialTestListener(t, withSocketTimeouts(dialer.DialContext, 50*time.Millisecond, 0))
+	defer conn.Close()
+
+	start := time.Now()
+	_, err := conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("expected the read to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the read to time out after readtimeout, took %v", elapsed)
+	}
+}
+
+func TestHadoopEnvironmentSocketTimeout(t *testing.T) {
+	defer withHadoopConfDir(t, map[string]string{
+		"core-site.xml": testCoreSite,
+		"hdfs-site.xml": `<configuration><property><name>dfs.client.socket-timeout</name><value>90000</value></property></configuration>`,
+	})()
+
+	params := DriverParameters{}
+	if err := applyHadoopEnvironment(&params); err != nil {
+		t.Fatalf("unexpected error loading Hadoop configuration: %v", err)
+	}
+	if params.SocketTimeout != 90*time.Second {
+		t.Fatalf("expected sockettimeout from dfs.client.socket-timeout, got %v", params.SocketTimeout)
+	}
+}
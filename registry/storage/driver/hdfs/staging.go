@@ -0,0 +1,150 @@
+package hdfs
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/uuid"
+)
+
+// The stagingstrategy parameter decides how PutContent gets its content
+// into place. memory writes the buffered content straight to its path,
+// which costs a single create but lets readers see a partial object while
+// it is written. tempfile writes it to a temporary file next to the path
+// first and renames that over the path, so readers only ever see complete
+// objects, at the cost of a rename per object. auto uses tempfile for
+// content of stagingthreshold bytes or more, where a partial read is most
+// likely and the rename matters least.
+const (
+	stagingMemory   = "memory"
+	stagingTempFile = "tempfile"
+	stagingAuto     = "auto"
+
+	defaultStagingThreshold = 1 << 20
+)
+
+var stagingStrategies = []string{stagingMemory, stagingTempFile, stagingAuto}
+
+// stagingFilePrefix starts the names of temporary files. Driver paths
+// cannot start a component with a dot, so they never collide with objects
+// and are left out of List.
+const stagingFilePrefix = ".staging-"
+
+func validateStagingStrategy(strategy string) error {
+	for _, valid := range stagingStrategies {
+		if strategy == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("The stagingstrategy parameter must be one of %v, %q invalid", stagingStrategies, strategy)
+}
+
+// isStagingFile reports whether name is a temporary PutContent file
+func isStagingFile(name string) bool {
+	return strings.HasPrefix(name, stagingFilePrefix)
+}
+
+// stagingPath returns a new temporary file name for fullPath. Registries
+// sharing a root stage in the same directories, so besides a random part
+// the name carries the instanceTag of the registry writing it.
+func (d *driver) stagingPath(fullPath string) string {
+	return path.Join(path.Dir(fullPath), stagingFilePrefix+path.Base(fullPath)+"-"+d.instanceTag+"-"+uuid.Generate().String())
+}
+
+// newInstanceTag names this registry in temporary files: the host name and
+// the instanceid parameter, or the instance id of the process without it.
+// Characters HDFS or the driver paths may not like are replaced.
+func newInstanceTag(hostname, instanceID string) string {
+	if instanceID == "" {
+		instanceID = fmt.Sprint(context.Background().Value("instance.id"))
+	}
+	sanitize := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+				return r
+			}
+			return '_'
+		}, s)
+	}
+	return sanitize(hostname) + "-" + sanitize(instanceID)
+}
+
+// stagesInTempFile reports whether PutContent of size bytes goes through a
+// temporary file
+func (d *driver) stagesInTempFile(size int) bool {
+	switch d.stagingStrategy {
+	case stagingTempFile:
+		return true
+	case stagingAuto:
+		return int64(size) >= d.stagingThreshold
+	}
+	return false
+}
+
+// putContentStaged writes contents to a temporary file in the directory of
+// fullPath and renames it into place
+func (d *driver) putContentStaged(context context.Context, subPath, fullPath string, contents []byte, attrs map[string][]byte) error {
+	return d.putStaged(context, subPath, fullPath, int64(len(contents)), attrs, func(w io.Writer) error {
+		if len(contents) == 0 {
+			return nil
+		}
+		_, err := (&countingWriter{Writer: w, op: "PutContent"}).Write(contents)
+		return err
+	})
+}
+
+// putStaged has write put size bytes into a temporary file in the
+// directory of fullPath, gives it the extended attributes attrs and renames
+// it into place
+func (d *driver) putStaged(context context.Context, subPath, fullPath string, size int64, attrs map[string][]byte, write func(w io.Writer) error) error {
+	d = d.withOptions(context)
+	if err := d.writes.allow(); err != nil {
+		return err
+	}
+	if err := d.freeSpace.allow(); err != nil {
+		return err
+	}
+	if err := d.uploads.acquire(context); err != nil {
+		return err
+	}
+	defer d.uploads.release()
+
+	if err := d.checkNoClobber(subPath, fullPath); err != nil {
+		return err
+	}
+	if err := d.reserveQuota(subPath, size); err != nil {
+		return err
+	}
+
+	staged := d.stagingPath(fullPath)
+	writer, err := d.create(staged)
+	d.writes.record(err)
+	if err != nil {
+		d.releaseQuota(subPath, size)
+		return err
+	}
+
+	err = write(d.throttleWriter(writer))
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	for attr, value := range attrs {
+		if err == nil {
+			err = d.setXAttr(staged, attr, value)
+		}
+	}
+	if err == nil {
+		err = d.hdfsClient.Rename(staged, fullPath)
+		d.contentCache.invalidate(fullPath)
+	}
+	d.writes.record(err)
+	if err != nil {
+		d.hdfsClient.Remove(staged)
+		d.releaseQuota(subPath, size)
+		return err
+	}
+	return nil
+}
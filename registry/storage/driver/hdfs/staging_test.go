@@ -0,0 +1,114 @@
+package hdfs
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestStagingStrategies(t *testing.T) {
+	small := []byte("small")
+	large := bytes.Repeat([]byte("large"), 1000)
+	for _, tc := range []struct {
+		strategy string
+		renames  int
+	}{
+		// The overwrite renames a new file over the object in place
+		{stagingMemory, 1},
+		{stagingTempFile, 2},
+		{stagingAuto, 1},
+	} {
+		client := newFakeClient()
+		d := newTestDriverWithParameters(client, DriverParameters{StagingStrategy: tc.strategy, StagingThreshold: 1024})
+		ctx := context.Background()
+
+		for _, contents := range [][]byte{small, large} {
+			if err := d.PutContent(ctx, "/dir/object", contents); err != nil {
+				t.Fatalf("%s: unexpected error: %v", tc.strategy, err)
+			}
+			read, err := d.GetContent(ctx, "/dir/object")
+			if err != nil || !bytes.Equal(read, contents) {
+				t.Fatalf("%s: unexpected content after PutContent of %d bytes: %v", tc.strategy, len(contents), err)
+			}
+		}
+		if renames := client.callCount("Rename"); renames != tc.renames {
+			t.Fatalf("%s: expected %d renames, got %d", tc.strategy, tc.renames, renames)
+		}
+		entries, err := d.List(ctx, "/dir")
+		if err != nil || !reflect.DeepEqual(entries, []string{"/dir/object"}) {
+			t.Fatalf("%s: unexpected entries %v, %v", tc.strategy, entries, err)
+		}
+	}
+}
+
+func TestTempFileStagingFailureKeepsObject(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{StagingStrategy: stagingTempFile})
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/dir/object", []byte("old")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client.failWith("Rename", errors.New("rename failed"))
+	if err := d.PutContent(ctx, "/dir/object", []byte("new")); err == nil {
+		t.Fatal("expected the failed rename to be returned")
+	}
+
+	if read, _ := d.GetContent(ctx, "/dir/object"); string(read) != "old" {
+		t.Fatalf("expected the previous content to survive, got %q", read)
+	}
+	infos, err := client.ReadDir("/registry/dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, fi := range infos {
+		if strings.HasPrefix(fi.Name(), stagingFilePrefix) {
+			t.Fatalf("temporary file %s was left behind", fi.Name())
+		}
+	}
+}
+
+func TestStagingPathsAreUniqueAcrossInstances(t *testing.T) {
+	const fullPath = "/registry/docker/registry/v2/repositories/app/_layers/sha256/abcd/link"
+
+	// Instances on one host, sharing an instanceid by mistake, and on
+	// another host
+	instances := []*driver{
+		newTestDriverWithParameters(newFakeClient(), DriverParameters{InstanceID: "registry-a"}),
+		newTestDriverWithParameters(newFakeClient(), DriverParameters{InstanceID: "registry-a"}),
+		newTestDriverWithParameters(newFakeClient(), DriverParameters{}),
+	}
+	instances[2].instanceTag = newInstanceTag("other.example.com", "")
+
+	seen := make(map[string]bool)
+	for _, d := range instances {
+		for i := 0; i < 100; i++ {
+			staged := d.stagingPath(fullPath)
+			if seen[staged] {
+				t.Fatalf("staging path %s generated twice", staged)
+			}
+			seen[staged] = true
+			if path.Dir(staged) != path.Dir(fullPath) || !isStagingFile(path.Base(staged)) {
+				t.Fatalf("unexpected staging path %s", staged)
+			}
+			if !strings.Contains(staged, "-"+d.instanceTag+"-") {
+				t.Fatalf("expected %s to carry the instance tag %s", staged, d.instanceTag)
+			}
+		}
+	}
+}
+
+func TestNewInstanceTag(t *testing.T) {
+	if tag := newInstanceTag("registry-1.example.com", "pod/7:a"); tag != "registry-1.example.com-pod_7_a" {
+		t.Fatalf("unexpected tag %q", tag)
+	}
+	// Without an instanceid the instance id of the process is used
+	if tag := newInstanceTag("host", ""); tag == "host-" || tag != newInstanceTag("host", "") {
+		t.Fatalf("unexpected tag %q", tag)
+	}
+}
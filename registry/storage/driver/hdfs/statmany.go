@@ -0,0 +1,71 @@
+package hdfs
+
+import (
+	"sync"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// BulkStatter is implemented by drivers that can stat many paths at once,
+// such as the HDFS driver. A push checking which of its blobs exist can use
+// it instead of waiting for one Stat after the other:
+//
+//	if statter, ok := driver.(hdfs.BulkStatter); ok {
+//		infos, err := statter.StatMany(ctx, paths)
+//	}
+type BulkStatter interface {
+	// StatMany stats every path like Stat. Paths that do not exist map to
+	// nil. The error is that of the first path that could not be stat'ed
+	// for another reason; the other paths are still reported.
+	StatMany(ctx context.Context, paths []string) (map[string]storagedriver.FileInfo, error)
+}
+
+// StatMany implements BulkStatter. Up to statconcurrency Stats are in
+// flight at a time.
+func (d *Driver) StatMany(ctx context.Context, paths []string) (map[string]storagedriver.FileInfo, error) {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.StatMany(%d paths)", d.Name(), len(paths))
+
+	return d.inner().statMany(ctx, paths)
+}
+
+func (d *driver) statMany(ctx context.Context, paths []string) (map[string]storagedriver.FileInfo, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		infos    = make(map[string]storagedriver.FileInfo, len(paths))
+		firstErr error
+	)
+	work := make(chan string)
+	for i := 0; i < d.statConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				var fi storagedriver.FileInfo
+				checked, err := d.checkPath(p, false)
+				if err == nil {
+					fi, err = d.Stat(ctx, checked)
+				}
+				if _, missing := err.(storagedriver.PathNotFoundError); missing {
+					err = nil
+				}
+
+				mu.Lock()
+				infos[p] = fi
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, p := range paths {
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+	return infos, firstErr
+}
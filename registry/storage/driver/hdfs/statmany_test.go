@@ -0,0 +1,80 @@
+package hdfs
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestStatMany(t *testing.T) {
+	client := newFakeClient()
+	var sd storagedriver.StorageDriver = wrap(newTestDriverWithParameters(client, DriverParameters{StatConcurrency: 3}))
+	present := []string{"/blobs/a", "/blobs/b", "/blobs/c", "/blobs/d", "/blobs/e"}
+	for _, p := range present {
+		client.writeFile("/registry"+p, []byte(p))
+	}
+
+	var inFlight, maxInFlight int32
+	client.hook("Stat", func(string) error {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+
+	statter, ok := sd.(BulkStatter)
+	if !ok {
+		t.Fatalf("expected the driver to implement BulkStatter")
+	}
+	missing := []string{"/blobs/missing", "/blobs/gone"}
+	infos, err := statter.StatMany(context.Background(), append(present, missing...))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(infos) != len(present)+len(missing) {
+		t.Fatalf("expected a result for every path, got %v", infos)
+	}
+	for _, p := range present {
+		if fi := infos[p]; fi == nil || fi.Path() != p || fi.Size() != int64(len(p)) {
+			t.Fatalf("unexpected result for %s: %+v", p, fi)
+		}
+	}
+	for _, p := range missing {
+		if fi, ok := infos[p]; !ok || fi != nil {
+			t.Fatalf("expected %s to map to nil, got %+v", p, fi)
+		}
+	}
+	if max := atomic.LoadInt32(&maxInFlight); max > 3 {
+		t.Fatalf("expected at most 3 Stats in flight, got %d", max)
+	}
+}
+
+func TestStatManyErrors(t *testing.T) {
+	client := newFakeClient()
+	statter := wrap(newTestDriver(client))
+	client.writeFile("/registry/blobs/a", []byte("a"))
+
+	infos, err := statter.StatMany(context.Background(), []string{"/blobs/a", "invalid"})
+	if _, ok := err.(storagedriver.InvalidPathError); !ok {
+		t.Fatalf("expected InvalidPathError, got %v", err)
+	}
+	if infos["/blobs/a"] == nil {
+		t.Fatal("expected the valid path to be reported despite the error")
+	}
+
+	client.failWith("Stat", errors.New("namenode unavailable"))
+	if _, err := statter.StatMany(context.Background(), []string{"/blobs/a"}); err == nil {
+		t.Fatal("expected the Stat failure to be returned")
+	}
+}
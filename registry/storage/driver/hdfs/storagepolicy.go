@@ -0,0 +1,73 @@
+package hdfs
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// errStoragePolicyWebHdfs is returned by Validate for storage policies
+// without WebHDFS, which colinmarc/hdfs needs to set them
+var errStoragePolicyWebHdfs = fmt.Errorf("The storagepolicy, hotstoragepolicy and coldstoragepolicy parameters require hdfswebhdfsaddr, webhdfsport or webhdfstls")
+
+// storagePolicySetter is implemented by clients that can assign an HDFS
+// storage policy, such as HOT, COLD or ALL_SSD, to a path. colinmarc/hdfs
+// cannot, so the driver goes through WebHDFS instead, see
+// driver.setStoragePolicy.
+type storagePolicySetter interface {
+	SetStoragePolicy(name string, policy string) error
+}
+
+// setStoragePolicy assigns policy to name if c supports it
+func setStoragePolicy(c hdfsClient, name string, policy string) error {
+	if s, ok := c.(storagePolicySetter); ok {
+		return s.SetStoragePolicy(name, policy)
+	}
+	return errUnsupportedByClient
+}
+
+// setStoragePolicy assigns policy to the file or directory at fullPath,
+// through WebHDFS when the client cannot
+func (d *driver) setStoragePolicy(fullPath string, policy string) error {
+	err := setStoragePolicy(d.hdfsClient, fullPath, policy)
+	if err == errUnsupportedByClient {
+		if d.webHdfs == nil {
+			return errWebHdfsRequired
+		}
+		err = d.webHdfs.SetStoragePolicy(fullPath, policy)
+	}
+	return err
+}
+
+// isStoragePolicyDisabled reports whether the namenode refused a storage
+// policy because tiered storage is turned off
+func isStoragePolicyDisabled(err error) bool {
+	return strings.Contains(err.Error(), "dfs.storage.policy.enabled")
+}
+
+// applyStoragePolicy assigns the storagepolicy parameter to fullPath, or
+// the policy of the tier new files at fullPath belong to. The policy is
+// best effort: on clusters without tiered storage, or without WebHDFS to
+// set it, it is logged once and not attempted again.
+func (d *driver) applyStoragePolicy(fullPath string) {
+	policy := d.storagePolicy
+	if d.tiers != nil && fullPath != d.hdfsRootDirectory {
+		policy = d.tiers.policy(d.logicalPath(fullPath), 0)
+	}
+	if policy == "" || atomic.LoadInt32(d.storagePolicyDisabled) != 0 {
+		return
+	}
+
+	err := d.setStoragePolicy(fullPath, policy)
+	if err == nil {
+		return
+	}
+	if err == errWebHdfsRequired || isStoragePolicyDisabled(err) {
+		if atomic.CompareAndSwapInt32(d.storagePolicyDisabled, 0, 1) {
+			log.Printf("hdfs: storage policy %s is not available, files get the default policy: %v", policy, err)
+		}
+		return
+	}
+	log.Printf("hdfs: unable to set storage policy of %s to %s: %v", fullPath, policy, err)
+}
@@ -0,0 +1,76 @@
+package hdfs
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestStoragePolicy(t *testing.T) {
+	client := newFakeClient()
+	client.MkdirAll("/registry", 0755)
+	d := newTestDriverWithParameters(client, DriverParameters{StoragePolicy: "COLD"})
+
+	if policy := client.storagePolicy("/registry"); policy != "COLD" {
+		t.Fatalf("expected the root directory to get policy COLD, got %q", policy)
+	}
+	if err := d.PutContent(context.Background(), "/blobs/data", []byte("layer")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if policy := client.storagePolicy("/registry/blobs/data"); policy != "COLD" {
+		t.Fatalf("expected new files to get policy COLD, got %q", policy)
+	}
+}
+
+func TestStoragePolicyDisabledOnCluster(t *testing.T) {
+	client := newFakeClient()
+	client.failWith("SetStoragePolicy", errors.New("Failed to set storage policy since dfs.storage.policy.enabled is set to false."))
+	d := newTestDriverWithParameters(client, DriverParameters{StoragePolicy: "COLD"})
+	ctx := context.Background()
+
+	for _, p := range []string{"/a", "/b", "/c"} {
+		if err := d.PutContent(ctx, p, []byte(p)); err != nil {
+			t.Fatalf("unexpected error from PutContent: %v", err)
+		}
+	}
+	if calls := client.callCount("SetStoragePolicy"); calls != 1 {
+		t.Fatalf("expected the policy to be given up after the first refusal, got %d calls", calls)
+	}
+}
+
+func TestStoragePolicyUnsupportedByClient(t *testing.T) {
+	d := newTestDriverWithParameters(basicClient{newFakeClient()}, DriverParameters{StoragePolicy: "COLD"})
+	if err := d.PutContent(context.Background(), "/a", []byte("a")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if *d.storagePolicyDisabled == 0 {
+		t.Fatalf("expected the policy to be disabled for a client without SetStoragePolicy")
+	}
+}
+
+func TestStoragePolicyThroughWebHdfs(t *testing.T) {
+	client := newFakeClient()
+	client.MkdirAll("/registry", 0755)
+	server := httptest.NewServer(&fakeWebHdfs{namenode: client})
+	defer server.Close()
+
+	// colinmarc/hdfs cannot set storage policies over RPC
+	d := newTestDriverWithParameters(basicClient{client}, DriverParameters{HdfsUser: "registry", WebHdfsAddress: server.URL, StoragePolicy: "COLD"})
+	if policy := client.storagePolicy("/registry"); policy != "COLD" {
+		t.Fatalf("expected the root directory to get policy COLD through WebHDFS, got %q", policy)
+	}
+	if err := d.PutContent(context.Background(), "/blobs/data", []byte("layer")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if policy := client.storagePolicy("/registry/blobs/data"); policy != "COLD" {
+		t.Fatalf("expected new files to get policy COLD through WebHDFS, got %q", policy)
+	}
+}
+
+// basicClient hides the optional interfaces of fakeClient, like the
+// colinmarc/hdfs client
+type basicClient struct {
+	hdfsClient
+}
@@ -0,0 +1,108 @@
+package hdfs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// Rebalancer is implemented by drivers that can move files between storage
+// tiers as they age, such as the HDFS driver with hotstoragepolicy or
+// coldstoragepolicy set.
+type Rebalancer interface {
+	// Rebalance assigns every file below prefix the storage policy its
+	// tier calls for now. Files keep their blocks where they are until the
+	// HDFS mover runs.
+	Rebalance(ctx context.Context, prefix string) error
+}
+
+// storageTiers splits files between a hot and a cold storage policy.
+// Files below one of hotPrefixes are hot, as are files younger than
+// coldAfter when it is set; all others are cold. An empty policy leaves
+// the files of its tier with the default policy.
+type storageTiers struct {
+	hot, cold   string
+	hotPrefixes []string
+	coldAfter   time.Duration
+}
+
+// newStorageTiers returns nil when neither tier has a policy
+func newStorageTiers(hot, cold, hotPrefixes string, coldAfter time.Duration) *storageTiers {
+	if hot == "" && cold == "" {
+		return nil
+	}
+	t := &storageTiers{hot: hot, cold: cold, coldAfter: coldAfter}
+	for _, prefix := range splitList(hotPrefixes) {
+		t.hotPrefixes = append(t.hotPrefixes, path.Clean("/"+prefix))
+	}
+	return t
+}
+
+// policy returns the policy of the file at subPath, which is age old
+func (t *storageTiers) policy(subPath string, age time.Duration) string {
+	for _, prefix := range t.hotPrefixes {
+		if subPath == prefix || strings.HasPrefix(subPath, prefix+"/") || prefix == "/" {
+			return t.hot
+		}
+	}
+	if t.coldAfter > 0 && age < t.coldAfter {
+		return t.hot
+	}
+	return t.cold
+}
+
+// logicalPath returns the driver-relative path stored at fullPath
+func (d *driver) logicalPath(fullPath string) string {
+	subPath := strings.TrimPrefix(fullPath, d.hdfsRootDirectory)
+	if d.pathTransform != nil {
+		subPath = d.pathTransform.reverse(subPath)
+	}
+	return path.Clean("/" + subPath)
+}
+
+// Rebalance implements Rebalancer
+func (d *Driver) Rebalance(ctx context.Context, prefix string) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.Rebalance(%q)", d.Name(), prefix)
+
+	inner := d.inner()
+	if inner.tiers == nil {
+		return fmt.Errorf("hdfs: Rebalance requires hotstoragepolicy or coldstoragepolicy")
+	}
+	prefix, err := d.normalize(prefix)
+	if err != nil {
+		return err
+	}
+	fi, err := d.Stat(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return inner.rebalanceFile(prefix, fi.ModTime())
+	}
+
+	list := func(dir string) ([]string, error) {
+		return d.List(ctx, dir)
+	}
+	return walkTree(prefix, list, func(child string) (bool, error) {
+		fi, err := d.Stat(ctx, child)
+		if err != nil {
+			return false, err
+		}
+		if fi.IsDir() {
+			return true, nil
+		}
+		return false, inner.rebalanceFile(child, fi.ModTime())
+	})
+}
+
+func (d *driver) rebalanceFile(subPath string, modTime time.Time) error {
+	policy := d.tiers.policy(subPath, time.Since(modTime))
+	if policy == "" {
+		return nil
+	}
+	return d.setStoragePolicy(d.fullPath(subPath), policy)
+}
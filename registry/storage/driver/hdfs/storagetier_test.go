@@ -0,0 +1,57 @@
+package hdfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestStorageTiersOnWrite(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{
+		HotStoragePolicy:  "ALL_SSD",
+		ColdStoragePolicy: "COLD",
+		HotPrefixes:       "/repositories/library",
+	})
+	ctx := context.Background()
+
+	for _, p := range []string{"/repositories/library/ubuntu/link", "/repositories/team/app/link"} {
+		if err := d.PutContent(ctx, p, []byte(p)); err != nil {
+			t.Fatalf("unexpected error from PutContent: %v", err)
+		}
+	}
+	if policy := client.storagePolicy("/registry/repositories/library/ubuntu/link"); policy != "ALL_SSD" {
+		t.Fatalf("expected the hot prefix to get ALL_SSD, got %q", policy)
+	}
+	if policy := client.storagePolicy("/registry/repositories/team/app/link"); policy != "COLD" {
+		t.Fatalf("expected other files to get COLD, got %q", policy)
+	}
+}
+
+func TestRebalanceByAge(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriverWithParameters(client, DriverParameters{
+		HotStoragePolicy:  "HOT",
+		ColdStoragePolicy: "COLD",
+		ColdAfter:         24 * time.Hour,
+	}))
+	ctx := context.Background()
+
+	client.writeFile("/registry/blobs/new/data", []byte("new"))
+	client.writeFile("/registry/blobs/old/data", []byte("old"))
+	old := time.Now().Add(-48 * time.Hour)
+	if err := client.Chtimes("/registry/blobs/old/data", old, old); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := d.Rebalance(ctx, "/blobs"); err != nil {
+		t.Fatalf("unexpected error from Rebalance: %v", err)
+	}
+	if policy := client.storagePolicy("/registry/blobs/new/data"); policy != "HOT" {
+		t.Fatalf("expected the recent file to be hot, got %q", policy)
+	}
+	if policy := client.storagePolicy("/registry/blobs/old/data"); policy != "COLD" {
+		t.Fatalf("expected the old file to be cold, got %q", policy)
+	}
+}
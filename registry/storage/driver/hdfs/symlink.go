@@ -0,0 +1,114 @@
+package hdfs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// HDFS can hold symbolic links when the cluster enables them, but the
+// namenode does not resolve them itself: operations on a path ending in a
+// link fail with an UnresolvedLinkException and leave resolution to the
+// client. The symlinks parameter picks what the driver does then. With
+// follow, the default and what Hadoop clients do, Stat, GetContent and
+// Reader act on the target of the link. With nofollow, Stat describes the
+// link itself as an empty file and reads fail. Either way List reports a
+// link as the entry its parent lists, and walks do not descend into links
+// to directories, so there can be no cycles. Stat reports the target of a
+// link through ExtendedFileInfo.Symlink. Links inside a path, rather than
+// at its end, are not resolved.
+
+// maxSymlinkHops is how many links are followed before giving up on a
+// chain, like FsConstants.MAX_PATH_LINKS in Hadoop
+const maxSymlinkHops = 32
+
+func validateSymlinks(mode string) error {
+	switch mode {
+	case "follow", "nofollow":
+		return nil
+	}
+	return fmt.Errorf("The symlinks parameter should be follow or nofollow, %q invalid", mode)
+}
+
+// errSymlinkNotFollowed is returned for reads of a link with symlinks set
+// to nofollow
+type errSymlinkNotFollowed struct {
+	path string
+}
+
+func (e errSymlinkNotFollowed) Error() string {
+	return fmt.Sprintf("hdfs: %s is a symbolic link, which symlinks nofollow does not read through", e.path)
+}
+
+// isUnresolvedLink reports whether the namenode refused err because the
+// path ends in a symbolic link
+func isUnresolvedLink(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "UnresolvedLinkException") || strings.Contains(err.Error(), "UnresolvedPathException"))
+}
+
+// linkTarget returns the target of fi if it is a symbolic link. Like
+// EncryptionInfo, it reads the HdfsFileStatusProto that colinmarc/hdfs
+// returns from Sys, which carries the file type and target.
+func linkTarget(fi os.FileInfo) string {
+	status := reflect.ValueOf(fi.Sys())
+	if !status.IsValid() {
+		return ""
+	}
+	getType, getSymlink := status.MethodByName("GetFileType"), status.MethodByName("GetSymlink")
+	if !getType.IsValid() || !getSymlink.IsValid() {
+		return ""
+	}
+	if fmt.Sprint(getType.Call(nil)[0].Interface()) != "IS_SYMLINK" {
+		return ""
+	}
+	target := getSymlink.Call(nil)[0]
+	if target.Kind() == reflect.Slice {
+		return string(target.Bytes())
+	}
+	return target.String()
+}
+
+// lstat returns the FileInfo of the link at fullPath rather than of its
+// target. colinmarc/hdfs cannot issue getFileLinkInfo, so the link is
+// looked up in the listing of its parent.
+func (d *driver) lstat(fullPath string) (os.FileInfo, error) {
+	fileInfos, err := d.hdfsClient.ReadDir(path.Dir(fullPath))
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fileInfos {
+		if fi.Name() == path.Base(fullPath) {
+			return fi, nil
+		}
+	}
+	return nil, &os.PathError{Op: "lstat", Path: fullPath, Err: os.ErrNotExist}
+}
+
+// followLink returns the path the link at fullPath ends up at, following
+// chains of links, or errSymlinkNotFollowed with symlinks set to nofollow
+func (d *driver) followLink(fullPath string) (string, error) {
+	if !d.followSymlinks {
+		return "", errSymlinkNotFollowed{path: fullPath}
+	}
+	start := fullPath
+	for hop := 0; hop < maxSymlinkHops; hop++ {
+		link, err := d.lstat(fullPath)
+		if err != nil {
+			return "", err
+		}
+		target := linkTarget(link)
+		if target == "" {
+			return fullPath, nil
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(fullPath), target)
+		}
+		fullPath = path.Clean(target)
+		if _, err := d.hdfsClient.Stat(fullPath); !isUnresolvedLink(err) {
+			return fullPath, nil
+		}
+	}
+	return "", fmt.Errorf("hdfs: more than %d symbolic links from %s", maxSymlinkHops, start)
+}
@@ -0,0 +1,89 @@
+package hdfs
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func newSymlinkTestClient() *fakeClient {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/data", []byte("layer"))
+	client.symlinkTo("/registry/links/absolute", "/registry/blobs/data")
+	client.symlinkTo("/registry/links/relative", "../blobs/data")
+	client.symlinkTo("/registry/links/chained", "relative")
+	return client
+}
+
+func TestSymlinksFollow(t *testing.T) {
+	d := newTestDriverWithParameters(newSymlinkTestClient(), DriverParameters{Symlinks: "follow"})
+	ctx := context.Background()
+
+	for _, link := range []string{"/links/absolute", "/links/relative", "/links/chained"} {
+		fi, err := d.Stat(ctx, link)
+		if err != nil {
+			t.Fatalf("%s: unexpected error from Stat: %v", link, err)
+		}
+		if fi.Size() != 5 || fi.IsDir() || fi.Path() != link {
+			t.Fatalf("%s: expected the target's size under the link's path, got %+v", link, fi)
+		}
+		if fi.(ExtendedFileInfo).Symlink() == "" {
+			t.Fatalf("%s: expected Stat to report the link", link)
+		}
+
+		if contents, err := d.GetContent(ctx, link); err != nil || string(contents) != "layer" {
+			t.Fatalf("%s: expected GetContent to read the target, got %q, %v", link, contents, err)
+		}
+		reader, err := d.Reader(ctx, link, 1)
+		if err != nil {
+			t.Fatalf("%s: unexpected error from Reader: %v", link, err)
+		}
+		contents, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil || string(contents) != "ayer" {
+			t.Fatalf("%s: expected Reader to read the target, got %q, %v", link, contents, err)
+		}
+	}
+
+	// Other paths are no links
+	if fi, err := d.Stat(ctx, "/blobs/data"); err != nil || fi.(ExtendedFileInfo).Symlink() != "" {
+		t.Fatalf("expected a plain file, got %+v, %v", fi, err)
+	}
+}
+
+func TestSymlinksNoFollow(t *testing.T) {
+	d := newTestDriverWithParameters(newSymlinkTestClient(), DriverParameters{Symlinks: "nofollow"})
+	ctx := context.Background()
+
+	fi, err := d.Stat(ctx, "/links/relative")
+	if err != nil {
+		t.Fatalf("unexpected error from Stat: %v", err)
+	}
+	if fi.Size() != 0 || fi.IsDir() {
+		t.Fatalf("expected the link itself, got %+v", fi)
+	}
+	if target := fi.(ExtendedFileInfo).Symlink(); target != "../blobs/data" {
+		t.Fatalf("expected the link target, got %q", target)
+	}
+
+	if _, err := d.Reader(ctx, "/links/relative", 0); err == nil {
+		t.Fatal("expected reading through the link to fail")
+	} else if _, ok := err.(errSymlinkNotFollowed); !ok {
+		t.Fatalf("expected errSymlinkNotFollowed, got %v", err)
+	}
+	if _, err := d.GetContent(ctx, "/links/relative"); err == nil {
+		t.Fatal("expected reading through the link to fail")
+	}
+}
+
+func TestSymlinkLoop(t *testing.T) {
+	client := newFakeClient()
+	client.symlinkTo("/registry/links/a", "b")
+	client.symlinkTo("/registry/links/b", "a")
+	d := newTestDriver(client)
+
+	if _, err := d.Stat(context.Background(), "/links/a"); err == nil {
+		t.Fatal("expected a link loop to fail")
+	}
+}
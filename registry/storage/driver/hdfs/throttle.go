@@ -0,0 +1,97 @@
+package hdfs
+
+import (
+	"io"
+	"math"
+
+	"github.com/docker/distribution/context"
+	"golang.org/x/time/rate"
+)
+
+// newBandwidthLimiter returns a token bucket holding one second worth of
+// bytes at bytesPerSecond, or nil when bytesPerSecond is 0 (unlimited).
+func newBandwidthLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := bytesPerSecond
+	if burst > math.MaxInt32 {
+		burst = math.MaxInt32
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+}
+
+// throttledReader takes a token from limiter for every byte read. Reads are
+// capped at the bucket size so a single call never waits for more tokens
+// than the bucket can hold.
+type throttledReader struct {
+	io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(context.Background(), n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// throttledWriter takes a token from limiter for every byte written to the
+// underlying HDFS file, waiting before the bytes are handed to the client.
+type throttledWriter struct {
+	hdfsFileWriter
+	limiter *rate.Limiter
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if burst := w.limiter.Burst(); len(chunk) > burst {
+			chunk = chunk[:burst]
+		}
+		if err := w.limiter.WaitN(context.Background(), len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.hdfsFileWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// throttleReader applies the readbandwidth limit to r
+func (d *driver) throttleReader(r io.Reader) io.Reader {
+	if d.readLimiter == nil {
+		return r
+	}
+	return &throttledReader{Reader: r, limiter: d.readLimiter}
+}
+
+// throttleReadCloser applies the readbandwidth limit to rc, keeping its Close
+func (d *driver) throttleReadCloser(rc io.ReadCloser) io.ReadCloser {
+	if d.readLimiter == nil {
+		return rc
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{d.throttleReader(rc), rc}
+}
+
+// throttleWriter applies the writebandwidth limit to w
+func (d *driver) throttleWriter(w hdfsFileWriter) hdfsFileWriter {
+	if d.writeLimiter == nil || w == nil {
+		return w
+	}
+	return &throttledWriter{hdfsFileWriter: w, limiter: d.writeLimiter}
+}
@@ -0,0 +1,68 @@
+package hdfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+const testBandwidth = 64 << 10
+
+// expectThrottled fails unless transferring size bytes at testBandwidth took
+// about as long as the bucket allows. The bucket starts full, so the first
+// second worth of bytes is free.
+func expectThrottled(t *testing.T, elapsed time.Duration, size int) {
+	expected := time.Duration(size-testBandwidth) * time.Second / testBandwidth
+	if elapsed < expected*8/10 || elapsed > expected*2 {
+		t.Fatalf("transferring %d bytes at %d bytes/s took %v, expected about %v", size, testBandwidth, elapsed, expected)
+	}
+}
+
+func TestReadBandwidth(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{ReadBandwidth: testBandwidth})
+	contents := bytes.Repeat([]byte("r"), 2*testBandwidth)
+	client.writeFile("/registry/blob", contents)
+
+	reader, err := d.Reader(context.Background(), "/blob", 0)
+	if err != nil {
+		t.Fatalf("unexpected error from Reader: %v", err)
+	}
+	defer reader.Close()
+
+	start := time.Now()
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	expectThrottled(t, time.Since(start), len(read))
+	if !bytes.Equal(read, contents) {
+		t.Fatalf("throttled read returned different contents")
+	}
+}
+
+func TestWriteBandwidth(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{WriteBandwidth: testBandwidth})
+	contents := bytes.Repeat([]byte("w"), 2*testBandwidth)
+
+	writer, err := d.Writer(context.Background(), "/blob", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := writer.Write(contents); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	expectThrottled(t, time.Since(start), len(contents))
+	writer.Close()
+
+	stored, _ := client.ReadFile("/registry/blob")
+	if !bytes.Equal(stored, contents) {
+		t.Fatalf("throttled write stored different contents")
+	}
+}
@@ -0,0 +1,47 @@
+package hdfs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Clusters that encrypt their RPC traffic with hadoop.rpc.protection
+// privacy usually serve WebHDFS and the KMS over HTTPS as well, with
+// certificates from an internal CA. The tlscafile parameter names a PEM
+// bundle of the CAs to trust for those HTTPS connections instead of the
+// system roots. Java trust stores need converting to PEM first, e.g. with
+// keytool -exportcert -rfc. The namenode RPC connection itself goes
+// through colinmarc/hdfs, which does not support privacy protection, so
+// the trust material does not reach it.
+
+// newTLSConfig returns the TLS configuration trusting the CAs in caFile, or
+// nil to use the system roots when caFile is ""
+func newTLSConfig(caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("The tlscafile parameter must name a readable PEM file: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("The tlscafile parameter must name a PEM file, %s has no certificates", caFile)
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
+
+// newHTTPClient returns the client for WebHDFS and the KMS, which uses
+// config for HTTPS when set
+func newHTTPClient(config *tls.Config) *http.Client {
+	if config == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: config,
+	}}
+}
@@ -0,0 +1,72 @@
+package hdfs
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes contents to name in a new temporary directory
+func writeTestFile(t *testing.T, name string, contents []byte) (string, func()) {
+	dir, err := ioutil.TempDir("", "hdfs-tls-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func TestTLSCAFileTrustsWebHdfsAndKms(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeWebHdfs{})
+	defer server.Close()
+	caFile, cleanup := writeTestFile(t, "ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	defer cleanup()
+
+	params := DriverParameters{
+		WebHdfsAddress: server.URL,
+		WebHdfsTLS:     true,
+		KmsURI:         "kms://https@kms.example.com:9600/kms",
+		TLSCAFile:      caFile,
+	}
+	d := newTestDriverWithParameters(newFakeClient(), params)
+
+	transport, ok := d.webHdfs.client.Transport.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil || transport.TLSClientConfig.RootCAs == nil {
+		t.Fatalf("expected the WebHDFS client to trust the CAs in %s", caFile)
+	}
+	if d.kms.client != d.webHdfs.client {
+		t.Fatal("expected the KMS client to share the TLS configuration")
+	}
+	if token, err := d.webHdfs.getDelegationToken(); err != nil || token != testDelegationToken {
+		t.Fatalf("expected a token over HTTPS, got %q, %v", token, err)
+	}
+
+	// The system roots do not know the test CA
+	params.TLSCAFile = ""
+	d = newTestDriverWithParameters(newFakeClient(), params)
+	if _, err := d.webHdfs.getDelegationToken(); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected the certificate to be rejected without tlscafile, got %v", err)
+	}
+}
+
+func TestTLSCAFileMustHoldCertificates(t *testing.T) {
+	notPEM, cleanup := writeTestFile(t, "ca.jks", []byte{0xfe, 0xed, 0xfe, 0xed})
+	defer cleanup()
+
+	for _, caFile := range []string{notPEM, filepath.Join(filepath.Dir(notPEM), "missing.pem")} {
+		if _, err := newTLSConfig(caFile); err == nil || !strings.Contains(err.Error(), "tlscafile") {
+			t.Errorf("expected %s to be rejected, got %v", caFile, err)
+		}
+	}
+	if config, err := newTLSConfig(""); config != nil || err != nil {
+		t.Fatalf("expected the system roots without tlscafile, got %v, %v", config, err)
+	}
+}
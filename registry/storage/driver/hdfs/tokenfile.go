@@ -0,0 +1,289 @@
+package hdfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// YARN and Oozie hand the HDFS delegation tokens of a job to its processes
+// in the file named by HADOOP_TOKEN_FILE_LOCATION. colinmarc/hdfs cannot
+// authenticate RPCs with a token, so the token only authenticates the
+// WebHDFS operations the driver performs itself. It outlives any URL and is
+// not ours to cancel, so URLFor still asks for a token of its own per URL.
+const hadoopTokenFileEnv = "HADOOP_TOKEN_FILE_LOCATION"
+
+// hdfsDelegationTokenKind is the kind of the tokens the namenode issues
+const hdfsDelegationTokenKind = "HDFS_DELEGATION_TOKEN"
+
+// tokenStorageMagic starts every Hadoop credentials file
+const tokenStorageMagic = "HDTS"
+
+// Credentials files are written with Writable serialization by Hadoop 2
+// and either that or protocol buffers by Hadoop 3
+const (
+	tokenStorageWritable = 0
+	tokenStorageProtobuf = 1
+)
+
+// delegationToken is a Hadoop security token
+type delegationToken struct {
+	identifier []byte
+	password   []byte
+	kind       string
+	service    string
+}
+
+// encodeURLString encodes the token like Token.encodeToUrlString, which is
+// how WebHDFS expects it in the delegation parameter
+func (t delegationToken) encodeURLString() string {
+	var buf bytes.Buffer
+	writeVInt(&buf, int64(len(t.identifier)))
+	buf.Write(t.identifier)
+	writeVInt(&buf, int64(len(t.password)))
+	buf.Write(t.password)
+	writeVInt(&buf, int64(len(t.kind)))
+	buf.WriteString(t.kind)
+	writeVInt(&buf, int64(len(t.service)))
+	buf.WriteString(t.service)
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(buf.Bytes()), "=")
+}
+
+// applyTokenFile loads the HDFS delegation token from the file named by
+// HADOOP_TOKEN_FILE_LOCATION, if set, into params. A file without one, such
+// as that of a job granted tokens for other services only, is not an error.
+func applyTokenFile(params *DriverParameters) error {
+	name := os.Getenv(hadoopTokenFileEnv)
+	if name == "" {
+		return nil
+	}
+	tokens, err := readTokenFile(name)
+	if err != nil {
+		return fmt.Errorf("reading %s %s: %v", hadoopTokenFileEnv, name, err)
+	}
+
+	for _, token := range tokens {
+		if token.kind != hdfsDelegationTokenKind {
+			continue
+		}
+		params.delegationToken = token.encodeURLString()
+		return nil
+	}
+	log.Printf("hdfs: %s %s holds no %s, ignoring it", hadoopTokenFileEnv, name, hdfsDelegationTokenKind)
+	return nil
+}
+
+// readTokenFile reads the tokens of a Hadoop credentials file
+func readTokenFile(name string) ([]delegationToken, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(tokenStorageMagic)+1)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic[:len(tokenStorageMagic)]) != tokenStorageMagic {
+		return nil, errors.New("not a Hadoop credentials file")
+	}
+	switch version := magic[len(tokenStorageMagic)]; version {
+	case tokenStorageWritable:
+		return readWritableTokens(r)
+	case tokenStorageProtobuf:
+		return readProtobufTokens(r)
+	default:
+		return nil, fmt.Errorf("unsupported credentials file version %d", version)
+	}
+}
+
+// readWritableTokens reads the tokens of Credentials.readFields, skipping
+// the secret keys that follow them
+func readWritableTokens(r byteReader) ([]delegationToken, error) {
+	count, err := readVInt(r)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []delegationToken
+	for i := int64(0); i < count; i++ {
+		if _, err := readText(r); err != nil { // alias
+			return nil, err
+		}
+		var token delegationToken
+		if token.identifier, err = readBytes(r); err != nil {
+			return nil, err
+		}
+		if token.password, err = readBytes(r); err != nil {
+			return nil, err
+		}
+		if token.kind, err = readText(r); err != nil {
+			return nil, err
+		}
+		if token.service, err = readText(r); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// readProtobufTokens reads the tokens of a delimited CredentialsProto:
+//
+//	message CredentialsProto { repeated CredentialsKVProto tokens = 1; ... }
+//	message CredentialsKVProto { string alias = 1; TokenProto token = 2; ... }
+//	message TokenProto { bytes identifier = 1; bytes password = 2; string kind = 3; string service = 4; }
+func readProtobufTokens(r byteReader) ([]delegationToken, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make([]byte, size)
+	if _, err := io.ReadFull(r, credentials); err != nil {
+		return nil, err
+	}
+
+	var tokens []delegationToken
+	err = readProtoFields(credentials, func(field int, value []byte) error {
+		if field != 1 {
+			return nil
+		}
+		return readProtoFields(value, func(field int, value []byte) error {
+			if field != 2 {
+				return nil
+			}
+			var token delegationToken
+			err := readProtoFields(value, func(field int, value []byte) error {
+				switch field {
+				case 1:
+					token.identifier = value
+				case 2:
+					token.password = value
+				case 3:
+					token.kind = string(value)
+				case 4:
+					token.service = string(value)
+				}
+				return nil
+			})
+			tokens = append(tokens, token)
+			return err
+		})
+	})
+	return tokens, err
+}
+
+// readProtoFields calls f with the length-delimited fields of message,
+// which is all the credentials messages hold, skipping varints
+func readProtoFields(message []byte, f func(field int, value []byte) error) error {
+	r := bytes.NewReader(message)
+	for r.Len() > 0 {
+		key, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		switch wireType := key & 7; wireType {
+		case 0:
+			if _, err := binary.ReadUvarint(r); err != nil {
+				return err
+			}
+		case 2:
+			size, err := binary.ReadUvarint(r)
+			if err != nil {
+				return err
+			}
+			if size > uint64(r.Len()) {
+				return io.ErrUnexpectedEOF
+			}
+			value := make([]byte, size)
+			r.Read(value)
+			if err := f(int(key>>3), value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected protobuf wire type %d", wireType)
+		}
+	}
+	return nil
+}
+
+// readVInt reads a WritableUtils variable-length integer
+func readVInt(r io.ByteReader) (int64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	b := int8(first)
+	if b >= -112 {
+		return int64(b), nil
+	}
+	negative := b < -120
+	size := -112 - int(b)
+	if negative {
+		size = -120 - int(b)
+	}
+	var value int64
+	for i := 0; i < size; i++ {
+		next, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<8 | int64(next)
+	}
+	if negative {
+		value = ^value
+	}
+	return value, nil
+}
+
+// writeVInt writes a WritableUtils variable-length integer
+func writeVInt(w io.ByteWriter, value int64) {
+	if value >= -112 && value <= 127 {
+		w.WriteByte(byte(value))
+		return
+	}
+	prefix := -112
+	if value < 0 {
+		value = ^value
+		prefix = -120
+	}
+	size := 0
+	for tmp := value; tmp != 0; tmp >>= 8 {
+		size++
+	}
+	w.WriteByte(byte(int8(prefix - size)))
+	for i := size - 1; i >= 0; i-- {
+		w.WriteByte(byte(value >> (8 * uint(i))))
+	}
+}
+
+// byteReader is what the Writable encodings are read from
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// readBytes reads a byte array prefixed with its vint length
+func readBytes(r byteReader) ([]byte, error) {
+	size, err := readVInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 || size > 1<<20 {
+		return nil, fmt.Errorf("invalid length %d", size)
+	}
+	b := make([]byte, size)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+// readText reads a Hadoop Text, a string prefixed with its vint length
+func readText(r byteReader) (string, error) {
+	b, err := readBytes(r)
+	return string(b), err
+}
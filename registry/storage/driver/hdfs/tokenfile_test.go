@@ -0,0 +1,150 @@
+package hdfs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+// setTokenFile points HADOOP_TOKEN_FILE_LOCATION at name until the
+// returned function is called
+func setTokenFile(name string) func() {
+	old, set := os.LookupEnv(hadoopTokenFileEnv)
+	os.Setenv(hadoopTokenFileEnv, name)
+	return func() {
+		if set {
+			os.Setenv(hadoopTokenFileEnv, old)
+		} else {
+			os.Unsetenv(hadoopTokenFileEnv)
+		}
+	}
+}
+
+func TestApplyTokenFile(t *testing.T) {
+	// Both files hold a YARN token followed by a namenode token
+	for _, fixture := range []string{"testdata/container_tokens", "testdata/container_tokens_proto"} {
+		restore := setTokenFile(fixture)
+		var params DriverParameters
+		err := applyTokenFile(&params)
+		restore()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", fixture, err)
+		}
+		if params.HdfsUser != "" {
+			t.Fatalf("%s: expected the user to be left alone, got %q", fixture, params.HdfsUser)
+		}
+
+		encoded, err := base64.RawURLEncoding.DecodeString(params.delegationToken)
+		if err != nil {
+			t.Fatalf("%s: the token is not URL encoded: %v", fixture, err)
+		}
+		r := bytes.NewReader(encoded)
+		identifier, _ := readBytes(r)
+		password, _ := readBytes(r)
+		kind, _ := readText(r)
+		service, err := readText(r)
+		if err != nil || r.Len() != 0 {
+			t.Fatalf("%s: unexpected token encoding: %v", fixture, err)
+		}
+		if !bytes.Contains(identifier, []byte("registry@EXAMPLE.COM")) {
+			t.Fatalf("%s: unexpected token identifier %q", fixture, identifier)
+		}
+		if len(password) != 20 || kind != hdfsDelegationTokenKind || service != "ha-hdfs:cluster" {
+			t.Fatalf("%s: unexpected token %x, %q, %q", fixture, password, kind, service)
+		}
+	}
+}
+
+func TestApplyTokenFileErrors(t *testing.T) {
+	restore := setTokenFile("")
+	var params DriverParameters
+	if err := applyTokenFile(&params); err != nil || params.delegationToken != "" {
+		t.Fatalf("expected nothing to be loaded without a token file, got %v", err)
+	}
+	restore()
+
+	// A credentials file of other services only is ignored
+	name := writeTokenFile(t, nil)
+	defer os.Remove(name)
+	restore = setTokenFile(name)
+	if err := applyTokenFile(&params); err != nil || params.delegationToken != "" {
+		t.Fatalf("expected a file without an HDFS token to be ignored, got %v", err)
+	}
+	restore()
+
+	for _, name := range []string{"testdata/missing", "tokenfile.go"} {
+		restore := setTokenFile(name)
+		if err := applyTokenFile(&params); err == nil {
+			t.Errorf("expected %s to be rejected", name)
+		}
+		restore()
+	}
+}
+
+// writeTokenFile writes a Writable credentials file holding a YARN token
+// and the given namenode tokens, and returns its name
+func writeTokenFile(t *testing.T, tokens []delegationToken) string {
+	var buf bytes.Buffer
+	buf.WriteString(tokenStorageMagic)
+	buf.WriteByte(tokenStorageWritable)
+	tokens = append([]delegationToken{{identifier: []byte{1}, kind: "YARN_AM_RM_TOKEN"}}, tokens...)
+	writeVInt(&buf, int64(len(tokens)))
+	for _, token := range tokens {
+		for _, field := range [][]byte{[]byte(token.kind), token.identifier, token.password, []byte(token.kind), []byte(token.service)} {
+			writeVInt(&buf, int64(len(field)))
+			buf.Write(field)
+		}
+	}
+	writeVInt(&buf, 0) // secret keys
+
+	f, err := ioutil.TempFile("", "container-tokens-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestTokenFileTokenIsNotHandedOut(t *testing.T) {
+	fake := &fakeWebHdfs{}
+	d, closeServer := newWebHdfsTestDriver(fake)
+	defer closeServer()
+	d.delegationToken = "jobtoken"
+	d.webHdfs.token = d.delegationToken
+
+	u, err := d.URLFor(context.Background(), "/docker/registry/v2/blobs/sha256/ab/abcd/data", nil)
+	if err != nil {
+		t.Fatalf("unexpected error from URLFor: %v", err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Query().Get("delegation") != testDelegationToken {
+		t.Fatalf("expected a token of its own in %q", u)
+	}
+	if fake.issued != 1 {
+		t.Fatalf("expected a token to be requested, got %d", fake.issued)
+	}
+}
+
+func TestTokenFileAuthenticatesWebHdfsOperations(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/a", []byte("data"))
+	fake := &fakeWebHdfs{namenode: client}
+	d, closeServer := newWebHdfsTestDriver(fake)
+	defer closeServer()
+	d.webHdfs.token = "jobtoken"
+
+	if err := d.webHdfs.SetReplication("/registry/a", 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := fake.delegations(); len(got) != 1 || got[0] != "jobtoken" {
+		t.Fatalf("expected the operation to carry the job's token, got %q", got)
+	}
+}
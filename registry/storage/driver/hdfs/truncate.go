@@ -0,0 +1,91 @@
+package hdfs
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// Truncater is implemented by drivers that can shorten a file in place,
+// e.g. to roll a resumable upload back to the last offset known to be good
+type Truncater interface {
+	Truncate(ctx context.Context, path string, size int64) error
+}
+
+// truncater is implemented by clients that can issue the namenode's
+// truncate RPC, available since Hadoop 2.7. It reports whether the file
+// is truncated already; otherwise the namenode is still recovering its
+// last block. colinmarc/hdfs cannot, so the driver goes through WebHDFS
+// instead, see driver.truncate.
+type truncater interface {
+	Truncate(name string, size int64) (bool, error)
+}
+
+// truncate shortens name to size if c supports it
+func truncate(c hdfsClient, name string, size int64) (bool, error) {
+	if t, ok := c.(truncater); ok {
+		return t.Truncate(name, size)
+	}
+	return false, errUnsupportedByClient
+}
+
+// truncate shortens the file at fullPath to size, through WebHDFS when the
+// client cannot
+func (d *driver) truncate(fullPath string, size int64) (bool, error) {
+	done, err := truncate(d.hdfsClient, fullPath, size)
+	if err == errUnsupportedByClient {
+		if d.webHdfs == nil {
+			return false, errWebHdfsRequired
+		}
+		done, err = d.webHdfs.Truncate(fullPath, size)
+	}
+	return done, err
+}
+
+// Truncate implements Truncater. Files can only be shortened. With
+// colinmarc/hdfs, which has no truncate RPC, it needs WebHDFS configured. When the
+// namenode has to recover the last block first, Truncate returns once the
+// recovery has started and appending to the file fails until it is done.
+func (d *Driver) Truncate(ctx context.Context, path string, size int64) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.Truncate(%q, %d)", d.Name(), path, size)
+
+	path, err := d.inner().checkPath(path, false)
+	if err != nil {
+		return err
+	}
+	return d.inner().truncateFile(ctx, path, size)
+}
+
+func (d *driver) truncateFile(ctx context.Context, subPath string, size int64) error {
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	if err := d.writes.allow(); err != nil {
+		return err
+	}
+
+	fullPath := d.fullPath(subPath)
+	fi, err := d.hdfsClient.Stat(fullPath)
+	if err != nil {
+		return storagedriver.PathNotFoundError{Path: subPath, DriverName: driverName}
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("cannot truncate %s: it is a directory", subPath)
+	}
+	if size < 0 || size > fi.Size() {
+		return storagedriver.InvalidOffsetError{Path: subPath, Offset: size, DriverName: driverName}
+	}
+	if size == fi.Size() {
+		return nil
+	}
+
+	_, err = d.truncate(fullPath, size)
+	d.contentCache.invalidate(fullPath)
+	if err == errWebHdfsRequired {
+		return fmt.Errorf("cannot truncate %s: %v", subPath, err)
+	}
+	d.writes.record(err)
+	return err
+}
@@ -0,0 +1,66 @@
+package hdfs
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestTruncate(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriver(client))
+	ctx := context.Background()
+	client.writeFile("/registry/upload/data", []byte("goodpartial"))
+
+	if err := d.Truncate(ctx, "/upload/data", 4); err != nil {
+		t.Fatalf("unexpected error truncating: %v", err)
+	}
+	fi, err := d.Stat(ctx, "/upload/data")
+	if err != nil || fi.Size() != 4 {
+		t.Fatalf("expected a size of 4 after truncating, got %v, %v", fi, err)
+	}
+	if contents, _ := d.GetContent(ctx, "/upload/data"); string(contents) != "good" {
+		t.Fatalf("unexpected contents %q", contents)
+	}
+
+	if err := d.Truncate(ctx, "/upload/data", 5); err == nil {
+		t.Fatal("expected an error truncating beyond the current size")
+	} else if _, ok := err.(storagedriver.InvalidOffsetError); !ok {
+		t.Fatalf("expected InvalidOffsetError, got %v", err)
+	}
+	if err := d.Truncate(ctx, "/missing", 0); !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError, got %v", err)
+	}
+}
+
+func TestTruncateUnsupported(t *testing.T) {
+	client := newFakeClient()
+	d := wrap(newTestDriver(basicClient{client}))
+	client.writeFile("/registry/upload/data", []byte("goodpartial"))
+
+	if err := d.Truncate(context.Background(), "/upload/data", 4); err == nil || !strings.Contains(err.Error(), "hdfswebhdfsaddr") {
+		t.Fatalf("expected a client without truncate to require WebHDFS, got %v", err)
+	}
+	if calls := client.callCount("Truncate"); calls != 0 {
+		t.Fatalf("expected no truncate calls, got %d", calls)
+	}
+}
+
+func TestTruncateThroughWebHdfs(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/upload/data", []byte("goodpartial"))
+	server := httptest.NewServer(&fakeWebHdfs{namenode: client})
+	defer server.Close()
+
+	// colinmarc/hdfs has no truncate RPC
+	d := wrap(newTestDriverWithParameters(basicClient{client}, DriverParameters{HdfsUser: "registry", WebHdfsAddress: server.URL}))
+	if err := d.Truncate(context.Background(), "/upload/data", 4); err != nil {
+		t.Fatalf("unexpected error truncating: %v", err)
+	}
+	if contents, _ := client.ReadFile("/registry/upload/data"); string(contents) != "good" {
+		t.Fatalf("expected the file to be truncated through WebHDFS, got %q", contents)
+	}
+}
@@ -0,0 +1,88 @@
+package hdfs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// errTooManyUploads is returned by writes when maxconcurrentuploadsmode is
+// error and maxconcurrentuploads uploads are already in progress.
+var errTooManyUploads = errors.New("hdfs: maxconcurrentuploads exceeded")
+
+// uploadLimiter caps the number of files being written at once. Every
+// open write pipeline holds datanode threads and memory on the registry,
+// so a burst of pushes is better queued than allowed to starve pulls. A
+// slot is taken by Writer and held until the FileWriter is closed, and by
+// PutContent and PutReader for the duration of the write.
+type uploadLimiter struct {
+	slots    chan struct{}
+	failFast bool
+}
+
+// newUploadLimiter returns nil, which lets every upload through, when max
+// is 0. mode is block to wait for a slot or error to fail fast with
+// errTooManyUploads.
+func newUploadLimiter(max int64, mode string) (*uploadLimiter, error) {
+	switch mode {
+	case "", "block", "error":
+	default:
+		return nil, fmt.Errorf("The maxconcurrentuploadsmode parameter must be one of %v, %q invalid", []string{"block", "error"}, mode)
+	}
+	if max <= 0 {
+		return nil, nil
+	}
+	return &uploadLimiter{slots: make(chan struct{}, max), failFast: mode == "error"}, nil
+}
+
+// acquire takes a slot, waiting for one unless the limiter fails fast or
+// ctx is done first
+func (l *uploadLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if l.failFast {
+		return errTooManyUploads
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// usage returns the slots taken and the number of slots
+func (l *uploadLimiter) usage() (int, int) {
+	if l == nil {
+		return 0, 0
+	}
+	return len(l.slots), cap(l.slots)
+}
+
+func (l *uploadLimiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
+
+// handOff passes the slot taken for a Writer call on to the FileWriter it
+// returned, which releases it on Close, or releases it if the call failed.
+func (l *uploadLimiter) handOff(w storagedriver.FileWriter, err error) {
+	if l == nil {
+		return
+	}
+	if fw, ok := w.(*fileWriter); ok && err == nil {
+		fw.release = l.release
+		return
+	}
+	l.release()
+}
@@ -0,0 +1,66 @@
+package hdfs
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestMaxConcurrentUploads(t *testing.T) {
+	d := newTestDriverWithParameters(newFakeClient(), DriverParameters{MaxConcurrentUploads: 2})
+
+	var active, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			writer, err := d.Writer(context.Background(), fmt.Sprintf("/upload-%d", i), false)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			n := atomic.AddInt32(&active, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			writer.Write([]byte("data"))
+			writer.Commit()
+			atomic.AddInt32(&active, -1)
+			writer.Close()
+		}(i)
+	}
+	wg.Wait()
+
+	if peak > 2 {
+		t.Fatalf("expected at most 2 uploads at once, got %d", peak)
+	}
+	// Every slot was given back
+	if err := d.PutContent(context.Background(), "/after", []byte("after")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMaxConcurrentUploadsFailFast(t *testing.T) {
+	d := newTestDriverWithParameters(newFakeClient(), DriverParameters{MaxConcurrentUploads: 1, MaxConcurrentUploadsMode: "error"})
+
+	writer, err := d.Writer(context.Background(), "/a", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.PutContent(context.Background(), "/b", []byte("b")); err != errTooManyUploads {
+		t.Fatalf("expected errTooManyUploads, got %v", err)
+	}
+	writer.Close()
+	if err := d.PutContent(context.Background(), "/b", []byte("b")); err != nil {
+		t.Fatalf("unexpected error after the upload closed: %v", err)
+	}
+}
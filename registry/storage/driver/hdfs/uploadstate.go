@@ -0,0 +1,63 @@
+package hdfs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// uploadsDir is the directory the registry keeps upload sessions in. Each
+// session directory holds the uploaded bytes in "data" next to the session
+// metadata, such as "startedat" and the saved hash states.
+const uploadsDir = "_uploads"
+
+// isUploadMetadata reports whether subPath is upload session metadata, i.e.
+// anything inside a session directory except its data.
+func isUploadMetadata(subPath string) bool {
+	components := strings.Split(strings.TrimPrefix(subPath, "/"), "/")
+	for i, component := range components {
+		if component == uploadsDir && i+2 < len(components) {
+			return components[i+2] != "data"
+		}
+	}
+	return false
+}
+
+// isUploadSession reports whether subPath is an uploads directory or a
+// session directory in one, whose entries are spread over both trees.
+func isUploadSession(subPath string) bool {
+	components := strings.Split(strings.TrimPrefix(subPath, "/"), "/")
+	for i, component := range components {
+		if component == uploadsDir && i+2 >= len(components) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateUploadStateDirectory checks that upload session metadata can be
+// kept apart from the blob tree under root.
+func validateUploadStateDirectory(dir, root string) error {
+	if dir == "" {
+		return nil
+	}
+	if !path.IsAbs(dir) {
+		return fmt.Errorf("uploadstatedirectory %q must be an absolute path", dir)
+	}
+	if root == "/" || dir == root || strings.HasPrefix(dir, root+"/") {
+		return fmt.Errorf("uploadstatedirectory %q must be outside the root directory %q", dir, root)
+	}
+	return nil
+}
+
+// uploadStatePath returns the HDFS path of the upload session metadata at
+// subPath, which keeps the layout of the blob tree under the state directory.
+func (d *driver) uploadStatePath(subPath string) string {
+	return path.Join(d.uploadStateDirectory, path.Clean("/"+subPath))
+}
+
+// inUploadStateDirectory reports whether fullPath is under the state
+// directory already
+func (d *driver) inUploadStateDirectory(fullPath string) bool {
+	return d.uploadStateDirectory != "" && (fullPath == d.uploadStateDirectory || strings.HasPrefix(fullPath, d.uploadStateDirectory+"/"))
+}
@@ -0,0 +1,87 @@
+package hdfs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+const testSession = "/docker/registry/v2/repositories/library/ubuntu/_uploads/8b5a1c0e"
+
+func TestUploadStateDirectory(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{UploadStateDir: "/registry-uploads"})
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, testSession+"/startedat", []byte("2016-01-01T00:00:00Z")); err != nil {
+		t.Fatalf("unexpected error writing startedat: %v", err)
+	}
+	if err := d.PutContent(ctx, testSession+"/hashstates/sha256/0", []byte("state")); err != nil {
+		t.Fatalf("unexpected error writing the hash state: %v", err)
+	}
+	if err := d.PutContent(ctx, testSession+"/data", []byte("layer")); err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+
+	if _, err := client.Stat("/registry-uploads" + testSession + "/startedat"); err != nil {
+		t.Fatalf("expected startedat under the state directory: %v", err)
+	}
+	if _, err := client.Stat("/registry" + testSession + "/startedat"); err == nil {
+		t.Fatalf("expected no startedat in the blob tree")
+	}
+	if _, err := client.Stat("/registry" + testSession + "/hashstates"); err == nil {
+		t.Fatalf("expected no hash states in the blob tree")
+	}
+	if _, err := client.Stat("/registry" + testSession + "/data"); err != nil {
+		t.Fatalf("expected the data in the blob tree: %v", err)
+	}
+
+	contents, err := d.GetContent(ctx, testSession+"/startedat")
+	if err != nil || string(contents) != "2016-01-01T00:00:00Z" {
+		t.Fatalf("expected startedat to read back, got %q, %v", contents, err)
+	}
+
+	// The session still looks the same to the registry
+	entries, err := d.List(ctx, testSession)
+	if err != nil {
+		t.Fatalf("unexpected error listing the session: %v", err)
+	}
+	expected := []string{testSession + "/data", testSession + "/hashstates", testSession + "/startedat"}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+
+	if err := d.Delete(ctx, testSession); err != nil {
+		t.Fatalf("unexpected error deleting the session: %v", err)
+	}
+	if _, err := client.Stat("/registry-uploads" + testSession); err == nil {
+		t.Fatalf("expected the session metadata to be deleted with the session")
+	}
+}
+
+func TestUploadStateDirectorySessionWithoutData(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{UploadStateDir: "/registry-uploads"})
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, testSession+"/startedat", []byte("2016-01-01T00:00:00Z")); err != nil {
+		t.Fatalf("unexpected error writing startedat: %v", err)
+	}
+
+	uploads, err := d.List(ctx, "/docker/registry/v2/repositories/library/ubuntu/_uploads")
+	if err != nil || !reflect.DeepEqual(uploads, []string{testSession}) {
+		t.Fatalf("expected the session to be listed, got %v, %v", uploads, err)
+	}
+	if fi, err := d.Stat(ctx, testSession); err != nil || !fi.IsDir() {
+		t.Fatalf("expected the session to stat as a directory, got %v, %v", fi, err)
+	}
+}
+
+func TestUploadStateDirectoryValidation(t *testing.T) {
+	for _, dir := range []string{"relative", "/registry", "/registry/_state"} {
+		if _, err := newDriver(newFakeClient(), DriverParameters{HdfsRootDirectory: "/registry", DirectoryUmask: defaultDirectoryUmask, UploadStateDir: dir}); err == nil {
+			t.Errorf("expected uploadstatedirectory %q to be rejected", dir)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package hdfs
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+)
+
+// validationErrors collects every problem found in a configuration, so that
+// all of them can be fixed at once rather than one restart at a time
+type validationErrors []error
+
+func (e validationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "hdfs: invalid configuration: " + strings.Join(messages, "; ")
+}
+
+// Validate checks the parameters in a single pass and returns all the
+// problems found as one error, or nil. Parameters that usehadoopenv may
+// still supply, such as hdfsnamenode, are only required without it.
+func (p DriverParameters) Validate() error {
+	var errs validationErrors
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	inRange := func(name string, value, min, max int64) {
+		if value < min || value > max {
+			check(fmt.Errorf("The %s %#v parameter should be a number between %d and %d (inclusive)", name, value, min, max))
+		}
+	}
+
+	if strings.TrimSpace(p.HdfsNameNode) == "" && !p.UseHadoopEnv {
+		check(fmt.Errorf("The hdfsnamenode parameter is required unless usehadoopenv is set"))
+	}
+	if p.DirectoryUmask <= 0 || p.DirectoryUmask > 0777 {
+		check(fmt.Errorf("The directoryumask parameter must be a mode between 01 and 0777, %#o invalid", p.DirectoryUmask))
+	}
+	inRange("webhdfsport", p.WebHdfsPort, 0, 65535)
+	// Without a namenode the address is derived once usehadoopenv ran
+	if p.WebHdfsAddress != "" || strings.TrimSpace(p.HdfsNameNode) != "" {
+		if _, err := webHdfsAddress(p); err != nil {
+			check(err)
+		}
+	}
+	if p.TransferBufferSize != 0 {
+		inRange("transferbuffersize", p.TransferBufferSize, minTransferBufferSize, maxTransferBufferSize)
+	}
+	inRange("maxputcontentsize", p.MaxPutContentSize, 0, math.MaxInt64)
+	inRange("readbandwidth", p.ReadBandwidth, 0, math.MaxInt64)
+	inRange("writebandwidth", p.WriteBandwidth, 0, math.MaxInt64)
+	inRange("maxopspersecond", p.MaxOpsPerSecond, 0, math.MaxInt64)
+	if _, err := newOpsRateLimit(nil, 0, p.MaxOpsMode); err != nil {
+		check(err)
+	}
+	if _, err := newPathMapping(p); err != nil {
+		check(err)
+	}
+	if _, err := newCodec(p.Compression); err != nil {
+		check(err)
+	} else if p.Compression != "" {
+		if p.WebHdfsAddress == "" && p.WebHdfsPort == 0 && !p.WebHdfsTLS {
+			check(errCompressionWebHdfs)
+		}
+		if p.MirrorNameNode != "" {
+			check(errCompressionMirror)
+		}
+	}
+
+	// Replication is capped by the namenode's dfs.replication.max, which
+	// the driver cannot see, so only the protocol limit is checked here
+	inRange("replication", p.Replication, 0, math.MaxInt16)
+	inRange("stagingreplication", p.StagingReplication, 0, math.MaxInt16)
+	if p.StagingReplication > 0 && p.Replication == 0 {
+		check(fmt.Errorf("stagingreplication requires replication to be set"))
+	}
+
+	if p.ListSort != "" {
+		check(validateListSort(p.ListSort))
+	}
+	inRange("readahead", p.ReadAhead, 0, maxReadAhead)
+	if p.StagingStrategy != "" {
+		check(validateStagingStrategy(p.StagingStrategy))
+	}
+	inRange("stagingthreshold", p.StagingThreshold, 0, math.MaxInt64)
+	if p.DeleteConcurrency != 0 {
+		inRange("deleteconcurrency", p.DeleteConcurrency, 1, 1024)
+	}
+	if p.StatConcurrency != 0 {
+		inRange("statconcurrency", p.StatConcurrency, 1, 1024)
+	}
+	check(validateSnapshotName(p.Snapshot))
+	if p.UploadStateDir != "" {
+		check(validateUploadStateDirectory(path.Clean(p.UploadStateDir), path.Clean("/"+p.HdfsRootDirectory)))
+	}
+	check(validateInstanceID(p.ClaimRoot, p.InstanceID))
+	if p.KmsURI != "" {
+		if _, err := kmsAddress(p.KmsURI); err != nil {
+			check(err)
+		}
+	}
+	inRange("repositoryquota", p.RepositoryQuota, 0, math.MaxInt64)
+	inRange("minfreebytes", p.MinFreeBytes, 0, math.MaxInt64)
+	if p.ReadFromObserver && len(splitList(p.ObserverNameNode)) == 0 {
+		check(fmt.Errorf("The readfromobserver parameter requires observernamenode"))
+	}
+	if p.DialTimeout < 0 {
+		check(fmt.Errorf("The dialtimeout parameter should be a positive duration such as 10s"))
+	}
+	inRange("listretries", p.ListRetries, 0, 10)
+	if p.ListRetryDelay < 0 {
+		check(fmt.Errorf("The listretrydelay parameter should be a positive duration such as 10s"))
+	}
+	if p.LeaseTimeout < 0 {
+		check(fmt.Errorf("The leaserecoverytimeout parameter should be a positive duration such as 10s"))
+	}
+	if p.ReadLogLevel != "" {
+		check(validateReadLogLevel(p.ReadLogLevel))
+	}
+	inRange("readlogsampling", p.ReadLogSampling, 0, math.MaxInt64)
+	if p.HotStoragePolicy == "" && p.ColdStoragePolicy == "" && (p.HotPrefixes != "" || p.ColdAfter != 0) {
+		check(fmt.Errorf("The hotprefixes and coldafter parameters require hotstoragepolicy or coldstoragepolicy"))
+	}
+	if (p.StoragePolicy != "" || p.HotStoragePolicy != "" || p.ColdStoragePolicy != "") && p.WebHdfsAddress == "" && p.WebHdfsPort == 0 && !p.WebHdfsTLS {
+		check(errStoragePolicyWebHdfs)
+	}
+	if p.ColdAfter < 0 {
+		check(fmt.Errorf("The coldafter parameter should be a positive duration such as 720h"))
+	}
+	inRange("readretries", p.ReadRetries, 0, 10)
+	inRange("contentcachesize", p.ContentCacheSize, 0, math.MaxInt64)
+	if _, err := newFaultInjector(p.FaultInjection, 0, 0); err != nil {
+		check(err)
+	}
+	inRange("faultinjectionerrorrate", p.FaultInjectionErrorRate, 0, 100)
+	if p.FaultInjectionDelay < 0 {
+		check(fmt.Errorf("The faultinjectiondelay parameter should be a positive duration such as 10s"))
+	}
+	if p.WritePipelineRecovery != "" {
+		check(validateWritePipelineRecovery(p.WritePipelineRecovery))
+	}
+	if _, err := newTLSConfig(p.TLSCAFile); err != nil {
+		check(err)
+	}
+	inRange("parallelreadthreshold", p.ParallelReadThreshold, 0, math.MaxInt64)
+	if p.ParallelReads != 0 {
+		inRange("parallelreads", p.ParallelReads, 1, 64)
+	}
+	if p.WebHdfsReads && p.WebHdfsAddress == "" && p.WebHdfsPort == 0 && !p.WebHdfsTLS {
+		check(errWebHdfsReadsAddress)
+	}
+	inRange("maxconcurrentuploads", p.MaxConcurrentUploads, 0, math.MaxInt32)
+	if _, err := newUploadLimiter(0, p.MaxConcurrentUploadsMode); err != nil {
+		check(err)
+	}
+	if _, err := parseFavoredNodes(p.FavoredNodes); err != nil {
+		check(err)
+	} else if p.FavoredNodes != "" && p.WebHdfsAddress == "" && p.WebHdfsPort == 0 && !p.WebHdfsTLS {
+		check(errFavoredNodesWebHdfs)
+	}
+	if p.Symlinks != "" {
+		check(validateSymlinks(p.Symlinks))
+	}
+	if p.FlushInterval < 0 {
+		check(fmt.Errorf("The flushinterval parameter should be a positive duration such as 30s"))
+	}
+	if p.DFSPacketSize != 0 {
+		inRange("dfspacketsize", p.DFSPacketSize, minDFSPacketSize, maxDFSPacketSize)
+	}
+	if p.RetryBudget < 0 {
+		check(fmt.Errorf("The retrybudget parameter should be a positive duration such as 30s"))
+	}
+	if p.ProxyURL != "" {
+		if _, err := parseProxyURL(p.ProxyURL); err != nil {
+			check(err)
+		}
+	}
+	if p.LazyConnect && (p.ClaimRoot || p.FixPermissions) {
+		check(fmt.Errorf("The lazyconnect parameter cannot be combined with claimroot or fixpermissions, which need the namenode at startup"))
+	}
+	if p.LockDirectory != "" && !path.IsAbs(p.LockDirectory) {
+		check(fmt.Errorf("The lockdirectory parameter must be an absolute path, %q invalid", p.LockDirectory))
+	}
+	inRange("localcachesize", p.LocalCacheSize, 0, math.MaxInt64)
+	if p.LocalCacheSize > 0 && p.LocalCacheDir == "" {
+		check(fmt.Errorf("The localcachesize parameter requires localcachedirectory"))
+	}
+	if _, err := parseDirectoryModes(p.DirectoryModes); err != nil {
+		check(err)
+	}
+	if p.CloseTimeout < 0 {
+		check(fmt.Errorf("The closetimeout parameter should be a positive duration such as 2m"))
+	}
+	if p.CommitStatTimeout < 0 {
+		check(fmt.Errorf("The commitstattimeout parameter should be a positive duration such as 10s"))
+	}
+	if p.SlowOpLog < 0 {
+		check(fmt.Errorf("The slowoplog parameter should be a positive duration such as 5s"))
+	}
+	if p.AuditLog != "" && !path.IsAbs(p.AuditLog) {
+		check(fmt.Errorf("The auditlog parameter must be an absolute path, %q invalid", p.AuditLog))
+	}
+	inRange("mindeletedepth", p.MinDeleteDepth, 0, math.MaxInt32)
+	inRange("maxdeleteentries", p.MaxDeleteEntries, 0, math.MaxInt64)
+	inRange("maintenanceretries", p.MaintenanceRetries, 0, 10)
+	if p.MaintenanceRetryDelay < 0 {
+		check(fmt.Errorf("The maintenanceretrydelay parameter should be a positive duration such as 2s"))
+	}
+	if p.SocketTimeout < 0 {
+		check(fmt.Errorf("The sockettimeout parameter should be a positive duration such as 60s"))
+	}
+	if p.ReadTimeout < 0 {
+		check(fmt.Errorf("The readtimeout parameter should be a positive duration such as 60s"))
+	}
+	if p.WriteTimeout < 0 {
+		check(fmt.Errorf("The writetimeout parameter should be a positive duration such as 8m"))
+	}
+	if _, err := newPacks(p.PackPrefixes, 0); err != nil {
+		check(err)
+	}
+	inRange("packmaxsize", p.PackMaxSize, 0, maxPackMaxSize)
+	if p.PackPrefixes != "" && p.KmsURI != "" {
+		check(fmt.Errorf("The packprefixes parameter cannot be combined with kmsuri, packs are not encrypted"))
+	}
+	if p.DeleteGrace < 0 {
+		check(fmt.Errorf("The deletegrace parameter should be a positive duration such as 30s"))
+	}
+	if p.HealthProbeInterval < 0 {
+		check(fmt.Errorf("The healthprobeinterval parameter should be a positive duration such as 10s"))
+	}
+	check(validateMirror(p.MirrorMode, p.MirrorFailure))
+	if p.DatanodeBlacklistTTL < 0 {
+		check(fmt.Errorf("The datanodeblacklistttl parameter should be a positive duration such as 5m"))
+	}
+	inRange("datanodeblacklistthreshold", p.DatanodeBlacklistThreshold, 0, 100)
+	check(validateLongPathStrategy(p.LongPathStrategy))
+	if p.MaxPathComponentLength != 0 {
+		inRange("maxpathcomponentlength", p.MaxPathComponentLength, minPathComponentLength, maxHDFSPathLength)
+	}
+	inRange("writebreakerthreshold", p.BreakerThreshold, 0, math.MaxInt32)
+	if p.BreakerCooldown < 0 {
+		check(fmt.Errorf("The writebreakercooldown parameter should be a positive duration such as 10s"))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package hdfs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func validParameters() DriverParameters {
+	return DriverParameters{
+		HdfsRootDirectory:  "/registry",
+		HdfsNameNode:       "namenode:8020",
+		DirectoryUmask:     defaultDirectoryUmask,
+		TransferBufferSize: defaultTransferBufferSize,
+		MaxOpsMode:         "block",
+		ListSort:           listSortName,
+		DeleteConcurrency:  defaultDeleteConcurrency,
+		ListRetryDelay:     defaultListRetryDelay,
+		BreakerCooldown:    defaultWriteBreakerCooldown,
+	}
+}
+
+func TestValidateAcceptsValidParameters(t *testing.T) {
+	params := validParameters()
+	params.WebHdfsPort = 9870
+	params.Replication = 3
+	params.StagingReplication = 1
+	params.PathTransform = "digestprefix"
+	params.Compression = "gzip"
+	params.UploadStateDir = "/registry-state"
+	params.KmsURI = "kms://http@kms:9600/kms"
+	params.DialTimeout = 10 * time.Second
+	if err := params.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The namenode may come from the Hadoop configuration
+	params = validParameters()
+	params.HdfsNameNode = ""
+	params.UseHadoopEnv = true
+	params.WebHdfsPort = 9870
+	if err := params.Validate(); err != nil {
+		t.Fatalf("unexpected error with usehadoopenv: %v", err)
+	}
+}
+
+func TestValidateRejectsInvalidParameters(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(p *DriverParameters)
+		want   string
+	}{
+		{"namenode", func(p *DriverParameters) { p.HdfsNameNode = "" }, "hdfsnamenode"},
+		{"umask zero", func(p *DriverParameters) { p.DirectoryUmask = 0 }, "directoryumask"},
+		{"umask too large", func(p *DriverParameters) { p.DirectoryUmask = 01777 }, "directoryumask"},
+		{"webhdfsport", func(p *DriverParameters) { p.WebHdfsPort = 70000 }, "webhdfsport"},
+		{"webhdfsaddr", func(p *DriverParameters) { p.WebHdfsAddress = "https://nn:9871"; p.WebHdfsTLS = false }, "hdfswebhdfsaddr"},
+		{"transferbuffersize", func(p *DriverParameters) { p.TransferBufferSize = 1 }, "transferbuffersize"},
+		{"maxputcontentsize", func(p *DriverParameters) { p.MaxPutContentSize = -1 }, "maxputcontentsize"},
+		{"readbandwidth", func(p *DriverParameters) { p.ReadBandwidth = -1 }, "readbandwidth"},
+		{"writebandwidth", func(p *DriverParameters) { p.WriteBandwidth = -1 }, "writebandwidth"},
+		{"maxopspersecond", func(p *DriverParameters) { p.MaxOpsPerSecond = -1 }, "maxopspersecond"},
+		{"maxopsmode", func(p *DriverParameters) { p.MaxOpsMode = "sometimes" }, "maxopsmode"},
+		{"pathtransform", func(p *DriverParameters) { p.PathTransform = "bogus" }, "pathtransform"},
+		{"compression", func(p *DriverParameters) { p.Compression = "zstd" }, "compression"},
+		{"replication", func(p *DriverParameters) { p.Replication = -1 }, "replication"},
+		{"replication too large", func(p *DriverParameters) { p.Replication = 1 << 20 }, "replication"},
+		{"stagingreplication", func(p *DriverParameters) { p.StagingReplication = 1 }, "stagingreplication requires replication"},
+		{"listsort", func(p *DriverParameters) { p.ListSort = "size" }, "listsort"},
+		{"stagingstrategy", func(p *DriverParameters) { p.StagingStrategy = "disk" }, "stagingstrategy"},
+		{"deleteconcurrency", func(p *DriverParameters) { p.DeleteConcurrency = 4096 }, "deleteconcurrency"},
+		{"snapshot", func(p *DriverParameters) { p.Snapshot = "a/b" }, "snapshot"},
+		{"uploadstatedirectory relative", func(p *DriverParameters) { p.UploadStateDir = "state" }, "uploadstatedirectory"},
+		{"uploadstatedirectory inside root", func(p *DriverParameters) { p.UploadStateDir = "/registry/state" }, "uploadstatedirectory"},
+		{"kmsuri", func(p *DriverParameters) { p.KmsURI = "ftp://kms" }, "KMS URI"},
+		{"repositoryquota", func(p *DriverParameters) { p.RepositoryQuota = -1 }, "repositoryquota"},
+		{"minfreebytes", func(p *DriverParameters) { p.MinFreeBytes = -1 }, "minfreebytes"},
+		{"readfromobserver", func(p *DriverParameters) { p.ReadFromObserver = true }, "observernamenode"},
+		{"dialtimeout", func(p *DriverParameters) { p.DialTimeout = -time.Second }, "dialtimeout"},
+		{"listretries", func(p *DriverParameters) { p.ListRetries = 11 }, "listretries"},
+		{"listretrydelay", func(p *DriverParameters) { p.ListRetryDelay = -time.Second }, "listretrydelay"},
+		{"leaserecoverytimeout", func(p *DriverParameters) { p.LeaseTimeout = -time.Second }, "leaserecoverytimeout"},
+		{"readloglevel", func(p *DriverParameters) { p.ReadLogLevel = "trace" }, "readloglevel"},
+		{"readlogsampling", func(p *DriverParameters) { p.ReadLogSampling = -1 }, "readlogsampling"},
+		{"hotprefixes", func(p *DriverParameters) { p.HotPrefixes = "/hot" }, "hotstoragepolicy"},
+		{"coldafter", func(p *DriverParameters) { p.ColdStoragePolicy = "COLD"; p.ColdAfter = -time.Hour }, "coldafter"},
+		{"storagepolicy", func(p *DriverParameters) { p.StoragePolicy = "COLD" }, "storagepolicy"},
+		{"hotstoragepolicy", func(p *DriverParameters) { p.HotStoragePolicy = "ALL_SSD" }, "storagepolicy"},
+		{"readretries", func(p *DriverParameters) { p.ReadRetries = 11 }, "readretries"},
+		{"contentcachesize", func(p *DriverParameters) { p.ContentCacheSize = -1 }, "contentcachesize"},
+		{"faultinjectionerrorrate", func(p *DriverParameters) { p.FaultInjectionErrorRate = 101 }, "faultinjectionerrorrate"},
+		{"writepipelinerecovery", func(p *DriverParameters) { p.WritePipelineRecovery = "sometimes" }, "writepipelinerecovery"},
+		{"tlscafile", func(p *DriverParameters) { p.TLSCAFile = "/nonexistent/ca.pem" }, "tlscafile"},
+		{"parallelreads", func(p *DriverParameters) { p.ParallelReads = 65 }, "parallelreads"},
+		{"webhdfsreads", func(p *DriverParameters) { p.WebHdfsReads = true }, "webhdfsreads"},
+		{"maxconcurrentuploads", func(p *DriverParameters) { p.MaxConcurrentUploads = -1 }, "maxconcurrentuploads"},
+		{"maxconcurrentuploadsmode", func(p *DriverParameters) { p.MaxConcurrentUploadsMode = "sometimes" }, "maxconcurrentuploadsmode"},
+		{"favorednodes", func(p *DriverParameters) { p.FavoredNodes = "dn1:9866,dn2" }, "favorednodes"},
+		{"favorednodes webhdfs", func(p *DriverParameters) { p.FavoredNodes = "dn1:9866" }, "requires hdfswebhdfsaddr"},
+		{"symlinks", func(p *DriverParameters) { p.Symlinks = "sometimes" }, "symlinks"},
+		{"flushinterval", func(p *DriverParameters) { p.FlushInterval = -time.Second }, "flushinterval"},
+		{"dfspacketsize", func(p *DriverParameters) { p.DFSPacketSize = 100 }, "dfspacketsize"},
+		{"retrybudget", func(p *DriverParameters) { p.RetryBudget = -time.Second }, "retrybudget"},
+		{"proxyurl", func(p *DriverParameters) { p.ProxyURL = "ftp://proxy:21" }, "proxyurl"},
+		{"lazyconnect", func(p *DriverParameters) { p.LazyConnect = true; p.FixPermissions = true }, "lazyconnect"},
+		{"lockdirectory", func(p *DriverParameters) { p.LockDirectory = "locks" }, "lockdirectory"},
+		{"localcachesize", func(p *DriverParameters) { p.LocalCacheSize = 1 << 20 }, "localcachedirectory"},
+		{"directorymodes", func(p *DriverParameters) { p.DirectoryModes = "0775,0800" }, "directorymodes"},
+		{"closetimeout", func(p *DriverParameters) { p.CloseTimeout = -time.Second }, "closetimeout"},
+		{"commitstattimeout", func(p *DriverParameters) { p.CommitStatTimeout = -time.Second }, "commitstattimeout"},
+		{"slowoplog", func(p *DriverParameters) { p.SlowOpLog = -time.Second }, "slowoplog"},
+		{"auditlog", func(p *DriverParameters) { p.AuditLog = "audit.log" }, "auditlog"},
+		{"mindeletedepth", func(p *DriverParameters) { p.MinDeleteDepth = -1 }, "mindeletedepth"},
+		{"maxdeleteentries", func(p *DriverParameters) { p.MaxDeleteEntries = -1 }, "maxdeleteentries"},
+		{"maintenanceretries", func(p *DriverParameters) { p.MaintenanceRetries = 11 }, "maintenanceretries"},
+		{"maintenanceretrydelay", func(p *DriverParameters) { p.MaintenanceRetryDelay = -time.Second }, "maintenanceretrydelay"},
+		{"sockettimeout", func(p *DriverParameters) { p.SocketTimeout = -time.Second }, "sockettimeout"},
+		{"readtimeout", func(p *DriverParameters) { p.ReadTimeout = -time.Second }, "readtimeout"},
+		{"writetimeout", func(p *DriverParameters) { p.WriteTimeout = -time.Second }, "writetimeout"},
+		{"packprefixes", func(p *DriverParameters) { p.PackPrefixes = "links" }, "packprefixes"},
+		{"packmaxsize", func(p *DriverParameters) { p.PackMaxSize = 1 << 30 }, "packmaxsize"},
+		{"packprefixes kmsuri", func(p *DriverParameters) { p.PackPrefixes = "/links"; p.KmsURI = "kms://http@kms:9600/kms" }, "kmsuri"},
+		{"deletegrace", func(p *DriverParameters) { p.DeleteGrace = -time.Second }, "deletegrace"},
+		{"healthprobeinterval", func(p *DriverParameters) { p.HealthProbeInterval = -time.Second }, "healthprobeinterval"},
+		{"mirrormode", func(p *DriverParameters) { p.MirrorMode = "sometimes" }, "mirrormode"},
+		{"mirrorfailure", func(p *DriverParameters) { p.MirrorFailure = "fail" }, "mirrorfailure"},
+		{"datanodeblacklistttl", func(p *DriverParameters) { p.DatanodeBlacklistTTL = -time.Minute }, "datanodeblacklistttl"},
+		{"datanodeblacklistthreshold", func(p *DriverParameters) { p.DatanodeBlacklistThreshold = 101 }, "datanodeblacklistthreshold"},
+		{"longpathstrategy", func(p *DriverParameters) { p.LongPathStrategy = "truncate" }, "longpathstrategy"},
+		{"maxpathcomponentlength", func(p *DriverParameters) { p.MaxPathComponentLength = 8 }, "maxpathcomponentlength"},
+		{"writebreakerthreshold", func(p *DriverParameters) { p.BreakerThreshold = -1 }, "writebreakerthreshold"},
+		{"writebreakercooldown", func(p *DriverParameters) { p.BreakerCooldown = -time.Second }, "writebreakercooldown"},
+	} {
+		params := validParameters()
+		tc.modify(&params)
+		err := params.Validate()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error mentioning %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	params := validParameters()
+	params.HdfsNameNode = ""
+	params.DirectoryUmask = 0
+	params.ListSort = "size"
+
+	err := params.Validate()
+	errs, ok := err.(validationErrors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", err)
+	}
+
+	// FromParameters validates before connecting
+	_, err = FromParameters(map[string]interface{}{"stagingreplication": 2, "listsort": "size"})
+	if errs, ok := err.(validationErrors); !ok || len(errs) != 3 {
+		t.Fatalf("expected FromParameters to report 3 errors, got %v", err)
+	}
+}
+
+func TestParseDirectoryUmask(t *testing.T) {
+	for value, expected := range map[interface{}]int{0700: 0700, int64(0750): 0750, "0770": 0770, "448": 0700} {
+		params, err := parseParameters(map[string]interface{}{"directoryumask": value})
+		if err != nil || params.DirectoryUmask != expected {
+			t.Errorf("expected directoryumask %#v to be %#o, got %#o, %v", value, expected, params.DirectoryUmask, err)
+		}
+	}
+	// Values that are not integers are rejected rather than panicking
+	for _, value := range []interface{}{"rwxr-xr-x", 0755.0, "01777", 0, "0"} {
+		if _, err := parseParameters(map[string]interface{}{"directoryumask": value}); err == nil || !strings.Contains(err.Error(), "directoryumask") {
+			t.Errorf("expected directoryumask %#v to be rejected, got %v", value, err)
+		}
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	params := DefaultParameters()
+	if params.DirectoryUmask != defaultDirectoryUmask || !params.RecoverPanics || params.ListSort != listSortName {
+		t.Fatalf("expected the FromParameters defaults, got %+v", params)
+	}
+
+	// Typed configuration is validated like a parameters map
+	params.HdfsNameNode = ""
+	params.ListSort = "size"
+	if _, err := NewFromConfig(params); err == nil {
+		t.Fatal("expected an invalid configuration to be rejected")
+	} else if errs, ok := err.(validationErrors); !ok || len(errs) != 2 {
+		t.Fatalf("expected 2 validation errors, got %v", err)
+	}
+
+	// A valid configuration gets as far as connecting
+	params = DefaultParameters()
+	params.HdfsNameNode = "127.0.0.1:1"
+	params.DialTimeout = time.Second
+	if _, err := NewFromConfig(params); err == nil {
+		t.Fatal("expected connecting to an unreachable namenode to fail")
+	} else if _, ok := err.(validationErrors); ok {
+		t.Fatalf("expected a connection error, got %v", err)
+	}
+}
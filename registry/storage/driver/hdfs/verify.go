@@ -0,0 +1,90 @@
+package hdfs
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// Verifier is implemented by drivers that can check their tree for signs of
+// corruption, such as the HDFS driver. It is meant for diagnosing a
+// registry after a bad deploy, not for routine use: every file is opened.
+type Verifier interface {
+	// Verify walks the tree below prefix and returns the files that are
+	// empty or cannot be read. The error is only set when prefix itself
+	// cannot be walked.
+	Verify(ctx context.Context, prefix string) ([]SuspiciousFile, error)
+}
+
+// SuspiciousFile is a file reported by Verify
+type SuspiciousFile struct {
+	Path   string
+	Reason string
+}
+
+// Verify implements Verifier
+func (d *Driver) Verify(ctx context.Context, prefix string) ([]SuspiciousFile, error) {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.Verify(%q)", d.Name(), prefix)
+
+	prefix, err := d.normalize(prefix)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := d.Stat(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var suspicious []SuspiciousFile
+	if fi.IsDir() {
+		d.inner().verifyDir(ctx, prefix, &suspicious)
+	} else {
+		d.inner().verifyFile(ctx, prefix, fi, &suspicious)
+	}
+	return suspicious, nil
+}
+
+func (d *driver) verifyDir(ctx context.Context, dir string, suspicious *[]SuspiciousFile) {
+	list := func(dir string) ([]string, error) {
+		children, err := d.List(ctx, dir)
+		if err != nil {
+			*suspicious = append(*suspicious, SuspiciousFile{Path: dir, Reason: fmt.Sprintf("cannot list: %v", err)})
+		}
+		return children, nil
+	}
+	walkTree(dir, list, func(child string) (bool, error) {
+		fi, err := d.Stat(ctx, child)
+		if err != nil {
+			*suspicious = append(*suspicious, SuspiciousFile{Path: child, Reason: fmt.Sprintf("cannot stat: %v", err)})
+			return false, nil
+		}
+		if fi.IsDir() {
+			return true, nil
+		}
+		d.verifyFile(ctx, child, fi, suspicious)
+		return false, nil
+	})
+}
+
+// verifyFile reads the first byte of a file, which fails when its first
+// block is missing or corrupt on every datanode
+func (d *driver) verifyFile(ctx context.Context, subPath string, fi storagedriver.FileInfo, suspicious *[]SuspiciousFile) {
+	if fi.Size() == 0 {
+		*suspicious = append(*suspicious, SuspiciousFile{Path: subPath, Reason: "zero length"})
+		return
+	}
+
+	reader, err := d.Reader(ctx, subPath, 0)
+	if err != nil {
+		*suspicious = append(*suspicious, SuspiciousFile{Path: subPath, Reason: fmt.Sprintf("cannot open: %v", err)})
+		return
+	}
+	defer reader.Close()
+
+	if _, err := io.ReadFull(reader, make([]byte, 1)); err != nil {
+		*suspicious = append(*suspicious, SuspiciousFile{Path: subPath, Reason: fmt.Sprintf("cannot read: %v", err)})
+	}
+}
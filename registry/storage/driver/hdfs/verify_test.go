@@ -0,0 +1,50 @@
+package hdfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestVerify(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/blobs/sha256/aa/good/data", []byte("layer"))
+	client.writeFile("/registry/blobs/sha256/bb/empty/data", nil)
+	client.writeFile("/registry/blobs/sha256/cc/lost/data", []byte("layer"))
+	client.writeFile("/registry/blobs/sha256/dd/good/data", []byte("layer"))
+	client.hook("Open", func(name string) error {
+		if name == "/registry/blobs/sha256/cc/lost/data" {
+			return errors.New("could not obtain block")
+		}
+		return nil
+	})
+
+	var sd storagedriver.StorageDriver = wrap(newTestDriver(client))
+	verifier, ok := sd.(Verifier)
+	if !ok {
+		t.Fatalf("expected the driver to implement Verifier")
+	}
+
+	suspicious, err := verifier.Verify(context.Background(), "/blobs")
+	if err != nil {
+		t.Fatalf("unexpected error from Verify: %v", err)
+	}
+	var paths []string
+	for _, s := range suspicious {
+		paths = append(paths, s.Path)
+	}
+	expected := []string{"/blobs/sha256/bb/empty/data", "/blobs/sha256/cc/lost/data"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %v to be reported, got %+v", expected, suspicious)
+	}
+	if suspicious[0].Reason != "zero length" {
+		t.Fatalf("unexpected reason for the empty file: %q", suspicious[0].Reason)
+	}
+
+	if _, err := verifier.Verify(context.Background(), "/missing"); !isPathNotFound(err) {
+		t.Fatalf("expected a missing prefix to be an error, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package hdfs
+
+import (
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// WalkFn is called by Walk for every path below the walked one. It is
+// called a second time for a directory that cannot be read, with the error
+// from the namenode; returning nil then skips the directory and carries on
+// with its siblings. Any error returned stops the walk and is returned by
+// Walk.
+type WalkFn func(fi storagedriver.FileInfo, err error) error
+
+// Walker is implemented by drivers that can walk a tree themselves, such as
+// the HDFS driver. Unlike walking with List, which lists a directory it
+// cannot read as empty, directories that cannot be read are reported, so
+// callers like garbage collection can tell a missing subtree from an empty
+// one and decide whether to go on.
+type Walker interface {
+	// Walk calls f for everything below path, depth first in List order
+	Walk(ctx context.Context, path string, f WalkFn) error
+}
+
+// Walk implements Walker
+func (d *Driver) Walk(ctx context.Context, path string, f WalkFn) error {
+	ctx, done := context.WithTrace(ctx)
+	defer done("%s.Walk(%q)", d.Name(), path)
+
+	path, err := d.inner().checkPath(path, true)
+	if err != nil {
+		return err
+	}
+	return d.inner().walk(ctx, path, f)
+}
+
+func (d *driver) walk(ctx context.Context, from string, f WalkFn) error {
+	if err := d.checkClient(); err != nil {
+		return err
+	}
+	root, err := d.Stat(ctx, from)
+	if err != nil {
+		return err
+	}
+	if !root.IsDir() {
+		return f(root, nil)
+	}
+
+	// The listing of a directory carries the FileInfo of its entries, which
+	// are kept until they are visited rather than asked for again
+	infos := map[string]storagedriver.FileInfo{from: root}
+	list := func(dir string) ([]string, error) {
+		fi := infos[dir]
+		delete(infos, dir)
+		entries, err := d.readEntries(ctx, dir)
+		if unreadable, ok := err.(errUnreadableDirectory); ok {
+			return nil, f(fi, unreadable.err)
+		} else if err != nil {
+			return nil, err
+		}
+		sortEntries(entries, d.listSort)
+		children, err := d.entryInfos(entries)
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, len(children))
+		for i, child := range children {
+			paths[i] = child.Path()
+			infos[paths[i]] = child
+		}
+		return paths, nil
+	}
+	return walkTree(from, list, func(p string) (bool, error) {
+		fi := infos[p]
+		if !fi.IsDir() {
+			delete(infos, p)
+		}
+		return fi.IsDir(), f(fi, nil)
+	})
+}
+
+// walkTree visits everything below from depth first, in the order list
+// returns it. It keeps the pending paths on an explicit stack rather than
+// recursing, so a deep or adversarial tree grows a slice instead of the
+// goroutine stack. visit is called for every path and reports whether the
+// path is a directory to descend into; list and visit errors stop the walk.
+func walkTree(from string, list func(dir string) ([]string, error), visit func(p string) (bool, error)) error {
+	children, err := list(from)
+	if err != nil {
+		return err
+	}
+	stack := pushReversed(nil, children)
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		descend, err := visit(p)
+		if err != nil {
+			return err
+		}
+		if !descend {
+			continue
+		}
+		children, err := list(p)
+		if err != nil {
+			return err
+		}
+		stack = pushReversed(stack, children)
+	}
+	return nil
+}
+
+// pushReversed pushes paths onto stack so that the first is popped first
+func pushReversed(stack, paths []string) []string {
+	for i := len(paths) - 1; i >= 0; i-- {
+		stack = append(stack, paths[i])
+	}
+	return stack
+}
@@ -0,0 +1,200 @@
+package hdfs
+
+import (
+	"errors"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// deepClient serves a synthetic chain of depth directories named component
+// below root, ending in an empty file named data, without storing it.
+// onDeepest is called when the last directory is read.
+type deepClient struct {
+	*fakeClient
+	root      string
+	component string
+	depth     int
+	onDeepest func()
+}
+
+// level returns how many directories below root name is, and whether it
+// is the data file at the bottom
+func (c *deepClient) level(name string) (level int, leaf, ok bool) {
+	rest := strings.TrimPrefix(name, c.root)
+	if rest == name {
+		return 0, false, false
+	}
+	if strings.HasSuffix(rest, "/data") {
+		rest, leaf = strings.TrimSuffix(rest, "/data"), true
+	}
+	level = len(rest) / (len(c.component) + 1)
+	if level > c.depth || rest != strings.Repeat("/"+c.component, level) || (leaf && level != c.depth) {
+		return 0, false, false
+	}
+	return level, leaf, true
+}
+
+func (c *deepClient) Stat(name string) (os.FileInfo, error) {
+	_, leaf, ok := c.level(name)
+	if !ok {
+		return c.fakeClient.Stat(name)
+	}
+	if leaf {
+		return fakeFileInfo{name: "data", mode: 0644}, nil
+	}
+	return fakeFileInfo{name: path.Base(name), mode: os.ModeDir | 0755, isDir: true}, nil
+}
+
+func (c *deepClient) ReadDir(dirname string) ([]os.FileInfo, error) {
+	level, leaf, ok := c.level(dirname)
+	if !ok || leaf {
+		return c.fakeClient.ReadDir(dirname)
+	}
+	if level < c.depth {
+		return []os.FileInfo{fakeFileInfo{name: c.component, mode: os.ModeDir | 0755, isDir: true}}, nil
+	}
+	c.onDeepest()
+	return []os.FileInfo{fakeFileInfo{name: "data", mode: 0644}}, nil
+}
+
+// newDeepTestDriver returns a driver over a deep tree below subPath and a
+// function reporting how much the goroutine stacks grew while it was walked
+func newDeepTestDriver(t *testing.T, subPath, component string, params DriverParameters) (*driver, func() uint64) {
+	fake := newFakeClient()
+	fake.MkdirAll("/registry"+subPath, 0755)
+
+	var before, deepest runtime.MemStats
+	client := &deepClient{
+		fakeClient: fake,
+		root:       "/registry" + subPath,
+		component:  component,
+		depth:      5000,
+		onDeepest:  func() { runtime.ReadMemStats(&deepest) },
+	}
+	d := newTestDriverWithParameters(client, params)
+	runtime.ReadMemStats(&before)
+
+	return d, func() uint64 {
+		if deepest.StackInuse == 0 {
+			t.Fatal("the bottom of the tree was never read")
+		}
+		if deepest.StackInuse < before.StackInuse {
+			return 0
+		}
+		return deepest.StackInuse - before.StackInuse
+	}
+}
+
+// maxStackGrowth is far below what recursing through the test trees takes
+const maxStackGrowth = 512 << 10
+
+func TestVerifyDeepTree(t *testing.T) {
+	d, growth := newDeepTestDriver(t, "/deep", "d", DriverParameters{})
+
+	suspicious, err := wrap(d).Verify(context.Background(), "/deep")
+	if err != nil {
+		t.Fatalf("unexpected error from Verify: %v", err)
+	}
+	if len(suspicious) != 1 || !strings.HasSuffix(suspicious[0].Path, "/d/data") {
+		t.Fatalf("expected the empty file at the bottom to be reported, got %d files", len(suspicious))
+	}
+	if g := growth(); g > maxStackGrowth {
+		t.Fatalf("walking the tree grew the stack by %d bytes", g)
+	}
+}
+
+func TestMigrateWalkDeepTree(t *testing.T) {
+	d, growth := newDeepTestDriver(t, "/deep", "d", DriverParameters{})
+
+	var files []string
+	err := migrateWalk(context.Background(), wrap(d), "/deep", func(fi storagedriver.FileInfo) error {
+		files = append(files, fi.Path())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error from migrateWalk: %v", err)
+	}
+	if len(files) != 1 || !strings.HasSuffix(files[0], "/d/data") {
+		t.Fatalf("expected the file at the bottom to be visited, got %d files", len(files))
+	}
+	if g := growth(); g > maxStackGrowth {
+		t.Fatalf("walking the tree grew the stack by %d bytes", g)
+	}
+}
+
+func TestListDeepIntermediateDirectories(t *testing.T) {
+	d, growth := newDeepTestDriver(t, "/deep", "~ab", DriverParameters{PathTransform: "digestprefix"})
+
+	entries, err := d.List(context.Background(), "/deep")
+	if err != nil {
+		t.Fatalf("unexpected error from List: %v", err)
+	}
+	if len(entries) != 1 || entries[0] != "/deep/data" {
+		t.Fatalf("expected the nested file to be listed as /deep/data, got %v", entries)
+	}
+	if g := growth(); g > maxStackGrowth {
+		t.Fatalf("listing the tree grew the stack by %d bytes", g)
+	}
+}
+
+func TestWalkReportsUnreadableDirectories(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/repo/a/file", []byte("a"))
+	client.writeFile("/registry/repo/b/file", []byte("b"))
+	client.writeFile("/registry/repo/c/file", []byte("c"))
+	denied := errors.New("Permission denied: user=registry, access=READ_EXECUTE, inode=\"/registry/repo/b\"")
+	client.hook("ReadDir", func(name string) error {
+		if name == "/registry/repo/b" {
+			return denied
+		}
+		return nil
+	})
+	d := wrap(newTestDriver(client))
+
+	// List drops the subtree
+	if children, err := d.List(context.Background(), "/repo/b"); err != nil || len(children) != 0 {
+		t.Fatalf("expected List to return nothing for the unreadable directory, got %v, %v", children, err)
+	}
+
+	var visited, unreadable []string
+	err := d.Walk(context.Background(), "/repo", func(fi storagedriver.FileInfo, err error) error {
+		if err != nil {
+			if err != denied {
+				t.Errorf("expected the namenode error for %s, got %v", fi.Path(), err)
+			}
+			unreadable = append(unreadable, fi.Path())
+			return nil
+		}
+		visited = append(visited, fi.Path())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error from Walk: %v", err)
+	}
+	if strings.Join(unreadable, ",") != "/repo/b" {
+		t.Fatalf("expected /repo/b to be reported, got %v", unreadable)
+	}
+	if want := "/repo/a,/repo/a/file,/repo/b,/repo/c,/repo/c/file"; strings.Join(visited, ",") != want {
+		t.Fatalf("expected the walk to visit %s, got %v", want, visited)
+	}
+
+	// The callback can give up on the first unreadable directory instead
+	err = d.Walk(context.Background(), "/repo", func(fi storagedriver.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Path() == "/repo/c" {
+			t.Errorf("expected the walk to stop before /repo/c")
+		}
+		return nil
+	})
+	if err != denied {
+		t.Fatalf("expected Walk to return the callback's error, got %v", err)
+	}
+}
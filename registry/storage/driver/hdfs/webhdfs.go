@@ -0,0 +1,348 @@
+package hdfs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+// webHdfsPrefix is the path prefix of every WebHDFS REST endpoint
+const webHdfsPrefix = "/webhdfs/v1"
+
+// Default namenode HTTP ports of Hadoop 3. Hadoop 2 clusters listen on
+// 50070 and 50470 and need webhdfsport set.
+const (
+	defaultWebHdfsPort    = 9870
+	defaultWebHdfsTLSPort = 9871
+)
+
+// webHdfsClient talks to the namenode's WebHDFS REST API. It hands out
+// delegation tokens for redirect URLs and performs the operations
+// colinmarc/hdfs has no RPC for, such as SETREPLICATION, TRUNCATE and
+// SETXATTR. Data transfer done by the driver itself goes through the RPC
+// client, but for files created with favored nodes and, with webhdfsreads,
+// reads.
+type webHdfsClient struct {
+	address string
+	user    string
+	client  *http.Client
+
+	// token, when set, authenticates the operations of the driver in place
+	// of user.name, see applyTokenFile
+	token string
+
+	// packetSize is the dfspacketsize reads ask for, see setBufferSize
+	packetSize int64
+}
+
+// webHdfsRemoteException is the error body returned by WebHDFS
+type webHdfsRemoteException struct {
+	RemoteException struct {
+		Exception     string `json:"exception"`
+		JavaClassName string `json:"javaClassName"`
+		Message       string `json:"message"`
+	} `json:"RemoteException"`
+}
+
+// webHdfsAddress returns the WebHDFS endpoint to use, or "" when WebHDFS is
+// not configured. An explicit hdfswebhdfsaddr wins; otherwise setting
+// webhdfsport or webhdfstls derives the address from the first namenode's
+// host. With HA namenodes that may be the standby, in which case token
+// requests fail and URLFor falls back to ErrUnsupportedMethod.
+func webHdfsAddress(params DriverParameters) (string, error) {
+	scheme := "http"
+	if params.WebHdfsTLS {
+		scheme = "https"
+	}
+
+	if params.WebHdfsAddress != "" {
+		if !strings.Contains(params.WebHdfsAddress, "://") {
+			return scheme + "://" + params.WebHdfsAddress, nil
+		}
+		u, err := url.Parse(params.WebHdfsAddress)
+		if err != nil {
+			return "", fmt.Errorf("invalid hdfswebhdfsaddr %q: %v", params.WebHdfsAddress, err)
+		}
+		if u.Scheme != scheme {
+			return "", fmt.Errorf("hdfswebhdfsaddr %q does not match webhdfstls=%t", params.WebHdfsAddress, params.WebHdfsTLS)
+		}
+		return params.WebHdfsAddress, nil
+	}
+
+	if params.WebHdfsPort == 0 && !params.WebHdfsTLS {
+		return "", nil
+	}
+	namenodes := splitList(params.HdfsNameNode)
+	if len(namenodes) == 0 {
+		return "", fmt.Errorf("deriving the WebHDFS address requires hdfsnamenode, or set hdfswebhdfsaddr")
+	}
+	host := namenodes[0]
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	port := params.WebHdfsPort
+	if port == 0 {
+		port = defaultWebHdfsPort
+		if params.WebHdfsTLS {
+			port = defaultWebHdfsTLSPort
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.FormatInt(port, 10)), nil
+}
+
+func newWebHdfsClient(address, user string) *webHdfsClient {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &webHdfsClient{
+		address: strings.TrimRight(address, "/"),
+		user:    user,
+		client:  http.DefaultClient,
+	}
+}
+
+// endpoint builds the WebHDFS URL for the operation on the given HDFS path
+func (w *webHdfsClient) endpoint(hdfsPath string, query url.Values) string {
+	u, err := url.Parse(w.address)
+	if err != nil {
+		// The address is validated when the driver is constructed, so
+		// fall back to plain concatenation
+		return w.address + webHdfsPrefix + hdfsPath + "?" + query.Encode()
+	}
+	prefix := strings.TrimRight(u.Path, "/") + webHdfsPrefix
+	u.Path = prefix + hdfsPath
+	u.RawPath = prefix + escapeHdfsPath(hdfsPath)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// escapeHdfsPath percent-encodes every component of hdfsPath. Plus signs are
+// encoded as well, since some WebHDFS servers decode them as spaces in paths.
+func escapeHdfsPath(hdfsPath string) string {
+	components := strings.Split(hdfsPath, "/")
+	for i, component := range components {
+		components[i] = strings.Replace(url.QueryEscape(component), "+", "%20", -1)
+	}
+	return strings.Join(components, "/")
+}
+
+// getDelegationToken asks the namenode for a delegation token that can be
+// used to read files without any further credentials.
+func (w *webHdfsClient) getDelegationToken() (string, error) {
+	query := url.Values{}
+	query.Set("op", "GETDELEGATIONTOKEN")
+	query.Set("renewer", w.user)
+	query.Set("user.name", w.user)
+
+	resp, err := w.client.Get(w.endpoint("/", query))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", webHdfsError(resp)
+	}
+
+	var body struct {
+		Token struct {
+			URLString string `json:"urlString"`
+		} `json:"Token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding delegation token: %v", err)
+	}
+	if body.Token.URLString == "" {
+		return "", fmt.Errorf("namenode returned an empty delegation token")
+	}
+	return body.Token.URLString, nil
+}
+
+// cancelDelegationToken invalidates the token on the namenode
+func (w *webHdfsClient) cancelDelegationToken(token string) error {
+	query := url.Values{}
+	query.Set("op", "CANCELDELEGATIONTOKEN")
+	query.Set("token", token)
+	query.Set("user.name", w.user)
+
+	req, err := http.NewRequest("PUT", w.endpoint("/", query), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return webHdfsError(resp)
+	}
+	return nil
+}
+
+// operation issues the WebHDFS operation of query on hdfsPath as the
+// driver and returns the response of a successful one, which the caller
+// closes
+func (w *webHdfsClient) operation(method, hdfsPath string, query url.Values) (*http.Response, error) {
+	if w.token != "" {
+		query.Set("delegation", w.token)
+	} else {
+		query.Set("user.name", w.user)
+	}
+	req, err := http.NewRequest(method, w.endpoint(hdfsPath, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, webHdfsError(resp)
+	}
+	return resp, nil
+}
+
+// booleanOp issues the WebHDFS operation of query on hdfsPath, which
+// answers with a boolean
+func (w *webHdfsClient) booleanOp(method, hdfsPath string, query url.Values) (bool, error) {
+	resp, err := w.operation(method, hdfsPath, query)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Boolean bool `json:"boolean"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, fmt.Errorf("decoding %s response: %v", query.Get("op"), err)
+	}
+	return body.Boolean, nil
+}
+
+// SetReplication implements replicationSetter with SETREPLICATION
+func (w *webHdfsClient) SetReplication(name string, replication int) error {
+	query := url.Values{}
+	query.Set("op", "SETREPLICATION")
+	query.Set("replication", strconv.Itoa(replication))
+	set, err := w.booleanOp("PUT", name, query)
+	if err == nil && !set {
+		err = fmt.Errorf("webhdfs: the replication of %s was not set, it is not a file", name)
+	}
+	return err
+}
+
+// SetStoragePolicy implements storagePolicySetter with SETSTORAGEPOLICY
+func (w *webHdfsClient) SetStoragePolicy(name string, policy string) error {
+	query := url.Values{}
+	query.Set("op", "SETSTORAGEPOLICY")
+	query.Set("storagepolicy", policy)
+	resp, err := w.operation("PUT", name, query)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Truncate implements truncater with TRUNCATE
+func (w *webHdfsClient) Truncate(name string, size int64) (bool, error) {
+	query := url.Values{}
+	query.Set("op", "TRUNCATE")
+	query.Set("newlength", strconv.FormatInt(size, 10))
+	return w.booleanOp("POST", name, query)
+}
+
+// SetXAttr implements xattrClient with SETXATTR
+func (w *webHdfsClient) SetXAttr(name, attr string, value []byte) error {
+	query := url.Values{}
+	query.Set("op", "SETXATTR")
+	query.Set("xattr.name", attr)
+	query.Set("xattr.value", "0s"+base64.StdEncoding.EncodeToString(value))
+	query.Set("flag", "CREATE")
+	resp, err := w.operation("PUT", name, query)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// XAttrs implements xattrClient with GETXATTRS
+func (w *webHdfsClient) XAttrs(name string) (map[string][]byte, error) {
+	query := url.Values{}
+	query.Set("op", "GETXATTRS")
+	query.Set("encoding", "base64")
+	resp, err := w.operation("GET", name, query)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		XAttrs []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"XAttrs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding GETXATTRS response: %v", err)
+	}
+	attrs := make(map[string][]byte, len(body.XAttrs))
+	for _, attr := range body.XAttrs {
+		value, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(attr.Value, "0s"))
+		if err != nil {
+			return nil, fmt.Errorf("decoding the value of %s: %v", attr.Name, err)
+		}
+		attrs[attr.Name] = value
+	}
+	return attrs, nil
+}
+
+// cancelDelegationTokenAfter cancels the token once expiresIn has elapsed, so
+// that a URL handed out by URLFor stops working at its requested expiry
+// rather than at the namenode's token lifetime.
+func (w *webHdfsClient) cancelDelegationTokenAfter(ctx context.Context, token string, expiresIn time.Duration) *time.Timer {
+	logger := context.GetLogger(ctx)
+	return time.AfterFunc(expiresIn, func() {
+		if err := w.cancelDelegationToken(token); err != nil {
+			logger.Errorf("hdfs: unable to cancel WebHDFS delegation token: %v", err)
+		}
+	})
+}
+
+// openURL returns the URL reading hdfsPath with the given delegation token.
+// WebHDFS always serves files as application/octet-stream and ignores
+// parameters it does not know, so a non-empty contentType is passed as a
+// contenttype parameter for a proxy in front of the namenode to turn into
+// the Content-Type of the response. The namenode drops it when it
+// redirects to a datanode.
+func (w *webHdfsClient) openURL(hdfsPath, token, contentType string) string {
+	query := url.Values{}
+	query.Set("op", "OPEN")
+	query.Set("delegation", token)
+	setBufferSize(query, w.packetSize)
+	if contentType != "" {
+		query.Set("contenttype", contentType)
+	}
+	return w.endpoint(hdfsPath, query)
+}
+
+// webHdfsError converts a failed WebHDFS response into an error
+func webHdfsError(resp *http.Response) error {
+	var remote webHdfsRemoteException
+	if err := json.NewDecoder(resp.Body).Decode(&remote); err == nil && remote.RemoteException.Exception != "" {
+		return fmt.Errorf("webhdfs: %s: %s", remote.RemoteException.Exception, remote.RemoteException.Message)
+	}
+	return fmt.Errorf("webhdfs: unexpected status %s", resp.Status)
+}
@@ -0,0 +1,370 @@
+package hdfs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+const testDelegationToken = "HAAEaGRmcwRoZGZz+/="
+
+// fakeWebHdfs serves the delegation token endpoints of a namenode, and the
+// CREATE, SETREPLICATION, SETSTORAGEPOLICY, TRUNCATE and extended
+// attribute operations on the files of namenode
+type fakeWebHdfs struct {
+	sync.Mutex
+	issued    int
+	cancelled []string
+	fail      bool
+	namenode  *fakeClient
+
+	// delegated are the tokens operations were authenticated with
+	delegated []string
+}
+
+func (f *fakeWebHdfs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.fail {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"RemoteException":{"exception":"SecurityException","javaClassName":"java.lang.SecurityException","message":"Failed to obtain user group information"}}`))
+		return
+	}
+
+	switch r.URL.Query().Get("op") {
+	case "GETDELEGATIONTOKEN":
+		f.issued++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Token":{"urlString":"` + testDelegationToken + `"}}`))
+	case "CANCELDELEGATIONTOKEN":
+		if r.Method != "PUT" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		f.cancelled = append(f.cancelled, r.URL.Query().Get("token"))
+	case "SETREPLICATION":
+		replication, _ := strconv.Atoi(r.URL.Query().Get("replication"))
+		err := f.namenode.SetReplication(strings.TrimPrefix(r.URL.Path, webHdfsPrefix), replication)
+		f.writeBoolean(w, r, "PUT", err)
+	case "SETSTORAGEPOLICY":
+		err := f.namenode.SetStoragePolicy(strings.TrimPrefix(r.URL.Path, webHdfsPrefix), r.URL.Query().Get("storagepolicy"))
+		f.writeJSON(w, r, "PUT", err, nil)
+	case "CREATE":
+		f.create(w, r)
+	case "TRUNCATE":
+		size, _ := strconv.ParseInt(r.URL.Query().Get("newlength"), 10, 64)
+		_, err := f.namenode.Truncate(strings.TrimPrefix(r.URL.Path, webHdfsPrefix), size)
+		f.writeBoolean(w, r, "POST", err)
+	case "SETXATTR":
+		value, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Query().Get("xattr.value"), "0s"))
+		err := f.namenode.SetXAttr(strings.TrimPrefix(r.URL.Path, webHdfsPrefix), r.URL.Query().Get("xattr.name"), value)
+		f.writeJSON(w, r, "PUT", err, nil)
+	case "GETXATTRS":
+		attrs, err := f.namenode.XAttrs(strings.TrimPrefix(r.URL.Path, webHdfsPrefix))
+		var body struct {
+			XAttrs []map[string]string `json:"XAttrs"`
+		}
+		for name, value := range attrs {
+			body.XAttrs = append(body.XAttrs, map[string]string{"name": name, "value": "0s" + base64.StdEncoding.EncodeToString(value)})
+		}
+		f.writeJSON(w, r, "GET", err, body)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// create answers a CREATE like the namenode, with a redirect to a datanode,
+// and then like the datanode, writing the body to the file
+func (f *fakeWebHdfs) create(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if r.Method != "PUT" || query.Get("user.name") != "registry" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if query.Get("datanode") == "" {
+		w.Header().Set("Location", "http://"+r.Host+r.URL.RequestURI()+"&datanode=true")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, webHdfsPrefix)
+	replication, _ := strconv.Atoi(query.Get("replication"))
+	blockSize, _ := strconv.ParseInt(query.Get("blocksize"), 10, 64)
+	perm, _ := strconv.ParseUint(query.Get("permission"), 8, 32)
+	var favored []string
+	if nodes := query.Get("favorednodes"); nodes != "" {
+		favored = strings.Split(nodes, ",")
+	}
+	if query.Get("overwrite") == "true" {
+		f.namenode.Remove(name)
+	}
+	f.namenode.MkdirAll(path.Dir(name), 0755)
+	writer, err := f.namenode.CreateWithFavoredNodes(name, replication, blockSize, os.FileMode(perm), favored)
+	if err == nil {
+		_, err = io.Copy(writer, r.Body)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"RemoteException":{"exception":"IOException","javaClassName":"java.io.IOException","message":%q}}`, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// writeBoolean answers an operation that has to be requested with method,
+// false when it failed with err
+func (f *fakeWebHdfs) writeBoolean(w http.ResponseWriter, r *http.Request, method string, err error) {
+	query := r.URL.Query()
+	if token := query.Get("delegation"); token != "" && query.Get("user.name") == "" {
+		f.delegated = append(f.delegated, token)
+	} else if query.Get("user.name") != "registry" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.Method != method {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"boolean":%t}`, err == nil)
+}
+
+// writeJSON answers an operation that has to be requested with method with
+// body, or with the RemoteException of err
+func (f *fakeWebHdfs) writeJSON(w http.ResponseWriter, r *http.Request, method string, err error, body interface{}) {
+	if r.Method != method || r.URL.Query().Get("user.name") != "registry" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"RemoteException":{"exception":"IOException","javaClassName":"java.io.IOException","message":%q}}`, err.Error())
+		return
+	}
+	if body != nil {
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func (f *fakeWebHdfs) cancelledTokens() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string(nil), f.cancelled...)
+}
+
+func (f *fakeWebHdfs) delegations() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string(nil), f.delegated...)
+}
+
+func newWebHdfsTestDriver(fake *fakeWebHdfs) (*driver, func()) {
+	server := httptest.NewServer(fake)
+	d := &driver{
+		hdfsRootDirectory: "/registry",
+		hdfsUser:          "hdfs",
+		webHdfs:           newWebHdfsClient(server.URL, "hdfs"),
+	}
+	return d, server.Close
+}
+
+func TestURLForEmbedsDelegationToken(t *testing.T) {
+	fake := &fakeWebHdfs{}
+	d, closeServer := newWebHdfsTestDriver(fake)
+	defer closeServer()
+
+	u, err := d.URLFor(context.Background(), "/docker/registry/v2/blobs/sha256/ab/abcd/data", nil)
+	if err != nil {
+		t.Fatalf("unexpected error from URLFor: %v", err)
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("URLFor returned an unparseable URL %q: %v", u, err)
+	}
+	if expected := "/webhdfs/v1/registry/docker/registry/v2/blobs/sha256/ab/abcd/data"; parsed.Path != expected {
+		t.Fatalf("unexpected URL path: expected %q, got %q", expected, parsed.Path)
+	}
+	query := parsed.Query()
+	if query.Get("op") != "OPEN" {
+		t.Fatalf("expected op=OPEN, got %q", query.Get("op"))
+	}
+	if query.Get("delegation") != testDelegationToken {
+		t.Fatalf("expected delegation token %q, got %q", testDelegationToken, query.Get("delegation"))
+	}
+	if query.Get("user.name") != "" {
+		t.Fatalf("redirect URL must not carry a user name, got %q", query.Get("user.name"))
+	}
+	if _, ok := query["contenttype"]; ok {
+		t.Fatalf("expected no content type without the option, got %q", query.Get("contenttype"))
+	}
+}
+
+func TestURLForContentType(t *testing.T) {
+	fake := &fakeWebHdfs{}
+	d, closeServer := newWebHdfsTestDriver(fake)
+	defer closeServer()
+
+	options := map[string]interface{}{"contenttype": "application/vnd.docker.image.rootfs.diff.tar.gzip"}
+	u, err := d.URLFor(context.Background(), "/docker/registry/v2/blobs/sha256/ab/abcd/data", options)
+	if err != nil {
+		t.Fatalf("unexpected error from URLFor: %v", err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("URLFor returned an unparseable URL %q: %v", u, err)
+	}
+	if contentType := parsed.Query().Get("contenttype"); contentType != options["contenttype"] {
+		t.Fatalf("expected the content type in the URL, got %q", contentType)
+	}
+
+	// A hint that is not a string is not understood
+	options["contenttype"] = 42
+	if _, err := d.URLFor(context.Background(), "/a/b", options); err == nil {
+		t.Fatal("expected an invalid content type to be refused")
+	} else if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+		t.Fatalf("expected ErrUnsupportedMethod, got %v", err)
+	}
+}
+
+func TestURLForCancelsTokenAtExpiry(t *testing.T) {
+	fake := &fakeWebHdfs{}
+	d, closeServer := newWebHdfsTestDriver(fake)
+	defer closeServer()
+
+	options := map[string]interface{}{
+		"expiry": time.Now().Add(50 * time.Millisecond),
+	}
+	if _, err := d.URLFor(context.Background(), "/a/b", options); err != nil {
+		t.Fatalf("unexpected error from URLFor: %v", err)
+	}
+
+	if cancelled := fake.cancelledTokens(); len(cancelled) != 0 {
+		t.Fatalf("token cancelled before expiry: %v", cancelled)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.cancelledTokens()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("delegation token was not cancelled after expiry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cancelled := fake.cancelledTokens(); cancelled[0] != testDelegationToken {
+		t.Fatalf("unexpected token cancelled: %q", cancelled[0])
+	}
+}
+
+func TestURLForExpiredOrUnsupported(t *testing.T) {
+	fake := &fakeWebHdfs{}
+	d, closeServer := newWebHdfsTestDriver(fake)
+	defer closeServer()
+
+	for _, options := range []map[string]interface{}{
+		{"expiry": time.Now().Add(-time.Minute)},
+		{"method": "POST"},
+	} {
+		_, err := d.URLFor(context.Background(), "/a/b", options)
+		if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+			t.Fatalf("expected ErrUnsupportedMethod for options %v, got %v", options, err)
+		}
+	}
+	if fake.issued != 0 {
+		t.Fatalf("no token should be requested for unsupported options, %d were issued", fake.issued)
+	}
+
+	_, err := (&driver{}).URLFor(context.Background(), "/a/b", nil)
+	if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+		t.Fatalf("expected ErrUnsupportedMethod without WebHDFS, got %v", err)
+	}
+}
+
+func TestURLForFallsBackOnTokenFailure(t *testing.T) {
+	fake := &fakeWebHdfs{fail: true}
+	d, closeServer := newWebHdfsTestDriver(fake)
+	defer closeServer()
+
+	_, err := d.URLFor(context.Background(), "/a/b", nil)
+	if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+		t.Fatalf("expected ErrUnsupportedMethod when the token cannot be obtained, got %v", err)
+	}
+}
+
+func TestWebHdfsAddress(t *testing.T) {
+	for _, tc := range []struct {
+		params   DriverParameters
+		expected string
+	}{
+		{DriverParameters{HdfsNameNode: "nn1:8020"}, ""},
+		{DriverParameters{HdfsNameNode: "nn1:8020", WebHdfsPort: 50070}, "http://nn1:50070"},
+		{DriverParameters{HdfsNameNode: "nn1:8020,nn2:8020", WebHdfsTLS: true}, "https://nn1:9871"},
+		{DriverParameters{HdfsNameNode: "nn1", WebHdfsPort: 9870}, "http://nn1:9870"},
+		{DriverParameters{HdfsNameNode: "[::1]:8020", WebHdfsPort: 9870}, "http://[::1]:9870"},
+		// An explicit address wins over the derived one
+		{DriverParameters{HdfsNameNode: "nn1:8020", WebHdfsPort: 9870, WebHdfsAddress: "gateway:14000"}, "http://gateway:14000"},
+		{DriverParameters{HdfsNameNode: "nn1:8020", WebHdfsTLS: true, WebHdfsAddress: "gateway:14000"}, "https://gateway:14000"},
+		{DriverParameters{WebHdfsTLS: true, WebHdfsAddress: "https://gateway:14000/"}, "https://gateway:14000/"},
+	} {
+		address, err := webHdfsAddress(tc.params)
+		if err != nil {
+			t.Errorf("unexpected error for %+v: %v", tc.params, err)
+		} else if address != tc.expected {
+			t.Errorf("expected %q for %+v, got %q", tc.expected, tc.params, address)
+		}
+	}
+}
+
+func TestWebHdfsAddressErrors(t *testing.T) {
+	for _, params := range []DriverParameters{
+		{WebHdfsAddress: "http://gateway:14000", WebHdfsTLS: true},
+		{WebHdfsAddress: "https://gateway:14000"},
+		{WebHdfsPort: 9870},
+	} {
+		if _, err := webHdfsAddress(params); err == nil {
+			t.Errorf("expected an error for %+v", params)
+		}
+	}
+}
+
+func TestURLForEscapesPath(t *testing.T) {
+	fake := &fakeWebHdfs{}
+	d, closeServer := newWebHdfsTestDriver(fake)
+	defer closeServer()
+
+	u, err := d.URLFor(context.Background(), "/docker/registry/v2/repositories/my repo+c.d%2/_layers/link", nil)
+	if err != nil {
+		t.Fatalf("unexpected error from URLFor: %v", err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("URLFor returned an unparseable URL %q: %v", u, err)
+	}
+
+	const escaped = "/webhdfs/v1/registry/docker/registry/v2/repositories/my%20repo%2Bc.d%252/_layers/link"
+	if parsed.EscapedPath() != escaped {
+		t.Fatalf("expected the path to be encoded once as %q, got %q", escaped, parsed.EscapedPath())
+	}
+	if parsed.Path != "/webhdfs/v1/registry/docker/registry/v2/repositories/my repo+c.d%2/_layers/link" {
+		t.Fatalf("expected the path to decode back to the HDFS path, got %q", parsed.Path)
+	}
+}
@@ -0,0 +1,134 @@
+package hdfs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// With webhdfsreads, files are read through WebHDFS instead of the
+// datanode protocol, for registries that can reach the HTTP ports of the
+// cluster only. Metadata still comes from the namenode RPC connection.
+// The namenode answers an OPEN with a redirect to a datanode holding the
+// data, which may be down or unreachable from the registry; the read is
+// then asked for again with that datanode excluded, and the namenode
+// redirects to another replica. Files in encryption zones are decrypted by
+// the datanode, so the KMS is not used for reads.
+
+var errWebHdfsReadsAddress = fmt.Errorf("The webhdfsreads parameter requires hdfswebhdfsaddr, webhdfsport or webhdfstls")
+
+// webHdfsReadAttempts bounds the datanodes tried for a read, one per
+// replica of the default replication factor
+const webHdfsReadAttempts = 3
+
+// open reads hdfsPath from offset, trying another datanode whenever the one
+// the namenode redirected to fails
+func (w *webHdfsClient) open(hdfsPath string, offset int64) (io.ReadCloser, error) {
+	// The redirects are followed by hand to learn the datanode
+	client := *w.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	var excluded []string
+	var lastErr error
+	for attempt := 0; attempt < webHdfsReadAttempts; attempt++ {
+		query := url.Values{}
+		query.Set("op", "OPEN")
+		query.Set("offset", strconv.FormatInt(offset, 10))
+		query.Set("user.name", w.user)
+		setBufferSize(query, w.packetSize)
+		if len(excluded) > 0 {
+			query.Set("excludedatanodes", strings.Join(excluded, ","))
+		}
+
+		resp, err := client.Get(w.endpoint(hdfsPath, query))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp.Body, nil
+		}
+		location, err := resp.Location()
+		if resp.StatusCode != http.StatusTemporaryRedirect || err != nil {
+			defer resp.Body.Close()
+			return nil, webHdfsError(resp)
+		}
+		resp.Body.Close()
+
+		resp, err = w.client.Get(location.String())
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return resp.Body, nil
+		} else if err == nil {
+			lastErr = webHdfsError(resp)
+			resp.Body.Close()
+			// The datanode is up but refused the request
+			if resp.StatusCode < http.StatusInternalServerError {
+				return nil, lastErr
+			}
+		} else {
+			lastErr = err
+		}
+		excluded = append(excluded, location.Host)
+	}
+	return nil, fmt.Errorf("webhdfs: reading %s failed on datanodes %s: %v", hdfsPath, strings.Join(excluded, ", "), lastErr)
+}
+
+// webHdfsFile reads a file through WebHDFS, opening a new stream at the
+// position after every seek
+type webHdfsFile struct {
+	webHdfs  *webHdfsClient
+	hdfsPath string
+	info     os.FileInfo
+	position int64
+	body     io.ReadCloser
+}
+
+func (f *webHdfsFile) Read(p []byte) (int, error) {
+	if f.position >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		body, err := f.webHdfs.open(f.hdfsPath, f.position)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.position += int64(n)
+	return n, err
+}
+
+func (f *webHdfsFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.position
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return f.position, fmt.Errorf("webhdfs: cannot seek %s to %d", f.hdfsPath, offset)
+	}
+	if offset != f.position && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.position = offset
+	return offset, nil
+}
+
+func (f *webHdfsFile) Stat() os.FileInfo {
+	return f.info
+}
+
+func (f *webHdfsFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
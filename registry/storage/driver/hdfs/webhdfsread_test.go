@@ -0,0 +1,98 @@
+package hdfs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+// fakeWebHdfsCluster redirects OPEN requests to the first datanode that is
+// not excluded, like a namenode picking a replica
+type fakeWebHdfsCluster struct {
+	sync.Mutex
+	datanodes []string
+	excluded  []string
+	contents  map[string][]byte
+}
+
+func (c *fakeWebHdfsCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+
+	query := r.URL.Query()
+	excluded := map[string]bool{}
+	if exclude := query.Get("excludedatanodes"); exclude != "" {
+		c.excluded = append(c.excluded, exclude)
+		for _, host := range splitList(exclude) {
+			excluded[host] = true
+		}
+	}
+	for _, datanode := range c.datanodes {
+		u, _ := url.Parse(datanode)
+		if !excluded[u.Host] {
+			http.Redirect(w, r, datanode+r.URL.Path+"?offset="+query.Get("offset"), http.StatusTemporaryRedirect)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(`{"RemoteException":{"exception":"IOException","javaClassName":"java.io.IOException","message":"No datanodes left"}}`))
+}
+
+// serveData serves the contents of the files from the requested offset
+func (c *fakeWebHdfsCluster) serveData(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	w.Write(c.contents[r.URL.Path][offset:])
+}
+
+func TestWebHdfsReadsRetryAnotherDatanode(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	cluster := &fakeWebHdfsCluster{contents: map[string][]byte{"/webhdfs/v1/registry/blob": []byte("blob contents")}}
+	live := httptest.NewServer(http.HandlerFunc(cluster.serveData))
+	defer live.Close()
+	cluster.datanodes = []string{dead.URL, live.URL}
+	namenode := httptest.NewServer(cluster)
+	defer namenode.Close()
+
+	client := newFakeClient()
+	client.writeFile("/registry/blob", []byte("blob contents"))
+	d := newTestDriverWithParameters(client, DriverParameters{WebHdfsAddress: namenode.URL, WebHdfsReads: true})
+	ctx := context.Background()
+
+	contents, err := d.GetContent(ctx, "/blob")
+	if err != nil || string(contents) != "blob contents" {
+		t.Fatalf("expected the read to succeed on the live datanode, got %q, %v", contents, err)
+	}
+	reader, err := d.Reader(ctx, "/blob", 5)
+	if err != nil {
+		t.Fatalf("unexpected error from Reader: %v", err)
+	}
+	defer reader.Close()
+	if contents, err := ioutil.ReadAll(reader); err != nil || string(contents) != "contents" {
+		t.Fatalf("expected to read from offset 5, got %q, %v", contents, err)
+	}
+	if calls := client.callCount("Open"); calls != 0 {
+		t.Fatalf("expected no datanode protocol reads, got %d", calls)
+	}
+
+	deadHost, _ := url.Parse(dead.URL)
+	cluster.Lock()
+	excluded := cluster.excluded
+	cluster.datanodes = cluster.datanodes[:1]
+	cluster.Unlock()
+	if len(excluded) != 2 || excluded[0] != deadHost.Host {
+		t.Fatalf("expected every read to exclude the dead datanode %s, got %v", deadHost.Host, excluded)
+	}
+	if _, err := d.GetContent(ctx, "/blob"); err == nil {
+		t.Fatal("expected a read without live datanodes to fail")
+	}
+}
@@ -0,0 +1,140 @@
+package hdfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errWebHdfsFlush is returned by Flush of files written through WebHDFS,
+// which has no way to flush a file before it is closed
+var errWebHdfsFlush = errors.New("webhdfs: files written through WebHDFS cannot be flushed before they are closed")
+
+// createThroughWebHdfs creates the file at fullPath through WebHDFS with
+// the favorednodes of d, replacing the file there with overwrite. The file
+// only exists once the datanode has it, so its storage policy is set when
+// it is closed.
+func (d *driver) createThroughWebHdfs(fullPath string, overwrite bool, replication int, blockSize int64) (hdfsFileWriter, error) {
+	writer, err := d.webHdfs.create(fullPath, overwrite, replication, blockSize, defaultFileMode, d.favoredNodes)
+	if err != nil {
+		return nil, createError(fullPath, err)
+	}
+	writer.closed = func() { d.applyStoragePolicy(fullPath) }
+	return writer, nil
+}
+
+// create creates name through WebHDFS CREATE, replacing the file there with
+// overwrite, and returns a writer streaming to the datanode the namenode
+// redirects to. A replication or block size of 0 is the cluster default.
+// The datanode creates the file once the stream starts, so a create that
+// fails there, such as one without overwrite of a file that exists, fails
+// the writes and Close rather than create itself.
+func (w *webHdfsClient) create(name string, overwrite bool, replication int, blockSize int64, perm os.FileMode, favoredNodes []string) (*webHdfsWriter, error) {
+	query := url.Values{}
+	query.Set("op", "CREATE")
+	query.Set("overwrite", strconv.FormatBool(overwrite))
+	query.Set("permission", strconv.FormatUint(uint64(perm.Perm()), 8))
+	if replication > 0 {
+		query.Set("replication", strconv.Itoa(replication))
+	}
+	if blockSize > 0 {
+		query.Set("blocksize", strconv.FormatInt(blockSize, 10))
+	}
+	if len(favoredNodes) > 0 {
+		query.Set("favorednodes", strings.Join(favoredNodes, ","))
+	}
+	setBufferSize(query, w.packetSize)
+	if w.token != "" {
+		query.Set("delegation", w.token)
+	} else {
+		query.Set("user.name", w.user)
+	}
+
+	// The namenode only picks the datanode, the data goes to the redirect
+	client := *w.client
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	req, err := http.NewRequest("PUT", w.endpoint(name, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	location, err := resp.Location()
+	if resp.StatusCode != http.StatusTemporaryRedirect || err != nil {
+		return nil, webHdfsError(resp)
+	}
+
+	reader, writer := io.Pipe()
+	req, err = http.NewRequest("PUT", location.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	f := &webHdfsWriter{hdfsPath: name, pipe: writer, done: make(chan error, 1)}
+	go func() {
+		err := f.send(w.client, req)
+		// Writes after the datanode answered fail, with its error if any
+		reader.CloseWithError(err)
+		f.done <- err
+	}()
+	return f, nil
+}
+
+// webHdfsWriter writes a file created through WebHDFS, streaming what is
+// written in the body of the request to the datanode
+type webHdfsWriter struct {
+	hdfsPath string
+	pipe     *io.PipeWriter
+	done     chan error
+	err      error
+	isClosed bool
+
+	// closed, when set, runs once the file is closed successfully
+	closed func()
+}
+
+// send streams the body of req to the datanode, returning the error the
+// datanode answered with
+func (f *webHdfsWriter) send(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhdfs: writing %s: %v", f.hdfsPath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return webHdfsError(resp)
+	}
+	return nil
+}
+
+func (f *webHdfsWriter) Write(p []byte) (int, error) {
+	return f.pipe.Write(p)
+}
+
+func (f *webHdfsWriter) Flush() error {
+	return errWebHdfsFlush
+}
+
+// Close ends the stream and waits for the datanode to close the file
+func (f *webHdfsWriter) Close() error {
+	if f.isClosed {
+		return f.err
+	}
+	f.isClosed = true
+	f.pipe.Close()
+	f.err = <-f.done
+	if f.err == nil && f.closed != nil {
+		f.closed()
+	}
+	return f.err
+}
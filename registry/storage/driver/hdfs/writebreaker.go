@@ -0,0 +1,114 @@
+package hdfs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// defaultWriteBreakerCooldown is how long writes stay suspended once the
+// breaker opens, unless writebreakercooldown says otherwise
+const defaultWriteBreakerCooldown = time.Minute
+
+// writeBreaker suspends writes after writebreakerthreshold consecutive
+// write failures, e.g. while the namenode is in safe mode or the cluster
+// is full. Pushes then fail at once with a clear error instead of slowly,
+// one timeout at a time, while pulls keep working. Once the cooldown has
+// passed a single write is let through as a probe: its success closes the
+// breaker again, its failure starts another cooldown.
+type writeBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	lastErr  error
+	probing  bool
+}
+
+// newWriteBreaker returns nil, which lets every write through, when
+// threshold is 0
+func newWriteBreaker(threshold int, cooldown time.Duration) *writeBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &writeBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// errWritesSuspended is returned for writes while the breaker is open
+type errWritesSuspended struct {
+	until time.Time
+	cause error
+}
+
+func (e errWritesSuspended) Error() string {
+	return fmt.Sprintf("hdfs: writes are suspended until %s after repeated failures, last error: %v", e.until.Format(time.RFC3339), e.cause)
+}
+
+// allow returns an error when the write should not be attempted
+func (b *writeBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	until := b.openedAt.Add(b.cooldown)
+	if b.probing || b.now().Before(until) {
+		return errWritesSuspended{until: until, cause: b.lastErr}
+	}
+	b.probing = true
+	return nil
+}
+
+// suspended reports whether the breaker is open
+func (b *writeBreaker) suspended() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold
+}
+
+// record takes note of the outcome of a write. Errors the caller caused,
+// such as writing to a missing path, say nothing about the cluster and are
+// ignored.
+func (b *writeBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	if _, ok := err.(storagedriver.PathNotFoundError); ok || os.IsNotExist(err) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasOpen := b.failures >= b.threshold
+	b.probing = false
+	if err == nil {
+		if wasOpen {
+			log.Printf("hdfs: writes succeed again, resuming writes")
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastErr = err
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+		if !wasOpen {
+			log.Printf("hdfs: suspending writes for %v after %d consecutive failures: %v", b.cooldown, b.failures, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package hdfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+)
+
+func newBreakerTestDriver(client hdfsClient) (*driver, *time.Time) {
+	d := newTestDriverWithParameters(client, DriverParameters{BreakerThreshold: 3, BreakerCooldown: time.Minute})
+	now := time.Now()
+	d.writes.now = func() time.Time { return now }
+	return d, &now
+}
+
+func TestWriteBreakerSuspendsWrites(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/a", []byte("a"))
+	d, now := newBreakerTestDriver(client)
+	client.failWith("Rename", errors.New("Cannot rename /registry/a. Name node is in safe mode."))
+
+	for i := 0; i < 3; i++ {
+		if err := d.Move(context.Background(), "/a", "/b"); err == nil {
+			t.Fatalf("expected move %d to fail", i)
+		}
+	}
+
+	// The breaker is open: writes fail without reaching the namenode
+	if err := d.Move(context.Background(), "/a", "/b"); err == nil {
+		t.Fatal("expected writes to be suspended")
+	} else if _, ok := err.(errWritesSuspended); !ok {
+		t.Fatalf("expected errWritesSuspended, got %v", err)
+	}
+	if _, err := d.Writer(context.Background(), "/c", false); err == nil {
+		t.Fatal("expected Writer to be suspended")
+	}
+	if calls := client.callCount("Rename"); calls != 3 {
+		t.Fatalf("expected 3 renames, got %d", calls)
+	}
+
+	// Reads keep working
+	if _, err := d.GetContent(context.Background(), "/a"); err != nil {
+		t.Fatalf("unexpected error reading while suspended: %v", err)
+	}
+
+	// After the cooldown a probe goes through and closes the breaker
+	*now = now.Add(time.Minute)
+	client.hook("Rename", nil)
+	if err := d.Move(context.Background(), "/a", "/b"); err != nil {
+		t.Fatalf("unexpected error from the probe: %v", err)
+	}
+	if err := d.PutContent(context.Background(), "/c", []byte("c")); err != nil {
+		t.Fatalf("unexpected error after the breaker closed: %v", err)
+	}
+}
+
+func TestWriteBreakerFailedProbeReopens(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/a", []byte("a"))
+	d, now := newBreakerTestDriver(client)
+	client.failWith("Rename", errors.New("no space left on device"))
+
+	for i := 0; i < 3; i++ {
+		d.Move(context.Background(), "/a", "/b")
+	}
+	*now = now.Add(time.Minute)
+	if err := d.Move(context.Background(), "/a", "/b"); err == nil {
+		t.Fatal("expected the probe to fail")
+	} else if _, ok := err.(errWritesSuspended); ok {
+		t.Fatal("expected the probe to reach the namenode")
+	}
+
+	if err := d.Move(context.Background(), "/a", "/b"); err == nil {
+		t.Fatal("expected writes to be suspended again after the failed probe")
+	} else if _, ok := err.(errWritesSuspended); !ok {
+		t.Fatalf("expected errWritesSuspended, got %v", err)
+	}
+	if calls := client.callCount("Rename"); calls != 4 {
+		t.Fatalf("expected 4 renames, got %d", calls)
+	}
+}
+
+func TestWriteBreakerIgnoresMissingPaths(t *testing.T) {
+	d, _ := newBreakerTestDriver(newFakeClient())
+
+	for i := 0; i < 5; i++ {
+		if err := d.Move(context.Background(), "/missing", "/b"); err == nil {
+			t.Fatal("expected moving a missing path to fail")
+		}
+	}
+	if err := d.PutContent(context.Background(), "/c", []byte("c")); err != nil {
+		t.Fatalf("missing paths should not suspend writes: %v", err)
+	}
+}
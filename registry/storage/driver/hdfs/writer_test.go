@@ -0,0 +1,466 @@
+package hdfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution/context"
+)
+
+func TestWriterAppendAfterConcurrentDelete(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(client)
+	ctx := context.Background()
+	client.writeFile("/registry/uploads/data", []byte("partial"))
+
+	// Another writer removes the file between the existence check and
+	// the append
+	client.hook("Append", func(name string) error {
+		return client.Remove(name)
+	})
+
+	writer, err := d.Writer(ctx, "/uploads/data", true)
+	if !isPathNotFound(err) {
+		t.Fatalf("expected PathNotFoundError, got writer %v and error %v", writer, err)
+	}
+	if writer != nil {
+		t.Fatal("no writer should be returned when the append fails")
+	}
+}
+
+func TestStagingReplication(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{Replication: 3, StagingReplication: 1})
+	ctx := context.Background()
+	upload := "/docker/registry/v2/repositories/foo/_uploads/1234/data"
+	blob := "/docker/registry/v2/blobs/sha256/ab/" + testDigestHex + "/data"
+
+	writer, err := d.Writer(ctx, upload, false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("layer"))
+	writer.Commit()
+	writer.Close()
+	if replication := client.replication("/registry" + upload); replication != 1 {
+		t.Fatalf("expected the upload to use staging replication 1, got %d", replication)
+	}
+
+	if err := d.Move(ctx, upload, blob); err != nil {
+		t.Fatalf("unexpected error from Move: %v", err)
+	}
+	if replication := client.replication("/registry" + blob); replication != 3 {
+		t.Fatalf("expected the committed blob to use replication 3, got %d", replication)
+	}
+
+	if err := d.PutContent(ctx, "/docker/registry/v2/repositories/foo/_layers/link", []byte("link")); err != nil {
+		t.Fatalf("unexpected error from PutContent: %v", err)
+	}
+	if replication := client.replication("/registry/docker/registry/v2/repositories/foo/_layers/link"); replication != 3 {
+		t.Fatalf("expected other files to use replication 3, got %d", replication)
+	}
+}
+
+func TestStagingReplicationRequiresReplication(t *testing.T) {
+	if _, err := newDriver(newFakeClient(), DriverParameters{StagingReplication: 1}); err == nil {
+		t.Fatalf("expected stagingreplication without replication to be rejected")
+	}
+}
+
+// shortStatClient reports every file one byte shorter than it is, like a
+// namenode that lost the end of a write
+type shortStatClient struct {
+	*fakeClient
+}
+
+func (c shortStatClient) Stat(name string) (os.FileInfo, error) {
+	fi, err := c.fakeClient.Stat(name)
+	if err != nil || fi.IsDir() {
+		return fi, err
+	}
+	short := fi.(fakeFileInfo)
+	short.size--
+	return short, nil
+}
+
+func TestVerifyWrites(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(client, DriverParameters{VerifyWrites: true})
+	ctx := context.Background()
+
+	writer, err := d.Writer(ctx, "/blob", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("contents"))
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("unexpected error from Commit: %v", err)
+	}
+	writer.Close()
+
+	d = newTestDriverWithParameters(shortStatClient{client}, DriverParameters{VerifyWrites: true})
+	writer, err = d.Writer(ctx, "/truncated", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("contents"))
+	if err := writer.Commit(); err == nil || !strings.Contains(err.Error(), "verifywrites") {
+		t.Fatalf("expected a verification error, got %v", err)
+	}
+	writer.Close()
+}
+
+func TestPutContentReturnsCommitError(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriverWithParameters(shortStatClient{client}, DriverParameters{VerifyWrites: true})
+	ctx := context.Background()
+
+	// Commit fails verification while the Close that follows succeeds
+	err := d.PutContent(ctx, "/truncated", []byte("contents"))
+	if err == nil || !strings.Contains(err.Error(), "verifywrites") {
+		t.Fatalf("expected PutContent to return the Commit error, got %v", err)
+	}
+
+	writer, err := d.Writer(ctx, "/truncated", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	writer.Write([]byte("contents"))
+	err = writer.Commit()
+	if err == nil {
+		t.Fatal("expected Commit to fail")
+	}
+	if closeErr := writer.Close(); closeErr != nil {
+		t.Fatalf("unexpected error from Close: %v", closeErr)
+	}
+	if again := writer.Commit(); again != err {
+		t.Fatalf("expected Commit after Close to keep failing with %v, got %v", err, again)
+	}
+}
+
+var errAppendDisabled = errors.New("org.apache.hadoop.ipc.RemoteException: Append is not supported. Please see the dfs.support.append configuration parameter")
+
+func TestAppendUnsupportedWithoutFallback(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/uploads/data", []byte("partial"))
+	client.failWith("Append", errAppendDisabled)
+	d := newTestDriver(client)
+
+	if _, err := d.Writer(context.Background(), "/uploads/data", true); err == nil || !strings.Contains(err.Error(), "appendfallback") {
+		t.Fatalf("expected an error pointing at appendfallback, got %v", err)
+	}
+}
+
+func TestAppendFallbackRewrites(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/uploads/data", []byte("partial"))
+	client.failWith("Append", errAppendDisabled)
+	d := newTestDriverWithParameters(client, DriverParameters{AppendFallback: true})
+	ctx := context.Background()
+
+	writer, err := d.Writer(ctx, "/uploads/data", true)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	if writer.Size() != int64(len("partial")) {
+		t.Fatalf("expected the writer to start after the existing bytes, got size %d", writer.Size())
+	}
+	writer.Write([]byte(" upload"))
+	writer.Commit()
+	writer.Close()
+
+	contents, err := d.GetContent(ctx, "/uploads/data")
+	if err != nil || string(contents) != "partial upload" {
+		t.Fatalf("expected the rewritten file to hold both parts, got %q, %v", contents, err)
+	}
+	if infos, _ := client.ReadDir("/registry/uploads"); len(infos) != 1 {
+		t.Fatalf("expected the rewrite file to be renamed into place, found %d files", len(infos))
+	}
+}
+
+func TestWriterOnDirectory(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/repo/_layers/link", []byte("link"))
+	d := newTestDriver(client)
+
+	for _, append := range []bool{true, false} {
+		_, err := d.Writer(context.Background(), "/repo/_layers", append)
+		if err == nil || !strings.Contains(err.Error(), "is a directory") {
+			t.Fatalf("expected a directory error with append=%v, got %v", append, err)
+		}
+	}
+	if _, err := client.Stat("/registry/repo/_layers/link"); err != nil {
+		t.Fatalf("expected the directory to be left alone: %v", err)
+	}
+	if client.callCount("Append") != 0 {
+		t.Fatalf("expected no append to be attempted")
+	}
+}
+
+// racingMkdirClient reports that the directory already exists from every
+// MkdirAll but the first, like the losers of concurrent creations do
+type racingMkdirClient struct {
+	hdfsClient
+	mu      sync.Mutex
+	created bool
+}
+
+func (c *racingMkdirClient) MkdirAll(dirname string, perm os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.hdfsClient.MkdirAll(dirname, perm); err != nil {
+		return err
+	}
+	if c.created {
+		return &os.PathError{Op: "mkdir", Path: dirname, Err: os.ErrExist}
+	}
+	c.created = true
+	return nil
+}
+
+func TestConcurrentMakeParentDir(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(&racingMkdirClient{hdfsClient: client})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- d.makeParentDir(d.fullPath(fmt.Sprintf("/docker/registry/v2/repositories/new/_layers/file%d", i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error creating a parent directory concurrently: %v", err)
+		}
+	}
+
+	// A file in the way is still an error
+	client.writeFile("/registry/blocked", []byte("file"))
+	if err := d.makeParentDir(d.fullPath("/blocked/child")); err == nil {
+		t.Fatal("expected an error when the parent is a file")
+	}
+}
+
+// shortWriter accepts only half of every write, failing with err
+type shortWriter struct {
+	hdfsFileWriter
+	err error
+}
+
+func (w shortWriter) Write(p []byte) (int, error) {
+	n, _ := w.hdfsFileWriter.Write(p[:len(p)/2])
+	return n, w.err
+}
+
+func TestWriteReportsShortWrites(t *testing.T) {
+	client := newFakeClient()
+	d := newTestDriver(client)
+	client.writeFile("/registry/short", nil)
+	full, err := client.Append("/registry/short")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pipeErr := errors.New("broken pipe")
+	writer := d.newFileWriter(shortWriter{full, pipeErr}, "/short", "/registry/short", 0)
+	if n, err := writer.Write([]byte("abcd")); n != 2 || err != pipeErr {
+		t.Fatalf("expected a short write of 2 bytes failing with %v, got %d, %v", pipeErr, n, err)
+	}
+	if size := writer.Size(); size != 2 {
+		t.Fatalf("expected the size to count the bytes written, got %d", size)
+	}
+
+	// A short write without an error still is one
+	writer = d.newFileWriter(shortWriter{full, nil}, "/short", "/registry/short", 2)
+	if n, err := writer.Write([]byte("efgh")); n != 2 || err != io.ErrShortWrite {
+		t.Fatalf("expected io.ErrShortWrite after 2 bytes, got %d, %v", n, err)
+	}
+}
+
+func TestWriterClosesProbeReaderOnOverwrite(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/file", []byte("old contents"))
+	d := newTestDriver(client)
+
+	writer, err := d.Writer(context.Background(), "/file", false)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	if open := client.openReaders; open != 0 {
+		t.Fatalf("expected the probe reader to be closed before the overwrite, %d readers left open", open)
+	}
+	writer.Write([]byte("new"))
+	writer.Commit()
+	writer.Close()
+
+	if contents, err := d.GetContent(context.Background(), "/file"); err != nil || string(contents) != "new" {
+		t.Fatalf("expected the file to be overwritten, got %q, %v", contents, err)
+	}
+}
+
+func TestPutContentEmpty(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		params DriverParameters
+	}{
+		{"default", DriverParameters{}},
+		{"verifywrites", DriverParameters{VerifyWrites: true}},
+		{"tempfile staging", DriverParameters{StagingStrategy: "tempfile"}},
+		{"compression", DriverParameters{Compression: "gzip"}},
+	} {
+		client := newFakeClient()
+		d := newTestDriverWithParameters(client, tc.params)
+		ctx := context.Background()
+
+		// The registry writes empty marker files
+		if err := d.PutContent(ctx, "/repo/_layers/marker", []byte{}); err != nil {
+			t.Fatalf("%s: unexpected error from PutContent: %v", tc.name, err)
+		}
+		contents, err := d.GetContent(ctx, "/repo/_layers/marker")
+		if err != nil || contents == nil || len(contents) != 0 {
+			t.Fatalf("%s: expected empty contents, got %#v, %v", tc.name, contents, err)
+		}
+		fi, err := d.Stat(ctx, "/repo/_layers/marker")
+		if err != nil || fi.Size() != 0 || fi.IsDir() {
+			t.Fatalf("%s: expected a zero-length file, got %+v, %v", tc.name, fi, err)
+		}
+		if calls := client.callCount("Create") + client.callCount("CreateFile"); calls != 1 {
+			t.Fatalf("%s: expected the file to be created once, got %d creates", tc.name, calls)
+		}
+
+		// Overwriting contents with nothing empties the file
+		d.PutContent(ctx, "/repo/_layers/marker", []byte("contents"))
+		if err := d.PutContent(ctx, "/repo/_layers/marker", nil); err != nil {
+			t.Fatalf("%s: unexpected error overwriting with nothing: %v", tc.name, err)
+		}
+		if contents, err := d.GetContent(ctx, "/repo/_layers/marker"); err != nil || len(contents) != 0 {
+			t.Fatalf("%s: expected the overwritten file to be empty, got %q, %v", tc.name, contents, err)
+		}
+	}
+}
+
+// seekingAppendClient returns appending writers that can seek, starting at
+// the beginning of the file like some versions of colinmarc/hdfs
+type seekingAppendClient struct {
+	*fakeClient
+}
+
+func (c *seekingAppendClient) Append(name string) (hdfsFileWriter, error) {
+	writer, err := c.fakeClient.Append(name)
+	if err != nil {
+		return nil, err
+	}
+	return &seekingFakeWriter{fakeWriter: writer.(*fakeWriter)}, nil
+}
+
+// seekingFakeWriter writes at its position rather than at the end
+type seekingFakeWriter struct {
+	*fakeWriter
+	position int64
+}
+
+func (w *seekingFakeWriter) Write(p []byte) (int, error) {
+	w.client.mu.Lock()
+	defer w.client.mu.Unlock()
+	data := w.file.data
+	if end := w.position + int64(len(p)); end > int64(len(data)) {
+		data = append(data, make([]byte, end-int64(len(data)))...)
+	}
+	copy(data[w.position:], p)
+	w.file.data = data
+	w.position += int64(len(p))
+	return len(p), nil
+}
+
+func (w *seekingFakeWriter) Seek(offset int64, whence int) (int64, error) {
+	w.client.mu.Lock()
+	defer w.client.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		w.position += offset
+	case io.SeekEnd:
+		w.position = int64(len(w.file.data)) + offset
+	default:
+		w.position = offset
+	}
+	return w.position, nil
+}
+
+func TestWriterAppendsAfterExistingContent(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		client func(*fakeClient) hdfsClient
+	}{
+		{"positioned at the end", func(c *fakeClient) hdfsClient { return c }},
+		{"positioned at the start", func(c *fakeClient) hdfsClient { return &seekingAppendClient{c} }},
+	} {
+		fake := newFakeClient()
+		fake.writeFile("/registry/uploads/data", []byte("existing"))
+		d := newTestDriver(tc.client(fake))
+
+		writer, err := d.Writer(context.Background(), "/uploads/data", true)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if size := writer.Size(); size != int64(len("existing")) {
+			t.Fatalf("%s: expected the writer to start at %d, got %d", tc.name, len("existing"), size)
+		}
+		if _, err := writer.Write([]byte(" appended")); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if err := writer.Commit(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		writer.Close()
+
+		if contents, err := d.GetContent(context.Background(), "/uploads/data"); err != nil || string(contents) != "existing appended" {
+			t.Fatalf("%s: expected the appended bytes after the existing ones, got %q, %v", tc.name, contents, err)
+		}
+	}
+}
+
+func TestWriterAppendSizeFromCurrentStat(t *testing.T) {
+	client := newFakeClient()
+	client.writeFile("/registry/uploads/data", []byte("probed"))
+	d := newTestDriver(client)
+
+	// Another writer extends the file after the probe opened it
+	client.hook("Stat", func(name string) error {
+		client.hook("Stat", nil)
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		f := client.files[name]
+		f.data = append(f.data, " and grown"...)
+		return nil
+	})
+	writer, err := d.Writer(context.Background(), "/uploads/data", true)
+	if err != nil {
+		t.Fatalf("unexpected error from Writer: %v", err)
+	}
+	if size := writer.Size(); size != int64(len("probed and grown")) {
+		t.Fatalf("expected the starting size of the current file, got %d", size)
+	}
+	writer.Cancel()
+
+	// A failing Stat fails the Writer rather than appending blindly
+	statErr := errors.New("namenode overloaded")
+	client.failWith("Stat", statErr)
+	if _, err := d.Writer(context.Background(), "/uploads/data", true); err == nil {
+		t.Fatal("expected the Stat failure to be returned")
+	} else if err != statErr {
+		t.Fatalf("expected %v, got %v", statErr, err)
+	}
+	if calls := client.callCount("Append"); calls != 1 {
+		t.Fatalf("expected no append after the failed Stat, got %d appends", calls)
+	}
+}
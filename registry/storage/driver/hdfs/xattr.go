@@ -0,0 +1,55 @@
+package hdfs
+
+// xattrClient is implemented by clients that can set and read the extended
+// attributes of files, such as the user.registry.compression attribute of
+// compressed objects. colinmarc/hdfs cannot, so the driver goes through
+// WebHDFS instead, see driver.setXAttr.
+type xattrClient interface {
+	// SetXAttr creates the attribute attr of name, which must not have it
+	SetXAttr(name, attr string, value []byte) error
+
+	// XAttrs returns the attributes of name by their names
+	XAttrs(name string) (map[string][]byte, error)
+}
+
+// setXAttr sets the attribute attr of name if c supports it
+func setXAttr(c hdfsClient, name, attr string, value []byte) error {
+	if x, ok := c.(xattrClient); ok {
+		return x.SetXAttr(name, attr, value)
+	}
+	return errUnsupportedByClient
+}
+
+// getXAttrs returns the attributes of name if c supports it
+func getXAttrs(c hdfsClient, name string) (map[string][]byte, error) {
+	if x, ok := c.(xattrClient); ok {
+		return x.XAttrs(name)
+	}
+	return nil, errUnsupportedByClient
+}
+
+// setXAttr sets the attribute attr of the file at fullPath, through WebHDFS
+// when the client cannot
+func (d *driver) setXAttr(fullPath, attr string, value []byte) error {
+	err := setXAttr(d.hdfsClient, fullPath, attr, value)
+	if err == errUnsupportedByClient {
+		if d.webHdfs == nil {
+			return errWebHdfsRequired
+		}
+		err = d.webHdfs.SetXAttr(fullPath, attr, value)
+	}
+	return err
+}
+
+// xattrs returns the attributes of the file at fullPath, through WebHDFS
+// when the client cannot read them
+func (d *driver) xattrs(fullPath string) (map[string][]byte, error) {
+	attrs, err := getXAttrs(d.hdfsClient, fullPath)
+	if err == errUnsupportedByClient {
+		if d.webHdfs == nil {
+			return nil, errWebHdfsRequired
+		}
+		attrs, err = d.webHdfs.XAttrs(fullPath)
+	}
+	return attrs, err
+}